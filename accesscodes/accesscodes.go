@@ -0,0 +1,374 @@
+package accesscodes
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"mcq-exam/tracking"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+)
+
+// Code states, derived from the email_tracking columns
+const (
+	StatusNone        = "none"
+	StatusActive      = "active"
+	StatusUsed        = "used"
+	StatusExpired     = "expired"
+	StatusInvalidated = "invalidated"
+)
+
+// Audit trail actions
+const (
+	ActionIssued      = "issued"
+	ActionUsed        = "used"
+	ActionReissued    = "reissued"
+	ActionRegenerated = "regenerated"
+	ActionInvalidated = "invalidated"
+)
+
+// EmailType is the tracking row that holds the exam access code
+const EmailType = string(tracking.FirstMail)
+
+// ErrNotFound is returned for students who have not attended the conference and so have no code
+var ErrNotFound = errors.New("no access code for this student")
+
+// ErrUnavailable is returned by Claim when the code is used, expired or invalidated
+var ErrUnavailable = errors.New("access code is no longer valid")
+
+// Code is a student's current access code and its lifecycle
+type Code struct {
+	StudentID     int        `json:"student_id"`
+	AccessCode    *string    `json:"access_code"`
+	Status        string     `json:"status"`
+	IssuedAt      *time.Time `json:"issued_at"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	UsedAt        *time.Time `json:"used_at"`
+	InvalidatedAt *time.Time `json:"invalidated_at"`
+}
+
+// Event is one entry of a student's access code audit trail
+type Event struct {
+	ID        int        `json:"id"`
+	Action    string     `json:"action"`
+	Reason    *string    `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	IP        *string    `json:"ip"`
+	UserAgent *string    `json:"user_agent"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Audit describes who caused a change, for the audit trail
+type Audit struct {
+	Reason    string
+	IP        string
+	UserAgent string
+}
+
+// querier is satisfied by both the pool and a transaction
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// TTL is how long a new code stays valid, from ACCESS_CODE_TTL (default 72h; 0 means codes never expire)
+func TTL() time.Duration {
+	ttl := 72 * time.Hour
+	if value := os.Getenv("ACCESS_CODE_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			log.Warn().Msgf("Invalid ACCESS_CODE_TTL=%q, using %s", value, ttl)
+		} else {
+			ttl = parsed
+		}
+	}
+	return ttl
+}
+
+// expiry is when a code issued now expires, or nil when codes do not expire
+func expiry() *time.Time {
+	ttl := TTL()
+	if ttl == 0 {
+		return nil
+	}
+	expiresAt := time.Now().Add(ttl)
+	return &expiresAt
+}
+
+// Generate returns a random 6-character alphanumeric code
+func Generate() (string, error) {
+	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	code := make([]byte, 6)
+	randomBytes := make([]byte, 6)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("failed to generate access code: %w", err)
+	}
+	for i := range code {
+		code[i] = charset[int(randomBytes[i])%len(charset)]
+	}
+	return string(code), nil
+}
+
+// uniqueCodeIndex keeps access codes unique, since verify-otp looks codes up alone
+const uniqueCodeIndex = "idx_email_tracking_access_code"
+
+// uniqueViolation is the SQLSTATE of a duplicate key
+const uniqueViolation = "23505"
+
+// maxCodeAttempts is how many codes are drawn before giving up when each is already held
+const maxCodeAttempts = 5
+
+// writeNewCode draws codes until write stores one no other student holds. The unique index
+// decides, so two codes issued at once cannot come out the same.
+func writeNewCode(write func(code string) error) (string, error) {
+	for i := 0; i < maxCodeAttempts; i++ {
+		code, err := Generate()
+		if err != nil {
+			return "", err
+		}
+		err = write(code)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == uniqueCodeIndex {
+			continue
+		}
+		return code, err
+	}
+	return "", errors.New("failed to generate an unused access code")
+}
+
+// StatusAt derives a code's state at the given time
+func StatusAt(code *string, expiresAt, usedAt, invalidatedAt *time.Time, now time.Time) string {
+	switch {
+	case code == nil || *code == "":
+		return StatusNone
+	case invalidatedAt != nil:
+		return StatusInvalidated
+	case usedAt != nil:
+		return StatusUsed
+	case expiresAt != nil && !now.Before(*expiresAt):
+		return StatusExpired
+	}
+	return StatusActive
+}
+
+// Issue marks the conference as attended for the student's tracking row of emailType and
+// gives it a new code. Reports false if the conference was already attended.
+func Issue(ctx context.Context, studentID int, emailType string, audit Audit) (bool, error) {
+	expiresAt := expiry()
+	query := `
+		UPDATE email_tracking
+		SET conference_attended = true,
+		    conference_attended_at = NOW(),
+		    access_code = $1,
+		    access_code_issued_at = NOW(),
+		    access_code_expires_at = $2,
+		    access_code_used_at = NULL,
+		    access_code_invalidated_at = NULL,
+		    updated_at = NOW()
+		WHERE student_id = $3 AND email_type = $4 AND conference_attended = false
+	`
+	var tag pgconn.CommandTag
+	_, err := writeNewCode(func(code string) (err error) {
+		tag, err = db.Pool.Exec(ctx, query, code, expiresAt, studentID, emailType)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to issue access code: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if emailType == EmailType {
+		if err := recordEvent(ctx, db.Pool, studentID, ActionIssued, expiresAt, audit); err != nil {
+			log.Warn().Err(err).Int("student_id", studentID).Msg("Failed to record access code event")
+		}
+	}
+	return true, nil
+}
+
+// Get returns the student's current code
+func Get(ctx context.Context, studentID int) (*Code, error) {
+	return get(ctx, db.Pool, studentID)
+}
+
+func get(ctx context.Context, q querier, studentID int) (*Code, error) {
+	code := Code{StudentID: studentID}
+	query := `
+		SELECT access_code, access_code_issued_at, access_code_expires_at, access_code_used_at, access_code_invalidated_at
+		FROM email_tracking
+		WHERE student_id = $1 AND email_type = $2 AND conference_attended = true
+	`
+	err := q.QueryRow(ctx, query, studentID, EmailType).Scan(&code.AccessCode, &code.IssuedAt, &code.ExpiresAt, &code.UsedAt, &code.InvalidatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch access code: %w", err)
+	}
+	code.Status = StatusAt(code.AccessCode, code.ExpiresAt, code.UsedAt, code.InvalidatedAt, time.Now())
+	return &code, nil
+}
+
+// Regenerate replaces the student's code with a new one, valid for TTL and unused. The old
+// code stops working immediately. action is ActionRegenerated for admin requests and
+// ActionReissued when a student with attempts left fetches a new code.
+func Regenerate(ctx context.Context, studentID int, action string, audit Audit) (*Code, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	expiresAt := expiry()
+	query := `
+		UPDATE email_tracking
+		SET access_code = $1,
+		    access_code_issued_at = NOW(),
+		    access_code_expires_at = $2,
+		    access_code_used_at = NULL,
+		    access_code_invalidated_at = NULL,
+		    updated_at = NOW()
+		WHERE student_id = $3 AND email_type = $4 AND conference_attended = true
+		RETURNING student_id
+	`
+	// Each code is tried under a savepoint, so a code already held leaves the transaction usable
+	_, err = writeNewCode(func(code string) error {
+		attempt, err := tx.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer attempt.Rollback(ctx)
+		var id int
+		if err := attempt.QueryRow(ctx, query, code, expiresAt, studentID, EmailType).Scan(&id); err != nil {
+			return err
+		}
+		return attempt.Commit(ctx)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to regenerate access code: %w", err)
+	}
+
+	if err := recordEvent(ctx, tx, studentID, action, expiresAt, audit); err != nil {
+		return nil, err
+	}
+
+	updated, err := get(ctx, tx, studentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Invalidate stops the student's code from working until it is regenerated
+func Invalidate(ctx context.Context, studentID int, audit Audit) (*Code, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE email_tracking
+		SET access_code_invalidated_at = COALESCE(access_code_invalidated_at, NOW()), updated_at = NOW()
+		WHERE student_id = $1 AND email_type = $2 AND conference_attended = true AND access_code IS NOT NULL
+		RETURNING student_id
+	`
+	var id int
+	err = tx.QueryRow(ctx, query, studentID, EmailType).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to invalidate access code: %w", err)
+	}
+
+	if err := recordEvent(ctx, tx, studentID, ActionInvalidated, nil, audit); err != nil {
+		return nil, err
+	}
+
+	updated, err := get(ctx, tx, studentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Claim consumes an active code inside the caller's transaction, so the code and the session
+// it starts are committed together. Returns ErrUnavailable if the code was used, expired or
+// invalidated in the meantime.
+func Claim(ctx context.Context, tx pgx.Tx, studentID int, code string, audit Audit) error {
+	query := `
+		UPDATE email_tracking
+		SET access_code_used_at = NOW(), updated_at = NOW()
+		WHERE student_id = $1 AND email_type = $2 AND access_code = $3
+		  AND access_code_used_at IS NULL
+		  AND access_code_invalidated_at IS NULL
+		  AND (access_code_expires_at IS NULL OR access_code_expires_at > NOW())
+		RETURNING student_id
+	`
+	var id int
+	err := tx.QueryRow(ctx, query, studentID, EmailType, code).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUnavailable
+	}
+	if err != nil {
+		return fmt.Errorf("failed to claim access code: %w", err)
+	}
+
+	return recordEvent(ctx, tx, studentID, ActionUsed, nil, audit)
+}
+
+// Events returns the student's audit trail, newest first
+func Events(ctx context.Context, studentID int, limit int) ([]Event, error) {
+	query := `
+		SELECT id, action, reason, expires_at, ip, user_agent, created_at
+		FROM access_code_events
+		WHERE student_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+	rows, err := db.Pool.Query(ctx, query, studentID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.Action, &e.Reason, &e.ExpiresAt, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// recordEvent appends to the audit trail
+func recordEvent(ctx context.Context, q querier, studentID int, action string, expiresAt *time.Time, audit Audit) error {
+	query := `
+		INSERT INTO access_code_events (student_id, action, reason, expires_at, ip, user_agent)
+		VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, ''), NULLIF($6, ''))
+		RETURNING id
+	`
+	var id int
+	if err := q.QueryRow(ctx, query, studentID, action, audit.Reason, expiresAt, audit.IP, audit.UserAgent).Scan(&id); err != nil {
+		return fmt.Errorf("failed to record access code event: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package accesscodes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// Outcomes of a verify-otp attempt
+const (
+	OutcomeSuccess = "success"
+	// OutcomeInvalid is a code that matched no student (or not the named one): the only
+	// outcome counted toward a lockout
+	OutcomeInvalid = "invalid"
+	OutcomeRevoked = "revoked"
+	OutcomeUsed    = "used"
+	OutcomeExpired = "expired"
+	// OutcomeRejected is a working code refused for the student's state: an attempt already
+	// open or none left, or outside the exam window
+	OutcomeRejected = "rejected"
+	// OutcomeLocked is an attempt refused because the student's code or the IP is locked out
+	OutcomeLocked = "locked"
+)
+
+// Audit trail actions of lockouts
+const (
+	ActionLocked   = "locked"
+	ActionUnlocked = "unlocked"
+)
+
+// ErrNotLocked is returned by Unlock when nothing is locked out
+var ErrNotLocked = errors.New("not locked out")
+
+// LockoutConfig is when failed verify-otp attempts lock out a student's code or an IP
+type LockoutConfig struct {
+	// MaxFailures locks a student's code after this many failures within Duration
+	MaxFailures int
+	// IPMaxFailures locks an IP after this many failures within Duration. It is far higher
+	// than MaxFailures since a whole exam hall or campus often shares one NAT address, and a
+	// few mistyped codes each must not lock everyone there out.
+	IPMaxFailures int
+	// Duration is both the window failures are counted in and how long a lockout lasts
+	Duration time.Duration
+}
+
+// LockoutFromEnv reads OTP_MAX_FAILURES (default 5), OTP_IP_MAX_FAILURES (default 200) and
+// OTP_LOCKOUT_DURATION (default 15m)
+func LockoutFromEnv() LockoutConfig {
+	cfg := LockoutConfig{MaxFailures: 5, IPMaxFailures: 200, Duration: 15 * time.Minute}
+
+	for _, setting := range []struct {
+		name  string
+		value *int
+	}{{"OTP_MAX_FAILURES", &cfg.MaxFailures}, {"OTP_IP_MAX_FAILURES", &cfg.IPMaxFailures}} {
+		if value := strings.TrimSpace(os.Getenv(setting.name)); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				log.Warn().Msgf("Invalid %s=%q, using %d", setting.name, value, *setting.value)
+			} else {
+				*setting.value = parsed
+			}
+		}
+	}
+
+	if value := strings.TrimSpace(os.Getenv("OTP_LOCKOUT_DURATION")); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Warn().Msgf("Invalid OTP_LOCKOUT_DURATION=%q, using %s", value, cfg.Duration)
+		} else {
+			cfg.Duration = parsed
+		}
+	}
+	return cfg
+}
+
+// Lockout is a student's code or an IP locked out of verify-otp
+type Lockout struct {
+	ID           int        `json:"id"`
+	StudentID    *int       `json:"student_id,omitempty"`
+	IP           *string    `json:"ip,omitempty"`
+	Failures     int        `json:"failures"`
+	LockedAt     time.Time  `json:"locked_at"`
+	LockedUntil  time.Time  `json:"locked_until"`
+	UnlockedAt   *time.Time `json:"unlocked_at,omitempty"`
+	UnlockReason *string    `json:"unlock_reason,omitempty"`
+}
+
+// Attempt is one recorded verify-otp attempt
+type Attempt struct {
+	ID        int64     `json:"id"`
+	StudentID *int      `json:"student_id"`
+	Outcome   string    `json:"outcome"`
+	IP        *string   `json:"ip"`
+	UserAgent *string   `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const lockoutColumns = `id, student_id, ip, failures, locked_at, locked_until, unlocked_at, unlock_reason`
+
+// activeLockout matches lockouts that have neither run out nor been lifted
+const activeLockout = `unlocked_at IS NULL AND locked_until > NOW()`
+
+func scanLockout(row pgx.Row, l *Lockout) error {
+	return row.Scan(&l.ID, &l.StudentID, &l.IP, &l.Failures, &l.LockedAt, &l.LockedUntil, &l.UnlockedAt, &l.UnlockReason)
+}
+
+// ActiveLockout returns the lockout of the student's code (when studentID is not 0) or of
+// the IP that lasts longest, or nil when neither is locked out
+func ActiveLockout(ctx context.Context, studentID int, ip string) (*Lockout, error) {
+	query := `
+		SELECT ` + lockoutColumns + `
+		FROM otp_lockouts
+		WHERE ((student_id = $1 AND $1 <> 0) OR (ip = $2 AND $2 <> ''))
+		  AND ` + activeLockout + `
+		ORDER BY locked_until DESC
+		LIMIT 1
+	`
+	var l Lockout
+	err := scanLockout(db.Pool.QueryRow(ctx, query, studentID, ip), &l)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check OTP lockout: %w", err)
+	}
+	return &l, nil
+}
+
+// RecordAttempt stores a verify-otp attempt; studentID is 0 when it names no student. An
+// invalid code that brings the IP's or the student's failures within cfg.Duration to their
+// limit locks it out, and the new lockout is returned.
+func RecordAttempt(ctx context.Context, cfg LockoutConfig, studentID int, outcome string, audit Audit) (*Lockout, error) {
+	var student *int
+	if studentID != 0 {
+		student = &studentID
+	}
+	query := `
+		INSERT INTO otp_attempts (student_id, outcome, ip, user_agent)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''))
+	`
+	if _, err := db.Pool.Exec(ctx, query, student, outcome, audit.IP, audit.UserAgent); err != nil {
+		return nil, fmt.Errorf("failed to record OTP attempt: %w", err)
+	}
+	if outcome != OutcomeInvalid {
+		return nil, nil
+	}
+
+	var lockout *Lockout
+	if audit.IP != "" {
+		locked, err := lockIfOver(ctx, cfg, "ip", audit.IP, cfg.IPMaxFailures)
+		if err != nil {
+			return nil, err
+		}
+		lockout = locked
+	}
+	if studentID != 0 {
+		locked, err := lockIfOver(ctx, cfg, "student_id", studentID, cfg.MaxFailures)
+		if err != nil {
+			return nil, err
+		}
+		if locked != nil {
+			audit.Reason = fmt.Sprintf("%d failed attempts", locked.Failures)
+			if err := recordEvent(ctx, db.Pool, studentID, ActionLocked, &locked.LockedUntil, audit); err != nil {
+				log.Warn().Err(err).Int("student_id", studentID).Msg("Failed to record access code event")
+			}
+			lockout = locked
+		}
+	}
+	return lockout, nil
+}
+
+// lockIfOver locks out the key (column "ip" or "student_id") when its invalid attempts since
+// its last lockout, and within cfg.Duration, reach limit. Nothing is locked while a lockout
+// of the key is active, so concurrent failures lock it once.
+func lockIfOver(ctx context.Context, cfg LockoutConfig, column string, key any, limit int) (*Lockout, error) {
+	query := `
+		INSERT INTO otp_lockouts (` + column + `, failures, locked_until)
+		SELECT ` + column + `, f.failures, NOW() + make_interval(secs => $3)
+		FROM (
+			SELECT $1 AS ` + column + `, COUNT(*) AS failures
+			FROM otp_attempts
+			WHERE ` + column + ` = $1 AND outcome = '` + OutcomeInvalid + `'
+			  AND created_at > GREATEST(NOW() - make_interval(secs => $3),
+			                            COALESCE((SELECT MAX(locked_at) FROM otp_lockouts WHERE ` + column + ` = $1), '-infinity'))
+		) f
+		WHERE f.failures >= $2
+		  AND NOT EXISTS (SELECT 1 FROM otp_lockouts WHERE ` + column + ` = $1 AND ` + activeLockout + `)
+		RETURNING ` + lockoutColumns
+	var l Lockout
+	err := scanLockout(db.Pool.QueryRow(ctx, query, key, limit, cfg.Duration.Seconds()), &l)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock out %s: %w", column, err)
+	}
+	log.Warn().Interface(column, key).Int("failures", l.Failures).Time("locked_until", l.LockedUntil).Msg("Locked out of OTP verification")
+	return &l, nil
+}
+
+// Unlock lifts the active lockouts of the student's code (when studentID is not 0) or of the
+// IP, returning them. Returns ErrNotLocked when there are none.
+func Unlock(ctx context.Context, studentID int, ip string, audit Audit) ([]Lockout, error) {
+	query := `
+		UPDATE otp_lockouts
+		SET unlocked_at = NOW(), unlock_reason = NULLIF($3, '')
+		WHERE ((student_id = $1 AND $1 <> 0) OR (ip = $2 AND $2 <> ''))
+		  AND ` + activeLockout + `
+		RETURNING ` + lockoutColumns
+	rows, err := db.Pool.Query(ctx, query, studentID, ip, audit.Reason)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock: %w", err)
+	}
+	lockouts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Lockout, error) {
+		var l Lockout
+		err := scanLockout(row, &l)
+		return l, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock: %w", err)
+	}
+	if len(lockouts) == 0 {
+		return nil, ErrNotLocked
+	}
+
+	if studentID != 0 {
+		if err := recordEvent(ctx, db.Pool, studentID, ActionUnlocked, nil, audit); err != nil {
+			log.Warn().Err(err).Int("student_id", studentID).Msg("Failed to record access code event")
+		}
+	}
+	return lockouts, nil
+}
+
+// IPFailures is an IP's invalid attempts in a report
+type IPFailures struct {
+	IP       string    `json:"ip"`
+	Failures int       `json:"failures"`
+	Students int       `json:"students"`
+	LastAt   time.Time `json:"last_at"`
+}
+
+// AttemptsReport summarizes verify-otp attempts since a time
+type AttemptsReport struct {
+	Since    time.Time      `json:"since"`
+	Outcomes map[string]int `json:"outcomes"`
+	// TopIPs are the IPs with the most invalid attempts
+	TopIPs []IPFailures `json:"top_ips"`
+	// Lockouts are the lockouts in effect now
+	Lockouts []Lockout `json:"lockouts"`
+	// Attempts are the most recent attempts, of one student or IP when filtered
+	Attempts []Attempt `json:"attempts"`
+}
+
+// Report summarizes attempts since a time, listing up to limit of the most recent ones,
+// optionally only those of a student (not 0) or an IP (not empty)
+func Report(ctx context.Context, since time.Time, studentID int, ip string, limit int) (*AttemptsReport, error) {
+	report := &AttemptsReport{Since: since, Outcomes: map[string]int{}}
+
+	rows, err := db.Pool.Query(ctx, `
+		SELECT outcome, COUNT(*) FROM otp_attempts WHERE created_at >= $1 GROUP BY outcome
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count OTP attempts: %w", err)
+	}
+	for rows.Next() {
+		var outcome string
+		var count int
+		if err := rows.Scan(&outcome, &count); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to count OTP attempts: %w", err)
+		}
+		report.Outcomes[outcome] = count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to count OTP attempts: %w", err)
+	}
+
+	rows, err = db.Pool.Query(ctx, `
+		SELECT ip, COUNT(*), COUNT(DISTINCT student_id), MAX(created_at)
+		FROM otp_attempts
+		WHERE created_at >= $1 AND outcome = '`+OutcomeInvalid+`' AND ip IS NOT NULL
+		GROUP BY ip
+		ORDER BY COUNT(*) DESC, ip
+		LIMIT 20
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rank OTP failures: %w", err)
+	}
+	report.TopIPs, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (IPFailures, error) {
+		var f IPFailures
+		err := row.Scan(&f.IP, &f.Failures, &f.Students, &f.LastAt)
+		return f, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to rank OTP failures: %w", err)
+	}
+
+	rows, err = db.Pool.Query(ctx, `SELECT `+lockoutColumns+` FROM otp_lockouts WHERE `+activeLockout+` ORDER BY locked_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list OTP lockouts: %w", err)
+	}
+	report.Lockouts, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (Lockout, error) {
+		var l Lockout
+		err := scanLockout(row, &l)
+		return l, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list OTP lockouts: %w", err)
+	}
+
+	rows, err = db.Pool.Query(ctx, `
+		SELECT id, student_id, outcome, ip, user_agent, created_at
+		FROM otp_attempts
+		WHERE created_at >= $1 AND ($2 = 0 OR student_id = $2) AND ($3 = '' OR ip = $3)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4
+	`, since, studentID, ip, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list OTP attempts: %w", err)
+	}
+	report.Attempts, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (Attempt, error) {
+		var a Attempt
+		err := row.Scan(&a.ID, &a.StudentID, &a.Outcome, &a.IP, &a.UserAgent, &a.CreatedAt)
+		return a, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list OTP attempts: %w", err)
+	}
+	return report, nil
+}
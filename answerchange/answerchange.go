@@ -0,0 +1,100 @@
+// Package answerchange holds the exam setting that lets students change a submitted answer
+// while its section is open. Scoring always uses the answer recorded last.
+package answerchange
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// settingsKey is the exam_settings row holding the setting
+const settingsKey = "allow_answer_change"
+
+// Where a Setting comes from
+const (
+	// SourceEnv is ALLOW_ANSWER_CHANGE, used until an admin saves the setting
+	SourceEnv = "env"
+	// SourceExam is the value saved through PUT /api/admin/answer-change
+	SourceExam = "exam_settings"
+)
+
+// Setting is whether a new answer to an answered question replaces the old one (last
+// write wins) instead of being rejected with 409
+type Setting struct {
+	AllowAnswerChange bool       `json:"allow_answer_change"`
+	Source            string     `json:"source"`
+	UpdatedAt         *time.Time `json:"updated_at"`
+}
+
+// envDefault is ALLOW_ANSWER_CHANGE (default false)
+func envDefault() Setting {
+	allow, _ := strconv.ParseBool(os.Getenv("ALLOW_ANSWER_CHANGE"))
+	return Setting{AllowAnswerChange: allow, Source: SourceEnv}
+}
+
+// Load reads the saved setting, falling back to ALLOW_ANSWER_CHANGE when none has been saved.
+// It reads the database on every call, so all instances agree as soon as it changes.
+func Load(ctx context.Context) (Setting, error) {
+	var value []byte
+	var updatedAt time.Time
+	err := db.Pool.QueryRow(ctx, `SELECT value, updated_at FROM exam_settings WHERE key = $1`, settingsKey).Scan(&value, &updatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return envDefault(), nil
+	}
+	if err != nil {
+		return envDefault(), fmt.Errorf("failed to load answer change setting: %w", err)
+	}
+
+	var allow bool
+	if err := json.Unmarshal(value, &allow); err != nil {
+		return envDefault(), fmt.Errorf("stored answer change setting is invalid: %s", value)
+	}
+	return Setting{AllowAnswerChange: allow, Source: SourceExam, UpdatedAt: &updatedAt}, nil
+}
+
+// Allowed is Load for answer submission: a failed lookup is logged and ALLOW_ANSWER_CHANGE
+// is used so answering keeps working
+func Allowed(ctx context.Context) bool {
+	setting, err := Load(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Using ALLOW_ANSWER_CHANGE for answer changes")
+	}
+	return setting.AllowAnswerChange
+}
+
+// Save stores the setting for the exam, overriding ALLOW_ANSWER_CHANGE
+func Save(ctx context.Context, allow bool) (Setting, error) {
+	value, err := json.Marshal(allow)
+	if err != nil {
+		return Setting{}, err
+	}
+
+	query := `
+		INSERT INTO exam_settings (key, value, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()
+		RETURNING updated_at
+	`
+	var updatedAt time.Time
+	if err := db.Pool.QueryRow(ctx, query, settingsKey, value).Scan(&updatedAt); err != nil {
+		return Setting{}, fmt.Errorf("failed to save answer change setting: %w", err)
+	}
+	return Setting{AllowAnswerChange: allow, Source: SourceExam, UpdatedAt: &updatedAt}, nil
+}
+
+// Reset deletes the saved setting so ALLOW_ANSWER_CHANGE applies again
+func Reset(ctx context.Context) (Setting, error) {
+	if _, err := db.Pool.Exec(ctx, `DELETE FROM exam_settings WHERE key = $1`, settingsKey); err != nil {
+		return Setting{}, fmt.Errorf("failed to reset answer change setting: %w", err)
+	}
+	return envDefault(), nil
+}
@@ -0,0 +1,117 @@
+package apierror
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Error codes. Clients should branch on the code; messages are for people and may change.
+const (
+	CodeBadRequest         = "BAD_REQUEST"
+	CodeUnauthorized       = "UNAUTHORIZED"
+	CodeForbidden          = "FORBIDDEN"
+	CodeNotFound           = "NOT_FOUND"
+	CodeMethodNotAllowed   = "METHOD_NOT_ALLOWED"
+	CodeConflict           = "CONFLICT"
+	CodeGone               = "GONE"
+	CodePayloadTooLarge    = "PAYLOAD_TOO_LARGE"
+	CodeLocked             = "LOCKED"
+	CodeRateLimited        = "RATE_LIMITED"
+	CodeInternal           = "INTERNAL_ERROR"
+	CodeServiceUnavailable = "SERVICE_UNAVAILABLE"
+	CodeShuttingDown       = "SHUTTING_DOWN"
+
+	// Exam flow
+	CodeTokenInvalid          = "TOKEN_INVALID"
+	CodeConferenceNotAttended = "CONFERENCE_NOT_ATTENDED"
+	CodeOTPInvalid            = "OTP_INVALID"
+	CodeOTPRevoked            = "OTP_REVOKED"
+	CodeOTPUsed               = "OTP_USED"
+	CodeOTPExpired            = "OTP_EXPIRED"
+	CodeOTPLocked             = "OTP_LOCKED"
+	CodeSessionExists         = "SESSION_EXISTS"
+	CodeSessionInvalid        = "SESSION_INVALID"
+	CodeAttemptsExhausted     = "ATTEMPTS_EXHAUSTED"
+	CodeTestNotStarted        = "TEST_NOT_STARTED"
+	CodeTestExpired           = "TEST_EXPIRED"
+	CodeEntryClosed           = "ENTRY_CLOSED"
+	CodeTestCompleted         = "TEST_COMPLETED"
+	CodeExamPaused            = "EXAM_PAUSED"
+	CodeRegistrationClosed    = "REGISTRATION_CLOSED"
+	CodeAPIKeyInvalid         = "API_KEY_INVALID"
+)
+
+// Response is the body of every error response. Success is always false; it is kept so
+// clients that branch on it keep working.
+type Response struct {
+	Success bool        `json:"success"`
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// CodeForStatus is the generic code for an HTTP status
+func CodeForStatus(status int) string {
+	switch status {
+	case fiber.StatusBadRequest:
+		return CodeBadRequest
+	case fiber.StatusUnauthorized:
+		return CodeUnauthorized
+	case fiber.StatusForbidden:
+		return CodeForbidden
+	case fiber.StatusNotFound:
+		return CodeNotFound
+	case fiber.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case fiber.StatusConflict:
+		return CodeConflict
+	case fiber.StatusGone:
+		return CodeGone
+	case fiber.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case fiber.StatusLocked:
+		return CodeLocked
+	case fiber.StatusTooManyRequests:
+		return CodeRateLimited
+	case fiber.StatusServiceUnavailable:
+		return CodeServiceUnavailable
+	}
+	if status < fiber.StatusInternalServerError {
+		return CodeBadRequest
+	}
+	return CodeInternal
+}
+
+// Send writes an error response with the generic code for status
+func Send(c *fiber.Ctx, status int, message string) error {
+	return Respond(c, status, CodeForStatus(status), message, nil)
+}
+
+// SendCode writes an error response with a specific code
+func SendCode(c *fiber.Ctx, status int, code, message string) error {
+	return Respond(c, status, code, message, nil)
+}
+
+// Respond writes an error response; details carries extra data such as the exam window or
+// the fields that failed validation, and is left out when nil
+func Respond(c *fiber.Ctx, status int, code, message string, details interface{}) error {
+	return c.Status(status).JSON(Response{
+		Code:    code,
+		Message: message,
+		Details: details,
+	})
+}
+
+// Handler is the app's fiber.ErrorHandler, so unmatched routes, oversized bodies and errors
+// returned by handlers get the same envelope
+func Handler(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	message := "Internal server error"
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+		message = fiberErr.Message
+	}
+	return Send(c, status, message)
+}
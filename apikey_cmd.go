@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mcq-exam/apikeys"
+	"os"
+	"strconv"
+	"time"
+)
+
+const apiKeyUsage = `Usage: %s apikey <command>
+
+Commands:
+  create NAME SCOPE  mint a key; SCOPE is stats, mail or admin. The key is printed once.
+  list               show every key and whether it is active
+  revoke ID          stop a key from working
+`
+
+// runAPIKeyCommand handles "<binary> apikey ..." and returns the process exit code.
+// It is how the first admin key is minted once API_KEYS_REQUIRED locks the API.
+func runAPIKeyCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, apiKeyUsage, os.Args[0])
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	switch command := args[0]; {
+	case command == "create" && len(args) == 3:
+		if !apikeys.ValidScope(args[2]) {
+			fmt.Fprintln(os.Stderr, apikeys.ErrInvalidScope)
+			return 2
+		}
+		key, raw, err := apikeys.Create(ctx, args[1], args[2], 0, nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		fmt.Printf("Created key %d (%s, scope %s). Store it now; it cannot be shown again:\n%s\n", key.ID, key.Name, key.Scope, raw)
+	case command == "list" && len(args) == 1:
+		keys, err := apikeys.List(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		if len(keys) == 0 {
+			fmt.Println("No API keys")
+		}
+		for _, key := range keys {
+			status := "active"
+			switch {
+			case key.RevokedAt != nil:
+				status = "revoked"
+			case key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now()):
+				status = "expired"
+			}
+			fmt.Printf("%4d  %-16s  %-6s  %-8s  %s\n", key.ID, key.Prefix, key.Scope, status, key.Name)
+		}
+	case command == "revoke" && len(args) == 2:
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ID must be a number")
+			return 2
+		}
+		if _, err := apikeys.Revoke(ctx, id); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return 1
+		}
+		fmt.Printf("Revoked key %d\n", id)
+	default:
+		fmt.Fprintf(os.Stderr, apiKeyUsage, os.Args[0])
+		return 2
+	}
+	return 0
+}
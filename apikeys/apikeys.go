@@ -0,0 +1,231 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Scopes, from least to most access. Each scope includes the ones before it.
+const (
+	// ScopeStats reads results, leaderboards, analytics and tracking reports
+	ScopeStats = "stats"
+	// ScopeMail also sends mail, campaigns and notifications
+	ScopeMail = "mail"
+	// ScopeAdmin can call every endpoint, including key management
+	ScopeAdmin = "admin"
+)
+
+var scopeLevels = map[string]int{ScopeStats: 1, ScopeMail: 2, ScopeAdmin: 3}
+
+// keyPrefix starts every key, so leaked keys are easy to search for
+const keyPrefix = "nicm_"
+
+// DefaultRateLimit is the per-minute request limit of a key created without one
+const DefaultRateLimit = 600
+
+var (
+	ErrNotFound     = errors.New("api key not found")
+	ErrInvalidKey   = errors.New("invalid api key")
+	ErrRevoked      = errors.New("api key has been revoked")
+	ErrExpired      = errors.New("api key has expired")
+	ErrInvalidScope = errors.New("scope must be stats, mail or admin")
+)
+
+// Key is an API key's record. The key itself is only returned once, by Create.
+type Key struct {
+	ID                 int        `json:"id"`
+	Name               string     `json:"name"`
+	Prefix             string     `json:"prefix"`
+	Scope              string     `json:"scope"`
+	RateLimitPerMinute int        `json:"rate_limit_per_minute"`
+	ExpiresAt          *time.Time `json:"expires_at"`
+	RevokedAt          *time.Time `json:"revoked_at"`
+	LastUsedAt         *time.Time `json:"last_used_at"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+// Usage is one day's requests by a key to one route
+type Usage struct {
+	Day          string `json:"day"`
+	Method       string `json:"method"`
+	Route        string `json:"route"`
+	RequestCount int    `json:"request_count"`
+	ErrorCount   int    `json:"error_count"`
+}
+
+const keyColumns = `id, name, prefix, scope, rate_limit_per_minute, expires_at, revoked_at, last_used_at, created_at`
+
+func scanKey(row pgx.Row, key *Key) error {
+	return row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Scope, &key.RateLimitPerMinute,
+		&key.ExpiresAt, &key.RevokedAt, &key.LastUsedAt, &key.CreatedAt)
+}
+
+// ValidScope reports whether scope is one of the known scopes
+func ValidScope(scope string) bool {
+	_, ok := scopeLevels[scope]
+	return ok
+}
+
+// Allows reports whether a key with this scope may call endpoints requiring required
+func (k *Key) Allows(required string) bool {
+	return scopeLevels[k.Scope] >= scopeLevels[required]
+}
+
+// hash returns the stored form of a key
+func hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create mints a key and returns its record and the key itself, which is not stored
+func Create(ctx context.Context, name, scope string, rateLimit int, expiresAt *time.Time) (*Key, string, error) {
+	if !ValidScope(scope) {
+		return nil, "", ErrInvalidScope
+	}
+	if rateLimit <= 0 {
+		rateLimit = DefaultRateLimit
+	}
+
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	raw := keyPrefix + hex.EncodeToString(bytes)
+
+	var key Key
+	query := `
+		INSERT INTO api_keys (name, prefix, key_hash, scope, rate_limit_per_minute, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + keyColumns
+	if err := scanKey(db.Pool.QueryRow(ctx, query, name, raw[:len(keyPrefix)+8], hash(raw), scope, rateLimit, expiresAt), &key); err != nil {
+		return nil, "", fmt.Errorf("failed to create api key: %w", err)
+	}
+	return &key, raw, nil
+}
+
+// List returns every key, newest first
+func List(ctx context.Context) ([]Key, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT `+keyColumns+` FROM api_keys ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]Key, 0)
+	for rows.Next() {
+		var key Key
+		if err := scanKey(rows, &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Get returns one key
+func Get(ctx context.Context, id int) (*Key, error) {
+	var key Key
+	err := scanKey(db.Pool.QueryRow(ctx, `SELECT `+keyColumns+` FROM api_keys WHERE id = $1`, id), &key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Revoke stops a key from working. Revoking a revoked key keeps the original time.
+func Revoke(ctx context.Context, id int) (*Key, error) {
+	var key Key
+	query := `
+		UPDATE api_keys SET revoked_at = COALESCE(revoked_at, NOW())
+		WHERE id = $1
+		RETURNING ` + keyColumns
+	err := scanKey(db.Pool.QueryRow(ctx, query, id), &key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Authenticate looks up a presented key and checks it is still usable
+func Authenticate(ctx context.Context, raw string) (*Key, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.HasPrefix(raw, keyPrefix) {
+		return nil, ErrInvalidKey
+	}
+
+	var key Key
+	err := scanKey(db.Pool.QueryRow(ctx, `SELECT `+keyColumns+` FROM api_keys WHERE key_hash = $1`, hash(raw)), &key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrRevoked
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now()) {
+		return nil, ErrExpired
+	}
+	return &key, nil
+}
+
+// RecordUsage counts one request by a key to route (the matched pattern, e.g. /api/students/:id)
+func RecordUsage(ctx context.Context, keyID int, method, route string, failed bool) error {
+	errorCount := 0
+	if failed {
+		errorCount = 1
+	}
+	query := `
+		WITH touched AS (
+			UPDATE api_keys SET last_used_at = NOW() WHERE id = $1
+		)
+		INSERT INTO api_key_usage (api_key_id, day, method, route, request_count, error_count)
+		VALUES ($1, CURRENT_DATE, $2, $3, 1, $4)
+		ON CONFLICT (api_key_id, day, method, route)
+		DO UPDATE SET request_count = api_key_usage.request_count + 1,
+		              error_count = api_key_usage.error_count + EXCLUDED.error_count
+	`
+	_, err := db.Pool.Exec(ctx, query, keyID, method, route, errorCount)
+	return err
+}
+
+// UsageSince returns a key's requests per day and route from the last days days, newest first
+func UsageSince(ctx context.Context, keyID, days int) ([]Usage, error) {
+	query := `
+		SELECT to_char(day, 'YYYY-MM-DD'), method, route, request_count, error_count
+		FROM api_key_usage
+		WHERE api_key_id = $1 AND day > CURRENT_DATE - $2::int
+		ORDER BY day DESC, request_count DESC
+	`
+	rows, err := db.Pool.Query(ctx, query, keyID, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := make([]Usage, 0)
+	for rows.Next() {
+		var u Usage
+		if err := rows.Scan(&u.Day, &u.Method, &u.Route, &u.RequestCount, &u.ErrorCount); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
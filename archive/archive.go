@@ -0,0 +1,547 @@
+// Package archive snapshots an event's results before the database is reset. An archive
+// holds each ranked student's counted attempt with their rank under the ranking policy, the
+// section results ranked as on the section leaderboards, and the event's statistics. The
+// archive tables survive POST /api/admin/reset-db, which refuses to run until the latest
+// event is archived (see Unarchived).
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mcq-exam/attempts"
+	"mcq-exam/db"
+	"mcq-exam/questions"
+	"mcq-exam/ranking"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrNotFound      = errors.New("archive not found")
+	ErrEventNotFound = errors.New("event not found")
+	// ErrNotLatest is returned for an earlier event: the results in the database are the
+	// latest event's
+	ErrNotLatest = errors.New("only the latest event can be archived")
+)
+
+// Section is a section of the question bank as it was when the event was archived
+type Section struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	TimeLimit int    `json:"time_limit"`
+	Questions int    `json:"questions"`
+}
+
+// Funnel counts distinct students at each stage, as the dashboard funnel does
+type Funnel struct {
+	TotalStudents      int `json:"total_students"`
+	Invited            int `json:"invited"`
+	Opened             int `json:"opened"`
+	AttendedConference int `json:"attended_conference"`
+	StartedTest        int `json:"started_test"`
+	CompletedTest      int `json:"completed_test"`
+}
+
+// ScoreStats summarizes the ranked attempts
+type ScoreStats struct {
+	Ranked             int     `json:"ranked"`
+	Average            float64 `json:"average"`
+	Median             float64 `json:"median"`
+	Max                int     `json:"max"`
+	Min                int     `json:"min"`
+	AverageTimeSeconds float64 `json:"average_time_seconds"`
+	AutoCompleted      int     `json:"auto_completed"`
+}
+
+// SectionStats summarizes one section's results
+type SectionStats struct {
+	SectionID          int     `json:"section_id"`
+	Name               string  `json:"name"`
+	Participants       int     `json:"participants"`
+	AverageScore       float64 `json:"average_score"`
+	MaxScore           int     `json:"max_score"`
+	AverageTimeSeconds float64 `json:"average_time_seconds"`
+}
+
+// Stats are the event's statistics at the time it was archived
+type Stats struct {
+	Funnel   Funnel         `json:"funnel"`
+	Scores   ScoreStats     `json:"scores"`
+	Sections []SectionStats `json:"sections"`
+}
+
+// Archive is an archived event
+type Archive struct {
+	ID              int       `json:"id"`
+	EventScheduleID int       `json:"event_schedule_id"`
+	Title           string    `json:"title"`
+	Notes           *string   `json:"notes"`
+	ExamStartsAt    time.Time `json:"exam_starts_at"`
+	Timezone        string    `json:"timezone"`
+	Participants    int       `json:"participants"`
+	ArchivedAt      time.Time `json:"archived_at"`
+	// Ranking, Sections and Stats are left out of List
+	Ranking  []string  `json:"ranking,omitempty"`
+	Sections []Section `json:"sections,omitempty"`
+	Stats    *Stats    `json:"stats,omitempty"`
+}
+
+// SectionResult is a student's archived result in one section
+type SectionResult struct {
+	SectionID         int `json:"section_id"`
+	Rank              int `json:"rank"`
+	Score             int `json:"score"`
+	TimeTakenSeconds  int `json:"time_taken_seconds"`
+	QuestionsAnswered int `json:"questions_answered"`
+}
+
+// Result is a student's archived counted attempt
+type Result struct {
+	Rank                  int             `json:"rank"`
+	StudentID             int             `json:"student_id"`
+	Name                  string          `json:"name,omitempty"`
+	Email                 string          `json:"email"`
+	Institution           *string         `json:"institution"`
+	Country               *string         `json:"country"`
+	Designation           *string         `json:"designation"`
+	Score                 int             `json:"score"`
+	TotalTimeTakenSeconds int             `json:"total_time_taken_seconds"`
+	QuestionsAnswered     int             `json:"questions_answered"`
+	CorrectAnswers        int             `json:"correct_answers"`
+	AttemptNumber         int             `json:"attempt_number"`
+	StartedAt             *time.Time      `json:"started_at"`
+	CompletedAt           *time.Time      `json:"completed_at"`
+	AutoCompletedReason   *string         `json:"auto_completed_reason"`
+	Sections              []SectionResult `json:"sections"`
+}
+
+// SectionEntry is a line of an archived section leaderboard
+type SectionEntry struct {
+	Rank              int    `json:"rank"`
+	StudentID         int    `json:"student_id"`
+	Name              string `json:"name,omitempty"`
+	Email             string `json:"email"`
+	Score             int    `json:"score"`
+	TimeTakenSeconds  int    `json:"time_taken_seconds"`
+	QuestionsAnswered int    `json:"questions_answered"`
+}
+
+// Create archives the latest event, identified by scheduleID, replacing an earlier archive of
+// it so sessions completed since are included. Reports whether an archive was replaced.
+func Create(ctx context.Context, scheduleID int, title, notes, ip string) (*Archive, bool, error) {
+	sections, err := questions.Load()
+	if err != nil {
+		return nil, false, err
+	}
+	archivedSections := make([]Section, 0, len(sections))
+	for _, s := range sections {
+		archivedSections = append(archivedSections, Section{ID: s.ID, Name: s.Name, TimeLimit: s.TimeLimit, Questions: len(s.Questions)})
+	}
+	policy := ranking.Current(ctx)
+
+	var archive *Archive
+	var replaced bool
+	err = db.WithTx(ctx, func(tx pgx.Tx) error {
+		var latestID int
+		var createdAt, startsAt time.Time
+		var timezone string
+		// Locked so two archives of the event cannot run at once
+		eventQuery := `
+			SELECT created_at, second_scheduled_time, timezone, (SELECT MAX(id) FROM event_schedule)
+			FROM event_schedule
+			WHERE id = $1
+			FOR UPDATE
+		`
+		err := tx.QueryRow(ctx, eventQuery, scheduleID).Scan(&createdAt, &startsAt, &timezone, &latestID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrEventNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if scheduleID != latestID {
+			return ErrNotLatest
+		}
+		if title == "" {
+			title = fmt.Sprintf("Event %d, %s", scheduleID, startsAt.Format("2 Jan 2006"))
+		}
+
+		tag, err := tx.Exec(ctx, `DELETE FROM exam_archives WHERE event_schedule_id = $1 AND event_created_at = $2`, scheduleID, createdAt)
+		if err != nil {
+			return err
+		}
+		replaced = tag.RowsAffected() > 0
+
+		stats, err := collectStats(ctx, tx, sections)
+		if err != nil {
+			return err
+		}
+		rankingJSON, err := json.Marshal(policy.Criteria)
+		if err != nil {
+			return err
+		}
+		sectionsJSON, err := json.Marshal(archivedSections)
+		if err != nil {
+			return err
+		}
+		statsJSON, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+
+		archive = &Archive{EventScheduleID: scheduleID, Title: title, ExamStartsAt: startsAt, Timezone: timezone,
+			Ranking: policy.Criteria, Sections: archivedSections, Stats: stats}
+		if notes != "" {
+			archive.Notes = &notes
+		}
+		insertQuery := `
+			INSERT INTO exam_archives (event_schedule_id, event_created_at, title, notes, exam_starts_at, timezone,
+			                           ranking, sections, stats, participants, ip)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''))
+			RETURNING id, archived_at
+		`
+		err = tx.QueryRow(ctx, insertQuery, scheduleID, createdAt, title, archive.Notes, startsAt, timezone,
+			rankingJSON, sectionsJSON, statsJSON, stats.Scores.Ranked, ip).Scan(&archive.ID, &archive.ArchivedAt)
+		if err != nil {
+			return err
+		}
+
+		resultsQuery := `
+			INSERT INTO exam_archive_results (archive_id, student_id, rank, name, email, institution, country, designation,
+			                                  score, total_time_taken_seconds, questions_answered, correct_answers,
+			                                  attempt_number, started_at, completed_at, auto_completed_reason)
+			SELECT $1, s.id, ` + policy.DenseRank("sess") + `, s.name, s.email, s.institution, s.country, s.designation,
+			       COALESCE(sess.score, 0), COALESCE(sess.total_time_taken_seconds, 0), ans.answered, ans.correct,
+			       sess.attempt_number, sess.started_at, sess.completed_at, sess.auto_completed_reason
+			FROM ` + attempts.CountedSessions() + ` sess
+			JOIN students s ON sess.student_id = s.id
+			CROSS JOIN LATERAL (
+				SELECT COUNT(*) AS answered, COUNT(*) FILTER (WHERE a.is_correct) AS correct
+				FROM answers a
+				WHERE a.session_id = sess.id
+			) ans
+		`
+		tag, err = tx.Exec(ctx, resultsQuery, archive.ID)
+		if err != nil {
+			return fmt.Errorf("failed to archive results: %w", err)
+		}
+		archive.Participants = int(tag.RowsAffected())
+
+		sectionQuery := `
+			INSERT INTO exam_archive_section_results (archive_id, student_id, section_id, rank, score, time_taken_seconds, questions_answered)
+			SELECT $1, sess.student_id, sss.section_id, ` + ranking.SectionDenseRank("sss") + `,
+			       sss.score, sss.time_taken_seconds, sss.questions_answered
+			FROM session_section_scores sss
+			JOIN ` + attempts.CountedSessions() + ` sess ON sess.id = sss.session_id
+			WHERE sss.questions_answered > 0
+		`
+		if _, err := tx.Exec(ctx, sectionQuery, archive.ID); err != nil {
+			return fmt.Errorf("failed to archive section results: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return archive, replaced, nil
+}
+
+// collectStats computes the statistics of the results about to be archived
+func collectStats(ctx context.Context, tx pgx.Tx, sections []questions.Section) (*Stats, error) {
+	stats := &Stats{Sections: make([]SectionStats, 0, len(sections))}
+
+	funnelQuery := `
+		SELECT
+			(SELECT COUNT(*) FROM students WHERE is_sandbox = false),
+			(SELECT COUNT(DISTINCT et.student_id) FROM email_tracking et JOIN students s ON s.id = et.student_id AND s.is_sandbox = false),
+			(SELECT COUNT(DISTINCT et.student_id) FROM email_tracking et JOIN students s ON s.id = et.student_id AND s.is_sandbox = false WHERE et.opened = true),
+			(SELECT COUNT(DISTINCT et.student_id) FROM email_tracking et JOIN students s ON s.id = et.student_id AND s.is_sandbox = false WHERE et.conference_attended = true),
+			(SELECT COUNT(DISTINCT student_id) FROM sessions WHERE is_sandbox = false),
+			(SELECT COUNT(DISTINCT student_id) FROM sessions WHERE completed = true AND is_sandbox = false)
+	`
+	f := &stats.Funnel
+	if err := tx.QueryRow(ctx, funnelQuery).Scan(&f.TotalStudents, &f.Invited, &f.Opened,
+		&f.AttendedConference, &f.StartedTest, &f.CompletedTest); err != nil {
+		return nil, fmt.Errorf("failed to count funnel: %w", err)
+	}
+
+	scoreQuery := `
+		SELECT COUNT(*), COALESCE(ROUND(AVG(COALESCE(sess.score, 0)), 2), 0)::float8,
+		       COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY COALESCE(sess.score, 0)), 0)::float8,
+		       COALESCE(MAX(sess.score), 0), COALESCE(MIN(sess.score), 0),
+		       COALESCE(ROUND(AVG(COALESCE(sess.total_time_taken_seconds, 0)), 2), 0)::float8,
+		       COUNT(*) FILTER (WHERE sess.auto_completed)
+		FROM ` + attempts.CountedSessions() + ` sess
+	`
+	sc := &stats.Scores
+	if err := tx.QueryRow(ctx, scoreQuery).Scan(&sc.Ranked, &sc.Average, &sc.Median, &sc.Max, &sc.Min,
+		&sc.AverageTimeSeconds, &sc.AutoCompleted); err != nil {
+		return nil, fmt.Errorf("failed to summarize scores: %w", err)
+	}
+
+	sectionQuery := `
+		SELECT sss.section_id, COUNT(*), ROUND(AVG(sss.score), 2)::float8, MAX(sss.score),
+		       ROUND(AVG(sss.time_taken_seconds), 2)::float8
+		FROM session_section_scores sss
+		JOIN ` + attempts.CountedSessions() + ` sess ON sess.id = sss.session_id
+		WHERE sss.questions_answered > 0
+		GROUP BY sss.section_id
+	`
+	rows, err := tx.Query(ctx, sectionQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize sections: %w", err)
+	}
+	bySection := make(map[int]SectionStats)
+	for rows.Next() {
+		var s SectionStats
+		if err := rows.Scan(&s.SectionID, &s.Participants, &s.AverageScore, &s.MaxScore, &s.AverageTimeSeconds); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		bySection[s.SectionID] = s
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Every section of the bank is listed, in bank order, even one nobody answered
+	for _, section := range sections {
+		s := bySection[section.ID]
+		s.SectionID, s.Name = section.ID, section.Name
+		stats.Sections = append(stats.Sections, s)
+	}
+	return stats, nil
+}
+
+const archiveColumns = `id, event_schedule_id, title, notes, exam_starts_at, timezone, participants, archived_at`
+
+func scanArchive(row pgx.Row, a *Archive, extra ...any) error {
+	return row.Scan(append([]any{&a.ID, &a.EventScheduleID, &a.Title, &a.Notes, &a.ExamStartsAt, &a.Timezone,
+		&a.Participants, &a.ArchivedAt}, extra...)...)
+}
+
+// List returns every archive, the latest event first
+func List(ctx context.Context) ([]Archive, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT `+archiveColumns+` FROM exam_archives ORDER BY exam_starts_at DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	archives := make([]Archive, 0)
+	for rows.Next() {
+		var a Archive
+		if err := scanArchive(rows, &a); err != nil {
+			return nil, err
+		}
+		archives = append(archives, a)
+	}
+	return archives, rows.Err()
+}
+
+// Get returns an archive with its ranking criteria, sections and statistics
+func Get(ctx context.Context, id int) (*Archive, error) {
+	var a Archive
+	var rankingJSON, sectionsJSON, statsJSON []byte
+	query := `SELECT ` + archiveColumns + `, ranking, sections, stats FROM exam_archives WHERE id = $1`
+	err := scanArchive(db.Pool.QueryRow(ctx, query, id), &a, &rankingJSON, &sectionsJSON, &statsJSON)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.Stats = &Stats{}
+	if err := json.Unmarshal(rankingJSON, &a.Ranking); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(sectionsJSON, &a.Sections); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(statsJSON, a.Stats); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// exists reports whether archive id exists
+func exists(ctx context.Context, id int) (bool, error) {
+	var found bool
+	err := db.Pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM exam_archives WHERE id = $1)`, id).Scan(&found)
+	return found, err
+}
+
+// Results returns a page of an archive's results in rank order, with each student's section
+// results, and the number of ranked students
+func Results(ctx context.Context, id, limit, offset int) ([]Result, int, error) {
+	if found, err := exists(ctx, id); err != nil || !found {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT rank, student_id, name, email, institution, country, designation, score, total_time_taken_seconds,
+		       questions_answered, correct_answers, attempt_number, started_at, completed_at, auto_completed_reason,
+		       COUNT(*) OVER ()
+		FROM exam_archive_results
+		WHERE archive_id = $1
+		ORDER BY rank, student_id
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := db.Pool.Query(ctx, query, id, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	results := make([]Result, 0)
+	byStudent := make(map[int]int)
+	total := 0
+	for rows.Next() {
+		r := Result{Sections: []SectionResult{}}
+		if err := rows.Scan(&r.Rank, &r.StudentID, &r.Name, &r.Email, &r.Institution, &r.Country, &r.Designation,
+			&r.Score, &r.TotalTimeTakenSeconds, &r.QuestionsAnswered, &r.CorrectAnswers, &r.AttemptNumber,
+			&r.StartedAt, &r.CompletedAt, &r.AutoCompletedReason, &total); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		byStudent[r.StudentID] = len(results)
+		results = append(results, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// A page past the end has no rows to carry the total
+	if len(results) == 0 {
+		err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM exam_archive_results WHERE archive_id = $1`, id).Scan(&total)
+		return results, total, err
+	}
+
+	studentIDs := make([]int, 0, len(results))
+	for _, r := range results {
+		studentIDs = append(studentIDs, r.StudentID)
+	}
+	sectionQuery := `
+		SELECT student_id, section_id, rank, score, time_taken_seconds, questions_answered
+		FROM exam_archive_section_results
+		WHERE archive_id = $1 AND student_id = ANY($2)
+		ORDER BY section_id
+	`
+	rows, err = db.Pool.Query(ctx, sectionQuery, id, studentIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var studentID int
+		var s SectionResult
+		if err := rows.Scan(&studentID, &s.SectionID, &s.Rank, &s.Score, &s.TimeTakenSeconds, &s.QuestionsAnswered); err != nil {
+			return nil, 0, err
+		}
+		i := byStudent[studentID]
+		results[i].Sections = append(results[i].Sections, s)
+	}
+	return results, total, rows.Err()
+}
+
+// SectionLeaderboard returns a page of an archive's leaderboard for one section and the
+// number of students ranked in it
+func SectionLeaderboard(ctx context.Context, id, sectionID, limit, offset int) ([]SectionEntry, int, error) {
+	if found, err := exists(ctx, id); err != nil || !found {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT sr.rank, sr.student_id, r.name, r.email, sr.score, sr.time_taken_seconds, sr.questions_answered,
+		       COUNT(*) OVER ()
+		FROM exam_archive_section_results sr
+		JOIN exam_archive_results r ON r.archive_id = sr.archive_id AND r.student_id = sr.student_id
+		WHERE sr.archive_id = $1 AND sr.section_id = $2
+		ORDER BY sr.rank, sr.student_id
+		LIMIT $3 OFFSET $4
+	`
+	rows, err := db.Pool.Query(ctx, query, id, sectionID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	entries := make([]SectionEntry, 0)
+	total := 0
+	for rows.Next() {
+		var e SectionEntry
+		if err := rows.Scan(&e.Rank, &e.StudentID, &e.Name, &e.Email, &e.Score, &e.TimeTakenSeconds, &e.QuestionsAnswered, &total); err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(entries) == 0 {
+		query := `SELECT COUNT(*) FROM exam_archive_section_results WHERE archive_id = $1 AND section_id = $2`
+		err := db.Pool.QueryRow(ctx, query, id, sectionID).Scan(&total)
+		return entries, total, err
+	}
+	return entries, total, nil
+}
+
+// Delete removes an archive with its results
+func Delete(ctx context.Context, id int) error {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM exam_archives WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Pending describes the latest event when it is not archived, or has sessions completed
+// since its archive was taken
+type Pending struct {
+	EventScheduleID int `json:"event_schedule_id"`
+	// ArchiveID is the event's archive, 0 when it has none
+	ArchiveID int `json:"archive_id,omitempty"`
+	// CompletedSinceArchive counts sessions completed after the archive was taken
+	CompletedSinceArchive int `json:"completed_since_archive"`
+}
+
+// Unarchived returns the latest event when resetting the database would lose results not in
+// its archive: non-sandbox sessions completed since the archive was taken, or at all when
+// there is none. It is nil when there is no event or nothing to lose, e.g. before anyone
+// has taken the test.
+func Unarchived(ctx context.Context) (*Pending, error) {
+	var p Pending
+	var archiveID *int
+	query := `
+		SELECT e.id, a.id,
+		       (SELECT COUNT(*) FROM sessions s
+		        WHERE s.completed AND s.is_sandbox = false AND s.completed_at > COALESCE(a.archived_at, '-infinity'))
+		FROM event_schedule e
+		LEFT JOIN exam_archives a ON a.event_schedule_id = e.id AND a.event_created_at = e.created_at
+		ORDER BY e.id DESC
+		LIMIT 1
+	`
+	err := db.Pool.QueryRow(ctx, query).Scan(&p.EventScheduleID, &archiveID, &p.CompletedSinceArchive)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.CompletedSinceArchive == 0 {
+		return nil, nil
+	}
+	if archiveID != nil {
+		p.ArchiveID = *archiveID
+	}
+	return &p, nil
+}
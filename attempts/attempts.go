@@ -0,0 +1,95 @@
+package attempts
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Attempt policies: which completed attempt counts toward results and leaderboards
+const (
+	// PolicyBest counts the highest score, ties broken by less time
+	PolicyBest = "best"
+	// PolicyLatest counts the most recently completed attempt
+	PolicyLatest = "latest"
+)
+
+// Whether sessions the server finalized (sessions.auto_completed) count
+const (
+	// AutoCompletedInclude counts them like any completed attempt; results show them badged
+	AutoCompletedInclude = "include"
+	// AutoCompletedExclude leaves them out of leaderboards and results
+	AutoCompletedExclude = "exclude"
+)
+
+// Config is the exam-level attempt setting
+type Config struct {
+	// MaxAttempts is how many sessions a student may start (at least 1)
+	MaxAttempts int
+	// Policy selects the counted attempt: PolicyBest or PolicyLatest
+	Policy string
+	// AutoCompleted is AutoCompletedInclude or AutoCompletedExclude
+	AutoCompleted string
+}
+
+// ConfigFromEnv reads MAX_ATTEMPTS (default 1), ATTEMPT_POLICY (best or latest, default best)
+// and AUTO_COMPLETED_POLICY (include or exclude, default include)
+func ConfigFromEnv() Config {
+	cfg := Config{MaxAttempts: 1, Policy: PolicyBest, AutoCompleted: AutoCompletedInclude}
+
+	if value := strings.TrimSpace(os.Getenv("MAX_ATTEMPTS")); value != "" {
+		maxAttempts, err := strconv.Atoi(value)
+		if err != nil || maxAttempts < 1 {
+			log.Warn().Msgf("Invalid MAX_ATTEMPTS=%q, allowing 1 attempt", value)
+		} else {
+			cfg.MaxAttempts = maxAttempts
+		}
+	}
+
+	if value := strings.ToLower(strings.TrimSpace(os.Getenv("ATTEMPT_POLICY"))); value != "" {
+		if value != PolicyBest && value != PolicyLatest {
+			log.Warn().Msgf("Invalid ATTEMPT_POLICY=%q, using %s", value, PolicyBest)
+		} else {
+			cfg.Policy = value
+		}
+	}
+
+	if value := strings.ToLower(strings.TrimSpace(os.Getenv("AUTO_COMPLETED_POLICY"))); value != "" {
+		if value != AutoCompletedInclude && value != AutoCompletedExclude {
+			log.Warn().Msgf("Invalid AUTO_COMPLETED_POLICY=%q, using %s", value, AutoCompletedInclude)
+		} else {
+			cfg.AutoCompleted = value
+		}
+	}
+
+	return cfg
+}
+
+// OrderBy is the ORDER BY expression that puts a student's counted attempt first
+// among their completed sessions
+func (cfg Config) OrderBy() string {
+	if cfg.Policy == PolicyLatest {
+		return "completed_at DESC, id DESC"
+	}
+	return "score DESC, total_time_taken_seconds ASC, id ASC"
+}
+
+// CountedSessions is a subquery with one row per student: the completed session that counts
+// under the policy. Use it in place of the sessions table, e.g. "FROM " + CountedSessions() + " sess".
+// Sandbox sessions (POST /api/admin/test-run) and disqualified sessions never count, nor do
+// sessions the server finalized under AutoCompletedExclude.
+func CountedSessions() string {
+	cfg := ConfigFromEnv()
+	condition := "completed = true AND is_sandbox = false AND disqualified_at IS NULL"
+	if cfg.AutoCompleted == AutoCompletedExclude {
+		condition += " AND auto_completed = false"
+	}
+	return `(
+		SELECT DISTINCT ON (student_id) *
+		FROM sessions
+		WHERE ` + condition + `
+		ORDER BY student_id, ` + cfg.OrderBy() + `
+	)`
+}
@@ -0,0 +1,269 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mcq-exam/config"
+	"mcq-exam/db"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// Format identifies a JSON backup; Version changes when the archive layout does
+const (
+	Format  = "nicm-backup"
+	Version = 1
+)
+
+// defaultImportLimitMB is the request body limit while imports are enabled
+const defaultImportLimitMB = 256
+
+// Tables are the tables in a backup, parents before children. Results are the score
+// columns on sessions plus session_section_scores.
+var Tables = []string{"students", "email_tracking", "sessions", "answers", "session_section_scores"}
+
+var (
+	// ErrInvalidArchive is wrapped by Restore for input that is not a usable backup
+	ErrInvalidArchive = errors.New("invalid backup")
+	// ErrSchemaMismatch is returned by Restore when the backup was taken at another
+	// migration version, so its rows may not fit the tables
+	ErrSchemaMismatch = errors.New("backup schema version does not match the database")
+	// ErrPgDumpMissing is returned by WriteSQL when pg_dump is not on PATH
+	ErrPgDumpMissing = errors.New("pg_dump is not installed")
+)
+
+// Archive is the layout of a JSON backup
+type Archive struct {
+	Format        string                     `json:"format"`
+	Version       int                        `json:"version"`
+	SchemaVersion uint                       `json:"schema_version"`
+	CreatedAt     time.Time                  `json:"created_at"`
+	Tables        map[string]json.RawMessage `json:"tables"`
+	Counts        map[string]int             `json:"counts"`
+}
+
+// ImportEnabled reports whether backups may be restored over the API (BACKUP_IMPORT_ENABLED,
+// default false). Restoring replaces every student, so only turn it on for staging.
+func ImportEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("BACKUP_IMPORT_ENABLED"))
+	return enabled
+}
+
+// ImportLimit is the request body limit in bytes while imports are enabled
+// (BACKUP_IMPORT_MAX_MB, default 256)
+func ImportLimit() int {
+	limit := defaultImportLimitMB
+	if value := strings.TrimSpace(os.Getenv("BACKUP_IMPORT_MAX_MB")); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			log.Warn().Msgf("Invalid BACKUP_IMPORT_MAX_MB=%q, using %d", value, defaultImportLimitMB)
+		} else {
+			limit = parsed
+		}
+	}
+	return limit * 1024 * 1024
+}
+
+// WriteJSON writes a JSON backup of Tables to w. Rows are read in one read-only snapshot and
+// streamed as Postgres renders them, so the backup is consistent and never held in memory.
+func WriteJSON(ctx context.Context, w io.Writer) error {
+	state, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return fmt.Errorf("failed to begin snapshot: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	createdAt, err := json.Marshal(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, `{"format":%q,"version":%d,"schema_version":%d,"created_at":%s,"tables":{`,
+		Format, Version, state.Version, createdAt); err != nil {
+		return err
+	}
+
+	counts := make(map[string]int, len(Tables))
+	for i, table := range Tables {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%q:[", table); err != nil {
+			return err
+		}
+		count, err := writeRows(ctx, tx, w, table)
+		if err != nil {
+			return err
+		}
+		counts[table] = count
+		if _, err := io.WriteString(w, "]"); err != nil {
+			return err
+		}
+	}
+
+	countsJSON, err := json.Marshal(counts)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, `},"counts":%s}`, countsJSON)
+	return err
+}
+
+// writeRows writes every row of table as comma-separated JSON objects
+func writeRows(ctx context.Context, tx pgx.Tx, w io.Writer, table string) (int, error) {
+	rows, err := tx.Query(ctx, fmt.Sprintf(`SELECT row_to_json(t)::text FROM %s t ORDER BY id`, pgx.Identifier{table}.Sanitize()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var row string
+		if err := rows.Scan(&row); err != nil {
+			return count, err
+		}
+		if count > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return count, err
+			}
+		}
+		if _, err := io.WriteString(w, row); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	return count, nil
+}
+
+// PgDumpAvailable reports whether WriteSQL can run
+func PgDumpAvailable() bool {
+	_, err := exec.LookPath("pg_dump")
+	return err == nil
+}
+
+// WriteSQL writes a data-only pg_dump of Tables to w, for restoring with psql into a database
+// migrated to the same version
+func WriteSQL(ctx context.Context, w io.Writer) error {
+	path, err := exec.LookPath("pg_dump")
+	if err != nil {
+		return ErrPgDumpMissing
+	}
+
+	args := []string{"--data-only", "--no-owner", "--no-privileges"}
+	for _, table := range Tables {
+		args = append(args, "--table=public."+table)
+	}
+	// The password goes in the environment rather than the arguments so it is not visible in ps
+	dbname, password := splitPassword(config.Get().DatabaseURL)
+	args = append(args, "--dbname="+dbname)
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = os.Environ()
+	if password != "" {
+		cmd.Env = append(cmd.Env, "PGPASSWORD="+password)
+	}
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pg_dump failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// splitPassword removes the password from a postgres:// URL; other connection strings are
+// returned unchanged
+func splitPassword(databaseURL string) (string, string) {
+	u, err := url.Parse(databaseURL)
+	if err != nil || u.User == nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return databaseURL, ""
+	}
+	password, ok := u.User.Password()
+	if !ok {
+		return databaseURL, ""
+	}
+	u.User = url.User(u.User.Username())
+	return u.String(), password
+}
+
+// Restore replaces the contents of Tables with a JSON backup, in one transaction, and returns
+// the rows restored per table. Truncating students cascades to every table that references
+// students or sessions (answer events, client events, proctoring, ...), which the backup does
+// not contain. Sequences are moved past the restored IDs.
+func Restore(ctx context.Context, r io.Reader) (map[string]int, error) {
+	var archive Archive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	if archive.Format != Format {
+		return nil, fmt.Errorf("%w: format must be %q", ErrInvalidArchive, Format)
+	}
+	if archive.Version != Version {
+		return nil, fmt.Errorf("%w: version %d is not supported", ErrInvalidArchive, archive.Version)
+	}
+	for _, table := range Tables {
+		if _, ok := archive.Tables[table]; !ok {
+			return nil, fmt.Errorf("%w: table %s is missing", ErrInvalidArchive, table)
+		}
+	}
+
+	state, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if archive.SchemaVersion != state.Version {
+		return nil, fmt.Errorf("%w: backup is at %d, database at %d", ErrSchemaMismatch, archive.SchemaVersion, state.Version)
+	}
+
+	identifiers := make([]string, len(Tables))
+	for i, table := range Tables {
+		identifiers[i] = pgx.Identifier{table}.Sanitize()
+	}
+
+	counts := make(map[string]int, len(Tables))
+	err = db.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `TRUNCATE `+strings.Join(identifiers, ", ")+` CASCADE`); err != nil {
+			return fmt.Errorf("failed to clear tables: %w", err)
+		}
+		for i, table := range Tables {
+			// Columns come from the table's own row type, so keys the backup lacks become NULL
+			tag, err := tx.Exec(ctx, fmt.Sprintf(`INSERT INTO %[1]s SELECT * FROM json_populate_recordset(NULL::%[1]s, $1::json)`,
+				identifiers[i]), string(archive.Tables[table]))
+			if err != nil {
+				return fmt.Errorf("failed to restore %s: %w", table, err)
+			}
+			counts[table] = int(tag.RowsAffected())
+
+			_, err = tx.Exec(ctx, fmt.Sprintf(`SELECT setval(pg_get_serial_sequence($1, 'id'), COALESCE(MAX(id), 1), MAX(id) IS NOT NULL) FROM %s`,
+				identifiers[i]), table)
+			if err != nil {
+				return fmt.Errorf("failed to reset %s sequence: %w", table, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
@@ -0,0 +1,514 @@
+package campaigns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"mcq-exam/i18n"
+	"mcq-exam/segments"
+	"mcq-exam/tracking"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Campaign statuses
+const (
+	StatusDraft     = "draft"
+	StatusRunning   = "running"
+	StatusPaused    = "paused"
+	StatusCompleted = "completed"
+	// StatusTracking is a system campaign's: it records sends made elsewhere and is never
+	// launched, paused, resumed or deleted
+	StatusTracking = "tracking"
+)
+
+// Recipient statuses
+const (
+	RecipientPending = "pending"
+	RecipientSent    = "sent"
+	RecipientFailed  = "failed"
+	RecipientSkipped = "skipped"
+)
+
+// Campaign kinds
+const (
+	// KindCustom sends html_body as written, with {{name}} replaced
+	KindCustom = "custom"
+	// KindResults fills in each recipient's scorecard (see RenderScorecard)
+	KindResults = "results"
+	// KindSystem tracks the emails of one email type sent outside campaigns (firstMail,
+	// secondMail, broadcast, ...); see package tracking
+	KindSystem = "system"
+)
+
+// Audience segments
+const (
+	// SegmentAll is every student
+	SegmentAll = "all"
+	// SegmentNotOpened is students who were sent source_email_type but never opened it
+	SegmentNotOpened = "not_opened"
+	// SegmentNotAttended is students invited to the conference who did not join it
+	SegmentNotAttended = "not_attended"
+	// SegmentAttendedNotStarted is students who joined the conference but never started the test
+	SegmentAttendedNotStarted = "attended_not_started"
+	// SegmentStartedNotCompleted is students with a session but no completed one
+	SegmentStartedNotCompleted = "started_not_completed"
+	// SegmentCompleted is students with a completed session
+	SegmentCompleted = "completed"
+)
+
+// Segments lists every audience segment a campaign can target
+var Segments = []string{
+	SegmentAll,
+	SegmentNotOpened,
+	SegmentNotAttended,
+	SegmentAttendedNotStarted,
+	SegmentStartedNotCompleted,
+	SegmentCompleted,
+}
+
+// segmentFilters are WHERE conditions over students aliased as s. $1 is source_email_type
+// for the segments that need it (see segmentArgs).
+var segmentFilters = map[string]string{
+	SegmentAll: `TRUE`,
+	SegmentNotOpened: `EXISTS (SELECT 1 FROM email_events e WHERE e.student_id = s.id AND e.email_type = $1 AND e.event_type = 'sent')
+		AND NOT EXISTS (SELECT 1 FROM email_events e WHERE e.student_id = s.id AND e.email_type = $1 AND e.event_type = 'open')`,
+	SegmentNotAttended: `EXISTS (SELECT 1 FROM email_tracking et
+		WHERE et.student_id = s.id AND et.email_type = 'firstMail' AND et.conference_attended = false)`,
+	SegmentAttendedNotStarted: `EXISTS (SELECT 1 FROM email_tracking et
+		WHERE et.student_id = s.id AND et.email_type = 'firstMail' AND et.conference_attended = true)
+		AND NOT EXISTS (SELECT 1 FROM sessions sess WHERE sess.student_id = s.id)`,
+	SegmentStartedNotCompleted: `EXISTS (SELECT 1 FROM sessions sess WHERE sess.student_id = s.id)
+		AND NOT EXISTS (SELECT 1 FROM sessions sess WHERE sess.student_id = s.id AND sess.completed = true)`,
+	SegmentCompleted: `EXISTS (SELECT 1 FROM sessions sess WHERE sess.student_id = s.id AND sess.completed = true)`,
+}
+
+var (
+	ErrNotFound     = errors.New("campaign not found")
+	ErrNoRecipients = errors.New("segment matches no students")
+)
+
+// StateError reports an action that is not allowed in the campaign's current status
+type StateError struct {
+	Action string
+	Status string
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("cannot %s a %s campaign", e.Action, e.Status)
+}
+
+// Campaign is one email template sent to an audience segment, optionally narrowed by a
+// segment filter (see package segments). Recipients whose locale has one of the Variants
+// get it instead of Subject and HTMLBody.
+type Campaign struct {
+	ID              int             `json:"id"`
+	Name            string          `json:"name"`
+	Kind            string          `json:"kind"`
+	Subject         string          `json:"subject"`
+	HTMLBody        string          `json:"html_body,omitempty"`
+	Variants        i18n.Variants   `json:"variants,omitempty"`
+	Segment         string          `json:"segment"`
+	SourceEmailType *string         `json:"source_email_type"`
+	Filter          *string         `json:"filter"`
+	Status          string          `json:"status"`
+	Recipients      RecipientCounts `json:"recipients"`
+	StartedAt       *time.Time      `json:"started_at"`
+	CompletedAt     *time.Time      `json:"completed_at"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	// EmailType is the type a system campaign tracks; campaigns' own emails are "campaign-N"
+	EmailType *string `json:"email_type,omitempty"`
+	// StrictVariables fails a recipient whose email would go out with a variable left empty
+	// (see package personalize)
+	StrictVariables bool `json:"strict_variables"`
+	// Tracking adds the open pixel and click tracking to every email (see tracking.Instrument)
+	Tracking bool `json:"tracking"`
+}
+
+// Options are how a campaign sends; the zero value sends tracked, non-strict emails
+type Options struct {
+	// StrictVariables fails recipients whose email would go out with a variable left empty
+	StrictVariables bool
+	// Untracked sends without the open pixel and click tracking
+	Untracked bool
+}
+
+// RecipientCounts summarises per-recipient status
+type RecipientCounts struct {
+	Total   int `json:"total"`
+	Pending int `json:"pending"`
+	Sent    int `json:"sent"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+	Opened  int `json:"opened"`
+	Clicked int `json:"clicked"`
+}
+
+// Recipient is one student's delivery state in a campaign
+type Recipient struct {
+	ID        int        `json:"id"`
+	StudentID int        `json:"student_id"`
+	Name      string     `json:"name"`
+	Email     string     `json:"email"`
+	Status    string     `json:"status"`
+	RequestID *string    `json:"request_id"`
+	Error     *string    `json:"error"`
+	SentAt    *time.Time `json:"sent_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	// Token identifies the recipient and is kept across resends
+	Token      string     `json:"token"`
+	OpenedAt   *time.Time `json:"opened_at"`
+	OpenCount  int        `json:"open_count"`
+	ClickedAt  *time.Time `json:"clicked_at"`
+	ClickCount int        `json:"click_count"`
+}
+
+// Preview is the audience a segment would reach right now
+type Preview struct {
+	Segment    string  `json:"segment"`
+	Filter     *string `json:"filter,omitempty"`
+	Students   int     `json:"students"`
+	Suppressed int     `json:"suppressed"`
+	// InvalidEmails counts addresses that failed validation and are not suppressed; they
+	// are skipped when the campaign launches
+	InvalidEmails int `json:"invalid_emails"`
+	// Deliverable excludes suppressed and invalid addresses, which are skipped
+	Deliverable int `json:"deliverable"`
+}
+
+// IsValidSegment reports whether segment is a known audience segment
+func IsValidSegment(segment string) bool {
+	_, exists := segmentFilters[segment]
+	return exists
+}
+
+// EmailType is the tracking type used for a campaign's emails (email_events, email_links),
+// and the source_email_type to target people who did not open it
+func EmailType(campaignID int) string {
+	return fmt.Sprintf("campaign-%d", campaignID)
+}
+
+// segmentArgs returns the query arguments for a segment filter. A legacy source email
+// type ("first", "second") matches its canonical name.
+func segmentArgs(segment string, sourceEmailType *string) []interface{} {
+	if segment == SegmentNotOpened {
+		source := ""
+		if sourceEmailType != nil {
+			source = string(tracking.ParseEmailType(*sourceEmailType))
+		}
+		return []interface{}{source}
+	}
+	return nil
+}
+
+// audience is the WHERE condition over students aliased as s for a segment narrowed by
+// an optional filter, with its arguments. A filter that does not parse wraps
+// segments.ErrInvalidFilter.
+func audience(segment string, sourceEmailType, filter *string) (string, []interface{}, error) {
+	args := segmentArgs(segment, sourceEmailType)
+	if filter == nil {
+		return segmentFilters[segment], args, nil
+	}
+	compiled, err := segments.Compile(*filter, len(args)+1)
+	if err != nil {
+		return "", nil, err
+	}
+	return "(" + segmentFilters[segment] + ") AND (" + compiled.SQL + ")", append(args, compiled.Args...), nil
+}
+
+// campaignColumns selects a campaign with its recipient counts; join rc from recipientCountsJoin
+const campaignColumns = `
+	c.id, c.name, c.kind, c.subject, c.html_body, c.variants, c.segment, c.source_email_type, c.filter, c.status,
+	COALESCE(rc.total, 0), COALESCE(rc.pending, 0), COALESCE(rc.sent, 0), COALESCE(rc.failed, 0), COALESCE(rc.skipped, 0),
+	COALESCE(rc.opened, 0), COALESCE(rc.clicked, 0),
+	c.started_at, c.completed_at, c.created_at, c.updated_at, c.email_type, c.strict_variables, c.tracking`
+
+const recipientCountsJoin = `
+	LEFT JOIN (
+		SELECT campaign_id,
+		       COUNT(*) AS total,
+		       COUNT(*) FILTER (WHERE status IN ('pending', 'sending')) AS pending,
+		       COUNT(*) FILTER (WHERE status = 'sent') AS sent,
+		       COUNT(*) FILTER (WHERE status = 'failed') AS failed,
+		       COUNT(*) FILTER (WHERE status = 'skipped') AS skipped,
+		       COUNT(*) FILTER (WHERE opened_at IS NOT NULL) AS opened,
+		       COUNT(*) FILTER (WHERE clicked_at IS NOT NULL) AS clicked
+		FROM email_campaign_recipients
+		GROUP BY campaign_id
+	) rc ON rc.campaign_id = c.id`
+
+func scanCampaign(row pgx.Row, c *Campaign) error {
+	return row.Scan(&c.ID, &c.Name, &c.Kind, &c.Subject, &c.HTMLBody, &c.Variants, &c.Segment, &c.SourceEmailType, &c.Filter, &c.Status,
+		&c.Recipients.Total, &c.Recipients.Pending, &c.Recipients.Sent, &c.Recipients.Failed, &c.Recipients.Skipped,
+		&c.Recipients.Opened, &c.Recipients.Clicked,
+		&c.StartedAt, &c.CompletedAt, &c.CreatedAt, &c.UpdatedAt, &c.EmailType, &c.StrictVariables, &c.Tracking)
+}
+
+// PreviewSegment counts the students a segment, narrowed by filter when not nil, matches
+// and how many of them are suppressed or have an invalid address
+func PreviewSegment(ctx context.Context, segment string, sourceEmailType, filter *string) (Preview, error) {
+	preview := Preview{Segment: segment, Filter: filter}
+	condition, args, err := audience(segment, sourceEmailType, filter)
+	if err != nil {
+		return preview, err
+	}
+	query := `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE x.suppressed), COUNT(*) FILTER (WHERE NOT x.suppressed AND NOT s.email_valid)
+		FROM students s
+		CROSS JOIN LATERAL (
+			SELECT EXISTS (SELECT 1 FROM suppressed_emails se WHERE se.email = LOWER(TRIM(s.email))) AS suppressed
+		) x
+		WHERE s.is_sandbox = false AND (` + condition + `)`
+	if err := db.Pool.QueryRow(ctx, query, args...).Scan(&preview.Students, &preview.Suppressed, &preview.InvalidEmails); err != nil {
+		return preview, fmt.Errorf("failed to count segment: %w", err)
+	}
+	preview.Deliverable = preview.Students - preview.Suppressed - preview.InvalidEmails
+	return preview, nil
+}
+
+// Create stores a draft campaign; filter, when not nil, narrows the segment. variants may be nil.
+func Create(ctx context.Context, name, subject, htmlBody string, variants i18n.Variants, segment string, sourceEmailType, filter *string, opts Options) (*Campaign, error) {
+	if _, _, err := audience(segment, sourceEmailType, filter); err != nil {
+		return nil, err
+	}
+	return create(ctx, KindCustom, name, subject, htmlBody, variants, segment, sourceEmailType, filter, opts)
+}
+
+// CreateResults stores a draft results campaign addressed to every student with a completed session
+func CreateResults(ctx context.Context, name, subject, htmlBody string, variants i18n.Variants, opts Options) (*Campaign, error) {
+	return create(ctx, KindResults, name, subject, htmlBody, variants, SegmentCompleted, nil, nil, opts)
+}
+
+func create(ctx context.Context, kind, name, subject, htmlBody string, variants i18n.Variants, segment string, sourceEmailType, filter *string, opts Options) (*Campaign, error) {
+	if variants == nil {
+		variants = i18n.Variants{}
+	}
+	var id int
+	query := `
+		INSERT INTO email_campaigns (kind, name, subject, html_body, variants, segment, source_email_type, filter, strict_variables, tracking)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id
+	`
+	if err := db.Pool.QueryRow(ctx, query, kind, name, subject, htmlBody, variants, segment, sourceEmailType, filter, opts.StrictVariables, !opts.Untracked).Scan(&id); err != nil {
+		return nil, fmt.Errorf("failed to create campaign: %w", err)
+	}
+	return Get(ctx, id)
+}
+
+// ActiveResults returns the results campaign that is running or paused, if any, so
+// results are not blasted twice
+func ActiveResults(ctx context.Context) (*Campaign, error) {
+	var id int
+	query := `SELECT id FROM email_campaigns WHERE kind = 'results' AND status IN ('running', 'paused') ORDER BY id DESC LIMIT 1`
+	err := db.Pool.QueryRow(ctx, query).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check results campaigns: %w", err)
+	}
+	return Get(ctx, id)
+}
+
+// Get loads a campaign with its recipient counts
+func Get(ctx context.Context, id int) (*Campaign, error) {
+	var campaign Campaign
+	query := `SELECT ` + campaignColumns + ` FROM email_campaigns c ` + recipientCountsJoin + ` WHERE c.id = $1`
+	err := scanCampaign(db.Pool.QueryRow(ctx, query, id), &campaign)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch campaign: %w", err)
+	}
+	return &campaign, nil
+}
+
+// List returns campaigns newest first, optionally filtered by status. Bodies are left out.
+func List(ctx context.Context, status string) ([]Campaign, error) {
+	query := `SELECT ` + campaignColumns + ` FROM email_campaigns c ` + recipientCountsJoin + `
+		WHERE $1 = '' OR c.status = $1
+		ORDER BY c.id DESC`
+	rows, err := db.Pool.Query(ctx, query, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch campaigns: %w", err)
+	}
+	defer rows.Close()
+
+	campaigns := []Campaign{}
+	for rows.Next() {
+		var campaign Campaign
+		if err := scanCampaign(rows, &campaign); err != nil {
+			return nil, fmt.Errorf("failed to scan campaign: %w", err)
+		}
+		campaign.HTMLBody = ""
+		campaign.Variants = nil
+		campaigns = append(campaigns, campaign)
+	}
+	return campaigns, rows.Err()
+}
+
+// Recipients returns a page of a campaign's recipients in send order, optionally filtered by status
+func Recipients(ctx context.Context, campaignID int, status string, limit, offset int) ([]Recipient, int, error) {
+	var total int
+	countQuery := `SELECT COUNT(*) FROM email_campaign_recipients WHERE campaign_id = $1 AND ($2 = '' OR status = $2)`
+	if err := db.Pool.QueryRow(ctx, countQuery, campaignID, status).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count recipients: %w", err)
+	}
+
+	query := `
+		SELECT r.id, r.student_id, s.name, r.email, r.status, r.request_id, r.error, r.sent_at, r.updated_at,
+		       r.token, r.opened_at, r.open_count, r.clicked_at, r.click_count
+		FROM email_campaign_recipients r
+		JOIN students s ON s.id = r.student_id
+		WHERE r.campaign_id = $1 AND ($2 = '' OR r.status = $2)
+		ORDER BY r.id
+		LIMIT $3 OFFSET $4
+	`
+	rows, err := db.Pool.Query(ctx, query, campaignID, status, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch recipients: %w", err)
+	}
+	defer rows.Close()
+
+	recipients := []Recipient{}
+	for rows.Next() {
+		var r Recipient
+		if err := rows.Scan(&r.ID, &r.StudentID, &r.Name, &r.Email, &r.Status, &r.RequestID, &r.Error, &r.SentAt, &r.UpdatedAt,
+			&r.Token, &r.OpenedAt, &r.OpenCount, &r.ClickedAt, &r.ClickCount); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan recipient: %w", err)
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, total, rows.Err()
+}
+
+// Launch snapshots the segment's current students as recipients and starts sending.
+// Students whose address failed validation are recorded as skipped, so the campaign's
+// skipped recipients report them.
+func Launch(ctx context.Context, id int) (*Campaign, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var segment, status string
+	var sourceEmailType, filter *string
+	err = tx.QueryRow(ctx, `SELECT segment, source_email_type, filter, status FROM email_campaigns WHERE id = $1 FOR UPDATE`, id).
+		Scan(&segment, &sourceEmailType, &filter, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch campaign: %w", err)
+	}
+	if status != StatusDraft {
+		return nil, &StateError{Action: "launch", Status: status}
+	}
+
+	// The filter is evaluated now, so students tagged after the campaign was created are included
+	condition, args, err := audience(segment, sourceEmailType, filter)
+	if err != nil {
+		return nil, err
+	}
+	insertQuery := fmt.Sprintf(`
+		INSERT INTO email_campaign_recipients (campaign_id, student_id, email, status, error)
+		SELECT $%d, s.id, s.email,
+		       CASE WHEN s.email_valid THEN 'pending' ELSE 'skipped' END,
+		       CASE WHEN s.email_valid THEN NULL ELSE 'invalid email address: ' || COALESCE(s.email_invalid_reason, 'unknown') END
+		FROM students s
+		WHERE s.is_sandbox = false AND (%s)
+		ORDER BY s.id
+		ON CONFLICT (campaign_id, student_id) DO NOTHING
+	`, len(args)+1, condition)
+	tag, err := tx.Exec(ctx, insertQuery, append(args, id)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot recipients: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	updateQuery := `
+		UPDATE email_campaigns
+		SET status = 'running', started_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+	`
+	if _, err := tx.Exec(ctx, updateQuery, id); err != nil {
+		return nil, fmt.Errorf("failed to start campaign: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit: %w", err)
+	}
+
+	start(id)
+	return Get(ctx, id)
+}
+
+// Pause stops a running campaign after the send in progress
+func Pause(ctx context.Context, id int) (*Campaign, error) {
+	if err := transition(ctx, id, "pause", StatusRunning, StatusPaused); err != nil {
+		return nil, err
+	}
+	stop(id)
+	return Get(ctx, id)
+}
+
+// Resume continues a paused campaign with its remaining pending recipients
+func Resume(ctx context.Context, id int) (*Campaign, error) {
+	if err := transition(ctx, id, "resume", StatusPaused, StatusRunning); err != nil {
+		return nil, err
+	}
+	start(id)
+	return Get(ctx, id)
+}
+
+// Delete removes a campaign that is not running, with its recipient history. System
+// campaigns are kept.
+func Delete(ctx context.Context, id int) error {
+	var status string
+	err := db.Pool.QueryRow(ctx, `DELETE FROM email_campaigns WHERE id = $1 AND status NOT IN ('running', 'tracking') RETURNING status`, id).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return stateOrNotFound(ctx, id, "delete")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete campaign: %w", err)
+	}
+	return nil
+}
+
+// transition moves a campaign from one status to another
+func transition(ctx context.Context, id int, action, from, to string) error {
+	query := `UPDATE email_campaigns SET status = $1, updated_at = NOW() WHERE id = $2 AND status = $3`
+	tag, err := db.Pool.Exec(ctx, query, to, id, from)
+	if err != nil {
+		return fmt.Errorf("failed to %s campaign: %w", action, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return stateOrNotFound(ctx, id, action)
+	}
+	return nil
+}
+
+// stateOrNotFound explains why a conditional update matched nothing
+func stateOrNotFound(ctx context.Context, id int, action string) error {
+	var status string
+	err := db.Pool.QueryRow(ctx, `SELECT status FROM email_campaigns WHERE id = $1`, id).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch campaign: %w", err)
+	}
+	return &StateError{Action: action, Status: status}
+}
+
+// NormalizeSegment lowercases and trims a segment name
+func NormalizeSegment(segment string) string {
+	return strings.ToLower(strings.TrimSpace(segment))
+}
@@ -0,0 +1,67 @@
+package campaigns
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mcq-exam/config"
+	"mcq-exam/db"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrInvalidResultToken is returned for a result token no student holds
+var ErrInvalidResultToken = errors.New("invalid result token")
+
+// ResultToken returns the student's result link token, creating it on first use. The
+// token lets the student look up their own result without an access code.
+func ResultToken(ctx context.Context, studentID int) (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+
+	// An existing token is kept, so links in earlier results emails keep working
+	query := `
+		INSERT INTO result_tokens (student_id, token)
+		VALUES ($1, $2)
+		ON CONFLICT (student_id) DO UPDATE SET student_id = EXCLUDED.student_id
+		RETURNING token
+	`
+	var token string
+	if err := db.Pool.QueryRow(ctx, query, studentID, hex.EncodeToString(randomBytes)).Scan(&token); err != nil {
+		return "", fmt.Errorf("failed to create result token: %w", err)
+	}
+	return token, nil
+}
+
+// StudentByResultToken returns the student a result token belongs to and records its use
+func StudentByResultToken(ctx context.Context, token string) (int, error) {
+	query := `
+		UPDATE result_tokens
+		SET last_used_at = NOW()
+		WHERE token = $1
+		RETURNING student_id
+	`
+	var studentID int
+	err := db.Pool.QueryRow(ctx, query, token).Scan(&studentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrInvalidResultToken
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to check result token: %w", err)
+	}
+	return studentID, nil
+}
+
+// AttachResultsURL sets the scorecard's link to the student's result page
+func (card *Scorecard) AttachResultsURL(ctx context.Context) error {
+	token, err := ResultToken(ctx, card.StudentID)
+	if err != nil {
+		return err
+	}
+	card.ResultsURL = config.FrontendURL() + "/results?token=" + token
+	return nil
+}
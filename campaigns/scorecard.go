@@ -0,0 +1,215 @@
+package campaigns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/attempts"
+	"mcq-exam/db"
+	"mcq-exam/questions"
+	"mcq-exam/ranking"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// meritRanks is how many top ranks receive a merit certificate, as announced in the invitations
+const meritRanks = 10
+
+// Certificate types
+const (
+	CertificateMerit         = "merit"
+	CertificateParticipation = "participation"
+)
+
+// ErrNoResult is returned for a student without a completed session
+var ErrNoResult = errors.New("student has no completed session")
+
+// DefaultResultsSubject is used when a results campaign is created without a subject
+const DefaultResultsSubject = "Your SmartMCQ Test Results"
+
+// DefaultResultsBody is the results email used when none is given. Placeholders:
+// {{name}}, {{score}}, {{max_score}}, {{rank}}, {{participants}}, {{time_taken}},
+// {{sections}} (a table of section results), {{certificate}} (a link block, empty when
+// CERTIFICATE_URL is unset), {{certificate_url}}, {{certificate_type}}, {{results_link}}
+// (a link block to the student's result page) and {{results_url}}.
+const DefaultResultsBody = `
+<div style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto;">
+	<h2>Your Test Results - SmartMCQ</h2>
+	<p>Dear {{name}},</p>
+	<p>Thank you for taking the test. Here is your scorecard:</p>
+	<table style="border-collapse: collapse; margin: 10px 0;">
+		<tr><td style="padding: 4px 12px 4px 0;"><strong>Score</strong></td><td>{{score}} / {{max_score}}</td></tr>
+		<tr><td style="padding: 4px 12px 4px 0;"><strong>Rank</strong></td><td>{{rank}} of {{participants}}</td></tr>
+		<tr><td style="padding: 4px 12px 4px 0;"><strong>Time taken</strong></td><td>{{time_taken}}</td></tr>
+	</table>
+	<h3>Section breakdown</h3>
+	{{sections}}
+	{{certificate}}
+	{{results_link}}
+	<p>Best regards,<br>SmartMCQ Team</p>
+</div>
+`
+
+// SectionResult is a student's result in one section
+type SectionResult struct {
+	SectionID        int    `json:"section_id"`
+	Name             string `json:"name"`
+	Score            int    `json:"score"`
+	MaxScore         int    `json:"max_score"`
+	TimeTakenSeconds int    `json:"time_taken_seconds"`
+}
+
+// Scorecard is a student's result in their counted attempt
+type Scorecard struct {
+	StudentID    int `json:"student_id"`
+	SessionID    int `json:"session_id"`
+	Score        int `json:"score"`
+	MaxScore     int `json:"max_score"`
+	Rank         int `json:"rank"`
+	Participants int `json:"participants"`
+	// Percentile is the share of participants ranked below the student, 0 to 100
+	Percentile       float64         `json:"percentile"`
+	TimeTakenSeconds int             `json:"time_taken_seconds"`
+	Sections         []SectionResult `json:"sections"`
+	CertificateType  string          `json:"certificate_type"`
+	CertificateURL   string          `json:"certificate_url,omitempty"`
+	// ResultsURL links to the student's result page; set by AttachResultsURL for emails
+	ResultsURL string `json:"-"`
+}
+
+// LoadScorecard builds the scorecard of a student's counted attempt (ATTEMPT_POLICY),
+// ranked by the exam's ranking policy
+func LoadScorecard(ctx context.Context, studentID int) (*Scorecard, error) {
+	policy := ranking.Current(ctx)
+	query := `
+		SELECT id, score, total_time, rank, participants, percentile, question_seed
+		FROM (
+			SELECT sess.id, sess.student_id,
+			       COALESCE(sess.score, 0) AS score,
+			       COALESCE(sess.total_time_taken_seconds, 0) AS total_time,
+			       sess.question_seed,
+			       ` + policy.DenseRank("sess") + ` AS rank,
+			       COUNT(*) OVER () AS participants,
+			       ROUND(((1 - CUME_DIST() OVER (ORDER BY ` + policy.OrderBy("sess") + `)) * 100)::numeric, 1)::float8 AS percentile
+			FROM ` + attempts.CountedSessions() + ` sess
+		) ranked
+		WHERE student_id = $1
+	`
+	card := Scorecard{StudentID: studentID}
+	var seed *int64
+	err := db.Pool.QueryRow(ctx, query, studentID).Scan(&card.SessionID, &card.Score, &card.TimeTakenSeconds,
+		&card.Rank, &card.Participants, &card.Percentile, &seed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoResult
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load result: %w", err)
+	}
+
+	sections, err := questions.Load()
+	if err != nil {
+		return nil, err
+	}
+	// The student's own question set decides how many questions each section had
+	if seed != nil {
+		sections = questions.ForSeed(sections, *seed, questions.ConfigFromEnv())
+	}
+
+	scores := make(map[int]SectionResult)
+	rows, err := db.Pool.Query(ctx, `SELECT section_id, score, time_taken_seconds FROM session_section_scores WHERE session_id = $1`, card.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load section results: %w", err)
+	}
+	for rows.Next() {
+		var section SectionResult
+		if err := rows.Scan(&section.SectionID, &section.Score, &section.TimeTakenSeconds); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		scores[section.SectionID] = section
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	card.Sections = make([]SectionResult, 0, len(sections))
+	for _, section := range sections {
+		result := scores[section.ID]
+		result.SectionID = section.ID
+		result.Name = section.Name
+		result.MaxScore = len(section.Questions)
+		card.MaxScore += result.MaxScore
+		card.Sections = append(card.Sections, result)
+	}
+
+	card.CertificateType = CertificateParticipation
+	if card.Rank <= meritRanks {
+		card.CertificateType = CertificateMerit
+	}
+	card.CertificateURL = certificateURL(card)
+	return &card, nil
+}
+
+// certificateURL fills CERTIFICATE_URL, e.g. "https://certs.example.com/{{student_id}}?type={{certificate_type}}".
+// Empty when CERTIFICATE_URL is unset.
+func certificateURL(card Scorecard) string {
+	template := os.Getenv("CERTIFICATE_URL")
+	if template == "" {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{{student_id}}", strconv.Itoa(card.StudentID),
+		"{{session_id}}", strconv.Itoa(card.SessionID),
+		"{{certificate_type}}", card.CertificateType,
+	).Replace(template)
+}
+
+// RenderScorecard fills a results email body with the recipient's name and scorecard
+func RenderScorecard(body, name string, card *Scorecard) string {
+	var sections strings.Builder
+	sections.WriteString(`<table style="border-collapse: collapse;">`)
+	sections.WriteString(`<tr><th style="text-align: left; padding: 4px 12px 4px 0;">Section</th><th style="text-align: left; padding: 4px 12px 4px 0;">Score</th><th style="text-align: left;">Time</th></tr>`)
+	for _, section := range card.Sections {
+		fmt.Fprintf(&sections, `<tr><td style="padding: 4px 12px 4px 0;">%s</td><td style="padding: 4px 12px 4px 0;">%d / %d</td><td>%s</td></tr>`,
+			section.Name, section.Score, section.MaxScore, FormatDuration(section.TimeTakenSeconds))
+	}
+	sections.WriteString(`</table>`)
+
+	certificate := ""
+	if card.CertificateURL != "" {
+		label := "Download your Participation Certificate"
+		if card.CertificateType == CertificateMerit {
+			label = "Download your Merit Certificate"
+		}
+		certificate = `<p><a href="` + card.CertificateURL + `" style="background-color: #4CAF50; color: white; padding: 14px 20px; text-decoration: none; border-radius: 4px; display: inline-block;">` + label + `</a></p>`
+	}
+
+	resultsLink := ""
+	if card.ResultsURL != "" {
+		resultsLink = `<p>You can view your result online at any time: <a href="` + card.ResultsURL + `">` + card.ResultsURL + `</a></p>`
+	}
+
+	return strings.NewReplacer(
+		"{{name}}", name,
+		"{{score}}", strconv.Itoa(card.Score),
+		"{{max_score}}", strconv.Itoa(card.MaxScore),
+		"{{rank}}", strconv.Itoa(card.Rank),
+		"{{participants}}", strconv.Itoa(card.Participants),
+		"{{time_taken}}", FormatDuration(card.TimeTakenSeconds),
+		"{{sections}}", sections.String(),
+		"{{certificate}}", certificate,
+		"{{certificate_url}}", card.CertificateURL,
+		"{{certificate_type}}", card.CertificateType,
+		"{{results_link}}", resultsLink,
+		"{{results_url}}", card.ResultsURL,
+	).Replace(body)
+}
+
+// FormatDuration renders seconds as "12m 05s", as scorecards show times
+func FormatDuration(seconds int) string {
+	return fmt.Sprintf("%dm %02ds", seconds/60, seconds%60)
+}
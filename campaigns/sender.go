@@ -0,0 +1,349 @@
+package campaigns
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/db"
+	"mcq-exam/events"
+	"mcq-exam/i18n"
+	"mcq-exam/jobs"
+	"mcq-exam/personalize"
+	"mcq-exam/suppression"
+	"mcq-exam/tracking"
+	"mcq-exam/utils"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sendBatchSize is how many pending recipients are loaded at a time; the campaign's
+// status is re-checked between batches so a pause from another instance takes effect
+const sendBatchSize = 100
+
+var (
+	workersMu sync.Mutex
+	// workers holds the sender for each campaign sending in this process
+	workers = make(map[int]*worker)
+)
+
+type worker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type pendingRecipient struct {
+	ID        int
+	StudentID int
+	Name      string
+	Email     string
+	Locale    string
+	// InvalidReason is set when the address was found invalid after the campaign launched
+	InvalidReason string
+	// Variables are the recipient's custom variables (see SetVariables)
+	Variables map[string]string
+}
+
+// ResumeRunning restarts sending for campaigns left running by a previous process.
+// A recipient claimed when that process died is sent again.
+func ResumeRunning(ctx context.Context) error {
+	resetQuery := `
+		UPDATE email_campaign_recipients r
+		SET status = 'pending', updated_at = NOW()
+		FROM email_campaigns c
+		WHERE c.id = r.campaign_id AND c.status = 'running' AND r.status = 'sending'
+	`
+	if _, err := db.Pool.Exec(ctx, resetQuery); err != nil {
+		return err
+	}
+
+	rows, err := db.Pool.Query(ctx, `SELECT id FROM email_campaigns WHERE status = 'running' ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		log.Info().Int("campaign_id", id).Msg("Resuming email campaign")
+		start(id)
+	}
+	return nil
+}
+
+// start sends the campaign's pending recipients in the background unless this process already is
+func start(id int) {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+
+	// A cancelled sender (paused, not yet exited) is replaced rather than waited for
+	if existing, running := workers[id]; running && existing.ctx.Err() == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(jobs.Context())
+	w := &worker{ctx: ctx, cancel: cancel}
+	workers[id] = w
+
+	jobs.Go(func(context.Context) {
+		defer func() {
+			workersMu.Lock()
+			if workers[id] == w {
+				delete(workers, id)
+			}
+			workersMu.Unlock()
+			cancel()
+		}()
+		send(ctx, id)
+	})
+}
+
+// stop cancels the campaign's sender in this process, if any
+func stop(id int) {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+
+	if w, running := workers[id]; running {
+		w.cancel()
+	}
+}
+
+// send works through pending recipients until none are left, the campaign is no longer
+// running, or the server shuts down (the campaign stays running and resumes on restart)
+func send(ctx context.Context, id int) {
+	campaign, err := Get(ctx, id)
+	if err != nil {
+		log.Error().Err(err).Int("campaign_id", id).Msg("Failed to load campaign")
+		return
+	}
+	emailType := EmailType(id)
+
+	texts := []string{campaign.Subject, campaign.HTMLBody}
+	for _, variant := range campaign.Variants {
+		texts = append(texts, variant.Subject, variant.HTMLBody)
+	}
+	renderer, err := personalize.New(ctx, campaign.StrictVariables, texts...)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Error().Err(err).Int("campaign_id", id).Msg("Failed to prepare campaign variables")
+		}
+		return
+	}
+
+	for {
+		var status string
+		if err := db.Pool.QueryRow(ctx, `SELECT status FROM email_campaigns WHERE id = $1`, id).Scan(&status); err != nil {
+			if ctx.Err() == nil {
+				log.Error().Err(err).Int("campaign_id", id).Msg("Failed to check campaign status")
+			}
+			return
+		}
+		if status != StatusRunning {
+			log.Info().Int("campaign_id", id).Str("status", status).Msg("Campaign sending stopped")
+			return
+		}
+
+		batch, err := pendingBatch(ctx, id)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error().Err(err).Int("campaign_id", id).Msg("Failed to fetch pending recipients")
+			}
+			return
+		}
+		if len(batch) == 0 {
+			complete(id)
+			return
+		}
+
+		for _, recipient := range batch {
+			if ctx.Err() != nil {
+				return
+			}
+			sendOne(ctx, campaign, renderer, emailType, recipient)
+		}
+	}
+}
+
+func pendingBatch(ctx context.Context, id int) ([]pendingRecipient, error) {
+	query := `
+		SELECT r.id, r.student_id, s.name, r.email, COALESCE(s.locale, ''),
+		       CASE WHEN s.email = r.email AND NOT s.email_valid THEN COALESCE(s.email_invalid_reason, 'unknown') ELSE '' END,
+		       COALESCE(v.variables, '{}')
+		FROM email_campaign_recipients r
+		JOIN students s ON s.id = r.student_id
+		LEFT JOIN email_campaign_variables v ON v.campaign_id = r.campaign_id AND v.student_id = r.student_id
+		WHERE r.campaign_id = $1 AND r.status = 'pending'
+		ORDER BY r.id
+		LIMIT $2
+	`
+	rows, err := db.Pool.Query(ctx, query, id, sendBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []pendingRecipient
+	for rows.Next() {
+		var r pendingRecipient
+		if err := rows.Scan(&r.ID, &r.StudentID, &r.Name, &r.Email, &r.Locale, &r.InvalidReason, &r.Variables); err != nil {
+			return nil, err
+		}
+		batch = append(batch, r)
+	}
+	return batch, rows.Err()
+}
+
+// claim marks a pending recipient as being sent, so a sender replaced on pause/resume
+// cannot send to the same recipient twice. Reports false if someone else claimed it.
+func claim(ctx context.Context, id int) bool {
+	query := `UPDATE email_campaign_recipients SET status = 'sending', updated_at = NOW() WHERE id = $1 AND status = 'pending'`
+	tag, err := db.Pool.Exec(ctx, query, id)
+	return err == nil && tag.RowsAffected() > 0
+}
+
+// sendOne delivers the campaign to one recipient and records the outcome
+func sendOne(ctx context.Context, campaign *Campaign, renderer *personalize.Renderer, emailType string, recipient pendingRecipient) {
+	if !claim(ctx, recipient.ID) {
+		return
+	}
+
+	// Skip known-bad addresses
+	if suppression.IsSuppressed(ctx, recipient.Email) {
+		updateRecipient(recipient.ID, RecipientSkipped, nil, "on the suppression list")
+		return
+	}
+	if recipient.InvalidReason != "" {
+		updateRecipient(recipient.ID, RecipientSkipped, nil, "invalid email address: "+recipient.InvalidReason)
+		return
+	}
+
+	subject, htmlBody := i18n.Pick(campaign.Variants, recipient.Locale, campaign.Subject, campaign.HTMLBody)
+	body := htmlBody
+	var card *Scorecard
+	if campaign.Kind == KindResults {
+		var err error
+		card, err = LoadScorecard(ctx, recipient.StudentID)
+		if errors.Is(err, ErrNoResult) {
+			updateRecipient(recipient.ID, RecipientSkipped, nil, err.Error())
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error().Err(err).Int("campaign_id", campaign.ID).Int("student_id", recipient.StudentID).Msg("Failed to load scorecard")
+			}
+			updateRecipient(recipient.ID, RecipientFailed, nil, err.Error())
+			return
+		}
+		// The email still goes out without the link if the token cannot be created
+		if err := card.AttachResultsURL(ctx); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Int("campaign_id", campaign.ID).Int("student_id", recipient.StudentID).Msg("Failed to create result link")
+		}
+		body = RenderScorecard(htmlBody, recipient.Name, card)
+	}
+
+	// Scorecard placeholders are filled in first; the rest come from the student and the
+	// recipient's custom variables
+	rendered, err := renderer.Render(ctx, recipient.StudentID, recipient.Name, recipient.Variables, subject, body)
+	if err != nil {
+		if !errors.Is(err, personalize.ErrUnresolved) && ctx.Err() == nil {
+			log.Error().Err(err).Int("campaign_id", campaign.ID).Int("student_id", recipient.StudentID).Msg("Failed to personalize campaign email")
+		}
+		updateRecipient(recipient.ID, RecipientFailed, nil, err.Error())
+		return
+	}
+	subject, body = rendered[0], rendered[1]
+
+	params := utils.SendEmailParams{
+		ToEmail:  recipient.Email,
+		ToName:   recipient.Name,
+		Subject:  subject,
+		HTMLBody: body,
+	}
+	if campaign.Tracking {
+		params.Tracking = &tracking.Target{StudentID: recipient.StudentID, EmailType: tracking.EmailType(emailType)}
+	}
+	result, err := utils.SendEmail(ctx, params)
+	if err != nil {
+		log.Error().Err(err).Int("campaign_id", campaign.ID).Int("student_id", recipient.StudentID).Str("email", recipient.Email).
+			Msg("Failed to send campaign email")
+		updateRecipient(recipient.ID, RecipientFailed, nil, err.Error())
+		return
+	}
+
+	updateRecipient(recipient.ID, RecipientSent, &result.RequestID, "")
+	tracking.RecordSent(recipient.StudentID, tracking.EmailType(emailType))
+	if card != nil && card.CertificateURL != "" {
+		events.Publish(events.CertificateIssued, map[string]interface{}{
+			"student_id":       card.StudentID,
+			"session_id":       card.SessionID,
+			"rank":             card.Rank,
+			"certificate_type": card.CertificateType,
+			"certificate_url":  card.CertificateURL,
+			"campaign_id":      campaign.ID,
+		})
+	}
+
+	// email_logs lets the bounce webhook find the message by request ID
+	var providerResponse *string
+	if len(result.Raw) > 0 {
+		raw := string(result.Raw)
+		providerResponse = &raw
+	}
+	logCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	logQuery := `
+		INSERT INTO email_logs (student_id, email, subject, status, request_id, response_code, response_message, provider, provider_response, sent_at)
+		VALUES ($1, $2, $3, 'sent', $4, $5, $6, $7, $8, NOW())
+	`
+	if _, err := db.Pool.Exec(logCtx, logQuery, recipient.StudentID, recipient.Email, subject,
+		result.RequestID, result.Code, result.Message, result.Provider, providerResponse); err != nil {
+		log.Warn().Err(err).Int("campaign_id", campaign.ID).Int("student_id", recipient.StudentID).Msg("Failed to write email log")
+	}
+}
+
+// updateRecipient stores a recipient's outcome. It uses a fresh context so the result of
+// a send is saved even while shutting down.
+func updateRecipient(id int, status string, requestID *string, errMsg string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE email_campaign_recipients
+		SET status = $1,
+		    request_id = $2,
+		    error = NULLIF($3, ''),
+		    sent_at = CASE WHEN $1 = 'sent' THEN NOW() ELSE sent_at END,
+		    updated_at = NOW()
+		WHERE id = $4
+	`
+	if _, err := db.Pool.Exec(ctx, query, status, requestID, errMsg, id); err != nil {
+		log.Error().Err(err).Int("recipient_id", id).Str("status", status).Msg("Failed to update campaign recipient")
+	}
+}
+
+// complete marks a running campaign as finished
+func complete(id int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE email_campaigns
+		SET status = 'completed', completed_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = 'running'
+	`
+	if _, err := db.Pool.Exec(ctx, query, id); err != nil {
+		log.Error().Err(err).Int("campaign_id", id).Msg("Failed to complete campaign")
+		return
+	}
+	log.Info().Int("campaign_id", id).Msg("Email campaign completed")
+}
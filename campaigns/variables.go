@@ -0,0 +1,115 @@
+package campaigns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"mcq-exam/personalize"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// MaxVariableRecipients bounds the recipients of one SetVariables call
+const MaxVariableRecipients = 5000
+
+// RecipientVariables are the custom variables of one student in a campaign
+type RecipientVariables struct {
+	StudentID int               `json:"student_id"`
+	Variables map[string]string `json:"variables"`
+	UpdatedAt time.Time         `json:"updated_at,omitempty"`
+}
+
+// ErrUnknownStudent is returned by SetVariables for a student that does not exist
+var ErrUnknownStudent = errors.New("unknown student")
+
+// SetVariables stores custom variables for students of a campaign, replacing what they had;
+// an empty payload removes a student's variables. payloads are checked with
+// personalize.ValidatePayload. It returns how many students were set and removed.
+func SetVariables(ctx context.Context, campaignID int, payloads map[int]map[string]any) (set, removed int, err error) {
+	values := make(map[int]map[string]string, len(payloads))
+	for studentID, payload := range payloads {
+		validated, err := personalize.ValidatePayload(payload)
+		if err != nil {
+			return 0, 0, fmt.Errorf("student %d: %w", studentID, err)
+		}
+		values[studentID] = validated
+	}
+
+	err = db.WithTx(ctx, func(tx pgx.Tx) error {
+		var status string
+		err := tx.QueryRow(ctx, `SELECT status FROM email_campaigns WHERE id = $1 FOR UPDATE`, campaignID).Scan(&status)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch campaign: %w", err)
+		}
+		if status == StatusCompleted || status == StatusTracking {
+			return &StateError{Action: "set variables of", Status: status}
+		}
+
+		for studentID, variables := range values {
+			if len(variables) == 0 {
+				tag, err := tx.Exec(ctx, `DELETE FROM email_campaign_variables WHERE campaign_id = $1 AND student_id = $2`, campaignID, studentID)
+				if err != nil {
+					return fmt.Errorf("failed to remove variables: %w", err)
+				}
+				removed += int(tag.RowsAffected())
+				continue
+			}
+			query := `
+				INSERT INTO email_campaign_variables (campaign_id, student_id, variables)
+				SELECT $1, s.id, $3 FROM students s WHERE s.id = $2
+				ON CONFLICT (campaign_id, student_id)
+				DO UPDATE SET variables = EXCLUDED.variables, updated_at = NOW()
+			`
+			tag, err := tx.Exec(ctx, query, campaignID, studentID, variables)
+			if err != nil {
+				return fmt.Errorf("failed to save variables: %w", err)
+			}
+			if tag.RowsAffected() == 0 {
+				return fmt.Errorf("%w: %d", ErrUnknownStudent, studentID)
+			}
+			set++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return set, removed, nil
+}
+
+// Variables returns a page of a campaign's custom variables by student, with the total
+func Variables(ctx context.Context, campaignID, limit, offset int) ([]RecipientVariables, int, error) {
+	var total int
+	countQuery := `SELECT COUNT(*) FROM email_campaign_variables WHERE campaign_id = $1`
+	if err := db.Pool.QueryRow(ctx, countQuery, campaignID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count variables: %w", err)
+	}
+
+	query := `
+		SELECT student_id, variables, updated_at
+		FROM email_campaign_variables
+		WHERE campaign_id = $1
+		ORDER BY student_id
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := db.Pool.Query(ctx, query, campaignID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch variables: %w", err)
+	}
+	defer rows.Close()
+
+	list := []RecipientVariables{}
+	for rows.Next() {
+		var v RecipientVariables
+		if err := rows.Scan(&v.StudentID, &v.Variables, &v.UpdatedAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan variables: %w", err)
+		}
+		list = append(list, v)
+	}
+	return list, total, rows.Err()
+}
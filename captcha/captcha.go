@@ -0,0 +1,95 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// siteverifyURLs are the verification endpoints of the supported providers. All of them take
+// the same form fields and answer {"success": bool, "error-codes": [...]}.
+var siteverifyURLs = map[string]string{
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"recaptcha": "https://www.google.com/recaptcha/api/siteverify",
+}
+
+var (
+	// ErrMissing is returned when a captcha is required but no token was sent
+	ErrMissing = errors.New("captcha token is required")
+	// ErrRejected is returned when the provider does not accept the token
+	ErrRejected = errors.New("captcha verification failed")
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Enabled reports whether CAPTCHA_SECRET is set. Without it tokens are not checked, so
+// development setups work without a captcha account.
+func Enabled() bool {
+	return os.Getenv("CAPTCHA_SECRET") != ""
+}
+
+// Provider returns CAPTCHA_PROVIDER: turnstile (default), hcaptcha or recaptcha
+func Provider() string {
+	provider := strings.ToLower(strings.TrimSpace(os.Getenv("CAPTCHA_PROVIDER")))
+	if provider == "" {
+		return "turnstile"
+	}
+	return provider
+}
+
+// Verify checks a token from the client-side widget with the provider. It returns nil when
+// captchas are disabled, ErrMissing or ErrRejected for a bad token, and any other error when
+// the provider could not be asked.
+func Verify(ctx context.Context, token, remoteIP string) error {
+	secret := os.Getenv("CAPTCHA_SECRET")
+	if secret == "" {
+		return nil
+	}
+	if strings.TrimSpace(token) == "" {
+		return ErrMissing
+	}
+	endpoint, ok := siteverifyURLs[Provider()]
+	if !ok {
+		return fmt.Errorf("unknown captcha provider %q (expected turnstile, hcaptcha or recaptcha)", Provider())
+	}
+
+	form := url.Values{"secret": {secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create captcha request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to verify captcha: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("captcha verification failed with status %d: %s", resp.StatusCode, string(body))
+	}
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to parse captcha response: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("%w: %s", ErrRejected, strings.Join(result.ErrorCodes, ", "))
+	}
+	return nil
+}
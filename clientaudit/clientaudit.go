@@ -0,0 +1,97 @@
+package clientaudit
+
+import (
+	"context"
+	"fmt"
+	"mcq-exam/db"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/rs/zerolog/log"
+)
+
+// Actions recorded in client_events. Session starts are stored on the session row and answer
+// submissions in answer_events.
+const (
+	ActionConferenceToken = "conference_token"
+	ActionOTPVerified     = "otp_verified"
+)
+
+// Client identifies where a request came from
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+var (
+	geoOnce   sync.Once
+	geoReader *maxminddb.Reader
+)
+
+// geoDB opens the MaxMind database at GEOIP_DB_PATH once; nil when unset or unreadable
+func geoDB() *maxminddb.Reader {
+	geoOnce.Do(func() {
+		path := strings.TrimSpace(os.Getenv("GEOIP_DB_PATH"))
+		if path == "" {
+			return
+		}
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("Failed to open GeoIP database, countries will not be recorded")
+			return
+		}
+		geoReader = reader
+	})
+	return geoReader
+}
+
+// Country returns the ISO country code of ip from the GeoIP database (GeoLite2-Country or
+// -City), or "" when GeoIP is not configured or the address is unknown
+func Country(ip string) string {
+	reader := geoDB()
+	parsed := net.ParseIP(ip)
+	if reader == nil || parsed == nil {
+		return ""
+	}
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := reader.Lookup(parsed, &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+// Record stores a critical action with the client's IP, user agent and country. sessionID is
+// 0 for actions taken before a session exists.
+func Record(ctx context.Context, q db.Querier, action string, studentID, sessionID int, client Client) error {
+	query := `
+		INSERT INTO client_events (action, student_id, session_id, ip, user_agent, country)
+		VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''))
+	`
+	if _, err := q.Exec(ctx, query, action, studentID, sessionID, client.IP, client.UserAgent, Country(client.IP)); err != nil {
+		return fmt.Errorf("failed to record %s: %w", action, err)
+	}
+	return nil
+}
+
+// IPFlagThreshold is the number of distinct IPs (SESSION_IP_FLAG_THRESHOLD, default 3) at
+// which a session is flagged for review
+func IPFlagThreshold() int {
+	threshold := 3
+	if value := strings.TrimSpace(os.Getenv("SESSION_IP_FLAG_THRESHOLD")); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 2 {
+			log.Warn().Msgf("Invalid SESSION_IP_FLAG_THRESHOLD=%q, using %d", value, threshold)
+		} else {
+			threshold = parsed
+		}
+	}
+	return threshold
+}
@@ -0,0 +1,482 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultFrontendURL is where student-facing links point when FRONTEND_URL is unset
+const DefaultFrontendURL = "https://nicm.smart-mcq.com"
+
+// Config is the validated server configuration. Settings only read by one package are
+// validated here as well but still parsed where they are used.
+type Config struct {
+	DatabaseURL string
+	// DBMaxConns and DBMinConns size the Postgres pool (DB_MAX_CONNS, DB_MIN_CONNS)
+	DBMaxConns int32
+	DBMinConns int32
+	// DBStatementTimeout cancels any statement running longer (DB_STATEMENT_TIMEOUT, 0 = none)
+	DBStatementTimeout time.Duration
+	// DBSlowQueryThreshold logs statements taking at least this long (DB_SLOW_QUERY_THRESHOLD, 0 = off)
+	DBSlowQueryThreshold time.Duration
+	RedisURL             string
+	Port                 string
+	// FrontendURL is the base of every link sent to students, without a trailing slash
+	FrontendURL string
+	// BaseURL is this API's public URL, used for tracking links and webhooks; empty if unset
+	BaseURL        string
+	TrustedProxies []string
+	ProxyHeader    string
+	EmailProvider  string
+	// EmailFallbackProvider is empty when no fallback is configured
+	EmailFallbackProvider string
+}
+
+// setting is one environment variable the server reads
+type setting struct {
+	name string
+	// def is the value used when the variable is unset, shown in the config view
+	def    string
+	secret bool
+	// check validates a non-empty value; nil accepts anything
+	check func(value string) error
+}
+
+// settings lists every variable read at startup or on demand, so a typo in any of them
+// is caught before the server accepts traffic
+var settings = []setting{
+	{name: "DATABASE_URL", secret: true, check: checkDatabaseURL},
+	{name: "DB_MAX_CONNS", def: "25", check: checkInt(1)},
+	{name: "DB_MIN_CONNS", def: "5", check: checkInt(0)},
+	{name: "DB_STATEMENT_TIMEOUT", def: "30s", check: checkDuration(true)},
+	{name: "DB_SLOW_QUERY_THRESHOLD", def: "500ms", check: checkDuration(true)},
+	{name: "REDIS_URL", secret: true, check: checkRedisURL},
+	{name: "PORT", def: "8080", check: checkPort},
+	{name: "FRONTEND_URL", def: DefaultFrontendURL, check: checkURL("http", "https")},
+	{name: "BASE_URL", check: checkURL("http", "https")},
+	{name: "CERTIFICATE_URL", check: checkURL("http", "https")},
+	{name: "SCORECARD_BRAND", def: "SmartMCQ"},
+	{name: "SCORECARD_LOGO"},
+	{name: "SCORECARD_CACHE", def: "disk", check: checkOneOf("disk", "s3", "off")},
+	{name: "SCORECARD_CACHE_DIR", def: "scorecards"},
+	{name: "SCORECARD_S3_BUCKET"},
+	{name: "SCORECARD_S3_PREFIX", def: "scorecards/"},
+	{name: "TRUSTED_PROXIES"},
+	{name: "PROXY_HEADER", def: "X-Real-IP"},
+	{name: "SHUTDOWN_TIMEOUT", def: "30s", check: checkDuration(false)},
+	{name: "SHUTDOWN_DRAIN_DELAY", def: "0s", check: checkDuration(true)},
+	{name: "MIGRATE_ON_START", def: "true", check: checkBool},
+	{name: "INSTANCE_ID"},
+	{name: "LOG_LEVEL", def: "info", check: checkOneOf("trace", "debug", "info", "warn", "error", "fatal", "panic", "disabled")},
+	{name: "LOG_FORMAT", def: "json", check: checkOneOf("json", "console")},
+	{name: "SENTRY_DSN", secret: true, check: checkSentryDSN},
+	{name: "SENTRY_ENVIRONMENT", def: "production"},
+	{name: "SENTRY_SAMPLE_RATE", def: "1", check: checkFraction},
+
+	{name: "EMAIL_PROVIDER", def: "zeptomail", check: checkOneOf("zeptomail", "zepto", "smtp", "ses")},
+	{name: "EMAIL_FALLBACK_PROVIDER", check: checkOneOf("zeptomail", "zepto", "smtp", "ses")},
+	{name: "ZEPTO_API_KEY", secret: true},
+	{name: "ZEPTO_FROM_EMAIL"},
+	{name: "ZEPTO_FROM_NAME"},
+	{name: "ZEPTO_RATE_LIMIT", def: "10/1s", check: checkRate},
+	{name: "ZEPTO_RATE_BURST", def: "20", check: checkInt(1)},
+	{name: "ZEPTO_WEBHOOK_SECRET", secret: true},
+	{name: "SMTP_HOST"},
+	{name: "SMTP_PORT", def: "587", check: checkPort},
+	{name: "SMTP_USERNAME"},
+	{name: "SMTP_PASSWORD", secret: true},
+	{name: "SMTP_FROM_EMAIL"},
+	{name: "SMTP_FROM_NAME"},
+	{name: "SMTP_RATE_LIMIT", def: "5/1s", check: checkRate},
+	{name: "SMTP_RATE_BURST", def: "20", check: checkInt(1)},
+	{name: "SES_FROM_EMAIL"},
+	{name: "SES_FROM_NAME"},
+	{name: "SES_CONFIGURATION_SET"},
+	{name: "SES_RATE_LIMIT", def: "14/1s", check: checkRate},
+	{name: "SES_RATE_BURST", def: "20", check: checkInt(1)},
+	{name: "SOFT_BOUNCE_LIMIT", def: "3", check: checkInt(1)},
+	{name: "MAIL_SEND_CONCURRENCY", def: "8", check: checkInt(1)},
+	{name: "MAIL_HTML_MAX_BYTES", def: "524288", check: checkInt(1)},
+	{name: "EMAIL_MX_CHECK", def: "false", check: checkBool},
+	{name: "DEFAULT_LOCALE", def: "en", check: checkOneOf("en", "hi", "fr", "es")},
+
+	{name: "SMS_PROVIDER", check: checkOneOf("twilio", "msg91")},
+	{name: "WHATSAPP_PROVIDER", check: checkOneOf("meta")},
+	{name: "TWILIO_ACCOUNT_SID"},
+	{name: "TWILIO_AUTH_TOKEN", secret: true},
+	{name: "TWILIO_FROM"},
+	{name: "TWILIO_STATUS_CALLBACK_URL", check: checkURL("http", "https")},
+	{name: "MSG91_AUTH_KEY", secret: true},
+	{name: "MSG91_TEMPLATE_ACCESS_CODE"},
+	{name: "MSG91_TEMPLATE_START_REMINDER"},
+	{name: "WHATSAPP_TOKEN", secret: true},
+	{name: "WHATSAPP_PHONE_NUMBER_ID"},
+	{name: "WHATSAPP_APP_SECRET", secret: true},
+	{name: "WHATSAPP_VERIFY_TOKEN", secret: true},
+	{name: "WHATSAPP_TEMPLATE_ACCESS_CODE"},
+	{name: "WHATSAPP_TEMPLATE_START_REMINDER"},
+	{name: "WHATSAPP_TEMPLATE_LANGUAGE", def: "en"},
+
+	{name: "RATE_LIMIT_TRACKING_IP", def: "120/1m", check: checkRate},
+	{name: "RATE_LIMIT_TRACK_IP", def: "600/1m", check: checkRate},
+	{name: "RATE_LIMIT_VERIFY_TOKEN_IP", def: "60/1m", check: checkRate},
+	{name: "RATE_LIMIT_LIVE_IP", def: "600/1m", check: checkRate},
+	{name: "RATE_LIMIT_LIVE_TOKEN", def: "60/1m", check: checkRate},
+	{name: "RATE_LIMIT_AUTH_IP", def: "20/1m", check: checkRate},
+	{name: "RATE_LIMIT_RESULTS_LOOKUP_IP", def: "10/1m", check: checkRate},
+	{name: "RATE_LIMIT_REGISTER_IP", def: "20/1m", check: checkRate},
+	{name: "RATE_LIMIT_EMAIL_CHANGE_IP", def: "10/1m", check: checkRate},
+	{name: "API_KEYS_REQUIRED", def: "false", check: checkBool},
+	{name: "CORS_ALLOWED_ORIGINS", check: checkOrigins},
+	{name: "CORS_ENFORCE", def: "true", check: checkBool},
+
+	{name: "SESSION_CACHE", check: checkOneOf("redis", "memory", "off")},
+	{name: "SESSION_CACHE_TTL", def: "4h", check: checkDuration(false)},
+	{name: "SESSION_CACHE_SIZE", def: "10000", check: checkInt(1)},
+	{name: "MAX_ATTEMPTS", def: "1", check: checkInt(1)},
+	{name: "ATTEMPT_POLICY", def: "best", check: checkOneOf("best", "latest")},
+	{name: "AUTO_COMPLETED_POLICY", def: "include", check: checkOneOf("include", "exclude")},
+	{name: "ACCESS_CODE_TTL", def: "72h", check: checkDuration(true)},
+	{name: "OTP_MAX_FAILURES", def: "5", check: checkInt(1)},
+	{name: "OTP_IP_MAX_FAILURES", def: "200", check: checkInt(1)},
+	{name: "OTP_LOCKOUT_DURATION", def: "15m", check: checkDuration(false)},
+	{name: "OUTBOX_POLL_INTERVAL", def: "5s", check: checkDuration(false)},
+	{name: "OUTBOX_MAX_ATTEMPTS", def: "5", check: checkInt(1)},
+	{name: "REGISTRATION_TOKEN_TTL", def: "24h", check: checkDuration(false)},
+	{name: "EMAIL_CHANGE_TOKEN_TTL", def: "24h", check: checkDuration(false)},
+	{name: "CAPTCHA_PROVIDER", def: "turnstile", check: checkOneOf("turnstile", "hcaptcha", "recaptcha")},
+	{name: "CAPTCHA_SECRET", secret: true},
+	{name: "QUESTIONS_SHUFFLE", def: "true", check: checkBool},
+	{name: "QUESTIONS_PER_SECTION", def: "0", check: checkInt(0)},
+	{name: "QUESTIONS_SHUFFLE_OPTIONS", def: "false", check: checkBool},
+	{name: "QUESTION_TIMING", def: "server", check: checkOneOf("server", "client")},
+	{name: "QUESTION_MIN_SECONDS", def: "1", check: checkInt(0)},
+	{name: "SECTION_NAVIGATION", def: "locked", check: checkOneOf("locked", "free")},
+	{name: "ALLOW_ANSWER_CHANGE", def: "false", check: checkBool},
+	{name: "ANSWER_INGEST", def: "direct", check: checkOneOf("direct", "batch")},
+	{name: "ANSWER_BATCH_INTERVAL_MS", def: "50", check: checkInt(1)},
+	{name: "ANSWER_BATCH_SIZE", def: "500", check: checkInt(1)},
+	{name: "PROCTOR_FLAG_THRESHOLD", def: "3", check: checkInt(1)},
+	{name: "SESSION_IP_FLAG_THRESHOLD", def: "3", check: checkInt(2)},
+	{name: "GEOIP_DB_PATH"},
+	{name: "SESSION_IDLE_MINUTES", def: "10", check: checkInt(1)},
+	{name: "SESSION_AUTO_FINALIZE", def: "false", check: checkBool},
+	{name: "TEST_RUN_ENABLED", def: "false", check: checkBool},
+	{name: "MIGRATIONS_API_ENABLED", def: "false", check: checkBool},
+	{name: "BACKUP_IMPORT_ENABLED", def: "false", check: checkBool},
+	{name: "BACKUP_IMPORT_MAX_MB", def: "256", check: checkInt(1)},
+	{name: "SEED_ENABLED", def: "false", check: checkBool},
+}
+
+// providerKeys are the variables a provider cannot send without, by provider name
+var providerKeys = map[string][]string{
+	"zeptomail": {"ZEPTO_API_KEY", "ZEPTO_FROM_EMAIL"},
+	"zepto":     {"ZEPTO_API_KEY", "ZEPTO_FROM_EMAIL"},
+	"smtp":      {"SMTP_HOST", "SMTP_FROM_EMAIL"},
+	"ses":       {"SES_FROM_EMAIL"},
+	"twilio":    {"TWILIO_ACCOUNT_SID", "TWILIO_AUTH_TOKEN", "TWILIO_FROM"},
+	"msg91":     {"MSG91_AUTH_KEY"},
+	"meta":      {"WHATSAPP_TOKEN", "WHATSAPP_PHONE_NUMBER_ID"},
+}
+
+var (
+	mu      sync.RWMutex
+	current *Config
+)
+
+// Load reads and validates the configuration from the environment. Every problem is
+// reported at once, one per line, so a broken deployment can be fixed in a single pass.
+// On success the result is also what Get returns.
+func Load() (*Config, error) {
+	var problems []error
+	for _, s := range settings {
+		value := env(s.name)
+		if value == "" || s.check == nil {
+			continue
+		}
+		if err := s.check(value); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", s.name, err))
+		}
+	}
+
+	if env("DATABASE_URL") == "" {
+		problems = append(problems, errors.New("DATABASE_URL: required"))
+	}
+
+	cfg := build()
+	if cfg.DBMinConns > cfg.DBMaxConns {
+		problems = append(problems, fmt.Errorf("DB_MIN_CONNS: %d is more than DB_MAX_CONNS (%d)", cfg.DBMinConns, cfg.DBMaxConns))
+	}
+
+	// Selected providers must have their credentials, otherwise every send fails later
+	for _, selector := range []string{"EMAIL_PROVIDER", "EMAIL_FALLBACK_PROVIDER", "SMS_PROVIDER", "WHATSAPP_PROVIDER"} {
+		provider := strings.ToLower(valueOrDefault(selector))
+		for _, key := range providerKeys[provider] {
+			if env(key) == "" {
+				problems = append(problems, fmt.Errorf("%s: required when %s=%s", key, selector, provider))
+			}
+		}
+	}
+
+	if strings.EqualFold(valueOrDefault("SCORECARD_CACHE"), "s3") && env("SCORECARD_S3_BUCKET") == "" {
+		problems = append(problems, errors.New("SCORECARD_S3_BUCKET: required when SCORECARD_CACHE=s3"))
+	}
+
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+
+	mu.Lock()
+	current = cfg
+	mu.Unlock()
+	return cfg, nil
+}
+
+// Get returns the configuration loaded at startup. Before Load has succeeded, as in
+// tools that skip validation, it is built from the environment with defaults applied.
+func Get() *Config {
+	mu.RLock()
+	cfg := current
+	mu.RUnlock()
+	if cfg != nil {
+		return cfg
+	}
+	return build()
+}
+
+// FrontendURL is the base of student-facing links (FRONTEND_URL, default DefaultFrontendURL)
+func FrontendURL() string {
+	return Get().FrontendURL
+}
+
+// build reads the typed settings, falling back to the default for anything invalid
+func build() *Config {
+	cfg := &Config{
+		DatabaseURL:           env("DATABASE_URL"),
+		DBMaxConns:            int32(intOrDefault("DB_MAX_CONNS")),
+		DBMinConns:            int32(intOrDefault("DB_MIN_CONNS")),
+		DBStatementTimeout:    durationOrDefault("DB_STATEMENT_TIMEOUT"),
+		DBSlowQueryThreshold:  durationOrDefault("DB_SLOW_QUERY_THRESHOLD"),
+		RedisURL:              env("REDIS_URL"),
+		Port:                  valueOrDefault("PORT"),
+		FrontendURL:           strings.TrimRight(valueOrDefault("FRONTEND_URL"), "/"),
+		BaseURL:               strings.TrimRight(env("BASE_URL"), "/"),
+		ProxyHeader:           valueOrDefault("PROXY_HEADER"),
+		EmailProvider:         strings.ToLower(valueOrDefault("EMAIL_PROVIDER")),
+		EmailFallbackProvider: strings.ToLower(env("EMAIL_FALLBACK_PROVIDER")),
+	}
+	if proxies := env("TRUSTED_PROXIES"); proxies != "" {
+		for _, proxy := range strings.Split(proxies, ",") {
+			if proxy = strings.TrimSpace(proxy); proxy != "" {
+				cfg.TrustedProxies = append(cfg.TrustedProxies, proxy)
+			}
+		}
+	}
+	return cfg
+}
+
+// Entry is one setting in the redacted configuration view
+type Entry struct {
+	Name string `json:"name"`
+	// Value is the effective value; secrets are shown only as "[redacted]"
+	Value   string `json:"value"`
+	Set     bool   `json:"set"`
+	Default string `json:"default,omitempty"`
+	Secret  bool   `json:"secret,omitempty"`
+}
+
+// Redacted lists every known setting with its effective value, hiding secrets, sorted by name
+func Redacted() []Entry {
+	entries := make([]Entry, 0, len(settings))
+	for _, s := range settings {
+		value := env(s.name)
+		entry := Entry{Name: s.name, Set: value != "", Default: s.def, Secret: s.secret}
+		switch {
+		case value == "":
+			entry.Value = s.def
+		case s.secret:
+			entry.Value = "[redacted]"
+		default:
+			entry.Value = value
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+func env(name string) string {
+	return strings.TrimSpace(os.Getenv(name))
+}
+
+func lookup(name string) setting {
+	for _, s := range settings {
+		if s.name == name {
+			return s
+		}
+	}
+	return setting{name: name}
+}
+
+// valueOrDefault is the variable's value if set and valid, otherwise its default
+func valueOrDefault(name string) string {
+	s := lookup(name)
+	value := env(name)
+	if value == "" || (s.check != nil && s.check(value) != nil) {
+		return s.def
+	}
+	return value
+}
+
+func intOrDefault(name string) int {
+	n, _ := strconv.Atoi(valueOrDefault(name))
+	return n
+}
+
+func durationOrDefault(name string) time.Duration {
+	d, _ := time.ParseDuration(valueOrDefault(name))
+	return d
+}
+
+func checkDatabaseURL(value string) error {
+	if _, err := pgxpool.ParseConfig(value); err != nil {
+		return errors.New("not a valid Postgres connection string")
+	}
+	return nil
+}
+
+func checkRedisURL(value string) error {
+	if _, err := redis.ParseURL(value); err != nil {
+		return errors.New("not a valid Redis URL")
+	}
+	return nil
+}
+
+func checkInt(min int) func(string) error {
+	return func(value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", value)
+		}
+		if n < min {
+			return fmt.Errorf("%d is below the minimum of %d", n, min)
+		}
+		return nil
+	}
+}
+
+func checkPort(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%q is not a valid port", value)
+	}
+	return nil
+}
+
+func checkBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("%q is not true or false", value)
+	}
+	return nil
+}
+
+// checkDuration accepts Go durations such as "90s" or "4h"; zero only when allowZero is set
+func checkDuration(allowZero bool) func(string) error {
+	return func(value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration (e.g. 30s, 4h)", value)
+		}
+		if d < 0 || (d == 0 && !allowZero) {
+			return fmt.Errorf("%q must be positive", value)
+		}
+		return nil
+	}
+}
+
+func checkOneOf(options ...string) func(string) error {
+	return func(value string) error {
+		for _, option := range options {
+			if strings.EqualFold(value, option) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", value, strings.Join(options, ", "))
+	}
+}
+
+// checkOrigins accepts "*" or a comma-separated list of http(s) origins
+func checkOrigins(value string) error {
+	if value == "*" {
+		return nil
+	}
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimSpace(origin); origin == "" {
+			continue
+		}
+		if err := checkURL("http", "https")(strings.Replace(origin, "://*.", "://", 1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRate accepts "<max>/<window>" (e.g. "20/1m"), or "off"/"0" to disable the limit
+func checkRate(value string) error {
+	if value == "off" || value == "0" {
+		return nil
+	}
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) == 2 {
+		max, err := strconv.Atoi(parts[0])
+		window, werr := time.ParseDuration(parts[1])
+		if err == nil && werr == nil && max >= 0 && window > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not <max>/<window> (e.g. 20/1m) or off", value)
+}
+
+// checkFraction accepts a number from 0 to 1, e.g. a sample rate
+func checkFraction(value string) error {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || f > 1 {
+		return fmt.Errorf("%q is not a number from 0 to 1", value)
+	}
+	return nil
+}
+
+// checkSentryDSN accepts https://<key>@<host>/<project> without echoing the key
+func checkSentryDSN(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User.Username() == "" ||
+		strings.Trim(u.Path, "/") == "" {
+		return errors.New("not a DSN like https://<key>@<host>/<project>")
+	}
+	return nil
+}
+
+func checkURL(schemes ...string) func(string) error {
+	return func(value string) error {
+		u, err := url.Parse(value)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("%q is not an absolute URL", value)
+		}
+		for _, scheme := range schemes {
+			if u.Scheme == scheme {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q must use %s", value, strings.Join(schemes, " or "))
+	}
+}
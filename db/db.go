@@ -3,44 +3,49 @@ package db
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
+	"mcq-exam/config"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/joho/godotenv"
+	"github.com/rs/zerolog/log"
 )
 
 var Pool *pgxpool.Pool
 
-// InitDB initializes the database connection pool optimized for high traffic
+// InitDB initializes the database connection pool optimized for high traffic.
+// Pool sizes come from DB_MAX_CONNS and DB_MIN_CONNS (default 25 and 5).
 func InitDB() error {
-	// Load .env file
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using environment variables")
-	}
-
-	databaseURL := os.Getenv("DATABASE_URL")
+	settings := config.Get()
+	databaseURL := settings.DatabaseURL
 	if databaseURL == "" {
 		return fmt.Errorf("DATABASE_URL environment variable is not set")
 	}
 
 	// Parse and configure pool settings for 2k req/sec peak load
-	config, err := pgxpool.ParseConfig(databaseURL)
+	poolConfig, err := pgxpool.ParseConfig(databaseURL)
 	if err != nil {
 		return fmt.Errorf("unable to parse DATABASE_URL: %w", err)
 	}
 
 	// Connection pool settings optimized for 2 vCPU + MCQ exam load
-	config.MaxConns = 25                          // 2-3x vCPUs, handles 800 writes/sec peak
-	config.MinConns = 5                           // Keep warm connections ready
-	config.MaxConnLifetime = 5 * time.Minute      // Recycle connections
-	config.MaxConnIdleTime = 2 * time.Minute      // Close idle connections
-	config.HealthCheckPeriod = 1 * time.Minute    // Periodic health checks
-	config.ConnConfig.ConnectTimeout = 3 * time.Second
+	poolConfig.MaxConns = settings.DBMaxConns      // 2-3x vCPUs, handles 800 writes/sec peak
+	poolConfig.MinConns = settings.DBMinConns      // Keep warm connections ready
+	poolConfig.MaxConnLifetime = 5 * time.Minute   // Recycle connections
+	poolConfig.MaxConnIdleTime = 2 * time.Minute   // Close idle connections
+	poolConfig.HealthCheckPeriod = 1 * time.Minute // Periodic health checks
+	poolConfig.ConnConfig.ConnectTimeout = 3 * time.Second
+
+	// Every statement gets a server-side timeout, so a query started with a context that
+	// never expires still cannot hang a request on a stuck database
+	if settings.DBStatementTimeout > 0 {
+		poolConfig.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(settings.DBStatementTimeout.Milliseconds(), 10)
+	}
+	tracer.threshold = settings.DBSlowQueryThreshold
+	poolConfig.ConnConfig.Tracer = tracer
 
 	// Create pool
-	Pool, err = pgxpool.NewWithConfig(context.Background(), config)
+	Pool, err = pgxpool.NewWithConfig(context.Background(), poolConfig)
 	if err != nil {
 		return fmt.Errorf("unable to create connection pool: %w", err)
 	}
@@ -53,7 +58,9 @@ func InitDB() error {
 		return fmt.Errorf("unable to ping database: %w", err)
 	}
 
-	log.Printf("Database connection pool initialized (max: %d, min: %d)", config.MaxConns, config.MinConns)
+	log.Info().Int32("max_conns", poolConfig.MaxConns).Int32("min_conns", poolConfig.MinConns).
+		Dur("statement_timeout", settings.DBStatementTimeout).Dur("slow_query_threshold", settings.DBSlowQueryThreshold).
+		Msg("Database connection pool initialized")
 	return nil
 }
 
@@ -61,6 +68,6 @@ func InitDB() error {
 func Close() {
 	if Pool != nil {
 		Pool.Close()
-		log.Println("Database connection pool closed")
+		log.Info().Msg("Database connection pool closed")
 	}
 }
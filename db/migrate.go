@@ -1,14 +1,21 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/rs/zerolog/log"
 )
 
 // RunMigrations runs all pending database migrations
@@ -16,13 +23,36 @@ func RunMigrations(databaseURL string) error {
 	// Remove channel_binding parameter if present (not supported by migrate)
 	_ = strings.Replace(databaseURL, "&channel_binding=require", "", 1)
 
-	// Open database connection for migrations
-	db := stdlib.OpenDB(*Pool.Config().ConnConfig)
-	defer db.Close()
+	m, err := newMigrator()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	if err == migrate.ErrNoChange {
+		log.Info().Msg("No new migrations to run")
+	} else {
+		log.Info().Msg("Migrations completed successfully")
+	}
+
+	return nil
+}
+
+// newMigrator opens a migrate instance on its own connection; Close releases it
+func newMigrator() (*migrate.Migrate, error) {
+	// Migrations may rewrite large tables or build indexes, so DB_STATEMENT_TIMEOUT does not apply
+	connConfig := Pool.Config().ConnConfig
+	connConfig.RuntimeParams["statement_timeout"] = "0"
+	db := stdlib.OpenDB(*connConfig)
 
 	driver, err := postgres.WithInstance(db, &postgres.Config{})
 	if err != nil {
-		return fmt.Errorf("failed to create postgres driver: %w", err)
+		db.Close()
+		return nil, fmt.Errorf("failed to create postgres driver: %w", err)
 	}
 
 	m, err := migrate.NewWithDatabaseInstance(
@@ -31,18 +61,202 @@ func RunMigrations(databaseURL string) error {
 		driver,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create migration instance: %w", err)
+		driver.Close()
+		return nil, fmt.Errorf("failed to create migration instance: %w", err)
 	}
 
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		return fmt.Errorf("failed to run migrations: %w", err)
+	return m, nil
+}
+
+// ErrInitialSchema is returned when a rollback would undo the first migration
+var ErrInitialSchema = errors.New("the initial schema cannot be rolled back; use reset-db to start over")
+
+// MigrateUp applies the next steps pending migrations, or all of them when steps is 0
+func MigrateUp(steps int) error {
+	if steps < 0 {
+		return fmt.Errorf("steps must not be negative")
 	}
 
-	if err == migrate.ErrNoChange {
-		log.Println("No new migrations to run")
+	m, err := newMigrator()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if steps == 0 {
+		err = m.Up()
 	} else {
-		log.Println("Migrations completed successfully")
+		err = m.Steps(steps)
+	}
+	return migrationResult(err, "up")
+}
+
+// MigrateDown rolls back the last steps migrations, stopping short of the initial schema
+func MigrateDown(ctx context.Context, steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("steps must be at least 1")
+	}
+
+	state, err := MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	files, err := MigrationFiles()
+	if err != nil {
+		return err
+	}
+	applied := 0
+	for _, file := range files {
+		if file.Version <= state.Version {
+			applied++
+		}
+	}
+	if steps >= applied {
+		return fmt.Errorf("cannot roll back %d of %d applied migrations: %w", steps, applied, ErrInitialSchema)
+	}
+
+	m, err := newMigrator()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	return migrationResult(m.Steps(-steps), "down")
+}
+
+// ForceVersion records version as applied and clears the dirty flag without running any
+// migration. Used after fixing the schema by hand following a failed migration.
+func ForceVersion(version int) error {
+	if version < 0 {
+		return fmt.Errorf("version must not be negative")
 	}
 
+	m, err := newMigrator()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Force(version); err != nil {
+		return fmt.Errorf("failed to force migration version %d: %w", version, err)
+	}
+	log.Warn().Int("version", version).Msg("Migration version forced")
+	return nil
+}
+
+// migrationResult treats "nothing to do" as success and logs completed changes
+func migrationResult(err error, direction string) error {
+	if err == migrate.ErrNoChange {
+		log.Info().Str("direction", direction).Msg("No migrations to run")
+		return nil
+	}
+	// Asked for more steps than there were; the available ones were applied
+	var short migrate.ErrShortLimit
+	if errors.As(err, &short) {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to migrate %s: %w", direction, err)
+	}
+	log.Info().Str("direction", direction).Msg("Migrations completed successfully")
 	return nil
 }
+
+// MigrationState compares the applied schema version with the newest migration file
+type MigrationState struct {
+	Version uint `json:"version"`
+	Latest  uint `json:"latest"`
+	Dirty   bool `json:"dirty"`
+}
+
+// Current reports whether every migration has been applied cleanly
+func (s MigrationState) Current() bool {
+	return !s.Dirty && s.Version >= s.Latest
+}
+
+// MigrationStatus reads the applied version from schema_migrations and the newest
+// version from the migrations directory
+func MigrationStatus(ctx context.Context) (MigrationState, error) {
+	var state MigrationState
+
+	files, err := MigrationFiles()
+	if err != nil {
+		return state, err
+	}
+	if len(files) > 0 {
+		state.Latest = files[len(files)-1].Version
+	}
+
+	var version int64
+	err = Pool.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &state.Dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// No migration applied yet, or all of them rolled back
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	state.Version = uint(version)
+
+	return state, nil
+}
+
+// WaitForMigrations polls MigrationStatus every interval until the schema is current, for a
+// server that leaves migrating to a separate job. A dirty schema is waited out like a pending
+// one, since only an operator can repair it. Returns ctx's error if ctx ends first.
+func WaitForMigrations(ctx context.Context, interval time.Duration) error {
+	var last MigrationState
+	logged := false
+	for {
+		state, err := MigrationStatus(ctx)
+		switch {
+		case err != nil:
+			log.Warn().Err(err).Msg("Failed to read migration status")
+		case state.Current():
+			return nil
+		case !logged || state != last:
+			log.Info().Uint("version", state.Version).Uint("latest", state.Latest).Bool("dirty", state.Dirty).
+				Msg("Waiting for migrations")
+			last, logged = state, true
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// MigrationFile is one numbered migration in the migrations directory
+type MigrationFile struct {
+	Version uint   `json:"version"`
+	Name    string `json:"name"`
+}
+
+// MigrationFiles lists the migrations that have an up file, in version order
+func MigrationFiles() ([]MigrationFile, error) {
+	entries, err := os.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+
+	var files []MigrationFile
+	for _, entry := range entries {
+		name, found := strings.CutSuffix(entry.Name(), ".up.sql")
+		if !found {
+			continue
+		}
+		prefix, name, found := strings.Cut(name, "_")
+		if !found {
+			continue
+		}
+		if version, err := strconv.ParseUint(prefix, 10, 32); err == nil {
+			files = append(files, MigrationFile{Version: uint(version), Name: name})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Version < files[j].Version })
+	return files, nil
+}
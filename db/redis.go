@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// Redis is the optional shared Redis client. It is nil when REDIS_URL is not set,
+// in which case callers fall back to in-process implementations.
+var Redis *redis.Client
+
+// InitRedis connects to Redis if REDIS_URL is configured
+func InitRedis() error {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		log.Info().Msg("REDIS_URL not set, using in-memory stores")
+		return nil
+	}
+
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return fmt.Errorf("unable to parse REDIS_URL: %w", err)
+	}
+
+	opts.DialTimeout = 3 * time.Second
+	opts.ReadTimeout = 2 * time.Second
+	opts.WriteTimeout = 2 * time.Second
+
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return fmt.Errorf("unable to ping redis: %w", err)
+	}
+
+	Redis = client
+	log.Info().Str("addr", opts.Addr).Msg("Redis connection initialized")
+	return nil
+}
+
+// CloseRedis closes the Redis client if one was opened
+func CloseRedis() {
+	if Redis != nil {
+		Redis.Close()
+		log.Info().Msg("Redis connection closed")
+	}
+}
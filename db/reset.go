@@ -3,19 +3,62 @@ package db
 import (
 	"context"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/rs/zerolog/log"
 )
 
-// ResetDatabase drops all tables and re-runs migrations
+// ResetDatabase drops all tables and re-runs migrations. The exam archive and API key tables
+// are kept, so archived events outlive the reset and integrations keep their keys.
 func ResetDatabase() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	// Drop all tables (CASCADE will handle indexes and constraints)
 	dropQuery := `
+		DROP SCHEMA IF EXISTS loadtest CASCADE;
+		DROP TABLE IF EXISTS email_change_requests CASCADE;
+		DROP TABLE IF EXISTS email_outbox CASCADE;
+		DROP TABLE IF EXISTS otp_lockouts CASCADE;
+		DROP TABLE IF EXISTS otp_attempts CASCADE;
+		DROP TABLE IF EXISTS question_marks CASCADE;
+		DROP TABLE IF EXISTS email_campaign_variables CASCADE;
+		DROP TABLE IF EXISTS retention_runs CASCADE;
+		DROP TABLE IF EXISTS cors_origins CASCADE;
+		DROP TABLE IF EXISTS student_merges CASCADE;
+		DROP TABLE IF EXISTS ui_strings CASCADE;
+		DROP TABLE IF EXISTS email_templates CASCADE;
+		DROP TABLE IF EXISTS event_reminders CASCADE;
+		DROP TABLE IF EXISTS session_admin_actions CASCADE;
+		DROP TABLE IF EXISTS client_events CASCADE;
+		DROP TABLE IF EXISTS regrade_sessions CASCADE;
+		DROP TABLE IF EXISTS regrades CASCADE;
+		DROP TABLE IF EXISTS registrations CASCADE;
+		DROP TABLE IF EXISTS exam_pauses CASCADE;
+		DROP TABLE IF EXISTS result_tokens CASCADE;
+		DROP TABLE IF EXISTS job_executions CASCADE;
+		DROP TABLE IF EXISTS student_email_changes CASCADE;
+		DROP TABLE IF EXISTS answer_events CASCADE;
+		DROP FUNCTION IF EXISTS answer_events_immutable();
+		DROP TABLE IF EXISTS session_sections CASCADE;
+		DROP TABLE IF EXISTS question_serves CASCADE;
+		DROP TABLE IF EXISTS notification_logs CASCADE;
+		DROP TABLE IF EXISTS exam_windows CASCADE;
+		DROP TABLE IF EXISTS access_code_events CASCADE;
+		DROP TABLE IF EXISTS email_campaign_recipients CASCADE;
+		DROP TABLE IF EXISTS email_campaigns CASCADE;
+		DROP TABLE IF EXISTS exam_settings CASCADE;
+		DROP TABLE IF EXISTS proctor_events CASCADE;
+		DROP TABLE IF EXISTS suppressed_emails CASCADE;
+		DROP TABLE IF EXISTS email_bounces CASCADE;
+		DROP TABLE IF EXISTS email_events CASCADE;
+		DROP TABLE IF EXISTS email_links CASCADE;
+		DROP TABLE IF EXISTS job_runs CASCADE;
+		DROP TABLE IF EXISTS scheduled_jobs CASCADE;
+		DROP TABLE IF EXISTS webhook_deliveries CASCADE;
+		DROP TABLE IF EXISTS webhook_subscriptions CASCADE;
+		DROP TABLE IF EXISTS session_section_scores CASCADE;
 		DROP TABLE IF EXISTS answers CASCADE;
 		DROP TABLE IF EXISTS sessions CASCADE;
 		DROP TABLE IF EXISTS email_tracking CASCADE;
@@ -29,7 +72,7 @@ func ResetDatabase() error {
 		return fmt.Errorf("failed to drop tables: %w", err)
 	}
 
-	log.Println("All tables dropped successfully")
+	log.Info().Msg("All tables dropped successfully")
 
 	// Re-run migrations
 	if err := RunMigrations(""); err != nil {
@@ -39,6 +82,6 @@ func ResetDatabase() error {
 		}
 	}
 
-	log.Println("Database reset completed successfully")
+	log.Info().Msg("Database reset completed successfully")
 	return nil
 }
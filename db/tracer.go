@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+)
+
+// queryCanceled is the SQLSTATE of a statement stopped by statement_timeout or pg_cancel_backend
+const queryCanceled = "57014"
+
+// maxLoggedSQL bounds the statement text in a slow-query log line
+const maxLoggedSQL = 2000
+
+// Statement kinds counted by SlowQueryCounts and TimeoutCounts
+var statementKinds = []string{"select", "insert", "update", "delete", "other"}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// queryTracer logs statements slower than threshold and counts them, and counts statements
+// canceled by statement_timeout. Parameters are never logged, only their number, since
+// they carry emails, tokens and answers.
+type queryTracer struct {
+	threshold time.Duration
+
+	mu       sync.Mutex
+	slow     map[string]uint64
+	timeouts map[string]uint64
+}
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	at   time.Time
+	sql  string
+	args int
+}
+
+var tracer = &queryTracer{slow: make(map[string]uint64), timeouts: make(map[string]uint64)}
+
+func (t *queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, queryStart{at: time.Now(), sql: data.SQL, args: len(data.Args)})
+}
+
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(start.at)
+	kind := statementKind(start.sql)
+
+	var pgErr *pgconn.PgError
+	if errors.As(data.Err, &pgErr) && pgErr.Code == queryCanceled {
+		t.count(t.timeouts, kind)
+		log.Warn().Str("statement", kind).Dur("elapsed", elapsed).Int("params", start.args).
+			Str("sql", compactSQL(start.sql)).Msg("Database statement canceled")
+		return
+	}
+	if t.threshold <= 0 || elapsed < t.threshold {
+		return
+	}
+	t.count(t.slow, kind)
+	event := log.Warn().Str("statement", kind).Dur("elapsed", elapsed).Dur("threshold", t.threshold).
+		Int("params", start.args).Str("sql", compactSQL(start.sql))
+	if data.Err != nil {
+		event = event.AnErr("query_error", data.Err)
+	} else {
+		event = event.Int64("rows", data.CommandTag.RowsAffected())
+	}
+	event.Msg("Slow database query")
+}
+
+func (t *queryTracer) count(counts map[string]uint64, kind string) {
+	t.mu.Lock()
+	counts[kind]++
+	t.mu.Unlock()
+}
+
+func (t *queryTracer) snapshot(counts map[string]uint64) map[string]uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]uint64, len(statementKinds))
+	for _, kind := range statementKinds {
+		out[kind] = counts[kind]
+	}
+	return out
+}
+
+// statementKind is the statement's verb, looking past a leading WITH to the statement it feeds
+func statementKind(sql string) string {
+	fields := strings.Fields(strings.ToLower(sql))
+	if len(fields) == 0 {
+		return "other"
+	}
+	verb := fields[0]
+	if verb == "with" {
+		// Labelled by the first INSERT, UPDATE or DELETE in it, as the write is what matters
+		for _, field := range fields[1:] {
+			switch strings.TrimLeft(field, "(") {
+			case "insert", "update", "delete":
+				return strings.TrimLeft(field, "(")
+			}
+		}
+		return "select"
+	}
+	switch verb {
+	case "select", "insert", "update", "delete":
+		return verb
+	}
+	return "other"
+}
+
+// compactSQL collapses whitespace so a statement logs on one line, and truncates it
+func compactSQL(sql string) string {
+	sql = strings.TrimSpace(whitespace.ReplaceAllString(sql, " "))
+	if len(sql) > maxLoggedSQL {
+		sql = sql[:maxLoggedSQL] + "..."
+	}
+	return sql
+}
+
+// SlowQueryCounts returns how many statements per kind took at least DB_SLOW_QUERY_THRESHOLD
+// since startup
+func SlowQueryCounts() map[string]uint64 {
+	return tracer.snapshot(tracer.slow)
+}
+
+// TimeoutCounts returns how many statements per kind the server canceled, almost always for
+// running past DB_STATEMENT_TIMEOUT, since startup
+func TimeoutCounts() map[string]uint64 {
+	return tracer.snapshot(tracer.timeouts)
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Querier runs statements. *pgxpool.Pool and pgx.Tx both satisfy it, so data access written
+// against it works inside or outside a transaction, and tests can pass a fake.
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Beginner starts transactions; satisfied by *pgxpool.Pool and pgx.Tx (as a savepoint)
+type Beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// WithTx runs fn in a transaction on Pool. The transaction commits if fn returns nil and rolls
+// back otherwise; fn's error is returned unchanged so callers can match sentinel errors.
+func WithTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	return WithTxIn(ctx, Pool, fn)
+}
+
+// WithTxIn is WithTx against another pool, such as the load test sandbox
+func WithTxIn(ctx context.Context, b Beginner, fn func(tx pgx.Tx) error) error {
+	tx, err := b.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Package dbtest points db.Pool at a scratch PostgreSQL database for tests. The database is
+// named by TEST_DATABASE_URL; tests calling Open are skipped without it. Each caller gets its
+// own schema, dropped and migrated afresh, so test packages run in parallel do not share rows.
+package dbtest
+
+import (
+	"context"
+	"mcq-exam/db"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Open migrates a fresh schema and sets db.Pool to a pool using it until the test ends. The
+// test runs from the repository root, where migrations and the question bank are read.
+func Open(t *testing.T, schema string) {
+	t.Helper()
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	_, file, _, _ := runtime.Caller(0)
+	t.Chdir(filepath.Dir(filepath.Dir(file)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		t.Fatalf("connect to test database: %v", err)
+	}
+	name := pgx.Identifier{schema}.Sanitize()
+	_, err = conn.Exec(ctx, `DROP SCHEMA IF EXISTS `+name+` CASCADE; CREATE SCHEMA `+name)
+	conn.Close(ctx)
+	if err != nil {
+		t.Fatalf("create test schema: %v", err)
+	}
+
+	poolConfig, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		t.Fatalf("parse TEST_DATABASE_URL: %v", err)
+	}
+	poolConfig.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		t.Fatalf("open test pool: %v", err)
+	}
+	previous := db.Pool
+	db.Pool = pool
+	t.Cleanup(func() {
+		db.Pool = previous
+		pool.Close()
+	})
+
+	if err := db.RunMigrations(""); err != nil {
+		t.Fatalf("migrate test schema: %v", err)
+	}
+}
+
+// Exec runs a seeding statement, failing the test on error
+func Exec(t *testing.T, query string, args ...any) {
+	t.Helper()
+	if _, err := db.Pool.Exec(context.Background(), query, args...); err != nil {
+		t.Fatalf("seed: %v\n%s", err, query)
+	}
+}
+
+// QueryInt runs a query returning one integer, failing the test on error
+func QueryInt(t *testing.T, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := db.Pool.QueryRow(context.Background(), query, args...).Scan(&n); err != nil {
+		t.Fatalf("query: %v\n%s", err, query)
+	}
+	return n
+}
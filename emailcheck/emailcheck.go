@@ -0,0 +1,358 @@
+// Package emailcheck validates student email addresses before mail is sent to them. Every
+// address gets an RFC 5322 syntax check, with the domain held to hostname rules; with
+// EMAIL_MX_CHECK on, the domain must also publish a mail server. The result is stored on the
+// student (email_valid, email_invalid_reason), and campaigns and send-alls skip invalid
+// addresses rather than spend send quota on them.
+package emailcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"net"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Reasons an address is invalid, stored in students.email_invalid_reason
+const (
+	// ReasonSyntax is an address that is not a plain RFC 5322 addr-spec with a hostname domain
+	ReasonSyntax = "invalid_syntax"
+	// ReasonNoMailServer is a domain with no MX record and no address to fall back to, or a
+	// null MX (RFC 7505)
+	ReasonNoMailServer = "no_mail_server"
+)
+
+const (
+	// lookupTimeout bounds the DNS lookups for one domain
+	lookupTimeout = 3 * time.Second
+	// domainCacheTTL is how long a domain's lookup result is reused
+	domainCacheTTL = time.Hour
+	// maxCachedDomains bounds the cache; it is cleared when full
+	maxCachedDomains = 10000
+	// lookupWorkers is how many domains CheckDomains looks up at once
+	lookupWorkers = 10
+)
+
+// ErrInvalid is returned for an address that fails validation; errors.Is matches an *Error
+var ErrInvalid = errors.New("invalid email address")
+
+// Error is an address that failed validation
+type Error struct {
+	Email  string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", Message(e.Reason), e.Email)
+}
+
+func (e *Error) Is(target error) bool { return target == ErrInvalid }
+
+// Message describes a reason for people reading an error or a report
+func Message(reason string) string {
+	switch reason {
+	case ReasonSyntax:
+		return "email address is not valid"
+	case ReasonNoMailServer:
+		return "email domain cannot receive email"
+	}
+	return "email address is not valid"
+}
+
+var (
+	mxOnce    sync.Once
+	mxEnabled bool
+
+	cacheMu sync.Mutex
+	cache   = make(map[string]cachedDomain)
+)
+
+type cachedDomain struct {
+	reason  string
+	checked time.Time
+}
+
+// MXEnabled reports whether domains are looked up (EMAIL_MX_CHECK, default false)
+func MXEnabled() bool {
+	mxOnce.Do(func() {
+		if value := strings.TrimSpace(os.Getenv("EMAIL_MX_CHECK")); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				log.Warn().Msgf("Invalid EMAIL_MX_CHECK=%q, using false", value)
+				return
+			}
+			mxEnabled = parsed
+		}
+	})
+	return mxEnabled
+}
+
+// Syntax returns ReasonSyntax when email is not a bare addr-spec (no display name, no quoted
+// local part) of at most 254 characters whose domain is a hostname with at least two labels,
+// or "" when it is
+func Syntax(email string) string {
+	if email == "" || len(email) > 254 {
+		return ReasonSyntax
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return ReasonSyntax
+	}
+	at := strings.LastIndexByte(email, '@')
+	local, domain := email[:at], email[at+1:]
+	if local == "" || len(local) > 64 || strings.HasPrefix(local, `"`) || !validDomain(domain) {
+		return ReasonSyntax
+	}
+	return ""
+}
+
+// validDomain reports whether domain is an ASCII hostname (IDNs in punycode) with a
+// top-level label that is not numeric
+func validDomain(domain string) bool {
+	if len(domain) > 253 {
+		return false
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	tld := labels[len(labels)-1]
+	_, err := strconv.Atoi(tld)
+	return err != nil
+}
+
+// Domain returns ReasonNoMailServer when domain cannot receive email, or "" when it can or
+// the lookup did not get an answer; a failed lookup is never held against an address.
+// Answers are cached for domainCacheTTL.
+func Domain(ctx context.Context, domain string) string {
+	domain = strings.ToLower(domain)
+	cacheMu.Lock()
+	entry, ok := cache[domain]
+	cacheMu.Unlock()
+	if ok && time.Since(entry.checked) < domainCacheTTL {
+		return entry.reason
+	}
+
+	reason, answered := lookup(ctx, domain)
+	if !answered {
+		return ""
+	}
+	cacheMu.Lock()
+	if len(cache) >= maxCachedDomains {
+		cache = make(map[string]cachedDomain)
+	}
+	cache[domain] = cachedDomain{reason: reason, checked: time.Now()}
+	cacheMu.Unlock()
+	return reason
+}
+
+// lookup checks for MX records, falling back to the implicit MX of an A/AAAA record
+// (RFC 5321 5.1). answered is false when DNS gave no definite answer.
+func lookup(ctx context.Context, domain string) (reason string, answered bool) {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	records, err := net.DefaultResolver.LookupMX(ctx, domain)
+	if err == nil && len(records) > 0 {
+		if len(records) == 1 && (records[0].Host == "." || records[0].Host == "") {
+			return ReasonNoMailServer, true
+		}
+		return "", true
+	}
+	if err != nil && !notFound(err) {
+		log.Debug().Err(err).Str("domain", domain).Msg("MX lookup failed")
+		return "", false
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, domain)
+	if err == nil && len(addrs) > 0 {
+		return "", true
+	}
+	if err != nil && !notFound(err) {
+		log.Debug().Err(err).Str("domain", domain).Msg("Address lookup failed")
+		return "", false
+	}
+	return ReasonNoMailServer, true
+}
+
+// notFound reports whether err is DNS saying the name or record does not exist
+func notFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// DomainOf returns the lowercased domain of an address that passed Syntax
+func DomainOf(email string) string {
+	return strings.ToLower(email[strings.LastIndexByte(email, '@')+1:])
+}
+
+// Check validates email, looking its domain up when mx is true. It returns the reason it is
+// invalid, or "" when it is valid.
+func Check(ctx context.Context, email string, mx bool) string {
+	if reason := Syntax(email); reason != "" {
+		return reason
+	}
+	if !mx {
+		return ""
+	}
+	return Domain(ctx, DomainOf(email))
+}
+
+// Validate checks email as configured and returns an *Error when it is invalid
+func Validate(ctx context.Context, email string) error {
+	if reason := Check(ctx, email, MXEnabled()); reason != "" {
+		return &Error{Email: email, Reason: reason}
+	}
+	return nil
+}
+
+// Invalid is a student whose address failed revalidation
+type Invalid struct {
+	StudentID int    `json:"student_id"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Reason    string `json:"reason"`
+}
+
+// Report is the outcome of Revalidate
+type Report struct {
+	Checked   int  `json:"checked"`
+	Valid     int  `json:"valid"`
+	Invalid   int  `json:"invalid"`
+	MXChecked bool `json:"mx_checked"`
+	Domains   int  `json:"domains"`
+	// NewlyInvalid and NewlyValid count students whose flag changed
+	NewlyInvalid int       `json:"newly_invalid"`
+	NewlyValid   int       `json:"newly_valid"`
+	Students     []Invalid `json:"invalid_students"`
+}
+
+type revalidated struct {
+	id    int
+	name  string
+	email string
+	valid bool
+}
+
+// Revalidate checks every non-sandbox student's address, looking domains up when mx is true,
+// and stores the result. A student whose email changes while this runs keeps the flag set
+// by that change. Anonymized students keep their placeholder address flagged invalid.
+func Revalidate(ctx context.Context, mx bool) (*Report, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT id, name, email, email_valid FROM students WHERE is_sandbox = false AND anonymized_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch students: %w", err)
+	}
+	var students []revalidated
+	domains := make(map[string]string)
+	for rows.Next() {
+		var s revalidated
+		if err := rows.Scan(&s.id, &s.name, &s.email, &s.valid); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan student: %w", err)
+		}
+		students = append(students, s)
+		if Syntax(s.email) == "" {
+			domains[DomainOf(s.email)] = ""
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch students: %w", err)
+	}
+
+	report := &Report{MXChecked: mx, Domains: len(domains), Students: []Invalid{}}
+	if mx {
+		CheckDomains(ctx, domains)
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("domain lookups did not finish: %w", err)
+		}
+	}
+
+	ids := make([]int, len(students))
+	emails := make([]string, len(students))
+	reasons := make([]string, len(students))
+	for i, s := range students {
+		reason := Syntax(s.email)
+		if reason == "" && mx {
+			reason = domains[DomainOf(s.email)]
+		}
+		ids[i], emails[i], reasons[i] = s.id, s.email, reason
+
+		report.Checked++
+		if reason == "" {
+			report.Valid++
+			if !s.valid {
+				report.NewlyValid++
+			}
+			continue
+		}
+		report.Invalid++
+		if s.valid {
+			report.NewlyInvalid++
+		}
+		report.Students = append(report.Students, Invalid{StudentID: s.id, Name: s.name, Email: s.email, Reason: reason})
+	}
+
+	query := `
+		UPDATE students s
+		SET email_valid = (u.reason = ''), email_invalid_reason = NULLIF(u.reason, ''), email_checked_at = NOW()
+		FROM unnest($1::int[], $2::text[], $3::text[]) AS u(id, email, reason)
+		WHERE s.id = u.id AND s.email = u.email
+	`
+	if _, err := db.Pool.Exec(ctx, query, ids, emails, reasons); err != nil {
+		return nil, fmt.Errorf("failed to store results: %w", err)
+	}
+	return report, nil
+}
+
+// CheckDomains fills in each domain's reason (see Domain), lookupWorkers at a time
+func CheckDomains(ctx context.Context, domains map[string]string) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan string)
+	for i := 0; i < lookupWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for domain := range queue {
+				reason := Domain(ctx, domain)
+				mu.Lock()
+				domains[domain] = reason
+				mu.Unlock()
+			}
+		}()
+	}
+	names := make([]string, 0, len(domains))
+	for domain := range domains {
+		names = append(names, domain)
+	}
+feed:
+	for _, domain := range names {
+		select {
+		case queue <- domain:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
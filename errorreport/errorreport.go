@@ -0,0 +1,126 @@
+// Package errorreport collects panics and server errors with their request context. Every
+// report is grouped in memory on the instance that saw it (see Groups); reporters registered
+// with Register, such as Sentry when SENTRY_DSN is set, receive a sampled share as well.
+package errorreport
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"math/rand/v2"
+	"mcq-exam/metrics"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Kinds of report
+const (
+	KindPanic = "panic"
+	KindError = "error"
+)
+
+// Event is one panic or server error
+type Event struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	// Stack is the goroutine stack of a panic
+	Stack  string `json:"stack,omitempty"`
+	Method string `json:"method,omitempty"`
+	// Route is the matched route pattern, e.g. /api/students/:id; Path is the request path
+	Route     string    `json:"route,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Status    int       `json:"status,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Fingerprint groups events with the same cause: kind, route, status and message
+func (e Event) Fingerprint() string {
+	sum := sha1.Sum([]byte(e.Kind + "\n" + e.Method + " " + e.Route + "\n" + strconv.Itoa(e.Status) + "\n" + e.Message))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Reporter sends events to an external error tracker. Report must not block; a reporter
+// that sends over the network queues the event.
+type Reporter interface {
+	Name() string
+	Report(event Event)
+}
+
+var (
+	mu         sync.RWMutex
+	reporters  []Reporter
+	sampleRate = 1.0
+)
+
+// Init reads SENTRY_SAMPLE_RATE (share of server errors sent to reporters, 0-1, default 1;
+// panics are always sent) and registers the Sentry reporter when SENTRY_DSN is set
+func Init() {
+	rate := 1.0
+	if value := os.Getenv("SENTRY_SAMPLE_RATE"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			log.Warn().Msgf("Invalid SENTRY_SAMPLE_RATE=%q, using %g", value, rate)
+		} else {
+			rate = parsed
+		}
+	}
+	mu.Lock()
+	sampleRate = rate
+	mu.Unlock()
+
+	if dsn := os.Getenv("SENTRY_DSN"); dsn != "" {
+		reporter, err := newSentry(dsn, os.Getenv("SENTRY_ENVIRONMENT"))
+		if err != nil {
+			log.Warn().Err(err).Msg("Invalid SENTRY_DSN, errors are only grouped locally")
+			return
+		}
+		Register(reporter)
+		log.Info().Float64("sample_rate", rate).Msg("Reporting errors to Sentry")
+	}
+}
+
+// Register adds a reporter; every later report is passed to it
+func Register(r Reporter) {
+	mu.Lock()
+	defer mu.Unlock()
+	reporters = append(reporters, r)
+}
+
+// Reporters lists the registered reporters' names
+func Reporters() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(reporters))
+	for _, r := range reporters {
+		names = append(names, r.Name())
+	}
+	return names
+}
+
+// Report records an event in its local group and passes it to the reporters, server errors
+// subject to the sample rate
+func Report(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	metrics.ErrorsReportedTotal.WithLabelValues(event.Kind).Inc()
+	local.add(event)
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if len(reporters) == 0 {
+		return
+	}
+	if event.Kind != KindPanic && rand.Float64() >= sampleRate {
+		return
+	}
+	for _, r := range reporters {
+		r.Report(event)
+	}
+}
@@ -0,0 +1,107 @@
+package errorreport
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxGroups bounds the local groups; the group seen least recently makes room for a new one
+const maxGroups = 200
+
+// Group is the events sharing a fingerprint, with the latest one in full
+type Group struct {
+	Fingerprint string    `json:"fingerprint"`
+	Kind        string    `json:"kind"`
+	Message     string    `json:"message"`
+	Method      string    `json:"method,omitempty"`
+	Route       string    `json:"route,omitempty"`
+	Status      int       `json:"status,omitempty"`
+	Count       int64     `json:"count"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	LastEvent   Event     `json:"last_event"`
+}
+
+type groups struct {
+	mu     sync.Mutex
+	byKey  map[string]*Group
+	reset  time.Time
+	evicts int64
+}
+
+var local = &groups{byKey: make(map[string]*Group), reset: time.Now()}
+
+func (g *groups) add(event Event) {
+	key := event.Fingerprint()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if group, ok := g.byKey[key]; ok {
+		group.Count++
+		group.LastSeen = event.Time
+		group.LastEvent = event
+		return
+	}
+	if len(g.byKey) >= maxGroups {
+		var oldest *Group
+		for _, group := range g.byKey {
+			if oldest == nil || group.LastSeen.Before(oldest.LastSeen) {
+				oldest = group
+			}
+		}
+		delete(g.byKey, oldest.Fingerprint)
+		g.evicts++
+	}
+	g.byKey[key] = &Group{
+		Fingerprint: key,
+		Kind:        event.Kind,
+		Message:     event.Message,
+		Method:      event.Method,
+		Route:       event.Route,
+		Status:      event.Status,
+		Count:       1,
+		FirstSeen:   event.Time,
+		LastSeen:    event.Time,
+		LastEvent:   event,
+	}
+}
+
+// Summary is the local groups of this instance
+type Summary struct {
+	// Since is when the instance started or the groups were last cleared
+	Since time.Time `json:"since"`
+	// Evicted counts groups dropped to stay within the limit
+	Evicted int64   `json:"evicted"`
+	Total   int     `json:"total"`
+	Groups  []Group `json:"groups"`
+}
+
+// Groups returns up to limit groups of the given kind ("" for all), most recently seen first
+func Groups(kind string, limit int) Summary {
+	local.mu.Lock()
+	defer local.mu.Unlock()
+
+	list := make([]Group, 0, len(local.byKey))
+	for _, group := range local.byKey {
+		if kind == "" || group.Kind == kind {
+			list = append(list, *group)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeen.After(list[j].LastSeen) })
+	summary := Summary{Since: local.reset, Evicted: local.evicts, Total: len(list)}
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	summary.Groups = list
+	return summary
+}
+
+// Clear drops the local groups, e.g. once a fix is deployed
+func Clear() {
+	local.mu.Lock()
+	defer local.mu.Unlock()
+	local.byKey = make(map[string]*Group)
+	local.reset = time.Now()
+	local.evicts = 0
+}
@@ -0,0 +1,139 @@
+package errorreport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mcq-exam/instance"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sentryQueue bounds the events waiting to be sent; more are dropped while Sentry is slow
+const sentryQueue = 100
+
+// sentry sends events to Sentry's store endpoint, one at a time from a queue
+type sentry struct {
+	endpoint    string
+	auth        string
+	environment string
+	client      *http.Client
+	queue       chan Event
+}
+
+// newSentry parses a DSN such as https://<key>@o123.ingest.sentry.io/456 and starts the sender
+func newSentry(dsn, environment string) (*sentry, error) {
+	// The DSN holds the key, so errors do not repeat it
+	invalid := errors.New("DSN must look like https://<key>@<host>/<project>")
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, invalid
+	}
+	key := u.User.Username()
+	path := strings.TrimSuffix(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || key == "" || slash < 0 || path[slash+1:] == "" {
+		return nil, invalid
+	}
+	if environment == "" {
+		environment = "production"
+	}
+
+	s := &sentry{
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path[:slash], path[slash+1:]),
+		auth:        fmt.Sprintf("Sentry sentry_version=7, sentry_client=mcq-exam/1.0, sentry_key=%s", key),
+		environment: environment,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan Event, sentryQueue),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *sentry) Name() string { return "sentry" }
+
+func (s *sentry) Report(event Event) {
+	select {
+	case s.queue <- event:
+	default:
+		log.Warn().Str("fingerprint", event.Fingerprint()).Msg("Sentry queue full, dropping error report")
+	}
+}
+
+func (s *sentry) run() {
+	for event := range s.queue {
+		if err := s.send(event); err != nil {
+			log.Warn().Err(err).Str("fingerprint", event.Fingerprint()).Msg("Failed to send error report to Sentry")
+		}
+	}
+}
+
+func (s *sentry) send(event Event) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	level := "error"
+	if event.Kind == KindPanic {
+		level = "fatal"
+	}
+	tags := map[string]string{"kind": event.Kind, "instance": instance.ID()}
+	if event.Route != "" {
+		tags["route"] = event.Route
+	}
+	if event.Status != 0 {
+		tags["status"] = strconv.Itoa(event.Status)
+	}
+	if event.RequestID != "" {
+		tags["request_id"] = event.RequestID
+	}
+	payload := map[string]any{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   event.Time.UTC().Format(time.RFC3339),
+		"level":       level,
+		"platform":    "go",
+		"logger":      "mcq-exam",
+		"server_name": instance.ID(),
+		"environment": s.environment,
+		"message":     map[string]string{"formatted": event.Message},
+		"fingerprint": []string{event.Fingerprint()},
+		"tags":        tags,
+		"request": map[string]any{
+			"method":  event.Method,
+			"url":     event.Path,
+			"headers": map[string]string{"User-Agent": event.UserAgent},
+			"env":     map[string]string{"REMOTE_ADDR": event.IP},
+		},
+	}
+	if event.Stack != "" {
+		payload["extra"] = map[string]string{"stack": event.Stack}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sentry responded %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mcq-exam/db"
+	"mcq-exam/jobs"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxDeliveryAttempts = 5
+	maxResponseBodySize = 2000
+	// retryPollInterval is how often due retries are picked up
+	retryPollInterval = 2 * time.Second
+	// retryBatchSize is how many due retries one pass claims; attempts are made one by one,
+	// so a batch to unresponsive subscribers takes retryBatchSize times the client timeout
+	retryBatchSize = 10
+	// retryLease is how long a claimed retry stays with its instance; one still unrecorded
+	// after that (its instance stopped mid-attempt) is claimed again. It is well over the
+	// time a full batch of timed-out attempts takes, so no retry is claimed twice.
+	retryLease = 10 * time.Minute
+)
+
+// retryBackoff is the wait after each failed attempt before the next one
+var retryBackoff = []time.Duration{
+	2 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	2 * time.Minute,
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+type subscription struct {
+	ID     int
+	URL    string
+	Secret string
+}
+
+// StartDispatcher starts worker goroutines that deliver queued events to subscribers, and
+// the poller that attempts failed deliveries again once their backoff is over
+func StartDispatcher(workers int) {
+	log.Info().Int("workers", workers).Msg("Starting webhook dispatcher")
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for event := range queue {
+				dispatch(event)
+			}
+		}()
+	}
+
+	jobs.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(retryPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := retryDue(ctx); err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Msg("Failed to retry webhook deliveries")
+			}
+		}
+	})
+}
+
+// dispatch delivers a single event to every active subscription registered for its type
+func dispatch(event Event) {
+	subs, err := getSubscriptions(event.Type)
+	if err != nil {
+		log.Error().Err(err).Str("event_type", event.Type).Msg("Failed to load webhook subscriptions")
+		return
+	}
+
+	if len(subs) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Error().Err(err).Str("event_type", event.Type).Str("event_id", event.ID).Msg("Failed to marshal event")
+		return
+	}
+
+	for _, sub := range subs {
+		deliver(sub, event, body)
+	}
+}
+
+// getSubscriptions returns active subscriptions for an event type.
+// A subscription with no event types receives every event.
+func getSubscriptions(eventType string) ([]subscription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT id, url, secret
+		FROM webhook_subscriptions
+		WHERE active = true
+		  AND ($1 = ANY(event_types) OR cardinality(event_types) = 0)
+		ORDER BY id
+	`
+	rows, err := db.Pool.Query(ctx, query, eventType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []subscription
+	for rows.Next() {
+		var sub subscription
+		if err := rows.Scan(&sub.ID, &sub.URL, &sub.Secret); err != nil {
+			continue
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, nil
+}
+
+// deliver creates a delivery log row for the subscription and makes the first attempt
+func deliver(sub subscription, event Event, body []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	var deliveryID int
+	insertQuery := `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, status)
+		VALUES ($1, $2, $3, $4, 'pending')
+		RETURNING id
+	`
+	err := db.Pool.QueryRow(ctx, insertQuery, sub.ID, event.ID, event.Type, string(body)).Scan(&deliveryID)
+	cancel()
+	if err != nil {
+		log.Error().Err(err).Int("subscription_id", sub.ID).Msg("Failed to create webhook delivery")
+		return
+	}
+
+	attemptDelivery(sub, event, deliveryID, body, 1)
+}
+
+// attemptDelivery makes one delivery attempt and, on failure, records when the next one is
+// due. Retries are picked up from the delivery rows by retryDue, so they survive a restart
+// and a slow or dead subscriber never blocks the dispatcher workers.
+func attemptDelivery(sub subscription, event Event, deliveryID int, body []byte, attempt int) {
+	statusCode, respBody, err := send(sub, event, deliveryID, body)
+
+	status := "retrying"
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	} else if statusCode >= 200 && statusCode < 300 {
+		status = "delivered"
+	} else {
+		errMsg = fmt.Sprintf("unexpected status code %d", statusCode)
+	}
+	if status != "delivered" && attempt == maxDeliveryAttempts {
+		status = "failed"
+	}
+
+	var retryIn time.Duration
+	if status == "retrying" {
+		retryIn = retryBackoff[attempt-1]
+	}
+	recordAttempt(deliveryID, attempt, status, statusCode, respBody, errMsg, retryIn)
+
+	if status == "failed" {
+		log.Error().Int("delivery_id", deliveryID).Str("url", sub.URL).Int("attempts", maxDeliveryAttempts).Msg("Webhook delivery failed after all attempts")
+	}
+}
+
+// send performs one signed HTTP POST to the subscriber URL
+func send(sub subscription, event Event, deliveryID int, body []byte) (int, string, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest("POST", sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "MCQ-Exam-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-ID", event.ID)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(deliveryID))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(sub.Secret, timestamp, body))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBodySize))
+	return resp.StatusCode, string(respBody), nil
+}
+
+// Sign computes the hex HMAC-SHA256 of "<timestamp>.<body>" using the subscription secret.
+// Receivers verify a delivery by recomputing this value from the X-Webhook-Timestamp header.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDue claims retrying deliveries whose backoff is over and attempts each again. Claims
+// skip rows locked by other instances, so every retry is made by one of them.
+func retryDue(ctx context.Context) error {
+	query := `
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM webhook_subscriptions s
+		WHERE s.id = d.subscription_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'retrying' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at, id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.event_id, d.event_type, d.payload::text, d.attempts, s.id, s.url, s.secret
+	`
+	rows, err := db.Pool.Query(ctx, query, retryBatchSize, retryLease.Seconds())
+	if err != nil {
+		return err
+	}
+
+	type due struct {
+		sub        subscription
+		event      Event
+		deliveryID int
+		body       []byte
+		attempts   int
+	}
+	var batch []due
+	for rows.Next() {
+		var d due
+		var payload string
+		if err := rows.Scan(&d.deliveryID, &d.event.ID, &d.event.Type, &payload, &d.attempts,
+			&d.sub.ID, &d.sub.URL, &d.sub.Secret); err != nil {
+			rows.Close()
+			return err
+		}
+		d.body = []byte(payload)
+		batch = append(batch, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range batch {
+		if ctx.Err() != nil {
+			// Left claimed; the lease runs out and the retry is picked up again
+			return nil
+		}
+		attemptDelivery(d.sub, d.event, d.deliveryID, d.body, d.attempts+1)
+	}
+	return nil
+}
+
+// recordAttempt updates the delivery log with the outcome of an attempt; a retrying delivery
+// is next attempted after retryIn
+func recordAttempt(deliveryID, attempt int, status string, statusCode int, respBody, errMsg string, retryIn time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var code *int
+	var message *string
+	if statusCode != 0 {
+		code = &statusCode
+	}
+	if errMsg != "" {
+		message = &errMsg
+	}
+
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1,
+		    attempts = $2,
+		    response_code = $3,
+		    response_body = $4,
+		    error_message = $5,
+		    delivered_at = CASE WHEN $1 = 'delivered' THEN NOW() ELSE delivered_at END,
+		    next_attempt_at = CASE WHEN $1 = 'retrying' THEN NOW() + make_interval(secs => $7) END
+		WHERE id = $6
+	`
+	if _, err := db.Pool.Exec(ctx, query, status, attempt, code, respBody, message, deliveryID, retryIn.Seconds()); err != nil {
+		log.Error().Err(err).Int("delivery_id", deliveryID).Msg("Failed to update webhook delivery")
+	}
+}
@@ -0,0 +1,82 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Event types delivered to webhook subscribers
+const (
+	StudentCreated      = "student.created"
+	StudentMerged       = "student.merged"
+	SessionStarted      = "session.started"
+	SessionCompleted    = "session.completed"
+	SessionAbandoned    = "session.abandoned"
+	SessionDisqualified = "session.disqualified"
+	ExamPaused          = "exam.paused"
+	ExamResumed         = "exam.resumed"
+	EmailBounced        = "email.bounced"
+	CertificateIssued   = "certificate.issued"
+)
+
+// EventTypes lists every event type a subscription can register for
+var EventTypes = []string{
+	StudentCreated,
+	StudentMerged,
+	SessionStarted,
+	SessionCompleted,
+	SessionAbandoned,
+	SessionDisqualified,
+	ExamPaused,
+	ExamResumed,
+	EmailBounced,
+	CertificateIssued,
+}
+
+// Event is the JSON envelope POSTed to subscribers
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// queue buffers events between request handlers and dispatcher workers
+var queue = make(chan Event, 1000)
+
+// IsValidEventType reports whether eventType is a known event type
+func IsValidEventType(eventType string) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish queues an event for delivery to all matching subscriptions.
+// It never blocks the caller: if the queue is full the event is dropped and logged.
+func Publish(eventType string, data interface{}) {
+	event := Event{
+		ID:        generateEventID(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+
+	select {
+	case queue <- event:
+	default:
+		log.Warn().Str("event_type", eventType).Str("event_id", event.ID).Msg("Webhook queue full, dropping event")
+	}
+}
+
+// generateEventID generates a random identifier for an event
+func generateEventID() string {
+	bytes := make([]byte, 16)
+	_, _ = rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,114 @@
+package exampause
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrAlreadyPaused is returned by Pause while a pause is open
+	ErrAlreadyPaused = errors.New("exam is already paused")
+	// ErrNotPaused is returned by Resume when no pause is open
+	ErrNotPaused = errors.New("exam is not paused")
+)
+
+// Pause is an exam_pauses row; ResumedAt is nil while the exam is paused
+type Pause struct {
+	ID        int        `json:"id"`
+	Reason    string     `json:"reason"`
+	PausedAt  time.Time  `json:"paused_at"`
+	ResumedAt *time.Time `json:"resumed_at"`
+	// DurationSeconds is how long the pause lasted, or has lasted so far
+	DurationSeconds int `json:"duration_seconds"`
+}
+
+// State is the exam-wide pause state; Pause is the open pause, if any
+type State struct {
+	Paused bool   `json:"paused"`
+	Pause  *Pause `json:"pause,omitempty"`
+}
+
+// PausedSeconds is an SQL expression for the seconds between the from and to expressions
+// that the exam spent paused. Timers subtract it so they stand still during a pause.
+func PausedSeconds(from, to string) string {
+	return `(SELECT COALESCE(SUM(EXTRACT(EPOCH FROM
+			LEAST(COALESCE(p.resumed_at, NOW()), ` + to + `) - GREATEST(p.paused_at, ` + from + `))), 0)::float8
+		FROM exam_pauses p
+		WHERE p.paused_at < ` + to + ` AND COALESCE(p.resumed_at, NOW()) > ` + from + `)`
+}
+
+const pauseColumns = `id, reason, paused_at, resumed_at, EXTRACT(EPOCH FROM COALESCE(resumed_at, NOW()) - paused_at)::int`
+
+func scanPause(row pgx.Row) (*Pause, error) {
+	var p Pause
+	if err := row.Scan(&p.ID, &p.Reason, &p.PausedAt, &p.ResumedAt, &p.DurationSeconds); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Current returns whether the exam is paused. It reads the database on every call, so all
+// instances agree the moment an organizer pauses or resumes.
+func Current(ctx context.Context) (State, error) {
+	pause, err := scanPause(db.Pool.QueryRow(ctx, `SELECT `+pauseColumns+` FROM exam_pauses WHERE resumed_at IS NULL`))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, fmt.Errorf("failed to load exam pause state: %w", err)
+	}
+	return State{Paused: true, Pause: pause}, nil
+}
+
+// Start pauses the exam for everyone
+func Start(ctx context.Context, reason string) (*Pause, error) {
+	query := `
+		INSERT INTO exam_pauses (reason) VALUES ($1)
+		ON CONFLICT ((true)) WHERE resumed_at IS NULL DO NOTHING
+		RETURNING ` + pauseColumns
+	pause, err := scanPause(db.Pool.QueryRow(ctx, query, reason))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAlreadyPaused
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to pause exam: %w", err)
+	}
+	return pause, nil
+}
+
+// Resume ends the open pause; timers continue from where they stood
+func Resume(ctx context.Context) (*Pause, error) {
+	query := `UPDATE exam_pauses SET resumed_at = NOW() WHERE resumed_at IS NULL RETURNING ` + pauseColumns
+	pause, err := scanPause(db.Pool.QueryRow(ctx, query))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotPaused
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resume exam: %w", err)
+	}
+	return pause, nil
+}
+
+// Recent lists the latest pauses, newest first
+func Recent(ctx context.Context, limit int) ([]Pause, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT `+pauseColumns+` FROM exam_pauses ORDER BY paused_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pauses := []Pause{}
+	for rows.Next() {
+		pause, err := scanPause(rows)
+		if err != nil {
+			return nil, err
+		}
+		pauses = append(pauses, *pause)
+	}
+	return pauses, rows.Err()
+}
@@ -0,0 +1,273 @@
+package examwindow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// Window policies, set per event schedule
+const (
+	// PolicyGlobal opens the exam at second_scheduled_time for everyone
+	PolicyGlobal = "global"
+	// PolicyLocal opens the exam at the same wall-clock time in each participant's timezone
+	PolicyLocal = "local"
+)
+
+// DefaultTimezone is the zone schedules are entered in, and the zone used for
+// participants whose timezone is unknown
+const DefaultTimezone = "Asia/Kolkata"
+
+// DefaultMinutes is how long the exam window stays open
+const DefaultMinutes = 360
+
+// Entry statuses, relative to a participant's Entry
+const (
+	EntryNotOpen = "not_open"
+	EntryOpen    = "open"
+	EntryClosed  = "closed"
+)
+
+// ErrNoSchedule is returned when no event has been scheduled
+var ErrNoSchedule = errors.New("no event schedule")
+
+// Schedule is the window configuration of one event
+type Schedule struct {
+	ID       int
+	StartsAt time.Time
+	Minutes  int
+	Policy   string
+	Timezone string
+	// EarlyEntryMinutes lets participants start this long before their window opens
+	EarlyEntryMinutes int
+	// LateEntryMinutes closes entry this long after the window opens; 0 keeps it open until
+	// the window ends
+	LateEntryMinutes int
+	// ReducedTime makes late entrants finish when they would have had they started on time
+	ReducedTime bool
+}
+
+// Window is when a participant in Timezone may start the exam
+type Window struct {
+	Timezone         string    `json:"timezone"`
+	UTCOffsetMinutes int       `json:"utc_offset_minutes"`
+	StartsAt         time.Time `json:"starts_at"`
+	EndsAt           time.Time `json:"ends_at"`
+}
+
+// Entry is when a participant may start the exam, around their Window
+type Entry struct {
+	OpensAt     time.Time `json:"opens_at"`
+	ClosesAt    time.Time `json:"closes_at"`
+	ReducedTime bool      `json:"reduced_time"`
+}
+
+// Status reports whether entry has opened or closed at now
+func (e Entry) Status(now time.Time) string {
+	switch {
+	case now.Before(e.OpensAt):
+		return EntryNotOpen
+	case now.After(e.ClosesAt):
+		return EntryClosed
+	}
+	return EntryOpen
+}
+
+// ValidPolicy reports whether policy is a known window policy
+func ValidPolicy(policy string) bool {
+	return policy == PolicyGlobal || policy == PolicyLocal
+}
+
+// ValidTimezone checks that name is an IANA timezone such as "Europe/London"
+func ValidTimezone(name string) error {
+	if name == "" || name == "Local" {
+		return fmt.Errorf("invalid timezone %q", name)
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf("invalid timezone %q", name)
+	}
+	return nil
+}
+
+// Latest returns the window configuration of the most recent event
+func Latest(ctx context.Context) (*Schedule, error) {
+	var s Schedule
+	query := `
+		SELECT id, second_scheduled_time, window_minutes, window_policy, timezone,
+		       early_entry_minutes, late_entry_minutes, late_entry_reduced_time
+		FROM event_schedule
+		ORDER BY id DESC
+		LIMIT 1
+	`
+	err := db.Pool.QueryRow(ctx, query).Scan(&s.ID, &s.StartsAt, &s.Minutes, &s.Policy, &s.Timezone,
+		&s.EarlyEntryMinutes, &s.LateEntryMinutes, &s.ReducedTime)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoSchedule
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Compute resolves the schedule's window for a participant timezone. An empty or unknown
+// timezone falls back to the schedule's own.
+func (s *Schedule) Compute(timezone string) Window {
+	ref, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		ref, _ = time.LoadLocation(DefaultTimezone)
+	}
+	loc, err := time.LoadLocation(timezone)
+	if timezone == "" || err != nil {
+		timezone, loc = ref.String(), ref
+	}
+
+	start := s.StartsAt
+	if s.Policy == PolicyLocal {
+		wall := s.StartsAt.In(ref)
+		start = time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc)
+	}
+	_, offset := start.In(loc).Zone()
+
+	return Window{
+		Timezone:         timezone,
+		UTCOffsetMinutes: offset / 60,
+		StartsAt:         start.UTC(),
+		EndsAt:           start.Add(time.Duration(s.Minutes) * time.Minute).UTC(),
+	}
+}
+
+// Entry returns when a participant with window w may start the exam: from EarlyEntryMinutes
+// before it opens until LateEntryMinutes after, or until it ends
+func (s *Schedule) Entry(w Window) Entry {
+	closes := w.EndsAt
+	if s.LateEntryMinutes > 0 {
+		if late := w.StartsAt.Add(time.Duration(s.LateEntryMinutes) * time.Minute); late.Before(closes) {
+			closes = late
+		}
+	}
+	return Entry{
+		OpensAt:     w.StartsAt.Add(-time.Duration(s.EarlyEntryMinutes) * time.Minute),
+		ClosesAt:    closes,
+		ReducedTime: s.ReducedTime,
+	}
+}
+
+// FinishBy is when a session entering at now must end, for a test taking duration: with
+// ReducedTime, a late entrant finishes when the test started at the window's opening would
+// have. nil when the entrant gets the full duration.
+func (s *Schedule) FinishBy(w Window, now time.Time, duration time.Duration) *time.Time {
+	if !s.ReducedTime || duration <= 0 || !now.After(w.StartsAt) {
+		return nil
+	}
+	finishBy := w.StartsAt.Add(duration)
+	return &finishBy
+}
+
+// For returns the participant's window, storing it in exam_windows the first time a
+// timezone is seen for the event so the windows handed out can be reviewed later
+func For(ctx context.Context, s *Schedule, timezone string) Window {
+	window := s.Compute(timezone)
+
+	query := `
+		INSERT INTO exam_windows (event_schedule_id, timezone, utc_offset_minutes, starts_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (event_schedule_id, timezone) DO NOTHING
+	`
+	if _, err := db.Pool.Exec(ctx, query, s.ID, window.Timezone, window.UTCOffsetMinutes, window.StartsAt, window.EndsAt); err != nil {
+		log.Warn().Err(err).Int("event_schedule_id", s.ID).Str("timezone", window.Timezone).Msg("Failed to store exam window")
+	}
+	return window
+}
+
+// Rebuild recomputes the stored windows of an event for every participant timezone,
+// after the event's policy or duration changes. Returns the number of windows written.
+func Rebuild(ctx context.Context, s *Schedule) (int, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM exam_windows WHERE event_schedule_id = $1`, s.ID); err != nil {
+		return 0, fmt.Errorf("failed to clear exam windows: %w", err)
+	}
+
+	rows, err := tx.Query(ctx, `SELECT DISTINCT timezone FROM students WHERE timezone IS NOT NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list participant timezones: %w", err)
+	}
+	timezones := []string{s.Timezone}
+	for rows.Next() {
+		var timezone string
+		if err := rows.Scan(&timezone); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		timezones = append(timezones, timezone)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	query := `
+		INSERT INTO exam_windows (event_schedule_id, timezone, utc_offset_minutes, starts_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (event_schedule_id, timezone) DO NOTHING
+	`
+	for _, timezone := range timezones {
+		if ValidTimezone(timezone) != nil {
+			continue
+		}
+		window := s.Compute(timezone)
+		tag, err := tx.Exec(ctx, query, s.ID, window.Timezone, window.UTCOffsetMinutes, window.StartsAt, window.EndsAt)
+		if err != nil {
+			return 0, fmt.Errorf("failed to store exam window: %w", err)
+		}
+		written += int(tag.RowsAffected())
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return written, nil
+}
+
+// Stored returns the windows resolved for an event, with how many participants are in each timezone
+func Stored(ctx context.Context, scheduleID int) ([]StoredWindow, error) {
+	query := `
+		SELECT w.timezone, w.utc_offset_minutes, w.starts_at, w.ends_at,
+		       (SELECT COUNT(*) FROM students s WHERE s.timezone = w.timezone)
+		FROM exam_windows w
+		WHERE w.event_schedule_id = $1
+		ORDER BY w.starts_at ASC, w.timezone ASC
+	`
+	rows, err := db.Pool.Query(ctx, query, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	windows := make([]StoredWindow, 0)
+	for rows.Next() {
+		var w StoredWindow
+		if err := rows.Scan(&w.Timezone, &w.UTCOffsetMinutes, &w.StartsAt, &w.EndsAt, &w.Participants); err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, rows.Err()
+}
+
+// StoredWindow is a resolved window and the number of participants it applies to
+type StoredWindow struct {
+	Window
+	Participants int `json:"participants"`
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/accesscodes"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type AccessCodeRequest struct {
+	Reason string `json:"reason"`
+}
+
+// GetAccessCodeHandler handles GET /api/admin/students/:id/access-code
+// Returns the student's code, its state, any lockout of it and the 50 most recent audit events
+func GetAccessCodeHandler(c *fiber.Ctx) error {
+	studentID, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid student ID")
+	}
+	logging.SetStudent(c, studentID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	code, err := accesscodes.Get(ctx, studentID)
+	if errors.Is(err, accesscodes.ErrNotFound) {
+		return apierror.Send(c, fiber.StatusNotFound, "Student has not attended the conference")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch access code")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch access code")
+	}
+
+	events, err := accesscodes.Events(ctx, studentID, 50)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch access code events")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch access code events")
+	}
+
+	lockout, err := accesscodes.ActiveLockout(ctx, studentID, "")
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check access code lockout")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch access code")
+	}
+
+	return c.JSON(fiber.Map{
+		"access_code": code,
+		"ttl_seconds": int(accesscodes.TTL().Seconds()),
+		"lockout":     lockout,
+		"events":      events,
+	})
+}
+
+// RegenerateAccessCodeHandler handles POST /api/admin/students/:id/access-code/regenerate
+// Body: {"reason": "lost the email"}. The old code stops working immediately.
+func RegenerateAccessCodeHandler(c *fiber.Ctx) error {
+	return changeAccessCode(c, "regenerate", func(ctx context.Context, studentID int, audit accesscodes.Audit) (*accesscodes.Code, error) {
+		return accesscodes.Regenerate(ctx, studentID, accesscodes.ActionRegenerated, audit)
+	})
+}
+
+// InvalidateAccessCodeHandler handles POST /api/admin/students/:id/access-code/invalidate
+// Body: {"reason": "code shared with another student"}
+func InvalidateAccessCodeHandler(c *fiber.Ctx) error {
+	return changeAccessCode(c, "invalidate", accesscodes.Invalidate)
+}
+
+// changeAccessCode parses the request shared by regenerate and invalidate and records who made the change
+func changeAccessCode(c *fiber.Ctx, action string, change func(context.Context, int, accesscodes.Audit) (*accesscodes.Code, error)) error {
+	studentID, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid student ID")
+	}
+	logging.SetStudent(c, studentID)
+
+	var req AccessCodeRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	code, err := change(ctx, studentID, accesscodes.Audit{
+		Reason:    req.Reason,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+	if errors.Is(err, accesscodes.ErrNotFound) {
+		return apierror.Send(c, fiber.StatusNotFound, "Student has not attended the conference")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Str("action", action).Msg("Failed to change access code")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to "+action+" access code")
+	}
+	logging.Ctx(c).Info().Str("action", action).Str("reason", req.Reason).Msg("Access code changed by admin")
+
+	return c.JSON(fiber.Map{
+		"message":     "Access code " + action + "d",
+		"access_code": code,
+	})
+}
+
+// GetOTPAttemptsHandler handles GET /api/admin/otp-attempts?since=2025-03-01T00:00:00Z&student_id=12&ip=203.0.113.7&limit=100
+// Reports verify-otp attempts since a time (default the last 24 hours): counts by outcome,
+// the IPs with the most wrong codes, the lockouts in effect and the most recent attempts,
+// of one student or IP when filtered
+func GetOTPAttemptsHandler(c *fiber.Ctx) error {
+	since := time.Now().Add(-24 * time.Hour)
+	if value := c.Query("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "since must be an RFC 3339 time")
+		}
+		since = parsed
+	}
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > 1000 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Limit must be between 1 and 1000")
+	}
+	studentID := c.QueryInt("student_id", 0)
+	if studentID < 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid student ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	report, err := accesscodes.Report(ctx, since, studentID, strings.TrimSpace(c.Query("ip")), limit)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to build OTP attempts report")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch OTP attempts")
+	}
+	lockoutCfg := accesscodes.LockoutFromEnv()
+	return c.JSON(fiber.Map{
+		"config": fiber.Map{
+			"max_failures":    lockoutCfg.MaxFailures,
+			"ip_max_failures": lockoutCfg.IPMaxFailures,
+			"lockout_seconds": int(lockoutCfg.Duration.Seconds()),
+		},
+		"report": report,
+	})
+}
+
+type OTPUnlockRequest struct {
+	StudentID int    `json:"student_id"`
+	IP        string `json:"ip"`
+	Reason    string `json:"reason"`
+}
+
+// UnlockOTPHandler handles POST /api/admin/otp-attempts/unlock
+// Body: {"student_id": 12, "reason": "verified by phone"} or {"ip": "203.0.113.7"}
+// Lifts the lockout of a student's code or of an IP before it runs out
+func UnlockOTPHandler(c *fiber.Ctx) error {
+	var req OTPUnlockRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	req.IP = strings.TrimSpace(req.IP)
+	if (req.StudentID > 0) == (req.IP != "") || req.StudentID < 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Give either student_id or ip")
+	}
+	if req.StudentID > 0 {
+		logging.SetStudent(c, req.StudentID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lockouts, err := accesscodes.Unlock(ctx, req.StudentID, req.IP, accesscodes.Audit{
+		Reason:    req.Reason,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+	if errors.Is(err, accesscodes.ErrNotLocked) {
+		return apierror.Send(c, fiber.StatusNotFound, "No lockout in effect")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to unlock OTP verification")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to unlock")
+	}
+	logging.Ctx(c).Info().Str("ip", req.IP).Str("reason", req.Reason).Msg("OTP lockout lifted by admin")
+
+	return c.JSON(fiber.Map{
+		"message":  "Unlocked",
+		"lockouts": lockouts,
+	})
+}
@@ -1,26 +1,155 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/archive"
 	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/scoring"
+	"mcq-exam/sessioncache"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 // ResetDatabaseHandler handles POST /api/admin/reset-db
 // WARNING: This drops all tables and re-runs migrations
+// Refused while the latest event has completed sessions not in its archive
+// (POST /api/admin/exams/:id/archive)
 func ResetDatabaseHandler(c *fiber.Ctx) error {
-	// Optional: Add authentication/authorization here
-	// For now, it's open - SECURE THIS IN PRODUCTION!
+	checkCtx, checkCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer checkCancel()
+
+	pending, err := archive.Unarchived(checkCtx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check exam archives")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to check exam archives")
+	}
+	if pending != nil {
+		message := "Archive the latest event before resetting the database"
+		if pending.ArchiveID != 0 {
+			message = "Sessions were completed after the latest event was archived; archive it again before resetting the database"
+		}
+		return apierror.Respond(c, fiber.StatusConflict, apierror.CodeConflict, message, pending)
+	}
 
 	if err := db.ResetDatabase(); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to reset database",
-			"details": err.Error(),
-		})
+		return apierror.Respond(c, fiber.StatusInternalServerError, apierror.CodeInternal,
+			"Failed to reset database", fiber.Map{"error": err.Error()})
 	}
 
+	// Cached session tokens would point at sessions that no longer exist
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sessioncache.Clear(ctx)
+
 	return c.JSON(fiber.Map{
 		"message": "Database reset successfully",
 		"status":  "All tables dropped and migrations re-run",
 	})
 }
+
+// RebuildSectionScoresHandler handles POST /api/admin/section-scores/rebuild
+// Recomputes section results for all completed sessions (e.g. after the question bank changes)
+func RebuildSectionScoresHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	rows, err := scoring.RebuildSectionScores(ctx)
+	if err != nil {
+		return apierror.Respond(c, fiber.StatusInternalServerError, apierror.CodeInternal,
+			"Failed to rebuild section scores", fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":      "Section scores rebuilt successfully",
+		"rows_written": rows,
+	})
+}
+
+// GetInconsistentSessionsHandler handles GET /api/admin/sessions/inconsistent?limit=100
+// Lists completed sessions whose stored results disagree with their answers
+func GetInconsistentSessionsHandler(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sessions, err := scoring.FindInconsistent(ctx, limit)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check sessions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to check sessions")
+	}
+
+	return c.JSON(fiber.Map{
+		"count":    len(sessions),
+		"sessions": sessions,
+	})
+}
+
+type ReconcileSessionsRequest struct {
+	// SessionIDs to re-finalize; when empty, every inconsistent session found (up to Limit)
+	SessionIDs []int `json:"session_ids"`
+	Limit      int   `json:"limit"`
+}
+
+// ReconcileSessionsHandler handles POST /api/admin/sessions/reconcile
+// Body: {"session_ids": [12, 15]} or {} to re-finalize every inconsistent session
+func ReconcileSessionsHandler(c *fiber.Ctx) error {
+	var req ReconcileSessionsRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+	}
+	if req.Limit < 1 || req.Limit > 1000 {
+		req.Limit = 1000
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	sessionIDs := req.SessionIDs
+	if len(sessionIDs) == 0 {
+		found, err := scoring.FindInconsistent(ctx, req.Limit)
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to check sessions")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to check sessions")
+		}
+		for _, session := range found {
+			sessionIDs = append(sessionIDs, session.SessionID)
+		}
+	}
+
+	type reconciled struct {
+		SessionID int             `json:"session_id"`
+		Result    *scoring.Result `json:"result,omitempty"`
+		Error     string          `json:"error,omitempty"`
+	}
+	results := make([]reconciled, 0, len(sessionIDs))
+	failed := 0
+	for _, sessionID := range sessionIDs {
+		result, err := scoring.Refinalize(ctx, sessionID)
+		if err != nil {
+			failed++
+			if !errors.Is(err, scoring.ErrNotCompleted) {
+				logging.Ctx(c).Error().Err(err).Int("session_id", sessionID).Msg("Failed to re-finalize session")
+			}
+			results = append(results, reconciled{SessionID: sessionID, Error: err.Error()})
+			continue
+		}
+		results = append(results, reconciled{SessionID: sessionID, Result: result})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":    "Sessions reconciled",
+		"reconciled": len(sessionIDs) - failed,
+		"failed":     failed,
+		"sessions":   results,
+	})
+}
@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"mcq-exam/apierror"
+	"mcq-exam/attempts"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/questions"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Item analysis settings
+const (
+	// discriminationGroup is the share of sessions in the upper and lower scoring groups
+	discriminationGroup = 0.27
+	// minItemResponses is how many answers a question needs before it is flagged
+	minItemResponses = 10
+	// optionCount is the number of options accepted by submit-answer
+	optionCount = 4
+)
+
+// Item analysis flags
+const (
+	FlagTooHard                = "too_hard"                // fewer than 20% correct
+	FlagTooEasy                = "too_easy"                // more than 95% correct
+	FlagLowDiscrimination      = "low_discrimination"      // discrimination below 0.2
+	FlagNegativeDiscrimination = "negative_discrimination" // low scorers do better than high scorers
+	FlagDistractorPreferred    = "distractor_preferred"    // a wrong option is chosen more often than the key
+	FlagInconsistentKey        = "inconsistent_key"        // stored correctness disagrees with the current answer key
+)
+
+type OptionStats struct {
+	Index   int     `json:"index"`
+	Text    string  `json:"text"`
+	Correct bool    `json:"correct"`
+	Count   int     `json:"count"`
+	Percent float64 `json:"percent"`
+}
+
+type QuestionStats struct {
+	QuestionID    int    `json:"question_id"`
+	SectionID     int    `json:"section_id"`
+	SectionName   string `json:"section_name"`
+	Question      string `json:"question"`
+	CorrectOption int    `json:"correct_option"`
+	Responses     int    `json:"responses"`
+	Correct       int    `json:"correct"`
+	// Difficulty is the percentage of responses that were correct (higher is easier)
+	Difficulty *float64 `json:"difficulty"`
+	// Discrimination is the share correct in the top 27% of sessions by score minus the share
+	// correct in the bottom 27%, from -1 to 1
+	Discrimination      *float64      `json:"discrimination"`
+	AvgTimeTakenSeconds *float64      `json:"avg_time_taken_seconds"`
+	Options             []OptionStats `json:"options"`
+	InconsistentAnswers int           `json:"inconsistent_answers"`
+	// FlaggedForReview counts sessions that ended with the question flagged; SkippedBy those
+	// that skipped it at least once, SkippedUnanswered of them never answered it, and Skips
+	// counts every skip (see POST /api/live/flag-question)
+	FlaggedForReview  int      `json:"flagged_for_review"`
+	SkippedBy         int      `json:"skipped_by"`
+	SkippedUnanswered int      `json:"skipped_unanswered"`
+	Skips             int      `json:"skips"`
+	Flags             []string `json:"flags"`
+}
+
+// GetQuestionAnalyticsHandler handles GET /api/analytics/questions?section_id=2&flagged=true
+// Item analysis over each student's counted attempt (ATTEMPT_POLICY)
+func GetQuestionAnalyticsHandler(c *fiber.Ctx) error {
+	sectionID := c.QueryInt("section_id", 0)
+	flaggedOnly := c.QueryBool("flagged", false)
+
+	sections, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+	}
+	if sectionID != 0 && questions.FindSection(sections, sectionID) == nil {
+		return apierror.Send(c, fiber.StatusNotFound, "Section not found")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var sessions int
+	if err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM `+attempts.CountedSessions()+` sess`).Scan(&sessions); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to count sessions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to compute question analytics")
+	}
+	groupSize := int(math.Ceil(float64(sessions) * discriminationGroup))
+
+	var keyQuestionIDs, keyOptions []int
+	for _, section := range sections {
+		for _, q := range section.Questions {
+			keyQuestionIDs = append(keyQuestionIDs, q.ID)
+			keyOptions = append(keyOptions, q.CorrectAnswer)
+		}
+	}
+
+	// Sessions are ordered by score; the first groupSize form the upper group and the last
+	// groupSize the lower group. $1 is the group size, $2/$3 the answer key.
+	query := `
+		WITH ranked AS (
+			SELECT sess.id,
+			       ROW_NUMBER() OVER (ORDER BY COALESCE(sess.score, 0) DESC, sess.id ASC) AS pos,
+			       COUNT(*) OVER () AS total
+			FROM ` + attempts.CountedSessions() + ` sess
+		),
+		grouped AS (
+			SELECT id,
+			       CASE WHEN pos <= $1 THEN 'upper'
+			            WHEN pos > total - $1 THEN 'lower'
+			       END AS grp
+			FROM ranked
+		)
+		SELECT
+			a.question_id,
+			COUNT(*),
+			COUNT(*) FILTER (WHERE a.is_correct),
+			AVG(a.time_taken_seconds)::float8,
+			COUNT(*) FILTER (WHERE a.selected_option_index = 0),
+			COUNT(*) FILTER (WHERE a.selected_option_index = 1),
+			COUNT(*) FILTER (WHERE a.selected_option_index = 2),
+			COUNT(*) FILTER (WHERE a.selected_option_index = 3),
+			COUNT(*) FILTER (WHERE g.grp = 'upper'),
+			COUNT(*) FILTER (WHERE g.grp = 'upper' AND a.is_correct),
+			COUNT(*) FILTER (WHERE g.grp = 'lower'),
+			COUNT(*) FILTER (WHERE g.grp = 'lower' AND a.is_correct),
+			COUNT(*) FILTER (WHERE k.correct_option IS NOT NULL AND a.is_correct <> (a.selected_option_index = k.correct_option))
+		FROM answers a
+		INNER JOIN grouped g ON g.id = a.session_id
+		LEFT JOIN unnest($2::int[], $3::int[]) AS k(question_id, correct_option) ON k.question_id = a.question_id
+		GROUP BY a.question_id
+	`
+	rows, err := db.Pool.Query(ctx, query, groupSize, keyQuestionIDs, keyOptions)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to compute question analytics")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to compute question analytics")
+	}
+	defer rows.Close()
+
+	type itemCounts struct {
+		responses, correct                                 int
+		avgTime                                            float64
+		options                                            [optionCount]int
+		upper, upperCorrect, lower, lowerCorrect, mismatch int
+	}
+	counts := make(map[int]*itemCounts)
+	for rows.Next() {
+		var questionID int
+		var ic itemCounts
+		if err := rows.Scan(&questionID, &ic.responses, &ic.correct, &ic.avgTime,
+			&ic.options[0], &ic.options[1], &ic.options[2], &ic.options[3],
+			&ic.upper, &ic.upperCorrect, &ic.lower, &ic.lowerCorrect, &ic.mismatch); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan question analytics")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to compute question analytics")
+		}
+		counts[questionID] = &ic
+	}
+	if err := rows.Err(); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to read question analytics")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to compute question analytics")
+	}
+	rows.Close()
+
+	marks, err := questionMarkCounts(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to count question marks")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to compute question analytics")
+	}
+
+	items := make([]QuestionStats, 0)
+	for _, section := range sections {
+		if sectionID != 0 && section.ID != sectionID {
+			continue
+		}
+		for _, q := range section.Questions {
+			item := QuestionStats{
+				QuestionID:    q.ID,
+				SectionID:     section.ID,
+				SectionName:   section.Name,
+				Question:      q.Question,
+				CorrectOption: q.CorrectAnswer,
+				Options:       make([]OptionStats, 0, len(q.Options)),
+			}
+			ic := counts[q.ID]
+			if ic == nil {
+				ic = &itemCounts{}
+			}
+			item.Responses, item.Correct, item.InconsistentAnswers = ic.responses, ic.correct, ic.mismatch
+			mc := marks[q.ID]
+			item.FlaggedForReview, item.SkippedBy, item.SkippedUnanswered, item.Skips = mc.flagged, mc.skippedBy, mc.skippedUnanswered, mc.skips
+
+			for i, text := range q.Options {
+				option := OptionStats{Index: i, Text: text, Correct: i == q.CorrectAnswer}
+				if i < optionCount {
+					option.Count = ic.options[i]
+				}
+				if ic.responses > 0 {
+					option.Percent = roundTo(float64(option.Count)/float64(ic.responses)*100, 2)
+				}
+				item.Options = append(item.Options, option)
+			}
+
+			if ic.responses > 0 {
+				difficulty := roundTo(float64(ic.correct)/float64(ic.responses)*100, 2)
+				avgTime := roundTo(ic.avgTime, 2)
+				item.Difficulty, item.AvgTimeTakenSeconds = &difficulty, &avgTime
+			}
+			// Shares within each group, so students who were not shown the question
+			// (per-student question sets) do not count as wrong
+			if ic.upper > 0 && ic.lower > 0 && sessions >= 2 {
+				discrimination := roundTo(float64(ic.upperCorrect)/float64(ic.upper)-float64(ic.lowerCorrect)/float64(ic.lower), 3)
+				item.Discrimination = &discrimination
+			}
+
+			item.Flags = itemFlags(item)
+			if flaggedOnly && len(item.Flags) == 0 {
+				continue
+			}
+			items = append(items, item)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"success":    true,
+		"sessions":   sessions,
+		"group_size": groupSize,
+		"questions":  items,
+	})
+}
+
+type markCounts struct {
+	flagged, skippedBy, skippedUnanswered, skips int
+}
+
+// questionMarkCounts counts the review markers of each question over the counted attempts
+func questionMarkCounts(ctx context.Context) (map[int]markCounts, error) {
+	query := `
+		SELECT m.question_id,
+		       COUNT(*) FILTER (WHERE m.flagged),
+		       COUNT(*) FILTER (WHERE m.skip_count > 0),
+		       COUNT(*) FILTER (WHERE m.skip_count > 0 AND a.id IS NULL),
+		       COALESCE(SUM(m.skip_count), 0)::int
+		FROM question_marks m
+		INNER JOIN ` + attempts.CountedSessions() + ` sess ON sess.id = m.session_id
+		LEFT JOIN answers a ON a.session_id = m.session_id AND a.question_id = m.question_id
+		GROUP BY m.question_id
+	`
+	rows, err := db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]markCounts)
+	for rows.Next() {
+		var questionID int
+		var mc markCounts
+		if err := rows.Scan(&questionID, &mc.flagged, &mc.skippedBy, &mc.skippedUnanswered, &mc.skips); err != nil {
+			return nil, err
+		}
+		counts[questionID] = mc
+	}
+	return counts, rows.Err()
+}
+
+// itemFlags lists what looks wrong with a question. Questions with fewer than
+// minItemResponses answers are not flagged.
+func itemFlags(item QuestionStats) []string {
+	flags := make([]string, 0)
+	if item.InconsistentAnswers > 0 {
+		flags = append(flags, FlagInconsistentKey)
+	}
+	if item.Responses < minItemResponses || item.Difficulty == nil {
+		return flags
+	}
+
+	if *item.Difficulty < 20 {
+		flags = append(flags, FlagTooHard)
+	}
+	if *item.Difficulty > 95 {
+		flags = append(flags, FlagTooEasy)
+	}
+	if item.Discrimination != nil {
+		if *item.Discrimination < 0 {
+			flags = append(flags, FlagNegativeDiscrimination)
+		} else if *item.Discrimination < 0.2 {
+			flags = append(flags, FlagLowDiscrimination)
+		}
+	}
+	for _, option := range item.Options {
+		if !option.Correct && option.Count > item.Correct {
+			flags = append(flags, FlagDistractorPreferred)
+			break
+		}
+	}
+	return flags
+}
+
+func roundTo(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"mcq-exam/answerchange"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type UpdateAnswerChangeRequest struct {
+	AllowAnswerChange *bool `json:"allow_answer_change"`
+}
+
+// GetAnswerChangeHandler handles GET /api/admin/answer-change
+// Returns whether students may change a submitted answer and where the setting comes from
+func GetAnswerChangeHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	setting, err := answerchange.Load(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load answer change setting")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load answer change setting")
+	}
+	return c.JSON(setting)
+}
+
+// UpdateAnswerChangeHandler handles PUT /api/admin/answer-change
+// Body: {"allow_answer_change": true}
+// Takes effect for the next answer submitted, on every instance
+func UpdateAnswerChangeHandler(c *fiber.Ctx) error {
+	var req UpdateAnswerChangeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if req.AllowAnswerChange == nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "allow_answer_change is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	setting, err := answerchange.Save(ctx, *req.AllowAnswerChange)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to save answer change setting")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to save answer change setting")
+	}
+	logging.Ctx(c).Info().Bool("allow_answer_change", setting.AllowAnswerChange).Msg("Answer change setting updated")
+	return c.JSON(setting)
+}
+
+// ResetAnswerChangeHandler handles DELETE /api/admin/answer-change
+// Drops the saved setting so ALLOW_ANSWER_CHANGE applies again
+func ResetAnswerChangeHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	setting, err := answerchange.Reset(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to reset answer change setting")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to reset answer change setting")
+	}
+	logging.Ctx(c).Info().Bool("allow_answer_change", setting.AllowAnswerChange).Msg("Answer change setting reset")
+	return c.JSON(setting)
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type AnswerEventRecord struct {
+	ID                     int64     `json:"id"`
+	QuestionID             int       `json:"question_id"`
+	SelectedOptionIndex    int       `json:"selected_option_index"`
+	ClientTimeTakenSeconds *int      `json:"client_time_taken_seconds"`
+	Outcome                string    `json:"outcome"`
+	Reason                 *string   `json:"reason"`
+	PreviousOptionIndex    *int      `json:"previous_option_index"`
+	Batch                  bool      `json:"batch"`
+	IP                     *string   `json:"ip"`
+	UserAgent              *string   `json:"user_agent"`
+	CreatedAt              time.Time `json:"created_at"`
+}
+
+// GetSessionAnswerEventsHandler handles GET /api/admin/sessions/:session_id/answer-events?question_id=57
+// Returns every answer submission attempt of the session, accepted or not, in the order
+// the server received them, together with the answers finally recorded. With answer
+// changes allowed, "updated" events carry the answer they replaced.
+func GetSessionAnswerEventsHandler(c *fiber.Ctx) error {
+	sessionID, err := strconv.Atoi(c.Params("session_id"))
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid session ID")
+	}
+	questionID := 0
+	if value := c.Query("question_id"); value != "" {
+		questionID, err = strconv.Atoi(value)
+		if err != nil || questionID < 1 {
+			return apierror.Send(c, fiber.StatusBadRequest, "question_id must be a positive integer")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var studentID int
+	var completed bool
+	var completedAt *time.Time
+	sessionQuery := `SELECT student_id, completed, completed_at FROM sessions WHERE id = $1`
+	if err := db.Pool.QueryRow(ctx, sessionQuery, sessionID).Scan(&studentID, &completed, &completedAt); err != nil {
+		return apierror.Send(c, fiber.StatusNotFound, "Session not found")
+	}
+
+	query := `
+		SELECT id, question_id, selected_option_index, client_time_taken_seconds, outcome, reason,
+		       previous_option_index, batch, ip, user_agent, created_at
+		FROM answer_events
+		WHERE session_id = $1 AND ($2 = 0 OR question_id = $2)
+		ORDER BY id ASC
+	`
+	rows, err := db.Pool.Query(ctx, query, sessionID, questionID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("session_id", sessionID).Msg("Failed to fetch answer events")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch answer events")
+	}
+	defer rows.Close()
+
+	events := []AnswerEventRecord{}
+	for rows.Next() {
+		var e AnswerEventRecord
+		if err := rows.Scan(&e.ID, &e.QuestionID, &e.SelectedOptionIndex, &e.ClientTimeTakenSeconds, &e.Outcome,
+			&e.Reason, &e.PreviousOptionIndex, &e.Batch, &e.IP, &e.UserAgent, &e.CreatedAt); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan answer event")
+			continue
+		}
+		events = append(events, e)
+	}
+
+	// The answers that count, to compare against the attempts
+	answerQuery := `
+		SELECT question_id, selected_option_index, submitted_at
+		FROM answers
+		WHERE session_id = $1 AND ($2 = 0 OR question_id = $2)
+		ORDER BY question_id ASC
+	`
+	answerRows, err := db.Pool.Query(ctx, answerQuery, sessionID, questionID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("session_id", sessionID).Msg("Failed to fetch answers")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch answers")
+	}
+	defer answerRows.Close()
+
+	recorded := []fiber.Map{}
+	for answerRows.Next() {
+		var qID, option int
+		var submittedAt time.Time
+		if err := answerRows.Scan(&qID, &option, &submittedAt); err != nil {
+			continue
+		}
+		recorded = append(recorded, fiber.Map{
+			"question_id":           qID,
+			"selected_option_index": option,
+			"submitted_at":          submittedAt,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"session_id":   sessionID,
+		"student_id":   studentID,
+		"completed":    completed,
+		"completed_at": completedAt,
+		"count":        len(events),
+		"events":       events,
+		"answers":      recorded,
+	})
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/apikeys"
+	"mcq-exam/logging"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name"`
+	// Scope is stats (read-only stats), mail (stats plus sending) or admin (everything)
+	Scope              string     `json:"scope"`
+	RateLimitPerMinute int        `json:"rate_limit_per_minute"`
+	ExpiresAt          *time.Time `json:"expires_at"`
+}
+
+// apiKeyError maps apikeys errors to a response
+func apiKeyError(c *fiber.Ctx, err error, msg string) error {
+	if errors.Is(err, apikeys.ErrNotFound) {
+		return apierror.Send(c, fiber.StatusNotFound, "API key not found")
+	}
+	logging.Ctx(c).Error().Err(err).Msg(msg)
+	return apierror.Send(c, fiber.StatusInternalServerError, msg)
+}
+
+// CreateAPIKeyHandler handles POST /api/admin/api-keys
+// Body: {"name": "reporting script", "scope": "stats", "rate_limit_per_minute": 120, "expires_at": "2025-12-31T00:00:00Z"}
+// The key is returned only in this response; only its hash is stored.
+func CreateAPIKeyHandler(c *fiber.Ctx) error {
+	var req CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	req.Scope = strings.ToLower(strings.TrimSpace(req.Scope))
+	if req.Name == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "name is required")
+	}
+	if !apikeys.ValidScope(req.Scope) {
+		return apierror.Send(c, fiber.StatusBadRequest, apikeys.ErrInvalidScope.Error())
+	}
+	if req.RateLimitPerMinute < 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "rate_limit_per_minute must not be negative")
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return apierror.Send(c, fiber.StatusBadRequest, "expires_at must be in the future")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, raw, err := apikeys.Create(ctx, req.Name, req.Scope, req.RateLimitPerMinute, req.ExpiresAt)
+	if err != nil {
+		return apiKeyError(c, err, "Failed to create API key")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "API key created. Store it now; it cannot be shown again",
+		"key":     raw,
+		"api_key": key,
+	})
+}
+
+// GetAPIKeysHandler handles GET /api/admin/api-keys
+func GetAPIKeysHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	keys, err := apikeys.List(ctx)
+	if err != nil {
+		return apiKeyError(c, err, "Failed to fetch API keys")
+	}
+
+	return c.JSON(fiber.Map{
+		"count":    len(keys),
+		"api_keys": keys,
+	})
+}
+
+// RevokeAPIKeyHandler handles DELETE /api/admin/api-keys/:id
+// The key stops working immediately; its record and usage are kept
+func RevokeAPIKeyHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid API key ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, err := apikeys.Revoke(ctx, id)
+	if err != nil {
+		return apiKeyError(c, err, "Failed to revoke API key")
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "API key revoked",
+		"api_key": key,
+	})
+}
+
+// GetAPIKeyUsageHandler handles GET /api/admin/api-keys/:id/usage?days=7
+// Returns requests per day and route over the last days days (default 7, at most 90)
+func GetAPIKeyUsageHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid API key ID")
+	}
+	days := c.QueryInt("days", 7)
+	if days < 1 || days > 90 {
+		return apierror.Send(c, fiber.StatusBadRequest, "days must be between 1 and 90")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, err := apikeys.Get(ctx, id)
+	if err != nil {
+		return apiKeyError(c, err, "Failed to fetch API key")
+	}
+	usage, err := apikeys.UsageSince(ctx, id, days)
+	if err != nil {
+		return apiKeyError(c, err, "Failed to fetch API key usage")
+	}
+
+	total := 0
+	for _, u := range usage {
+		total += u.RequestCount
+	}
+
+	return c.JSON(fiber.Map{
+		"api_key":        key,
+		"days":           days,
+		"total_requests": total,
+		"usage":          usage,
+	})
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/apikeys"
+	"mcq-exam/archive"
+	"mcq-exam/logging"
+	"mcq-exam/middleware"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ArchiveExamRequest names an archive; both fields are optional
+type ArchiveExamRequest struct {
+	Title string `json:"title"`
+	Notes string `json:"notes"`
+}
+
+// ArchiveExamHandler handles POST /api/admin/exams/:id/archive
+// Snapshots the results, leaderboards and statistics of event :id (an event_schedule id)
+// into the archive tables, which survive POST /api/admin/reset-db. Only the latest event
+// can be archived; archiving it again replaces its archive, taking in sessions completed since.
+func ArchiveExamHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid event ID")
+	}
+
+	var req ArchiveExamRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+	}
+	req.Title = strings.TrimSpace(req.Title)
+	req.Notes = strings.TrimSpace(req.Notes)
+	if len(req.Title) > 255 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Title must be at most 255 characters")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	created, replaced, err := archive.Create(ctx, id, req.Title, req.Notes, c.IP())
+	switch {
+	case errors.Is(err, archive.ErrEventNotFound):
+		return apierror.Send(c, fiber.StatusNotFound, "Event not found")
+	case errors.Is(err, archive.ErrNotLatest):
+		return apierror.Send(c, fiber.StatusConflict, "Only the latest event can be archived; earlier results are no longer in the database")
+	case err != nil:
+		logging.Ctx(c).Error().Err(err).Int("event_schedule_id", id).Msg("Failed to archive exam")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to archive exam")
+	}
+
+	logging.Ctx(c).Info().Int("event_schedule_id", id).Int("archive_id", created.ID).
+		Int("participants", created.Participants).Bool("replaced", replaced).Msg("Exam archived")
+
+	status := fiber.StatusCreated
+	if replaced {
+		status = fiber.StatusOK
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"message":  "Exam archived",
+		"replaced": replaced,
+		"archive":  created,
+	})
+}
+
+// ListArchivesHandler handles GET /api/archives
+// Lists the archived events, the latest first
+func ListArchivesHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	archives, err := archive.List(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to list archives")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to list archives")
+	}
+
+	return c.JSON(fiber.Map{
+		"count":    len(archives),
+		"archives": archives,
+	})
+}
+
+// GetArchiveHandler handles GET /api/archives/:id
+// Returns an archive with its ranking criteria, sections and statistics
+func GetArchiveHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid archive ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	found, err := archive.Get(ctx, id)
+	if errors.Is(err, archive.ErrNotFound) {
+		return apierror.Send(c, fiber.StatusNotFound, "Archive not found")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("archive_id", id).Msg("Failed to get archive")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to get archive")
+	}
+	return c.JSON(found)
+}
+
+// archiveIncludePII reads ?include_pii, as GET /api/results does. Archives are read with a
+// stats key, so names and full emails take an admin-scoped key, as GET /api/results does;
+// status and message are set when the value is invalid or the key may not see them.
+func archiveIncludePII(c *fiber.Ctx) (includePII bool, status int, message string) {
+	value := c.Query("include_pii")
+	if value == "" {
+		return false, 0, ""
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fiber.StatusBadRequest, "include_pii must be true or false"
+	}
+	if parsed && !middleware.KeyAllows(c, apikeys.ScopeAdmin) {
+		return false, fiber.StatusForbidden, "include_pii requires an admin-scoped API key"
+	}
+	return parsed, 0, ""
+}
+
+// GetArchiveResultsHandler handles GET /api/archives/:id/results?limit=100&offset=0&include_pii=true
+// Returns a page of the archived results in rank order, with each student's section results.
+// Emails are masked and names left out unless include_pii is set.
+func GetArchiveResultsHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid archive ID")
+	}
+	limit, offset, message := leaderboardPage(c)
+	if message != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, message)
+	}
+	includePII, status, message := archiveIncludePII(c)
+	if message != "" {
+		return apierror.Send(c, status, message)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	results, total, err := archive.Results(ctx, id, limit, offset)
+	if errors.Is(err, archive.ErrNotFound) {
+		return apierror.Send(c, fiber.StatusNotFound, "Archive not found")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("archive_id", id).Msg("Failed to get archived results")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to get archived results")
+	}
+	if !includePII {
+		for i := range results {
+			results[i].Name = ""
+			results[i].Email = maskEmail(results[i].Email)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"archive_id":  id,
+		"total":       total,
+		"limit":       limit,
+		"offset":      offset,
+		"include_pii": includePII,
+		"results":     results,
+	})
+}
+
+// GetArchiveSectionHandler handles GET /api/archives/:id/sections/:section_id?limit=100&offset=0&include_pii=true
+// Returns a page of the archived leaderboard of one section. Emails are masked and names
+// left out unless include_pii is set.
+func GetArchiveSectionHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid archive ID")
+	}
+	sectionID, err := c.ParamsInt("section_id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid section ID")
+	}
+	limit, offset, message := leaderboardPage(c)
+	if message != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, message)
+	}
+	includePII, status, message := archiveIncludePII(c)
+	if message != "" {
+		return apierror.Send(c, status, message)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	entries, total, err := archive.SectionLeaderboard(ctx, id, sectionID, limit, offset)
+	if errors.Is(err, archive.ErrNotFound) {
+		return apierror.Send(c, fiber.StatusNotFound, "Archive not found")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("archive_id", id).Int("section_id", sectionID).Msg("Failed to get archived section leaderboard")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to get archived section leaderboard")
+	}
+	if !includePII {
+		for i := range entries {
+			entries[i].Name = ""
+			entries[i].Email = maskEmail(entries[i].Email)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"archive_id":  id,
+		"section_id":  sectionID,
+		"total":       total,
+		"limit":       limit,
+		"offset":      offset,
+		"include_pii": includePII,
+		"entries":     entries,
+	})
+}
+
+// DeleteArchiveHandler handles DELETE /api/admin/archives/:id
+func DeleteArchiveHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid archive ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := archive.Delete(ctx, id); errors.Is(err, archive.ErrNotFound) {
+		return apierror.Send(c, fiber.StatusNotFound, "Archive not found")
+	} else if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("archive_id", id).Msg("Failed to delete archive")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to delete archive")
+	}
+
+	logging.Ctx(c).Info().Int("archive_id", id).Msg("Archive deleted")
+	return c.JSON(fiber.Map{"message": "Archive deleted", "archive_id": id})
+}
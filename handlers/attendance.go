@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/jobs"
+	"mcq-exam/logging"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// attendanceStreamInterval is how often the attendance stream checks for new attendees
+	attendanceStreamInterval = 5 * time.Second
+	// maxAttendanceWindow bounds the per-minute series
+	maxAttendanceWindow = 24 * time.Hour
+	// defaultAttendanceWindow is the series length when from is not given
+	defaultAttendanceWindow = 2 * time.Hour
+)
+
+type AttendancePoint struct {
+	Minute     time.Time `json:"minute"`
+	Attended   int       `json:"attended"`
+	Cumulative int       `json:"cumulative"`
+}
+
+type AttendanceSnapshot struct {
+	Invited          int               `json:"invited"`
+	Attended         int               `json:"attended"`
+	NotAttended      int               `json:"not_attended"`
+	AttendanceRate   float64           `json:"attendance_rate"`
+	LastMinute       int               `json:"last_minute"`
+	LastFiveMinutes  int               `json:"last_five_minutes"`
+	FirstAttendedAt  *time.Time        `json:"first_attended_at"`
+	LatestAttendedAt *time.Time        `json:"latest_attended_at"`
+	From             time.Time         `json:"from"`
+	To               time.Time         `json:"to"`
+	Series           []AttendancePoint `json:"series"`
+	GeneratedAt      time.Time         `json:"generated_at"`
+}
+
+// GetLiveAttendanceHandler handles GET /api/event/attendance/live?from=2025-10-08T09:00:00Z&to=2025-10-08T11:00:00Z
+// Returns conference attendance counts plus attendees per minute between from and to
+// (defaults to the last 2 hours, at most 24 hours). With ?stream=true (or Accept:
+// text/event-stream) the response is a server-sent event stream that sends a new snapshot
+// whenever someone joins, with the window's end following the clock.
+func GetLiveAttendanceHandler(c *fiber.Ctx) error {
+	stream := c.QueryBool("stream", false) || strings.Contains(c.Get(fiber.HeaderAccept), "text/event-stream")
+
+	to := time.Now()
+	if value := c.Query("to"); value != "" {
+		if stream {
+			return apierror.Send(c, fiber.StatusBadRequest, "to cannot be combined with stream")
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "to must be an RFC3339 timestamp")
+		}
+		to = parsed
+	}
+
+	from := to.Add(-defaultAttendanceWindow)
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "from must be an RFC3339 timestamp")
+		}
+		from = parsed
+	}
+
+	if !from.Before(to) {
+		return apierror.Send(c, fiber.StatusBadRequest, "from must be before to")
+	}
+	if to.Sub(from) > maxAttendanceWindow {
+		return apierror.Send(c, fiber.StatusBadRequest, "Time range cannot exceed 24 hours")
+	}
+
+	if !stream {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		snapshot, err := loadAttendance(ctx, from, to)
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to fetch attendance")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch attendance")
+		}
+		return c.JSON(snapshot)
+	}
+
+	setSSEHeaders(c)
+
+	// The body is streamed after the handler returns, so keep the logger rather than the context
+	logger := logging.Ctx(c)
+	shutdown := jobs.Context()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ticker := time.NewTicker(attendanceStreamInterval)
+		defer ticker.Stop()
+
+		lastAttended := -1
+		var lastLatest time.Time
+		for {
+			// The window grows with the clock; past 24 hours it slides
+			now := time.Now()
+			start := from
+			if now.Sub(start) > maxAttendanceWindow {
+				start = now.Add(-maxAttendanceWindow)
+			}
+
+			ctx, cancel := context.WithTimeout(shutdown, 10*time.Second)
+			snapshot, err := loadAttendance(ctx, start, now)
+			cancel()
+
+			var writeErr error
+			switch {
+			case err != nil:
+				logger.Error().Err(err).Msg("Failed to fetch attendance for stream")
+				writeErr = writeSSEComment(w, "attendance unavailable")
+			case snapshot.Attended != lastAttended || !timeEqual(snapshot.LatestAttendedAt, lastLatest):
+				lastAttended = snapshot.Attended
+				if snapshot.LatestAttendedAt != nil {
+					lastLatest = *snapshot.LatestAttendedAt
+				}
+				writeErr = writeSSEEvent(w, "attendance", snapshot)
+			default:
+				// Keeps proxies from closing an idle stream and notices a client that went away
+				writeErr = writeSSEComment(w, "ping")
+			}
+			if writeErr != nil {
+				return
+			}
+
+			select {
+			case <-ticker.C:
+			case <-shutdown.Done():
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func timeEqual(t *time.Time, other time.Time) bool {
+	if t == nil {
+		return other.IsZero()
+	}
+	return t.Equal(other)
+}
+
+// loadAttendance counts conference attendance of real (non-sandbox) students. A student counts
+// once, at their earliest conference_attended_at across email types.
+func loadAttendance(ctx context.Context, from, to time.Time) (*AttendanceSnapshot, error) {
+	// Align to whole minutes so buckets line up with date_trunc
+	from = from.Truncate(time.Minute)
+	to = to.Truncate(time.Minute).Add(time.Minute)
+
+	snapshot := &AttendanceSnapshot{From: from, To: to, Series: []AttendancePoint{}, GeneratedAt: time.Now()}
+	countQuery := `
+		WITH attendees AS (
+			SELECT et.student_id, MIN(et.conference_attended_at) AS attended_at
+			FROM email_tracking et
+			JOIN students s ON s.id = et.student_id AND s.is_sandbox = false
+			WHERE et.conference_attended = true
+			GROUP BY et.student_id
+		)
+		SELECT
+			(SELECT COUNT(DISTINCT et.student_id) FROM email_tracking et
+			 JOIN students s ON s.id = et.student_id AND s.is_sandbox = false
+			 WHERE et.conference_token IS NOT NULL),
+			COUNT(*),
+			COUNT(*) FILTER (WHERE attended_at >= NOW() - interval '1 minute'),
+			COUNT(*) FILTER (WHERE attended_at >= NOW() - interval '5 minutes'),
+			MIN(attended_at),
+			MAX(attended_at)
+		FROM attendees
+	`
+	err := db.Pool.QueryRow(ctx, countQuery).Scan(&snapshot.Invited, &snapshot.Attended, &snapshot.LastMinute,
+		&snapshot.LastFiveMinutes, &snapshot.FirstAttendedAt, &snapshot.LatestAttendedAt)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.Invited > snapshot.Attended {
+		snapshot.NotAttended = snapshot.Invited - snapshot.Attended
+	}
+	if snapshot.Invited > 0 {
+		snapshot.AttendanceRate = float64(snapshot.Attended) / float64(snapshot.Invited)
+	}
+
+	// Per-minute buckets, including minutes nobody joined; cumulative counts everyone who
+	// joined up to the end of the minute, including before from
+	seriesQuery := `
+		WITH attendees AS (
+			SELECT et.student_id, MIN(et.conference_attended_at) AS attended_at
+			FROM email_tracking et
+			JOIN students s ON s.id = et.student_id AND s.is_sandbox = false
+			WHERE et.conference_attended = true AND et.conference_attended_at IS NOT NULL
+			GROUP BY et.student_id
+		),
+		buckets AS (
+			SELECT generate_series($1::timestamptz, $2::timestamptz - interval '1 minute', interval '1 minute') AS minute
+		),
+		per_minute AS (
+			SELECT date_trunc('minute', attended_at) AS minute, COUNT(*) AS total
+			FROM attendees
+			WHERE attended_at >= $1 AND attended_at < $2
+			GROUP BY 1
+		)
+		SELECT b.minute,
+		       COALESCE(p.total, 0),
+		       ((SELECT COUNT(*) FROM attendees WHERE attended_at < $1) + SUM(COALESCE(p.total, 0)) OVER (ORDER BY b.minute))::int
+		FROM buckets b
+		LEFT JOIN per_minute p ON p.minute = b.minute
+		ORDER BY b.minute
+	`
+	rows, err := db.Pool.Query(ctx, seriesQuery, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var point AttendancePoint
+		if err := rows.Scan(&point.Minute, &point.Attended, &point.Cumulative); err != nil {
+			return nil, err
+		}
+		snapshot.Series = append(snapshot.Series, point)
+	}
+	return snapshot, rows.Err()
+}
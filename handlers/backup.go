@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/backup"
+	"mcq-exam/emailcheck"
+	"mcq-exam/jobs"
+	"mcq-exam/logging"
+	"mcq-exam/sessioncache"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// backupTimeout bounds one export or import
+const backupTimeout = 30 * time.Minute
+
+// ExportBackupHandler handles GET /api/admin/export?format=json (or format=sql)
+// Streams a timestamped backup of students, email tracking, sessions, answers and section
+// results as a download. json is read in one consistent snapshot and can be restored with
+// POST /api/admin/import; sql is a data-only pg_dump for psql.
+func ExportBackupHandler(c *fiber.Ctx) error {
+	format := c.Query("format", "json")
+	if format != "json" && format != "sql" {
+		return apierror.Send(c, fiber.StatusBadRequest, "format must be json or sql")
+	}
+	if format == "sql" && !backup.PgDumpAvailable() {
+		return apierror.Send(c, fiber.StatusServiceUnavailable, "pg_dump is not installed on this server; use format=json")
+	}
+
+	filename := fmt.Sprintf("nicm-backup-%s.%s", time.Now().Format("20060102-150405"), format)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if format == "json" {
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	} else {
+		c.Set(fiber.HeaderContentType, "application/sql; charset=utf-8")
+	}
+
+	// The body is streamed after the handler returns, so keep the logger rather than the context
+	logger := logging.Ctx(c)
+	shutdown := jobs.Context()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithTimeout(shutdown, backupTimeout)
+		defer cancel()
+
+		started := time.Now()
+		write := backup.WriteJSON
+		if format == "sql" {
+			write = backup.WriteSQL
+		}
+		// Headers are already sent, so a failure can only cut the download short
+		if err := write(ctx, w); err != nil {
+			logger.Error().Err(err).Str("format", format).Msg("Failed to export backup")
+			return
+		}
+		if err := w.Flush(); err != nil {
+			logger.Error().Err(err).Str("format", format).Msg("Failed to send backup")
+			return
+		}
+		logger.Info().Str("format", format).Dur("duration", time.Since(started)).Msg("Backup exported")
+	})
+	return nil
+}
+
+// ImportBackupHandler handles POST /api/admin/import
+// Body: a JSON backup from GET /api/admin/export?format=json
+// Replaces students, email tracking, sessions, answers and section results with the backup's
+// rows. Only allowed with BACKUP_IMPORT_ENABLED=true, which is meant for staging.
+func ImportBackupHandler(c *fiber.Ctx) error {
+	if !backup.ImportEnabled() {
+		return apierror.Send(c, fiber.StatusForbidden, "Imports are disabled; set BACKUP_IMPORT_ENABLED=true to allow them")
+	}
+	if len(c.Body()) == 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Request body must be a JSON backup")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backupTimeout)
+	defer cancel()
+
+	started := time.Now()
+	counts, err := backup.Restore(ctx, bytes.NewReader(c.Body()))
+	if errors.Is(err, backup.ErrInvalidArchive) {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, backup.ErrSchemaMismatch) {
+		return apierror.Send(c, fiber.StatusConflict, err.Error())
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to import backup")
+		return apierror.Respond(c, fiber.StatusInternalServerError, apierror.CodeInternal,
+			"Failed to import backup", fiber.Map{"error": err.Error()})
+	}
+
+	// Cached session tokens would point at sessions that were replaced
+	sessioncache.Clear(ctx)
+
+	// Imported addresses are checked like created ones; the import stands if this fails
+	response := fiber.Map{
+		"success":          true,
+		"message":          "Backup imported successfully",
+		"counts":           counts,
+		"duration_seconds": time.Since(started).Seconds(),
+	}
+	report, err := emailcheck.Revalidate(ctx, emailcheck.MXEnabled())
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to validate imported student emails")
+	} else {
+		response["email_validation"] = report
+	}
+
+	logging.Ctx(c).Info().Interface("counts", counts).Msg("Backup imported")
+	return c.JSON(response)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/campaigns"
+	"mcq-exam/logging"
+	"mcq-exam/personalize"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CampaignVariablesRecipient struct {
+	StudentID int            `json:"student_id"`
+	Variables map[string]any `json:"variables"`
+}
+
+type SetCampaignVariablesRequest struct {
+	Recipients []CampaignVariablesRecipient `json:"recipients"`
+}
+
+// SetCampaignVariablesHandler handles PUT /api/mail/campaigns/:id/variables
+// Body: {"recipients": [{"student_id": 12, "variables": {"seat": "A12", "slot": 2}}, {"student_id": 13, "variables": {}}]}
+// Sets each student's custom variables, used as {{seat}} in the campaign's subject and body;
+// empty variables remove a student's. Allowed until the campaign completes; pending
+// recipients get the values current when they are sent.
+func SetCampaignVariablesHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid campaign ID")
+	}
+	var req SetCampaignVariablesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if len(req.Recipients) == 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "recipients is required")
+	}
+	if len(req.Recipients) > campaigns.MaxVariableRecipients {
+		return apierror.Send(c, fiber.StatusBadRequest, fmt.Sprintf("At most %d recipients per request", campaigns.MaxVariableRecipients))
+	}
+	payloads := make(map[int]map[string]any, len(req.Recipients))
+	for _, recipient := range req.Recipients {
+		if _, ok := payloads[recipient.StudentID]; ok {
+			return apierror.Send(c, fiber.StatusBadRequest, fmt.Sprintf("student %d is listed twice", recipient.StudentID))
+		}
+		payloads[recipient.StudentID] = recipient.Variables
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	set, removed, err := campaigns.SetVariables(ctx, id, payloads)
+	if err != nil {
+		return campaignError(c, err, "Failed to set campaign variables")
+	}
+	logging.Ctx(c).Info().Int("campaign_id", id).Int("set", set).Int("removed", removed).Msg("Campaign variables set")
+
+	return c.JSON(fiber.Map{
+		"campaign_id": id,
+		"set":         set,
+		"removed":     removed,
+	})
+}
+
+// GetCampaignVariablesHandler handles GET /api/mail/campaigns/:id/variables?limit=100&offset=0
+// Lists the custom variables by student, with the built-in variables every email can use
+func GetCampaignVariablesHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid campaign ID")
+	}
+	limit := c.QueryInt("limit", 100)
+	offset := c.QueryInt("offset", 0)
+	if limit < 1 || limit > 1000 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Limit must be between 1 and 1000")
+	}
+	if offset < 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Offset must not be negative")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	campaign, err := campaigns.Get(ctx, id)
+	if err != nil {
+		return campaignError(c, err, "Failed to fetch campaign")
+	}
+	recipients, total, err := campaigns.Variables(ctx, id, limit, offset)
+	if err != nil {
+		return campaignError(c, err, "Failed to fetch campaign variables")
+	}
+
+	return c.JSON(fiber.Map{
+		"campaign_id":      id,
+		"strict_variables": campaign.StrictVariables,
+		"student_fields":   personalize.StudentFields,
+		"computed":         personalize.Computed,
+		"recipients":       recipients,
+		"total":            total,
+		"limit":            limit,
+		"offset":           offset,
+	})
+}
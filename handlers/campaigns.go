@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/campaigns"
+	"mcq-exam/db"
+	"mcq-exam/i18n"
+	"mcq-exam/logging"
+	"mcq-exam/personalize"
+	"mcq-exam/segments"
+	"mcq-exam/tracking"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+type CreateCampaignRequest struct {
+	Name            string `json:"name"`
+	Subject         string `json:"subject"`
+	HTMLBody        string `json:"html_body"`
+	Segment         string `json:"segment"`
+	SourceEmailType string `json:"source_email_type"`
+	// Filter narrows the segment, e.g. "country=IN AND tag=vip"; see package segments
+	Filter string `json:"filter"`
+	// Variants replace subject and html_body for students in their locale; TemplateKey
+	// starts from a stored template's variants
+	Variants    i18n.Variants `json:"variants"`
+	TemplateKey string        `json:"template_key"`
+	// Strict fails recipients whose email would go out with a variable left empty
+	Strict bool `json:"strict"`
+	// Tracking adds the open pixel and click tracking (default true)
+	Tracking *bool `json:"tracking"`
+}
+
+// campaignOptions reads the sending options of a create request
+func campaignOptions(strict bool, tracking *bool) campaigns.Options {
+	return campaigns.Options{StrictVariables: strict, Untracked: tracking != nil && !*tracking}
+}
+
+// optionalFilter is nil for a blank segment filter, so it is stored as NULL
+func optionalFilter(filter string) *string {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return nil
+	}
+	return &filter
+}
+
+// validateSegment checks the segment and its source email type, returning an error message
+func validateSegment(segment, sourceEmailType string) string {
+	if !campaigns.IsValidSegment(segment) {
+		return fmt.Sprintf("Unknown segment '%s'. Valid segments: %s", segment, strings.Join(campaigns.Segments, ", "))
+	}
+	if segment == campaigns.SegmentNotOpened && sourceEmailType == "" {
+		return "source_email_type is required for the not_opened segment (e.g. firstMail, broadcast, campaign-3)"
+	}
+	return ""
+}
+
+// campaignError maps campaign package errors to responses
+func campaignError(c *fiber.Ctx, err error, message string) error {
+	var stateErr *campaigns.StateError
+	switch {
+	case errors.Is(err, campaigns.ErrNotFound):
+		return apierror.Send(c, fiber.StatusNotFound, "Campaign not found")
+	case errors.Is(err, campaigns.ErrNoRecipients):
+		return apierror.Send(c, fiber.StatusBadRequest, "Segment matches no students")
+	case errors.Is(err, segments.ErrInvalidFilter), errors.Is(err, personalize.ErrInvalidPayload), errors.Is(err, campaigns.ErrUnknownStudent):
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	case errors.As(err, &stateErr):
+		return apierror.Send(c, fiber.StatusConflict, fmt.Sprintf("Cannot %s a %s campaign", stateErr.Action, stateErr.Status))
+	}
+	logging.Ctx(c).Error().Err(err).Msg(message)
+	return apierror.Send(c, fiber.StatusInternalServerError, message)
+}
+
+// CreateCampaignHandler handles POST /api/mail/campaigns
+// Creates a draft campaign; {{variables}} in the subject and html_body are filled in per
+// recipient (see package personalize and PUT /api/mail/campaigns/:id/variables)
+func CreateCampaignHandler(c *fiber.Ctx) error {
+	var req CreateCampaignRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	req.Segment = campaigns.NormalizeSegment(req.Segment)
+	req.SourceEmailType = string(tracking.ParseEmailType(req.SourceEmailType))
+	// A filter alone selects from every student
+	if req.Segment == "" && strings.TrimSpace(req.Filter) != "" {
+		req.Segment = campaigns.SegmentAll
+	}
+	if req.Name == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "name is required")
+	}
+	if strings.TrimSpace(req.Subject) == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "subject is required")
+	}
+	if strings.TrimSpace(req.HTMLBody) == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "html_body is required")
+	}
+	if msg := validateSegment(req.Segment, req.SourceEmailType); msg != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, msg)
+	}
+
+	var sourceEmailType *string
+	if req.Segment == campaigns.SegmentNotOpened {
+		sourceEmailType = &req.SourceEmailType
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	variants, err := i18n.BuildVariants(ctx, strings.TrimSpace(req.TemplateKey), req.Variants)
+	if err != nil {
+		return i18nError(c, err, "Failed to load template variants")
+	}
+
+	campaign, err := campaigns.Create(ctx, req.Name, req.Subject, req.HTMLBody, variants, req.Segment, sourceEmailType, optionalFilter(req.Filter), campaignOptions(req.Strict, req.Tracking))
+	if err != nil {
+		return campaignError(c, err, "Failed to create campaign")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(campaign)
+}
+
+// GetCampaignsHandler handles GET /api/mail/campaigns?status=running
+func GetCampaignsHandler(c *fiber.Ctx) error {
+	status := c.Query("status")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	list, err := campaigns.List(ctx, status)
+	if err != nil {
+		return campaignError(c, err, "Failed to fetch campaigns")
+	}
+
+	return c.JSON(fiber.Map{
+		"count":     len(list),
+		"campaigns": list,
+	})
+}
+
+// GetCampaignHandler handles GET /api/mail/campaigns/:id
+func GetCampaignHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid campaign ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	campaign, err := campaigns.Get(ctx, id)
+	if err != nil {
+		return campaignError(c, err, "Failed to fetch campaign")
+	}
+
+	return c.JSON(campaign)
+}
+
+// PreviewSegmentHandler handles GET /api/mail/campaigns/preview?segment=not_opened&source_email_type=firstMail&filter=tag=vip
+// Counts who a segment, narrowed by the optional filter, would reach before a campaign is created
+func PreviewSegmentHandler(c *fiber.Ctx) error {
+	segment := campaigns.NormalizeSegment(c.Query("segment"))
+	sourceEmailType := strings.TrimSpace(c.Query("source_email_type"))
+	filter := optionalFilter(c.Query("filter"))
+	if segment == "" && filter != nil {
+		segment = campaigns.SegmentAll
+	}
+	if msg := validateSegment(segment, sourceEmailType); msg != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, msg)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	preview, err := campaigns.PreviewSegment(ctx, segment, &sourceEmailType, filter)
+	if err != nil {
+		return campaignError(c, err, "Failed to preview segment")
+	}
+
+	return c.JSON(preview)
+}
+
+// PreviewCampaignHandler handles GET /api/mail/campaigns/:id/preview
+// Counts who the campaign's segment matches now; launch snapshots the same query
+func PreviewCampaignHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid campaign ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	campaign, err := campaigns.Get(ctx, id)
+	if err != nil {
+		return campaignError(c, err, "Failed to fetch campaign")
+	}
+
+	preview, err := campaigns.PreviewSegment(ctx, campaign.Segment, campaign.SourceEmailType, campaign.Filter)
+	if err != nil {
+		return campaignError(c, err, "Failed to preview segment")
+	}
+
+	return c.JSON(fiber.Map{
+		"campaign_id": campaign.ID,
+		"status":      campaign.Status,
+		"preview":     preview,
+	})
+}
+
+// GetCampaignRecipientsHandler handles GET /api/mail/campaigns/:id/recipients?status=failed&limit=100&offset=0
+func GetCampaignRecipientsHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid campaign ID")
+	}
+
+	limit := c.QueryInt("limit", 100)
+	offset := c.QueryInt("offset", 0)
+	if limit < 1 || limit > 1000 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Limit must be between 1 and 1000")
+	}
+	status := c.Query("status")
+	switch status {
+	case "", campaigns.RecipientPending, campaigns.RecipientSent, campaigns.RecipientFailed, campaigns.RecipientSkipped:
+	default:
+		return apierror.Send(c, fiber.StatusBadRequest, "status must be pending, sent, failed or skipped")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := campaigns.Get(ctx, id); err != nil {
+		return campaignError(c, err, "Failed to fetch campaign")
+	}
+
+	recipients, total, err := campaigns.Recipients(ctx, id, status, limit, offset)
+	if err != nil {
+		return campaignError(c, err, "Failed to fetch recipients")
+	}
+
+	return c.JSON(fiber.Map{
+		"campaign_id": id,
+		"recipients":  recipients,
+		"total":       total,
+		"limit":       limit,
+		"offset":      offset,
+		"count":       len(recipients),
+	})
+}
+
+// LaunchCampaignHandler handles POST /api/mail/campaigns/:id/launch
+// Snapshots the segment's students as recipients and sends in the background
+func LaunchCampaignHandler(c *fiber.Ctx) error {
+	return campaignAction(c, campaigns.Launch, "Campaign launched", "Failed to launch campaign")
+}
+
+// PauseCampaignHandler handles POST /api/mail/campaigns/:id/pause
+func PauseCampaignHandler(c *fiber.Ctx) error {
+	return campaignAction(c, campaigns.Pause, "Campaign paused", "Failed to pause campaign")
+}
+
+// ResumeCampaignHandler handles POST /api/mail/campaigns/:id/resume
+func ResumeCampaignHandler(c *fiber.Ctx) error {
+	return campaignAction(c, campaigns.Resume, "Campaign resumed", "Failed to resume campaign")
+}
+
+// campaignAction runs a status change and returns the updated campaign
+func campaignAction(c *fiber.Ctx, action func(context.Context, int) (*campaigns.Campaign, error), message, failure string) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid campaign ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	campaign, err := action(ctx, id)
+	if err != nil {
+		return campaignError(c, err, failure)
+	}
+	logging.Ctx(c).Info().Int("campaign_id", id).Str("status", campaign.Status).Msg(message)
+
+	return c.JSON(fiber.Map{
+		"message":  message,
+		"campaign": campaign,
+	})
+}
+
+// DeleteCampaignHandler handles DELETE /api/mail/campaigns/:id
+// Running campaigns must be paused first
+func DeleteCampaignHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid campaign ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := campaigns.Delete(ctx, id); err != nil {
+		return campaignError(c, err, "Failed to delete campaign")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+type SendResultsRequest struct {
+	Name     string `json:"name"`
+	Subject  string `json:"subject"`
+	HTMLBody string `json:"html_body"`
+	// Variants and TemplateKey work as for campaigns; with the default text the stored
+	// results template's variants are used
+	Variants    i18n.Variants `json:"variants"`
+	TemplateKey string        `json:"template_key"`
+	// Strict and Tracking work as for campaigns
+	Strict   bool  `json:"strict"`
+	Tracking *bool `json:"tracking"`
+}
+
+// SendResultsHandler handles POST /api/mail/send-results
+// Emails every student with a completed session their scorecard (score, rank, section
+// breakdown, certificate link). Runs as a results campaign, so progress, pause and resume
+// go through /api/mail/campaigns/:id. All fields are optional.
+func SendResultsHandler(c *fiber.Ctx) error {
+	var req SendResultsRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	req.TemplateKey = strings.TrimSpace(req.TemplateKey)
+	if req.Name == "" {
+		req.Name = "Results " + time.Now().Format("2006-01-02 15:04")
+	}
+	if strings.TrimSpace(req.Subject) == "" && strings.TrimSpace(req.HTMLBody) == "" && req.TemplateKey == "" {
+		req.TemplateKey = i18n.TemplateResults
+	}
+	if strings.TrimSpace(req.Subject) == "" {
+		req.Subject = campaigns.DefaultResultsSubject
+	}
+	if strings.TrimSpace(req.HTMLBody) == "" {
+		req.HTMLBody = campaigns.DefaultResultsBody
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	variants, err := resultsVariants(ctx, req.TemplateKey, req.Variants)
+	if err != nil {
+		return i18nError(c, err, "Failed to load template variants")
+	}
+
+	active, err := campaigns.ActiveResults(ctx)
+	if err != nil {
+		return campaignError(c, err, "Failed to check results campaigns")
+	}
+	if active != nil {
+		return apierror.Respond(c, fiber.StatusConflict, apierror.CodeConflict,
+			"A results email is already "+active.Status, fiber.Map{"campaign": active})
+	}
+
+	campaign, err := campaigns.CreateResults(ctx, req.Name, req.Subject, req.HTMLBody, variants, campaignOptions(req.Strict, req.Tracking))
+	if err != nil {
+		return campaignError(c, err, "Failed to create results campaign")
+	}
+
+	id := campaign.ID
+	campaign, err = campaigns.Launch(ctx, id)
+	if errors.Is(err, campaigns.ErrNoRecipients) {
+		// Nothing to send; drop the draft rather than leave it in the campaign list
+		if err := campaigns.Delete(ctx, id); err != nil {
+			logging.Ctx(c).Warn().Err(err).Int("campaign_id", id).Msg("Failed to delete empty results campaign")
+		}
+		return apierror.Send(c, fiber.StatusBadRequest, "No students have completed the test")
+	}
+	if err != nil {
+		return campaignError(c, err, "Failed to launch results campaign")
+	}
+	logging.Ctx(c).Info().Int("campaign_id", campaign.ID).Int("recipients", campaign.Recipients.Total).Msg("Results email started")
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message":  "Results email started",
+		"campaign": campaign,
+	})
+}
+
+// resultsVariants builds the variants of a results email. The results template may have
+// no stored variants, in which case everyone gets the default text.
+func resultsVariants(ctx context.Context, templateKey string, given i18n.Variants) (i18n.Variants, error) {
+	variants, err := i18n.BuildVariants(ctx, templateKey, given)
+	if errors.Is(err, i18n.ErrNotFound) && templateKey == i18n.TemplateResults {
+		return i18n.BuildVariants(ctx, "", given)
+	}
+	return variants, err
+}
+
+// PreviewResultsHandler handles GET /api/mail/send-results/preview?student_id=12
+// Renders the default results email for one student, in their locale, without sending it
+func PreviewResultsHandler(c *fiber.Ctx) error {
+	studentID := c.QueryInt("student_id", 0)
+	if studentID < 1 {
+		return apierror.Send(c, fiber.StatusBadRequest, "student_id is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var name, locale string
+	if err := db.Pool.QueryRow(ctx, `SELECT name, COALESCE(locale, '') FROM students WHERE id = $1`, studentID).Scan(&name, &locale); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return apierror.Send(c, fiber.StatusNotFound, "Student not found")
+		}
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch student")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch student")
+	}
+
+	card, err := campaigns.LoadScorecard(ctx, studentID)
+	if errors.Is(err, campaigns.ErrNoResult) {
+		return apierror.Send(c, fiber.StatusNotFound, "Student has no completed session")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load scorecard")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load scorecard")
+	}
+	if err := card.AttachResultsURL(ctx); err != nil {
+		logging.Ctx(c).Warn().Err(err).Int("student_id", studentID).Msg("Failed to create result link")
+	}
+
+	variants, err := i18n.TemplateVariants(ctx, i18n.TemplateResults)
+	if err != nil {
+		return i18nError(c, err, "Failed to load results template")
+	}
+	subject, htmlBody := i18n.Pick(variants, locale, campaigns.DefaultResultsSubject, campaigns.DefaultResultsBody)
+
+	return c.JSON(fiber.Map{
+		"scorecard": card,
+		"locale":    i18n.Resolve(locale),
+		"subject":   subject,
+		"html_body": campaigns.RenderScorecard(htmlBody, name, card),
+	})
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"log"
+	"mcq-exam/accesscodes"
+	"mcq-exam/apierror"
+	"mcq-exam/clientaudit"
 	"mcq-exam/db"
+	"mcq-exam/logging"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -27,17 +30,11 @@ type VerifyTokenResponse struct {
 func VerifyConferenceTokenHandler(c *fiber.Ctx) error {
 	var req VerifyTokenRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(VerifyTokenResponse{
-			Valid:   false,
-			Message: "Invalid request body",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
 	if req.Token == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(VerifyTokenResponse{
-			Valid:   false,
-			Message: "Token is required",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Token is required")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -46,14 +43,15 @@ func VerifyConferenceTokenHandler(c *fiber.Ctx) error {
 	// Find student by conference token
 	var studentID int
 	var attended bool
-	query := `SELECT student_id, conference_attended FROM email_tracking WHERE conference_token = $1 AND email_type = 'first'`
+	query := `SELECT student_id, conference_attended FROM email_tracking WHERE conference_token = $1 AND email_type = 'firstMail'`
 	err := db.Pool.QueryRow(ctx, query, req.Token).Scan(&studentID, &attended)
 
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(VerifyTokenResponse{
-			Valid:   false,
-			Message: "Invalid or expired token",
-		})
+		return apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeTokenInvalid, "Invalid or expired token")
+	}
+	client := clientaudit.Client{IP: c.IP(), UserAgent: c.Get(fiber.HeaderUserAgent)}
+	if err := clientaudit.Record(ctx, db.Pool, clientaudit.ActionConferenceToken, studentID, 0, client); err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Failed to record conference token verification")
 	}
 
 	// Get video URL from event schedule
@@ -61,21 +59,21 @@ func VerifyConferenceTokenHandler(c *fiber.Ctx) error {
 	scheduleQuery := `SELECT video_url FROM event_schedule ORDER BY id DESC LIMIT 1`
 	err = db.Pool.QueryRow(ctx, scheduleQuery).Scan(&videoURL)
 	if err != nil || videoURL == "" {
-		log.Printf("Failed to get video URL: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(VerifyTokenResponse{
-			Valid:   false,
-			Message: "Video URL not configured",
-		})
+		logging.Ctx(c).Error().Err(err).Msg("Failed to get video URL")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Video URL not configured")
 	}
 
-	// Mark as attended if not already
+	// Mark as attended and issue the access code (valid for ACCESS_CODE_TTL) if not already.
+	// Issue only applies to the request that flips the flag, so concurrent verifications
+	// cannot replace the access code it issued.
 	if !attended {
-		// Generate 6-character alphanumeric access code
-		accessCode := generateAccessCode()
-		updateQuery := `UPDATE email_tracking SET conference_attended = true, conference_attended_at = NOW(), access_code = $1, updated_at = NOW() WHERE conference_token = $2`
-		_, err = db.Pool.Exec(context.Background(), updateQuery, accessCode, req.Token)
+		_, err = accesscodes.Issue(ctx, studentID, accesscodes.EmailType, accesscodes.Audit{
+			IP:        c.IP(),
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+		})
 		if err != nil {
-			log.Printf("Failed to mark attendance: %v", err)
+			logging.Ctx(c).Error().Err(err).Msg("Failed to mark attendance")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to update verification status")
 		}
 	}
 
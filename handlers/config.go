@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"mcq-exam/config"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetConfigHandler handles GET /api/admin/config
+// Returns every known setting with its effective value. Secrets such as DATABASE_URL and
+// provider keys only report whether they are set.
+func GetConfigHandler(c *fiber.Ctx) error {
+	settings := config.Redacted()
+	return c.JSON(fiber.Map{
+		"count":    len(settings),
+		"settings": settings,
+	})
+}
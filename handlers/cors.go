@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/origins"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type AddOriginRequest struct {
+	Origin string `json:"origin"`
+	Note   string `json:"note"`
+}
+
+// originsError maps origins package errors to responses
+func originsError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, origins.ErrNotFound):
+		return apierror.Send(c, fiber.StatusNotFound, "Origin not found")
+	case errors.Is(err, origins.ErrExists):
+		return apierror.Send(c, fiber.StatusConflict, "Origin is already allowed")
+	case errors.Is(err, origins.ErrInvalidOrigin):
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	logging.Ctx(c).Error().Err(err).Msg(message)
+	return apierror.Send(c, fiber.StatusInternalServerError, message)
+}
+
+// GetCORSHandler handles GET /api/admin/cors
+// Returns the origins allowed by the environment and by the stored allow-list, whether other
+// origins are rejected, and the blocked origins this instance has seen since it started
+func GetCORSHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stored, err := origins.List(ctx)
+	if err != nil {
+		return originsError(c, err, "Failed to fetch origins")
+	}
+
+	return c.JSON(fiber.Map{
+		"configured": origins.Configured(),
+		"enforce":    origins.Enforce(),
+		"origins":    stored,
+		"blocked":    origins.RecentlyBlocked(),
+	})
+}
+
+// AddOriginHandler handles POST /api/admin/cors/origins
+// Body: {"origin": "https://quiz.partner.org", "note": "Partner-hosted frontend"}
+func AddOriginHandler(c *fiber.Ctx) error {
+	var req AddOriginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if strings.TrimSpace(req.Origin) == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "origin is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	origin, err := origins.Add(ctx, req.Origin, req.Note)
+	if err != nil {
+		return originsError(c, err, "Failed to save origin")
+	}
+	logging.Ctx(c).Info().Str("origin", origin.Origin).Msg("Allowed origin added")
+
+	return c.Status(fiber.StatusCreated).JSON(origin)
+}
+
+// RemoveOriginHandler handles DELETE /api/admin/cors/origins/:id
+func RemoveOriginHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid origin ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := origins.Remove(ctx, id); err != nil {
+		return originsError(c, err, "Failed to delete origin")
+	}
+	logging.Ctx(c).Info().Int("origin_id", id).Msg("Allowed origin removed")
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
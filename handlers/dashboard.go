@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxDashboardWindow caps the time series at one day of per-minute points
+const maxDashboardWindow = 24 * time.Hour
+
+type DashboardFunnel struct {
+	TotalStudents      int `json:"total_students"`
+	Invited            int `json:"invited"`
+	Opened             int `json:"opened"`
+	AttendedConference int `json:"attended_conference"`
+	StartedTest        int `json:"started_test"`
+	CompletedTest      int `json:"completed_test"`
+}
+
+type DashboardPoint struct {
+	Minute            time.Time `json:"minute"`
+	AnswersSubmitted  int       `json:"answers_submitted"`
+	SessionsStarted   int       `json:"sessions_started"`
+	SessionsCompleted int       `json:"sessions_completed"`
+}
+
+// GetAdminDashboardHandler handles GET /api/admin/dashboard?from=2025-10-08T16:00:00Z&to=2025-10-08T18:00:00Z
+// Returns the participation funnel plus per-minute activity between from and to
+// (defaults to the last 2 hours, at most 24 hours).
+func GetAdminDashboardHandler(c *fiber.Ctx) error {
+	to := time.Now()
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "to must be an RFC3339 timestamp")
+		}
+		to = parsed
+	}
+
+	from := to.Add(-2 * time.Hour)
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "from must be an RFC3339 timestamp")
+		}
+		from = parsed
+	}
+
+	if !from.Before(to) {
+		return apierror.Send(c, fiber.StatusBadRequest, "from must be before to")
+	}
+	if to.Sub(from) > maxDashboardWindow {
+		return apierror.Send(c, fiber.StatusBadRequest, "Time range cannot exceed 24 hours")
+	}
+
+	// Align to whole minutes so buckets line up with date_trunc
+	from = from.Truncate(time.Minute)
+	to = to.Truncate(time.Minute).Add(time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	funnel, err := loadDashboardFunnel(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch dashboard funnel")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch dashboard funnel")
+	}
+
+	// Per-minute buckets, including minutes with no activity
+	seriesQuery := `
+		WITH buckets AS (
+			SELECT generate_series($1::timestamptz, $2::timestamptz - interval '1 minute', interval '1 minute') AS minute
+		),
+		answer_counts AS (
+			SELECT date_trunc('minute', submitted_at) AS minute, COUNT(*) AS total
+			FROM answers
+			WHERE submitted_at >= $1 AND submitted_at < $2
+			  AND session_id NOT IN (SELECT id FROM sessions WHERE is_sandbox = true)
+			GROUP BY 1
+		),
+		start_counts AS (
+			SELECT date_trunc('minute', started_at) AS minute, COUNT(*) AS total
+			FROM sessions
+			WHERE started_at >= $1 AND started_at < $2 AND is_sandbox = false
+			GROUP BY 1
+		),
+		completion_counts AS (
+			SELECT date_trunc('minute', completed_at) AS minute, COUNT(*) AS total
+			FROM sessions
+			WHERE completed = true AND completed_at >= $1 AND completed_at < $2 AND is_sandbox = false
+			GROUP BY 1
+		)
+		SELECT
+			b.minute,
+			COALESCE(a.total, 0),
+			COALESCE(s.total, 0),
+			COALESCE(cc.total, 0)
+		FROM buckets b
+		LEFT JOIN answer_counts a ON a.minute = b.minute
+		LEFT JOIN start_counts s ON s.minute = b.minute
+		LEFT JOIN completion_counts cc ON cc.minute = b.minute
+		ORDER BY b.minute
+	`
+
+	rows, err := db.Pool.Query(ctx, seriesQuery, from, to)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch dashboard time series")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch dashboard time series")
+	}
+	defer rows.Close()
+
+	series := make([]DashboardPoint, 0)
+	for rows.Next() {
+		var point DashboardPoint
+		if err := rows.Scan(&point.Minute, &point.AnswersSubmitted, &point.SessionsStarted, &point.SessionsCompleted); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan row")
+			continue
+		}
+		series = append(series, point)
+	}
+
+	return c.JSON(fiber.Map{
+		"success":     true,
+		"from":        from,
+		"to":          to,
+		"funnel":      funnel,
+		"time_series": series,
+	})
+}
+
+// loadDashboardFunnel counts distinct students at each stage, across all tracked email types
+func loadDashboardFunnel(ctx context.Context) (DashboardFunnel, error) {
+	var funnel DashboardFunnel
+	funnelQuery := `
+		SELECT
+			(SELECT COUNT(*) FROM students WHERE is_sandbox = false),
+			(SELECT COUNT(DISTINCT et.student_id) FROM email_tracking et JOIN students s ON s.id = et.student_id AND s.is_sandbox = false),
+			(SELECT COUNT(DISTINCT et.student_id) FROM email_tracking et JOIN students s ON s.id = et.student_id AND s.is_sandbox = false WHERE et.opened = true),
+			(SELECT COUNT(DISTINCT et.student_id) FROM email_tracking et JOIN students s ON s.id = et.student_id AND s.is_sandbox = false WHERE et.conference_attended = true),
+			(SELECT COUNT(DISTINCT student_id) FROM sessions WHERE is_sandbox = false),
+			(SELECT COUNT(DISTINCT student_id) FROM sessions WHERE completed = true AND is_sandbox = false)
+	`
+	err := db.Pool.QueryRow(ctx, funnelQuery).Scan(
+		&funnel.TotalStudents, &funnel.Invited, &funnel.Opened,
+		&funnel.AttendedConference, &funnel.StartedTest, &funnel.CompletedTest,
+	)
+	return funnel, err
+}
@@ -0,0 +1,427 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/captcha"
+	"mcq-exam/config"
+	"mcq-exam/db"
+	"mcq-exam/emailcheck"
+	"mcq-exam/i18n"
+	"mcq-exam/logging"
+	"mcq-exam/models"
+	"mcq-exam/outbox"
+	"mcq-exam/suppression"
+	"mcq-exam/tracking"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+type ChangeEmailRequest struct {
+	CurrentEmail string `json:"current_email"`
+	NewEmail     string `json:"new_email"`
+	// Name, and Phone when the participant has one on file, must match the participant
+	Name  string `json:"name"`
+	Phone string `json:"phone"`
+	// CaptchaToken is the response of the CAPTCHA_PROVIDER widget
+	CaptchaToken string `json:"captcha_token"`
+}
+
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token"`
+}
+
+// emailChangeTokenTTL is how long an email change link stays valid (EMAIL_CHANGE_TOKEN_TTL, default 24h)
+func emailChangeTokenTTL() time.Duration {
+	ttl := 24 * time.Hour
+	if value := os.Getenv("EMAIL_CHANGE_TOKEN_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Warn().Msgf("Invalid EMAIL_CHANGE_TOKEN_TTL=%q, using %s", value, ttl)
+		} else {
+			ttl = parsed
+		}
+	}
+	return ttl
+}
+
+// emailChangeStudent is the participant a change-email request names
+type emailChangeStudent struct {
+	ID         int
+	Name       string
+	Email      string
+	Phone      string
+	Locale     string
+	EmailValid bool
+}
+
+// sameName compares names ignoring letter case and spacing
+func sameName(a, b string) bool {
+	return strings.EqualFold(strings.Join(strings.Fields(a), " "), strings.Join(strings.Fields(b), " "))
+}
+
+// phoneDigits keeps the digits of a phone number, so formatting does not matter
+func phoneDigits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// ChangeEmailHandler handles POST /api/participants/change-email
+// Body: {"current_email": "...", "new_email": "...", "name": "...", "phone": "...", "captcha_token": "..."}.
+// Emails a confirmation link to the new address of the participant with current_email,
+// when the name (and the phone, if one is on file) match; the address changes once the link
+// is confirmed. The current address, when valid, is told about the request. The response
+// is the same whether or not the details match, so the endpoint cannot be used to look up
+// participants.
+func ChangeEmailHandler(c *fiber.Ctx) error {
+	var req ChangeEmailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	req.CurrentEmail, req.NewEmail = strings.TrimSpace(req.CurrentEmail), strings.TrimSpace(req.NewEmail)
+	req.Name, req.Phone = strings.TrimSpace(req.Name), strings.TrimSpace(req.Phone)
+	if req.CurrentEmail == "" || req.NewEmail == "" || req.Name == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "current_email, new_email and name are required")
+	}
+	if len(req.NewEmail) > 255 || emailcheck.Syntax(req.NewEmail) != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "new_email must be a valid email address")
+	}
+	if strings.EqualFold(req.CurrentEmail, req.NewEmail) {
+		return apierror.Send(c, fiber.StatusBadRequest, "new_email must differ from current_email")
+	}
+	// Catches a second typo while the participant can still correct it
+	if emailcheck.MXEnabled() && emailcheck.Domain(context.Background(), emailcheck.DomainOf(req.NewEmail)) != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "email domain cannot receive email, please check the address")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	err := captcha.Verify(ctx, req.CaptchaToken, c.IP())
+	if errors.Is(err, captcha.ErrMissing) || errors.Is(err, captcha.ErrRejected) {
+		logging.Ctx(c).Warn().Err(err).Msg("Email change captcha rejected")
+		return apierror.Send(c, fiber.StatusBadRequest, "Captcha verification failed")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to verify captcha")
+		return apierror.Send(c, fiber.StatusServiceUnavailable, "Captcha could not be verified, please try again")
+	}
+
+	ttl := emailChangeTokenTTL()
+	accepted := fiber.Map{
+		"message":            "If the details match a participant, a confirmation link has been sent to the new address",
+		"expires_in_seconds": int(ttl.Seconds()),
+	}
+
+	var student emailChangeStudent
+	studentQuery := `
+		SELECT id, name, email, COALESCE(phone, ''), COALESCE(locale, ''), email_valid
+		FROM students
+		WHERE LOWER(email) = LOWER($1) AND is_sandbox = false AND anonymized_at IS NULL
+		ORDER BY id
+		LIMIT 1
+	`
+	err = db.Pool.QueryRow(ctx, studentQuery, req.CurrentEmail).Scan(&student.ID, &student.Name, &student.Email,
+		&student.Phone, &student.Locale, &student.EmailValid)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logging.Ctx(c).Info().Msg("Email change requested for an unknown address")
+		return c.Status(fiber.StatusAccepted).JSON(accepted)
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to look up participant for email change")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to request email change")
+	}
+	logging.SetStudent(c, student.ID)
+
+	if !sameName(req.Name, student.Name) || (student.Phone != "" && phoneDigits(req.Phone) != phoneDigits(student.Phone)) {
+		logging.Ctx(c).Warn().Msg("Email change requested with details that do not match the participant")
+		return c.Status(fiber.StatusAccepted).JSON(accepted)
+	}
+
+	var taken bool
+	if err := db.Pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM students WHERE LOWER(email) = LOWER($1))`, req.NewEmail).Scan(&taken); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check new email")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to request email change")
+	}
+	if taken {
+		logging.Ctx(c).Info().Msg("Email change requested to an address that is already registered")
+		return c.Status(fiber.StatusAccepted).JSON(accepted)
+	}
+
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to generate email change token")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to request email change")
+	}
+	token := hex.EncodeToString(randomBytes)
+	link := config.FrontendURL() + "/change-email/confirm?token=" + token
+
+	// The request and its emails are stored together; the outbox relay sends them
+	err = db.WithTx(ctx, func(tx pgx.Tx) error {
+		// Only the latest request can be confirmed
+		cancelQuery := `
+			UPDATE email_change_requests SET cancelled_at = NOW()
+			WHERE student_id = $1 AND confirmed_at IS NULL AND cancelled_at IS NULL
+		`
+		if _, err := tx.Exec(ctx, cancelQuery, student.ID); err != nil {
+			return err
+		}
+		insertQuery := `
+			INSERT INTO email_change_requests (student_id, old_email, new_email, token, expires_at, ip, user_agent)
+			VALUES ($1, $2, $3, $4, NOW() + make_interval(secs => $5), $6, NULLIF($7, ''))
+		`
+		if _, err := tx.Exec(ctx, insertQuery, student.ID, student.Email, req.NewEmail, token, ttl.Seconds(),
+			c.IP(), c.Get(fiber.HeaderUserAgent)); err != nil {
+			return err
+		}
+		return outbox.Enqueue(ctx, tx, emailChangeMessages(ctx, student, req.NewEmail, link, ttl)...)
+	})
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to store email change request")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to request email change")
+	}
+	outbox.Notify()
+	logging.Ctx(c).Info().Str("new_email", req.NewEmail).Msg("Email change requested")
+
+	return c.Status(fiber.StatusAccepted).JSON(accepted)
+}
+
+// emailChangeMessages builds the confirmation link email to the new address and the notice
+// to the current one, leaving out suppressed addresses and a current address that failed
+// validation
+func emailChangeMessages(ctx context.Context, student emailChangeStudent, newEmail, link string, ttl time.Duration) []outbox.Message {
+	var msgs []outbox.Message
+	if !suppression.IsSuppressed(ctx, newEmail) {
+		subject, htmlBody := i18n.Localize(ctx, i18n.TemplateEmailChange, student.Locale,
+			"Confirm your new email address: CoopQuest International Online Quiz", emailChangeHTML(student.Name, newEmail, link, ttl),
+			map[string]string{"name": student.Name, "link": link, "hours": strconv.Itoa(int(ttl.Hours())), "new_email": newEmail})
+		msgs = append(msgs, outbox.Message{
+			StudentID: student.ID,
+			Source:    "email_change",
+			ToEmail:   newEmail,
+			ToName:    student.Name,
+			Subject:   subject,
+			HTMLBody:  htmlBody,
+		})
+	}
+	if student.EmailValid && !suppression.IsSuppressed(ctx, student.Email) {
+		subject, htmlBody := i18n.Localize(ctx, i18n.TemplateEmailChangeNotice, student.Locale,
+			"Email change requested: CoopQuest International Online Quiz", emailChangeNoticeHTML(student.Name, newEmail),
+			map[string]string{"name": student.Name, "new_email": newEmail})
+		msgs = append(msgs, outbox.Message{
+			StudentID: student.ID,
+			Source:    "email_change",
+			ToEmail:   student.Email,
+			ToName:    student.Name,
+			Subject:   subject,
+			HTMLBody:  htmlBody,
+		})
+	}
+	return msgs
+}
+
+func emailChangeHTML(name, newEmail, link string, ttl time.Duration) string {
+	return fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; max-width: 700px; margin: 0 auto; padding: 20px;">
+			<h2 style="color: #2c3e50;">Confirm your new email address</h2>
+
+			<p>Dear %s,</p>
+
+			<p>We received a request to change the email address of your registration for the <strong>International Online Quiz on Cooperatives</strong> to <strong>%s</strong>.</p>
+
+			<div style="background-color: #f8f9fa; padding: 15px; border-left: 4px solid #4CAF50; margin: 20px 0;">
+				<p style="margin: 5px 0;"><strong>🔗 <a href="%s" style="color: #4CAF50; font-weight: bold;">Click here to confirm your new email address</a></strong></p>
+				<p style="margin: 5px 0;">This link is valid for %d hours.</p>
+			</div>
+
+			<p>Once confirmed, all further emails, including a new conference link if you have already been invited, are sent to this address.</p>
+
+			<p>If you did not ask for this change, you can ignore this email and nothing will change.</p>
+
+			<p>Warm regards,<br><strong>Natesan Institute of Cooperative Management (NICM)</strong><br>Chennai</p>
+		</div>
+	`, name, newEmail, link, int(ttl.Hours()))
+}
+
+func emailChangeNoticeHTML(name, newEmail string) string {
+	return fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; max-width: 700px; margin: 0 auto; padding: 20px;">
+			<h2 style="color: #2c3e50;">Email change requested</h2>
+
+			<p>Dear %s,</p>
+
+			<p>We received a request to change the email address of your registration for the <strong>International Online Quiz on Cooperatives</strong> to <strong>%s</strong>. The change is made only once it is confirmed from that address.</p>
+
+			<p>If you did not ask for this change, please contact the organizers right away.</p>
+
+			<p>Warm regards,<br><strong>Natesan Institute of Cooperative Management (NICM)</strong><br>Chennai</p>
+		</div>
+	`, name, newEmail)
+}
+
+// ConfirmEmailChangeHandler handles POST /api/participants/change-email/confirm
+// Body: {"token": "..."}. Changes the participant's address from an emailed link, recording
+// the change like an admin edit (source self_service). A participant who was sent the
+// conference invitation gets a new conference token, and the invitation with it is queued
+// to the new address; the old link stops working. Confirming again returns the same result.
+func ConfirmEmailChangeHandler(c *fiber.Ctx) error {
+	var req ConfirmEmailChangeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	req.Token = strings.TrimSpace(req.Token)
+	if req.Token == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Token is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The new address is checked again before any row is locked; the lookup has its own timeout
+	var newEmail string
+	err := db.Pool.QueryRow(ctx, `SELECT new_email FROM email_change_requests WHERE token = $1`, req.Token).Scan(&newEmail)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierror.Send(c, fiber.StatusNotFound, "Invalid confirmation link")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load email change request")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to confirm email change")
+	}
+	emailReason := emailcheck.Check(context.Background(), newEmail, emailcheck.MXEnabled())
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to begin transaction")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to confirm email change")
+	}
+	defer tx.Rollback(ctx)
+
+	var requestID, studentID int
+	var oldEmail string
+	var confirmed, cancelled, expired bool
+	query := `
+		SELECT id, student_id, old_email, confirmed_at IS NOT NULL, cancelled_at IS NOT NULL, expires_at <= NOW()
+		FROM email_change_requests
+		WHERE token = $1
+		FOR UPDATE
+	`
+	err = tx.QueryRow(ctx, query, req.Token).Scan(&requestID, &studentID, &oldEmail, &confirmed, &cancelled, &expired)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierror.Send(c, fiber.StatusNotFound, "Invalid confirmation link")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load email change request")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to confirm email change")
+	}
+	logging.SetStudent(c, studentID)
+
+	switch {
+	case confirmed:
+		return c.JSON(fiber.Map{"message": "Email change already confirmed", "student_id": studentID, "email": newEmail})
+	case cancelled:
+		return apierror.Send(c, fiber.StatusGone, "This link was replaced by a newer request, use the latest email")
+	case expired:
+		return apierror.Send(c, fiber.StatusGone, "Confirmation link has expired, please request the change again")
+	}
+
+	// An admin may have changed the address since the request
+	var current bool
+	err = tx.QueryRow(ctx, `SELECT LOWER(email) = LOWER($2) FROM students WHERE id = $1 FOR UPDATE`, studentID, oldEmail).Scan(&current)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load participant for email change")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to confirm email change")
+	}
+	if !current {
+		return apierror.Send(c, fiber.StatusConflict, "The email address has changed since this link was sent")
+	}
+
+	student, change, err := applyStudentUpdate(ctx, tx, studentID, nil, &newEmail, models.StudentProfile{}, emailReason, emailChangeSourceSelfService)
+	if errors.Is(err, errEmailTaken) {
+		return apierror.Send(c, fiber.StatusConflict, "Email already exists")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to change email")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to confirm email change")
+	}
+	var changeID *int
+	if change != nil {
+		changeID = &change.ID
+	}
+	if _, err := tx.Exec(ctx, `UPDATE email_change_requests SET confirmed_at = NOW(), email_change_id = $2 WHERE id = $1`, requestID, changeID); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to confirm email change request")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to confirm email change")
+	}
+
+	// The conference link sent to the old address stops working; the new one is tied to the
+	// new address
+	token := GenerateConferenceToken()
+	rotateQuery := `
+		UPDATE email_tracking
+		SET conference_token = $2, recipient_email = NULL, updated_at = NOW()
+		WHERE student_id = $1 AND email_type = 'firstMail' AND conference_token IS NOT NULL
+	`
+	tag, err := tx.Exec(ctx, rotateQuery, studentID, token)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to regenerate conference token")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to confirm email change")
+	}
+	regenerated := tag.RowsAffected() > 0
+	queued := false
+	if regenerated && emailReason == "" && !suppression.IsSuppressed(ctx, newEmail) {
+		locale := ""
+		if student.Locale != nil {
+			locale = *student.Locale
+		}
+		link := config.FrontendURL() + "/live?token=" + token
+		subject, htmlBody := i18n.Localize(ctx, i18n.TemplateFirstMail, locale,
+			"Invitation: CoopQuest- An International Online Cooperative  Conclave", conferenceInvitationHTML(student.Name, link),
+			map[string]string{"name": student.Name, "link": link})
+		err := outbox.Enqueue(ctx, tx, outbox.Message{
+			StudentID: studentID,
+			EmailType: tracking.FirstMail,
+			Source:    "email_change",
+			ToEmail:   newEmail,
+			ToName:    student.Name,
+			Subject:   subject,
+			HTMLBody:  htmlBody,
+		})
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to queue conference invitation")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to confirm email change")
+		}
+		queued = true
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to commit email change")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to confirm email change")
+	}
+	if queued {
+		outbox.Notify()
+	}
+	logging.Ctx(c).Info().Str("old_email", oldEmail).Str("new_email", newEmail).Bool("token_regenerated", regenerated).
+		Msg("Participant changed their email")
+
+	return c.JSON(fiber.Map{
+		"message":                      "Email address changed",
+		"student_id":                   studentID,
+		"email":                        newEmail,
+		"conference_token_regenerated": regenerated,
+		"invitation_queued":            queued,
+	})
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"context"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Domains whose bounce rate reaches flagBounceRate, or whose delivery rate falls below
+// flagDeliveryRate while the provider reports deliveries, are flagged as likely blocking us
+const (
+	flagBounceRate   = 0.10
+	flagDeliveryRate = 0.50
+)
+
+// webmailDomains are public mailbox providers; everything else is institutional or other
+var webmailDomains = map[string]bool{
+	"gmail.com": true, "googlemail.com": true,
+	"yahoo.com": true, "yahoo.co.in": true, "yahoo.in": true, "ymail.com": true, "rocketmail.com": true,
+	"outlook.com": true, "hotmail.com": true, "live.com": true, "msn.com": true,
+	"icloud.com": true, "me.com": true, "aol.com": true, "rediffmail.com": true,
+	"protonmail.com": true, "proton.me": true, "zoho.com": true, "gmx.com": true, "mail.com": true,
+}
+
+// DeliverabilityCounts are per-message counts; each email_logs row is counted by the
+// furthest status provider events moved it to
+type DeliverabilityCounts struct {
+	Sent         int     `json:"sent"`
+	Failed       int     `json:"failed"`
+	Delivered    int     `json:"delivered"`
+	Bounced      int     `json:"bounced"`
+	HardBounces  int     `json:"hard_bounces"`
+	SoftBounces  int     `json:"soft_bounces"`
+	Spam         int     `json:"spam"`
+	Opened       int     `json:"opened"`
+	Clicked      int     `json:"clicked"`
+	DeliveryRate float64 `json:"delivery_rate"`
+	BounceRate   float64 `json:"bounce_rate"`
+	OpenRate     float64 `json:"open_rate"`
+	ClickRate    float64 `json:"click_rate"`
+}
+
+func (d *DeliverabilityCounts) add(o DeliverabilityCounts) {
+	d.Sent += o.Sent
+	d.Failed += o.Failed
+	d.Delivered += o.Delivered
+	d.Bounced += o.Bounced
+	d.HardBounces += o.HardBounces
+	d.SoftBounces += o.SoftBounces
+	d.Spam += o.Spam
+	d.Opened += o.Opened
+	d.Clicked += o.Clicked
+}
+
+// rates fills the ratios: delivery and bounce over sent, open and click over delivered
+func (d *DeliverabilityCounts) rates() {
+	d.DeliveryRate = ratio(d.Delivered, d.Sent)
+	d.BounceRate = ratio(d.Bounced, d.Sent)
+	d.OpenRate = ratio(d.Opened, d.Delivered)
+	d.ClickRate = ratio(d.Clicked, d.Delivered)
+}
+
+func ratio(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
+}
+
+type DeliverabilityDay struct {
+	Day string `json:"day"`
+	DeliverabilityCounts
+}
+
+// DeliverabilityCampaign is an email campaign, or for other sends their subject
+type DeliverabilityCampaign struct {
+	Campaign   string `json:"campaign"`
+	CampaignID *int   `json:"campaign_id"`
+	DeliverabilityCounts
+	Days []DeliverabilityDay `json:"days"`
+}
+
+type DeliverabilityDomain struct {
+	Domain   string `json:"domain"`
+	Category string `json:"category"`
+	DeliverabilityCounts
+	Flagged    bool   `json:"flagged"`
+	FlagReason string `json:"flag_reason,omitempty"`
+}
+
+type DeliverabilityCategory struct {
+	Category string `json:"category"`
+	Domains  int    `json:"domains"`
+	DeliverabilityCounts
+}
+
+// domainCategory classifies a recipient domain as webmail, institutional (academic domains
+// such as .edu, .ac.in, .edu.au) or other
+func domainCategory(domain string) string {
+	if webmailDomains[domain] {
+		return "webmail"
+	}
+	labels := strings.Split(domain, ".")
+	for i, label := range labels[:len(labels)-1] {
+		if i > 0 && (label == "edu" || label == "ac") {
+			return "institutional"
+		}
+	}
+	if labels[len(labels)-1] == "edu" {
+		return "institutional"
+	}
+	return "other"
+}
+
+// GetEmailDeliverabilityHandler handles GET /api/mail/deliverability?from=&to=&min_sent=20&domains=50
+// Aggregates email logs, provider events and bounces by campaign and UTC day and by recipient
+// domain, flagging domains that bounce or never report delivery. from/to bound sent_at.
+func GetEmailDeliverabilityHandler(c *fiber.Ctx) error {
+	var from, to *time.Time
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "from must be an RFC3339 timestamp")
+		}
+		from = &parsed
+	}
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "to must be an RFC3339 timestamp")
+		}
+		to = &parsed
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		return apierror.Send(c, fiber.StatusBadRequest, "from must be before to")
+	}
+	minSent := c.QueryInt("min_sent", 20)
+	if minSent < 1 {
+		return apierror.Send(c, fiber.StatusBadRequest, "min_sent must be at least 1")
+	}
+	domainLimit := c.QueryInt("domains", 50)
+	if domainLimit < 1 || domainLimit > 1000 {
+		return apierror.Send(c, fiber.StatusBadRequest, "domains must be between 1 and 1000")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	// Campaign sends are matched to their campaign through the recipient's request ID;
+	// bounces are matched to the message by request ID and address
+	query := `
+		WITH campaign_messages AS (
+			SELECT DISTINCT ON (r.request_id, LOWER(r.email)) r.request_id, LOWER(r.email) AS email, ec.id, ec.name
+			FROM email_campaign_recipients r
+			JOIN email_campaigns ec ON ec.id = r.campaign_id
+			WHERE r.request_id IS NOT NULL
+			ORDER BY r.request_id, LOWER(r.email), r.id
+		),
+		bounces AS (
+			SELECT request_id, LOWER(email) AS email, bool_or(bounce_type = 'hard') AS hard
+			FROM email_bounces
+			WHERE request_id IS NOT NULL
+			GROUP BY request_id, LOWER(email)
+		),
+		messages AS (
+			SELECT l.status, COALESCE(cm.name, l.subject) AS campaign, cm.id AS campaign_id,
+			       to_char(l.sent_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day,
+			       LOWER(split_part(l.email, '@', 2)) AS domain,
+			       b.hard
+			FROM email_logs l
+			LEFT JOIN campaign_messages cm ON cm.request_id = l.request_id AND cm.email = LOWER(l.email)
+			LEFT JOIN bounces b ON b.request_id = l.request_id AND b.email = LOWER(l.email)
+			WHERE ($1::timestamptz IS NULL OR l.sent_at >= $1)
+			  AND ($2::timestamptz IS NULL OR l.sent_at < $2)
+		)
+		SELECT campaign, campaign_id, day, domain,
+		       COUNT(*) FILTER (WHERE status <> 'failed'),
+		       COUNT(*) FILTER (WHERE status = 'failed'),
+		       COUNT(*) FILTER (WHERE status IN ('delivered', 'opened', 'clicked', 'spam')),
+		       COUNT(*) FILTER (WHERE status = 'bounced' OR hard IS NOT NULL),
+		       COUNT(*) FILTER (WHERE hard),
+		       COUNT(*) FILTER (WHERE NOT hard),
+		       COUNT(*) FILTER (WHERE status = 'spam'),
+		       COUNT(*) FILTER (WHERE status IN ('opened', 'clicked')),
+		       COUNT(*) FILTER (WHERE status = 'clicked')
+		FROM messages
+		GROUP BY campaign, campaign_id, day, domain
+	`
+	rows, err := db.Pool.Query(ctx, query, from, to)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch email deliverability")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch email deliverability")
+	}
+	defer rows.Close()
+
+	type campaignKey struct {
+		name string
+		id   int
+	}
+	var totals DeliverabilityCounts
+	campaigns := make(map[campaignKey]*DeliverabilityCampaign)
+	campaignDays := make(map[campaignKey]map[string]*DeliverabilityDay)
+	days := make(map[string]*DeliverabilityDay)
+	domains := make(map[string]*DeliverabilityDomain)
+	for rows.Next() {
+		var campaign, day, domain string
+		var campaignID *int
+		var counts DeliverabilityCounts
+		if err := rows.Scan(&campaign, &campaignID, &day, &domain, &counts.Sent, &counts.Failed, &counts.Delivered,
+			&counts.Bounced, &counts.HardBounces, &counts.SoftBounces, &counts.Spam, &counts.Opened, &counts.Clicked); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to read email deliverability")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch email deliverability")
+		}
+		totals.add(counts)
+
+		key := campaignKey{name: campaign}
+		if campaignID != nil {
+			key.id = *campaignID
+		}
+		if campaigns[key] == nil {
+			campaigns[key] = &DeliverabilityCampaign{Campaign: campaign, CampaignID: campaignID}
+			campaignDays[key] = make(map[string]*DeliverabilityDay)
+		}
+		campaigns[key].add(counts)
+		if campaignDays[key][day] == nil {
+			campaignDays[key][day] = &DeliverabilityDay{Day: day}
+		}
+		campaignDays[key][day].add(counts)
+
+		if days[day] == nil {
+			days[day] = &DeliverabilityDay{Day: day}
+		}
+		days[day].add(counts)
+
+		if domain == "" {
+			domain = "(invalid)"
+		}
+		if domains[domain] == nil {
+			domains[domain] = &DeliverabilityDomain{Domain: domain, Category: domainCategory(domain)}
+		}
+		domains[domain].add(counts)
+	}
+	if err := rows.Err(); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to read email deliverability")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch email deliverability")
+	}
+	totals.rates()
+
+	campaignList := make([]DeliverabilityCampaign, 0, len(campaigns))
+	for key, campaign := range campaigns {
+		campaign.rates()
+		for _, day := range campaignDays[key] {
+			day.rates()
+			campaign.Days = append(campaign.Days, *day)
+		}
+		sort.Slice(campaign.Days, func(i, j int) bool { return campaign.Days[i].Day < campaign.Days[j].Day })
+		campaignList = append(campaignList, *campaign)
+	}
+	sort.Slice(campaignList, func(i, j int) bool {
+		if campaignList[i].Sent != campaignList[j].Sent {
+			return campaignList[i].Sent > campaignList[j].Sent
+		}
+		return campaignList[i].Campaign < campaignList[j].Campaign
+	})
+
+	dayList := make([]DeliverabilityDay, 0, len(days))
+	for _, day := range days {
+		day.rates()
+		dayList = append(dayList, *day)
+	}
+	sort.Slice(dayList, func(i, j int) bool { return dayList[i].Day < dayList[j].Day })
+
+	// Deliveries are only judged when the provider reports them at all
+	deliveryReported := totals.Delivered > 0
+	categories := make(map[string]*DeliverabilityCategory)
+	domainList := make([]DeliverabilityDomain, 0, len(domains))
+	flagged := 0
+	for _, domain := range domains {
+		domain.rates()
+		if domain.Sent >= minSent {
+			switch {
+			case domain.BounceRate >= flagBounceRate:
+				domain.Flagged, domain.FlagReason = true, "high bounce rate"
+			case deliveryReported && domain.DeliveryRate < flagDeliveryRate:
+				domain.Flagged, domain.FlagReason = true, "low delivery rate"
+			}
+		}
+		if domain.Flagged {
+			flagged++
+		}
+		if categories[domain.Category] == nil {
+			categories[domain.Category] = &DeliverabilityCategory{Category: domain.Category}
+		}
+		categories[domain.Category].Domains++
+		categories[domain.Category].add(domain.DeliverabilityCounts)
+		domainList = append(domainList, *domain)
+	}
+	// Flagged domains first, then by volume
+	sort.Slice(domainList, func(i, j int) bool {
+		if domainList[i].Flagged != domainList[j].Flagged {
+			return domainList[i].Flagged
+		}
+		if domainList[i].Sent != domainList[j].Sent {
+			return domainList[i].Sent > domainList[j].Sent
+		}
+		return domainList[i].Domain < domainList[j].Domain
+	})
+	totalDomains := len(domainList)
+	if len(domainList) > domainLimit {
+		domainList = domainList[:domainLimit]
+	}
+
+	categoryList := make([]DeliverabilityCategory, 0, len(categories))
+	for _, category := range categories {
+		category.rates()
+		categoryList = append(categoryList, *category)
+	}
+	sort.Slice(categoryList, func(i, j int) bool { return categoryList[i].Sent > categoryList[j].Sent })
+
+	return c.JSON(fiber.Map{
+		"from":              from,
+		"to":                to,
+		"totals":            totals,
+		"campaigns":         campaignList,
+		"days":              dayList,
+		"domain_categories": categoryList,
+		"domains":           domainList,
+		"total_domains":     totalDomains,
+		"flagged_domains":   flagged,
+		"min_sent":          minSent,
+	})
+}
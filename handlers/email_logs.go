@@ -2,7 +2,10 @@ package handlers
 
 import (
 	"context"
+	"mcq-exam/apierror"
 	"mcq-exam/db"
+	"mcq-exam/logging"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -20,32 +23,94 @@ type EmailLog struct {
 	SentAt          time.Time `json:"sent_at"`
 }
 
-// GetEmailLogsHandler handles GET /api/mail/logs?status=sent
-// Returns email logs filtered by status (default: sent)
-// Special case: status=failed returns emails where request_id IS NULL
+// EmailTimelineEntry is a sent email ("email") or an open, click or delivery event ("event")
+type EmailTimelineEntry struct {
+	Kind       string     `json:"kind"`
+	At         *time.Time `json:"at"`
+	EmailLogID *int       `json:"email_log_id,omitempty"`
+	Subject    *string    `json:"subject,omitempty"`
+	Status     *string    `json:"status,omitempty"`
+	EventType  *string    `json:"event_type,omitempty"`
+	EmailType  *string    `json:"email_type,omitempty"`
+	Source     *string    `json:"source,omitempty"`
+	Email      *string    `json:"email"`
+	RequestID  *string    `json:"request_id"`
+}
+
+// GetEmailLogsHandler handles GET /api/mail/logs?status=sent&student_id=&email=&subject=&request_id=&from=&to=&limit=1000&cursor=
+// Returns email logs newest first. status defaults to sent (all for every status); subject
+// matches a substring; from/to bound sent_at. Pass next_cursor back as cursor for the next page.
 func GetEmailLogsHandler(c *fiber.Ctx) error {
-	status := c.Query("status", "sent")
+	status := strings.TrimSpace(c.Query("status", "sent"))
+	if status == "all" {
+		status = ""
+	}
+	studentID := c.QueryInt("student_id", 0)
+	email := strings.TrimSpace(c.Query("email"))
+	subject := strings.TrimSpace(c.Query("subject"))
+	requestID := strings.TrimSpace(c.Query("request_id"))
+	limit := c.QueryInt("limit", 1000)
+	if limit < 1 || limit > 1000 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Limit must be between 1 and 1000")
+	}
+	cursor := c.QueryInt("cursor", 0)
+	if cursor < 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid cursor")
+	}
+
+	var from, to *time.Time
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "from must be an RFC3339 timestamp")
+		}
+		from = &parsed
+	}
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "to must be an RFC3339 timestamp")
+		}
+		to = &parsed
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		return apierror.Send(c, fiber.StatusBadRequest, "from must be before to")
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// One row past the page tells whether there is a next page
 	query := `
 		SELECT id, student_id, email, subject, status, request_id, response_code, response_message, sent_at
 		FROM email_logs
-		WHERE status = $1
+		WHERE ($1 = '' OR status = $1)
+		  AND ($2 = 0 OR student_id = $2)
+		  AND ($3 = '' OR LOWER(email) = LOWER($3))
+		  AND ($4 = '' OR subject ILIKE '%' || $4 || '%')
+		  AND ($5 = '' OR request_id = $5)
+		  AND ($6::timestamptz IS NULL OR sent_at >= $6)
+		  AND ($7::timestamptz IS NULL OR sent_at < $7)
+		  AND ($8 = 0 OR id < $8)
 		ORDER BY id DESC
-		LIMIT 1000
+		LIMIT $9
 	`
 
-	rows, err := db.Pool.Query(ctx, query, status)
-
+	rows, err := db.Pool.Query(ctx, query, status, studentID, email, subject, requestID, from, to, cursor, limit+1)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch email logs"})
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch email logs")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch email logs")
 	}
 	defer rows.Close()
 
-	var logs []EmailLog
+	logs := []EmailLog{}
+	var nextCursor *int
 	for rows.Next() {
+		if len(logs) == limit {
+			last := logs[len(logs)-1].ID
+			nextCursor = &last
+			break
+		}
 		var log EmailLog
 		if err := rows.Scan(&log.ID, &log.StudentID, &log.Email, &log.Subject, &log.Status, &log.RequestID, &log.ResponseCode, &log.ResponseMessage, &log.SentAt); err != nil {
 			continue
@@ -54,7 +119,67 @@ func GetEmailLogsHandler(c *fiber.Ctx) error {
 	}
 
 	return c.JSON(fiber.Map{
-		"count": len(logs),
-		"logs":  logs,
+		"count":       len(logs),
+		"limit":       limit,
+		"next_cursor": nextCursor,
+		"logs":        logs,
+	})
+}
+
+// GetStudentEmailsFiber handles GET /api/students/:id/emails?limit=200
+// Returns the student's email timeline newest first: every logged send plus the tracking
+// and provider events (delivered, open, click, spam, bounce) recorded for the student.
+func GetStudentEmailsFiber(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid student ID")
+	}
+	limit := c.QueryInt("limit", 200)
+	if limit < 1 || limit > 1000 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Limit must be between 1 and 1000")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var email string
+	if err := db.Pool.QueryRow(ctx, `SELECT email FROM students WHERE id = $1`, id).Scan(&email); err != nil {
+		return apierror.Send(c, fiber.StatusNotFound, "Student not found")
+	}
+
+	query := `
+		SELECT 'email', l.sent_at, l.id, l.subject, l.status, NULL::varchar, NULL::varchar, NULL::varchar, l.email, l.request_id
+		FROM email_logs l
+		WHERE l.student_id = $1
+		UNION ALL
+		SELECT 'event', e.created_at, NULL::int, NULL::varchar, NULL::varchar, e.event_type, e.email_type, e.source, e.email, e.request_id
+		FROM email_events e
+		WHERE e.student_id = $1
+		ORDER BY 2 DESC NULLS LAST
+		LIMIT $2
+	`
+	rows, err := db.Pool.Query(ctx, query, id, limit)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("student_id", id).Msg("Failed to fetch email timeline")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch email timeline")
+	}
+	defer rows.Close()
+
+	timeline := []EmailTimelineEntry{}
+	for rows.Next() {
+		var entry EmailTimelineEntry
+		if err := rows.Scan(&entry.Kind, &entry.At, &entry.EmailLogID, &entry.Subject, &entry.Status,
+			&entry.EventType, &entry.EmailType, &entry.Source, &entry.Email, &entry.RequestID); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan email timeline entry")
+			continue
+		}
+		timeline = append(timeline, entry)
+	}
+
+	return c.JSON(fiber.Map{
+		"student_id": id,
+		"email":      email,
+		"count":      len(timeline),
+		"timeline":   timeline,
 	})
 }
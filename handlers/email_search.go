@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"mcq-exam/apierror"
 	"mcq-exam/db"
 	"strings"
 	"time"
@@ -15,7 +16,7 @@ func SearchEmailHandler(c *fiber.Ctx) error {
 	searchTerm := c.Query("email")
 
 	if strings.TrimSpace(searchTerm) == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "email query parameter is required"})
+		return apierror.Send(c, fiber.StatusBadRequest, "email query parameter is required")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -25,7 +26,7 @@ func SearchEmailHandler(c *fiber.Ctx) error {
 	query := `SELECT email FROM students WHERE email ILIKE $1 ORDER BY email LIMIT 50`
 	rows, err := db.Pool.Query(ctx, query, "%"+searchTerm+"%")
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to search emails"})
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to search emails")
 	}
 	defer rows.Close()
 
@@ -39,7 +40,7 @@ func SearchEmailHandler(c *fiber.Ctx) error {
 	}
 
 	if len(emails) == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "No emails found"})
+		return apierror.Send(c, fiber.StatusNotFound, "No emails found")
 	}
 
 	return c.JSON(fiber.Map{
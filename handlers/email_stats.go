@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"mcq-exam/apierror"
 	"mcq-exam/db"
 	"time"
 
@@ -16,9 +17,9 @@ func GetEmailStatsHandler(c *fiber.Ctx) error {
 
 	// Get total email addresses from students table
 	var totalEmails int
-	query := `SELECT COUNT(*) FROM students`
+	query := `SELECT COUNT(*) FROM students WHERE is_sandbox = false`
 	if err := db.Pool.QueryRow(ctx, query).Scan(&totalEmails); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get email count"})
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to get email count")
 	}
 
 	return c.JSON(fiber.Map{
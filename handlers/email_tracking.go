@@ -4,19 +4,25 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
-	"log"
-	"math/rand"
+	"mcq-exam/apierror"
+	"mcq-exam/config"
 	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/tracking"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// TrackEmailOpenHandler handles GET /api/track-open?student_id=123&type=first
-// Returns 1x1 transparent PNG and tracks email open + generates access code for first email
+// TrackEmailOpenHandler handles GET /api/track-open?student_id=123&type=firstMail
+// Returns 1x1 transparent PNG and tracks the email open in its campaign. The legacy types
+// "first" and "second", still in pixels of emails already sent, count as firstMail and
+// secondMail.
+// Access codes are issued when the student attends the conference, not on open.
 func TrackEmailOpenHandler(c *fiber.Ctx) error {
 	studentIDStr := c.Query("student_id")
-	emailType := c.Query("type") // 'first' or 'second'
+	emailType := tracking.ParseEmailType(c.Query("type"))
 
 	if studentIDStr == "" || emailType == "" {
 		// Return pixel anyway but don't track
@@ -28,57 +34,28 @@ func TrackEmailOpenHandler(c *fiber.Ctx) error {
 		return returnTransparentPixel(c)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Pixels load concurrently (mail clients prefetch, readers reopen), so every write is a
+	// single statement bounded by the request rather than a read followed by a write
+	ctx, cancel := context.WithTimeout(c.UserContext(), 3*time.Second)
 	defer cancel()
 
-	// Check if tracking record exists
-	var trackingID int
-	var opened bool
-	checkQuery := `SELECT id, opened FROM email_tracking WHERE student_id = $1 AND email_type = $2`
-	err := db.Pool.QueryRow(ctx, checkQuery, studentID, emailType).Scan(&trackingID, &opened)
+	tracking.RecordEvent(ctx, studentID, emailType, tracking.EventOpen, nil, c.IP(), c.Get(fiber.HeaderUserAgent))
 
-	if err != nil {
-		// Create new tracking record
-		accessCode := ""
-		if emailType == "first" {
-			accessCode = generateAccessCode()
-		}
-
-		insertQuery := `
-			INSERT INTO email_tracking (student_id, email_type, opened, opened_at, access_code)
-			VALUES ($1, $2, true, NOW(), $3)
-			RETURNING id
-		`
-		err = db.Pool.QueryRow(context.Background(), insertQuery, studentID, emailType, nullString(accessCode)).Scan(&trackingID)
-		if err != nil {
-			log.Printf("Failed to create email tracking: %v", err)
-		}
-	} else if !opened {
-		// Update existing record to opened
-		accessCode := ""
-		if emailType == "first" {
-			accessCode = generateAccessCode()
-		}
-
-		updateQuery := `UPDATE email_tracking SET opened = true, opened_at = NOW(), access_code = $1, updated_at = NOW() WHERE id = $2`
-		_, _ = db.Pool.Exec(context.Background(), updateQuery, nullString(accessCode), trackingID)
+	// Opens are tracked per campaign by RecordEvent; the invitation rows of the firstMail
+	// flow keep their opened flag for the funnel. No row is created for other types, and
+	// only the first open sets opened_at.
+	updateQuery := `
+		UPDATE email_tracking
+		SET opened = true, opened_at = NOW(), updated_at = NOW()
+		WHERE student_id = $1 AND email_type = $2 AND opened IS DISTINCT FROM true
+	`
+	if _, err := db.Pool.Exec(ctx, updateQuery, studentID, string(emailType)); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to update email tracking")
 	}
 
 	return returnTransparentPixel(c)
 }
 
-// generateAccessCode generates a random 6-character alphanumeric code
-func generateAccessCode() string {
-	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	rand.Seed(time.Now().UnixNano())
-
-	code := make([]byte, 6)
-	for i := range code {
-		code[i] = charset[rand.Intn(len(charset))]
-	}
-	return string(code)
-}
-
 // nullString returns nil if string is empty, otherwise returns the string
 func nullString(s string) *string {
 	if s == "" {
@@ -99,34 +76,71 @@ func returnTransparentPixel(c *fiber.Ctx) error {
 	return c.Send(imgData)
 }
 
-// GetStudentsWhoOpenedHandler handles GET /api/tracking/opened-first
+type StudentTracking struct {
+	StudentID  int       `json:"student_id"`
+	Name       string    `json:"name"`
+	Email      string    `json:"email"`
+	AccessCode string    `json:"access_code"`
+	OpenedAt   time.Time `json:"opened_at"`
+}
+
+type NonAttendee struct {
+	StudentID int        `json:"student_id"`
+	Name      string     `json:"name"`
+	Email     string     `json:"email"`
+	Opened    *bool      `json:"opened"`
+	OpenedAt  *time.Time `json:"opened_at"`
+	EmailType *string    `json:"email_type"`
+}
+
+type StudentNotStarted struct {
+	StudentID            int       `json:"student_id"`
+	Name                 string    `json:"name"`
+	Email                string    `json:"email"`
+	AccessCode           string    `json:"access_code"`
+	ConferenceAttendedAt time.Time `json:"conference_attended_at"`
+}
+
+// GetStudentsWhoOpenedHandler handles GET /api/tracking/opened-first?format=json|csv|xlsx
 // Returns students who opened first email with their access codes
 func GetStudentsWhoOpenedHandler(c *fiber.Ctx) error {
+	format, err := trackingFormat(c)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	students, err := loadStudentsWhoOpened(ctx)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch tracking data")
+	}
+	if format != "json" {
+		return sendTrackingExport(c, format, "opened-first", openedFirstTable(students))
+	}
+
+	return c.JSON(fiber.Map{
+		"count":    len(students),
+		"students": students,
+	})
+}
+
+func loadStudentsWhoOpened(ctx context.Context) ([]StudentTracking, error) {
 	query := `
-		SELECT et.student_id, s.name, s.email, et.access_code, et.opened_at
+		SELECT et.student_id, s.name, s.email, COALESCE(et.access_code, ''), et.opened_at
 		FROM email_tracking et
 		JOIN students s ON et.student_id = s.id
-		WHERE et.email_type = 'first' AND et.opened = true
+		WHERE et.email_type = 'firstMail' AND et.opened = true
 		ORDER BY et.opened_at DESC
 	`
 
 	rows, err := db.Pool.Query(ctx, query)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch tracking data"})
+		return nil, err
 	}
 	defer rows.Close()
 
-	type StudentTracking struct {
-		StudentID  int       `json:"student_id"`
-		Name       string    `json:"name"`
-		Email      string    `json:"email"`
-		AccessCode string    `json:"access_code"`
-		OpenedAt   time.Time `json:"opened_at"`
-	}
-
 	var students []StudentTracking
 	for rows.Next() {
 		var st StudentTracking
@@ -135,19 +149,35 @@ func GetStudentsWhoOpenedHandler(c *fiber.Ctx) error {
 		}
 		students = append(students, st)
 	}
-
-	return c.JSON(fiber.Map{
-		"count":    len(students),
-		"students": students,
-	})
+	return students, rows.Err()
 }
 
-// GetStudentsNotAttendedHandler handles GET /api/tracking/not-attended
+// GetStudentsNotAttendedHandler handles GET /api/tracking/not-attended?format=json|csv|xlsx
 // Returns students who did NOT attend the conference (fail-safe mechanism)
 func GetStudentsNotAttendedHandler(c *fiber.Ctx) error {
+	format, err := trackingFormat(c)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	students, err := loadStudentsNotAttended(ctx)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch non-attendees")
+	}
+	if format != "json" {
+		return sendTrackingExport(c, format, "not-attended", notAttendedTable(students))
+	}
+
+	return c.JSON(fiber.Map{
+		"count":    len(students),
+		"students": students,
+	})
+}
+
+func loadStudentsNotAttended(ctx context.Context) ([]NonAttendee, error) {
 	query := `
 		SELECT s.id, s.name, s.email, et.opened, et.opened_at, et.email_type
 		FROM students s
@@ -158,19 +188,10 @@ func GetStudentsNotAttendedHandler(c *fiber.Ctx) error {
 
 	rows, err := db.Pool.Query(ctx, query)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch non-attendees"})
+		return nil, err
 	}
 	defer rows.Close()
 
-	type NonAttendee struct {
-		StudentID  int        `json:"student_id"`
-		Name       string     `json:"name"`
-		Email      string     `json:"email"`
-		Opened     *bool      `json:"opened"`
-		OpenedAt   *time.Time `json:"opened_at"`
-		EmailType  *string    `json:"email_type"`
-	}
-
 	var students []NonAttendee
 	for rows.Next() {
 		var st NonAttendee
@@ -179,19 +200,35 @@ func GetStudentsNotAttendedHandler(c *fiber.Ctx) error {
 		}
 		students = append(students, st)
 	}
-
-	return c.JSON(fiber.Map{
-		"count":    len(students),
-		"students": students,
-	})
+	return students, rows.Err()
 }
 
-// GetStudentsNotStartedTestHandler handles GET /api/tracking/not-started-test
+// GetStudentsNotStartedTestHandler handles GET /api/tracking/not-started-test?format=json|csv|xlsx
 // Returns students who attended conference but did NOT start the test (no session created)
 func GetStudentsNotStartedTestHandler(c *fiber.Ctx) error {
+	format, err := trackingFormat(c)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	students, err := loadStudentsNotStartedTest(ctx)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch students")
+	}
+	if format != "json" {
+		return sendTrackingExport(c, format, "not-started-test", notStartedTestTable(students))
+	}
+
+	return c.JSON(fiber.Map{
+		"count":    len(students),
+		"students": students,
+	})
+}
+
+func loadStudentsNotStartedTest(ctx context.Context) ([]StudentNotStarted, error) {
 	query := `
 		SELECT et.student_id, s.name, s.email, et.access_code, et.conference_attended_at
 		FROM email_tracking et
@@ -206,18 +243,10 @@ func GetStudentsNotStartedTestHandler(c *fiber.Ctx) error {
 
 	rows, err := db.Pool.Query(ctx, query)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch students"})
+		return nil, err
 	}
 	defer rows.Close()
 
-	type StudentNotStarted struct {
-		StudentID            int       `json:"student_id"`
-		Name                 string    `json:"name"`
-		Email                string    `json:"email"`
-		AccessCode           string    `json:"access_code"`
-		ConferenceAttendedAt time.Time `json:"conference_attended_at"`
-	}
-
 	var students []StudentNotStarted
 	for rows.Next() {
 		var st StudentNotStarted
@@ -226,9 +255,182 @@ func GetStudentsNotStartedTestHandler(c *fiber.Ctx) error {
 		}
 		students = append(students, st)
 	}
+	return students, rows.Err()
+}
+
+// TrackClickHandler handles GET /api/track-click?cid=...
+// Records a click on a tracked email link and redirects to the original URL
+func TrackClickHandler(c *fiber.Ctx) error {
+	cid := c.Query("cid")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var linkID, studentID int
+	var emailType, target string
+	query := `SELECT id, student_id, email_type, url FROM email_links WHERE cid = $1`
+	err := db.Pool.QueryRow(ctx, query, cid).Scan(&linkID, &studentID, &emailType, &target)
+	if err != nil {
+		// Unknown or missing link: send the reader somewhere useful instead of an error page
+		return c.Redirect(config.FrontendURL(), fiber.StatusFound)
+	}
+
+	tracking.RecordEvent(c.UserContext(), studentID, tracking.ParseEmailType(emailType), tracking.EventClick, &linkID, c.IP(), c.Get(fiber.HeaderUserAgent))
+
+	c.Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	return c.Redirect(target, fiber.StatusFound)
+}
+
+type CampaignSummary struct {
+	EmailType string `json:"email_type"`
+	Sent      int    `json:"sent"`
+	Opened    int    `json:"opened"`
+	Clicked   int    `json:"clicked"`
+	Neither   int    `json:"neither"`
+}
+
+type CohortStudent struct {
+	StudentID      int        `json:"student_id"`
+	Name           string     `json:"name"`
+	Email          string     `json:"email"`
+	SentAt         time.Time  `json:"sent_at"`
+	FirstOpenedAt  *time.Time `json:"first_opened_at"`
+	FirstClickedAt *time.Time `json:"first_clicked_at"`
+	Clicks         int        `json:"clicks"`
+}
+
+// GetCampaignSummaryHandler handles GET /api/tracking/campaigns?format=json|csv|xlsx
+// Returns sent / opened / clicked / neither counts per email type
+func GetCampaignSummaryHandler(c *fiber.Ctx) error {
+	format, err := trackingFormat(c)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	campaigns, err := loadCampaignSummary(ctx)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch campaign summary")
+	}
+	if format != "json" {
+		return sendTrackingExport(c, format, "campaigns", campaignSummaryTable(campaigns))
+	}
 
 	return c.JSON(fiber.Map{
-		"count":    len(students),
-		"students": students,
+		"count":     len(campaigns),
+		"campaigns": campaigns,
+	})
+}
+
+func loadCampaignSummary(ctx context.Context) ([]CampaignSummary, error) {
+	query := `
+		WITH per_student AS (
+			SELECT
+				email_type,
+				student_id,
+				bool_or(event_type = 'open') AS opened,
+				bool_or(event_type = 'click') AS clicked
+			FROM email_events
+			GROUP BY email_type, student_id
+			HAVING bool_or(event_type = 'sent')
+		)
+		SELECT
+			email_type,
+			COUNT(*),
+			COUNT(*) FILTER (WHERE opened),
+			COUNT(*) FILTER (WHERE clicked),
+			COUNT(*) FILTER (WHERE NOT opened AND NOT clicked)
+		FROM per_student
+		GROUP BY email_type
+		ORDER BY email_type
+	`
+
+	rows, err := db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	campaigns := []CampaignSummary{}
+	for rows.Next() {
+		var cs CampaignSummary
+		if err := rows.Scan(&cs.EmailType, &cs.Sent, &cs.Opened, &cs.Clicked, &cs.Neither); err != nil {
+			continue
+		}
+		campaigns = append(campaigns, cs)
+	}
+	return campaigns, rows.Err()
+}
+
+// GetCampaignCohortHandler handles GET /api/tracking/campaigns/:email_type?cohort=opened|clicked|neither&format=json|csv|xlsx
+// Lists recipients of an email type in the requested engagement cohort
+func GetCampaignCohortHandler(c *fiber.Ctx) error {
+	emailType := string(tracking.ParseEmailType(c.Params("email_type")))
+	cohort := strings.ToLower(c.Query("cohort", "opened"))
+
+	var filter string
+	switch cohort {
+	case "opened":
+		filter = "first_opened_at IS NOT NULL"
+	case "clicked":
+		filter = "first_clicked_at IS NOT NULL"
+	case "neither":
+		filter = "first_opened_at IS NULL AND first_clicked_at IS NULL"
+	default:
+		return apierror.Send(c, fiber.StatusBadRequest, "cohort must be opened, clicked or neither")
+	}
+	format, err := trackingFormat(c)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `
+		WITH per_student AS (
+			SELECT
+				student_id,
+				MIN(created_at) FILTER (WHERE event_type = 'sent') AS sent_at,
+				MIN(created_at) FILTER (WHERE event_type = 'open') AS first_opened_at,
+				MIN(created_at) FILTER (WHERE event_type = 'click') AS first_clicked_at,
+				COUNT(*) FILTER (WHERE event_type = 'click') AS clicks
+			FROM email_events
+			WHERE email_type = $1
+			GROUP BY student_id
+			HAVING bool_or(event_type = 'sent')
+		)
+		SELECT ps.student_id, s.name, s.email, ps.sent_at, ps.first_opened_at, ps.first_clicked_at, ps.clicks
+		FROM per_student ps
+		JOIN students s ON s.id = ps.student_id
+		WHERE ` + filter + `
+		ORDER BY ps.student_id ASC
+	`
+
+	rows, err := db.Pool.Query(ctx, query, emailType)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch campaign cohort")
+	}
+	defer rows.Close()
+
+	students := []CohortStudent{}
+	for rows.Next() {
+		var st CohortStudent
+		if err := rows.Scan(&st.StudentID, &st.Name, &st.Email, &st.SentAt, &st.FirstOpenedAt, &st.FirstClickedAt, &st.Clicks); err != nil {
+			continue
+		}
+		students = append(students, st)
+	}
+	if format != "json" {
+		return sendTrackingExport(c, format, emailType+"-"+cohort, campaignCohortTable(students))
+	}
+
+	return c.JSON(fiber.Map{
+		"email_type": emailType,
+		"cohort":     cohort,
+		"count":      len(students),
+		"students":   students,
 	})
 }
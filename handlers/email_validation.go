@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"mcq-exam/apierror"
+	"mcq-exam/emailcheck"
+	"mcq-exam/logging"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// revalidateTimeout bounds one revalidation of the students table, domain lookups included
+const revalidateTimeout = 5 * time.Minute
+
+// RevalidateEmailsHandler handles POST /api/admin/students/revalidate-emails?mx=true
+// Checks every non-sandbox student's address again and stores the result. mx overrides
+// EMAIL_MX_CHECK for this run. Returns the counts and the students now invalid.
+func RevalidateEmailsHandler(c *fiber.Ctx) error {
+	mx := emailcheck.MXEnabled()
+	if value := c.Query("mx"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "mx must be true or false")
+		}
+		mx = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), revalidateTimeout)
+	defer cancel()
+
+	started := time.Now()
+	report, err := emailcheck.Revalidate(ctx, mx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to revalidate student emails")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to revalidate student emails")
+	}
+	logging.Ctx(c).Info().Int("checked", report.Checked).Int("invalid", report.Invalid).Int("newly_invalid", report.NewlyInvalid).
+		Int("newly_valid", report.NewlyValid).Bool("mx", mx).Dur("duration", time.Since(started)).Msg("Student emails revalidated")
+
+	return c.JSON(report)
+}
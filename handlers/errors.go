@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"mcq-exam/apierror"
+	"mcq-exam/errorreport"
+	"mcq-exam/instance"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetErrorGroupsHandler handles GET /api/admin/errors?kind=panic&limit=50
+// Lists the panics and server errors this instance has seen, grouped by cause, most
+// recently seen first. Groups are kept in memory, so each instance reports its own.
+func GetErrorGroupsHandler(c *fiber.Ctx) error {
+	kind := c.Query("kind")
+	if kind != "" && kind != errorreport.KindPanic && kind != errorreport.KindError {
+		return apierror.Send(c, fiber.StatusBadRequest, "kind must be panic or error")
+	}
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 200 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Limit must be between 1 and 200")
+	}
+
+	summary := errorreport.Groups(kind, limit)
+	return c.JSON(fiber.Map{
+		"instance":  instance.ID(),
+		"reporters": errorreport.Reporters(),
+		"since":     summary.Since,
+		"evicted":   summary.Evicted,
+		"total":     summary.Total,
+		"count":     len(summary.Groups),
+		"groups":    summary.Groups,
+	})
+}
+
+// ClearErrorGroupsHandler handles DELETE /api/admin/errors
+// Clears this instance's error groups, e.g. once a fix is deployed
+func ClearErrorGroupsHandler(c *fiber.Ctx) error {
+	errorreport.Clear()
+	return c.SendStatus(fiber.StatusNoContent)
+}
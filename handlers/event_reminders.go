@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/reminders"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CreateRemindersRequest struct {
+	// EventScheduleID defaults to the latest event
+	EventScheduleID int `json:"event_schedule_id"`
+	// Offsets before first_scheduled_time, e.g. ["24h", "1h", "10m"]
+	Offsets  []string `json:"offsets"`
+	Subject  string   `json:"subject"`
+	HTMLBody string   `json:"html_body"`
+}
+
+type UpdateReminderRequest struct {
+	Offset   *string `json:"offset"`
+	Subject  *string `json:"subject"`
+	HTMLBody *string `json:"html_body"`
+}
+
+// reminderError maps reminders package errors to responses
+func reminderError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, reminders.ErrNotFound):
+		return apierror.Send(c, fiber.StatusNotFound, "Reminder not found")
+	case errors.Is(err, reminders.ErrScheduleNotFound):
+		return apierror.Send(c, fiber.StatusNotFound, "No schedule found")
+	case errors.Is(err, reminders.ErrInvalidOffset):
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	case errors.Is(err, reminders.ErrDuplicate), errors.Is(err, reminders.ErrAlreadySent):
+		return apierror.Send(c, fiber.StatusConflict, err.Error())
+	}
+	logging.Ctx(c).Error().Err(err).Msg(message)
+	return apierror.Send(c, fiber.StatusInternalServerError, message)
+}
+
+// optionalText is nil for blank text, so the reminder default is used
+func optionalText(text string) *string {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	return &text
+}
+
+// CreateRemindersHandler handles POST /api/event/reminders
+// Body: {"offsets": ["24h", "1h", "10m"], "subject": "...", "html_body": "..."}
+// Schedules a reminder campaign to students who have not attended at each offset before
+// the event's first_scheduled_time
+func CreateRemindersHandler(c *fiber.Ctx) error {
+	var req CreateRemindersRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if len(req.Offsets) == 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "offsets is required, e.g. [\"24h\", \"1h\", \"10m\"]")
+	}
+
+	offsets := make([]int, 0, len(req.Offsets))
+	seen := make(map[int]bool)
+	for _, offset := range req.Offsets {
+		minutes, err := reminders.ParseOffset(offset)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+		}
+		if seen[minutes] {
+			return apierror.Send(c, fiber.StatusBadRequest, "offsets must not repeat")
+		}
+		seen[minutes] = true
+		offsets = append(offsets, minutes)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	scheduleID := req.EventScheduleID
+	if scheduleID == 0 {
+		var err error
+		if scheduleID, err = reminders.LatestScheduleID(ctx); err != nil {
+			return reminderError(c, err, "Failed to fetch schedule")
+		}
+	}
+
+	list, err := reminders.Create(ctx, scheduleID, offsets, optionalText(req.Subject), optionalText(req.HTMLBody))
+	if err != nil {
+		return reminderError(c, err, "Failed to create reminders")
+	}
+	logging.Ctx(c).Info().Int("event_schedule_id", scheduleID).Strs("offsets", req.Offsets).Msg("Conference reminders scheduled")
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"event_schedule_id": scheduleID,
+		"reminders":         list,
+	})
+}
+
+// GetRemindersHandler handles GET /api/event/reminders?event_schedule_id=3
+// Lists an event's reminders (the latest event by default) with their job and campaign status
+func GetRemindersHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	scheduleID := c.QueryInt("event_schedule_id")
+	if scheduleID == 0 {
+		var err error
+		if scheduleID, err = reminders.LatestScheduleID(ctx); err != nil {
+			return reminderError(c, err, "Failed to fetch schedule")
+		}
+	}
+
+	list, err := reminders.List(ctx, scheduleID)
+	if err != nil {
+		return reminderError(c, err, "Failed to fetch reminders")
+	}
+
+	return c.JSON(fiber.Map{
+		"event_schedule_id": scheduleID,
+		"reminders":         list,
+		"count":             len(list),
+	})
+}
+
+// GetReminderHandler handles GET /api/event/reminders/:id
+func GetReminderHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid reminder ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reminder, err := reminders.Get(ctx, id)
+	if err != nil {
+		return reminderError(c, err, "Failed to fetch reminder")
+	}
+	return c.JSON(reminder)
+}
+
+// UpdateReminderHandler handles PUT /api/event/reminders/:id
+// Body: {"offset": "2h", "subject": "...", "html_body": "..."}; omitted fields are kept and an
+// empty subject or html_body restores the default. Only reminders not yet sent can change.
+func UpdateReminderHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid reminder ID")
+	}
+
+	var req UpdateReminderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	var offset *int
+	if req.Offset != nil {
+		minutes, err := reminders.ParseOffset(*req.Offset)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+		}
+		offset = &minutes
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reminder, err := reminders.Update(ctx, id, offset, req.Subject, req.HTMLBody)
+	if err != nil {
+		return reminderError(c, err, "Failed to update reminder")
+	}
+	logging.Ctx(c).Info().Int("reminder_id", id).Str("offset", reminder.Offset).Msg("Conference reminder updated")
+	return c.JSON(reminder)
+}
+
+// DeleteReminderHandler handles DELETE /api/event/reminders/:id
+// Removes the reminder and its pending job; a campaign it already launched is not affected
+func DeleteReminderHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid reminder ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := reminders.Delete(ctx, id); err != nil {
+		return reminderError(c, err, "Failed to delete reminder")
+	}
+	logging.Ctx(c).Info().Int("reminder_id", id).Msg("Conference reminder deleted")
+	return c.JSON(fiber.Map{"message": "Reminder deleted"})
+}
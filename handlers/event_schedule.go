@@ -2,8 +2,12 @@ package handlers
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"mcq-exam/apierror"
 	"mcq-exam/db"
+	"mcq-exam/examwindow"
+	"mcq-exam/logging"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -13,37 +17,98 @@ type CreateScheduleRequest struct {
 	FirstScheduledTime  string `json:"first_scheduled_time"`   // ISO8601 format
 	SecondScheduledTime string `json:"second_scheduled_time"` // ISO8601 format
 	VideoURL            string `json:"video_url"`
+	// Timezone the times are given in (IANA name, default Asia/Kolkata)
+	Timezone string `json:"timezone"`
+	// WindowPolicy is "global" (default) or "local"; see examwindow
+	WindowPolicy  string `json:"window_policy"`
+	WindowMinutes int    `json:"window_minutes"`
+	ExamEntry
+}
+
+type UpdateWindowRequest struct {
+	WindowPolicy  string `json:"window_policy"`
+	WindowMinutes int    `json:"window_minutes"`
+	ExamEntry
+}
+
+// ExamEntry is when participants may start the exam around their window; see examwindow.Entry
+type ExamEntry struct {
+	EarlyEntryMinutes int `json:"early_entry_minutes"`
+	// LateEntryMinutes is 0 to keep entry open until the window ends
+	LateEntryMinutes     int  `json:"late_entry_minutes"`
+	LateEntryReducedTime bool `json:"late_entry_reduced_time"`
+}
+
+// validateWindow fills in the window defaults and checks the policy and duration
+func validateWindow(policy *string, minutes *int) error {
+	if *policy == "" {
+		*policy = examwindow.PolicyGlobal
+	}
+	if *minutes == 0 {
+		*minutes = examwindow.DefaultMinutes
+	}
+	if !examwindow.ValidPolicy(*policy) {
+		return fmt.Errorf("window_policy must be %q or %q", examwindow.PolicyGlobal, examwindow.PolicyLocal)
+	}
+	if *minutes < 1 || *minutes > 24*60 {
+		return errors.New("window_minutes must be between 1 and 1440")
+	}
+	return nil
+}
+
+// validateEntry checks the entry times against a window of windowMinutes
+func validateEntry(entry ExamEntry, windowMinutes int) error {
+	if entry.EarlyEntryMinutes < 0 || entry.EarlyEntryMinutes > 24*60 {
+		return errors.New("early_entry_minutes must be between 0 and 1440")
+	}
+	if entry.LateEntryMinutes < 0 || entry.LateEntryMinutes > windowMinutes {
+		return errors.New("late_entry_minutes must be between 0 and window_minutes")
+	}
+	return nil
 }
 
 // CreateEventScheduleHandler handles POST /api/event/schedule
-// Creates a new event schedule with 2 timed function calls
+// Creates a new event schedule and its 2 one-shot scheduled jobs
 func CreateEventScheduleHandler(c *fiber.Ctx) error {
 	var req CreateScheduleRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if req.Timezone == "" {
+		req.Timezone = examwindow.DefaultTimezone
+	}
+	if err := examwindow.ValidTimezone(req.Timezone); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "timezone must be an IANA timezone, e.g. Asia/Kolkata")
+	}
+	if err := validateWindow(&req.WindowPolicy, &req.WindowMinutes); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if err := validateEntry(req.ExamEntry, req.WindowMinutes); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
 	}
 
-	// Load IST timezone
-	istLocation, err := time.LoadLocation("Asia/Kolkata")
+	// Load the schedule's timezone (IST unless given)
+	location, err := time.LoadLocation(req.Timezone)
 	if err != nil {
-		log.Printf("Failed to load IST timezone: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Server timezone error"})
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load schedule timezone")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Server timezone error")
 	}
 
-	// Parse times as IST (Asia/Kolkata)
-	firstTime, err := time.ParseInLocation("2006-01-02T15:04:05", req.FirstScheduledTime, istLocation)
+	// Parse times in the schedule's timezone
+	firstTime, err := time.ParseInLocation("2006-01-02T15:04:05", req.FirstScheduledTime, location)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid first_scheduled_time format. Use YYYY-MM-DDTHH:MM:SS in IST (e.g., 2025-10-05T15:30:00)"})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid first_scheduled_time format. Use YYYY-MM-DDTHH:MM:SS in IST (e.g., 2025-10-05T15:30:00)")
 	}
 
-	secondTime, err := time.ParseInLocation("2006-01-02T15:04:05", req.SecondScheduledTime, istLocation)
+	secondTime, err := time.ParseInLocation("2006-01-02T15:04:05", req.SecondScheduledTime, location)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid second_scheduled_time format. Use YYYY-MM-DDTHH:MM:SS in IST (e.g., 2025-10-05T18:00:00)"})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid second_scheduled_time format. Use YYYY-MM-DDTHH:MM:SS in IST (e.g., 2025-10-05T18:00:00)")
 	}
 
 	// Validate second time is after first time
 	if secondTime.Before(firstTime) || secondTime.Equal(firstTime) {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "second_scheduled_time must be after first_scheduled_time"})
+		return apierror.Send(c, fiber.StatusBadRequest, "second_scheduled_time must be after first_scheduled_time")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -51,55 +116,77 @@ func CreateEventScheduleHandler(c *fiber.Ctx) error {
 
 	// Validate video URL
 	if req.VideoURL == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "video_url is required"})
+		return apierror.Send(c, fiber.StatusBadRequest, "video_url is required")
 	}
 
 	// Hardcoded function names
 	firstFunction := "Phase1FirstMailVerification"
 	secondFunction := "Phase2SecondMailSending"
 
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to create schedule")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to create schedule")
+	}
+	defer tx.Rollback(ctx)
+
 	// Insert schedule
 	query := `
-		INSERT INTO event_schedule (first_function, first_scheduled_time, second_function, second_scheduled_time, video_url)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO event_schedule (first_scheduled_time, second_scheduled_time, video_url, timezone, window_policy, window_minutes,
+		                            early_entry_minutes, late_entry_minutes, late_entry_reduced_time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING id
 	`
 
 	var scheduleID int
-	err = db.Pool.QueryRow(ctx, query, firstFunction, firstTime, secondFunction, secondTime, req.VideoURL).Scan(&scheduleID)
+	err = tx.QueryRow(ctx, query, firstTime, secondTime, req.VideoURL, req.Timezone, req.WindowPolicy, req.WindowMinutes,
+		req.EarlyEntryMinutes, req.LateEntryMinutes, req.LateEntryReducedTime).Scan(&scheduleID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to create schedule")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to create schedule")
+	}
+
+	// The mail phases run as one-shot scheduled jobs linked to the event by payload
+	jobQuery := `
+		INSERT INTO scheduled_jobs (name, function_name, run_at, next_run_at, payload)
+		VALUES ($1, $2, $3, $3, jsonb_build_object('event_schedule_id', $4::int))
+	`
+	_, err = tx.Exec(ctx, jobQuery, fmt.Sprintf("Event %d first mail", scheduleID), firstFunction, firstTime, scheduleID)
+	if err == nil {
+		_, err = tx.Exec(ctx, jobQuery, fmt.Sprintf("Event %d second mail", scheduleID), secondFunction, secondTime, scheduleID)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
 	if err != nil {
-		log.Printf("Failed to create schedule: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create schedule"})
+		logging.Ctx(c).Error().Err(err).Msg("Failed to create schedule jobs")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to create schedule")
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"message":               "Schedule created successfully",
 		"schedule_id":           scheduleID,
 		"first_function":        firstFunction,
-		"first_scheduled_time":  firstTime.In(istLocation).Format("2006-01-02T15:04:05 IST"),
+		"first_scheduled_time":  firstTime.In(location).Format("2006-01-02T15:04:05 MST"),
 		"second_function":       secondFunction,
-		"second_scheduled_time": secondTime.In(istLocation).Format("2006-01-02T15:04:05 IST"),
+		"second_scheduled_time": secondTime.In(location).Format("2006-01-02T15:04:05 MST"),
 		"video_url":             req.VideoURL,
+		"timezone":              req.Timezone,
+		"window_policy":         req.WindowPolicy,
+		"window_minutes":        req.WindowMinutes,
+		"exam_entry":            req.ExamEntry,
 	})
 }
 
 // GetEventScheduleHandler handles GET /api/event/schedule
-// Returns the current event schedule
+// Returns the current event schedule, with execution status from its scheduled jobs
 func GetEventScheduleHandler(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// Load IST timezone
-	istLocation, err := time.LoadLocation("Asia/Kolkata")
-	if err != nil {
-		log.Printf("Failed to load IST timezone: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Server timezone error"})
-	}
-
 	query := `
-		SELECT id, first_function, first_scheduled_time, first_executed, first_executed_at,
-		       second_function, second_scheduled_time, second_executed, second_executed_at,
-		       created_at, video_url
+		SELECT id, first_scheduled_time, second_scheduled_time, created_at, video_url, timezone, window_policy, window_minutes,
+		       early_entry_minutes, late_entry_minutes, late_entry_reduced_time
 		FROM event_schedule
 		ORDER BY id DESC
 		LIMIT 1
@@ -117,24 +204,67 @@ func GetEventScheduleHandler(c *fiber.Ctx) error {
 		SecondExecutedAt    *time.Time `json:"second_executed_at"`
 		CreatedAt           time.Time  `json:"created_at"`
 		VideoURL            string     `json:"video_url"`
+		Timezone            string     `json:"timezone"`
+		WindowPolicy        string     `json:"window_policy"`
+		WindowMinutes       int        `json:"window_minutes"`
+		ExamEntry           ExamEntry  `json:"exam_entry"`
 	}
 
-	err = db.Pool.QueryRow(ctx, query).Scan(
+	err := db.Pool.QueryRow(ctx, query).Scan(
 		&schedule.ID,
-		&schedule.FirstFunction,
 		&schedule.FirstScheduledTime,
-		&schedule.FirstExecuted,
-		&schedule.FirstExecutedAt,
-		&schedule.SecondFunction,
 		&schedule.SecondScheduledTime,
-		&schedule.SecondExecuted,
-		&schedule.SecondExecutedAt,
 		&schedule.CreatedAt,
 		&schedule.VideoURL,
+		&schedule.Timezone,
+		&schedule.WindowPolicy,
+		&schedule.WindowMinutes,
+		&schedule.ExamEntry.EarlyEntryMinutes,
+		&schedule.ExamEntry.LateEntryMinutes,
+		&schedule.ExamEntry.LateEntryReducedTime,
 	)
 
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "No schedule found"})
+		return apierror.Send(c, fiber.StatusNotFound, "No schedule found")
+	}
+
+	// The first and second functions are the event's jobs in run order
+	jobsQuery := `
+		SELECT id, function_name, status = 'completed', CASE WHEN status = 'completed' THEN last_run_at END
+		FROM scheduled_jobs
+		WHERE payload->>'event_schedule_id' = $1::text
+		ORDER BY run_at ASC, id ASC
+		LIMIT 2
+	`
+	rows, err := db.Pool.Query(ctx, jobsQuery, schedule.ID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch schedule jobs")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch schedule")
+	}
+	defer rows.Close()
+
+	jobIDs := []int{}
+	for i := 0; rows.Next(); i++ {
+		var jobID int
+		var function string
+		var executed bool
+		var executedAt *time.Time
+		if err := rows.Scan(&jobID, &function, &executed, &executedAt); err != nil {
+			continue
+		}
+		jobIDs = append(jobIDs, jobID)
+		if i == 0 {
+			schedule.FirstFunction, schedule.FirstExecuted, schedule.FirstExecutedAt = function, executed, executedAt
+		} else {
+			schedule.SecondFunction, schedule.SecondExecuted, schedule.SecondExecutedAt = function, executed, executedAt
+		}
+	}
+
+	// Times are shown in the schedule's timezone (IST unless another was given)
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load schedule timezone")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Server timezone error")
 	}
 
 	// Helper function to format nullable time
@@ -142,22 +272,123 @@ func GetEventScheduleHandler(c *fiber.Ctx) error {
 		if t == nil {
 			return nil
 		}
-		formatted := t.In(istLocation).Format("2006-01-02T15:04:05 IST")
+		formatted := t.In(location).Format("2006-01-02T15:04:05 MST")
 		return &formatted
 	}
 
-	// Return schedule with all times converted to IST
+	// Return schedule with all times converted to the schedule's timezone
 	return c.JSON(fiber.Map{
 		"id":                    schedule.ID,
 		"first_function":        schedule.FirstFunction,
-		"first_scheduled_time":  schedule.FirstScheduledTime.In(istLocation).Format("2006-01-02T15:04:05 IST"),
+		"first_scheduled_time":  schedule.FirstScheduledTime.In(location).Format("2006-01-02T15:04:05 MST"),
 		"first_executed":        schedule.FirstExecuted,
 		"first_executed_at":     formatTimeIST(schedule.FirstExecutedAt),
 		"second_function":       schedule.SecondFunction,
-		"second_scheduled_time": schedule.SecondScheduledTime.In(istLocation).Format("2006-01-02T15:04:05 IST"),
+		"second_scheduled_time": schedule.SecondScheduledTime.In(location).Format("2006-01-02T15:04:05 MST"),
 		"second_executed":       schedule.SecondExecuted,
 		"second_executed_at":    formatTimeIST(schedule.SecondExecutedAt),
-		"created_at":            schedule.CreatedAt.In(istLocation).Format("2006-01-02T15:04:05 IST"),
+		"created_at":            schedule.CreatedAt.In(location).Format("2006-01-02T15:04:05 MST"),
 		"video_url":             schedule.VideoURL,
+		"timezone":              schedule.Timezone,
+		"window_policy":         schedule.WindowPolicy,
+		"window_minutes":        schedule.WindowMinutes,
+		"exam_entry":            schedule.ExamEntry,
+		"job_ids":               jobIDs,
+	})
+}
+
+// UpdateExamWindowHandler handles PUT /api/event/schedule/window
+// Body: {"window_policy": "local", "window_minutes": 360, "early_entry_minutes": 15,
+// "late_entry_minutes": 30, "late_entry_reduced_time": true}. Changes the latest event's window
+// policy and entry times and recomputes the stored windows for every participant timezone.
+func UpdateExamWindowHandler(c *fiber.Ctx) error {
+	var req UpdateWindowRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if err := validateWindow(&req.WindowPolicy, &req.WindowMinutes); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if err := validateEntry(req.ExamEntry, req.WindowMinutes); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	schedule, err := examwindow.Latest(ctx)
+	if errors.Is(err, examwindow.ErrNoSchedule) {
+		return apierror.Send(c, fiber.StatusNotFound, "No schedule found")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch schedule")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch schedule")
+	}
+
+	query := `
+		UPDATE event_schedule
+		SET window_policy = $1, window_minutes = $2,
+		    early_entry_minutes = $3, late_entry_minutes = $4, late_entry_reduced_time = $5, updated_at = NOW()
+		WHERE id = $6
+	`
+	if _, err := db.Pool.Exec(ctx, query, req.WindowPolicy, req.WindowMinutes,
+		req.EarlyEntryMinutes, req.LateEntryMinutes, req.LateEntryReducedTime, schedule.ID); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to update exam window")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to update exam window")
+	}
+	schedule.Policy, schedule.Minutes = req.WindowPolicy, req.WindowMinutes
+	schedule.EarlyEntryMinutes, schedule.LateEntryMinutes, schedule.ReducedTime = req.EarlyEntryMinutes, req.LateEntryMinutes, req.LateEntryReducedTime
+
+	written, err := examwindow.Rebuild(ctx, schedule)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to rebuild exam windows")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to rebuild exam windows")
+	}
+
+	return c.JSON(fiber.Map{
+		"message":         "Exam window updated",
+		"schedule_id":     schedule.ID,
+		"window_policy":   schedule.Policy,
+		"window_minutes":  schedule.Minutes,
+		"exam_entry":      req.ExamEntry,
+		"windows_written": written,
+	})
+}
+
+// GetExamWindowsHandler handles GET /api/event/schedule/windows
+// Returns the latest event's exam window for each participant timezone, in UTC
+func GetExamWindowsHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	schedule, err := examwindow.Latest(ctx)
+	if errors.Is(err, examwindow.ErrNoSchedule) {
+		return apierror.Send(c, fiber.StatusNotFound, "No schedule found")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch schedule")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch schedule")
+	}
+
+	windows, err := examwindow.Stored(ctx, schedule.ID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch exam windows")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch exam windows")
+	}
+
+	var unknown int
+	if err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM students WHERE timezone IS NULL`).Scan(&unknown); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to count students without a timezone")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch exam windows")
+	}
+
+	return c.JSON(fiber.Map{
+		"schedule_id":               schedule.ID,
+		"window_policy":             schedule.Policy,
+		"window_minutes":            schedule.Minutes,
+		"exam_entry":                ExamEntry{schedule.EarlyEntryMinutes, schedule.LateEntryMinutes, schedule.ReducedTime},
+		"timezone":                  schedule.Timezone,
+		"students_without_timezone": unknown,
+		"windows":                   windows,
 	})
 }
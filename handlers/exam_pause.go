@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/events"
+	"mcq-exam/exampause"
+	"mcq-exam/logging"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxPauseReasonLength bounds the reason shown to candidates in session-state
+const maxPauseReasonLength = 500
+
+type PauseExamRequest struct {
+	Reason string `json:"reason"`
+}
+
+// GetExamStateHandler handles GET /api/admin/exam/state
+// Returns whether the exam is paused and the 20 latest pauses
+func GetExamStateHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	state, err := exampause.Current(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load exam state")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load exam state")
+	}
+	recent, err := exampause.Recent(ctx, 20)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to list exam pauses")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load exam state")
+	}
+
+	return c.JSON(fiber.Map{
+		"paused": state.Paused,
+		"pause":  state.Pause,
+		"recent": recent,
+	})
+}
+
+// PauseExamHandler handles POST /api/admin/exam/pause
+// Body (optional): {"reason": "Video stream down"}. Pauses the exam for every candidate:
+// answers and end-session are refused with 423 and section and question timers stand
+// still until POST /api/admin/exam/resume.
+func PauseExamHandler(c *fiber.Ctx) error {
+	var req PauseExamRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len(req.Reason) > maxPauseReasonLength {
+		return apierror.Send(c, fiber.StatusBadRequest, "reason must be at most 500 characters")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	pause, err := exampause.Start(ctx, req.Reason)
+	if errors.Is(err, exampause.ErrAlreadyPaused) {
+		return apierror.Send(c, fiber.StatusConflict, "Exam is already paused")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to pause exam")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to pause exam")
+	}
+	logging.Ctx(c).Warn().Int("pause_id", pause.ID).Str("reason", pause.Reason).Msg("Exam paused")
+
+	events.Publish(events.ExamPaused, fiber.Map{
+		"pause_id":  pause.ID,
+		"reason":    pause.Reason,
+		"paused_at": pause.PausedAt,
+	})
+
+	return c.JSON(fiber.Map{
+		"message": "Exam paused",
+		"pause":   pause,
+	})
+}
+
+// ResumeExamHandler handles POST /api/admin/exam/resume
+// Ends the open pause; every timer continues from where it stood when the exam was paused
+func ResumeExamHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	pause, err := exampause.Resume(ctx)
+	if errors.Is(err, exampause.ErrNotPaused) {
+		return apierror.Send(c, fiber.StatusConflict, "Exam is not paused")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to resume exam")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to resume exam")
+	}
+	logging.Ctx(c).Info().Int("pause_id", pause.ID).Int("duration_seconds", pause.DurationSeconds).Msg("Exam resumed")
+
+	events.Publish(events.ExamResumed, fiber.Map{
+		"pause_id":         pause.ID,
+		"reason":           pause.Reason,
+		"paused_at":        pause.PausedAt,
+		"resumed_at":       pause.ResumedAt,
+		"duration_seconds": pause.DurationSeconds,
+	})
+
+	return c.JSON(fiber.Map{
+		"message": "Exam resumed",
+		"pause":   pause,
+	})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/reporting"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GraphQLHandler handles POST /api/stats/graphql
+// Body: {"query": "{ scoreStats(groupBy: COUNTRY) { key participants averageScore } }", "variables": {}}
+// Read-only reporting over students, sessions, answers, email tracking and leaderboard
+// aggregates. Errors are returned in the response's "errors" list with 200, as GraphQL
+// clients expect.
+func GraphQLHandler(c *fiber.Ctx) error {
+	var req GraphQLRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "query is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	response := reporting.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	if len(response.Errors) > 0 {
+		logging.Ctx(c).Warn().Str("operation", req.OperationName).Interface("errors", response.Errors).Msg("GraphQL query returned errors")
+	}
+	return c.JSON(response)
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"mcq-exam/db"
+	"mcq-exam/instance"
+	"mcq-exam/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthFailing  = "failing"
+
+	// healthCheckTimeout bounds each probe so a hung dependency cannot stall the others
+	healthCheckTimeout = 2 * time.Second
+)
+
+// DependencyStatus is one dependency's result in the readiness response
+type DependencyStatus struct {
+	Status    string                 `json:"status"`
+	LatencyMs int64                  `json:"latency_ms,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// LivenessHandler handles GET /health/live
+// Only confirms the process is serving requests; it never touches dependencies,
+// so a database outage does not get the instance restarted
+func LivenessHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": healthOK})
+}
+
+// ReadinessHandler handles GET /health/ready
+// Probes the database, migration state, email provider config and Redis (when configured).
+// Returns 503 if any of them is failing, or while the instance is waiting for migrations or
+// draining for shutdown, so load balancers stop routing to it.
+func ReadinessHandler(c *fiber.Ctx) error {
+	checks := map[string]DependencyStatus{
+		"database":   checkDatabase(),
+		"migrations": checkMigrations(),
+		"email":      checkEmailProvider(),
+	}
+	if db.Redis != nil {
+		checks["redis"] = checkRedis()
+	}
+
+	status := healthOK
+	httpStatus := fiber.StatusOK
+	for _, check := range checks {
+		if check.Status == healthFailing {
+			status = healthFailing
+			httpStatus = fiber.StatusServiceUnavailable
+			break
+		}
+		if check.Status == healthDegraded {
+			status = healthDegraded
+		}
+	}
+
+	phase := instance.Phase()
+	if phase != instance.PhaseServing {
+		status = healthFailing
+		httpStatus = fiber.StatusServiceUnavailable
+	}
+
+	return c.Status(httpStatus).JSON(fiber.Map{
+		"status":   status,
+		"phase":    phase,
+		"instance": instance.ID(),
+		"checks":   checks,
+	})
+}
+
+// checkDatabase pings through the pool, so an exhausted pool fails the probe once the timeout
+// expires. A pool with no idle connections left is reported as degraded.
+func checkDatabase() DependencyStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := db.Pool.Ping(ctx)
+	latency := time.Since(start).Milliseconds()
+
+	stat := db.Pool.Stat()
+	details := map[string]interface{}{
+		"total_conns":    stat.TotalConns(),
+		"acquired_conns": stat.AcquiredConns(),
+		"idle_conns":     stat.IdleConns(),
+		"max_conns":      stat.MaxConns(),
+	}
+
+	if err != nil {
+		return DependencyStatus{Status: healthFailing, LatencyMs: latency, Error: err.Error(), Details: details}
+	}
+	if stat.AcquiredConns() >= stat.MaxConns() {
+		return DependencyStatus{Status: healthDegraded, LatencyMs: latency, Error: "connection pool exhausted", Details: details}
+	}
+	return DependencyStatus{Status: healthOK, LatencyMs: latency, Details: details}
+}
+
+func checkMigrations() DependencyStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	state, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return DependencyStatus{Status: healthFailing, Error: err.Error()}
+	}
+
+	details := map[string]interface{}{
+		"version": state.Version,
+		"latest":  state.Latest,
+		"dirty":   state.Dirty,
+	}
+	if state.Dirty {
+		return DependencyStatus{Status: healthFailing, Error: "last migration failed (dirty)", Details: details}
+	}
+	if !state.Current() {
+		return DependencyStatus{Status: healthFailing, Error: "pending migrations", Details: details}
+	}
+	return DependencyStatus{Status: healthOK, Details: details}
+}
+
+// checkEmailProvider only validates configuration; it does not call the provider
+func checkEmailProvider() DependencyStatus {
+	primary, fallback, err := utils.EmailProviderStatus()
+	if err != nil {
+		return DependencyStatus{Status: healthFailing, Error: err.Error()}
+	}
+
+	details := map[string]interface{}{"provider": primary}
+	if fallback != "" {
+		details["fallback"] = fallback
+	}
+	return DependencyStatus{Status: healthOK, Details: details}
+}
+
+func checkRedis() DependencyStatus {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := db.Redis.Ping(ctx).Err()
+	latency := time.Since(start).Milliseconds()
+	if err != nil {
+		return DependencyStatus{Status: healthFailing, LatencyMs: latency, Error: err.Error()}
+	}
+	return DependencyStatus{Status: healthOK, LatencyMs: latency}
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/i18n"
+	"mcq-exam/logging"
+	"mcq-exam/questions"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type PutTemplateRequest struct {
+	Subject  string `json:"subject"`
+	HTMLBody string `json:"html_body"`
+}
+
+// LocalizedSection is a section of the exam with its name in the requested locale
+type LocalizedSection struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	TimeLimit int    `json:"time_limit"`
+}
+
+// i18nError maps i18n package errors to responses
+func i18nError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, i18n.ErrNotFound):
+		return apierror.Send(c, fiber.StatusNotFound, "Template not found")
+	case errors.Is(err, i18n.ErrInvalidKey), errors.Is(err, i18n.ErrUnsupportedLocale),
+		errors.Is(err, i18n.ErrIncompleteVariant), errors.Is(err, i18n.ErrInvalidStringKey):
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	logging.Ctx(c).Error().Err(err).Msg(message)
+	return apierror.Send(c, fiber.StatusInternalServerError, message)
+}
+
+// requestLocale is the ?locale= of the request, else the first supported language of its
+// Accept-Language header, else the default locale
+func requestLocale(c *fiber.Ctx) (string, error) {
+	if value := strings.TrimSpace(c.Query("locale")); value != "" {
+		locale, ok := i18n.Normalize(value)
+		if !ok {
+			return "", i18n.ErrUnsupportedLocale
+		}
+		return locale, nil
+	}
+	if locale, ok := i18n.FromAcceptLanguage(c.Get(fiber.HeaderAcceptLanguage)); ok {
+		return locale, nil
+	}
+	return i18n.Default(), nil
+}
+
+// GetUIStringsHandler handles GET /api/i18n/strings?locale=fr
+// Returns the quiz UI labels and section names in the locale; any label missing in it
+// falls back to the default locale, then to English
+func GetUIStringsHandler(c *fiber.Ctx) error {
+	locale, err := requestLocale(c)
+	if err != nil {
+		return i18nError(c, err, "Invalid locale")
+	}
+
+	sections, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	strs, err := i18n.Strings(ctx, locale)
+	if err != nil {
+		return i18nError(c, err, "Failed to fetch UI strings")
+	}
+
+	localized := make([]LocalizedSection, 0, len(sections))
+	for _, section := range sections {
+		name := section.Name
+		if value, ok := strs[i18n.SectionNameKey(section.ID)]; ok {
+			name = value
+		}
+		localized = append(localized, LocalizedSection{ID: section.ID, Name: name, TimeLimit: section.TimeLimit})
+	}
+
+	c.Vary(fiber.HeaderAcceptLanguage)
+	return c.JSON(fiber.Map{
+		"locale":         locale,
+		"default_locale": i18n.Default(),
+		"locales":        i18n.Locales,
+		"strings":        strs,
+		"sections":       localized,
+	})
+}
+
+// GetUIStringOverridesHandler handles GET /api/admin/i18n/strings/:locale
+// Returns only the strings stored for the locale, which replace the built-in ones
+func GetUIStringOverridesHandler(c *fiber.Ctx) error {
+	locale, ok := i18n.Normalize(c.Params("locale"))
+	if !ok {
+		return i18nError(c, i18n.ErrUnsupportedLocale, "Invalid locale")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	overrides, err := i18n.Overrides(ctx, locale)
+	if err != nil {
+		return i18nError(c, err, "Failed to fetch UI strings")
+	}
+
+	return c.JSON(fiber.Map{
+		"locale":  locale,
+		"count":   len(overrides),
+		"strings": overrides,
+	})
+}
+
+// UpdateUIStringsHandler handles PUT /api/admin/i18n/strings/:locale
+// Body: {"exam.next": "Continuer", "section.1.name": "Histoire", "exam.previous": ""}
+// Stores the given strings for the locale; an empty value removes the override
+func UpdateUIStringsHandler(c *fiber.Ctx) error {
+	var values map[string]string
+	if err := c.BodyParser(&values); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if len(values) == 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "At least one string is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := i18n.SetStrings(ctx, c.Params("locale"), values); err != nil {
+		return i18nError(c, err, "Failed to save UI strings")
+	}
+	locale, _ := i18n.Normalize(c.Params("locale"))
+	overrides, err := i18n.Overrides(ctx, locale)
+	if err != nil {
+		return i18nError(c, err, "Failed to fetch UI strings")
+	}
+	logging.Ctx(c).Info().Str("locale", locale).Int("updated", len(values)).Msg("UI strings updated")
+
+	return c.JSON(fiber.Map{
+		"locale":  locale,
+		"count":   len(overrides),
+		"strings": overrides,
+	})
+}
+
+// GetEmailTemplatesHandler handles GET /api/mail/templates?template_key=firstMail
+// Lists the stored email templates by key and locale
+func GetEmailTemplatesHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	templates, err := i18n.List(ctx, strings.TrimSpace(c.Query("template_key")))
+	if err != nil {
+		return i18nError(c, err, "Failed to fetch templates")
+	}
+
+	return c.JSON(fiber.Map{
+		"count":     len(templates),
+		"builtin":   i18n.BuiltinTemplates,
+		"locales":   i18n.Locales,
+		"templates": templates,
+	})
+}
+
+// PutEmailTemplateHandler handles PUT /api/mail/templates/:key/:locale
+// Creates or replaces a template's subject and body in one locale
+func PutEmailTemplateHandler(c *fiber.Ctx) error {
+	var req PutTemplateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if strings.TrimSpace(req.Subject) == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "subject is required")
+	}
+	if strings.TrimSpace(req.HTMLBody) == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "html_body is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	template, err := i18n.Put(ctx, c.Params("key"), c.Params("locale"), req.Subject, req.HTMLBody)
+	if err != nil {
+		return i18nError(c, err, "Failed to save template")
+	}
+	logging.Ctx(c).Info().Str("template_key", template.Key).Str("locale", template.Locale).Msg("Email template saved")
+
+	return c.JSON(template)
+}
+
+// DeleteEmailTemplateHandler handles DELETE /api/mail/templates/:key/:locale
+// Removes a template's locale; its emails fall back to the default locale or the built-in text
+func DeleteEmailTemplateHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := i18n.Delete(ctx, c.Params("key"), c.Params("locale")); err != nil {
+		return i18nError(c, err, "Failed to delete template")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/scheduler"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type TriggerJobRequest struct {
+	// Force runs the function again even though it has completed a run before
+	Force   bool            `json:"force"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// GetJobHistoryHandler handles GET /api/admin/jobs/history?function=Phase1FirstMailVerification&job_id=3&status=failed&limit=50&offset=0
+// Lists function executions, newest first, with their duration and error
+func GetJobHistoryHandler(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	offset := c.QueryInt("offset", 0)
+	if limit < 1 || limit > 500 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Limit must be between 1 and 500")
+	}
+	if offset < 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Offset must not be negative")
+	}
+	jobID := c.QueryInt("job_id", 0)
+	function := strings.TrimSpace(c.Query("function"))
+	status := strings.TrimSpace(c.Query("status"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := `
+		WHERE ($1 = '' OR function_name = $1)
+		  AND ($2 = 0 OR job_id = $2)
+		  AND ($3 = '' OR status = $3)
+	`
+
+	var total int
+	if err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM job_executions`+filter, function, jobID, status).Scan(&total); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to count job executions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch job history")
+	}
+
+	query := `SELECT ` + scheduler.ExecutionColumns + ` FROM job_executions` + filter + `ORDER BY id DESC LIMIT $4 OFFSET $5`
+	rows, err := db.Pool.Query(ctx, query, function, jobID, status, limit, offset)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch job executions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch job history")
+	}
+	defer rows.Close()
+
+	executions := []*scheduler.Execution{}
+	for rows.Next() {
+		execution, err := scheduler.ScanExecution(rows)
+		if err != nil {
+			continue
+		}
+		executions = append(executions, execution)
+	}
+
+	return c.JSON(fiber.Map{
+		"executions": executions,
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+		"count":      len(executions),
+	})
+}
+
+// TriggerJobHandler handles POST /api/admin/jobs/:name/trigger
+// Body (optional): {"force": true, "payload": {...}}
+// Runs a registered function now in the background and returns its execution (202)
+func TriggerJobHandler(c *fiber.Ctx) error {
+	name := c.Params("name")
+
+	var req TriggerJobRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	execution, err := scheduler.Trigger(ctx, name, req.Payload, req.Force)
+	switch {
+	case errors.Is(err, scheduler.ErrUnknownFunction):
+		return apierror.Send(c, fiber.StatusNotFound, fmt.Sprintf("Unknown function '%s'. Valid functions: %s", name, strings.Join(scheduler.FunctionNames(), ", ")))
+	case errors.Is(err, scheduler.ErrAlreadyRunning), errors.Is(err, scheduler.ErrAlreadyCompleted):
+		return apierror.Send(c, fiber.StatusConflict, err.Error())
+	case err != nil:
+		logging.Ctx(c).Error().Err(err).Str("function", name).Msg("Failed to trigger function")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to trigger function")
+	}
+
+	logging.Ctx(c).Info().Str("function", name).Int("execution_id", execution.ID).Bool("forced", req.Force).Msg("Function triggered")
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message":   "Function started",
+		"execution": execution,
+	})
+}
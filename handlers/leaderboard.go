@@ -2,13 +2,18 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/attempts"
 	"mcq-exam/db"
-	"os"
+	"mcq-exam/logging"
+	"mcq-exam/questions"
+	"mcq-exam/ranking"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
 )
 
 // ============================================
@@ -22,222 +27,330 @@ type LeaderboardEntry struct {
 	Email                 string `json:"email"`
 	Score                 int    `json:"score"`
 	TotalTimeTakenSeconds int    `json:"total_time_taken_seconds"`
+	// AutoCompleted marks a result the server finalized for a candidate who never ended the test
+	AutoCompleted bool `json:"auto_completed"`
 }
 
 type OverallLeaderboardResponse struct {
 	Success bool               `json:"success"`
 	Message string             `json:"message,omitempty"`
 	Total   int                `json:"total,omitempty"`
+	Ranking []string           `json:"ranking,omitempty"`
 	Data    []LeaderboardEntry `json:"data,omitempty"`
+	// Limit and Offset are the page returned
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
 }
 
-// GetOverallLeaderboardHandler handles GET /api/leaderboard/overall
+// leaderboardPage reads the limit (default 100, at most 1000) and offset of a leaderboard
+// page; message is set when they are invalid
+func leaderboardPage(c *fiber.Ctx) (limit, offset int, message string) {
+	limit = c.QueryInt("limit", 100)
+	offset = c.QueryInt("offset", 0)
+	if limit < 1 || limit > 1000 {
+		return 0, 0, "Limit must be between 1 and 1000"
+	}
+	if offset < 0 {
+		return 0, 0, "Offset must not be negative"
+	}
+	return limit, offset, ""
+}
+
+// GetOverallLeaderboardHandler handles GET /api/leaderboard/overall?limit=100&offset=0
+// Public, so emails are masked
 func GetOverallLeaderboardHandler(c *fiber.Ctx) error {
+	limit, offset, message := leaderboardPage(c)
+	if message != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, message)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Query to get top 100 students ordered by score DESC, then time ASC
+	// Query a page of students ordered by the ranking policy, using each student's counted
+	// attempt (ATTEMPT_POLICY). Ranks are computed over every student before the page applies.
+	policy := ranking.Current(ctx)
 	query := `
 		SELECT
 			s.id,
 			s.name,
 			s.email,
 			COALESCE(sess.score, 0) as score,
-			COALESCE(sess.total_time_taken_seconds, 0) as total_time_taken_seconds
+			COALESCE(sess.total_time_taken_seconds, 0) as total_time_taken_seconds,
+			` + policy.DenseRank("sess") + ` as rank,
+			sess.auto_completed
 		FROM students s
-		INNER JOIN sessions sess ON s.id = sess.student_id
-		WHERE sess.completed = true
-		ORDER BY sess.score DESC, sess.total_time_taken_seconds ASC
-		LIMIT 100
+		INNER JOIN ` + attempts.CountedSessions() + ` sess ON s.id = sess.student_id
+		ORDER BY ` + policy.OrderBy("sess") + `, s.id ASC
+		LIMIT $1 OFFSET $2
 	`
 
-	rows, err := db.Pool.Query(ctx, query)
+	rows, err := db.Pool.Query(ctx, query, limit, offset)
 	if err != nil {
-		log.Printf("Failed to fetch leaderboard: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(OverallLeaderboardResponse{
-			Success: false,
-			Message: "Failed to fetch leaderboard",
-		})
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch leaderboard")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch leaderboard")
 	}
 	defer rows.Close()
 
 	leaderboard := make([]LeaderboardEntry, 0)
-	rank := 1
 
 	for rows.Next() {
 		var entry LeaderboardEntry
-		if err := rows.Scan(&entry.StudentID, &entry.Name, &entry.Email, &entry.Score, &entry.TotalTimeTakenSeconds); err != nil {
-			log.Printf("Failed to scan row: %v", err)
+		if err := rows.Scan(&entry.StudentID, &entry.Name, &entry.Email, &entry.Score, &entry.TotalTimeTakenSeconds, &entry.Rank, &entry.AutoCompleted); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan row")
 			continue
 		}
-		entry.Rank = rank
+		entry.Email = maskEmail(entry.Email)
 		leaderboard = append(leaderboard, entry)
-		rank++
 	}
 
-	// Get total count of completed sessions
+	// Get total count of students with a completed session
 	var total int
-	countQuery := `SELECT COUNT(*) FROM sessions WHERE completed = true`
+	countQuery := `SELECT COUNT(*) FROM ` + attempts.CountedSessions() + ` sess`
 	err = db.Pool.QueryRow(ctx, countQuery).Scan(&total)
 	if err != nil {
-		log.Printf("Failed to count sessions: %v", err)
+		logging.Ctx(c).Error().Err(err).Msg("Failed to count sessions")
 		total = len(leaderboard)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(OverallLeaderboardResponse{
 		Success: true,
 		Total:   total,
+		Ranking: policy.Criteria,
 		Data:    leaderboard,
+		Limit:   limit,
+		Offset:  offset,
 	})
 }
 
+// aroundRadius is how many entries GET /api/leaderboard/around shows on each side of the
+// student
+const aroundRadius = 5
+
+type AroundLeaderboardResponse struct {
+	Success   bool               `json:"success"`
+	StudentID int                `json:"student_id"`
+	Rank      int                `json:"rank"`
+	Position  int                `json:"position"`
+	Total     int                `json:"total"`
+	Ranking   []string           `json:"ranking"`
+	Data      []LeaderboardEntry `json:"data"`
+}
+
+// sessionStudent is the student whose session token came with a leaderboard request
+type sessionStudent struct {
+	ID    int
+	Name  string
+	Email string
+}
+
+// lookupSessionStudent finds the student of the session token sent via
+// "Authorization: Bearer <session_token>" or ?token=<session_token>, so callers only see
+// their own ranks. It returns nil once an error response is sent, with failure as the
+// message of a database error.
+func lookupSessionStudent(ctx context.Context, c *fiber.Ctx, failure string) (*sessionStudent, error) {
+	sessionToken := strings.TrimSpace(strings.TrimPrefix(c.Get("Authorization"), "Bearer"))
+	if sessionToken == "" {
+		sessionToken = strings.TrimSpace(c.Query("token"))
+	}
+	if sessionToken == "" {
+		return nil, apierror.Send(c, fiber.StatusBadRequest, "Session token is required")
+	}
+
+	var student sessionStudent
+	query := `
+		SELECT s.id, s.name, s.email
+		FROM sessions sess
+		JOIN students s ON s.id = sess.student_id
+		WHERE sess.session_token = $1
+	`
+	if err := db.Pool.QueryRow(ctx, query, sessionToken).Scan(&student.ID, &student.Name, &student.Email); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeSessionInvalid, "Invalid session token")
+		}
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch session")
+		return nil, apierror.Send(c, fiber.StatusInternalServerError, failure)
+	}
+	return &student, nil
+}
+
+// GetLeaderboardAroundHandler handles GET /api/leaderboard/around
+// Session token via "Authorization: Bearer <session_token>" or ?token=<session_token>.
+// Returns the session's student on the overall leaderboard with the 10 entries around it:
+// 5 on each side, or more on one side at the top or bottom of the leaderboard. Emails are
+// masked, as on the overall leaderboard.
+func GetLeaderboardAroundHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	student, err := lookupSessionStudent(ctx, c, "Failed to fetch leaderboard")
+	if student == nil {
+		return err
+	}
+	studentID := student.ID
+
+	// Every counted attempt is ranked in one pass; position numbers the rows in leaderboard
+	// order (ties by student_id, as on the overall leaderboard), and the window is the
+	// positions around the student's, shifted to stay within the leaderboard.
+	policy := ranking.Current(ctx)
+	query := `
+		WITH ranked AS (
+			SELECT
+				s.id,
+				s.name,
+				s.email,
+				COALESCE(sess.score, 0) as score,
+				COALESCE(sess.total_time_taken_seconds, 0) as total_time_taken_seconds,
+				` + policy.DenseRank("sess") + ` as rank,
+				ROW_NUMBER() OVER (ORDER BY ` + policy.OrderBy("sess") + `, s.id ASC) as position,
+				COUNT(*) OVER () as total,
+				sess.auto_completed
+			FROM students s
+			INNER JOIN ` + attempts.CountedSessions() + ` sess ON s.id = sess.student_id
+		),
+		me AS (
+			SELECT GREATEST(1, LEAST(position - $2, total - 2 * $2)) as first FROM ranked WHERE id = $1
+		)
+		SELECT r.id, r.name, r.email, r.score, r.total_time_taken_seconds, r.rank, r.auto_completed, r.position, r.total
+		FROM ranked r, me
+		WHERE r.position BETWEEN me.first AND me.first + 2 * $2
+		ORDER BY r.position
+	`
+	rows, err := db.Pool.Query(ctx, query, studentID, aroundRadius)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch leaderboard")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch leaderboard")
+	}
+	defer rows.Close()
+
+	response := AroundLeaderboardResponse{
+		Success:   true,
+		StudentID: studentID,
+		Ranking:   policy.Criteria,
+		Data:      make([]LeaderboardEntry, 0, 2*aroundRadius+1),
+	}
+	for rows.Next() {
+		var entry LeaderboardEntry
+		var position int
+		if err := rows.Scan(&entry.StudentID, &entry.Name, &entry.Email, &entry.Score, &entry.TotalTimeTakenSeconds, &entry.Rank, &entry.AutoCompleted, &position, &response.Total); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan row")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch leaderboard")
+		}
+		if entry.StudentID == studentID {
+			response.Rank = entry.Rank
+			response.Position = position
+		}
+		entry.Email = maskEmail(entry.Email)
+		response.Data = append(response.Data, entry)
+	}
+	if err := rows.Err(); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch leaderboard")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch leaderboard")
+	}
+	if response.Position == 0 {
+		return apierror.Send(c, fiber.StatusNotFound, "No completed session found for this student")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
 // ============================================
 // SECTION-BASED TOP 100
 // ============================================
 
 type SectionLeaderboardEntry struct {
-	Rank                  int    `json:"rank"`
-	StudentID             int    `json:"student_id"`
-	Name                  string `json:"name"`
-	Email                 string `json:"email"`
-	SectionScore          int    `json:"section_score"`
-	SectionTimeTakenSeconds int  `json:"section_time_taken_seconds"`
+	Rank                    int    `json:"rank"`
+	StudentID               int    `json:"student_id"`
+	Name                    string `json:"name"`
+	Email                   string `json:"email"`
+	SectionScore            int    `json:"section_score"`
+	SectionTimeTakenSeconds int    `json:"section_time_taken_seconds"`
+	AutoCompleted           bool   `json:"auto_completed"`
 }
 
 type SectionLeaderboardResponse struct {
-	Success     bool                       `json:"success"`
-	Message     string                     `json:"message,omitempty"`
-	SectionID   int                        `json:"section_id,omitempty"`
-	SectionName string                     `json:"section_name,omitempty"`
-	Total       int                        `json:"total,omitempty"`
-	Data        []SectionLeaderboardEntry  `json:"data,omitempty"`
+	Success     bool                      `json:"success"`
+	Message     string                    `json:"message,omitempty"`
+	SectionID   int                       `json:"section_id,omitempty"`
+	SectionName string                    `json:"section_name,omitempty"`
+	Total       int                       `json:"total,omitempty"`
+	Data        []SectionLeaderboardEntry `json:"data,omitempty"`
+	// Limit and Offset are the page returned
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
 }
 
-// GetSectionLeaderboardHandler handles GET /api/leaderboard/section/:section_id
+// GetSectionLeaderboardHandler handles GET /api/leaderboard/section/:section_id?limit=100&offset=0
+// Reads the per-section results persisted when each session ends. Public, so emails are masked.
 func GetSectionLeaderboardHandler(c *fiber.Ctx) error {
 	sectionID, err := c.ParamsInt("section_id")
 	if err != nil || sectionID < 1 || sectionID > 4 {
-		return c.Status(fiber.StatusBadRequest).JSON(SectionLeaderboardResponse{
-			Success: false,
-			Message: "Invalid section ID (must be 1-4)",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid section ID (must be 1-4)")
+	}
+	limit, offset, message := leaderboardPage(c)
+	if message != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, message)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Load questions to get section info and question IDs
-	questionsFile, err := os.ReadFile("questions_with_timer.json")
+	// Load questions to get the section name
+	sections, err := questions.Load()
 	if err != nil {
-		log.Printf("Failed to read questions file: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(SectionLeaderboardResponse{
-			Success: false,
-			Message: "Failed to load questions",
-		})
-	}
-
-	type JSONQuestion struct {
-		ID int `json:"id"`
-	}
-	type JSONSection struct {
-		ID        int            `json:"id"`
-		Name      string         `json:"name"`
-		Questions []JSONQuestion `json:"questions"`
-	}
-	var sections []JSONSection
-
-	if err := json.Unmarshal(questionsFile, &sections); err != nil {
-		log.Printf("Failed to parse questions: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(SectionLeaderboardResponse{
-			Success: false,
-			Message: "Failed to parse questions",
-		})
-	}
-
-	// Find the requested section
-	var targetSection *JSONSection
-	for i := range sections {
-		if sections[i].ID == sectionID {
-			targetSection = &sections[i]
-			break
-		}
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
 	}
 
+	targetSection := questions.FindSection(sections, sectionID)
 	if targetSection == nil {
-		return c.Status(fiber.StatusNotFound).JSON(SectionLeaderboardResponse{
-			Success: false,
-			Message: "Section not found",
-		})
-	}
-
-	// Extract question IDs for this section
-	questionIDs := make([]int, len(targetSection.Questions))
-	for i, q := range targetSection.Questions {
-		questionIDs[i] = q.ID
+		return apierror.Send(c, fiber.StatusNotFound, "Section not found")
 	}
 
-	// Query to calculate section scores and times
+	// Only students who answered at least one question in the section take part,
+	// with the section result of their counted attempt. Ranks and the total are computed
+	// over every participant before the page applies.
 	query := `
-		WITH section_scores AS (
-			SELECT
-				sess.student_id,
-				COUNT(CASE WHEN a.is_correct = true THEN 1 END) as section_score,
-				COALESCE(SUM(a.time_taken_seconds), 0) as section_time_taken_seconds
-			FROM sessions sess
-			LEFT JOIN answers a ON sess.id = a.session_id
-			WHERE sess.completed = true
-			AND a.question_id = ANY($1)
-			GROUP BY sess.student_id
-		)
 		SELECT
 			s.id,
 			s.name,
 			s.email,
-			COALESCE(sc.section_score, 0) as section_score,
-			COALESCE(sc.section_time_taken_seconds, 0) as section_time_taken_seconds
-		FROM students s
-		INNER JOIN section_scores sc ON s.id = sc.student_id
-		ORDER BY sc.section_score DESC, sc.section_time_taken_seconds ASC
-		LIMIT 100
+			sss.score,
+			sss.time_taken_seconds,
+			sess.auto_completed,
+			` + ranking.SectionDenseRank("sss") + ` as rank,
+			COUNT(*) OVER () as total
+		FROM session_section_scores sss
+		INNER JOIN ` + attempts.CountedSessions() + ` sess ON sess.id = sss.session_id
+		INNER JOIN students s ON s.id = sss.student_id
+		WHERE sss.section_id = $1
+		AND sss.questions_answered > 0
+		ORDER BY ` + ranking.SectionOrderBy("sss") + `, s.id ASC
+		LIMIT $2 OFFSET $3
 	`
 
-	rows, err := db.Pool.Query(ctx, query, questionIDs)
+	rows, err := db.Pool.Query(ctx, query, sectionID, limit, offset)
 	if err != nil {
-		log.Printf("Failed to fetch section leaderboard: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(SectionLeaderboardResponse{
-			Success: false,
-			Message: "Failed to fetch section leaderboard",
-		})
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch section leaderboard")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch section leaderboard")
 	}
 	defer rows.Close()
 
 	leaderboard := make([]SectionLeaderboardEntry, 0)
-	rank := 1
+	total := 0
 
 	for rows.Next() {
 		var entry SectionLeaderboardEntry
-		if err := rows.Scan(&entry.StudentID, &entry.Name, &entry.Email, &entry.SectionScore, &entry.SectionTimeTakenSeconds); err != nil {
-			log.Printf("Failed to scan row: %v", err)
+		if err := rows.Scan(&entry.StudentID, &entry.Name, &entry.Email, &entry.SectionScore, &entry.SectionTimeTakenSeconds, &entry.AutoCompleted, &entry.Rank, &total); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan row")
 			continue
 		}
-		entry.Rank = rank
+		entry.Email = maskEmail(entry.Email)
 		leaderboard = append(leaderboard, entry)
-		rank++
 	}
-
-	// Get total count for this section
-	countQuery := `
-		SELECT COUNT(DISTINCT sess.student_id)
-		FROM sessions sess
-		INNER JOIN answers a ON sess.id = a.session_id
-		WHERE sess.completed = true
-		AND a.question_id = ANY($1)
-	`
-	var total int
-	err = db.Pool.QueryRow(ctx, countQuery, questionIDs).Scan(&total)
-	if err != nil {
-		log.Printf("Failed to count section participants: %v", err)
-		total = len(leaderboard)
+	if err := rows.Err(); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch section leaderboard")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch section leaderboard")
 	}
 
 	return c.Status(fiber.StatusOK).JSON(SectionLeaderboardResponse{
@@ -246,6 +359,8 @@ func GetSectionLeaderboardHandler(c *fiber.Ctx) error {
 		SectionName: targetSection.Name,
 		Total:       total,
 		Data:        leaderboard,
+		Limit:       limit,
+		Offset:      offset,
 	})
 }
 
@@ -254,12 +369,12 @@ func GetSectionLeaderboardHandler(c *fiber.Ctx) error {
 // ============================================
 
 type UserSectionRank struct {
-	SectionID             int    `json:"section_id"`
-	SectionName           string `json:"section_name"`
-	Score                 int    `json:"score"`
-	TimeTakenSeconds      int    `json:"time_taken_seconds"`
-	Rank                  int    `json:"rank"`
-	TotalParticipants     int    `json:"total_participants"`
+	SectionID         int    `json:"section_id"`
+	SectionName       string `json:"section_name"`
+	Score             int    `json:"score"`
+	TimeTakenSeconds  int    `json:"time_taken_seconds"`
+	Rank              int    `json:"rank"`
+	TotalParticipants int    `json:"total_participants"`
 }
 
 type UserSectionRanksResponse struct {
@@ -271,153 +386,87 @@ type UserSectionRanksResponse struct {
 	Sections     []UserSectionRank `json:"sections,omitempty"`
 }
 
-// GetUserSectionRanksHandler handles GET /api/leaderboard/user-sections?email=student@example.com
+// GetUserSectionRanksHandler handles GET /api/leaderboard/user-sections
+// Session token via "Authorization: Bearer <session_token>" or ?token=<session_token>, as on
+// GET /api/leaderboard/around, so a student's section results are only shown to them. The
+// email is masked.
 func GetUserSectionRanksHandler(c *fiber.Ctx) error {
-	email := c.Query("email")
-	if email == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(UserSectionRanksResponse{
-			Success: false,
-			Message: "Email parameter is required",
-		})
-	}
-
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	// Get student by email
-	var studentID int
-	var studentName string
-	studentQuery := `SELECT id, name FROM students WHERE email = $1`
-	err := db.Pool.QueryRow(ctx, studentQuery, email).Scan(&studentID, &studentName)
-	if err != nil {
-		log.Printf("Student not found: %v", err)
-		return c.Status(fiber.StatusNotFound).JSON(UserSectionRanksResponse{
-			Success: false,
-			Message: "Student not found",
-		})
+	student, err := lookupSessionStudent(ctx, c, "Failed to fetch section ranks")
+	if student == nil {
+		return err
 	}
+	studentID, studentName := student.ID, student.Name
 
-	// Check if student has a completed session
+	// Check if student has a completed session, and pick the counted attempt
 	var sessionID int
-	sessionQuery := `SELECT id FROM sessions WHERE student_id = $1 AND completed = true`
+	sessionQuery := `SELECT id FROM ` + attempts.CountedSessions() + ` sess WHERE student_id = $1`
 	err = db.Pool.QueryRow(ctx, sessionQuery, studentID).Scan(&sessionID)
 	if err != nil {
-		log.Printf("No completed session found: %v", err)
-		return c.Status(fiber.StatusNotFound).JSON(UserSectionRanksResponse{
-			Success: false,
-			Message: "No completed session found for this student",
-		})
+		logging.Ctx(c).Warn().Err(err).Msg("No completed session found")
+		return apierror.Send(c, fiber.StatusNotFound, "No completed session found for this student")
 	}
 
-	// Load questions to get section info
-	questionsFile, err := os.ReadFile("questions_with_timer.json")
+	// Load questions to get section names
+	sections, err := questions.Load()
 	if err != nil {
-		log.Printf("Failed to read questions file: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(UserSectionRanksResponse{
-			Success: false,
-			Message: "Failed to load questions",
-		})
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
 	}
 
-	type JSONQuestion struct {
-		ID int `json:"id"`
-	}
-	type JSONSection struct {
-		ID        int            `json:"id"`
-		Name      string         `json:"name"`
-		Questions []JSONQuestion `json:"questions"`
-	}
-	var sections []JSONSection
-
-	if err := json.Unmarshal(questionsFile, &sections); err != nil {
-		log.Printf("Failed to parse questions: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(UserSectionRanksResponse{
-			Success: false,
-			Message: "Failed to parse questions",
-		})
+	// Every section is ranked in one pass, as on the section leaderboards: participants'
+	// counted attempts with at least one answer, ties sharing a rank. A section the student
+	// answered nothing in is not ranked (rank 0).
+	query := `
+		WITH ranked AS (
+			SELECT
+				sss.session_id,
+				sss.section_id,
+				` + ranking.SectionDenseRank("sss") + ` as rank,
+				COUNT(*) OVER (PARTITION BY sss.section_id) as total
+			FROM session_section_scores sss
+			INNER JOIN ` + attempts.CountedSessions() + ` sess ON sess.id = sss.session_id
+			WHERE sss.questions_answered > 0
+		)
+		SELECT
+			u.section_id,
+			u.score,
+			u.time_taken_seconds,
+			COALESCE(r.rank, 0) as rank,
+			COALESCE(r.total, (SELECT t.total FROM ranked t WHERE t.section_id = u.section_id LIMIT 1), 0) as total_participants
+		FROM session_section_scores u
+		LEFT JOIN ranked r ON r.session_id = u.session_id AND r.section_id = u.section_id
+		WHERE u.session_id = $1
+		ORDER BY u.section_id
+	`
+
+	rows, err := db.Pool.Query(ctx, query, sessionID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch user section ranks")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch section ranks")
 	}
+	defer rows.Close()
 
-	// Calculate ranks for each section
 	userSectionRanks := make([]UserSectionRank, 0, len(sections))
-
-	for _, section := range sections {
-		// Extract question IDs for this section
-		questionIDs := make([]int, len(section.Questions))
-		for i, q := range section.Questions {
-			questionIDs[i] = q.ID
-		}
-
-		// Get user's score and time for this section
-		userScoreQuery := `
-			SELECT
-				COUNT(CASE WHEN a.is_correct = true THEN 1 END) as section_score,
-				COALESCE(SUM(a.time_taken_seconds), 0) as section_time_taken_seconds
-			FROM answers a
-			WHERE a.session_id = $1
-			AND a.question_id = ANY($2)
-		`
-		var userScore, userTime int
-		err = db.Pool.QueryRow(ctx, userScoreQuery, sessionID, questionIDs).Scan(&userScore, &userTime)
-		if err != nil {
-			log.Printf("Failed to get user section score: %v", err)
+	for rows.Next() {
+		var entry UserSectionRank
+		if err := rows.Scan(&entry.SectionID, &entry.Score, &entry.TimeTakenSeconds, &entry.Rank, &entry.TotalParticipants); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan row")
 			continue
 		}
-
-		// Calculate rank: count how many students scored better
-		rankQuery := `
-			WITH section_scores AS (
-				SELECT
-					sess.student_id,
-					COUNT(CASE WHEN a.is_correct = true THEN 1 END) as section_score,
-					COALESCE(SUM(a.time_taken_seconds), 0) as section_time_taken_seconds
-				FROM sessions sess
-				LEFT JOIN answers a ON sess.id = a.session_id
-				WHERE sess.completed = true
-				AND a.question_id = ANY($1)
-				GROUP BY sess.student_id
-			)
-			SELECT COUNT(*) + 1
-			FROM section_scores
-			WHERE (section_score > $2)
-			   OR (section_score = $2 AND section_time_taken_seconds < $3)
-		`
-		var rank int
-		err = db.Pool.QueryRow(ctx, rankQuery, questionIDs, userScore, userTime).Scan(&rank)
-		if err != nil {
-			log.Printf("Failed to calculate rank: %v", err)
-			rank = 0
+		if section := questions.FindSection(sections, entry.SectionID); section != nil {
+			entry.SectionName = section.Name
 		}
-
-		// Get total participants for this section
-		totalQuery := `
-			SELECT COUNT(DISTINCT sess.student_id)
-			FROM sessions sess
-			INNER JOIN answers a ON sess.id = a.session_id
-			WHERE sess.completed = true
-			AND a.question_id = ANY($1)
-		`
-		var total int
-		err = db.Pool.QueryRow(ctx, totalQuery, questionIDs).Scan(&total)
-		if err != nil {
-			log.Printf("Failed to count participants: %v", err)
-			total = 0
-		}
-
-		userSectionRanks = append(userSectionRanks, UserSectionRank{
-			SectionID:         section.ID,
-			SectionName:       section.Name,
-			Score:             userScore,
-			TimeTakenSeconds:  userTime,
-			Rank:              rank,
-			TotalParticipants: total,
-		})
+		userSectionRanks = append(userSectionRanks, entry)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(UserSectionRanksResponse{
 		Success:      true,
 		StudentID:    studentID,
 		StudentName:  studentName,
-		StudentEmail: email,
+		StudentEmail: maskEmail(student.Email),
 		Sections:     userSectionRanks,
 	})
 }
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mcq-exam/dbtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// seedSectionResult inserts a completed session of studentID with its section 1 result
+func seedSectionResult(t *testing.T, studentID, attempt, sessionScore, sectionScore, seconds int) {
+	t.Helper()
+	sessionID := dbtest.QueryInt(t, `
+		INSERT INTO sessions (student_id, session_token, attempt_number, completed, completed_at, score, total_time_taken_seconds)
+		VALUES ($1, $2, $3, true, NOW(), $4, $5)
+		RETURNING id
+	`, studentID, fmt.Sprintf("token-%d-%d", studentID, attempt), attempt, sessionScore, seconds)
+	dbtest.Exec(t, `
+		INSERT INTO session_section_scores (session_id, student_id, section_id, score, time_taken_seconds, questions_answered)
+		VALUES ($1, $2, 1, $3, $4, 5)
+	`, sessionID, studentID, sectionScore, seconds)
+}
+
+// TestSectionLeaderboardRanks ranks a seeded section: tied results share a rank, the next
+// rank follows on without a gap, and a student with two attempts is listed once, with the
+// section result of the counted attempt
+func TestSectionLeaderboardRanks(t *testing.T) {
+	dbtest.Open(t, "test_handlers")
+	t.Setenv("ATTEMPT_POLICY", "best")
+	t.Setenv("AUTO_COMPLETED_POLICY", "include")
+
+	student := func(name string) int {
+		return dbtest.QueryInt(t, `INSERT INTO students (name, email) VALUES ($1, $1 || '@example.com') RETURNING id`, name)
+	}
+	anu, bala, chitra, divya := student("anu"), student("bala"), student("chitra"), student("divya")
+	seedSectionResult(t, anu, 1, 40, 10, 100)
+	seedSectionResult(t, bala, 1, 40, 10, 100)
+	seedSectionResult(t, chitra, 1, 30, 8, 120)
+	// Divya's first attempt has the higher overall score, so it counts under the best policy;
+	// the second attempt's better section result must not be listed as well
+	seedSectionResult(t, divya, 1, 35, 7, 90)
+	seedSectionResult(t, divya, 2, 20, 9, 90)
+
+	app := fiber.New()
+	app.Get("/api/leaderboard/section/:section_id", GetSectionLeaderboardHandler)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/leaderboard/section/1", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, data)
+	}
+	var body SectionLeaderboardResponse
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		studentID int
+		score     int
+		rank      int
+	}{
+		{anu, 10, 1},
+		{bala, 10, 1},
+		{chitra, 8, 2},
+		{divya, 7, 3},
+	}
+	if body.Total != len(want) || len(body.Data) != len(want) {
+		t.Fatalf("total %d with %d entries, want %d students each listed once: %+v", body.Total, len(body.Data), len(want), body.Data)
+	}
+	for i, w := range want {
+		got := body.Data[i]
+		if got.StudentID != w.studentID || got.SectionScore != w.score || got.Rank != w.rank {
+			t.Errorf("entry %d: student %d score %d rank %d, want student %d score %d rank %d",
+				i, got.StudentID, got.SectionScore, got.Rank, w.studentID, w.score, w.rank)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"math"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/instance"
+	"mcq-exam/jobs"
+	"mcq-exam/livemetrics"
+	"mcq-exam/logging"
+	"mcq-exam/presence"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// liveMetricsWindows are the spans, in minutes, GET /api/admin/live-metrics reports
+var liveMetricsWindows = []int{1, 5, 15}
+
+// GetLiveMetricsHandler handles GET /api/admin/live-metrics?stalled_after=120
+// Reports whether this instance keeps up with answer submissions: the current and 1/5/15
+// minute answer rates, rejections, errors and sampled p95 insert latency, open sessions by
+// state and Postgres pool saturation. Submission figures are for this instance only.
+func GetLiveMetricsHandler(c *fiber.Ctx) error {
+	stalledAfter := c.QueryInt("stalled_after", 120)
+	if stalledAfter < 1 {
+		return apierror.Send(c, fiber.StatusBadRequest, "stalled_after must be a positive number of seconds")
+	}
+
+	snapshot := livemetrics.Summarize(liveMetricsWindows...)
+	response := fiber.Map{
+		"instance":     instance.ID(),
+		"generated_at": time.Now().UTC(),
+		"current":      snapshot.Current,
+		"windows":      snapshot.Windows,
+		"db_pool":      poolSaturation(),
+	}
+
+	// The in-memory figures are what matter when the database struggles, so a slow or
+	// failed session count leaves sessions null instead of failing the request
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	counts, err := presence.Counts(ctx, time.Duration(stalledAfter)*time.Second)
+	if err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Failed to count open sessions for live metrics")
+		response["sessions"] = nil
+	} else {
+		response["sessions"] = fiber.Map{
+			"active":                counts[presence.StateActive],
+			"stalled":               counts[presence.StateStalled],
+			"abandoned":             counts[presence.StateAbandoned],
+			"stalled_after_seconds": stalledAfter,
+		}
+	}
+
+	return c.JSON(response)
+}
+
+// liveStatsStreamInterval is how often GET /api/live/stats/stream pushes counters by default
+const liveStatsStreamInterval = 5 * time.Second
+
+// StreamLiveStatsHandler handles GET /api/live/stats/stream?interval=5
+// A server-sent event stream for the live "answers received" screen: every interval seconds
+// (1-60, default 5) it sends a "stats" event with this instance's active sessions, answers,
+// submissions and completions per minute, and totals since the instance started. The
+// counters are kept in memory by the submit and end-session paths, so the stream does not
+// touch the database.
+func StreamLiveStatsHandler(c *fiber.Ctx) error {
+	interval := liveStatsStreamInterval
+	if value := c.Query("interval"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 || seconds > 60 {
+			return apierror.Send(c, fiber.StatusBadRequest, "interval must be between 1 and 60 seconds")
+		}
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	setSSEHeaders(c)
+
+	shutdown := jobs.Context()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			// Every event is sent, changed or not, so the screen's clock and the stream's
+			// liveness are visible; a failed write means the client went away
+			stats := fiber.Map{
+				"instance":     instance.ID(),
+				"generated_at": time.Now().UTC(),
+				"counters":     livemetrics.Live(),
+			}
+			if err := writeSSEEvent(w, "stats", stats); err != nil {
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-shutdown.Done():
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// poolSaturation is the Postgres pool's current use; saturation_percent near 100 with
+// growing empty_acquires_total means requests are queueing for connections
+func poolSaturation() fiber.Map {
+	stat := db.Pool.Stat()
+	saturation := 0.0
+	if stat.MaxConns() > 0 {
+		saturation = math.Round(float64(stat.AcquiredConns())/float64(stat.MaxConns())*1000) / 10
+	}
+	return fiber.Map{
+		"acquired":                   stat.AcquiredConns(),
+		"idle":                       stat.IdleConns(),
+		"total":                      stat.TotalConns(),
+		"max":                        stat.MaxConns(),
+		"saturation_percent":         saturation,
+		"empty_acquires_total":       stat.EmptyAcquireCount(),
+		"canceled_acquires_total":    stat.CanceledAcquireCount(),
+		"acquire_wait_seconds_total": math.Round(stat.AcquireDuration().Seconds()*1000) / 1000,
+	}
+}
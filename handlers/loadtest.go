@@ -2,8 +2,12 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"mcq-exam/apierror"
 	"mcq-exam/db"
+	"mcq-exam/loadtest"
+	"mcq-exam/logging"
 	"sync"
 	"time"
 
@@ -49,21 +53,18 @@ func LoadTestIndividualHandler(c *fiber.Ctx) error {
 	var responses []TestMCQResponse
 	if err := c.BodyParser(&responses); err != nil {
 		individualMetrics.recordFailure()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
 	if len(responses) != 5 {
 		individualMetrics.recordFailure()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Expected exactly 5 MCQ responses",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Expected exactly 5 MCQ responses")
 	}
 
 	// Insert each record individually
 	dbStartTime := time.Now()
-	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 	for _, resp := range responses {
 		query := `
 			INSERT INTO test_mcq_responses (question_text, option_a, option_b, option_c, option_d)
@@ -72,9 +73,7 @@ func LoadTestIndividualHandler(c *fiber.Ctx) error {
 		_, err := db.Pool.Exec(ctx, query, resp.QuestionText, resp.OptionA, resp.OptionB, resp.OptionC, resp.OptionD)
 		if err != nil {
 			individualMetrics.recordFailure()
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Database insert failed",
-			})
+			return apierror.Send(c, fiber.StatusInternalServerError, "Database insert failed")
 		}
 	}
 	dbDuration := time.Since(dbStartTime)
@@ -97,21 +96,18 @@ func LoadTestBatchHandler(c *fiber.Ctx) error {
 	var responses []TestMCQResponse
 	if err := c.BodyParser(&responses); err != nil {
 		batchMetrics.recordFailure()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
 	if len(responses) != 5 {
 		batchMetrics.recordFailure()
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Expected exactly 5 MCQ responses",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Expected exactly 5 MCQ responses")
 	}
 
 	// Batch insert using single query
 	dbStartTime := time.Now()
-	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 	query := `
 		INSERT INTO test_mcq_responses (question_text, option_a, option_b, option_c, option_d)
 		VALUES
@@ -130,9 +126,7 @@ func LoadTestBatchHandler(c *fiber.Ctx) error {
 	)
 	if err != nil {
 		batchMetrics.recordFailure()
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Database batch insert failed",
-		})
+		return apierror.Send(c, fiber.StatusInternalServerError, "Database batch insert failed")
 	}
 	dbDuration := time.Since(dbStartTime)
 
@@ -282,13 +276,12 @@ func calculatePercentiles(times []time.Duration) (p50, p95, p99 time.Duration) {
 
 // Cleanup test data
 func CleanupLoadTestDataHandler(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 	query := `DELETE FROM test_mcq_responses`
 	result, err := db.Pool.Exec(ctx, query)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to cleanup test data",
-		})
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to cleanup test data")
 	}
 
 	rowsDeleted := result.RowsAffected()
@@ -298,6 +291,42 @@ func CleanupLoadTestDataHandler(c *fiber.Ctx) error {
 	})
 }
 
+// RunScenarioHandler handles POST /api/load-test/run-scenario
+// Body: {"target": "submit-answer", "goroutines": 50, "duration_seconds": 60, "students": 5000, "notes": "..."}
+// Replays the target endpoint's queries from concurrent goroutines against the loadtest
+// sandbox schema, then records the latency distribution in test_results. Blocks for the run.
+// The leaderboard-overall, leaderboard-section and comprehensive-stats targets replay the
+// results reads against seeded finished attempts.
+func RunScenarioHandler(c *fiber.Ctx) error {
+	var cfg loadtest.Config
+	if err := c.BodyParser(&cfg); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if err := cfg.Normalize(); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	result, err := loadtest.Run(context.Background(), cfg)
+	if errors.Is(err, loadtest.ErrBusy) {
+		return apierror.Send(c, fiber.StatusConflict, "Another scenario is already running")
+	}
+	if err != nil && result == nil {
+		logging.Ctx(c).Error().Err(err).Str("target", cfg.Target).Msg("Load test scenario failed")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to run scenario")
+	}
+	if err != nil {
+		// The run finished but could not be recorded; still return what was measured
+		logging.Ctx(c).Error().Err(err).Str("target", cfg.Target).Msg("Failed to save scenario results")
+		return apierror.Respond(c, fiber.StatusInternalServerError, apierror.CodeInternal,
+			"Scenario finished but results could not be saved", fiber.Map{"result": result})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Scenario completed",
+		"result":  result,
+	})
+}
+
 // Save test results to database
 func SaveTestResultsHandler(c *fiber.Ctx) error {
 	// Request body structure
@@ -309,16 +338,12 @@ func SaveTestResultsHandler(c *fiber.Ctx) error {
 
 	var req SaveTestResultRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request body",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
 	// Validate test type
 	if req.TestType != "individual" && req.TestType != "batch" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "test_type must be 'individual' or 'batch'",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "test_type must be 'individual' or 'batch'")
 	}
 
 	// Get current metrics based on test type
@@ -333,9 +358,7 @@ func SaveTestResultsHandler(c *fiber.Ctx) error {
 	defer metrics.mu.RUnlock()
 
 	if metrics.TotalRequests == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "No test data available. Run a test first.",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "No test data available. Run a test first.")
 	}
 
 	// Calculate metrics
@@ -361,7 +384,8 @@ func SaveTestResultsHandler(c *fiber.Ctx) error {
 	}
 
 	// Save to database
-	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 	query := `
 		INSERT INTO test_results (
 			test_type, total_requests, successful_requests, failed_requests,
@@ -392,9 +416,7 @@ func SaveTestResultsHandler(c *fiber.Ctx) error {
 	).Scan(&resultID, &createdAt)
 
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to save test results",
-		})
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to save test results")
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
@@ -421,7 +443,8 @@ func SaveTestResultsHandler(c *fiber.Ctx) error {
 
 // Get all test results from database
 func GetAllTestResultsHandler(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
 	// Optional query params for filtering
 	testType := c.Query("test_type") // "individual" or "batch"
@@ -432,7 +455,7 @@ func GetAllTestResultsHandler(c *fiber.Ctx) error {
 			id, test_type, total_requests, successful_requests, failed_requests,
 			error_rate, min_db_time_ms, max_db_time_ms, avg_db_time_ms,
 			p50_db_time_ms, p95_db_time_ms, p99_db_time_ms,
-			test_duration_seconds, notes, created_at
+			test_duration_seconds, notes, concurrency, latency_distribution, created_at
 		FROM test_results
 	`
 
@@ -451,28 +474,28 @@ func GetAllTestResultsHandler(c *fiber.Ctx) error {
 
 	rows, err := db.Pool.Query(ctx, query, args...)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to fetch test results",
-		})
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch test results")
 	}
 	defer rows.Close()
 
 	type TestResult struct {
-		ID                   int       `json:"id"`
-		TestType             string    `json:"test_type"`
-		TotalRequests        int64     `json:"total_requests"`
-		SuccessfulRequests   int64     `json:"successful_requests"`
-		FailedRequests       int64     `json:"failed_requests"`
-		ErrorRate            float64   `json:"error_rate"`
-		MinDBTimeMs          *int64    `json:"min_db_time_ms"`
-		MaxDBTimeMs          *int64    `json:"max_db_time_ms"`
-		AvgDBTimeMs          *int64    `json:"avg_db_time_ms"`
-		P50DBTimeMs          *int64    `json:"p50_db_time_ms"`
-		P95DBTimeMs          *int64    `json:"p95_db_time_ms"`
-		P99DBTimeMs          *int64    `json:"p99_db_time_ms"`
-		TestDurationSeconds  *int      `json:"test_duration_seconds"`
-		Notes                *string   `json:"notes"`
-		CreatedAt            time.Time `json:"created_at"`
+		ID                  int                    `json:"id"`
+		TestType            string                 `json:"test_type"`
+		TotalRequests       int64                  `json:"total_requests"`
+		SuccessfulRequests  int64                  `json:"successful_requests"`
+		FailedRequests      int64                  `json:"failed_requests"`
+		ErrorRate           float64                `json:"error_rate"`
+		MinDBTimeMs         *int64                 `json:"min_db_time_ms"`
+		MaxDBTimeMs         *int64                 `json:"max_db_time_ms"`
+		AvgDBTimeMs         *int64                 `json:"avg_db_time_ms"`
+		P50DBTimeMs         *int64                 `json:"p50_db_time_ms"`
+		P95DBTimeMs         *int64                 `json:"p95_db_time_ms"`
+		P99DBTimeMs         *int64                 `json:"p99_db_time_ms"`
+		TestDurationSeconds *int                   `json:"test_duration_seconds"`
+		Notes               *string                `json:"notes"`
+		Concurrency         *int                   `json:"concurrency"`
+		LatencyDistribution *loadtest.Distribution `json:"latency_distribution"`
+		CreatedAt           time.Time              `json:"created_at"`
 	}
 
 	results := []TestResult{}
@@ -482,12 +505,10 @@ func GetAllTestResultsHandler(c *fiber.Ctx) error {
 			&r.ID, &r.TestType, &r.TotalRequests, &r.SuccessfulRequests,
 			&r.FailedRequests, &r.ErrorRate, &r.MinDBTimeMs, &r.MaxDBTimeMs,
 			&r.AvgDBTimeMs, &r.P50DBTimeMs, &r.P95DBTimeMs, &r.P99DBTimeMs,
-			&r.TestDurationSeconds, &r.Notes, &r.CreatedAt,
+			&r.TestDurationSeconds, &r.Notes, &r.Concurrency, &r.LatencyDistribution, &r.CreatedAt,
 		)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to parse test results",
-			})
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to parse test results")
 		}
 		results = append(results, r)
 	}
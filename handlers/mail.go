@@ -2,11 +2,19 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/config"
 	"mcq-exam/db"
+	"mcq-exam/i18n"
+	"mcq-exam/jobs"
+	"mcq-exam/logging"
+	"mcq-exam/mailhtml"
+	"mcq-exam/suppression"
+	"mcq-exam/tracking"
 	"mcq-exam/utils"
-	"os"
 	"strings"
 	"time"
 
@@ -14,156 +22,108 @@ import (
 )
 
 type SendEmailRequest struct {
-	ToEmail  string `json:"to_email"`
-	ToName   string `json:"to_name"`
-	Subject  string `json:"subject"`
-	HTMLBody string `json:"html_body"`
+	ToEmail     string                   `json:"to_email"`
+	ToName      string                   `json:"to_name"`
+	Subject     string                   `json:"subject"`
+	HTMLBody    string                   `json:"html_body"`
+	Attachments []EmailAttachmentRequest `json:"attachments"`
+}
+
+// EmailAttachmentRequest is a file sent with an email. With content_id it is an inline
+// image the body shows with <img src="cid:...">.
+type EmailAttachmentRequest struct {
+	Name      string `json:"name"`
+	MimeType  string `json:"mime_type"`
+	Content   string `json:"content"`
+	ContentID string `json:"content_id"`
+}
+
+// decodeAttachments turns the request's base64 attachments into the send parameters
+func decodeAttachments(requested []EmailAttachmentRequest) ([]utils.Attachment, error) {
+	attachments := make([]utils.Attachment, 0, len(requested))
+	for i, attachment := range requested {
+		// Encoders often wrap base64 lines; the line breaks carry no data
+		content := strings.Join(strings.Fields(attachment.Content), "")
+		data, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			return nil, fmt.Errorf("attachment %d content is not valid base64", i+1)
+		}
+		attachments = append(attachments, utils.Attachment{
+			Name:      strings.TrimSpace(attachment.Name),
+			MimeType:  strings.TrimSpace(attachment.MimeType),
+			ContentID: strings.TrimSpace(attachment.ContentID),
+			Data:      data,
+		})
+	}
+	return attachments, nil
 }
 
 // SendEmailHandler handles POST /api/mail/send
+// html_body is sanitized before sending (see package mailhtml); a body over the size limit
+// or with links that would not work in an email is refused.
 func SendEmailHandler(c *fiber.Ctx) error {
 	var req SendEmailRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
 	// Validate required fields
 	if strings.TrimSpace(req.ToEmail) == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to_email is required"})
+		return apierror.Send(c, fiber.StatusBadRequest, "to_email is required")
 	}
 	if strings.TrimSpace(req.Subject) == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "subject is required"})
+		return apierror.Send(c, fiber.StatusBadRequest, "subject is required")
 	}
 	if strings.TrimSpace(req.HTMLBody) == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "html_body is required"})
+		return apierror.Send(c, fiber.StatusBadRequest, "html_body is required")
 	}
 
-	// Send email
-	params := utils.SendEmailParams{
-		ToEmail:  req.ToEmail,
-		ToName:   req.ToName,
-		Subject:  req.Subject,
-		HTMLBody: req.HTMLBody,
+	sanitized, links, err := mailhtml.Sanitize(req.HTMLBody)
+	if errors.Is(err, mailhtml.ErrTooLarge) {
+		return apierror.Send(c, fiber.StatusRequestEntityTooLarge, err.Error())
 	}
-
-	zeptoResp, err := utils.SendEmail(params)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Failed to send email",
-			"details": err.Error(),
-		})
+	if errors.Is(err, mailhtml.ErrInvalidLinks) {
+		return apierror.Respond(c, fiber.StatusBadRequest, apierror.CodeBadRequest, err.Error(), fiber.Map{"invalid_links": links})
 	}
 
-	return c.JSON(fiber.Map{
-		"message":    "Email sent successfully",
-		"to":         req.ToEmail,
-		"subject":    req.Subject,
-		"request_id": zeptoResp.RequestID,
-	})
-}
-
-type SendAllRequest struct {
-	Subject  string `json:"subject"`
-	HTMLBody string `json:"html_body"`
-}
-
-// SendAllEmailsHandler handles POST /api/mail/send-all
-// Sends personalized emails to all students with {{name}} replacement
-func SendAllEmailsHandler(c *fiber.Ctx) error {
-	var req SendAllRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
-	}
-
-	// Validate required fields
-	if strings.TrimSpace(req.Subject) == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "subject is required"})
-	}
-	if strings.TrimSpace(req.HTMLBody) == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "html_body is required"})
-	}
-
-	// Get all students from database
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	query := `SELECT id, name, email FROM students ORDER BY id`
-	rows, err := db.Pool.Query(ctx, query)
+	attachments, err := decodeAttachments(req.Attachments)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch students"})
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
 	}
-	defer rows.Close()
 
-	type Student struct {
-		ID    int
-		Name  string
-		Email string
+	if suppression.IsSuppressed(c.Context(), req.ToEmail) {
+		return apierror.Send(c, fiber.StatusConflict, "Recipient is on the suppression list")
 	}
 
-	var students []Student
-	for rows.Next() {
-		var student Student
-		if err := rows.Scan(&student.ID, &student.Name, &student.Email); err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to scan student"})
-		}
-		students = append(students, student)
+	// Send email
+	params := utils.SendEmailParams{
+		ToEmail:     req.ToEmail,
+		ToName:      req.ToName,
+		Subject:     req.Subject,
+		HTMLBody:    sanitized.HTML,
+		Attachments: attachments,
 	}
 
-	if len(students) == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "No students found in database"})
+	result, err := utils.SendEmail(c.Context(), params)
+	if errors.Is(err, utils.ErrInvalidAttachment) {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
 	}
-
-	// Send emails to all students
-	sentCount := 0
-
-	for _, student := range students {
-		// Personalize email by replacing {{name}}
-		personalizedBody := strings.ReplaceAll(req.HTMLBody, "{{name}}", student.Name)
-
-		// Send email
-		params := utils.SendEmailParams{
-			ToEmail:  student.Email,
-			ToName:   student.Name,
-			Subject:  req.Subject,
-			HTMLBody: personalizedBody,
-		}
-
-		zeptoResp, err := utils.SendEmail(params)
-
-		// All emails marked as "sent" initially
-		// Webhook will update to "bounced" if delivery fails
-		status := "sent"
-		var requestID, responseCode, responseMessage *string
-		var zeptoResponseJSON *string
-
-		if err == nil {
-			sentCount++
-			requestID = &zeptoResp.RequestID
-			if len(zeptoResp.Data) > 0 {
-				responseCode = &zeptoResp.Data[0].Code
-				responseMessage = &zeptoResp.Data[0].Message
-			}
-			// Store full response as JSON
-			jsonBytes, _ := json.Marshal(zeptoResp)
-			jsonStr := string(jsonBytes)
-			zeptoResponseJSON = &jsonStr
-		}
-
-		// Log to database (even if API call failed, log for tracking)
-		logQuery := `
-			INSERT INTO email_logs (student_id, email, subject, status, request_id, response_code, response_message, zepto_response, sent_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
-		`
-		_, _ = db.Pool.Exec(context.Background(), logQuery, student.ID, student.Email, req.Subject, status, requestID, responseCode, responseMessage, zeptoResponseJSON)
-
-		// Small delay to avoid rate limiting
-		time.Sleep(100 * time.Millisecond)
+	if errors.Is(err, utils.ErrAttachmentsTooLarge) {
+		return apierror.Send(c, fiber.StatusRequestEntityTooLarge, err.Error())
+	}
+	if err != nil {
+		return apierror.Respond(c, fiber.StatusInternalServerError, apierror.CodeInternal,
+			"Failed to send email", fiber.Map{"error": err.Error()})
 	}
 
 	return c.JSON(fiber.Map{
-		"message": "All emails sent successfully",
-		"total":   len(students),
-		"sent":    sentCount,
+		"message":     "Email sent successfully",
+		"to":          req.ToEmail,
+		"subject":     req.Subject,
+		"request_id":  result.RequestID,
+		"provider":    result.Provider,
+		"attachments": len(attachments),
+		"removed":     sanitized.Removed,
 	})
 }
 
@@ -176,18 +136,19 @@ func ResendConferenceInvitationHandler(c *fiber.Ctx) error {
 
 	// Get students who have NOT attended the conference but have existing tokens
 	query := `
-		SELECT et.student_id, s.name, s.email, et.conference_token
+		SELECT et.student_id, s.name, s.email, COALESCE(s.locale, ''), et.conference_token
 		FROM email_tracking et
 		JOIN students s ON et.student_id = s.id
 		WHERE et.email_type = 'firstMail'
 		  AND et.conference_attended = false
 		  AND et.conference_token IS NOT NULL
+		  AND s.is_sandbox = false
 		ORDER BY et.student_id ASC
 	`
 
 	rows, err := db.Pool.Query(ctx, query)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch students"})
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch students")
 	}
 	defer rows.Close()
 
@@ -195,13 +156,14 @@ func ResendConferenceInvitationHandler(c *fiber.Ctx) error {
 		ID             int
 		Name           string
 		Email          string
+		Locale         string
 		ConferenceToken string
 	}
 
 	var students []StudentWithToken
 	for rows.Next() {
 		var st StudentWithToken
-		if err := rows.Scan(&st.ID, &st.Name, &st.Email, &st.ConferenceToken); err != nil {
+		if err := rows.Scan(&st.ID, &st.Name, &st.Email, &st.Locale, &st.ConferenceToken); err != nil {
 			continue
 		}
 		students = append(students, st)
@@ -215,23 +177,75 @@ func ResendConferenceInvitationHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get frontend URL from environment
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "https://nicm.smart-mcq.com"
-	}
+	// Get frontend URL from configuration
+	frontendURL := config.FrontendURL()
 
+	defer jobs.Track()()
 	sentCount := 0
+	skippedCount := 0
+	interrupted := false
 	for _, student := range students {
+		if jobs.Context().Err() != nil {
+			interrupted = true
+			break
+		}
+
+		// Skip known-bad addresses
+		if suppression.IsSuppressed(jobs.Context(), student.Email) {
+			skippedCount++
+			continue
+		}
+
 		// Reuse existing conference token
 		conferenceLink := frontendURL + "/live?token=" + student.ConferenceToken
 
 		// Email body - same as Phase 1 first mail
-		htmlBody := `
+		subject, htmlBody := i18n.Localize(jobs.Context(), i18n.TemplateFirstMail, student.Locale,
+			"Invitation: CoopQuest- An International Online Cooperative  Conclave", conferenceInvitationHTML(student.Name, conferenceLink),
+			map[string]string{"name": student.Name, "link": conferenceLink})
+
+		params := utils.SendEmailParams{
+			ToEmail:  student.Email,
+			ToName:   student.Name,
+			Subject:  subject,
+			HTMLBody: htmlBody,
+			Tracking: &tracking.Target{StudentID: student.ID, EmailType: tracking.FirstMail},
+		}
+
+		_, err := utils.SendEmail(jobs.Context(), params)
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Int("student_id", student.ID).Str("email", student.Email).Msg("Failed to resend conference invitation")
+		} else {
+			sentCount++
+			tracking.RecordSent(student.ID, tracking.FirstMail)
+		}
+	}
+
+	if interrupted {
+		return apierror.Respond(c, fiber.StatusServiceUnavailable, apierror.CodeShuttingDown,
+			"Server is shutting down, sending stopped early", fiber.Map{
+				"interrupted": true,
+				"total":       len(students),
+				"sent":        sentCount,
+				"skipped":     skippedCount,
+			})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Conference invitations resent successfully",
+		"total":   len(students),
+		"sent":    sentCount,
+		"skipped": skippedCount,
+	})
+}
+
+// conferenceInvitationHTML is the Phase 1 first mail body with the student's join link
+func conferenceInvitationHTML(name, conferenceLink string) string {
+	return fmt.Sprintf(`
 		<div style="font-family: Arial, sans-serif; max-width: 700px; margin: 0 auto; padding: 20px;">
 			<h2 style="color: #2c3e50;">Invitation to the Inaugural Virtual Meeting – CoopQuest - An International Online Cooperative Conclave</h2>
 
-			<p>Dear ` + student.Name + `,</p>
+			<p>Dear %s,</p>
 
 			<p><strong>Greetings from Natesan Institute of Cooperative Management (NICM), Chennai!</strong></p>
 
@@ -243,7 +257,7 @@ func ResendConferenceInvitationHandler(c *fiber.Ctx) error {
 				<p style="margin: 5px 0;"><strong>📅 Date:</strong> 8th October 2025</p>
 				<p style="margin: 5px 0;"><strong>🕒 Login Time:</strong> 1:45 PM (IST) onwards</p>
 				<p style="margin: 5px 0;"><strong>🎤 Inauguration:</strong> 2:00 PM (IST)</p>
-				<p style="margin: 5px 0;"><strong>🔗 Join Link:</strong> <a href="` + conferenceLink + `" style="color: #4CAF50; font-weight: bold;">Click here to join</a></p>
+				<p style="margin: 5px 0;"><strong>🔗 Join Link:</strong> <a href="%s" style="color: #4CAF50; font-weight: bold;">Click here to join</a></p>
 			</div>
 
 			<h3 style="color: #2c3e50;">Important Instructions for Participants:</h3>
@@ -269,31 +283,22 @@ func ResendConferenceInvitationHandler(c *fiber.Ctx) error {
 				"Cooperatives: Building a Better World Together"
 			</p>
 		</div>
-		`
-
-		params := utils.SendEmailParams{
-			ToEmail:  student.Email,
-			ToName:   student.Name,
-			Subject:  "Invitation: CoopQuest- An International Online Cooperative  Conclave",
-			HTMLBody: htmlBody,
-		}
-
-		_, err := utils.SendEmail(params)
-		if err != nil {
-			log.Printf("Failed to resend email to %s: %v", student.Email, err)
-		} else {
-			sentCount++
-		}
-
-		// Small delay to avoid rate limiting
-		time.Sleep(100 * time.Millisecond)
-	}
+	`, name, conferenceLink)
+}
 
-	return c.JSON(fiber.Map{
-		"message": "Conference invitations resent successfully",
-		"total":   len(students),
-		"sent":    sentCount,
-	})
+// testInvitationHTML is the Phase 2 second mail body with the student's test link and access code
+func testInvitationHTML(name, testURL, accessCode string) string {
+	return `
+		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto;">
+			<h2>Test Invitation - SmartMCQ</h2>
+			<p>Dear ` + name + `,</p>
+			<p>Thank you for attending the conference!</p>
+			<p>You are now eligible to take the test. Click the link below to start:</p>
+			<p><a href="` + testURL + `" style="background-color: #2196F3; color: white; padding: 14px 20px; text-decoration: none; border-radius: 4px; display: inline-block;">Start Test</a></p>
+			<p>Or use this access code: <strong>` + accessCode + `</strong></p>
+			<p>Best regards,<br>SmartMCQ Team</p>
+		</div>
+		`
 }
 
 // ResendTestInvitationHandler handles POST /api/mail/resend-test-invitation
@@ -303,22 +308,25 @@ func ResendTestInvitationHandler(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Get students who attended conference but haven't created session
+	// Get students who attended conference but haven't created session, and whose code still works
 	query := `
-		SELECT et.student_id, s.name, s.email, et.access_code
+		SELECT et.student_id, s.name, s.email, COALESCE(s.locale, ''), et.access_code
 		FROM email_tracking et
 		JOIN students s ON et.student_id = s.id
 		LEFT JOIN sessions sess ON sess.student_id = et.student_id
 		WHERE et.email_type = 'firstMail'
 		  AND et.conference_attended = true
 		  AND et.access_code IS NOT NULL
+		  AND et.access_code_invalidated_at IS NULL
+		  AND (et.access_code_expires_at IS NULL OR et.access_code_expires_at > NOW())
 		  AND sess.student_id IS NULL
+		  AND s.is_sandbox = false
 		ORDER BY et.student_id ASC
 	`
 
 	rows, err := db.Pool.Query(ctx, query)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch students"})
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch students")
 	}
 	defer rows.Close()
 
@@ -326,13 +334,14 @@ func ResendTestInvitationHandler(c *fiber.Ctx) error {
 		ID         int
 		Name       string
 		Email      string
+		Locale     string
 		AccessCode string
 	}
 
 	var students []StudentWithOTP
 	for rows.Next() {
 		var st StudentWithOTP
-		if err := rows.Scan(&st.ID, &st.Name, &st.Email, &st.AccessCode); err != nil {
+		if err := rows.Scan(&st.ID, &st.Name, &st.Email, &st.Locale, &st.AccessCode); err != nil {
 			continue
 		}
 		students = append(students, st)
@@ -346,51 +355,64 @@ func ResendTestInvitationHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get frontend URL from environment
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "https://nicm.smart-mcq.com"
-	}
+	// Get frontend URL from configuration
+	frontendURL := config.FrontendURL()
 
+	defer jobs.Track()()
 	sentCount := 0
+	skippedCount := 0
+	interrupted := false
 	for _, student := range students {
+		if jobs.Context().Err() != nil {
+			interrupted = true
+			break
+		}
+
+		// Skip known-bad addresses
+		if suppression.IsSuppressed(jobs.Context(), student.Email) {
+			skippedCount++
+			continue
+		}
+
 		// Create URL with existing OTP parameter
 		testURL := frontendURL + "?otp=" + student.AccessCode
 
 		// Email body - same as Phase 2 second mail template
-		htmlBody := `
-		<div style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto;">
-			<h2>Test Invitation - SmartMCQ</h2>
-			<p>Dear ` + student.Name + `,</p>
-			<p>Thank you for attending the conference!</p>
-			<p>You are now eligible to take the test. Click the link below to start:</p>
-			<p><a href="` + testURL + `" style="background-color: #2196F3; color: white; padding: 14px 20px; text-decoration: none; border-radius: 4px; display: inline-block;">Start Test</a></p>
-			<p>Or use this access code: <strong>` + student.AccessCode + `</strong></p>
-			<p>Best regards,<br>SmartMCQ Team</p>
-		</div>
-		`
+		subject, htmlBody := i18n.Localize(jobs.Context(), i18n.TemplateSecondMail, student.Locale,
+			"Test Invitation - Your Access Code", testInvitationHTML(student.Name, testURL, student.AccessCode),
+			map[string]string{"name": student.Name, "link": testURL, "access_code": student.AccessCode})
 
 		params := utils.SendEmailParams{
 			ToEmail:  student.Email,
 			ToName:   student.Name,
-			Subject:  "Test Invitation - Your Access Code",
+			Subject:  subject,
 			HTMLBody: htmlBody,
+			Tracking: &tracking.Target{StudentID: student.ID, EmailType: tracking.SecondMail},
 		}
 
-		_, err := utils.SendEmail(params)
+		_, err := utils.SendEmail(jobs.Context(), params)
 		if err != nil {
-			log.Printf("Failed to resend test invitation to %s: %v", student.Email, err)
+			logging.Ctx(c).Error().Err(err).Int("student_id", student.ID).Str("email", student.Email).Msg("Failed to resend test invitation")
 		} else {
 			sentCount++
+			tracking.RecordSent(student.ID, tracking.SecondMail)
 		}
+	}
 
-		// Small delay to avoid rate limiting
-		time.Sleep(100 * time.Millisecond)
+	if interrupted {
+		return apierror.Respond(c, fiber.StatusServiceUnavailable, apierror.CodeShuttingDown,
+			"Server is shutting down, sending stopped early", fiber.Map{
+				"interrupted": true,
+				"total":       len(students),
+				"sent":        sentCount,
+				"skipped":     skippedCount,
+			})
 	}
 
 	return c.JSON(fiber.Map{
 		"message": "Test invitations resent successfully",
 		"total":   len(students),
 		"sent":    sentCount,
+		"skipped": skippedCount,
 	})
 }
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"mcq-exam/apierror"
+	"mcq-exam/i18n"
+	"mcq-exam/mailhtml"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type LintEmailRequest struct {
+	Subject  string `json:"subject"`
+	HTMLBody string `json:"html_body"`
+	// TemplateKey names the template the body is for, whose placeholders are filled in
+	TemplateKey string `json:"template_key"`
+	// Variables are the placeholders filled in when there is no template_key
+	Variables []string `json:"variables"`
+}
+
+// LintEmailHandler handles POST /api/mail/lint
+// Body: {"subject": "Hello {{name}}", "html_body": "<p>...</p>", "template_key": "firstMail"}
+// Reports what would stop the email from being sent (size, invalid links) and warns about
+// what sanitizing removes, images without alt text, a missing tracking pixel and template
+// variables that would be sent as written. Nothing is sent or saved.
+func LintEmailHandler(c *fiber.Ctx) error {
+	var req LintEmailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if req.HTMLBody == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "html_body is required")
+	}
+	if req.TemplateKey != "" && !i18n.ValidKey(req.TemplateKey) {
+		return apierror.Send(c, fiber.StatusBadRequest, i18n.ErrInvalidKey.Error())
+	}
+
+	// POST /api/mail/send fills in nothing
+	variables := req.Variables
+	if req.TemplateKey != "" {
+		variables = i18n.Placeholders(req.TemplateKey)
+	}
+	if variables == nil {
+		variables = []string{}
+	}
+
+	return c.JSON(fiber.Map{
+		"template_key": req.TemplateKey,
+		"variables":    variables,
+		"report":       mailhtml.Lint(req.Subject, req.HTMLBody, variables),
+	})
+}
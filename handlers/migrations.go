@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type MigrateRequest struct {
+	// Steps is how many migrations to apply or roll back; 0 on up applies all pending
+	Steps int `json:"steps"`
+	// ExpectedVersion must match the current version, so a retried or stale request
+	// does not move the schema twice
+	ExpectedVersion *uint `json:"expected_version"`
+}
+
+// migrationsAPIEnabled reports whether migrations may be run over the API (MIGRATIONS_API_ENABLED, default false)
+func migrationsAPIEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("MIGRATIONS_API_ENABLED"))
+	return enabled
+}
+
+// GetMigrationsHandler handles GET /api/admin/migrations
+// Returns the applied version, dirty flag and the status of every migration file
+func GetMigrationsHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	state, err := db.MigrationStatus(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to read migration status")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to read migration status")
+	}
+	files, err := db.MigrationFiles()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to list migrations")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to list migrations")
+	}
+
+	type migrationEntry struct {
+		db.MigrationFile
+		Status string `json:"status"`
+	}
+	migrations := make([]migrationEntry, 0, len(files))
+	pending := 0
+	for _, file := range files {
+		status := "applied"
+		switch {
+		case file.Version > state.Version:
+			status = "pending"
+			pending++
+		case file.Version == state.Version && state.Dirty:
+			status = "dirty"
+		}
+		migrations = append(migrations, migrationEntry{MigrationFile: file, Status: status})
+	}
+
+	return c.JSON(fiber.Map{
+		"version":         state.Version,
+		"latest":          state.Latest,
+		"dirty":           state.Dirty,
+		"current":         state.Current(),
+		"pending":         pending,
+		"changes_enabled": migrationsAPIEnabled(),
+		"migrations":      migrations,
+	})
+}
+
+// MigrateUpHandler handles POST /api/admin/migrations/up
+// Body: {"steps": 0, "expected_version": 17}
+func MigrateUpHandler(c *fiber.Ctx) error {
+	return runMigration(c, "up", func(ctx context.Context, steps int) error {
+		return db.MigrateUp(steps)
+	})
+}
+
+// MigrateDownHandler handles POST /api/admin/migrations/down
+// Body: {"steps": 1, "expected_version": 18}
+func MigrateDownHandler(c *fiber.Ctx) error {
+	return runMigration(c, "down", db.MigrateDown)
+}
+
+// runMigration applies the guards shared by up and down, runs the migration and reports
+// the version before and after
+func runMigration(c *fiber.Ctx, direction string, migrate func(context.Context, int) error) error {
+	if !migrationsAPIEnabled() {
+		return apierror.Send(c, fiber.StatusForbidden, "Running migrations over the API is disabled. Set MIGRATIONS_API_ENABLED=true or use the migrate command")
+	}
+
+	var req MigrateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if req.ExpectedVersion == nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "expected_version is required (see GET /api/admin/migrations)")
+	}
+	if req.Steps < 0 || (direction == "down" && req.Steps < 1) {
+		return apierror.Send(c, fiber.StatusBadRequest, "steps must be at least 1 for down and not negative for up")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	before, err := db.MigrationStatus(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to read migration status")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to read migration status")
+	}
+	if before.Dirty {
+		return apierror.Respond(c, fiber.StatusConflict, apierror.CodeConflict,
+			"Schema is dirty after a failed migration. Fix it by hand, then run: migrate force <version>",
+			fiber.Map{"version": before.Version, "dirty": true})
+	}
+	if before.Version != *req.ExpectedVersion {
+		return apierror.Respond(c, fiber.StatusConflict, apierror.CodeConflict,
+			"Current version does not match expected_version", fiber.Map{"version": before.Version})
+	}
+
+	// Migrations can take a while; they are not tied to the request timeout
+	migrateErr := migrate(context.Background(), req.Steps)
+
+	afterCtx, afterCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer afterCancel()
+	after, err := db.MigrationStatus(afterCtx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to read migration status")
+	}
+	if errors.Is(migrateErr, db.ErrInitialSchema) {
+		return apierror.Send(c, fiber.StatusBadRequest, migrateErr.Error())
+	}
+	if migrateErr != nil {
+		logging.Ctx(c).Error().Err(migrateErr).Str("direction", direction).Int("steps", req.Steps).Msg("Migration failed")
+		return apierror.Respond(c, fiber.StatusInternalServerError, apierror.CodeInternal, "Migration failed", fiber.Map{
+			"error":            migrateErr.Error(),
+			"previous_version": before.Version,
+			"version":          after.Version,
+			"dirty":            after.Dirty,
+		})
+	}
+	logging.Ctx(c).Warn().Str("direction", direction).Uint("from", before.Version).Uint("to", after.Version).Msg("Migrations run over the API")
+
+	return c.JSON(fiber.Map{
+		"message":          "Migrations " + direction + " completed",
+		"previous_version": before.Version,
+		"version":          after.Version,
+		"latest":           after.Latest,
+		"dirty":            after.Dirty,
+	})
+}
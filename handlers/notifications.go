@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/config"
+	"mcq-exam/db"
+	"mcq-exam/examwindow"
+	"mcq-exam/jobs"
+	"mcq-exam/logging"
+	"mcq-exam/notify"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type NotifyRequest struct {
+	// Channel is "sms" or "whatsapp"
+	Channel string `json:"channel"`
+	// StudentIDs limits the send to these students; when empty, every eligible student
+	StudentIDs []int `json:"student_ids"`
+}
+
+type NotificationLog struct {
+	ID              int        `json:"id"`
+	StudentID       *int       `json:"student_id"`
+	Channel         string     `json:"channel"`
+	Phone           string     `json:"phone"`
+	Template        string     `json:"template"`
+	Status          string     `json:"status"`
+	Provider        string     `json:"provider"`
+	RequestID       *string    `json:"request_id"`
+	ResponseCode    *string    `json:"response_code"`
+	ResponseMessage *string    `json:"response_message"`
+	SentAt          time.Time  `json:"sent_at"`
+	StatusUpdatedAt *time.Time `json:"status_updated_at"`
+}
+
+// notifyRecipient is a student with a usable access code
+type notifyRecipient struct {
+	ID         int
+	Name       string
+	Phone      *string
+	Country    *string
+	Timezone   *string
+	AccessCode string
+}
+
+// loadNotifyRecipients returns attended students whose access code still works. Without
+// explicit student IDs, students who already started the test are left out.
+func loadNotifyRecipients(ctx context.Context, studentIDs []int) ([]notifyRecipient, error) {
+	query := `
+		SELECT s.id, s.name, s.phone, s.country, s.timezone, et.access_code
+		FROM email_tracking et
+		JOIN students s ON et.student_id = s.id
+		WHERE et.email_type = 'firstMail'
+		  AND et.conference_attended = true
+		  AND et.access_code IS NOT NULL
+		  AND et.access_code_used_at IS NULL
+		  AND s.is_sandbox = false
+		  AND et.access_code_invalidated_at IS NULL
+		  AND (et.access_code_expires_at IS NULL OR et.access_code_expires_at > NOW())
+		  AND (cardinality($1::int[]) > 0 AND s.id = ANY($1)
+		       OR cardinality($1::int[]) = 0 AND NOT EXISTS (SELECT 1 FROM sessions sess WHERE sess.student_id = s.id))
+		ORDER BY s.id ASC
+	`
+	if studentIDs == nil {
+		studentIDs = []int{}
+	}
+	rows, err := db.Pool.Query(ctx, query, studentIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []notifyRecipient
+	for rows.Next() {
+		var r notifyRecipient
+		if err := rows.Scan(&r.ID, &r.Name, &r.Phone, &r.Country, &r.Timezone, &r.AccessCode); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+// parseNotifyRequest reads the body and checks that the channel can send
+func parseNotifyRequest(c *fiber.Ctx) (*NotifyRequest, error) {
+	var req NotifyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return nil, apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	req.Channel = strings.ToLower(strings.TrimSpace(req.Channel))
+	if !notify.ValidChannel(req.Channel) {
+		return nil, apierror.Send(c, fiber.StatusBadRequest, "channel must be sms or whatsapp")
+	}
+	if err := notify.Enabled(req.Channel); err != nil {
+		return nil, apierror.Respond(c, fiber.StatusServiceUnavailable, apierror.CodeServiceUnavailable,
+			"Channel is not configured", fiber.Map{"error": err.Error()})
+	}
+	return &req, nil
+}
+
+// sendNotifications sends one message per recipient, built by params, and reports the totals
+func sendNotifications(c *fiber.Ctx, req *NotifyRequest, template string, recipients []notifyRecipient, params func(notifyRecipient) []string) error {
+	if len(recipients) == 0 {
+		return c.JSON(fiber.Map{
+			"message": "No students found to notify",
+			"total":   0,
+			"sent":    0,
+		})
+	}
+
+	defer jobs.Track()()
+	sentCount := 0
+	failedCount := 0
+	skipped := make([]fiber.Map, 0)
+	interrupted := false
+	for _, recipient := range recipients {
+		if jobs.Context().Err() != nil {
+			interrupted = true
+			break
+		}
+
+		var phone, country string
+		if recipient.Phone != nil {
+			phone = *recipient.Phone
+		}
+		if recipient.Country != nil {
+			country = *recipient.Country
+		}
+		if phone == "" {
+			skipped = append(skipped, fiber.Map{"student_id": recipient.ID, "reason": "no phone number"})
+			continue
+		}
+		to, err := notify.NormalizePhone(phone, country)
+		if err != nil {
+			skipped = append(skipped, fiber.Map{"student_id": recipient.ID, "reason": err.Error()})
+			continue
+		}
+
+		msg := notify.Message{To: to, Template: template, Params: params(recipient)}
+		if _, err := notify.Send(jobs.Context(), req.Channel, recipient.ID, msg); err != nil {
+			failedCount++
+			logging.Ctx(c).Error().Err(err).Int("student_id", recipient.ID).Str("channel", req.Channel).Msg("Failed to send notification")
+		} else {
+			sentCount++
+		}
+
+		// Small delay to avoid rate limiting
+		jobs.Sleep(jobs.Context(), 100*time.Millisecond)
+	}
+
+	if interrupted {
+		return apierror.Respond(c, fiber.StatusServiceUnavailable, apierror.CodeShuttingDown,
+			"Server is shutting down, sending stopped early", fiber.Map{
+				"interrupted": true,
+				"total":       len(recipients),
+				"sent":        sentCount,
+				"failed":      failedCount,
+				"skipped":     skipped,
+			})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Notifications sent",
+		"channel": req.Channel,
+		"total":   len(recipients),
+		"sent":    sentCount,
+		"failed":  failedCount,
+		"skipped": skipped,
+	})
+}
+
+// SendAccessCodeNotificationHandler handles POST /api/notify/access-code
+// Body: {"channel": "sms", "student_ids": [12]}. Without student_ids, every attended
+// student with a valid access code who has not started the test.
+func SendAccessCodeNotificationHandler(c *fiber.Ctx) error {
+	req, err := parseNotifyRequest(c)
+	if req == nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	recipients, err := loadNotifyRecipients(ctx, req.StudentIDs)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch students")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch students")
+	}
+
+	frontendURL := config.FrontendURL()
+
+	return sendNotifications(c, req, notify.TemplateAccessCode, recipients, func(r notifyRecipient) []string {
+		return []string{r.Name, r.AccessCode, frontendURL + "?otp=" + r.AccessCode}
+	})
+}
+
+// SendStartReminderNotificationHandler handles POST /api/notify/start-reminder
+// Body as for access-code. The start time is the student's exam window, shown in their timezone.
+func SendStartReminderNotificationHandler(c *fiber.Ctx) error {
+	req, err := parseNotifyRequest(c)
+	if req == nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	schedule, err := examwindow.Latest(ctx)
+	if errors.Is(err, examwindow.ErrNoSchedule) {
+		return apierror.Send(c, fiber.StatusNotFound, "No event has been scheduled")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch event schedule")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch event schedule")
+	}
+
+	recipients, err := loadNotifyRecipients(ctx, req.StudentIDs)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch students")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch students")
+	}
+
+	return sendNotifications(c, req, notify.TemplateStartReminder, recipients, func(r notifyRecipient) []string {
+		var timezone string
+		if r.Timezone != nil {
+			timezone = *r.Timezone
+		}
+		window := examwindow.For(jobs.Context(), schedule, timezone)
+		loc, err := time.LoadLocation(window.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		return []string{r.Name, window.StartsAt.In(loc).Format("02 Jan 2006, 03:04 PM MST"), r.AccessCode}
+	})
+}
+
+// GetNotificationLogsHandler handles GET /api/notify/logs?channel=sms&status=failed&student_id=12
+func GetNotificationLogsHandler(c *fiber.Ctx) error {
+	channel := c.Query("channel")
+	status := c.Query("status")
+	studentID := c.QueryInt("student_id", 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT id, student_id, channel, phone, template, status, provider, request_id, response_code,
+		       response_message, sent_at, status_updated_at
+		FROM notification_logs
+		WHERE ($1 = '' OR channel = $1)
+		  AND ($2 = '' OR status = $2)
+		  AND ($3 = 0 OR student_id = $3)
+		ORDER BY id DESC
+		LIMIT 1000
+	`
+	rows, err := db.Pool.Query(ctx, query, channel, status, studentID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch notification logs")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch notification logs")
+	}
+	defer rows.Close()
+
+	logs := make([]NotificationLog, 0)
+	for rows.Next() {
+		var entry NotificationLog
+		if err := rows.Scan(&entry.ID, &entry.StudentID, &entry.Channel, &entry.Phone, &entry.Template, &entry.Status,
+			&entry.Provider, &entry.RequestID, &entry.ResponseCode, &entry.ResponseMessage, &entry.SentAt, &entry.StatusUpdatedAt); err != nil {
+			continue
+		}
+		logs = append(logs, entry)
+	}
+
+	return c.JSON(fiber.Map{
+		"count": len(logs),
+		"logs":  logs,
+	})
+}
+
+// GetNotificationChannelsHandler handles GET /api/notify/channels
+// Shows which provider each channel sends through, or why it is disabled
+func GetNotificationChannelsHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"channels": notify.ChannelStatus()})
+}
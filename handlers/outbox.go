@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/outbox"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxOutboxRetryIDs bounds the ids list of POST /api/admin/outbox/retry
+const maxOutboxRetryIDs = 5000
+
+// GetOutboxHandler handles GET /api/admin/outbox?status=failed&student_id=12&limit=100
+// Reports the email outbox: message counts by status, the age of the oldest unsent message
+// and the latest messages, of one status or student when filtered
+func GetOutboxHandler(c *fiber.Ctx) error {
+	status := c.Query("status")
+	switch status {
+	case "", outbox.StatusPending, outbox.StatusSending, outbox.StatusSent, outbox.StatusFailed, outbox.StatusSkipped:
+	default:
+		return apierror.Send(c, fiber.StatusBadRequest, "status must be pending, sending, sent, failed or skipped")
+	}
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > 1000 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Limit must be between 1 and 1000")
+	}
+	studentID := c.QueryInt("student_id", 0)
+	if studentID < 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid student ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	report, err := outbox.Query(ctx, status, studentID, limit)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to build outbox report")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch outbox")
+	}
+	return c.JSON(fiber.Map{
+		"config": fiber.Map{
+			"poll_interval_seconds": outbox.PollInterval().Seconds(),
+			"max_attempts":          outbox.MaxAttempts(),
+		},
+		"report": report,
+	})
+}
+
+type OutboxRetryRequest struct {
+	IDs []int64 `json:"ids"`
+	// AllFailed retries every failed message instead of IDs
+	AllFailed bool `json:"all_failed"`
+}
+
+// RetryOutboxHandler handles POST /api/admin/outbox/retry
+// Body: {"ids": [31, 32]} or {"all_failed": true}
+// Queues failed messages for another round of sends, e.g. after a provider outage
+func RetryOutboxHandler(c *fiber.Ctx) error {
+	var req OutboxRetryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if req.AllFailed == (len(req.IDs) > 0) {
+		return apierror.Send(c, fiber.StatusBadRequest, "Provide either ids or \"all_failed\": true")
+	}
+	if len(req.IDs) > maxOutboxRetryIDs {
+		return apierror.Send(c, fiber.StatusBadRequest, "At most 5000 ids per request")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	queued, err := outbox.Retry(ctx, req.IDs)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to retry outbox emails")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to retry emails")
+	}
+	logging.Ctx(c).Info().Int("queued", len(queued)).Bool("all_failed", req.AllFailed).Msg("Retrying outbox emails")
+
+	return c.JSON(fiber.Map{
+		"message": "Failed emails queued for retry",
+		"queued":  len(queued),
+		"ids":     queued,
+	})
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/proctoring"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type FlaggedSession struct {
+	SessionID     int            `json:"session_id"`
+	StudentID     int            `json:"student_id"`
+	Name          string         `json:"name"`
+	Email         string         `json:"email"`
+	AttemptNumber int            `json:"attempt_number"`
+	Completed     bool           `json:"completed"`
+	Score         *int           `json:"score"`
+	CompletedAt   *time.Time     `json:"completed_at"`
+	TotalEvents   int            `json:"total_events"`
+	EventCounts   map[string]int `json:"event_counts"`
+	LastEventAt   time.Time      `json:"last_event_at"`
+}
+
+type ProctorEventRecord struct {
+	ID         int64           `json:"id"`
+	EventType  string          `json:"event_type"`
+	Details    json.RawMessage `json:"details"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	ReceivedAt time.Time       `json:"received_at"`
+}
+
+// GetFlaggedSessionsHandler handles GET /api/admin/proctoring/flagged?min_events=3&event_type=tab_switch&completed=true
+// Lists sessions with at least min_events proctoring events (default PROCTOR_FLAG_THRESHOLD),
+// most events first, so they can be reviewed before merit certificates are awarded.
+func GetFlaggedSessionsHandler(c *fiber.Ctx) error {
+	minEvents := proctoring.FlagThreshold()
+	if value := c.Query("min_events"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return apierror.Send(c, fiber.StatusBadRequest, "min_events must be a positive integer")
+		}
+		minEvents = parsed
+	}
+
+	eventType := c.Query("event_type")
+	if eventType != "" && !proctoring.IsValidEventType(eventType) {
+		return apierror.Send(c, fiber.StatusBadRequest, fmt.Sprintf("Unknown event type '%s'. Valid types: %s", eventType, strings.Join(proctoring.EventTypes, ", ")))
+	}
+	completedOnly := c.QueryBool("completed", false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// With event_type set, only that type counts toward min_events
+	query := `
+		SELECT s.id, s.student_id, st.name, st.email, s.attempt_number, s.completed, s.score, s.completed_at,
+		       c.total, c.counts, c.last_event_at
+		FROM (
+			SELECT session_id,
+			       SUM(n)::int AS total,
+			       COALESCE(SUM(n) FILTER (WHERE event_type = $2), 0)::int AS type_total,
+			       jsonb_object_agg(event_type, n) AS counts,
+			       MAX(last_at) AS last_event_at
+			FROM (
+				SELECT session_id, event_type, COUNT(*) AS n, MAX(occurred_at) AS last_at
+				FROM proctor_events
+				GROUP BY session_id, event_type
+			) per_type
+			GROUP BY session_id
+		) c
+		JOIN sessions s ON s.id = c.session_id
+		JOIN students st ON st.id = s.student_id
+		WHERE CASE WHEN $2 = '' THEN c.total ELSE c.type_total END >= $1
+		  AND (NOT $3 OR s.completed = true)
+		ORDER BY c.total DESC, s.id ASC
+	`
+	rows, err := db.Pool.Query(ctx, query, minEvents, eventType, completedOnly)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch flagged sessions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch flagged sessions")
+	}
+	defer rows.Close()
+
+	sessions := []FlaggedSession{}
+	for rows.Next() {
+		var s FlaggedSession
+		if err := rows.Scan(&s.SessionID, &s.StudentID, &s.Name, &s.Email, &s.AttemptNumber, &s.Completed, &s.Score,
+			&s.CompletedAt, &s.TotalEvents, &s.EventCounts, &s.LastEventAt); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan flagged session")
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+
+	return c.JSON(fiber.Map{
+		"min_events": minEvents,
+		"count":      len(sessions),
+		"sessions":   sessions,
+	})
+}
+
+// GetSessionProctorEventsHandler handles GET /api/admin/proctoring/sessions/:session_id/events
+// Returns the session's proctoring events in the order they happened
+func GetSessionProctorEventsHandler(c *fiber.Ctx) error {
+	sessionID, err := strconv.Atoi(c.Params("session_id"))
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid session ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var exists bool
+	if err := db.Pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM sessions WHERE id = $1)`, sessionID).Scan(&exists); err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch session")
+	}
+	if !exists {
+		return apierror.Send(c, fiber.StatusNotFound, "Session not found")
+	}
+
+	query := `
+		SELECT id, event_type, details, occurred_at, received_at
+		FROM proctor_events
+		WHERE session_id = $1
+		ORDER BY occurred_at ASC, id ASC
+	`
+	rows, err := db.Pool.Query(ctx, query, sessionID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("session_id", sessionID).Msg("Failed to fetch proctor events")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch events")
+	}
+	defer rows.Close()
+
+	events := []ProctorEventRecord{}
+	for rows.Next() {
+		var e ProctorEventRecord
+		if err := rows.Scan(&e.ID, &e.EventType, &e.Details, &e.OccurredAt, &e.ReceivedAt); err != nil {
+			continue
+		}
+		events = append(events, e)
+	}
+
+	return c.JSON(fiber.Map{
+		"session_id": sessionID,
+		"count":      len(events),
+		"events":     events,
+	})
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/questions"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type SectionCompositionRequest struct {
+	SectionID int              `json:"section_id"`
+	Rules     []questions.Rule `json:"rules"`
+}
+
+type UpdateCompositionRequest struct {
+	Sections []SectionCompositionRequest `json:"sections"`
+}
+
+type UpdateQuestionMetadataRequest struct {
+	Questions []questions.MetadataUpdate `json:"questions"`
+}
+
+// compositionView describes what sessions draw from each section and the pool they draw from
+func compositionView(bank []questions.Section) fiber.Map {
+	cfg := questions.ConfigFromEnv()
+	sections := make([]fiber.Map, 0, len(bank))
+	for _, section := range bank {
+		rules := section.Composition
+		if rules == nil {
+			rules = []questions.Rule{}
+		}
+		sections = append(sections, fiber.Map{
+			"section_id":  section.ID,
+			"name":        section.Name,
+			"questions":   len(section.Questions),
+			"serves":      questions.Serves(section, cfg),
+			"composition": rules,
+			"pool":        questions.Pool(section),
+		})
+	}
+	return fiber.Map{"difficulties": questions.Difficulties, "sections": sections}
+}
+
+// saveBank stores a changed bank, refusing while sessions are in progress unless force is
+// set, since their question sets would change. It responds itself on failure; ok reports
+// whether the bank was saved.
+func saveBank(c *fiber.Ctx, bank []questions.Section, force bool, action string) (backup string, ok bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	open, err := openSessions(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to count open sessions")
+		return "", false, apierror.Send(c, fiber.StatusInternalServerError, "Failed to "+action)
+	}
+	if open > 0 && !force {
+		return "", false, apierror.Respond(c, fiber.StatusConflict, apierror.CodeConflict,
+			"Sessions are in progress and would see their questions change; retry after they end or pass force=true",
+			fiber.Map{"open_sessions": open})
+	}
+
+	backup, err = questions.Save(bank)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to save questions")
+		return "", false, apierror.Send(c, fiber.StatusInternalServerError, "Failed to save questions")
+	}
+	return backup, true, nil
+}
+
+// GetQuestionCompositionHandler handles GET /api/admin/questions/composition
+// Returns each section's composition rules, how many questions a session is served and the
+// pool by difficulty and topic
+func GetQuestionCompositionHandler(c *fiber.Ctx) error {
+	bank, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+	}
+	return c.JSON(compositionView(bank))
+}
+
+// UpdateQuestionCompositionHandler handles PUT /api/admin/questions/composition?dry_run=true&force=true
+// Body: {"sections": [{"section_id": 1, "rules": [{"count": 10, "difficulty": "easy"}, {"count": 5, "difficulty": "hard", "topic": "Algebra"}]}]}
+// Replaces the rules of the listed sections (empty rules serve the whole section again).
+// Rules must not overlap and the pool must hold enough questions for each; dry_run checks
+// them without saving.
+func UpdateQuestionCompositionHandler(c *fiber.Ctx) error {
+	var req UpdateCompositionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if len(req.Sections) == 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "sections is required")
+	}
+	rules := make(map[int][]questions.Rule, len(req.Sections))
+	for _, section := range req.Sections {
+		if _, ok := rules[section.SectionID]; ok {
+			return apierror.Send(c, fiber.StatusBadRequest, "Each section may be listed once")
+		}
+		rules[section.SectionID] = section.Rules
+	}
+
+	questionImportMu.Lock()
+	defer questionImportMu.Unlock()
+
+	bank, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+	}
+	updated, err := questions.SetComposition(bank, rules)
+	if errors.Is(err, questions.ErrInvalidComposition) {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to compose sections")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to update composition")
+	}
+
+	dryRun := c.QueryBool("dry_run", false)
+	response := compositionView(updated)
+	response["dry_run"] = dryRun
+	if dryRun {
+		response["message"] = "Dry run: nothing was saved"
+		return c.JSON(response)
+	}
+	backup, ok, err := saveBank(c, updated, c.QueryBool("force", false), "update composition")
+	if !ok {
+		return err
+	}
+	logging.Ctx(c).Warn().Int("sections", len(rules)).Str("backup", backup).Msg("Section composition updated")
+
+	response["message"] = "Composition updated"
+	response["backup"] = backup
+	return c.JSON(response)
+}
+
+// UpdateQuestionMetadataHandler handles PUT /api/admin/questions/metadata?force=true
+// Body: {"questions": [{"id": 12, "difficulty": "hard", "topic": "Algebra"}, {"id": 13, "topic": ""}]}
+// Tags questions with a difficulty and topic; an omitted field keeps its value and an empty
+// string clears it. Refused when the sections' composition rules could no longer be met.
+func UpdateQuestionMetadataHandler(c *fiber.Ctx) error {
+	var req UpdateQuestionMetadataRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if len(req.Questions) == 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "questions is required")
+	}
+
+	questionImportMu.Lock()
+	defer questionImportMu.Unlock()
+
+	bank, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+	}
+	updated, err := questions.SetMetadata(bank, req.Questions)
+	if errors.Is(err, questions.ErrInvalidComposition) {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to tag questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to update questions")
+	}
+
+	// Tags only change the question sets drawn by composition rules
+	force := c.QueryBool("force", false) || !questions.Composed(bank)
+	backup, ok, err := saveBank(c, updated, force, "update questions")
+	if !ok {
+		return err
+	}
+	logging.Ctx(c).Info().Int("questions", len(req.Questions)).Str("backup", backup).Msg("Question metadata updated")
+
+	response := compositionView(updated)
+	response["message"] = "Questions updated"
+	response["updated"] = len(req.Questions)
+	response["backup"] = backup
+	return c.JSON(response)
+}
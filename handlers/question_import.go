@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/questions"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// questionImportMu keeps concurrent imports from overwriting each other's questions
+var questionImportMu sync.Mutex
+
+// openSessions counts the sessions in progress, whose question sets a bank change would alter
+func openSessions(ctx context.Context) (int, error) {
+	var open int
+	err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM sessions WHERE completed = false AND abandoned_at IS NULL`).Scan(&open)
+	return open, err
+}
+
+func bankSize(sections []questions.Section) fiber.Map {
+	count := 0
+	for _, section := range sections {
+		count += len(section.Questions)
+	}
+	return fiber.Map{"sections": len(sections), "questions": count}
+}
+
+// ImportQuestionsHandler handles POST /api/admin/questions/import?format=moodle&mode=append&section_id=&section_name=&time_limit=&difficulty=&topic=&dry_run=true
+// Body: an Aiken text file, a Moodle XML export, or QTI 2.x (an item XML or a zip package)
+// Converts the file into questions and adds them to the question bank (mode=replace swaps
+// the bank). Errors in any question block the import; dry_run=true reports them, the
+// warnings and the resulting sections without saving anything. difficulty and topic tag
+// every imported question.
+func ImportQuestionsHandler(c *fiber.Ctx) error {
+	body := c.Body()
+	if len(body) == 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Request body must be an Aiken, Moodle XML or QTI file")
+	}
+	format := strings.ToLower(strings.TrimSpace(c.Query("format")))
+	if format == "" {
+		format = questions.DetectFormat(body)
+	}
+	mode := c.Query("mode", "append")
+	if mode != "append" && mode != "replace" {
+		return apierror.Send(c, fiber.StatusBadRequest, "mode must be append or replace")
+	}
+	opts := questions.ImportOptions{
+		Replace:     mode == "replace",
+		SectionID:   c.QueryInt("section_id", 0),
+		SectionName: strings.TrimSpace(c.Query("section_name")),
+		TimeLimit:   c.QueryInt("time_limit", 0),
+	}
+	var err error
+	if opts.Difficulty, err = questions.NormalizeDifficulty(c.Query("difficulty")); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if opts.Topic, err = questions.NormalizeTopic(c.Query("topic")); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if opts.SectionID > 0 && opts.SectionName != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Pass section_id or section_name, not both")
+	}
+	if opts.Replace && opts.SectionID > 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "section_id cannot be used with mode=replace; the current sections are discarded")
+	}
+	dryRun := c.QueryBool("dry_run", false)
+	force := c.QueryBool("force", false)
+
+	parsed, err := questions.Parse(format, body)
+	if errors.Is(err, questions.ErrUnknownFormat) {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to parse question import")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to import questions")
+	}
+	if len(parsed.Questions) == 0 && len(parsed.Errors) == 0 {
+		parsed.Errors = append(parsed.Errors, questions.ImportError{Item: "file", Message: "no questions to import"})
+	}
+
+	questionImportMu.Lock()
+	defer questionImportMu.Unlock()
+
+	bank, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+	}
+	merged, sections, duplicates, err := questions.Merge(bank, parsed, opts)
+	if errors.Is(err, questions.ErrInvalidImport) {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to merge question import")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to import questions")
+	}
+	warnings := append(parsed.Warnings, duplicates...)
+
+	valid := len(parsed.Errors) == 0
+	response := fiber.Map{
+		"dry_run":            dryRun,
+		"format":             parsed.Format,
+		"mode":               mode,
+		"valid":              valid,
+		"errors":             parsed.Errors,
+		"warnings":           warnings,
+		"imported_questions": len(parsed.Questions),
+		"sections":           sections,
+		"bank_before":        bankSize(bank),
+		"bank_after":         bankSize(merged),
+	}
+	if dryRun {
+		response["message"] = "Dry run: nothing was saved"
+		return c.JSON(response)
+	}
+	if !valid {
+		return apierror.Respond(c, fiber.StatusBadRequest, apierror.CodeBadRequest, "Import has errors; nothing was saved",
+			fiber.Map{"errors": parsed.Errors, "warnings": warnings})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Stored answers point at question IDs, which a replaced bank would reuse
+	if opts.Replace {
+		var answered bool
+		if err := db.Pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM answers)`).Scan(&answered); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to check for answers")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to import questions")
+		}
+		if answered {
+			return apierror.Send(c, fiber.StatusConflict, "Answers already reference the question bank; use mode=append or reset the database first")
+		}
+	}
+	// A session's question set is drawn from the bank, so it changes under open sessions
+	open, err := openSessions(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to count open sessions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to import questions")
+	}
+	if open > 0 && !force {
+		return apierror.Respond(c, fiber.StatusConflict, apierror.CodeConflict,
+			"Sessions are in progress and would see their questions change; retry after they end or pass force=true",
+			fiber.Map{"open_sessions": open})
+	}
+
+	backup, err := questions.Save(merged)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to save questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to save questions")
+	}
+	logging.Ctx(c).Warn().Str("format", parsed.Format).Str("mode", mode).Int("questions", len(parsed.Questions)).
+		Str("backup", backup).Msg("Questions imported")
+
+	response["message"] = "Questions imported"
+	response["backup"] = backup
+	return c.JSON(response)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/questions"
+	"mcq-exam/ranking"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type UpdateRankingPolicyRequest struct {
+	Criteria []string `json:"criteria"`
+}
+
+// GetRankingPolicyHandler handles GET /api/admin/ranking-policy
+// Returns the criteria used by the leaderboard, results and exports, and the ones available
+func GetRankingPolicyHandler(c *fiber.Ctx) error {
+	sections, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	policy, err := ranking.Load(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load ranking policy")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load ranking policy")
+	}
+
+	return c.JSON(fiber.Map{
+		"criteria":   policy.Criteria,
+		"updated_at": policy.UpdatedAt,
+		"available":  ranking.Available(sections),
+	})
+}
+
+// UpdateRankingPolicyHandler handles PUT /api/admin/ranking-policy
+// Body: {"criteria": ["score", "time", "completed_at", "section:4"]}
+func UpdateRankingPolicyHandler(c *fiber.Ctx) error {
+	var req UpdateRankingPolicyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	for i := range req.Criteria {
+		req.Criteria[i] = strings.ToLower(strings.TrimSpace(req.Criteria[i]))
+	}
+
+	sections, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+	}
+	if err := ranking.Validate(req.Criteria, sections); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	policy, err := ranking.Save(ctx, req.Criteria)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to save ranking policy")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to save ranking policy")
+	}
+	logging.Ctx(c).Info().Strs("criteria", policy.Criteria).Msg("Ranking policy updated")
+
+	return c.JSON(fiber.Map{
+		"message":    "Ranking policy updated",
+		"criteria":   policy.Criteria,
+		"updated_at": policy.UpdatedAt,
+	})
+}
@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/captcha"
+	"mcq-exam/config"
+	"mcq-exam/db"
+	"mcq-exam/emailcheck"
+	"mcq-exam/events"
+	"mcq-exam/examwindow"
+	"mcq-exam/i18n"
+	"mcq-exam/logging"
+	"mcq-exam/models"
+	"mcq-exam/outbox"
+	"mcq-exam/suppression"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+type RegisterRequest struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	// CaptchaToken is the response of the CAPTCHA_PROVIDER widget
+	CaptchaToken string `json:"captcha_token"`
+	models.StudentProfile
+}
+
+type VerifyRegistrationRequest struct {
+	Token string `json:"token"`
+}
+
+type UpdateRegistrationWindowRequest struct {
+	// OpensAt and ClosesAt are in the schedule's timezone (YYYY-MM-DDTHH:MM:SS); an empty
+	// OpensAt closes registration and an empty ClosesAt keeps it open until the exam
+	OpensAt  string `json:"opens_at"`
+	ClosesAt string `json:"closes_at"`
+}
+
+// registrationWindow is when the latest event accepts self-registrations
+type registrationWindow struct {
+	ScheduleID int        `json:"schedule_id"`
+	Open       bool       `json:"open"`
+	OpensAt    *time.Time `json:"opens_at"`
+	ClosesAt   *time.Time `json:"closes_at"`
+}
+
+// registrationTokenTTL is how long a verification link stays valid (REGISTRATION_TOKEN_TTL, default 24h)
+func registrationTokenTTL() time.Duration {
+	ttl := 24 * time.Hour
+	if value := os.Getenv("REGISTRATION_TOKEN_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Warn().Msgf("Invalid REGISTRATION_TOKEN_TTL=%q, using %s", value, ttl)
+		} else {
+			ttl = parsed
+		}
+	}
+	return ttl
+}
+
+// loadRegistrationWindow reads the latest event's registration window. Registration is open
+// from registration_opens_at until registration_closes_at, when set.
+func loadRegistrationWindow(ctx context.Context) (*registrationWindow, error) {
+	var w registrationWindow
+	query := `
+		SELECT id, registration_opens_at, registration_closes_at,
+		       registration_opens_at <= NOW() AND (registration_closes_at IS NULL OR registration_closes_at > NOW())
+		FROM event_schedule
+		ORDER BY id DESC
+		LIMIT 1
+	`
+	var open *bool
+	err := db.Pool.QueryRow(ctx, query).Scan(&w.ScheduleID, &w.OpensAt, &w.ClosesAt, &open)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, examwindow.ErrNoSchedule
+	}
+	if err != nil {
+		return nil, err
+	}
+	w.Open = open != nil && *open
+	return &w, nil
+}
+
+// GetRegistrationStatusHandler handles GET /api/register/status
+// Tells the registration page whether registration is open and which captcha to show
+func GetRegistrationStatusHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	window, err := loadRegistrationWindow(ctx)
+	if errors.Is(err, examwindow.ErrNoSchedule) {
+		return c.JSON(fiber.Map{"open": false, "opens_at": nil, "closes_at": nil, "captcha": captchaStatus()})
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load registration window")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load registration status")
+	}
+
+	return c.JSON(fiber.Map{
+		"open":      window.Open,
+		"opens_at":  window.OpensAt,
+		"closes_at": window.ClosesAt,
+		"captcha":   captchaStatus(),
+	})
+}
+
+func captchaStatus() fiber.Map {
+	if !captcha.Enabled() {
+		return fiber.Map{"enabled": false}
+	}
+	return fiber.Map{"enabled": true, "provider": captcha.Provider()}
+}
+
+// RegisterHandler handles POST /api/register
+// Body: {"name": "...", "email": "...", "captcha_token": "...", "institution": "...", ...}.
+// Stores a pending registration and emails a verification link; the student is created
+// once the link is confirmed. The response is the same whether or not the email is already
+// registered, so the endpoint cannot be used to look up participants.
+func RegisterHandler(c *fiber.Ctx) error {
+	var req RegisterRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	req.Name, req.Email = strings.TrimSpace(req.Name), strings.TrimSpace(req.Email)
+	if req.Name == "" || req.Email == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Name and email are required")
+	}
+	if len(req.Name) > 255 {
+		return apierror.Send(c, fiber.StatusBadRequest, "name must be at most 255 characters")
+	}
+	if len(req.Email) > 255 || emailcheck.Syntax(req.Email) != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "email must be a valid email address")
+	}
+	// Catches typos such as gmial.com while the registrant can still correct them
+	if emailcheck.MXEnabled() && emailcheck.Domain(context.Background(), emailcheck.DomainOf(req.Email)) != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "email domain cannot receive email, please check the address")
+	}
+	if err := normalizeProfile(&req.StudentProfile); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	// Without a chosen language, the browser's is kept when supported
+	if req.Locale == nil || *req.Locale == "" {
+		if locale, ok := i18n.FromAcceptLanguage(c.Get(fiber.HeaderAcceptLanguage)); ok {
+			req.Locale = &locale
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	window, err := loadRegistrationWindow(ctx)
+	if err != nil && !errors.Is(err, examwindow.ErrNoSchedule) {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load registration window")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to register")
+	}
+	if window == nil || !window.Open {
+		var details fiber.Map
+		if window != nil {
+			details = fiber.Map{"opens_at": window.OpensAt, "closes_at": window.ClosesAt}
+		}
+		return apierror.Respond(c, fiber.StatusForbidden, apierror.CodeRegistrationClosed, "Registration is closed", details)
+	}
+
+	err = captcha.Verify(ctx, req.CaptchaToken, c.IP())
+	if errors.Is(err, captcha.ErrMissing) || errors.Is(err, captcha.ErrRejected) {
+		logging.Ctx(c).Warn().Err(err).Msg("Registration captcha rejected")
+		return apierror.Send(c, fiber.StatusBadRequest, "Captcha verification failed")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to verify captcha")
+		return apierror.Send(c, fiber.StatusServiceUnavailable, "Captcha could not be verified, please try again")
+	}
+
+	ttl := registrationTokenTTL()
+	accepted := fiber.Map{
+		"message":            "Check your email for a link to confirm your registration",
+		"expires_in_seconds": int(ttl.Seconds()),
+	}
+
+	var registered bool
+	existsQuery := `SELECT EXISTS (SELECT 1 FROM students WHERE LOWER(email) = LOWER($1))`
+	if err := db.Pool.QueryRow(ctx, existsQuery, req.Email).Scan(&registered); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check existing student")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to register")
+	}
+	if registered {
+		logging.Ctx(c).Info().Msg("Registration for an email that is already registered")
+		return c.Status(fiber.StatusAccepted).JSON(accepted)
+	}
+
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to generate registration token")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to register")
+	}
+	token := hex.EncodeToString(randomBytes)
+
+	insertQuery := `
+		INSERT INTO registrations (event_schedule_id, name, email, institution, country, phone, designation, timezone, locale,
+		                           token, expires_at, ip, user_agent)
+		VALUES ($1, $2, $3, NULLIF($4::text, ''), NULLIF($5::text, ''), NULLIF($6::text, ''), NULLIF($7::text, ''), NULLIF($8::text, ''), NULLIF($9::text, ''),
+		        $10, NOW() + make_interval(secs => $11), $12, $13)
+	`
+	locale := ""
+	if req.Locale != nil {
+		locale = *req.Locale
+	}
+	// The registration and the email with its link are stored together; the outbox relay sends it
+	err = db.WithTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, insertQuery, window.ScheduleID, req.Name, req.Email,
+			req.Institution, req.Country, req.Phone, req.Designation, req.Timezone, req.Locale,
+			token, ttl.Seconds(), c.IP(), c.Get(fiber.HeaderUserAgent))
+		if err != nil {
+			return err
+		}
+		return queueRegistrationVerification(ctx, tx, req.Name, req.Email, locale, config.FrontendURL()+"/register/verify?token="+token, ttl)
+	})
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to store registration")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to register")
+	}
+	outbox.Notify()
+
+	return c.Status(fiber.StatusAccepted).JSON(accepted)
+}
+
+// queueRegistrationVerification queues the verification link email in the registrant's locale,
+// unless the address is suppressed
+func queueRegistrationVerification(ctx context.Context, tx pgx.Tx, name, email, locale, link string, ttl time.Duration) error {
+	if suppression.IsSuppressed(ctx, email) {
+		log.Info().Str("email", email).Msg("Skipped registration verification to suppressed address")
+		return nil
+	}
+	subject, htmlBody := i18n.Localize(ctx, i18n.TemplateRegistration, locale,
+		"Confirm your registration: CoopQuest International Online Quiz", registrationVerificationHTML(name, link, ttl),
+		map[string]string{"name": name, "link": link, "hours": strconv.Itoa(int(ttl.Hours()))})
+	return outbox.Enqueue(ctx, tx, outbox.Message{
+		Source:   "registration",
+		ToEmail:  email,
+		ToName:   name,
+		Subject:  subject,
+		HTMLBody: htmlBody,
+	})
+}
+
+func registrationVerificationHTML(name, link string, ttl time.Duration) string {
+	return fmt.Sprintf(`
+		<div style="font-family: Arial, sans-serif; max-width: 700px; margin: 0 auto; padding: 20px;">
+			<h2 style="color: #2c3e50;">Confirm your registration</h2>
+
+			<p>Dear %s,</p>
+
+			<p>Thank you for registering for the <strong>International Online Quiz on Cooperatives</strong> hosted by the Natesan Institute of Cooperative Management (NICM), Chennai.</p>
+
+			<p>Please confirm your email address to complete your registration:</p>
+
+			<div style="background-color: #f8f9fa; padding: 15px; border-left: 4px solid #4CAF50; margin: 20px 0;">
+				<p style="margin: 5px 0;"><strong>🔗 <a href="%s" style="color: #4CAF50; font-weight: bold;">Click here to confirm your registration</a></strong></p>
+				<p style="margin: 5px 0;">This link is valid for %d hours.</p>
+			</div>
+
+			<p>If you did not register, you can ignore this email and no account will be created.</p>
+
+			<p>Warm regards,<br><strong>Natesan Institute of Cooperative Management (NICM)</strong><br>Chennai</p>
+		</div>
+	`, name, link, int(ttl.Hours()))
+}
+
+// VerifyRegistrationHandler handles POST /api/register/verify
+// Body: {"token": "..."}. Confirms a registration from its emailed link and creates the student.
+// Confirming again returns the same student.
+func VerifyRegistrationHandler(c *fiber.Ctx) error {
+	var req VerifyRegistrationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	req.Token = strings.TrimSpace(req.Token)
+	if req.Token == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Token is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to begin transaction")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to confirm registration")
+	}
+	defer tx.Rollback(ctx)
+
+	var registrationID int
+	var profile models.CreateStudentRequest
+	var studentID *int
+	var verified, expired bool
+	query := `
+		SELECT id, name, email, institution, country, phone, designation, timezone, locale,
+		       student_id, verified_at IS NOT NULL, expires_at <= NOW()
+		FROM registrations
+		WHERE token = $1
+		FOR UPDATE
+	`
+	err = tx.QueryRow(ctx, query, req.Token).Scan(&registrationID, &profile.Name, &profile.Email,
+		&profile.Institution, &profile.Country, &profile.Phone, &profile.Designation, &profile.Timezone, &profile.Locale,
+		&studentID, &verified, &expired)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierror.Send(c, fiber.StatusNotFound, "Invalid verification link")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load registration")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to confirm registration")
+	}
+
+	if verified {
+		return c.JSON(fiber.Map{"message": "Registration already confirmed", "student_id": studentID})
+	}
+	if expired {
+		return apierror.Send(c, fiber.StatusGone, "Verification link has expired, please register again")
+	}
+
+	// An existing student with the same address, in any letter case, is kept as is
+	var student models.Student
+	insertQuery := `
+		INSERT INTO students (name, email, institution, country, phone, designation, timezone, locale, email_checked_at, created_at, updated_at)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW(), NOW()
+		WHERE NOT EXISTS (SELECT 1 FROM students WHERE LOWER(email) = LOWER($2))
+		ON CONFLICT (email) DO NOTHING
+		RETURNING ` + studentColumns
+	err = scanStudent(tx.QueryRow(ctx, insertQuery, profile.Name, profile.Email,
+		profile.Institution, profile.Country, profile.Phone, profile.Designation, profile.Timezone, profile.Locale), &student)
+	created := err == nil
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Registered in the meantime, by another confirmed registration or an admin
+		err = scanStudent(tx.QueryRow(ctx, `SELECT `+studentColumns+` FROM students WHERE LOWER(email) = LOWER($1) ORDER BY id LIMIT 1`, profile.Email), &student)
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to create registered student")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to confirm registration")
+	}
+	logging.SetStudent(c, student.ID)
+
+	if _, err := tx.Exec(ctx, `UPDATE registrations SET verified_at = NOW(), student_id = $2 WHERE id = $1`, registrationID, student.ID); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to confirm registration")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to confirm registration")
+	}
+	if err := tx.Commit(ctx); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to commit registration")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to confirm registration")
+	}
+
+	if !created {
+		return c.JSON(fiber.Map{"message": "Registration already confirmed", "student_id": student.ID})
+	}
+	logging.Ctx(c).Info().Msg("Self-registered student created")
+	events.Publish(events.StudentCreated, student)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Registration confirmed", "student_id": student.ID})
+}
+
+// UpdateRegistrationWindowHandler handles PUT /api/event/schedule/registration
+// Body: {"opens_at": "2025-10-01T09:00:00", "closes_at": "2025-10-07T23:59:59"}, in the
+// schedule's timezone. Sets when the latest event accepts self-registrations.
+func UpdateRegistrationWindowHandler(c *fiber.Ctx) error {
+	var req UpdateRegistrationWindowRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	schedule, err := examwindow.Latest(ctx)
+	if errors.Is(err, examwindow.ErrNoSchedule) {
+		return apierror.Send(c, fiber.StatusNotFound, "No schedule found")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch schedule")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch schedule")
+	}
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load schedule timezone")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Server timezone error")
+	}
+
+	parse := func(name, value string) (*time.Time, error) {
+		if value == "" {
+			return nil, nil
+		}
+		parsed, err := time.ParseInLocation("2006-01-02T15:04:05", value, location)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s format. Use YYYY-MM-DDTHH:MM:SS in %s", name, schedule.Timezone)
+		}
+		return &parsed, nil
+	}
+	opensAt, err := parse("opens_at", req.OpensAt)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	closesAt, err := parse("closes_at", req.ClosesAt)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if opensAt == nil && closesAt != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "closes_at requires opens_at")
+	}
+	if opensAt != nil && closesAt != nil && !closesAt.After(*opensAt) {
+		return apierror.Send(c, fiber.StatusBadRequest, "closes_at must be after opens_at")
+	}
+
+	query := `UPDATE event_schedule SET registration_opens_at = $1, registration_closes_at = $2, updated_at = NOW() WHERE id = $3`
+	if _, err := db.Pool.Exec(ctx, query, opensAt, closesAt, schedule.ID); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to update registration window")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to update registration window")
+	}
+
+	window, err := loadRegistrationWindow(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load registration window")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to update registration window")
+	}
+	return c.JSON(fiber.Map{
+		"message":     "Registration window updated",
+		"schedule_id": window.ScheduleID,
+		"open":        window.Open,
+		"opens_at":    window.OpensAt,
+		"closes_at":   window.ClosesAt,
+		"timezone":    schedule.Timezone,
+	})
+}
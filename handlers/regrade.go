@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/scoring"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const maxRegradeReasonLength = 500
+
+type RegradeRequest struct {
+	Reason  string                  `json:"reason"`
+	Actions []scoring.RegradeAction `json:"actions"`
+	DryRun  bool                    `json:"dry_run"`
+}
+
+// RegradeHandler handles POST /api/admin/regrade
+// Body: {"reason": "Q12 key was wrong", "actions": [{"question_id": 12, "action": "change_key", "correct_options": [2]}]}
+// Recomputes is_correct for every answer to the listed questions and rescores the affected
+// completed sessions in one transaction; "dry_run": true reports the changes without saving them.
+func RegradeHandler(c *fiber.Ctx) error {
+	var req RegradeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "reason is required")
+	}
+	if len(req.Reason) > maxRegradeReasonLength {
+		return apierror.Send(c, fiber.StatusBadRequest, "reason must be at most 500 characters")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	result, err := scoring.Regrade(ctx, req.Actions, req.Reason, req.DryRun)
+	if errors.Is(err, scoring.ErrInvalidRegrade) {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to regrade")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to regrade")
+	}
+
+	message := "Regrade applied"
+	if result.DryRun {
+		message = "Dry run: nothing was saved"
+	} else {
+		logging.Ctx(c).Warn().Int("regrade_id", result.ID).Int("answers_changed", result.AnswersChanged).
+			Int("sessions_rescored", result.SessionsRescored).Msg("Regrade applied")
+	}
+
+	return c.JSON(fiber.Map{
+		"message": message,
+		"regrade": result,
+	})
+}
+
+// GetRegradesHandler handles GET /api/admin/regrades?limit=50
+func GetRegradesHandler(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	regrades, err := scoring.RecentRegrades(ctx, limit)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load regrades")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load regrades")
+	}
+
+	return c.JSON(fiber.Map{
+		"count":    len(regrades),
+		"regrades": regrades,
+	})
+}
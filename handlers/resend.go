@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/config"
+	"mcq-exam/db"
+	"mcq-exam/i18n"
+	"mcq-exam/jobs"
+	"mcq-exam/logging"
+	"mcq-exam/personalize"
+	"mcq-exam/suppression"
+	"mcq-exam/tracking"
+	"mcq-exam/utils"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Resend cohorts
+const (
+	// cohortNotOpened is students whose conference invitation was neither opened nor used
+	cohortNotOpened = "not_opened"
+	// cohortAttendedNotStarted is students who attended the conference but never started the test
+	cohortAttendedNotStarted = "attended_not_started"
+	// cohortCustom is the students listed in student_ids
+	cohortCustom = "custom"
+)
+
+// outcomeWouldSend is a dry run's recipient that a real resend would send to
+const outcomeWouldSend = "would_send"
+
+// errNotResendable is returned for built-in templates only their own flow can fill in
+var errNotResendable = errors.New("template cannot be resent")
+
+// maxResendStudentIDs bounds the student_ids of a custom cohort
+const maxResendStudentIDs = 5000
+
+// resendCohortFilters are the conditions each cohort adds to resendRecipientsQuery
+var resendCohortFilters = map[string]string{
+	cohortNotOpened: `et.student_id IS NOT NULL AND et.opened IS DISTINCT FROM true
+		AND et.conference_attended IS DISTINCT FROM true`,
+	cohortAttendedNotStarted: `et.conference_attended = true
+		AND NOT EXISTS (SELECT 1 FROM sessions ss WHERE ss.student_id = s.id)`,
+	cohortCustom: `s.id = ANY($1)`,
+}
+
+// resendRecipientsQuery lists a cohort's students with their conference token and their
+// access code while it still works. %s is the cohort's filter.
+const resendRecipientsQuery = `
+	SELECT s.id, s.name, s.email, COALESCE(s.locale, ''),
+	       CASE WHEN s.email_valid THEN '' ELSE COALESCE(s.email_invalid_reason, 'unknown') END,
+	       COALESCE(et.conference_token, ''),
+	       CASE WHEN et.access_code_invalidated_at IS NULL
+	             AND (et.access_code_expires_at IS NULL OR et.access_code_expires_at > NOW())
+	            THEN COALESCE(et.access_code, '') ELSE '' END
+	FROM students s
+	LEFT JOIN email_tracking et ON et.student_id = s.id AND et.email_type = 'firstMail'
+	WHERE s.is_sandbox = false AND (%s)
+	ORDER BY s.id
+`
+
+type ResendRequest struct {
+	Cohort string `json:"cohort"`
+	// StudentIDs are the students of the custom cohort
+	StudentIDs []int `json:"student_ids"`
+	// TemplateKey defaults to firstMail for not_opened and secondMail for attended_not_started
+	TemplateKey string `json:"template_key"`
+	// DryRun lists the recipients and counts without sending
+	DryRun bool `json:"dry_run"`
+}
+
+// ResendRecipient is a student of the cohort and what became of their email
+type ResendRecipient struct {
+	StudentID int    `json:"student_id"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+
+	locale, conferenceToken, accessCode, invalidReason string
+}
+
+// resendTemplate renders the chosen template for one recipient
+type resendTemplate struct {
+	key       string
+	emailType tracking.EmailType
+	// variants and renderer are set for stored templates other than the invitations
+	variants i18n.Variants
+	subject  string
+	htmlBody string
+	renderer *personalize.Renderer
+}
+
+// loadResendTemplate prepares a template key for a resend. The two invitations are sent as
+// the invitation flow sends them; the other built-in emails need data only their own flow
+// has, and any other key must have stored text, personalized as send-all does.
+func loadResendTemplate(ctx context.Context, key string) (*resendTemplate, error) {
+	switch key {
+	case i18n.TemplateFirstMail:
+		return &resendTemplate{key: key, emailType: tracking.FirstMail}, nil
+	case i18n.TemplateSecondMail:
+		return &resendTemplate{key: key, emailType: tracking.SecondMail}, nil
+	case i18n.TemplateRegistration, i18n.TemplateReminder, i18n.TemplateResults, i18n.TemplateEmailChange, i18n.TemplateEmailChangeNotice:
+		return nil, errNotResendable
+	}
+
+	variants, err := i18n.BuildVariants(ctx, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	t := &resendTemplate{key: key, emailType: tracking.EmailType(key), variants: variants}
+	// Students in a locale without a variant get the default locale's, else the first stored
+	base, ok := variants[i18n.Default()]
+	if !ok {
+		locales := make([]string, 0, len(variants))
+		for locale := range variants {
+			locales = append(locales, locale)
+		}
+		sort.Strings(locales)
+		base = variants[locales[0]]
+	}
+	t.subject, t.htmlBody = base.Subject, base.HTMLBody
+
+	texts := []string{}
+	for _, variant := range variants {
+		texts = append(texts, variant.Subject, variant.HTMLBody)
+	}
+	if t.renderer, err = personalize.New(ctx, false, texts...); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// skipReason is why the template cannot go to the recipient, or "" when it can
+func (t *resendTemplate) skipReason(ctx context.Context, r *ResendRecipient) string {
+	switch {
+	case r.invalidReason != "":
+		return "invalid email address: " + r.invalidReason
+	case t.emailType == tracking.FirstMail && r.conferenceToken == "":
+		return "no conference token"
+	case t.emailType == tracking.SecondMail && r.accessCode == "":
+		return "no usable access code"
+	case suppression.IsSuppressed(ctx, r.Email):
+		return "on the suppression list"
+	}
+	return ""
+}
+
+// render returns the recipient's subject and body
+func (t *resendTemplate) render(ctx context.Context, r *ResendRecipient) (string, string, error) {
+	switch t.emailType {
+	case tracking.FirstMail:
+		link := config.FrontendURL() + "/live?token=" + r.conferenceToken
+		subject, htmlBody := i18n.Localize(ctx, i18n.TemplateFirstMail, r.locale,
+			"Invitation: CoopQuest- An International Online Cooperative  Conclave", conferenceInvitationHTML(r.Name, link),
+			map[string]string{"name": r.Name, "link": link})
+		return subject, htmlBody, nil
+	case tracking.SecondMail:
+		link := config.FrontendURL() + "?otp=" + r.accessCode
+		subject, htmlBody := i18n.Localize(ctx, i18n.TemplateSecondMail, r.locale,
+			"Test Invitation - Your Access Code", testInvitationHTML(r.Name, link, r.accessCode),
+			map[string]string{"name": r.Name, "link": link, "access_code": r.accessCode})
+		return subject, htmlBody, nil
+	}
+	subject, htmlBody := i18n.Pick(t.variants, r.locale, t.subject, t.htmlBody)
+	rendered, err := t.renderer.Render(ctx, r.StudentID, r.Name, nil, subject, htmlBody)
+	if err != nil {
+		return "", "", err
+	}
+	return rendered[0], rendered[1], nil
+}
+
+// ResendHandler handles POST /api/mail/resend
+// Body: {"cohort": "not_opened" | "attended_not_started" | "custom", "student_ids": [1, 2],
+// "template_key": "secondMail", "dry_run": true}
+// Resends an email to a cohort of students, reusing their existing conference tokens and
+// access codes. Invalid, suppressed and sandbox addresses are never sent to, nor students
+// the template has no token or working code for. A dry run lists every recipient and the
+// counts without sending; a real one lists only the skipped and failed recipients.
+func ResendHandler(c *fiber.Ctx) error {
+	var req ResendRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	req.Cohort = strings.TrimSpace(req.Cohort)
+	req.TemplateKey = strings.TrimSpace(req.TemplateKey)
+
+	filter, ok := resendCohortFilters[req.Cohort]
+	if !ok {
+		return apierror.Send(c, fiber.StatusBadRequest, "cohort must be one of: not_opened, attended_not_started, custom")
+	}
+	if req.Cohort == cohortCustom {
+		if len(req.StudentIDs) == 0 || len(req.StudentIDs) > maxResendStudentIDs {
+			return apierror.Send(c, fiber.StatusBadRequest, "student_ids must list between 1 and 5000 students for the custom cohort")
+		}
+		if req.TemplateKey == "" {
+			return apierror.Send(c, fiber.StatusBadRequest, "template_key is required for the custom cohort")
+		}
+	} else if len(req.StudentIDs) > 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "student_ids is only used with the custom cohort")
+	}
+	if req.TemplateKey == "" {
+		req.TemplateKey = i18n.TemplateFirstMail
+		if req.Cohort == cohortAttendedNotStarted {
+			req.TemplateKey = i18n.TemplateSecondMail
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tmpl, err := loadResendTemplate(ctx, req.TemplateKey)
+	if errors.Is(err, errNotResendable) {
+		return apierror.Send(c, fiber.StatusBadRequest,
+			"template_key "+req.TemplateKey+" is only sent by its own flow and cannot be resent")
+	}
+	if err != nil {
+		return i18nError(c, err, "Failed to load template")
+	}
+
+	var args []any
+	if req.Cohort == cohortCustom {
+		args = append(args, req.StudentIDs)
+	}
+	rows, err := db.Pool.Query(ctx, fmt.Sprintf(resendRecipientsQuery, filter), args...)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Str("cohort", req.Cohort).Msg("Failed to fetch resend cohort")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch students")
+	}
+	defer rows.Close()
+
+	recipients := []ResendRecipient{}
+	found := make(map[int]bool)
+	for rows.Next() {
+		var r ResendRecipient
+		if err := rows.Scan(&r.StudentID, &r.Name, &r.Email, &r.locale, &r.invalidReason, &r.conferenceToken, &r.accessCode); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan resend cohort")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch students")
+		}
+		recipients = append(recipients, r)
+		found[r.StudentID] = true
+	}
+	if err := rows.Err(); err != nil {
+		logging.Ctx(c).Error().Err(err).Str("cohort", req.Cohort).Msg("Failed to fetch resend cohort")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch students")
+	}
+
+	// Listed students that do not exist or are sandbox students
+	notFound := []int{}
+	for _, id := range req.StudentIDs {
+		if !found[id] {
+			notFound = append(notFound, id)
+			found[id] = true
+		}
+	}
+
+	response := fiber.Map{
+		"cohort":       req.Cohort,
+		"template_key": req.TemplateKey,
+		"dry_run":      req.DryRun,
+		"total":        len(recipients),
+	}
+	if req.Cohort == cohortCustom {
+		response["not_found"] = notFound
+	}
+
+	if req.DryRun {
+		counts := map[string]int{outcomeWouldSend: 0, outcomeSkipped: 0}
+		for i := range recipients {
+			r := &recipients[i]
+			r.Status = outcomeWouldSend
+			if r.Reason = tmpl.skipReason(ctx, r); r.Reason != "" {
+				r.Status = outcomeSkipped
+			}
+			counts[r.Status]++
+		}
+		response["would_send"] = counts[outcomeWouldSend]
+		response["skipped"] = counts[outcomeSkipped]
+		response["recipients"] = recipients
+		return c.JSON(response)
+	}
+
+	defer jobs.Track()()
+	sent, skipped, failed := 0, 0, 0
+	outcomes := []ResendRecipient{}
+	interrupted := false
+	for i := range recipients {
+		if jobs.Context().Err() != nil {
+			interrupted = true
+			break
+		}
+		r := &recipients[i]
+		if r.Reason = tmpl.skipReason(jobs.Context(), r); r.Reason != "" {
+			r.Status = outcomeSkipped
+			skipped++
+			outcomes = append(outcomes, *r)
+			continue
+		}
+
+		subject, htmlBody, err := tmpl.render(jobs.Context(), r)
+		if err == nil {
+			_, err = utils.SendEmail(jobs.Context(), utils.SendEmailParams{
+				ToEmail:  r.Email,
+				ToName:   r.Name,
+				Subject:  subject,
+				HTMLBody: htmlBody,
+				Tracking: &tracking.Target{StudentID: r.StudentID, EmailType: tmpl.emailType},
+			})
+		}
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Int("student_id", r.StudentID).Str("template_key", tmpl.key).Msg("Failed to resend email")
+			r.Status, r.Reason = outcomeFailed, err.Error()
+			failed++
+			outcomes = append(outcomes, *r)
+			continue
+		}
+		tracking.RecordSent(r.StudentID, tmpl.emailType)
+		sent++
+	}
+
+	response["sent"] = sent
+	response["skipped"] = skipped
+	response["failed"] = failed
+	response["outcomes"] = outcomes
+	if interrupted {
+		response["interrupted"] = true
+		return apierror.Respond(c, fiber.StatusServiceUnavailable, apierror.CodeShuttingDown,
+			"Server is shutting down, sending stopped early", response)
+	}
+	logging.Ctx(c).Info().Str("cohort", req.Cohort).Str("template_key", tmpl.key).
+		Int("total", len(recipients)).Int("sent", sent).Int("skipped", skipped).Int("failed", failed).Msg("Resend finished")
+	return c.JSON(response)
+}
@@ -3,306 +3,373 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/attempts"
 	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/ranking"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// GetAllResultsHandler handles GET /api/results
-// Returns all completed test results ranked by score (DESC) then time (ASC)
+// GetAllResultsHandler handles GET /api/results?include_pii=true
+// Returns all completed test results ordered by the ranking policy,
+// one per student (the attempt counted by ATTEMPT_POLICY). Emails are masked unless
+// include_pii is set, which also adds names.
 func GetAllResultsHandler(c *fiber.Ctx) error {
+	includePII := false
+	if value := c.Query("include_pii"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "include_pii must be true or false")
+		}
+		includePII = parsed
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	policy := ranking.Current(ctx)
 	query := `
-		SELECT s.email, sess.score, sess.total_time_taken_seconds
-		FROM sessions sess
+		SELECT ` + policy.DenseRank("sess") + `, s.id, s.name, s.email, sess.score, sess.total_time_taken_seconds, sess.auto_completed
+		FROM ` + attempts.CountedSessions() + ` sess
 		JOIN students s ON sess.student_id = s.id
-		WHERE sess.completed = true
-		ORDER BY sess.score DESC, sess.total_time_taken_seconds ASC
+		ORDER BY ` + policy.OrderBy("sess") + `, s.id ASC
 	`
 
 	rows, err := db.Pool.Query(ctx, query)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch results"})
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch results")
 	}
 	defer rows.Close()
 
 	type StudentResult struct {
-		Email                 string `json:"email"`
-		Score                 int    `json:"score"`
-		TotalTimeTakenSeconds int    `json:"total_time_taken_seconds"`
+		Rank                  int     `json:"rank"`
+		StudentID             int     `json:"student_id"`
+		Name                  *string `json:"name,omitempty"`
+		Email                 string  `json:"email"`
+		Score                 int     `json:"score"`
+		TotalTimeTakenSeconds int     `json:"total_time_taken_seconds"`
+		AutoCompleted         bool    `json:"auto_completed"`
 	}
 
 	var results []StudentResult
 	for rows.Next() {
 		var result StudentResult
-		if err := rows.Scan(&result.Email, &result.Score, &result.TotalTimeTakenSeconds); err != nil {
+		var name string
+		if err := rows.Scan(&result.Rank, &result.StudentID, &name, &result.Email, &result.Score, &result.TotalTimeTakenSeconds, &result.AutoCompleted); err != nil {
 			continue
 		}
+		if includePII {
+			result.Name = &name
+		} else {
+			result.Email = maskEmail(result.Email)
+		}
 		results = append(results, result)
 	}
 
 	return c.JSON(fiber.Map{
-		"count":   len(results),
-		"results": results,
+		"count":       len(results),
+		"ranking":     policy.Criteria,
+		"include_pii": includePII,
+		"results":     results,
 	})
 }
 
-// GetComprehensiveStatsHandler handles GET /api/stats/comprehensive
-// Returns all statistics in a single response:
-// 1. Top 100 overall ranks
-// 2. Section-wise top 100 ranks (all 4 sections)
-// 3. Total attended conference
-// 4. Total completed vs incomplete users
-func GetComprehensiveStatsHandler(c *fiber.Ctx) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	response := fiber.Map{
-		"success": true,
-	}
-
-	// ============================================
-	// 1. TOP 100 OVERALL RANKS
-	// ============================================
-	overallQuery := `
-		SELECT
-			s.id,
-			s.name,
-			s.email,
-			COALESCE(sess.score, 0) as score,
-			COALESCE(sess.total_time_taken_seconds, 0) as total_time_taken_seconds
-		FROM students s
-		INNER JOIN sessions sess ON s.id = sess.student_id
-		WHERE sess.completed = true
-		ORDER BY sess.score DESC, sess.total_time_taken_seconds ASC
-		LIMIT 100
-	`
+// Blocks of GET /api/stats/comprehensive, selected with ?include=
+const (
+	statsBlockOverall   = "overall"
+	statsBlockSections  = "sections"
+	statsBlockAttendees = "attendees"
+	statsBlockFunnel    = "funnel"
+)
 
-	rows, err := db.Pool.Query(ctx, overallQuery)
-	if err != nil {
-		log.Printf("Failed to fetch overall leaderboard: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to fetch overall leaderboard",
-		})
-	}
+var statsBlocks = []string{statsBlockOverall, statsBlockSections, statsBlockAttendees, statsBlockFunnel}
 
-	type LeaderboardEntry struct {
-		Rank                  int    `json:"rank"`
-		StudentID             int    `json:"student_id"`
-		Name                  string `json:"name"`
-		Email                 string `json:"email"`
-		Score                 int    `json:"score"`
-		TotalTimeTakenSeconds int    `json:"total_time_taken_seconds"`
+// parseStatsInclude reads a comma-separated ?include= list; empty means every block
+func parseStatsInclude(value string) (map[string]bool, error) {
+	include := make(map[string]bool)
+	if strings.TrimSpace(value) == "" {
+		for _, block := range statsBlocks {
+			include[block] = true
+		}
+		return include, nil
 	}
-
-	overallLeaderboard := make([]LeaderboardEntry, 0)
-	rank := 1
-	for rows.Next() {
-		var entry LeaderboardEntry
-		if err := rows.Scan(&entry.StudentID, &entry.Name, &entry.Email, &entry.Score, &entry.TotalTimeTakenSeconds); err != nil {
-			log.Printf("Failed to scan row: %v", err)
-			continue
+	for _, block := range strings.Split(value, ",") {
+		block = strings.ToLower(strings.TrimSpace(block))
+		if !slices.Contains(statsBlocks, block) {
+			return nil, fmt.Errorf("include must be a comma-separated list of %s", strings.Join(statsBlocks, ", "))
 		}
-		entry.Rank = rank
-		overallLeaderboard = append(overallLeaderboard, entry)
-		rank++
+		include[block] = true
 	}
-	rows.Close()
-
-	response["top_100_overall"] = overallLeaderboard
-
-	// ============================================
-	// 2. SECTION-WISE TOP 100 RANKS (ALL 4 SECTIONS)
-	// ============================================
+	return include, nil
+}
 
-	// Load questions to get section info
-	questionsFile, err := os.ReadFile("questions_with_timer.json")
+// GetComprehensiveStatsHandler handles GET /api/stats/comprehensive?include=overall,sections&limit=100&offset=0
+// Returns the selected statistics blocks (all by default) in a single response:
+// 1. overall:   overall leaderboard
+// 2. sections:  section-wise leaderboards (all 4 sections)
+// 3. attendees: every attempt of every student who attended the test
+// 4. funnel:    participation funnel and completed vs incomplete users
+// limit (default 100, at most 1000) and offset page every list; each block reports its total.
+func GetComprehensiveStatsHandler(c *fiber.Ctx) error {
+	include, err := parseStatsInclude(c.Query("include"))
 	if err != nil {
-		log.Printf("Failed to read questions file: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to load questions",
-		})
-	}
-
-	type JSONQuestion struct {
-		ID int `json:"id"`
-	}
-	type JSONSection struct {
-		ID        int            `json:"id"`
-		Name      string         `json:"name"`
-		Questions []JSONQuestion `json:"questions"`
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
 	}
-	var sections []JSONSection
-
-	if err := json.Unmarshal(questionsFile, &sections); err != nil {
-		log.Printf("Failed to parse questions: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to parse questions",
-		})
+	limit := c.QueryInt("limit", 100)
+	offset := c.QueryInt("offset", 0)
+	if limit < 1 || limit > 1000 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Limit must be between 1 and 1000")
 	}
-
-	type SectionLeaderboardEntry struct {
-		Rank                    int    `json:"rank"`
-		StudentID               int    `json:"student_id"`
-		Name                    string `json:"name"`
-		Email                   string `json:"email"`
-		SectionScore            int    `json:"section_score"`
-		SectionTimeTakenSeconds int    `json:"section_time_taken_seconds"`
+	if offset < 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Offset must not be negative")
 	}
 
-	sectionLeaderboards := make(map[string]interface{})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	for _, section := range sections {
-		// Extract question IDs for this section
-		questionIDs := make([]int, len(section.Questions))
-		for i, q := range section.Questions {
-			questionIDs[i] = q.ID
-		}
+	response := fiber.Map{
+		"success": true,
+		"limit":   limit,
+		"offset":  offset,
+	}
 
-		// Query to calculate section scores and times
-		sectionQuery := `
-			WITH section_scores AS (
-				SELECT
-					sess.student_id,
-					COUNT(CASE WHEN a.is_correct = true THEN 1 END) as section_score,
-					COALESCE(SUM(a.time_taken_seconds), 0) as section_time_taken_seconds
-				FROM sessions sess
-				LEFT JOIN answers a ON sess.id = a.session_id
-				WHERE sess.completed = true
-				AND a.question_id = ANY($1)
-				GROUP BY sess.student_id
-			)
+	// ============================================
+	// 1. OVERALL LEADERBOARD
+	// ============================================
+	if include[statsBlockOverall] {
+		policy := ranking.Current(ctx)
+		overallQuery := `
 			SELECT
 				s.id,
 				s.name,
 				s.email,
-				COALESCE(sc.section_score, 0) as section_score,
-				COALESCE(sc.section_time_taken_seconds, 0) as section_time_taken_seconds
+				COALESCE(sess.score, 0) as score,
+				COALESCE(sess.total_time_taken_seconds, 0) as total_time_taken_seconds,
+				` + policy.DenseRank("sess") + ` as rank,
+				COUNT(*) OVER () as total,
+				sess.auto_completed
 			FROM students s
-			INNER JOIN section_scores sc ON s.id = sc.student_id
-			ORDER BY sc.section_score DESC, sc.section_time_taken_seconds ASC
-			LIMIT 100
+			INNER JOIN ` + attempts.CountedSessions() + ` sess ON s.id = sess.student_id
+			ORDER BY ` + policy.OrderBy("sess") + `, s.id ASC
+			LIMIT $1 OFFSET $2
 		`
 
-		sectionRows, err := db.Pool.Query(ctx, sectionQuery, questionIDs)
+		rows, err := db.Pool.Query(ctx, overallQuery, limit, offset)
 		if err != nil {
-			log.Printf("Failed to fetch section %d leaderboard: %v", section.ID, err)
-			continue
+			logging.Ctx(c).Error().Err(err).Msg("Failed to fetch overall leaderboard")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch overall leaderboard")
 		}
 
-		sectionLeaderboard := make([]SectionLeaderboardEntry, 0)
-		sectionRank := 1
+		type LeaderboardEntry struct {
+			Rank                  int    `json:"rank"`
+			StudentID             int    `json:"student_id"`
+			Name                  string `json:"name"`
+			Email                 string `json:"email"`
+			Score                 int    `json:"score"`
+			TotalTimeTakenSeconds int    `json:"total_time_taken_seconds"`
+			AutoCompleted         bool   `json:"auto_completed"`
+		}
 
-		for sectionRows.Next() {
-			var entry SectionLeaderboardEntry
-			if err := sectionRows.Scan(&entry.StudentID, &entry.Name, &entry.Email, &entry.SectionScore, &entry.SectionTimeTakenSeconds); err != nil {
-				log.Printf("Failed to scan section row: %v", err)
+		overallLeaderboard := make([]LeaderboardEntry, 0)
+		overallTotal := 0
+		for rows.Next() {
+			var entry LeaderboardEntry
+			if err := rows.Scan(&entry.StudentID, &entry.Name, &entry.Email, &entry.Score, &entry.TotalTimeTakenSeconds, &entry.Rank, &overallTotal, &entry.AutoCompleted); err != nil {
+				logging.Ctx(c).Error().Err(err).Msg("Failed to scan row")
 				continue
 			}
-			entry.Rank = sectionRank
-			sectionLeaderboard = append(sectionLeaderboard, entry)
-			sectionRank++
+			overallLeaderboard = append(overallLeaderboard, entry)
 		}
-		sectionRows.Close()
+		rows.Close()
 
-		// Get total count for this section
-		countQuery := `
-			SELECT COUNT(DISTINCT sess.student_id)
-			FROM sessions sess
-			INNER JOIN answers a ON sess.id = a.session_id
-			WHERE sess.completed = true
-			AND a.question_id = ANY($1)
-		`
-		var sectionTotal int
-		err = db.Pool.QueryRow(ctx, countQuery, questionIDs).Scan(&sectionTotal)
-		if err != nil {
-			log.Printf("Failed to count section participants: %v", err)
-			sectionTotal = len(sectionLeaderboard)
+		// A page past the end has no rows to carry the total
+		if len(overallLeaderboard) == 0 && offset > 0 {
+			countQuery := `SELECT COUNT(*) FROM ` + attempts.CountedSessions() + ` sess`
+			if err := db.Pool.QueryRow(ctx, countQuery).Scan(&overallTotal); err != nil {
+				logging.Ctx(c).Error().Err(err).Msg("Failed to count ranked students")
+			}
 		}
 
-		sectionLeaderboards[section.Name] = fiber.Map{
-			"section_id":   section.ID,
-			"section_name": section.Name,
-			"total":        sectionTotal,
-			"top_100":      sectionLeaderboard,
-		}
+		response["top_100_overall"] = overallLeaderboard
+		response["overall_total"] = overallTotal
 	}
 
-	response["section_leaderboards"] = sectionLeaderboards
-
 	// ============================================
-	// 3. COMPLETE LIST OF ALL STUDENTS WHO ATTENDED THE TEST
+	// 2. SECTION-WISE LEADERBOARDS (ALL 4 SECTIONS)
 	// ============================================
+	if include[statsBlockSections] {
+		// Load questions to get section info
+		questionsFile, err := os.ReadFile("questions_with_timer.json")
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to read questions file")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+		}
 
-	type TestAttendee struct {
-		StudentID             int        `json:"student_id"`
-		Name                  string     `json:"name"`
-		Email                 string     `json:"email"`
-		StartedAt             time.Time  `json:"started_at"`
-		Completed             bool       `json:"completed"`
-		CompletedAt           *time.Time `json:"completed_at,omitempty"`
-		Score                 *int       `json:"score,omitempty"`
-		TotalTimeTakenSeconds *int       `json:"total_time_taken_seconds,omitempty"`
-	}
+		type JSONSection struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		}
+		var sections []JSONSection
 
-	// Get ALL students who attended the test (both completed and incomplete)
-	allAttendeesQuery := `
-		SELECT s.id, s.name, s.email, sess.started_at, sess.completed, sess.completed_at, sess.score, sess.total_time_taken_seconds
-		FROM sessions sess
-		INNER JOIN students s ON sess.student_id = s.id
-		ORDER BY s.name ASC
-	`
+		if err := json.Unmarshal(questionsFile, &sections); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to parse questions")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to parse questions")
+		}
 
-	allAttendeesRows, err := db.Pool.Query(ctx, allAttendeesQuery)
-	if err != nil {
-		log.Printf("Failed to fetch test attendees: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "Failed to fetch test attendees",
-		})
+		type SectionLeaderboardEntry struct {
+			Rank                    int    `json:"rank"`
+			StudentID               int    `json:"student_id"`
+			Name                    string `json:"name"`
+			Email                   string `json:"email"`
+			SectionScore            int    `json:"section_score"`
+			SectionTimeTakenSeconds int    `json:"section_time_taken_seconds"`
+			AutoCompleted           bool   `json:"auto_completed"`
+		}
+
+		sectionLeaderboards := make(map[string]interface{})
+
+		for _, section := range sections {
+			// Section results are stored at finalization under the scoring scheme; ranks
+			// and the total cover every participant, not just the page
+			sectionQuery := `
+				SELECT s.id, s.name, s.email, sss.score, sss.time_taken_seconds, sess.auto_completed,
+				       ` + ranking.SectionDenseRank("sss") + ` as rank,
+				       COUNT(*) OVER () as total
+				FROM ` + attempts.CountedSessions() + ` sess
+				JOIN session_section_scores sss ON sss.session_id = sess.id AND sss.section_id = $1
+				JOIN students s ON s.id = sess.student_id
+				WHERE sss.questions_answered > 0
+				ORDER BY ` + ranking.SectionOrderBy("sss") + `, s.id ASC
+				LIMIT $2 OFFSET $3
+			`
+
+			sectionRows, err := db.Pool.Query(ctx, sectionQuery, section.ID, limit, offset)
+			if err != nil {
+				logging.Ctx(c).Error().Err(err).Int("section_id", section.ID).Msg("Failed to fetch section leaderboard")
+				continue
+			}
+
+			sectionLeaderboard := make([]SectionLeaderboardEntry, 0)
+			sectionTotal := 0
+
+			for sectionRows.Next() {
+				var entry SectionLeaderboardEntry
+				if err := sectionRows.Scan(&entry.StudentID, &entry.Name, &entry.Email, &entry.SectionScore, &entry.SectionTimeTakenSeconds, &entry.AutoCompleted, &entry.Rank, &sectionTotal); err != nil {
+					logging.Ctx(c).Error().Err(err).Msg("Failed to scan section row")
+					continue
+				}
+				sectionLeaderboard = append(sectionLeaderboard, entry)
+			}
+			sectionRows.Close()
+
+			// A page past the end has no rows to carry the total
+			if len(sectionLeaderboard) == 0 && offset > 0 {
+				countQuery := `
+					SELECT COUNT(*)
+					FROM ` + attempts.CountedSessions() + ` sess
+					JOIN session_section_scores sss ON sss.session_id = sess.id AND sss.section_id = $1
+					WHERE sss.questions_answered > 0
+				`
+				if err := db.Pool.QueryRow(ctx, countQuery, section.ID).Scan(&sectionTotal); err != nil {
+					logging.Ctx(c).Error().Err(err).Msg("Failed to count section participants")
+				}
+			}
+
+			sectionLeaderboards[section.Name] = fiber.Map{
+				"section_id":   section.ID,
+				"section_name": section.Name,
+				"total":        sectionTotal,
+				"top_100":      sectionLeaderboard,
+			}
+		}
+
+		response["section_leaderboards"] = sectionLeaderboards
 	}
 
-	allAttendees := make([]TestAttendee, 0)
-	completedCount := 0
-	incompleteCount := 0
+	// ============================================
+	// 3. ALL STUDENTS WHO ATTENDED THE TEST
+	// ============================================
+	if include[statsBlockAttendees] {
+		type TestAttendee struct {
+			StudentID             int        `json:"student_id"`
+			Name                  string     `json:"name"`
+			Email                 string     `json:"email"`
+			AttemptNumber         int        `json:"attempt_number"`
+			StartedAt             time.Time  `json:"started_at"`
+			Completed             bool       `json:"completed"`
+			CompletedAt           *time.Time `json:"completed_at,omitempty"`
+			Score                 *int       `json:"score,omitempty"`
+			TotalTimeTakenSeconds *int       `json:"total_time_taken_seconds,omitempty"`
+			DisqualifiedAt        *time.Time `json:"disqualified_at,omitempty"`
+			AutoCompletedReason   *string    `json:"auto_completed_reason,omitempty"`
+		}
 
-	for allAttendeesRows.Next() {
-		var student TestAttendee
-		if err := allAttendeesRows.Scan(&student.StudentID, &student.Name, &student.Email, &student.StartedAt, &student.Completed, &student.CompletedAt, &student.Score, &student.TotalTimeTakenSeconds); err != nil {
-			log.Printf("Failed to scan test attendee: %v", err)
-			continue
+		// Students who attended the test (both completed and incomplete), one row per attempt
+		allAttendeesQuery := `
+			SELECT s.id, s.name, s.email, sess.attempt_number, sess.started_at, sess.completed, sess.completed_at, sess.score, sess.total_time_taken_seconds,
+			       sess.disqualified_at, sess.auto_completed_reason
+			FROM sessions sess
+			INNER JOIN students s ON sess.student_id = s.id
+			WHERE sess.is_sandbox = false
+			ORDER BY s.name ASC, s.id ASC, sess.attempt_number ASC
+			LIMIT $1 OFFSET $2
+		`
+
+		allAttendeesRows, err := db.Pool.Query(ctx, allAttendeesQuery, limit, offset)
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to fetch test attendees")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch test attendees")
 		}
-		allAttendees = append(allAttendees, student)
 
-		if student.Completed {
-			completedCount++
-		} else {
-			incompleteCount++
+		allAttendees := make([]TestAttendee, 0)
+		for allAttendeesRows.Next() {
+			var student TestAttendee
+			if err := allAttendeesRows.Scan(&student.StudentID, &student.Name, &student.Email, &student.AttemptNumber, &student.StartedAt, &student.Completed, &student.CompletedAt, &student.Score, &student.TotalTimeTakenSeconds, &student.DisqualifiedAt, &student.AutoCompletedReason); err != nil {
+				logging.Ctx(c).Error().Err(err).Msg("Failed to scan test attendee")
+				continue
+			}
+			allAttendees = append(allAttendees, student)
+		}
+		allAttendeesRows.Close()
+
+		var attemptsTotal int
+		if err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM sessions WHERE is_sandbox = false`).Scan(&attemptsTotal); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to count test attendees")
+			attemptsTotal = offset + len(allAttendees)
 		}
-	}
-	allAttendeesRows.Close()
 
-	response["test_attendees"] = fiber.Map{
-		"total":    len(allAttendees),
-		"students": allAttendees,
+		response["test_attendees"] = fiber.Map{
+			"total":    attemptsTotal,
+			"count":    len(allAttendees),
+			"students": allAttendees,
+		}
 	}
 
 	// ============================================
-	// 4. COMPLETION BREAKDOWN
+	// 4. FUNNEL AND COMPLETION BREAKDOWN
 	// ============================================
+	if include[statsBlockFunnel] {
+		funnel, err := loadDashboardFunnel(ctx)
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to fetch funnel")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch funnel")
+		}
 
-	response["completion_breakdown"] = fiber.Map{
-		"total_attended_test": len(allAttendees),
-		"total_completed":     completedCount,
-		"total_incomplete":    incompleteCount,
+		// Breakdown counts students, not attempts
+		response["funnel"] = funnel
+		response["completion_breakdown"] = fiber.Map{
+			"total_attended_test": funnel.StartedTest,
+			"total_completed":     funnel.CompletedTest,
+			"total_incomplete":    funnel.StartedTest - funnel.CompletedTest,
+		}
 	}
 
 	return c.Status(fiber.StatusOK).JSON(response)
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/attempts"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/questions"
+	"mcq-exam/ranking"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xuri/excelize/v2"
+)
+
+// exportRow is one student's line in the results export
+type exportRow struct {
+	Rank                   int
+	StudentID              int
+	Name                   string
+	Email                  string
+	Institution            string
+	Country                string
+	Designation            string
+	Score                  int
+	TotalTimeTakenSeconds  int
+	TotalQuestionsAnswered int
+	CorrectAnswers         int
+	AttemptNumber          int
+	StartedAt              *time.Time
+	CompletedAt            *time.Time
+	AutoCompletedReason    string
+	// Section results keyed by section ID
+	SectionScores map[int]int
+	SectionTimes  map[int]int
+}
+
+// exportColumn renders one or more spreadsheet columns from a row.
+// "section_scores" expands to a score and time column per section.
+type exportColumn struct {
+	Key     string
+	Headers []string
+	Values  func(row *exportRow) []string
+}
+
+// exportColumns builds the available columns, in default order, for the current question bank
+func exportColumns(sections []questions.Section) []exportColumn {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	sectionHeaders := make([]string, 0, len(sections)*2)
+	for _, section := range sections {
+		sectionHeaders = append(sectionHeaders, section.Name+" Score", section.Name+" Time (s)")
+	}
+
+	return []exportColumn{
+		{"rank", []string{"Rank"}, func(r *exportRow) []string { return []string{strconv.Itoa(r.Rank)} }},
+		{"student_id", []string{"Student ID"}, func(r *exportRow) []string { return []string{strconv.Itoa(r.StudentID)} }},
+		{"name", []string{"Name"}, func(r *exportRow) []string { return []string{r.Name} }},
+		{"email", []string{"Email"}, func(r *exportRow) []string { return []string{r.Email} }},
+		{"institution", []string{"Institution"}, func(r *exportRow) []string { return []string{r.Institution} }},
+		{"country", []string{"Country"}, func(r *exportRow) []string { return []string{r.Country} }},
+		{"designation", []string{"Designation"}, func(r *exportRow) []string { return []string{r.Designation} }},
+		{"section_scores", sectionHeaders, func(r *exportRow) []string {
+			values := make([]string, 0, len(sections)*2)
+			for _, section := range sections {
+				values = append(values, strconv.Itoa(r.SectionScores[section.ID]), strconv.Itoa(r.SectionTimes[section.ID]))
+			}
+			return values
+		}},
+		{"score", []string{"Total Score"}, func(r *exportRow) []string { return []string{strconv.Itoa(r.Score)} }},
+		{"total_time_taken_seconds", []string{"Total Time (s)"}, func(r *exportRow) []string { return []string{strconv.Itoa(r.TotalTimeTakenSeconds)} }},
+		{"total_questions_answered", []string{"Questions Answered"}, func(r *exportRow) []string { return []string{strconv.Itoa(r.TotalQuestionsAnswered)} }},
+		{"correct_answers", []string{"Correct"}, func(r *exportRow) []string { return []string{strconv.Itoa(r.CorrectAnswers)} }},
+		{"wrong_answers", []string{"Wrong"}, func(r *exportRow) []string {
+			return []string{strconv.Itoa(r.TotalQuestionsAnswered - r.CorrectAnswers)}
+		}},
+		{"attempt_number", []string{"Attempt"}, func(r *exportRow) []string { return []string{strconv.Itoa(r.AttemptNumber)} }},
+		{"started_at", []string{"Started At"}, func(r *exportRow) []string { return []string{formatTime(r.StartedAt)} }},
+		{"completed_at", []string{"Completed At"}, func(r *exportRow) []string { return []string{formatTime(r.CompletedAt)} }},
+		{"auto_completed", []string{"Auto-completed"}, func(r *exportRow) []string { return []string{r.AutoCompletedReason} }},
+	}
+}
+
+// selectExportColumns picks the requested columns (comma-separated keys) in the requested order
+func selectExportColumns(available []exportColumn, requested string) ([]exportColumn, error) {
+	if strings.TrimSpace(requested) == "" {
+		return available, nil
+	}
+
+	byKey := make(map[string]exportColumn, len(available))
+	keys := make([]string, 0, len(available))
+	for _, col := range available {
+		byKey[col.Key] = col
+		keys = append(keys, col.Key)
+	}
+
+	selected := make([]exportColumn, 0)
+	for _, key := range strings.Split(requested, ",") {
+		key = strings.TrimSpace(key)
+		col, exists := byKey[key]
+		if !exists {
+			return nil, fmt.Errorf("Unknown column '%s'. Valid columns: %s", key, strings.Join(keys, ", "))
+		}
+		selected = append(selected, col)
+	}
+
+	return selected, nil
+}
+
+// fetchExportRows loads each student's counted attempt ordered by the ranking policy, with section results
+func fetchExportRows(ctx context.Context) ([]*exportRow, error) {
+	policy := ranking.Current(ctx)
+	query := `
+		SELECT
+			` + policy.DenseRank("sess") + `,
+			s.id,
+			s.name,
+			s.email,
+			COALESCE(s.institution, ''),
+			COALESCE(s.country, ''),
+			COALESCE(s.designation, ''),
+			COALESCE(sess.score, 0),
+			COALESCE(sess.total_time_taken_seconds, 0),
+			ans.answered,
+			ans.correct,
+			sess.attempt_number,
+			sess.started_at,
+			sess.completed_at,
+			COALESCE(sess.auto_completed_reason, ''),
+			sess.id
+		FROM ` + attempts.CountedSessions() + ` sess
+		JOIN students s ON sess.student_id = s.id
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) AS answered, COUNT(*) FILTER (WHERE a.is_correct) AS correct
+			FROM answers a
+			WHERE a.session_id = sess.id
+		) ans
+		ORDER BY ` + policy.OrderBy("sess") + `, s.id ASC
+	`
+	rows, err := db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch results: %w", err)
+	}
+	defer rows.Close()
+
+	results := make([]*exportRow, 0)
+	bySession := make(map[int]*exportRow)
+	for rows.Next() {
+		row := &exportRow{SectionScores: map[int]int{}, SectionTimes: map[int]int{}}
+		var sessionID int
+		if err := rows.Scan(&row.Rank, &row.StudentID, &row.Name, &row.Email, &row.Institution, &row.Country, &row.Designation, &row.Score, &row.TotalTimeTakenSeconds,
+			&row.TotalQuestionsAnswered, &row.CorrectAnswers, &row.AttemptNumber, &row.StartedAt, &row.CompletedAt, &row.AutoCompletedReason, &sessionID); err != nil {
+			return nil, fmt.Errorf("failed to scan result: %w", err)
+		}
+		results = append(results, row)
+		bySession[sessionID] = row
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch results: %w", err)
+	}
+
+	sectionRows, err := db.Pool.Query(ctx, `SELECT session_id, section_id, score, time_taken_seconds FROM session_section_scores`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch section scores: %w", err)
+	}
+	defer sectionRows.Close()
+
+	for sectionRows.Next() {
+		var sessionID, sectionID, score, timeTaken int
+		if err := sectionRows.Scan(&sessionID, &sectionID, &score, &timeTaken); err != nil {
+			return nil, fmt.Errorf("failed to scan section score: %w", err)
+		}
+		if row, exists := bySession[sessionID]; exists {
+			row.SectionScores[sectionID] = score
+			row.SectionTimes[sectionID] = timeTaken
+		}
+	}
+
+	return results, sectionRows.Err()
+}
+
+// ExportResultsHandler handles GET /api/results/export?format=csv|xlsx&columns=rank,name,email,score
+// Downloads the ranked results of all completed sessions. Section columns come from
+// session_section_scores (see POST /api/admin/section-scores/rebuild for older sessions).
+func ExportResultsHandler(c *fiber.Ctx) error {
+	format := strings.ToLower(c.Query("format", "csv"))
+	if format != "csv" && format != "xlsx" {
+		return apierror.Send(c, fiber.StatusBadRequest, "format must be csv or xlsx")
+	}
+
+	sections, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+	}
+
+	columns, err := selectExportColumns(exportColumns(sections), c.Query("columns"))
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	results, err := fetchExportRows(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to export results")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch results")
+	}
+
+	headers := make([]string, 0)
+	for _, col := range columns {
+		headers = append(headers, col.Headers...)
+	}
+	record := func(row *exportRow) []string {
+		values := make([]string, 0, len(headers))
+		for _, col := range columns {
+			values = append(values, col.Values(row)...)
+		}
+		return values
+	}
+
+	// The body is streamed after the handler returns, so keep the logger rather than the context
+	logger := logging.Ctx(c)
+
+	filename := fmt.Sprintf("results-%s.%s", time.Now().Format("20060102-150405"), format)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	if format == "csv" {
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			writer := csv.NewWriter(w)
+			_ = writer.Write(headers)
+			for _, row := range results {
+				_ = writer.Write(record(row))
+			}
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				logger.Error().Err(err).Msg("Failed to write CSV export")
+			}
+		})
+		return nil
+	}
+
+	file, err := buildResultsWorkbook(headers, results, record)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to build XLSX export")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to build export")
+	}
+
+	c.Set(fiber.HeaderContentType, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer file.Close()
+		if err := file.Write(w); err != nil {
+			logger.Error().Err(err).Msg("Failed to write XLSX export")
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// buildResultsWorkbook writes the export into a single-sheet workbook using excelize's row streamer
+func buildResultsWorkbook(headers []string, results []*exportRow, record func(row *exportRow) []string) (*excelize.File, error) {
+	file := excelize.NewFile()
+	sheet := "Results"
+	if err := file.SetSheetName("Sheet1", sheet); err != nil {
+		return nil, err
+	}
+
+	stream, err := file.NewStreamWriter(sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	toCells := func(values []string) []interface{} {
+		cells := make([]interface{}, len(values))
+		for i, value := range values {
+			// Keep numbers numeric so the sheet can be sorted and summed
+			if n, err := strconv.Atoi(value); err == nil {
+				cells[i] = n
+			} else {
+				cells[i] = value
+			}
+		}
+		return cells
+	}
+
+	if err := stream.SetRow("A1", toCells(headers)); err != nil {
+		return nil, err
+	}
+	for i, row := range results {
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		if err := stream.SetRow(cell, toCells(record(row))); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := stream.Flush(); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/attempts"
+	"mcq-exam/campaigns"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/ranking"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// publicLeaderboardSize is how many top ranks a result lookup shows, with names masked
+const publicLeaderboardSize = 10
+
+type ResultLookupRequest struct {
+	Email      string `json:"email"`
+	AccessCode string `json:"access_code"`
+	// Token is the result token from the link in the results email, instead of email and code
+	Token string `json:"token"`
+}
+
+// PublicLeaderboardEntry is a leaderboard row without other participants' personal data
+type PublicLeaderboardEntry struct {
+	Rank                  int     `json:"rank"`
+	Name                  string  `json:"name"`
+	Country               *string `json:"country"`
+	Score                 int     `json:"score"`
+	TotalTimeTakenSeconds int     `json:"total_time_taken_seconds"`
+	// You marks the student who looked up the result
+	You bool `json:"you"`
+}
+
+// maskEmail keeps the first character of the local part and the domain: "j***@example.com"
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return "***"
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+// maskName reduces a name to its initials: "Jane Doe" becomes "J. D."
+func maskName(name string) string {
+	parts := strings.Fields(name)
+	initials := make([]string, 0, len(parts))
+	for _, part := range parts {
+		initials = append(initials, strings.ToUpper(string([]rune(part)[:1]))+".")
+	}
+	return strings.Join(initials, " ")
+}
+
+// studentByAccessCode returns the student whose email and exam access code match. Used and
+// expired codes still work here, since results come out after the exam; invalidated ones do not.
+func studentByAccessCode(ctx context.Context, email, accessCode string) (int, error) {
+	query := `
+		SELECT s.id
+		FROM students s
+		JOIN email_tracking et ON et.student_id = s.id AND et.email_type = 'firstMail'
+		WHERE LOWER(s.email) = LOWER($1)
+		  AND et.access_code = $2
+		  AND et.access_code_invalidated_at IS NULL
+	`
+	var studentID int
+	err := db.Pool.QueryRow(ctx, query, email, accessCode).Scan(&studentID)
+	return studentID, err
+}
+
+// LookupResultHandler handles POST /api/results/lookup
+// Body: {"email": "jane@example.com", "access_code": "AB12CD"} or {"token": "..."}
+// Public: returns the caller's own scorecard and the top of the leaderboard with other
+// participants reduced to initials
+func LookupResultHandler(c *fiber.Ctx) error {
+	var req ResultLookupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	req.AccessCode = strings.ToUpper(strings.TrimSpace(req.AccessCode))
+	req.Token = strings.TrimSpace(req.Token)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var studentID int
+	var err error
+	switch {
+	case req.Token != "":
+		studentID, err = campaigns.StudentByResultToken(ctx, req.Token)
+		if errors.Is(err, campaigns.ErrInvalidResultToken) {
+			return apierror.Send(c, fiber.StatusUnauthorized, "Invalid result link")
+		}
+	case req.Email != "" && req.AccessCode != "":
+		studentID, err = studentByAccessCode(ctx, req.Email, req.AccessCode)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return apierror.Send(c, fiber.StatusUnauthorized, "Invalid email or access code")
+		}
+	default:
+		return apierror.Send(c, fiber.StatusBadRequest, "Provide email and access_code, or token")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to look up result")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to look up result")
+	}
+	logging.SetStudent(c, studentID)
+
+	var name string
+	if err := db.Pool.QueryRow(ctx, `SELECT name FROM students WHERE id = $1`, studentID).Scan(&name); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch student")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to look up result")
+	}
+
+	card, err := campaigns.LoadScorecard(ctx, studentID)
+	if errors.Is(err, campaigns.ErrNoResult) {
+		return apierror.Send(c, fiber.StatusNotFound, "No completed test found")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load scorecard")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to look up result")
+	}
+
+	policy := ranking.Current(ctx)
+	query := `
+		SELECT ` + policy.DenseRank("sess") + `, s.id, s.name, s.country,
+		       COALESCE(sess.score, 0), COALESCE(sess.total_time_taken_seconds, 0)
+		FROM ` + attempts.CountedSessions() + ` sess
+		JOIN students s ON s.id = sess.student_id
+		ORDER BY ` + policy.OrderBy("sess") + `, s.id ASC
+		LIMIT $1
+	`
+	rows, err := db.Pool.Query(ctx, query, publicLeaderboardSize)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch leaderboard")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to look up result")
+	}
+	defer rows.Close()
+
+	leaderboard := []PublicLeaderboardEntry{}
+	for rows.Next() {
+		var entry PublicLeaderboardEntry
+		var id int
+		var entryName string
+		if err := rows.Scan(&entry.Rank, &id, &entryName, &entry.Country, &entry.Score, &entry.TotalTimeTakenSeconds); err != nil {
+			continue
+		}
+		entry.You = id == studentID
+		entry.Name = maskName(entryName)
+		if entry.You {
+			entry.Name = entryName
+		}
+		leaderboard = append(leaderboard, entry)
+	}
+
+	return c.JSON(fiber.Map{
+		"name":        name,
+		"result":      card,
+		"ranking":     policy.Criteria,
+		"leaderboard": leaderboard,
+	})
+}
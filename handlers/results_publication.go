@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/publication"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type UpdateResultsPublicationRequest struct {
+	// PublishedAt defaults to now
+	PublishedAt *time.Time `json:"published_at"`
+}
+
+// GetResultsPublicationHandler handles GET /api/admin/results-publication
+// Returns when results, with the answer key, are published to students
+func GetResultsPublicationHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	setting, err := publication.Load(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load results publication")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load results publication")
+	}
+	return c.JSON(setting)
+}
+
+// UpdateResultsPublicationHandler handles PUT /api/admin/results-publication
+// Body: {"published_at": "2025-03-01T12:00:00Z"}, or {} to publish now
+// Takes effect on every instance; a future time publishes results when it passes
+func UpdateResultsPublicationHandler(c *fiber.Ctx) error {
+	var req UpdateResultsPublicationRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body (published_at must be RFC 3339, e.g. 2025-03-01T12:00:00Z)")
+		}
+	}
+	at := time.Now()
+	if req.PublishedAt != nil {
+		at = *req.PublishedAt
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	setting, err := publication.Save(ctx, at)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to save results publication")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to save results publication")
+	}
+	logging.Ctx(c).Info().Time("published_at", *setting.PublishedAt).Msg("Results publication set")
+	return c.JSON(setting)
+}
+
+// ResetResultsPublicationHandler handles DELETE /api/admin/results-publication
+// Withdraws the publication so students see scores only again
+func ResetResultsPublicationHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	setting, err := publication.Reset(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to reset results publication")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to reset results publication")
+	}
+	logging.Ctx(c).Info().Msg("Results publication withdrawn")
+	return c.JSON(setting)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/retention"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type UpdateRetentionPoliciesRequest struct {
+	Policies retention.Policies `json:"policies"`
+}
+
+// retentionError maps retention errors to responses
+func retentionError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, retention.ErrInvalidPolicy):
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	case errors.Is(err, retention.ErrRunning):
+		return apierror.Send(c, fiber.StatusConflict, "A retention run is already in progress")
+	}
+	logging.Ctx(c).Error().Err(err).Msg(message)
+	return apierror.Send(c, fiber.StatusInternalServerError, message)
+}
+
+// GetRetentionPoliciesHandler handles GET /api/admin/retention/policies
+// Returns the policy of every data class; days 0 keeps the class indefinitely
+func GetRetentionPoliciesHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	setting, err := retention.Load(ctx)
+	if err != nil {
+		return retentionError(c, err, "Failed to load retention policies")
+	}
+	return c.JSON(setting)
+}
+
+// UpdateRetentionPoliciesHandler handles PUT /api/admin/retention/policies
+// Body: {"policies": {"students": {"days": 365, "action": "anonymize"}, "email_logs": {"days": 90, "action": "delete"}}}
+// Replaces every policy; classes left out are kept indefinitely
+func UpdateRetentionPoliciesHandler(c *fiber.Ctx) error {
+	var req UpdateRetentionPoliciesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if req.Policies == nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "policies is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	setting, err := retention.Save(ctx, req.Policies)
+	if err != nil {
+		return retentionError(c, err, "Failed to save retention policies")
+	}
+	logging.Ctx(c).Info().Interface("policies", setting.Policies).Msg("Retention policies updated")
+	return c.JSON(setting)
+}
+
+// PreviewRetentionHandler handles GET /api/admin/retention/preview
+// Dry run: reports what the policies would anonymize or delete now, changing nothing
+func PreviewRetentionHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	report, err := retention.Run(ctx, retention.Options{DryRun: true, Trigger: retention.TriggerManual})
+	if err != nil {
+		return retentionError(c, err, "Failed to preview retention policies")
+	}
+	return c.JSON(report)
+}
+
+// RunRetentionHandler handles POST /api/admin/retention/run
+// Applies the policies now and records the run; the response is the run's report
+func RunRetentionHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	report, err := retention.Run(ctx, retention.Options{
+		Trigger:   retention.TriggerManual,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		return retentionError(c, err, "Failed to apply retention policies")
+	}
+	logging.Ctx(c).Info().Int("run_id", report.RunID).Int("classes", len(report.Classes)).Msg("Retention policies applied")
+	return c.JSON(report)
+}
+
+// GetRetentionRunsHandler handles GET /api/admin/retention/runs?limit=50
+// Lists applied runs with what each removed, newest first
+func GetRetentionRunsHandler(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 500 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Limit must be between 1 and 500")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	runs, err := retention.Runs(ctx, limit)
+	if err != nil {
+		return retentionError(c, err, "Failed to fetch retention runs")
+	}
+
+	return c.JSON(fiber.Map{
+		"count": len(runs),
+		"runs":  runs,
+	})
+}
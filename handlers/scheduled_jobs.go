@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/scheduler"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ScheduledJobRequest struct {
+	Name           string          `json:"name"`
+	FunctionName   string          `json:"function_name"`
+	CronExpression string          `json:"cron_expression"`
+	RunAt          string          `json:"run_at"` // RFC3339, or YYYY-MM-DDTHH:MM:SS in IST
+	Payload        json.RawMessage `json:"payload"`
+	MaxRetries     *int            `json:"max_retries"`
+	Paused         bool            `json:"paused"`
+}
+
+// scheduledJobSpec is a validated ScheduledJobRequest
+type scheduledJobSpec struct {
+	cronExpression *string
+	runAt          *time.Time
+	nextRunAt      time.Time
+	payload        json.RawMessage
+	maxRetries     int
+}
+
+// validateScheduledJobRequest checks the function, schedule and retry settings and works out the first run time
+func validateScheduledJobRequest(req *ScheduledJobRequest) (*scheduledJobSpec, string) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, "name is required"
+	}
+
+	if !scheduler.IsRegistered(req.FunctionName) {
+		return nil, fmt.Sprintf("Unknown function '%s'. Valid functions: %s", req.FunctionName, strings.Join(scheduler.FunctionNames(), ", "))
+	}
+
+	spec := &scheduledJobSpec{payload: req.Payload, maxRetries: 3}
+
+	cronExpression := strings.TrimSpace(req.CronExpression)
+	runAt := strings.TrimSpace(req.RunAt)
+	switch {
+	case cronExpression != "" && runAt != "":
+		return nil, "Provide either cron_expression or run_at, not both"
+	case cronExpression != "":
+		next, err := scheduler.NextCronRun(cronExpression, time.Now())
+		if err != nil {
+			return nil, err.Error()
+		}
+		spec.cronExpression = &cronExpression
+		spec.nextRunAt = next
+	case runAt != "":
+		t, err := time.Parse(time.RFC3339, runAt)
+		if err != nil {
+			istLocation, locErr := time.LoadLocation("Asia/Kolkata")
+			if locErr != nil {
+				return nil, "Server timezone error"
+			}
+			t, err = time.ParseInLocation("2006-01-02T15:04:05", runAt, istLocation)
+		}
+		if err != nil {
+			return nil, "Invalid run_at format. Use RFC3339 or YYYY-MM-DDTHH:MM:SS in IST (e.g., 2025-10-05T15:30:00)"
+		}
+		spec.runAt = &t
+		spec.nextRunAt = t
+	default:
+		return nil, "cron_expression or run_at is required"
+	}
+
+	if len(spec.payload) == 0 || string(spec.payload) == "null" {
+		spec.payload = json.RawMessage(`{}`)
+	}
+
+	if req.MaxRetries != nil {
+		if *req.MaxRetries < 0 || *req.MaxRetries > 10 {
+			return nil, "max_retries must be between 0 and 10"
+		}
+		spec.maxRetries = *req.MaxRetries
+	}
+
+	return spec, ""
+}
+
+// CreateScheduledJobHandler handles POST /api/admin/jobs
+// Schedules a registered function once (run_at) or repeatedly (cron_expression)
+func CreateScheduledJobHandler(c *fiber.Ctx) error {
+	var req ScheduledJobRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	spec, msg := validateScheduledJobRequest(&req)
+	if msg != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, msg)
+	}
+
+	status := scheduler.JobStatusActive
+	if req.Paused {
+		status = scheduler.JobStatusPaused
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO scheduled_jobs (name, function_name, cron_expression, run_at, payload, status, next_run_at, max_retries)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING ` + scheduler.JobColumns
+	job, err := scheduler.ScanJob(db.Pool.QueryRow(ctx, query, req.Name, req.FunctionName, spec.cronExpression, spec.runAt,
+		spec.payload, status, spec.nextRunAt, spec.maxRetries))
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to create scheduled job")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to create scheduled job")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(job)
+}
+
+// GetScheduledJobsHandler handles GET /api/admin/jobs?status=active
+func GetScheduledJobsHandler(c *fiber.Ctx) error {
+	status := c.Query("status")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT ` + scheduler.JobColumns + `
+		FROM scheduled_jobs
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY id DESC
+	`
+	rows, err := db.Pool.Query(ctx, query, status)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch scheduled jobs")
+	}
+	defer rows.Close()
+
+	jobs := []*scheduler.Job{}
+	for rows.Next() {
+		job, err := scheduler.ScanJob(rows)
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	return c.JSON(fiber.Map{
+		"count":     len(jobs),
+		"jobs":      jobs,
+		"functions": scheduler.FunctionNames(),
+	})
+}
+
+// GetScheduledJobHandler handles GET /api/admin/jobs/:id
+// Returns the job with its 20 most recent runs
+func GetScheduledJobHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid job ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	job, err := scheduler.ScanJob(db.Pool.QueryRow(ctx, `SELECT `+scheduler.JobColumns+` FROM scheduled_jobs WHERE id = $1`, id))
+	if err != nil {
+		return apierror.Send(c, fiber.StatusNotFound, "Scheduled job not found")
+	}
+
+	query := `
+		SELECT id, function_name, status, total, processed, sent, error, started_at, finished_at
+		FROM job_runs
+		WHERE job_id = $1
+		ORDER BY id DESC
+		LIMIT 20
+	`
+	rows, err := db.Pool.Query(ctx, query, id)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch job runs")
+	}
+	defer rows.Close()
+
+	type JobRun struct {
+		ID           int        `json:"id"`
+		FunctionName string     `json:"function_name"`
+		Status       string     `json:"status"`
+		Total        int        `json:"total"`
+		Processed    int        `json:"processed"`
+		Sent         int        `json:"sent"`
+		Error        *string    `json:"error"`
+		StartedAt    time.Time  `json:"started_at"`
+		FinishedAt   *time.Time `json:"finished_at"`
+	}
+
+	runs := []JobRun{}
+	for rows.Next() {
+		var r JobRun
+		if err := rows.Scan(&r.ID, &r.FunctionName, &r.Status, &r.Total, &r.Processed, &r.Sent, &r.Error, &r.StartedAt, &r.FinishedAt); err != nil {
+			continue
+		}
+		runs = append(runs, r)
+	}
+
+	return c.JSON(fiber.Map{
+		"job":  job,
+		"runs": runs,
+	})
+}
+
+// UpdateScheduledJobHandler handles PUT /api/admin/jobs/:id
+// Replaces the job definition and reschedules it. Paused jobs stay paused; completed
+// and failed jobs become active again.
+func UpdateScheduledJobHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid job ID")
+	}
+
+	var req ScheduledJobRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	spec, msg := validateScheduledJobRequest(&req)
+	if msg != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, msg)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE scheduled_jobs
+		SET name = $1,
+		    function_name = $2,
+		    cron_expression = $3,
+		    run_at = $4,
+		    payload = $5,
+		    next_run_at = $6,
+		    max_retries = $7,
+		    status = CASE WHEN status = 'paused' OR $8 THEN 'paused' ELSE 'active' END,
+		    attempts = 0,
+		    updated_at = NOW()
+		WHERE id = $9
+		RETURNING ` + scheduler.JobColumns
+	job, err := scheduler.ScanJob(db.Pool.QueryRow(ctx, query, req.Name, req.FunctionName, spec.cronExpression, spec.runAt,
+		spec.payload, spec.nextRunAt, spec.maxRetries, req.Paused, id))
+	if err != nil {
+		return apierror.Send(c, fiber.StatusNotFound, "Scheduled job not found")
+	}
+
+	return c.JSON(job)
+}
+
+// DeleteScheduledJobHandler handles DELETE /api/admin/jobs/:id
+// Also deletes the job's run history
+func DeleteScheduledJobHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid job ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := db.Pool.Exec(ctx, `DELETE FROM scheduled_jobs WHERE id = $1`, id)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to delete scheduled job")
+	}
+
+	if result.RowsAffected() == 0 {
+		return apierror.Send(c, fiber.StatusNotFound, "Scheduled job not found")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// PauseScheduledJobHandler handles POST /api/admin/jobs/:id/pause
+// Stops an active job from being picked up. A run already in progress finishes.
+func PauseScheduledJobHandler(c *fiber.Ctx) error {
+	query := `
+		UPDATE scheduled_jobs
+		SET status = 'paused', updated_at = NOW()
+		WHERE id = $1 AND status = 'active'
+		RETURNING ` + scheduler.JobColumns
+	return transitionScheduledJob(c, query, "Only active jobs can be paused")
+}
+
+// ResumeScheduledJobHandler handles POST /api/admin/jobs/:id/resume
+// Reactivates a paused job. A one-shot job whose time passed while paused runs at the next check;
+// a cron job continues from its next occurrence.
+func ResumeScheduledJobHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid job ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	job, err := scheduler.ScanJob(db.Pool.QueryRow(ctx, `SELECT `+scheduler.JobColumns+` FROM scheduled_jobs WHERE id = $1`, id))
+	if err != nil {
+		return apierror.Send(c, fiber.StatusNotFound, "Scheduled job not found")
+	}
+
+	nextRunAt := job.NextRunAt
+	if job.CronExpression != nil {
+		next, err := scheduler.NextCronRun(*job.CronExpression, time.Now())
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+		}
+		nextRunAt = &next
+	}
+
+	query := `
+		UPDATE scheduled_jobs
+		SET status = 'active', next_run_at = COALESCE($2, NOW()), updated_at = NOW()
+		WHERE id = $1 AND status = 'paused'
+		RETURNING ` + scheduler.JobColumns
+	job, err = scheduler.ScanJob(db.Pool.QueryRow(ctx, query, id, nextRunAt))
+	if err != nil {
+		return apierror.Send(c, fiber.StatusConflict, "Only paused jobs can be resumed")
+	}
+
+	return c.JSON(job)
+}
+
+// RunScheduledJobHandler handles POST /api/admin/jobs/:id/run
+// Queues the job to run at the next scheduler check (within a minute), whatever its status.
+// The retry count is reset; a failed or interrupted run resumes where it stopped.
+func RunScheduledJobHandler(c *fiber.Ctx) error {
+	query := `
+		UPDATE scheduled_jobs
+		SET status = 'active', next_run_at = NOW(), attempts = 0, updated_at = NOW()
+		WHERE id = $1
+		RETURNING ` + scheduler.JobColumns
+	return transitionScheduledJob(c, query, "")
+}
+
+// transitionScheduledJob applies a status update to the job in the :id param.
+// If conflictMsg is set, no updated row means the job exists in the wrong state (409) rather than 404.
+func transitionScheduledJob(c *fiber.Ctx, query, conflictMsg string) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid job ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	job, err := scheduler.ScanJob(db.Pool.QueryRow(ctx, query, id))
+	if err == nil {
+		return c.JSON(job)
+	}
+
+	var exists bool
+	_ = db.Pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM scheduled_jobs WHERE id = $1)`, id).Scan(&exists)
+	if exists && conflictMsg != "" {
+		return apierror.Send(c, fiber.StatusConflict, conflictMsg)
+	}
+	return apierror.Send(c, fiber.StatusNotFound, "Scheduled job not found")
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/campaigns"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/scorecard"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetScorecardPDFHandler handles GET /api/results/scorecard?email=jane@example.com&token=...
+// Public: the token from the results email plus the student's email download their
+// scorecard as a PDF (score, rank, percentile, time and section results)
+func GetScorecardPDFHandler(c *fiber.Ctx) error {
+	email := strings.TrimSpace(c.Query("email"))
+	token := strings.TrimSpace(c.Query("token"))
+	if email == "" || token == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "email and token are required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	studentID, err := campaigns.StudentByResultToken(ctx, token)
+	if errors.Is(err, campaigns.ErrInvalidResultToken) {
+		return apierror.Send(c, fiber.StatusUnauthorized, "Invalid result link")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check result token")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to generate scorecard")
+	}
+
+	var name, studentEmail string
+	if err := db.Pool.QueryRow(ctx, `SELECT name, email FROM students WHERE id = $1`, studentID).Scan(&name, &studentEmail); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch student")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to generate scorecard")
+	}
+	// Same response as a bad token, so the endpoint does not confirm whose link it is
+	if !strings.EqualFold(email, strings.TrimSpace(studentEmail)) {
+		return apierror.Send(c, fiber.StatusUnauthorized, "Invalid result link")
+	}
+	logging.SetStudent(c, studentID)
+
+	card, err := campaigns.LoadScorecard(ctx, studentID)
+	if errors.Is(err, campaigns.ErrNoResult) {
+		return apierror.Send(c, fiber.StatusNotFound, "No completed test found")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load scorecard")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to generate scorecard")
+	}
+
+	pdf, err := scorecard.PDF(ctx, name, card)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to render scorecard")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to generate scorecard")
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="scorecard-%d.pdf"`, studentID))
+	c.Set(fiber.HeaderCacheControl, "private, no-store")
+	return c.Send(pdf)
+}
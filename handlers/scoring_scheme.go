@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/scoring"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type UpdateScoringSchemeRequest struct {
+	scoring.Scheme
+	DryRun bool `json:"dry_run"`
+}
+
+// GetScoringSchemeHandler handles GET /api/admin/scoring-scheme
+// Returns the marking used by end-session, regrades, results and leaderboards
+func GetScoringSchemeHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scheme, err := scoring.LoadScheme(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load scoring scheme")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load scoring scheme")
+	}
+	return c.JSON(fiber.Map{
+		"scheme":  scheme,
+		"default": scheme.UpdatedAt == nil,
+	})
+}
+
+// UpdateScoringSchemeHandler handles PUT /api/admin/scoring-scheme
+// Body: {"marks_per_question": 4, "negative_marks": 1, "unanswered_marks": 0,
+// "question_marks": {"12": 8}, "section_weights": {"4": 2}, "dry_run": false}
+// Replaces the scheme (omitted fields take their defaults) and rescores every completed
+// session in one transaction; "dry_run": true reports the effect without saving it.
+func UpdateScoringSchemeHandler(c *fiber.Ctx) error {
+	req := UpdateScoringSchemeRequest{Scheme: scoring.DefaultScheme()}
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	update, err := scoring.UpdateScheme(ctx, req.Scheme, req.DryRun)
+	if errors.Is(err, scoring.ErrInvalidScheme) {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to update scoring scheme")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to update scoring scheme")
+	}
+
+	message := "Scoring scheme updated"
+	if update.DryRun {
+		message = "Dry run: nothing was saved"
+	} else {
+		logging.Ctx(c).Warn().Interface("scheme", update.Scheme).Int("sessions_rescored", update.SessionsRescored).
+			Int("scores_changed", update.ScoresChanged).Msg("Scoring scheme updated")
+	}
+
+	return c.JSON(fiber.Map{
+		"message": message,
+		"update":  update,
+	})
+}
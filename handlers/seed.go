@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/seed"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SeedHandler handles POST /api/admin/seed
+// Body: {"students": 1000, "seed": 42}
+// Fills the database with fake students, email tracking and logs, and sessions (completed and
+// in progress). The same seed always generates the same students; students that exist already
+// are skipped. Only allowed with SEED_ENABLED=true, which is meant for staging.
+func SeedHandler(c *fiber.Ctx) error {
+	if !seed.Enabled() {
+		return apierror.Send(c, fiber.StatusForbidden, "Seeding is disabled; set SEED_ENABLED=true to allow it")
+	}
+	var opts seed.Options
+	if err := c.BodyParser(&opts); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	report, err := seed.Run(ctx, opts)
+	if errors.Is(err, seed.ErrInvalidOptions) {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to seed database")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to seed database")
+	}
+
+	logging.Ctx(c).Info().Int("students", report.Students).Int64("seed", report.Seed).Msg("Database seeded")
+	return c.JSON(fiber.Map{
+		"success": true,
+		"report":  report,
+	})
+}
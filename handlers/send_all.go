@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/i18n"
+	"mcq-exam/jobs"
+	"mcq-exam/logging"
+	"mcq-exam/personalize"
+	"mcq-exam/suppression"
+	"mcq-exam/tracking"
+	"mcq-exam/utils"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultSendConcurrency is the number of send-all workers when MAIL_SEND_CONCURRENCY is unset
+	defaultSendConcurrency = 8
+	// maxSendConcurrency bounds the concurrency of a send-all request
+	maxSendConcurrency = 50
+	// keptBroadcasts is how many finished send-alls stay available to the status endpoint
+	keptBroadcasts = 20
+	// broadcastStreamInterval is how often the progress stream checks for changes
+	broadcastStreamInterval = time.Second
+)
+
+// Send-all statuses
+const (
+	BroadcastRunning     = "running"
+	BroadcastCompleted   = "completed"
+	BroadcastInterrupted = "interrupted"
+)
+
+// Per-recipient outcomes of a send-all
+const (
+	outcomeSent    = "sent"
+	outcomeSkipped = "skipped"
+	outcomeFailed  = "failed"
+)
+
+type SendAllRequest struct {
+	Subject  string `json:"subject"`
+	HTMLBody string `json:"html_body"`
+	// Concurrency overrides MAIL_SEND_CONCURRENCY for this send (1-50)
+	Concurrency int `json:"concurrency"`
+	// Variants replace subject and html_body for students in their locale; TemplateKey
+	// starts from a stored template's variants
+	Variants    i18n.Variants `json:"variants"`
+	TemplateKey string        `json:"template_key"`
+	// Strict fails recipients whose email would go out with a variable left empty
+	Strict bool `json:"strict"`
+}
+
+type broadcastRecipient struct {
+	ID     int
+	Name   string
+	Email  string
+	Locale string
+	// InvalidReason is set when the address failed validation; the recipient is skipped
+	InvalidReason string
+}
+
+// BroadcastOutcome is a recipient that was not sent, with the reason
+type BroadcastOutcome struct {
+	StudentID int    `json:"student_id"`
+	Email     string `json:"email"`
+	Status    string `json:"status"`
+	Error     string `json:"error"`
+}
+
+// BroadcastStatus is the progress of a send-all
+type BroadcastStatus struct {
+	ID          int        `json:"id"`
+	Subject     string     `json:"subject"`
+	Status      string     `json:"status"`
+	Concurrency int        `json:"concurrency"`
+	Total       int        `json:"total"`
+	Processed   int        `json:"processed"`
+	Sent        int        `json:"sent"`
+	Skipped     int        `json:"skipped"`
+	Failed      int        `json:"failed"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	// Outcomes lists skipped and failed recipients; sent ones are only counted
+	Outcomes []BroadcastOutcome `json:"outcomes,omitempty"`
+}
+
+// broadcast is a send-all in progress or recently finished in this process
+type broadcast struct {
+	mu       sync.Mutex
+	status   BroadcastStatus
+	htmlBody string
+	variants i18n.Variants
+	renderer *personalize.Renderer
+}
+
+var (
+	broadcastsMu    sync.Mutex
+	broadcasts      = make(map[int]*broadcast)
+	lastBroadcastID int
+)
+
+// sendConcurrency is the number of send-all workers (MAIL_SEND_CONCURRENCY, default 8).
+// Every worker shares the provider's rate limiter, so this bounds parallel requests, not the rate.
+func sendConcurrency() int {
+	concurrency := defaultSendConcurrency
+	if value := strings.TrimSpace(os.Getenv("MAIL_SEND_CONCURRENCY")); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxSendConcurrency {
+			log.Warn().Msgf("Invalid MAIL_SEND_CONCURRENCY=%q, using %d", value, concurrency)
+		} else {
+			concurrency = parsed
+		}
+	}
+	return concurrency
+}
+
+// newBroadcast registers a send-all, dropping the oldest finished ones beyond keptBroadcasts
+func newBroadcast(subject, htmlBody string, variants i18n.Variants, renderer *personalize.Renderer, total, concurrency int) *broadcast {
+	broadcastsMu.Lock()
+	defer broadcastsMu.Unlock()
+
+	lastBroadcastID++
+	b := &broadcast{
+		htmlBody: htmlBody,
+		variants: variants,
+		renderer: renderer,
+		status: BroadcastStatus{
+			ID:          lastBroadcastID,
+			Subject:     subject,
+			Status:      BroadcastRunning,
+			Concurrency: concurrency,
+			Total:       total,
+			StartedAt:   time.Now(),
+		},
+	}
+	broadcasts[b.status.ID] = b
+
+	for id := range broadcasts {
+		if len(broadcasts) <= keptBroadcasts {
+			break
+		}
+		if id <= lastBroadcastID-keptBroadcasts && broadcasts[id].snapshot().Status != BroadcastRunning {
+			delete(broadcasts, id)
+		}
+	}
+	return b
+}
+
+func findBroadcast(id int) *broadcast {
+	broadcastsMu.Lock()
+	defer broadcastsMu.Unlock()
+	return broadcasts[id]
+}
+
+// snapshot copies the status so it can be encoded without holding the lock
+func (b *broadcast) snapshot() BroadcastStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := b.status
+	status.Outcomes = append([]BroadcastOutcome(nil), b.status.Outcomes...)
+	return status
+}
+
+func (b *broadcast) record(outcome BroadcastOutcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.status.Processed++
+	switch outcome.Status {
+	case outcomeSent:
+		b.status.Sent++
+		return
+	case outcomeSkipped:
+		b.status.Skipped++
+	default:
+		b.status.Failed++
+	}
+	b.status.Outcomes = append(b.status.Outcomes, outcome)
+}
+
+func (b *broadcast) finish(interrupted bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.status.FinishedAt = &now
+	b.status.Status = BroadcastCompleted
+	if interrupted {
+		b.status.Status = BroadcastInterrupted
+	}
+}
+
+// run sends to every recipient over a bounded pool of workers. On shutdown no new sends
+// start; the ones in flight finish and the broadcast is marked interrupted.
+func (b *broadcast) run(ctx context.Context, recipients []broadcastRecipient) {
+	subject := b.status.Subject
+	queue := make(chan broadcastRecipient)
+
+	var wg sync.WaitGroup
+	for i := 0; i < b.status.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for recipient := range queue {
+				b.record(sendBroadcastEmail(ctx, subject, b.htmlBody, b.variants, b.renderer, recipient))
+			}
+		}()
+	}
+
+dispatch:
+	for _, recipient := range recipients {
+		select {
+		case queue <- recipient:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	b.finish(ctx.Err() != nil)
+	status := b.snapshot()
+	log.Info().Int("broadcast_id", status.ID).Str("status", status.Status).Int("total", status.Total).
+		Int("sent", status.Sent).Int("skipped", status.Skipped).Int("failed", status.Failed).
+		Dur("duration", status.FinishedAt.Sub(status.StartedAt)).Msg("Send-all finished")
+}
+
+// sendBroadcastEmail sends the personalized email, in the recipient's locale where there is
+// a variant, to one recipient and logs it to email_logs
+func sendBroadcastEmail(ctx context.Context, subject, htmlBody string, variants i18n.Variants, renderer *personalize.Renderer, recipient broadcastRecipient) BroadcastOutcome {
+	outcome := BroadcastOutcome{StudentID: recipient.ID, Email: recipient.Email, Status: outcomeSent}
+
+	// Skip known-bad addresses
+	if suppression.IsSuppressed(ctx, recipient.Email) {
+		outcome.Status = outcomeSkipped
+		outcome.Error = "on the suppression list"
+		return outcome
+	}
+	if recipient.InvalidReason != "" {
+		outcome.Status = outcomeSkipped
+		outcome.Error = "invalid email address: " + recipient.InvalidReason
+		return outcome
+	}
+
+	subject, htmlBody = i18n.Pick(variants, recipient.Locale, subject, htmlBody)
+
+	// Personalize the subject and body with the student's variables
+	rendered, err := renderer.Render(ctx, recipient.ID, recipient.Name, nil, subject, htmlBody)
+	if err != nil {
+		outcome.Status = outcomeFailed
+		outcome.Error = err.Error()
+		if !errors.Is(err, personalize.ErrUnresolved) {
+			log.Error().Err(err).Int("student_id", recipient.ID).Msg("Failed to personalize broadcast email")
+		}
+		return outcome
+	}
+	subject, personalizedBody := rendered[0], rendered[1]
+	params := utils.SendEmailParams{
+		ToEmail:  recipient.Email,
+		ToName:   recipient.Name,
+		Subject:  subject,
+		HTMLBody: personalizedBody,
+		Tracking: &tracking.Target{StudentID: recipient.ID, EmailType: tracking.Broadcast},
+	}
+
+	// Emails are logged as "sent"; the webhook updates them to "bounced" if delivery fails
+	status := "sent"
+	var provider, requestID, responseCode, responseMessage, providerResponse *string
+
+	result, err := utils.SendEmail(ctx, params)
+	if err != nil {
+		outcome.Status = outcomeFailed
+		outcome.Error = err.Error()
+		status = "failed"
+		responseMessage = &outcome.Error
+		log.Error().Err(err).Int("student_id", recipient.ID).Str("email", recipient.Email).Msg("Failed to send broadcast email")
+	} else {
+		tracking.RecordSent(recipient.ID, tracking.Broadcast)
+		provider = &result.Provider
+		requestID = &result.RequestID
+		responseCode = &result.Code
+		responseMessage = &result.Message
+		if len(result.Raw) > 0 {
+			raw := string(result.Raw)
+			providerResponse = &raw
+		}
+	}
+
+	// Use a fresh context so the outcome is logged even while shutting down
+	logCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	logQuery := `
+		INSERT INTO email_logs (student_id, email, subject, status, request_id, response_code, response_message, provider, provider_response, sent_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+	`
+	if _, err := db.Pool.Exec(logCtx, logQuery, recipient.ID, recipient.Email, subject, status, requestID, responseCode, responseMessage, provider, providerResponse); err != nil {
+		log.Warn().Err(err).Int("student_id", recipient.ID).Msg("Failed to write email log")
+	}
+	return outcome
+}
+
+// SendAllEmailsHandler handles POST /api/mail/send-all
+// Body: {"subject": "...", "html_body": "<p>Dear {{name}}, ...</p>", "concurrency": 8,
+// "variants": {"fr": {"subject": "...", "html_body": "..."}}, "template_key": "newsletter"}
+// Starts sending personalized emails to all students in the background and returns the
+// send-all's status (202); follow it with GET /api/mail/send-all/:id or /:id/stream
+func SendAllEmailsHandler(c *fiber.Ctx) error {
+	var req SendAllRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	// Validate required fields
+	if strings.TrimSpace(req.Subject) == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "subject is required")
+	}
+	if strings.TrimSpace(req.HTMLBody) == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "html_body is required")
+	}
+	concurrency := req.Concurrency
+	if concurrency == 0 {
+		concurrency = sendConcurrency()
+	}
+	if concurrency < 1 || concurrency > maxSendConcurrency {
+		return apierror.Send(c, fiber.StatusBadRequest, fmt.Sprintf("concurrency must be between 1 and %d", maxSendConcurrency))
+	}
+
+	// Get all students from database
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	variants, err := i18n.BuildVariants(ctx, strings.TrimSpace(req.TemplateKey), req.Variants)
+	if err != nil {
+		return i18nError(c, err, "Failed to load template variants")
+	}
+
+	texts := []string{req.Subject, req.HTMLBody}
+	for _, variant := range variants {
+		texts = append(texts, variant.Subject, variant.HTMLBody)
+	}
+	// Send-alls have no custom variables, so a strict send could never fill these in
+	if unknown := personalize.Unknown(texts...); req.Strict && len(unknown) > 0 {
+		return apierror.Respond(c, fiber.StatusBadRequest, apierror.CodeBadRequest,
+			"Unknown variables in a strict send: {{"+strings.Join(unknown, "}}, {{")+"}}",
+			fiber.Map{"student_fields": personalize.StudentFields, "computed": personalize.Computed})
+	}
+	renderer, err := personalize.New(ctx, req.Strict, texts...)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to prepare send-all variables")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to prepare variables")
+	}
+
+	query := `
+		SELECT id, name, email, COALESCE(locale, ''),
+		       CASE WHEN email_valid THEN '' ELSE COALESCE(email_invalid_reason, 'unknown') END
+		FROM students
+		WHERE is_sandbox = false
+		ORDER BY id
+	`
+	rows, err := db.Pool.Query(ctx, query)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch students")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch students")
+	}
+	defer rows.Close()
+
+	var recipients []broadcastRecipient
+	for rows.Next() {
+		var recipient broadcastRecipient
+		if err := rows.Scan(&recipient.ID, &recipient.Name, &recipient.Email, &recipient.Locale, &recipient.InvalidReason); err != nil {
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to scan student")
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	if len(recipients) == 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "No students found in database")
+	}
+
+	b := newBroadcast(req.Subject, req.HTMLBody, variants, renderer, len(recipients), concurrency)
+	jobs.Go(func(jobCtx context.Context) {
+		b.run(jobCtx, recipients)
+	})
+
+	status := b.snapshot()
+	logging.Ctx(c).Info().Int("broadcast_id", status.ID).Int("total", status.Total).Int("concurrency", concurrency).Msg("Send-all started")
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message":    "Sending started",
+		"status_url": fmt.Sprintf("/api/mail/send-all/%d", status.ID),
+		"stream_url": fmt.Sprintf("/api/mail/send-all/%d/stream", status.ID),
+		"broadcast":  status,
+	})
+}
+
+// GetSendAllStatusHandler handles GET /api/mail/send-all/:id
+// Returns the progress of a send-all started on this instance
+func GetSendAllStatusHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid send-all ID")
+	}
+	b := findBroadcast(id)
+	if b == nil {
+		return apierror.Send(c, fiber.StatusNotFound, "Send-all not found")
+	}
+	return c.JSON(b.snapshot())
+}
+
+// StreamSendAllHandler handles GET /api/mail/send-all/:id/stream
+// Server-sent events: a "progress" event whenever the counts change and a final "done"
+// event with the full status, after which the stream closes
+func StreamSendAllHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid send-all ID")
+	}
+	b := findBroadcast(id)
+	if b == nil {
+		return apierror.Send(c, fiber.StatusNotFound, "Send-all not found")
+	}
+
+	setSSEHeaders(c)
+
+	shutdown := jobs.Context()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ticker := time.NewTicker(broadcastStreamInterval)
+		defer ticker.Stop()
+
+		lastProcessed := -1
+		for {
+			status := b.snapshot()
+			if status.Status != BroadcastRunning {
+				writeSSEEvent(w, "done", status)
+				return
+			}
+			if status.Processed != lastProcessed {
+				lastProcessed = status.Processed
+				// Progress events leave out the outcomes; they come with "done"
+				status.Outcomes = nil
+				if err := writeSSEEvent(w, "progress", status); err != nil {
+					// The client went away
+					return
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-shutdown.Done():
+				// The send stops too; report where it got to
+				writeSSEEvent(w, "progress", b.snapshot())
+				return
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/presence"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetSessionActivityHandler handles GET /api/admin/sessions/activity?stalled_after=120&state=stalled
+// Lists open sessions as active, stalled (no heartbeat or answer for stalled_after seconds,
+// default 120) or abandoned (flagged by the sweeper after SESSION_IDLE_MINUTES)
+func GetSessionActivityHandler(c *fiber.Ctx) error {
+	stalledAfter := c.QueryInt("stalled_after", 120)
+	if stalledAfter < 1 {
+		return apierror.Send(c, fiber.StatusBadRequest, "stalled_after must be a positive number of seconds")
+	}
+	state := c.Query("state")
+	if state != "" && state != presence.StateActive && state != presence.StateStalled && state != presence.StateAbandoned {
+		return apierror.Send(c, fiber.StatusBadRequest, "state must be active, stalled or abandoned")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sessions, err := presence.Activity(ctx, time.Duration(stalledAfter)*time.Second)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch session activity")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch session activity")
+	}
+
+	counts := map[string]int{presence.StateActive: 0, presence.StateStalled: 0, presence.StateAbandoned: 0}
+	filtered := make([]presence.SessionActivity, 0, len(sessions))
+	for _, session := range sessions {
+		counts[session.State]++
+		if state == "" || session.State == state {
+			filtered = append(filtered, session)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"counts":                counts,
+		"stalled_after_seconds": stalledAfter,
+		"idle_timeout_seconds":  int(presence.IdleAfter().Seconds()),
+		"auto_finalize":         presence.AutoFinalize(),
+		"count":                 len(filtered),
+		"sessions":              filtered,
+	})
+}
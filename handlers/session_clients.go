@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"mcq-exam/apierror"
+	"mcq-exam/clientaudit"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sessionHitsQuery lists every request of session $1 that carried an IP: its start, its OTP
+// verifications and its answer submissions
+const sessionHitsQuery = `
+	SELECT start_ip AS ip, start_user_agent AS user_agent, 'session_start' AS action, started_at AS created_at
+	FROM sessions WHERE id = $1 AND start_ip IS NOT NULL
+	UNION ALL
+	SELECT ip, user_agent, action, created_at
+	FROM client_events WHERE session_id = $1 AND ip IS NOT NULL
+	UNION ALL
+	SELECT ip, user_agent, 'answer', created_at
+	FROM answer_events WHERE session_id = $1 AND ip IS NOT NULL
+`
+
+type SessionIP struct {
+	IP           string         `json:"ip"`
+	Country      string         `json:"country,omitempty"`
+	FirstSeen    time.Time      `json:"first_seen"`
+	LastSeen     time.Time      `json:"last_seen"`
+	Requests     int            `json:"requests"`
+	ActionCounts map[string]int `json:"action_counts"`
+	UserAgents   []string       `json:"user_agents"`
+}
+
+type ClientEventRecord struct {
+	ID        int64     `json:"id"`
+	Action    string    `json:"action"`
+	SessionID *int      `json:"session_id"`
+	IP        *string   `json:"ip"`
+	UserAgent *string   `json:"user_agent"`
+	Country   *string   `json:"country"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type IPFlaggedSession struct {
+	SessionID     int        `json:"session_id"`
+	StudentID     int        `json:"student_id"`
+	Name          string     `json:"name"`
+	Email         string     `json:"email"`
+	AttemptNumber int        `json:"attempt_number"`
+	Completed     bool       `json:"completed"`
+	Score         *int       `json:"score"`
+	CompletedAt   *time.Time `json:"completed_at"`
+	DistinctIPs   int        `json:"distinct_ips"`
+}
+
+// GetSessionDetailHandler handles GET /api/admin/sessions/:session_id
+// Returns the session with every IP it was used from (start, OTP verifications, answers),
+// the student's conference-token and OTP verifications, whether the number of distinct
+// IPs reaches SESSION_IP_FLAG_THRESHOLD, and its disqualification and admin actions
+func GetSessionDetailHandler(c *fiber.Ctx) error {
+	sessionID, err := strconv.Atoi(c.Params("session_id"))
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid session ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var session IPFlaggedSession
+	var startedAt time.Time
+	var startIP, startUserAgent, startCountry, disqualifyReason, autoCompletedReason *string
+	var disqualifiedAt *time.Time
+	sessionQuery := `
+		SELECT s.id, s.student_id, st.name, st.email, s.attempt_number, s.completed, s.score, s.completed_at,
+		       s.started_at, s.start_ip, s.start_user_agent, s.start_country, s.disqualified_at, s.disqualify_reason,
+		       s.auto_completed_reason
+		FROM sessions s
+		JOIN students st ON st.id = s.student_id
+		WHERE s.id = $1
+	`
+	if err := db.Pool.QueryRow(ctx, sessionQuery, sessionID).Scan(&session.SessionID, &session.StudentID, &session.Name,
+		&session.Email, &session.AttemptNumber, &session.Completed, &session.Score, &session.CompletedAt,
+		&startedAt, &startIP, &startUserAgent, &startCountry, &disqualifiedAt, &disqualifyReason,
+		&autoCompletedReason); err != nil {
+		return apierror.Send(c, fiber.StatusNotFound, "Session not found")
+	}
+
+	ipQuery := `
+		WITH hits AS (` + sessionHitsQuery + `)
+		SELECT ip, MIN(first_at), MAX(last_at), SUM(n)::int, jsonb_object_agg(action, n),
+		       (SELECT COALESCE(array_agg(DISTINCT h.user_agent), '{}') FROM hits h
+		        WHERE h.ip = per_action.ip AND h.user_agent IS NOT NULL)
+		FROM (
+			SELECT ip, action, COUNT(*) AS n, MIN(created_at) AS first_at, MAX(created_at) AS last_at
+			FROM hits
+			GROUP BY ip, action
+		) per_action
+		GROUP BY ip
+		ORDER BY MIN(first_at) ASC
+	`
+	rows, err := db.Pool.Query(ctx, ipQuery, sessionID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("session_id", sessionID).Msg("Failed to fetch session IPs")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch session IPs")
+	}
+	defer rows.Close()
+
+	ips := []SessionIP{}
+	userAgents := make(map[string]bool)
+	for rows.Next() {
+		var ip SessionIP
+		if err := rows.Scan(&ip.IP, &ip.FirstSeen, &ip.LastSeen, &ip.Requests, &ip.ActionCounts, &ip.UserAgents); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan session IP")
+			continue
+		}
+		ip.Country = clientaudit.Country(ip.IP)
+		for _, userAgent := range ip.UserAgents {
+			userAgents[userAgent] = true
+		}
+		ips = append(ips, ip)
+	}
+	rows.Close()
+
+	eventQuery := `
+		SELECT id, action, session_id, ip, user_agent, country, created_at
+		FROM client_events
+		WHERE student_id = $1
+		ORDER BY created_at ASC, id ASC
+	`
+	eventRows, err := db.Pool.Query(ctx, eventQuery, session.StudentID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("session_id", sessionID).Msg("Failed to fetch client events")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch client events")
+	}
+	defer eventRows.Close()
+
+	events := []ClientEventRecord{}
+	for eventRows.Next() {
+		var e ClientEventRecord
+		if err := eventRows.Scan(&e.ID, &e.Action, &e.SessionID, &e.IP, &e.UserAgent, &e.Country, &e.CreatedAt); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan client event")
+			continue
+		}
+		events = append(events, e)
+	}
+
+	adminActions, err := loadSessionActions(ctx, sessionID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("session_id", sessionID).Msg("Failed to fetch session admin actions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch session admin actions")
+	}
+
+	session.DistinctIPs = len(ips)
+	threshold := clientaudit.IPFlagThreshold()
+	return c.JSON(fiber.Map{
+		"session":    session,
+		"started_at": startedAt,
+		"start": fiber.Map{
+			"ip":         startIP,
+			"user_agent": startUserAgent,
+			"country":    startCountry,
+		},
+		"distinct_user_agents":  len(userAgents),
+		"ip_flag_threshold":     threshold,
+		"flagged":               len(ips) >= threshold,
+		"ips":                   ips,
+		"student_events":        events,
+		"disqualified_at":       disqualifiedAt,
+		"disqualify_reason":     disqualifyReason,
+		"auto_completed":        autoCompletedReason != nil,
+		"auto_completed_reason": autoCompletedReason,
+		"admin_actions":         adminActions,
+	})
+}
+
+// GetIPFlaggedSessionsHandler handles GET /api/admin/sessions/ip-flagged?min_ips=3&completed=true
+// Lists sessions used from at least min_ips distinct IPs (default SESSION_IP_FLAG_THRESHOLD),
+// most IPs first
+func GetIPFlaggedSessionsHandler(c *fiber.Ctx) error {
+	minIPs := clientaudit.IPFlagThreshold()
+	if value := c.Query("min_ips"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 2 {
+			return apierror.Send(c, fiber.StatusBadRequest, "min_ips must be an integer of at least 2")
+		}
+		minIPs = parsed
+	}
+	completedOnly := c.QueryBool("completed", false)
+	limit := c.QueryInt("limit", 500)
+	if limit < 1 || limit > 5000 {
+		limit = 500
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT s.id, s.student_id, st.name, st.email, s.attempt_number, s.completed, s.score, s.completed_at, ips.n
+		FROM (
+			SELECT session_id, COUNT(DISTINCT ip)::int AS n
+			FROM (
+				SELECT id AS session_id, start_ip AS ip FROM sessions WHERE start_ip IS NOT NULL
+				UNION ALL
+				SELECT session_id, ip FROM client_events WHERE session_id IS NOT NULL AND ip IS NOT NULL
+				UNION ALL
+				SELECT session_id, ip FROM answer_events WHERE ip IS NOT NULL
+			) hits
+			GROUP BY session_id
+			HAVING COUNT(DISTINCT ip) >= $1
+		) ips
+		JOIN sessions s ON s.id = ips.session_id
+		JOIN students st ON st.id = s.student_id
+		WHERE NOT $2 OR s.completed = true
+		ORDER BY ips.n DESC, s.id ASC
+		LIMIT $3
+	`
+	rows, err := db.Pool.Query(ctx, query, minIPs, completedOnly, limit)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch IP-flagged sessions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch flagged sessions")
+	}
+	defer rows.Close()
+
+	sessions := []IPFlaggedSession{}
+	for rows.Next() {
+		var s IPFlaggedSession
+		if err := rows.Scan(&s.SessionID, &s.StudentID, &s.Name, &s.Email, &s.AttemptNumber, &s.Completed, &s.Score,
+			&s.CompletedAt, &s.DistinctIPs); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan flagged session")
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+
+	return c.JSON(fiber.Map{
+		"min_ips":  minIPs,
+		"count":    len(sessions),
+		"sessions": sessions,
+	})
+}
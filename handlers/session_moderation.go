@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/events"
+	"mcq-exam/livemetrics"
+	"mcq-exam/logging"
+	"mcq-exam/scoring"
+	"mcq-exam/sessioncache"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// Actions recorded in session_admin_actions
+const (
+	SessionActionForceEnd   = "force_end"
+	SessionActionDisqualify = "disqualify"
+	SessionActionReinstate  = "reinstate"
+)
+
+// maxModerationReason bounds the reason stored with an admin action
+const maxModerationReason = 1000
+
+type SessionModerationRequest struct {
+	Reason string `json:"reason"`
+}
+
+// SessionAdminAction is a session_admin_actions row
+type SessionAdminAction struct {
+	ID        int             `json:"id"`
+	Action    string          `json:"action"`
+	Reason    *string         `json:"reason"`
+	Details   json.RawMessage `json:"details"`
+	IP        *string         `json:"ip"`
+	UserAgent *string         `json:"user_agent"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// moderatedSession is what the moderation endpoints need to know about a session
+type moderatedSession struct {
+	ID             int
+	StudentID      int
+	Token          string
+	Completed      bool
+	DisqualifiedAt *time.Time
+}
+
+// parseModeration reads the session ID and the optional {"reason": "..."} body; the error
+// is the message for a 400 response
+func parseModeration(c *fiber.Ctx) (int, string, error) {
+	sessionID, err := strconv.Atoi(c.Params("session_id"))
+	if err != nil || sessionID < 1 {
+		return 0, "", errors.New("Invalid session ID")
+	}
+	var req SessionModerationRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return 0, "", errors.New("Invalid request body")
+		}
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if len(reason) > maxModerationReason {
+		return 0, "", errors.New("Reason must be at most 1000 characters")
+	}
+	return sessionID, reason, nil
+}
+
+func loadModeratedSession(ctx context.Context, sessionID int) (*moderatedSession, error) {
+	var s moderatedSession
+	err := db.Pool.QueryRow(ctx, `SELECT id, student_id, session_token, completed, disqualified_at FROM sessions WHERE id = $1`,
+		sessionID).Scan(&s.ID, &s.StudentID, &s.Token, &s.Completed, &s.DisqualifiedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// recordSessionAction appends to the session's admin audit trail
+func recordSessionAction(ctx context.Context, q db.Querier, c *fiber.Ctx, s *moderatedSession, action, reason string, details fiber.Map) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, `
+		INSERT INTO session_admin_actions (session_id, student_id, action, reason, details, ip, user_agent)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, NULLIF($6, ''), NULLIF($7, ''))
+	`, s.ID, s.StudentID, action, reason, detailsJSON, c.IP(), c.Get(fiber.HeaderUserAgent))
+	return err
+}
+
+// forceEnd finalizes an open session the way end-session does. It returns nil when the
+// candidate ended the session in the meantime.
+func forceEnd(ctx context.Context, c *fiber.Ctx, s *moderatedSession) (*scoring.Result, error) {
+	result, err := scoring.Finalize(ctx, s.ID)
+	if errors.Is(err, scoring.ErrAlreadyCompleted) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The section in progress ends with the test
+	if _, err := db.Pool.Exec(ctx, `UPDATE session_sections SET ended_at = NOW() WHERE session_id = $1 AND ended_at IS NULL`, s.ID); err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Failed to end open section")
+	}
+	sessioncache.Invalidate(ctx, s.Token)
+	livemetrics.RecordCompletion(s.ID)
+
+	events.Publish(events.SessionCompleted, fiber.Map{
+		"session_id":               s.ID,
+		"student_id":               s.StudentID,
+		"score":                    result.Score,
+		"total_time_taken_seconds": result.TotalTimeTaken,
+		"total_questions_answered": result.TotalQuestions,
+		"forced":                   true,
+	})
+	return result, nil
+}
+
+// ForceEndSessionHandler handles POST /api/admin/sessions/:session_id/force-end
+// Body (optional): {"reason": "Browser crashed, candidate cannot submit"}
+// Completes an open session with the answers saved so far, as if the candidate had ended it.
+func ForceEndSessionHandler(c *fiber.Ctx) error {
+	sessionID, reason, err := parseModeration(c)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	logging.SetSession(c, sessionID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, err := loadModeratedSession(ctx, sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierror.Send(c, fiber.StatusNotFound, "Session not found")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load session")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to end session")
+	}
+	logging.SetStudent(c, session.StudentID)
+	if session.Completed {
+		return apierror.SendCode(c, fiber.StatusConflict, apierror.CodeTestCompleted, "Test already completed")
+	}
+
+	result, err := forceEnd(ctx, c, session)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to force-end session")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to end session")
+	}
+	if result == nil {
+		return apierror.SendCode(c, fiber.StatusConflict, apierror.CodeTestCompleted, "Test already completed")
+	}
+
+	if err := recordSessionAction(ctx, db.Pool, c, session, SessionActionForceEnd, reason, fiber.Map{
+		"score":                    result.Score,
+		"total_time_taken_seconds": result.TotalTimeTaken,
+		"total_questions_answered": result.TotalQuestions,
+	}); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to record force-end")
+	}
+	logging.Ctx(c).Warn().Str("reason", reason).Int("score", result.Score).Msg("Session force-ended by admin")
+
+	return c.JSON(fiber.Map{
+		"success":    true,
+		"message":    "Session ended",
+		"session_id": sessionID,
+		"result":     result,
+	})
+}
+
+// DisqualifySessionHandler handles POST /api/admin/sessions/:session_id/disqualify
+// Body: {"reason": "Second device seen in proctoring"}
+// Excludes the session from leaderboards, results and result emails. An open session is
+// ended first so the candidate cannot keep answering.
+func DisqualifySessionHandler(c *fiber.Ctx) error {
+	sessionID, reason, err := parseModeration(c)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if reason == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Reason is required")
+	}
+	logging.SetSession(c, sessionID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, err := loadModeratedSession(ctx, sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierror.Send(c, fiber.StatusNotFound, "Session not found")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load session")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to disqualify session")
+	}
+	logging.SetStudent(c, session.StudentID)
+	if session.DisqualifiedAt != nil {
+		return apierror.Send(c, fiber.StatusConflict, "Session is already disqualified")
+	}
+
+	var ended *scoring.Result
+	if !session.Completed {
+		ended, err = forceEnd(ctx, c, session)
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to end session before disqualifying")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to disqualify session")
+		}
+	}
+
+	var disqualifiedAt time.Time
+	err = db.WithTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+			UPDATE sessions
+			SET disqualified_at = NOW(), disqualify_reason = $2, updated_at = NOW()
+			WHERE id = $1 AND disqualified_at IS NULL
+			RETURNING disqualified_at
+		`, sessionID, reason).Scan(&disqualifiedAt)
+		if err != nil {
+			return err
+		}
+		return recordSessionAction(ctx, tx, c, session, SessionActionDisqualify, reason, fiber.Map{
+			"ended":  ended != nil,
+			"result": ended,
+		})
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		// A concurrent request disqualified it first
+		return apierror.Send(c, fiber.StatusConflict, "Session is already disqualified")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to disqualify session")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to disqualify session")
+	}
+
+	events.Publish(events.SessionDisqualified, fiber.Map{
+		"session_id": sessionID,
+		"student_id": session.StudentID,
+		"reason":     reason,
+	})
+	logging.Ctx(c).Warn().Str("reason", reason).Bool("ended", ended != nil).Msg("Session disqualified by admin")
+
+	return c.JSON(fiber.Map{
+		"success":         true,
+		"message":         "Session disqualified",
+		"session_id":      sessionID,
+		"disqualified_at": disqualifiedAt,
+		"reason":          reason,
+		"ended":           ended != nil,
+		"result":          ended,
+	})
+}
+
+// ReinstateSessionHandler handles DELETE /api/admin/sessions/:session_id/disqualify
+// Body (optional): {"reason": "Proctoring flag was a false positive"}
+// Lifts a disqualification so the session counts again.
+func ReinstateSessionHandler(c *fiber.Ctx) error {
+	sessionID, reason, err := parseModeration(c)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	logging.SetSession(c, sessionID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, err := loadModeratedSession(ctx, sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierror.Send(c, fiber.StatusNotFound, "Session not found")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load session")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to reinstate session")
+	}
+	logging.SetStudent(c, session.StudentID)
+
+	var previousReason *string
+	err = db.WithTx(ctx, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+			UPDATE sessions s
+			SET disqualified_at = NULL, disqualify_reason = NULL, updated_at = NOW()
+			FROM (SELECT id, disqualify_reason FROM sessions WHERE id = $1 FOR UPDATE) old
+			WHERE s.id = old.id AND s.disqualified_at IS NOT NULL
+			RETURNING old.disqualify_reason
+		`, sessionID).Scan(&previousReason)
+		if err != nil {
+			return err
+		}
+		return recordSessionAction(ctx, tx, c, session, SessionActionReinstate, reason, fiber.Map{
+			"disqualify_reason": previousReason,
+		})
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierror.Send(c, fiber.StatusConflict, "Session is not disqualified")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to reinstate session")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to reinstate session")
+	}
+	logging.Ctx(c).Warn().Str("reason", reason).Msg("Session reinstated by admin")
+
+	return c.JSON(fiber.Map{
+		"success":    true,
+		"message":    "Session reinstated",
+		"session_id": sessionID,
+	})
+}
+
+// loadSessionActions lists a session's admin actions, oldest first
+func loadSessionActions(ctx context.Context, sessionID int) ([]SessionAdminAction, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, action, reason, COALESCE(details, 'null'::jsonb), ip, user_agent, created_at
+		FROM session_admin_actions
+		WHERE session_id = $1
+		ORDER BY created_at ASC, id ASC
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []SessionAdminAction{}
+	for rows.Next() {
+		var action SessionAdminAction
+		var details []byte
+		if err := rows.Scan(&action.ID, &action.Action, &action.Reason, &details, &action.IP, &action.UserAgent, &action.CreatedAt); err != nil {
+			return nil, err
+		}
+		action.Details = details
+		actions = append(actions, action)
+	}
+	return actions, rows.Err()
+}
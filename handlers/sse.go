@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// setSSEHeaders marks the response as a server-sent event stream
+func setSSEHeaders(c *fiber.Ctx) {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	// Tell nginx not to buffer the stream
+	c.Set("X-Accel-Buffering", "no")
+}
+
+// writeSSEEvent writes one server-sent event with v as its JSON data and flushes it to the
+// client; an error means the client went away
+func writeSSEEvent(w *bufio.Writer, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// writeSSEComment writes a server-sent event comment, which clients ignore
+func writeSSEComment(w *bufio.Writer, text string) error {
+	if _, err := fmt.Fprintf(w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	return w.Flush()
+}
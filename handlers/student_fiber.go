@@ -2,9 +2,20 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"mcq-exam/apierror"
 	"mcq-exam/db"
+	"mcq-exam/emailcheck"
+	"mcq-exam/events"
+	"mcq-exam/examwindow"
+	"mcq-exam/i18n"
 	"mcq-exam/models"
+	"mcq-exam/segments"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,40 +23,121 @@ import (
 	"github.com/jackc/pgx/v5"
 )
 
+// studentColumns is the column list every student query selects or returns, in scanStudent order
+const studentColumns = `id, name, email, institution, country, phone, designation, timezone, locale, is_sandbox, tags, created_at, updated_at, email_valid, email_invalid_reason, anonymized_at`
+
+// insertStudentQuery creates a student from the create request's fields; $9 is the
+// emailcheck reason the address is invalid, or empty. A taken email inserts nothing.
+const insertStudentQuery = `
+	INSERT INTO students (name, email, institution, country, phone, designation, timezone, locale,
+	                      email_valid, email_invalid_reason, email_checked_at, created_at, updated_at)
+	VALUES ($1, $2, NULLIF($3::text, ''), NULLIF($4::text, ''), NULLIF($5::text, ''), NULLIF($6::text, ''), NULLIF($7::text, ''), NULLIF($8::text, ''),
+	        $9::text = '', NULLIF($9::text, ''), NOW(), NOW(), NOW())
+	ON CONFLICT (email) DO NOTHING
+	RETURNING ` + studentColumns
+
+var (
+	countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+	phonePattern       = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{4,31}$`)
+)
+
+func scanStudent(row pgx.Row, student *models.Student) error {
+	return row.Scan(
+		&student.ID,
+		&student.Name,
+		&student.Email,
+		&student.Institution,
+		&student.Country,
+		&student.Phone,
+		&student.Designation,
+		&student.Timezone,
+		&student.Locale,
+		&student.IsSandbox,
+		&student.Tags,
+		&student.CreatedAt,
+		&student.UpdatedAt,
+		&student.EmailValid,
+		&student.EmailInvalidReason,
+		&student.AnonymizedAt,
+	)
+}
+
+// normalizeProfile trims the optional profile fields, uppercases the country code and
+// validates them. Empty strings are kept so an update can clear a field.
+func normalizeProfile(profile *models.StudentProfile) error {
+	for _, field := range []*string{profile.Institution, profile.Country, profile.Phone, profile.Designation, profile.Timezone, profile.Locale} {
+		if field != nil {
+			*field = strings.TrimSpace(*field)
+		}
+	}
+
+	if profile.Institution != nil && len(*profile.Institution) > 255 {
+		return errors.New("institution must be at most 255 characters")
+	}
+	if profile.Designation != nil && len(*profile.Designation) > 255 {
+		return errors.New("designation must be at most 255 characters")
+	}
+	if profile.Country != nil && *profile.Country != "" {
+		*profile.Country = strings.ToUpper(*profile.Country)
+		if !countryCodePattern.MatchString(*profile.Country) {
+			return errors.New("country must be a two-letter ISO 3166-1 code, e.g. IN")
+		}
+	}
+	if profile.Phone != nil && *profile.Phone != "" && !phonePattern.MatchString(*profile.Phone) {
+		return errors.New("phone must contain only digits, spaces, '-', '(' and ')', optionally starting with '+'")
+	}
+	if profile.Timezone != nil && *profile.Timezone != "" && examwindow.ValidTimezone(*profile.Timezone) != nil {
+		return errors.New("timezone must be an IANA timezone, e.g. Asia/Kolkata")
+	}
+	if profile.Locale != nil && *profile.Locale != "" {
+		locale, ok := i18n.Normalize(*profile.Locale)
+		if !ok {
+			return i18n.ErrUnsupportedLocale
+		}
+		*profile.Locale = locale
+	}
+	return nil
+}
+
 // CreateStudentFiber handles POST /api/students
 func CreateStudentFiber(c *fiber.Ctx) error {
 	var req models.CreateStudentRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	if strings.TrimSpace(req.Name) == "" || req.Email == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Name and email are required")
+	}
+	if err := normalizeProfile(&req.StudentProfile); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
 	}
 
-	if strings.TrimSpace(req.Name) == "" || strings.TrimSpace(req.Email) == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Name and email are required"})
+	// Bad syntax is refused; a domain that cannot receive email is stored as invalid
+	emailReason := emailcheck.Check(context.Background(), req.Email, emailcheck.MXEnabled())
+	if emailReason == emailcheck.ReasonSyntax {
+		return apierror.Send(c, fiber.StatusBadRequest, "Email address is not valid")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var student models.Student
-	query := `
-		INSERT INTO students (name, email, created_at, updated_at)
-		VALUES ($1, $2, NOW(), NOW())
-		RETURNING id, name, email, created_at, updated_at
-	`
-	err := db.Pool.QueryRow(ctx, query, req.Name, req.Email).Scan(
-		&student.ID,
-		&student.Name,
-		&student.Email,
-		&student.CreatedAt,
-		&student.UpdatedAt,
-	)
+	err := scanStudent(db.Pool.QueryRow(ctx, insertStudentQuery, req.Name, req.Email,
+		req.Institution, req.Country, req.Phone, req.Designation, req.Timezone, req.Locale, emailReason), &student)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierror.Send(c, fiber.StatusConflict, "Email already exists")
+	}
 	if err != nil {
 		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
-			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Email already exists"})
+			return apierror.Send(c, fiber.StatusConflict, "Email already exists")
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create student"})
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to create student")
 	}
 
+	events.Publish(events.StudentCreated, student)
+
 	return c.Status(fiber.StatusCreated).JSON(student)
 }
 
@@ -53,29 +145,104 @@ func CreateStudentFiber(c *fiber.Ctx) error {
 func GetStudentFiber(c *fiber.Ctx) error {
 	id, err := c.ParamsInt("id")
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid student ID"})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid student ID")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var student models.Student
-	query := `SELECT id, name, email, created_at, updated_at FROM students WHERE id = $1`
-	err = db.Pool.QueryRow(ctx, query, id).Scan(
-		&student.ID,
-		&student.Name,
-		&student.Email,
-		&student.CreatedAt,
-		&student.UpdatedAt,
-	)
+	query := `SELECT ` + studentColumns + ` FROM students WHERE id = $1`
+	err = scanStudent(db.Pool.QueryRow(ctx, query, id), &student)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Student not found"})
+		return apierror.Send(c, fiber.StatusNotFound, "Student not found")
 	}
 
 	return c.JSON(student)
 }
 
-// GetAllStudentsFiber handles GET /api/students?limit=10&offset=0
+// studentSorts are the orders GET /api/students accepts; a leading '-' sorts descending
+var studentSorts = map[string]string{
+	"id":         "s.id",
+	"name":       "LOWER(s.name)",
+	"created_at": "s.created_at",
+}
+
+// studentFields are the JSON fields of a student, which ?fields= picks from
+var studentFields = func() []string {
+	encoded, err := json.Marshal(models.Student{})
+	if err != nil {
+		panic(err)
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &all); err != nil {
+		panic(err)
+	}
+	fields := make([]string, 0, len(all))
+	for name := range all {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}()
+
+// parseStudentFields parses ?fields=name,email into the fields to return, id always first;
+// nil returns whole students
+func parseStudentFields(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	fields := []string{"id"}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "id" {
+			continue
+		}
+		if idx := sort.SearchStrings(studentFields, name); idx == len(studentFields) || studentFields[idx] != name {
+			return nil, fmt.Errorf("unknown field %q (valid: %s)", name, strings.Join(studentFields, ", "))
+		}
+		fields = append(fields, name)
+	}
+	return fields, nil
+}
+
+// projectStudent returns only the given fields of a student
+func projectStudent(student models.Student, fields []string) (map[string]json.RawMessage, error) {
+	encoded, err := json.Marshal(student)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &all); err != nil {
+		return nil, err
+	}
+	projected := make(map[string]json.RawMessage, len(fields))
+	for _, name := range fields {
+		projected[name] = all[name]
+	}
+	return projected, nil
+}
+
+// queryOptionalBool parses an optional true/false query parameter; nil when it is absent
+func queryOptionalBool(c *fiber.Ctx, key string) (*bool, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false", key)
+	}
+	return &parsed, nil
+}
+
+// GetAllStudentsFiber handles GET /api/students?limit=10&offset=0&search=asha&sort=-created_at&fields=name,email
+// Filters: country matches the code exactly; institution and designation match partially,
+// ignoring case; search matches name or email partially, ignoring case; has_session and
+// attended (true/false) keep students who have or have not started a session or attended
+// the conference. sort is id (default), name or created_at, descending with a leading '-'.
+// fields returns only the listed student fields, with id always included.
 func GetAllStudentsFiber(c *fiber.Ctx) error {
 	// Get limit and offset from query params (default: limit=100, offset=0)
 	limit := c.QueryInt("limit", 100)
@@ -83,38 +250,108 @@ func GetAllStudentsFiber(c *fiber.Ctx) error {
 
 	// Validate limit
 	if limit < 1 || limit > 1000 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Limit must be between 1 and 1000"})
+		return apierror.Send(c, fiber.StatusBadRequest, "Limit must be between 1 and 1000")
+	}
+
+	country := strings.ToUpper(strings.TrimSpace(c.Query("country")))
+	if country != "" && !countryCodePattern.MatchString(country) {
+		return apierror.Send(c, fiber.StatusBadRequest, "country must be a two-letter ISO 3166-1 code, e.g. IN")
+	}
+	institution := strings.TrimSpace(c.Query("institution"))
+	designation := strings.TrimSpace(c.Query("designation"))
+	search := strings.TrimSpace(c.Query("search"))
+
+	sortKey := strings.TrimSpace(c.Query("sort", "id"))
+	direction := "ASC"
+	if strings.HasPrefix(sortKey, "-") {
+		sortKey, direction = sortKey[1:], "DESC"
+	}
+	sortColumn, ok := studentSorts[sortKey]
+	if !ok {
+		return apierror.Send(c, fiber.StatusBadRequest, "sort must be id, name or created_at, with a leading '-' for descending")
+	}
+
+	fields, err := parseStudentFields(c.Query("fields"))
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	// has_session and attended are the segment flags started and attended
+	conditions := ""
+	for _, f := range []struct{ param, flag string }{{"has_session", "started"}, {"attended", "attended"}} {
+		value, err := queryOptionalBool(c, f.param)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+		}
+		if value == nil {
+			continue
+		}
+		condition := segments.Flag(f.flag)
+		if !*value {
+			condition = "NOT " + condition
+		}
+		conditions += "\n\t\t  AND " + condition
+	}
+
+	// filter takes the segment language, e.g. ?filter=tag=vip AND NOT attended
+	segment, err := segments.Compile(c.Query("filter"), 5)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	// Empty filters match every student
+	filter := `
+		WHERE ($1 = '' OR country = $1)
+		  AND ($2 = '' OR institution ILIKE '%' || $2 || '%')
+		  AND ($3 = '' OR designation ILIKE '%' || $3 || '%')
+		  AND ($4 = '' OR s.name ILIKE '%' || $4 || '%' OR s.email ILIKE '%' || $4 || '%')
+		  AND ` + segment.SQL + conditions + `
+	`
+	args := append([]any{country, institution, designation, search}, segment.Args...)
+
 	// Get total count
 	var totalCount int
-	countQuery := `SELECT COUNT(*) FROM students`
-	if err := db.Pool.QueryRow(ctx, countQuery).Scan(&totalCount); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get total count"})
+	countQuery := `SELECT COUNT(*) FROM students s` + filter
+	if err := db.Pool.QueryRow(ctx, countQuery, args...).Scan(&totalCount); err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to get total count")
 	}
 
-	// Get paginated results
-	query := `SELECT id, name, email, created_at, updated_at FROM students ORDER BY id LIMIT $1 OFFSET $2`
-	rows, err := db.Pool.Query(ctx, query, limit, offset)
+	// Get paginated results; id breaks ties so pages do not overlap
+	query := fmt.Sprintf(`SELECT %s FROM students s%sORDER BY %s %s, s.id %s LIMIT $%d OFFSET $%d`,
+		studentColumns, filter, sortColumn, direction, direction, len(args)+1, len(args)+2)
+	rows, err := db.Pool.Query(ctx, query, append(args, limit, offset)...)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch students"})
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch students")
 	}
 	defer rows.Close()
 
 	students := []models.Student{}
 	for rows.Next() {
 		var student models.Student
-		if err := rows.Scan(&student.ID, &student.Name, &student.Email, &student.CreatedAt, &student.UpdatedAt); err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to scan student"})
+		if err := scanStudent(rows, &student); err != nil {
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to scan student")
 		}
 		students = append(students, student)
 	}
 
+	var list any = students
+	if fields != nil {
+		projected := make([]map[string]json.RawMessage, 0, len(students))
+		for _, student := range students {
+			p, err := projectStudent(student, fields)
+			if err != nil {
+				return apierror.Send(c, fiber.StatusInternalServerError, "Failed to encode student")
+			}
+			projected = append(projected, p)
+		}
+		list = projected
+	}
+
 	return c.JSON(fiber.Map{
-		"students": students,
+		"students": list,
 		"total":    totalCount,
 		"limit":    limit,
 		"offset":   offset,
@@ -123,50 +360,39 @@ func GetAllStudentsFiber(c *fiber.Ctx) error {
 }
 
 // UpdateStudentFiber handles PUT /api/students/:id
+// Profile fields left out of the body keep their value; an empty string clears them.
+// Changing the email is recorded; see updateStudent.
 func UpdateStudentFiber(c *fiber.Ctx) error {
 	id, err := c.ParamsInt("id")
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid student ID"})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid student ID")
 	}
 
 	var req models.UpdateStudentRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
-	if strings.TrimSpace(req.Name) == "" || strings.TrimSpace(req.Email) == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Name and email are required"})
+	req.Name, req.Email = strings.TrimSpace(req.Name), strings.TrimSpace(req.Email)
+	if req.Name == "" || req.Email == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Name and email are required")
+	}
+	if err := normalizeProfile(&req.StudentProfile); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var student models.Student
-	query := `
-		UPDATE students
-		SET name = $1, email = $2, updated_at = NOW()
-		WHERE id = $3
-		RETURNING id, name, email, created_at, updated_at
-	`
-	err = db.Pool.QueryRow(ctx, query, req.Name, req.Email, id).Scan(
-		&student.ID,
-		&student.Name,
-		&student.Email,
-		&student.CreatedAt,
-		&student.UpdatedAt,
-	)
-	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Student not found"})
-	}
-
-	return c.JSON(student)
+	student, change, err := updateStudent(ctx, id, &req.Name, &req.Email, req.StudentProfile)
+	return updateStudentResponse(c, id, student, change, err)
 }
 
 // DeleteStudentFiber handles DELETE /api/students/:id
 func DeleteStudentFiber(c *fiber.Ctx) error {
 	id, err := c.ParamsInt("id")
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid student ID"})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid student ID")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -175,11 +401,11 @@ func DeleteStudentFiber(c *fiber.Ctx) error {
 	query := `DELETE FROM students WHERE id = $1`
 	result, err := db.Pool.Exec(ctx, query, id)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete student"})
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to delete student")
 	}
 
 	if result.RowsAffected() == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Student not found"})
+		return apierror.Send(c, fiber.StatusNotFound, "Student not found")
 	}
 
 	return c.SendStatus(fiber.StatusNoContent)
@@ -192,31 +418,50 @@ func BulkCreateStudentsFiber(c *fiber.Ctx) error {
 	}
 
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
 	if len(req.Students) == 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "No students provided"})
+		return apierror.Send(c, fiber.StatusBadRequest, "No students provided")
 	}
 
 	// Validate max limit for bulk upload
 	if len(req.Students) > 2000 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Maximum 2000 students allowed per bulk upload"})
+		return apierror.Send(c, fiber.StatusBadRequest, "Maximum 2000 students allowed per bulk upload")
 	}
 
 	// Validate all students
-	for i, student := range req.Students {
-		if strings.TrimSpace(student.Name) == "" || strings.TrimSpace(student.Email) == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("Student at index %d has invalid name or email", i)})
+	for i := range req.Students {
+		student := &req.Students[i]
+		student.Email = strings.TrimSpace(student.Email)
+		if strings.TrimSpace(student.Name) == "" || student.Email == "" {
+			return apierror.Send(c, fiber.StatusBadRequest, fmt.Sprintf("Student at index %d has invalid name or email", i))
+		}
+		if err := normalizeProfile(&student.StudentProfile); err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, fmt.Sprintf("Student at index %d: %s", i, err.Error()))
 		}
 	}
 
-	// Deduplicate emails within the request
+	// Rows with bad syntax are skipped and reported; the rest are inserted
+	var invalidEmails []fiber.Map
+	domains := make(map[string]string)
 	emailMap := make(map[string]models.CreateStudentRequest)
 	var duplicatesInRequest []string
 
-	for _, student := range req.Students {
-		email := strings.ToLower(strings.TrimSpace(student.Email))
+	for i, student := range req.Students {
+		if emailcheck.Syntax(student.Email) != "" {
+			invalidEmails = append(invalidEmails, fiber.Map{
+				"index":  i,
+				"name":   student.Name,
+				"email":  student.Email,
+				"reason": emailcheck.ReasonSyntax,
+			})
+			continue
+		}
+		domains[emailcheck.DomainOf(student.Email)] = ""
+
+		// Deduplicate emails within the request
+		email := strings.ToLower(student.Email)
 		if _, exists := emailMap[email]; exists {
 			duplicatesInRequest = append(duplicatesInRequest, fmt.Sprintf("%s (%s)", student.Name, student.Email))
 		} else {
@@ -230,33 +475,57 @@ func BulkCreateStudentsFiber(c *fiber.Ctx) error {
 		uniqueStudents = append(uniqueStudents, student)
 	}
 
+	// Each domain is looked up once; students on one that cannot receive email are inserted
+	// marked invalid
+	if emailcheck.MXEnabled() {
+		emailcheck.CheckDomains(context.Background(), domains)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	// Use batch insert for performance with ON CONFLICT DO NOTHING
 	batch := &pgx.Batch{}
 	for _, student := range uniqueStudents {
-		query := `INSERT INTO students (name, email, created_at, updated_at) VALUES ($1, $2, NOW(), NOW()) ON CONFLICT (email) DO NOTHING`
-		batch.Queue(query, student.Name, student.Email)
+		emailReason := domains[emailcheck.DomainOf(student.Email)]
+		batch.Queue(insertStudentQuery, student.Name, student.Email, student.Institution, student.Country, student.Phone,
+			student.Designation, student.Timezone, student.Locale, emailReason)
 	}
 
-	results := db.Pool.SendBatch(ctx, batch)
-	defer results.Close()
-
-	// Execute all batched queries
+	// The upload is all or nothing: a failed insert rolls back the students inserted before it
 	successCount := 0
 	skippedCount := 0
-	for i := range uniqueStudents {
-		cmdTag, err := results.Exec()
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fmt.Sprintf("Failed to insert student at index %d: %s", i, err.Error())})
-		}
-		// Check rows affected - 0 means skipped due to conflict
-		if cmdTag.RowsAffected() == 0 {
-			skippedCount++
-		} else {
+	flaggedCount := 0
+	var created []models.Student
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		results := tx.SendBatch(ctx, batch)
+		defer results.Close()
+
+		for i := range uniqueStudents {
+			var student models.Student
+			err := scanStudent(results.QueryRow(), &student)
+			// No row returned means skipped due to conflict
+			if errors.Is(err, pgx.ErrNoRows) {
+				skippedCount++
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("Failed to insert student at index %d: %s", i, err.Error())
+			}
 			successCount++
+			if !student.EmailValid {
+				flaggedCount++
+			}
+			created = append(created, student)
 		}
+		return results.Close()
+	})
+	if err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	for _, student := range created {
+		events.Publish(events.StudentCreated, student)
 	}
 
 	// Prepare response
@@ -267,13 +536,18 @@ func BulkCreateStudentsFiber(c *fiber.Ctx) error {
 		"unique_emails":            len(uniqueStudents),
 		"successfully_inserted":    successCount,
 		"already_exists_skipped":   skippedCount,
+		"invalid_emails_skipped":   len(invalidEmails),
+		"inserted_email_invalid":   flaggedCount,
 	}
 
 	if len(duplicatesInRequest) > 0 {
 		response["duplicate_emails_in_request"] = duplicatesInRequest
 	}
+	if len(invalidEmails) > 0 {
+		response["invalid_emails"] = invalidEmails
+	}
 
-	if skippedCount > 0 || len(duplicatesInRequest) > 0 {
+	if skippedCount > 0 || len(duplicatesInRequest) > 0 || len(invalidEmails) > 0 {
 		return c.Status(fiber.StatusPartialContent).JSON(response)
 	}
 
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/events"
+	"mcq-exam/logging"
+	"mcq-exam/studentmerge"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type MergeStudentsRequest struct {
+	KeepID  int `json:"keep_id"`
+	MergeID int `json:"merge_id"`
+	// Email is the address to keep, either student's; empty keeps keep_id's
+	Email  string `json:"email"`
+	Reason string `json:"reason"`
+}
+
+// studentMergeError maps studentmerge errors to responses
+func studentMergeError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, studentmerge.ErrNotFound):
+		return apierror.Send(c, fiber.StatusNotFound, "Student not found")
+	case errors.Is(err, studentmerge.ErrSameStudent), errors.Is(err, studentmerge.ErrEmail):
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	case errors.Is(err, studentmerge.ErrSandbox), errors.Is(err, studentmerge.ErrSessionOpen):
+		return apierror.Send(c, fiber.StatusConflict, err.Error())
+	}
+	logging.Ctx(c).Error().Err(err).Msg(message)
+	return apierror.Send(c, fiber.StatusInternalServerError, message)
+}
+
+// MergeStudentsHandler handles POST /api/admin/students/merge
+// Body: {"keep_id": 12, "merge_id": 34, "email": "asha@example.com", "reason": "Registered twice"}
+// Moves everything merge_id owns to keep_id, deletes merge_id and records the merge; see
+// package studentmerge for how conflicts are resolved
+func MergeStudentsHandler(c *fiber.Ctx) error {
+	var req MergeStudentsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if req.KeepID < 1 || req.MergeID < 1 {
+		return apierror.Send(c, fiber.StatusBadRequest, "keep_id and merge_id are required")
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if len(reason) > maxModerationReason {
+		return apierror.Send(c, fiber.StatusBadRequest, "Reason must be at most 1000 characters")
+	}
+	logging.SetStudent(c, req.KeepID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	merge, err := studentmerge.Students(ctx, studentmerge.Request{
+		KeepID:    req.KeepID,
+		MergeID:   req.MergeID,
+		Email:     strings.TrimSpace(req.Email),
+		Reason:    reason,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		return studentMergeError(c, err, "Failed to merge students")
+	}
+	logging.Ctx(c).Info().Int("merged_student_id", req.MergeID).Str("final_email", merge.FinalEmail).Msg("Students merged")
+
+	events.Publish(events.StudentMerged, fiber.Map{
+		"student_id":        req.KeepID,
+		"merged_student_id": req.MergeID,
+		"email":             merge.FinalEmail,
+		"merged_email":      merge.MergedEmail,
+	})
+
+	return c.JSON(merge)
+}
+
+// GetStudentMergesHandler handles GET /api/admin/students/merges?student_id=12&limit=50
+// Lists recorded merges, newest first
+func GetStudentMergesHandler(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 500 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Limit must be between 1 and 500")
+	}
+	studentID := c.QueryInt("student_id", 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	merges, err := studentmerge.List(ctx, studentID, limit)
+	if err != nil {
+		return studentMergeError(c, err, "Failed to fetch merges")
+	}
+
+	return c.JSON(fiber.Map{
+		"count":  len(merges),
+		"merges": merges,
+	})
+}
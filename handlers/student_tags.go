@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/segments"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxTagsPerRequest bounds the tags one request adds or removes
+const maxTagsPerRequest = 20
+
+type StudentTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+type BulkTagRequest struct {
+	// Filter selects the students, e.g. "country=IN AND completed"; see package segments
+	Filter string   `json:"filter"`
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+	DryRun bool     `json:"dry_run"`
+}
+
+// normalizeTags checks and lowercases tags, dropping repeats
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxTagsPerRequest {
+		return nil, fmt.Errorf("at most %d tags per request", maxTagsPerRequest)
+	}
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag, err := segments.NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// updateTags adds and removes tags on the students matching condition over students aliased
+// s, keeping each student's tags sorted and unique, and returns how many students changed
+func updateTags(ctx context.Context, q db.Querier, condition string, args []any, add, remove []string) (int64, error) {
+	n := len(args)
+	query := fmt.Sprintf(`
+		UPDATE students s
+		SET tags = ARRAY(
+		        SELECT DISTINCT tag FROM unnest(s.tags || $%d::text[]) AS tag
+		        WHERE tag <> ALL($%d::text[])
+		        ORDER BY tag
+		    ),
+		    updated_at = NOW()
+		WHERE (%s)
+		  AND (NOT (s.tags @> $%d::text[]) OR s.tags && $%d::text[])
+	`, n+1, n+2, condition, n+1, n+2)
+	tag, err := q.Exec(ctx, query, append(args, add, remove)...)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// AddStudentTagsHandler handles POST /api/students/:id/tags
+// Body: {"tags": ["vip", "speaker"]}
+// Adds tags to a student; tags they already have are kept once
+func AddStudentTagsHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid student ID")
+	}
+	var req StudentTagsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if len(tags) == 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "tags is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return studentTagsResponse(c, ctx, id, tags, []string{})
+}
+
+// RemoveStudentTagHandler handles DELETE /api/students/:id/tags/:tag
+func RemoveStudentTagHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid student ID")
+	}
+	tag, err := segments.NormalizeTag(c.Params("tag"))
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return studentTagsResponse(c, ctx, id, []string{}, []string{tag})
+}
+
+// studentTagsResponse applies a tag change to one student and responds with their tags
+func studentTagsResponse(c *fiber.Ctx, ctx context.Context, id int, add, remove []string) error {
+	var tags []string
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		if _, err := updateTags(ctx, tx, `s.id = $1`, []any{id}, add, remove); err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, `SELECT tags FROM students WHERE id = $1`, id).Scan(&tags)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierror.Send(c, fiber.StatusNotFound, "Student not found")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("student_id", id).Msg("Failed to update student tags")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to update tags")
+	}
+	return c.JSON(fiber.Map{"student_id": id, "tags": tags})
+}
+
+// BulkTagStudentsHandler handles POST /api/students/tags/bulk
+// Body: {"filter": "country=IN AND completed", "add": ["finalist"], "remove": ["waitlist"], "dry_run": true}
+// Tags every student the filter matches; dry_run counts them without changing anything
+func BulkTagStudentsHandler(c *fiber.Ctx) error {
+	var req BulkTagRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	add, err := normalizeTags(req.Add)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	remove, err := normalizeTags(req.Remove)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "add or remove is required")
+	}
+	for _, tag := range add {
+		for _, removed := range remove {
+			if tag == removed {
+				return apierror.Send(c, fiber.StatusBadRequest, fmt.Sprintf("tag %q is both added and removed", tag))
+			}
+		}
+	}
+	// An empty filter would match every student, which is too easy to send by mistake
+	if strings.TrimSpace(req.Filter) == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "filter is required")
+	}
+	filter, err := segments.Compile(req.Filter, 1)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var matched int
+	if err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM students s WHERE `+filter.SQL, filter.Args...).Scan(&matched); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to count students for bulk tagging")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to tag students")
+	}
+	response := fiber.Map{
+		"filter":  req.Filter,
+		"add":     add,
+		"remove":  remove,
+		"matched": matched,
+		"dry_run": req.DryRun,
+	}
+	if req.DryRun {
+		return c.JSON(response)
+	}
+
+	updated, err := updateTags(ctx, db.Pool, filter.SQL, filter.Args, add, remove)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to bulk tag students")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to tag students")
+	}
+	logging.Ctx(c).Info().Str("filter", req.Filter).Strs("add", add).Strs("remove", remove).Int64("updated", updated).
+		Msg("Students tagged")
+	response["updated"] = updated
+	return c.JSON(response)
+}
+
+// GetStudentTagsHandler handles GET /api/students/tags
+// Lists every tag in use with how many students have it
+func GetStudentTagsHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.Pool.Query(ctx, `
+		SELECT tag, COUNT(*)
+		FROM students, unnest(tags) AS tag
+		GROUP BY tag
+		ORDER BY COUNT(*) DESC, tag
+	`)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to list tags")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch tags")
+	}
+	defer rows.Close()
+
+	type tagCount struct {
+		Tag      string `json:"tag"`
+		Students int    `json:"students"`
+	}
+	tags := []tagCount{}
+	for rows.Next() {
+		var t tagCount
+		if err := rows.Scan(&t.Tag, &t.Students); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan tag")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch tags")
+		}
+		tags = append(tags, t)
+	}
+	if err := rows.Err(); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to list tags")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch tags")
+	}
+	return c.JSON(fiber.Map{"count": len(tags), "tags": tags})
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// Timeline sources, telling support staff which record an entry comes from
+const (
+	timelineSourceStudent      = "student"
+	timelineSourceRegistration = "registration"
+	timelineSourceEmail        = "email_log"
+	timelineSourceEmailEvent   = "email_event"
+	timelineSourceNotification = "notification"
+	timelineSourceClient       = "client"
+	timelineSourceAccessCode   = "access_code"
+	timelineSourceOTP          = "otp"
+	timelineSourceSession      = "session"
+	timelineSourceAnswers      = "answers"
+	timelineSourceAdmin        = "admin"
+)
+
+// studentTimelineQuery gathers everything recorded about student $1 since $2 (NULL for all),
+// answers counted per session in buckets of $3 seconds, in time order. $4 is the row limit.
+const studentTimelineQuery = `
+	WITH timeline AS (
+		SELECT '` + timelineSourceStudent + `' AS source, 'student_created' AS event, s.created_at AS at, NULL::int AS session_id,
+		       jsonb_build_object('email', s.email, 'tags', s.tags) AS details
+		FROM students s WHERE s.id = $1
+		UNION ALL
+		SELECT '` + timelineSourceStudent + `', 'email_changed', c.changed_at, NULL,
+		       jsonb_build_object('old_email', c.old_email, 'new_email', c.new_email, 'source', c.source)
+		FROM student_email_changes c WHERE c.student_id = $1
+		UNION ALL
+		SELECT '` + timelineSourceStudent + `', 'email_change_requested', ecr.created_at, NULL,
+		       jsonb_build_object('new_email', ecr.new_email, 'ip', ecr.ip, 'confirmed', ecr.confirmed_at IS NOT NULL)
+		FROM email_change_requests ecr WHERE ecr.student_id = $1
+		UNION ALL
+		SELECT '` + timelineSourceRegistration + `', 'registered', r.created_at, NULL,
+		       jsonb_build_object('email', r.email, 'ip', r.ip)
+		FROM registrations r WHERE r.student_id = $1
+		UNION ALL
+		SELECT '` + timelineSourceRegistration + `', 'registration_verified', r.verified_at, NULL,
+		       jsonb_build_object('email', r.email)
+		FROM registrations r WHERE r.student_id = $1 AND r.verified_at IS NOT NULL
+		UNION ALL
+		SELECT '` + timelineSourceEmail + `', 'email_' || COALESCE(l.status, 'sent'), l.sent_at, NULL,
+		       jsonb_build_object('subject', l.subject, 'email', l.email, 'provider', l.provider,
+		                          'request_id', l.request_id, 'response_message', l.response_message)
+		FROM email_logs l WHERE l.student_id = $1
+		UNION ALL
+		SELECT '` + timelineSourceEmailEvent + `', 'email_' || e.event_type, e.created_at, NULL,
+		       jsonb_build_object('email_type', e.email_type, 'via', e.source, 'email', e.email,
+		                          'ip', e.ip_address, 'url', el.url)
+		FROM email_events e
+		LEFT JOIN email_links el ON el.id = e.link_id
+		WHERE e.student_id = $1 AND e.event_type <> 'sent'
+		UNION ALL
+		SELECT '` + timelineSourceNotification + `', n.channel || '_' || n.status, n.sent_at, NULL,
+		       jsonb_build_object('template', n.template, 'phone', n.phone, 'provider', n.provider,
+		                          'response_message', n.response_message)
+		FROM notification_logs n WHERE n.student_id = $1
+		UNION ALL
+		SELECT '` + timelineSourceClient + `', CASE ce.action WHEN 'conference_token' THEN 'token_verified' ELSE ce.action END,
+		       ce.created_at, ce.session_id,
+		       jsonb_build_object('ip', ce.ip, 'country', ce.country, 'user_agent', ce.user_agent)
+		FROM client_events ce WHERE ce.student_id = $1
+		UNION ALL
+		SELECT '` + timelineSourceAccessCode + `', 'access_code_' || ace.action, ace.created_at, NULL,
+		       jsonb_build_object('reason', ace.reason, 'expires_at', ace.expires_at, 'ip', ace.ip)
+		FROM access_code_events ace WHERE ace.student_id = $1
+		UNION ALL
+		SELECT '` + timelineSourceOTP + `', 'otp_' || oa.outcome, oa.created_at, NULL,
+		       jsonb_build_object('ip', oa.ip, 'user_agent', oa.user_agent)
+		FROM otp_attempts oa WHERE oa.student_id = $1
+		UNION ALL
+		SELECT '` + timelineSourceSession + `', 'session_started', ss.started_at, ss.id,
+		       jsonb_build_object('attempt_number', ss.attempt_number, 'ip', ss.start_ip, 'country', ss.start_country,
+		                          'user_agent', ss.start_user_agent, 'sandbox', ss.is_sandbox)
+		FROM sessions ss WHERE ss.student_id = $1
+		UNION ALL
+		SELECT '` + timelineSourceSession + `', 'session_completed', ss.completed_at, ss.id,
+		       jsonb_build_object('score', ss.score, 'total_time_taken_seconds', ss.total_time_taken_seconds,
+		                          'auto_completed_reason', ss.auto_completed_reason)
+		FROM sessions ss WHERE ss.student_id = $1 AND ss.completed AND ss.completed_at IS NOT NULL
+		UNION ALL
+		SELECT '` + timelineSourceSession + `', 'session_abandoned', ss.abandoned_at, ss.id, '{}'::jsonb
+		FROM sessions ss WHERE ss.student_id = $1 AND ss.abandoned_at IS NOT NULL
+		UNION ALL
+		SELECT '` + timelineSourceSession + `', 'session_disqualified', ss.disqualified_at, ss.id,
+		       jsonb_build_object('reason', ss.disqualify_reason)
+		FROM sessions ss WHERE ss.student_id = $1 AND ss.disqualified_at IS NOT NULL
+		UNION ALL
+		SELECT '` + timelineSourceAnswers + `', 'answers_submitted', b.bucket, b.session_id,
+		       jsonb_build_object('count', b.count, 'total', SUM(b.count) OVER (PARTITION BY b.session_id ORDER BY b.bucket))
+		FROM (
+			SELECT a.session_id, to_timestamp(floor(extract(epoch FROM a.submitted_at) / $3::int) * $3::int) AS bucket, COUNT(*) AS count
+			FROM answers a
+			JOIN sessions ss ON ss.id = a.session_id
+			WHERE ss.student_id = $1 AND a.submitted_at IS NOT NULL
+			GROUP BY 1, 2
+		) b
+		UNION ALL
+		SELECT '` + timelineSourceAdmin + `', 'session_' || sa.action, sa.created_at, sa.session_id,
+		       jsonb_build_object('reason', sa.reason, 'details', sa.details, 'ip', sa.ip)
+		FROM session_admin_actions sa WHERE sa.student_id = $1
+	)
+	SELECT source, event, at, session_id, jsonb_strip_nulls(details)
+	FROM timeline
+	WHERE at IS NOT NULL AND ($2::timestamptz IS NULL OR at >= $2)
+	ORDER BY at, source, event
+	LIMIT $4
+`
+
+type TimelineEntry struct {
+	At        time.Time      `json:"at"`
+	Source    string         `json:"source"`
+	Event     string         `json:"event"`
+	SessionID *int           `json:"session_id,omitempty"`
+	Details   map[string]any `json:"details"`
+}
+
+// GetStudentTimelineHandler handles GET /api/admin/students/:id/timeline?since=2025-03-01T00:00:00Z&limit=500&bucket_minutes=5
+// Returns everything recorded about a student in time order, for support calls such as
+// "I can't log in": emails sent and their opens, clicks and bounces, SMS/WhatsApp messages,
+// conference-token and OTP verifications, verify-otp attempts, access code changes and
+// lockouts, sessions started, completed, abandoned or disqualified, admin actions, and
+// answers counted per session every bucket_minutes. Each entry names its source.
+func GetStudentTimelineHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid student ID")
+	}
+	limit := c.QueryInt("limit", 500)
+	if limit < 1 || limit > 5000 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Limit must be between 1 and 5000")
+	}
+	bucketMinutes := c.QueryInt("bucket_minutes", 5)
+	if bucketMinutes < 1 || bucketMinutes > 60 {
+		return apierror.Send(c, fiber.StatusBadRequest, "bucket_minutes must be between 1 and 60")
+	}
+	var since *time.Time
+	if value := c.Query("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "since must be an RFC 3339 time")
+		}
+		since = &parsed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var name, email string
+	err = db.Pool.QueryRow(ctx, `SELECT name, email FROM students WHERE id = $1`, id).Scan(&name, &email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierror.Send(c, fiber.StatusNotFound, "Student not found")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("student_id", id).Msg("Failed to fetch student")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch student timeline")
+	}
+
+	// One row past the limit tells whether the timeline was cut short
+	rows, err := db.Pool.Query(ctx, studentTimelineQuery, id, since, bucketMinutes*60, limit+1)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("student_id", id).Msg("Failed to fetch student timeline")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch student timeline")
+	}
+	defer rows.Close()
+
+	timeline := []TimelineEntry{}
+	for rows.Next() {
+		var entry TimelineEntry
+		if err := rows.Scan(&entry.Source, &entry.Event, &entry.At, &entry.SessionID, &entry.Details); err != nil {
+			logging.Ctx(c).Error().Err(err).Int("student_id", id).Msg("Failed to scan student timeline")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch student timeline")
+		}
+		timeline = append(timeline, entry)
+	}
+	if err := rows.Err(); err != nil {
+		logging.Ctx(c).Error().Err(err).Int("student_id", id).Msg("Failed to fetch student timeline")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch student timeline")
+	}
+	truncated := len(timeline) > limit
+	if truncated {
+		timeline = timeline[:limit]
+	}
+
+	return c.JSON(fiber.Map{
+		"student_id":     id,
+		"name":           name,
+		"email":          email,
+		"bucket_minutes": bucketMinutes,
+		"count":          len(timeline),
+		"truncated":      truncated,
+		"timeline":       timeline,
+	})
+}
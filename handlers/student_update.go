@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/emailcheck"
+	"mcq-exam/logging"
+	"mcq-exam/models"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// Sources of a student email change
+const (
+	emailChangeSourceAdmin       = "admin"
+	emailChangeSourceSelfService = "self_service"
+)
+
+var (
+	errStudentNotFound = errors.New("student not found")
+	errEmailTaken      = errors.New("email already exists")
+	errEmailInvalid    = errors.New("email address is not valid")
+)
+
+// updateStudent applies a full or partial update. A nil name or email keeps the current value.
+// When the email changes, the old address is recorded in student_email_changes and pinned
+// on the student's existing email_tracking rows and sessions, so the conference links,
+// access codes and attempts issued before the change stay traceable to it. A given email
+// is validated again: bad syntax is refused, and a domain that cannot receive email marks
+// the student's address invalid.
+func updateStudent(ctx context.Context, id int, name, email *string, profile models.StudentProfile) (models.Student, *models.StudentEmailChange, error) {
+	var student models.Student
+
+	// Looked up before the row is locked; the lookup has its own timeout
+	var emailReason string
+	if email != nil {
+		emailReason = emailcheck.Check(context.Background(), *email, emailcheck.MXEnabled())
+		if emailReason == emailcheck.ReasonSyntax {
+			return student, nil, errEmailInvalid
+		}
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return student, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	student, change, err := applyStudentUpdate(ctx, tx, id, name, email, profile, emailReason, emailChangeSourceAdmin)
+	if err != nil {
+		return student, nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return student, nil, err
+	}
+	return student, change, nil
+}
+
+// applyStudentUpdate is updateStudent within tx, for an email already checked (emailReason)
+// and a change made by source
+func applyStudentUpdate(ctx context.Context, tx pgx.Tx, id int, name, email *string, profile models.StudentProfile, emailReason, source string) (models.Student, *models.StudentEmailChange, error) {
+	var student models.Student
+
+	var oldEmail string
+	err := tx.QueryRow(ctx, `SELECT email FROM students WHERE id = $1 FOR UPDATE`, id).Scan(&oldEmail)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return student, nil, errStudentNotFound
+	}
+	if err != nil {
+		return student, nil, err
+	}
+
+	query := `
+		UPDATE students
+		SET name = COALESCE($2, name), email = COALESCE($3, email),
+		    institution = NULLIF(COALESCE($4::text, institution), ''),
+		    country = NULLIF(COALESCE($5::text, country), ''),
+		    phone = NULLIF(COALESCE($6::text, phone), ''),
+		    designation = NULLIF(COALESCE($7::text, designation), ''),
+		    timezone = NULLIF(COALESCE($8::text, timezone), ''),
+		    locale = NULLIF(COALESCE($9::text, locale), ''),
+		    email_valid = CASE WHEN $3::text IS NULL THEN email_valid ELSE $10::text = '' END,
+		    email_invalid_reason = CASE WHEN $3::text IS NULL THEN email_invalid_reason ELSE NULLIF($10::text, '') END,
+		    email_checked_at = CASE WHEN $3::text IS NULL THEN email_checked_at ELSE NOW() END,
+		    updated_at = NOW()
+		WHERE id = $1
+		RETURNING ` + studentColumns
+	err = scanStudent(tx.QueryRow(ctx, query, id, name, email,
+		profile.Institution, profile.Country, profile.Phone, profile.Designation, profile.Timezone, profile.Locale, emailReason), &student)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "unique constraint") {
+			return student, nil, errEmailTaken
+		}
+		return student, nil, err
+	}
+
+	var change *models.StudentEmailChange
+	if student.Email != oldEmail {
+		change = &models.StudentEmailChange{OldEmail: oldEmail, NewEmail: student.Email, Source: source}
+		insertQuery := `
+			INSERT INTO student_email_changes (student_id, old_email, new_email, source)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, changed_at
+		`
+		if err := tx.QueryRow(ctx, insertQuery, id, oldEmail, student.Email, source).Scan(&change.ID, &change.ChangedAt); err != nil {
+			return student, nil, err
+		}
+
+		// Rows already pinned keep the address they were first issued to
+		if _, err := tx.Exec(ctx, `UPDATE email_tracking SET recipient_email = $2 WHERE student_id = $1 AND recipient_email IS NULL`, id, oldEmail); err != nil {
+			return student, nil, err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE sessions SET student_email = $2 WHERE student_id = $1 AND student_email IS NULL`, id, oldEmail); err != nil {
+			return student, nil, err
+		}
+	}
+	return student, change, nil
+}
+
+// updateStudentResponse sends the result of updateStudent
+func updateStudentResponse(c *fiber.Ctx, id int, student models.Student, change *models.StudentEmailChange, err error) error {
+	switch {
+	case errors.Is(err, errStudentNotFound):
+		return apierror.Send(c, fiber.StatusNotFound, "Student not found")
+	case errors.Is(err, errEmailTaken):
+		return apierror.Send(c, fiber.StatusConflict, "Email already exists")
+	case errors.Is(err, errEmailInvalid):
+		return apierror.Send(c, fiber.StatusBadRequest, "Email address is not valid")
+	case err != nil:
+		logging.Ctx(c).Error().Err(err).Int("student_id", id).Msg("Failed to update student")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to update student")
+	}
+
+	if change != nil {
+		logging.Ctx(c).Info().Int("student_id", id).Str("old_email", change.OldEmail).Str("new_email", change.NewEmail).Msg("Student email changed")
+	}
+	return c.JSON(student)
+}
+
+// PatchStudentFiber handles PATCH /api/students/:id
+// Only the fields in the body change; an empty profile field clears it, while name and
+// email cannot be cleared
+func PatchStudentFiber(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid student ID")
+	}
+
+	var req models.PatchStudentRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	for _, field := range []*string{req.Name, req.Email} {
+		if field == nil {
+			continue
+		}
+		*field = strings.TrimSpace(*field)
+		if *field == "" {
+			return apierror.Send(c, fiber.StatusBadRequest, "Name and email cannot be empty")
+		}
+	}
+	if err := normalizeProfile(&req.StudentProfile); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	student, change, err := updateStudent(ctx, id, req.Name, req.Email, req.StudentProfile)
+	return updateStudentResponse(c, id, student, change, err)
+}
+
+// GetStudentEmailHistoryFiber handles GET /api/students/:id/email-history
+// Lists the student's email changes, newest first
+func GetStudentEmailHistoryFiber(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid student ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var email string
+	if err := db.Pool.QueryRow(ctx, `SELECT email FROM students WHERE id = $1`, id).Scan(&email); err != nil {
+		return apierror.Send(c, fiber.StatusNotFound, "Student not found")
+	}
+
+	query := `
+		SELECT id, old_email, new_email, source, changed_at
+		FROM student_email_changes
+		WHERE student_id = $1
+		ORDER BY changed_at DESC, id DESC
+	`
+	rows, err := db.Pool.Query(ctx, query, id)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("student_id", id).Msg("Failed to fetch email history")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch email history")
+	}
+	defer rows.Close()
+
+	changes := []models.StudentEmailChange{}
+	for rows.Next() {
+		var change models.StudentEmailChange
+		if err := rows.Scan(&change.ID, &change.OldEmail, &change.NewEmail, &change.Source, &change.ChangedAt); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan email change")
+			continue
+		}
+		changes = append(changes, change)
+	}
+
+	return c.JSON(fiber.Map{
+		"student_id": id,
+		"email":      email,
+		"changes":    changes,
+	})
+}
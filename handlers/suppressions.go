@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/suppression"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type SuppressedEmail struct {
+	ID          int       `json:"id"`
+	Email       string    `json:"email"`
+	Reason      string    `json:"reason"`
+	Details     *string   `json:"details"`
+	SoftBounces int       `json:"soft_bounces"`
+	HardBounces int       `json:"hard_bounces"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type AddSuppressionRequest struct {
+	Email   string `json:"email"`
+	Details string `json:"details"`
+}
+
+// GetSuppressionsHandler handles GET /api/mail/suppressions?reason=hard_bounce
+// Lists addresses that every send path skips, newest first
+func GetSuppressionsHandler(c *fiber.Ctx) error {
+	reason := c.Query("reason")
+	if reason != "" && reason != suppression.ReasonHardBounce && reason != suppression.ReasonSoftBounce && reason != suppression.ReasonManual {
+		return apierror.Send(c, fiber.StatusBadRequest, "reason must be hard_bounce, soft_bounce or manual")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT se.id, se.email, se.reason, se.details,
+		       COUNT(eb.id) FILTER (WHERE eb.bounce_type = 'soft'),
+		       COUNT(eb.id) FILTER (WHERE eb.bounce_type = 'hard'),
+		       se.created_at
+		FROM suppressed_emails se
+		LEFT JOIN email_bounces eb ON eb.email = se.email
+		WHERE $1 = '' OR se.reason = $1
+		GROUP BY se.id
+		ORDER BY se.id DESC
+	`
+	rows, err := db.Pool.Query(ctx, query, reason)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch suppressions")
+	}
+	defer rows.Close()
+
+	suppressions := []SuppressedEmail{}
+	for rows.Next() {
+		var s SuppressedEmail
+		if err := rows.Scan(&s.ID, &s.Email, &s.Reason, &s.Details, &s.SoftBounces, &s.HardBounces, &s.CreatedAt); err != nil {
+			continue
+		}
+		suppressions = append(suppressions, s)
+	}
+
+	return c.JSON(fiber.Map{
+		"count":        len(suppressions),
+		"suppressions": suppressions,
+	})
+}
+
+// AddSuppressionHandler handles POST /api/mail/suppressions
+// Manually suppresses an address (reason "manual")
+func AddSuppressionHandler(c *fiber.Ctx) error {
+	var req AddSuppressionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	email := suppression.Normalize(req.Email)
+	if email == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "email is required")
+	}
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return apierror.Send(c, fiber.StatusBadRequest, "email is not a valid address")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	added, err := suppression.Add(ctx, email, suppression.ReasonManual, strings.TrimSpace(req.Details))
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Str("email", email).Msg("Failed to add suppression")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to add suppression")
+	}
+	if !added {
+		return apierror.Send(c, fiber.StatusConflict, "Email is already suppressed")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Email suppressed",
+		"email":   email,
+		"reason":  suppression.ReasonManual,
+	})
+}
+
+// RemoveSuppressionHandler handles DELETE /api/mail/suppressions/:email
+// Takes an address off the list and clears its bounce history
+func RemoveSuppressionHandler(c *fiber.Ctx) error {
+	email, err := url.PathUnescape(c.Params("email"))
+	if err != nil || strings.TrimSpace(email) == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid email")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	removed, err := suppression.Remove(ctx, email)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Str("email", email).Msg("Failed to remove suppression")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to remove suppression")
+	}
+	if !removed {
+		return apierror.Send(c, fiber.StatusNotFound, "Email is not suppressed")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"mcq-exam/accesscodes"
+	"mcq-exam/apierror"
+	"mcq-exam/config"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/models"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type TestRunRequest struct {
+	// Name defaults to "Test Run <token prefix>"
+	Name string `json:"name"`
+	// Timezone sets the sandbox student's exam window, e.g. "Asia/Kolkata"
+	Timezone string `json:"timezone"`
+}
+
+// testRunEnabled reports whether sandbox students may be created (TEST_RUN_ENABLED, default false)
+func testRunEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("TEST_RUN_ENABLED"))
+	return enabled
+}
+
+// CreateTestRunHandler handles POST /api/admin/test-run
+// Body (optional): {"name": "QA", "timezone": "Asia/Kolkata"}
+// Creates a sandbox student who has attended the conference and returns the conference
+// token and access code, so the live flow can be driven from verify-otp onwards. Sandbox
+// sessions are left out of leaderboards, results, stats and bulk mail.
+func CreateTestRunHandler(c *fiber.Ctx) error {
+	if !testRunEnabled() {
+		return apierror.Send(c, fiber.StatusForbidden, "Test runs are disabled; set TEST_RUN_ENABLED=true to allow them")
+	}
+
+	var req TestRunRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+		}
+	}
+	profile := models.StudentProfile{Timezone: &req.Timezone}
+	if err := normalizeProfile(&profile); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	token := GenerateConferenceToken()
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Test Run " + token[:8]
+	}
+	email := "test-run-" + token[:16] + "@sandbox.invalid"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to start transaction")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to create test run")
+	}
+	defer tx.Rollback(ctx)
+
+	var student models.Student
+	query := `
+		INSERT INTO students (name, email, timezone, is_sandbox, created_at, updated_at)
+		VALUES ($1, $2, NULLIF($3::text, ''), true, NOW(), NOW())
+		RETURNING ` + studentColumns
+	if err := scanStudent(tx.QueryRow(ctx, query, name, email, *profile.Timezone), &student); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to create sandbox student")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to create test run")
+	}
+
+	trackingQuery := `
+		INSERT INTO email_tracking (student_id, email_type, conference_token, created_at)
+		VALUES ($1, $2, $3, NOW())
+	`
+	if _, err := tx.Exec(ctx, trackingQuery, student.ID, accesscodes.EmailType, token); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to store conference token")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to create test run")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to commit test run")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to create test run")
+	}
+	logging.SetStudent(c, student.ID)
+
+	// Same as the student following the conference link: attendance marked, code issued
+	audit := accesscodes.Audit{Reason: "test run", IP: c.IP(), UserAgent: c.Get(fiber.HeaderUserAgent)}
+	if _, err := accesscodes.Issue(ctx, student.ID, accesscodes.EmailType, audit); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to issue access code")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to issue access code")
+	}
+	code, err := accesscodes.Get(ctx, student.ID)
+	if err != nil || code.AccessCode == nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch access code")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch access code")
+	}
+
+	frontendURL := config.FrontendURL()
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":                "Sandbox student created",
+		"student":                student,
+		"conference_token":       token,
+		"access_code":            *code.AccessCode,
+		"access_code_expires_at": code.ExpiresAt,
+		"otp_url":                frontendURL + "?otp=" + *code.AccessCode,
+		"next_steps": []string{
+			"POST /api/live/verify-otp with {\"otp\": access_code} to start the test",
+			"POST /api/live/get-otp with {\"email\": student.email} to fetch the code again",
+		},
+	})
+}
+
+// DeleteTestRunsHandler handles DELETE /api/admin/test-run
+// Removes every sandbox student along with their sessions, answers and tracking rows
+func DeleteTestRunsHandler(c *fiber.Ctx) error {
+	if !testRunEnabled() {
+		return apierror.Send(c, fiber.StatusForbidden, "Test runs are disabled; set TEST_RUN_ENABLED=true to allow them")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM students WHERE is_sandbox = true`)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to delete sandbox students")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to delete sandbox students")
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Sandbox students deleted",
+		"deleted": tag.RowsAffected(),
+	})
+}
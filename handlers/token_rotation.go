@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/config"
+	"mcq-exam/db"
+	"mcq-exam/i18n"
+	"mcq-exam/logging"
+	"mcq-exam/outbox"
+	"mcq-exam/suppression"
+	"mcq-exam/tracking"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxRotateStudents bounds the student_ids list of POST /api/admin/tokens/rotate
+const maxRotateStudents = 5000
+
+type RotateTokensRequest struct {
+	StudentIDs []int `json:"student_ids"`
+	// All rotates every invited (non-sandbox) student's token instead of StudentIDs
+	All bool `json:"all"`
+	// Resend queues the conference invitation with the new link
+	Resend bool `json:"resend"`
+}
+
+// rotatedToken is a student whose conference token was replaced
+type rotatedToken struct {
+	StudentID int
+	Name      string
+	Email     string
+	Locale    string
+	Token     string
+}
+
+// RotateTokensHandler handles POST /api/admin/tokens/rotate
+// Body: {"student_ids": [12, 40]} or {"all": true}, optionally with "resend": true.
+// Replaces the conference tokens of invited students so leaked or mangled links stop
+// working. Attendance and access codes are kept. With resend, the invitation with the new
+// link is queued in the email outbox along with the new token.
+func RotateTokensHandler(c *fiber.Ctx) error {
+	var req RotateTokensRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if req.All == (len(req.StudentIDs) > 0) {
+		return apierror.Send(c, fiber.StatusBadRequest, "Provide either student_ids or \"all\": true")
+	}
+	if len(req.StudentIDs) > maxRotateStudents {
+		return apierror.Send(c, fiber.StatusBadRequest, fmt.Sprintf("At most %d student_ids per request", maxRotateStudents))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Only students who were sent the first mail have a conference token to replace
+	query := `
+		SELECT et.student_id
+		FROM email_tracking et
+		JOIN students s ON s.id = et.student_id
+		WHERE et.email_type = 'firstMail' AND et.conference_token IS NOT NULL
+		  AND (($1::int[] IS NULL AND s.is_sandbox = false) OR et.student_id = ANY($1))
+		ORDER BY et.student_id ASC
+	`
+	var filter []int
+	if !req.All {
+		filter = req.StudentIDs
+	}
+	rows, err := db.Pool.Query(ctx, query, filter)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch students to rotate")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch students")
+	}
+	var studentIDs []int
+	var tokens []string
+	for rows.Next() {
+		var studentID int
+		if err := rows.Scan(&studentID); err != nil {
+			rows.Close()
+			logging.Ctx(c).Error().Err(err).Msg("Failed to fetch students to rotate")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch students")
+		}
+		studentIDs = append(studentIDs, studentID)
+		tokens = append(tokens, GenerateConferenceToken())
+	}
+	rows.Close()
+
+	// Students in the list without a token were never invited
+	notInvited := make([]int, 0)
+	if !req.All {
+		found := make(map[int]bool, len(studentIDs))
+		for _, id := range studentIDs {
+			found[id] = true
+		}
+		for _, id := range req.StudentIDs {
+			if !found[id] {
+				notInvited = append(notInvited, id)
+				found[id] = true
+			}
+		}
+	}
+
+	// The old token stops matching as soon as it is overwritten. With resend, the invitations
+	// carrying the new links are queued in the same transaction, so a token is never replaced
+	// without its email or the other way round.
+	rotated := make([]rotatedToken, 0, len(studentIDs))
+	queued := 0
+	if len(studentIDs) > 0 {
+		updateQuery := `
+			UPDATE email_tracking et
+			SET conference_token = t.token, recipient_email = NULL, updated_at = NOW()
+			FROM unnest($1::int[], $2::text[]) AS t(student_id, token), students s
+			WHERE et.student_id = t.student_id AND et.email_type = 'firstMail' AND s.id = et.student_id
+			RETURNING et.student_id, s.name, s.email, COALESCE(s.locale, ''), et.conference_token
+		`
+		err := db.WithTx(ctx, func(tx pgx.Tx) error {
+			rows, err := tx.Query(ctx, updateQuery, studentIDs, tokens)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var r rotatedToken
+				if err := rows.Scan(&r.StudentID, &r.Name, &r.Email, &r.Locale, &r.Token); err != nil {
+					rows.Close()
+					return err
+				}
+				rotated = append(rotated, r)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			if !req.Resend {
+				return nil
+			}
+			msgs := rotatedInvitations(ctx, rotated)
+			queued = len(msgs)
+			return outbox.Enqueue(ctx, tx, msgs...)
+		})
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to rotate conference tokens")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to rotate tokens")
+		}
+	}
+
+	rotatedIDs := make([]int, 0, len(rotated))
+	for _, r := range rotated {
+		rotatedIDs = append(rotatedIDs, r.StudentID)
+	}
+	logging.Ctx(c).Info().Int("rotated", len(rotated)).Bool("resend", req.Resend).Int("queued", queued).Msg("Rotated conference tokens")
+	if queued > 0 {
+		outbox.Notify()
+	}
+
+	return c.JSON(fiber.Map{
+		"message":            "Conference tokens rotated",
+		"rotated":            len(rotated),
+		"student_ids":        rotatedIDs,
+		"not_invited":        notInvited,
+		"invitations_queued": queued > 0,
+		"queued":             queued,
+	})
+}
+
+// rotatedInvitations builds the conference invitation with each student's new link, skipping
+// suppressed addresses
+func rotatedInvitations(ctx context.Context, rotated []rotatedToken) []outbox.Message {
+	frontendURL := config.FrontendURL()
+
+	msgs := make([]outbox.Message, 0, len(rotated))
+	for _, r := range rotated {
+		if suppression.IsSuppressed(ctx, r.Email) {
+			continue
+		}
+
+		link := frontendURL + "/live?token=" + r.Token
+		subject, htmlBody := i18n.Localize(ctx, i18n.TemplateFirstMail, r.Locale,
+			"Invitation: CoopQuest- An International Online Cooperative  Conclave", conferenceInvitationHTML(r.Name, link),
+			map[string]string{"name": r.Name, "link": link})
+		msgs = append(msgs, outbox.Message{
+			StudentID: r.StudentID,
+			EmailType: tracking.FirstMail,
+			Source:    "token_rotation",
+			ToEmail:   r.Email,
+			ToName:    r.Name,
+			Subject:   subject,
+			HTMLBody:  htmlBody,
+		})
+	}
+	return msgs
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/segments"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xuri/excelize/v2"
+)
+
+// trackingTable is a tracking cohort laid out for a CSV file or a workbook sheet. Cells are
+// strings, or ints so spreadsheets can sort and sum them.
+type trackingTable struct {
+	Sheet   string
+	Headers []string
+	Rows    [][]interface{}
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// trackingFormat reads ?format=json|csv|xlsx (default json)
+func trackingFormat(c *fiber.Ctx) (string, error) {
+	format := strings.ToLower(c.Query("format", "json"))
+	if format != "json" && format != "csv" && format != "xlsx" {
+		return "", errors.New("format must be json, csv or xlsx")
+	}
+	return format, nil
+}
+
+// exportTime formats a timestamp cell; a missing one is left empty
+func exportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func exportOptional(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func openedFirstTable(students []StudentTracking) trackingTable {
+	table := trackingTable{Sheet: "Opened first", Headers: []string{"student_id", "name", "email", "access_code", "opened_at"}}
+	for _, st := range students {
+		table.Rows = append(table.Rows, []interface{}{st.StudentID, st.Name, st.Email, st.AccessCode, exportTime(&st.OpenedAt)})
+	}
+	return table
+}
+
+func notAttendedTable(students []NonAttendee) trackingTable {
+	table := trackingTable{Sheet: "Not attended", Headers: []string{"student_id", "name", "email", "opened", "opened_at", "email_type"}}
+	for _, st := range students {
+		opened := ""
+		if st.Opened != nil {
+			opened = strconv.FormatBool(*st.Opened)
+		}
+		table.Rows = append(table.Rows, []interface{}{st.StudentID, st.Name, st.Email, opened, exportTime(st.OpenedAt), exportOptional(st.EmailType)})
+	}
+	return table
+}
+
+func notStartedTestTable(students []StudentNotStarted) trackingTable {
+	table := trackingTable{Sheet: "Not started test", Headers: []string{"student_id", "name", "email", "access_code", "conference_attended_at"}}
+	for _, st := range students {
+		table.Rows = append(table.Rows, []interface{}{st.StudentID, st.Name, st.Email, st.AccessCode, exportTime(&st.ConferenceAttendedAt)})
+	}
+	return table
+}
+
+func campaignSummaryTable(campaigns []CampaignSummary) trackingTable {
+	table := trackingTable{Sheet: "Campaigns", Headers: []string{"email_type", "sent", "opened", "clicked", "neither"}}
+	for _, cs := range campaigns {
+		table.Rows = append(table.Rows, []interface{}{cs.EmailType, cs.Sent, cs.Opened, cs.Clicked, cs.Neither})
+	}
+	return table
+}
+
+func campaignCohortTable(students []CohortStudent) trackingTable {
+	table := trackingTable{Sheet: "Cohort", Headers: []string{"student_id", "name", "email", "sent_at", "first_opened_at", "first_clicked_at", "clicks"}}
+	for _, st := range students {
+		table.Rows = append(table.Rows, []interface{}{st.StudentID, st.Name, st.Email, exportTime(&st.SentAt),
+			exportTime(st.FirstOpenedAt), exportTime(st.FirstClickedAt), st.Clicks})
+	}
+	return table
+}
+
+// sendTrackingExport downloads tables as a CSV file (the first table) or a workbook with a
+// sheet per table
+func sendTrackingExport(c *fiber.Ctx, format, name string, tables ...trackingTable) error {
+	// The body is streamed after the handler returns, so keep the logger rather than the context
+	logger := logging.Ctx(c)
+
+	name = unsafeFilenameChars.ReplaceAllString(name, "_")
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102-150405"), format)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	if format == "csv" {
+		table := tables[0]
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			writer := csv.NewWriter(w)
+			_ = writer.Write(table.Headers)
+			for _, row := range table.Rows {
+				record := make([]string, len(row))
+				for i, cell := range row {
+					record[i] = fmt.Sprint(cell)
+				}
+				_ = writer.Write(record)
+			}
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				logger.Error().Err(err).Msg("Failed to write tracking CSV export")
+			}
+		})
+		return nil
+	}
+
+	file, err := buildTrackingWorkbook(tables)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to build tracking XLSX export")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to build export")
+	}
+
+	c.Set(fiber.HeaderContentType, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer file.Close()
+		if err := file.Write(w); err != nil {
+			logger.Error().Err(err).Msg("Failed to write tracking XLSX export")
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// buildTrackingWorkbook writes one sheet per table using excelize's row streamer
+func buildTrackingWorkbook(tables []trackingTable) (*excelize.File, error) {
+	file := excelize.NewFile()
+	if err := file.SetSheetName("Sheet1", tables[0].Sheet); err != nil {
+		return nil, err
+	}
+	for i, table := range tables {
+		if i > 0 {
+			if _, err := file.NewSheet(table.Sheet); err != nil {
+				return nil, err
+			}
+		}
+
+		stream, err := file.NewStreamWriter(table.Sheet)
+		if err != nil {
+			return nil, err
+		}
+		headers := make([]interface{}, len(table.Headers))
+		for j, header := range table.Headers {
+			headers[j] = header
+		}
+		if err := stream.SetRow("A1", headers); err != nil {
+			return nil, err
+		}
+		for j, row := range table.Rows {
+			cell, _ := excelize.CoordinatesToCellName(1, j+2)
+			if err := stream.SetRow(cell, row); err != nil {
+				return nil, err
+			}
+		}
+		if err := stream.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+// ExportTrackingHandler handles GET /api/tracking/export?filter=tag=vip
+// Downloads one workbook with a sheet per cohort: opened first, not attended, not started
+// test, and the per-campaign engagement summary, plus a Segment sheet when filter is given
+func ExportTrackingHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var segment []trackingTable
+	if filter := strings.TrimSpace(c.Query("filter")); filter != "" {
+		students, err := loadSegment(ctx, filter)
+		if errors.Is(err, segments.ErrInvalidFilter) {
+			return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+		}
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to export tracking cohorts")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch segment")
+		}
+		segment = append(segment, segmentTable(students))
+	}
+
+	openedFirst, err := loadStudentsWhoOpened(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to export tracking cohorts")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch tracking data")
+	}
+	notAttended, err := loadStudentsNotAttended(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to export tracking cohorts")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch non-attendees")
+	}
+	notStarted, err := loadStudentsNotStartedTest(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to export tracking cohorts")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch students")
+	}
+	campaigns, err := loadCampaignSummary(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to export tracking cohorts")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch campaign summary")
+	}
+
+	tables := []trackingTable{
+		openedFirstTable(openedFirst),
+		notAttendedTable(notAttended),
+		notStartedTestTable(notStarted),
+		campaignSummaryTable(campaigns),
+	}
+	return sendTrackingExport(c, "xlsx", "tracking", append(tables, segment...)...)
+}
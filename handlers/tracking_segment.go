@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/segments"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SegmentStudent is a student matched by a segment filter with where they are in the funnel
+type SegmentStudent struct {
+	StudentID   int      `json:"student_id"`
+	Name        string   `json:"name"`
+	Email       string   `json:"email"`
+	Country     *string  `json:"country"`
+	Institution *string  `json:"institution"`
+	Tags        []string `json:"tags"`
+	Invited     bool     `json:"invited"`
+	Opened      bool     `json:"opened"`
+	Attended    bool     `json:"attended"`
+	Started     bool     `json:"started"`
+	Completed   bool     `json:"completed"`
+}
+
+// GetSegmentHandler handles GET /api/tracking/segment?filter=country=IN AND tag=vip&format=json|csv|xlsx
+// Lists the students a segment filter matches, with their tags and funnel progress
+func GetSegmentHandler(c *fiber.Ctx) error {
+	format, err := trackingFormat(c)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+	filter := strings.TrimSpace(c.Query("filter"))
+	if filter == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "filter is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	students, err := loadSegment(ctx, filter)
+	if err != nil {
+		if errors.Is(err, segments.ErrInvalidFilter) {
+			return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+		}
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load segment")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch segment")
+	}
+	if format != "json" {
+		return sendTrackingExport(c, format, "segment", segmentTable(students))
+	}
+
+	return c.JSON(fiber.Map{
+		"filter":   filter,
+		"count":    len(students),
+		"students": students,
+	})
+}
+
+func loadSegment(ctx context.Context, filter string) ([]SegmentStudent, error) {
+	segment, err := segments.Compile(filter, 1)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+		SELECT s.id, s.name, s.email, s.country, s.institution, s.tags,
+		       EXISTS (SELECT 1 FROM email_tracking et WHERE et.student_id = s.id AND et.email_type = 'firstMail'),
+		       EXISTS (SELECT 1 FROM email_events e WHERE e.student_id = s.id AND e.event_type = 'open'),
+		       EXISTS (SELECT 1 FROM email_tracking et WHERE et.student_id = s.id AND et.email_type = 'firstMail' AND et.conference_attended = true),
+		       EXISTS (SELECT 1 FROM sessions sess WHERE sess.student_id = s.id),
+		       EXISTS (SELECT 1 FROM sessions sess WHERE sess.student_id = s.id AND sess.completed = true)
+		FROM students s
+		WHERE ` + segment.SQL + `
+		ORDER BY s.id ASC
+	`
+
+	rows, err := db.Pool.Query(ctx, query, segment.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	students := []SegmentStudent{}
+	for rows.Next() {
+		var st SegmentStudent
+		if err := rows.Scan(&st.StudentID, &st.Name, &st.Email, &st.Country, &st.Institution, &st.Tags,
+			&st.Invited, &st.Opened, &st.Attended, &st.Started, &st.Completed); err != nil {
+			return nil, err
+		}
+		students = append(students, st)
+	}
+	return students, rows.Err()
+}
+
+func segmentTable(students []SegmentStudent) trackingTable {
+	table := trackingTable{Sheet: "Segment", Headers: []string{
+		"student_id", "name", "email", "country", "institution", "tags",
+		"invited", "opened", "attended", "started", "completed",
+	}}
+	for _, st := range students {
+		table.Rows = append(table.Rows, []interface{}{
+			st.StudentID, st.Name, st.Email, exportOptional(st.Country), exportOptional(st.Institution),
+			strings.Join(st.Tags, " "), st.Invited, st.Opened, st.Attended, st.Started, st.Completed,
+		})
+	}
+	return table
+}
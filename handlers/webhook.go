@@ -2,64 +2,248 @@ package handlers
 
 import (
 	"context"
-	"log"
-	"mcq-exam/db"
+	"encoding/json"
+	"mcq-exam/events"
+	"mcq-exam/logging"
+	"mcq-exam/notify"
+	"mcq-exam/suppression"
+	"mcq-exam/tracking"
+	"mcq-exam/utils"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 type WebhookPayload struct {
-	EventName []string `json:"event_name"`
-	EventMessage []struct {
-		RequestID string `json:"request_id"`
-		EmailInfo struct {
-			Subject string `json:"subject"`
-			To []struct {
-				EmailAddress struct {
-					Address string `json:"address"`
-					Name    string `json:"name"`
-				} `json:"email_address"`
-			} `json:"to"`
-		} `json:"email_info"`
-		EventData []struct {
-			Details []struct {
-				Reason            string `json:"reason"`
-				BouncedRecipient  string `json:"bounced_recipient"`
-				Time              string `json:"time"`
-				DiagnosticMessage string `json:"diagnostic_message"`
-			} `json:"details"`
-		} `json:"event_data"`
-	} `json:"event_message"`
+	EventName    []string          `json:"event_name"`
+	EventMessage []json.RawMessage `json:"event_message"`
 }
 
+// ZeptoMailEventMessage is one message of a ZeptoMail webhook payload
+type ZeptoMailEventMessage struct {
+	RequestID string `json:"request_id"`
+	EmailInfo struct {
+		Subject string `json:"subject"`
+		To      []struct {
+			EmailAddress struct {
+				Address string `json:"address"`
+				Name    string `json:"name"`
+			} `json:"email_address"`
+		} `json:"to"`
+	} `json:"email_info"`
+	EventData []struct {
+		Details []struct {
+			Reason            string `json:"reason"`
+			BouncedRecipient  string `json:"bounced_recipient"`
+			Time              string `json:"time"`
+			DiagnosticMessage string `json:"diagnostic_message"`
+		} `json:"details"`
+	} `json:"event_data"`
+}
+
+// zeptoTimeLayouts are the timestamp formats ZeptoMail event details use
+var zeptoTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.000-0700", "2006-01-02T15:04:05-0700"}
+
 // ZeptoMailWebhookHandler handles POST /api/webhooks/zeptomail
-// Receives bounce notifications from ZeptoMail and updates email status to failed
+// Receives ZeptoMail delivery events (delivered, open, click, spam, hard/soft bounce),
+// stores each in email_events and moves the message's email_logs status forward. Bounces
+// also suppress hard-bounced (or repeatedly soft-bounced) addresses. With
+// ZEPTO_WEBHOOK_SECRET set, requests must carry a valid producer-signature header.
 func ZeptoMailWebhookHandler(c *fiber.Ctx) error {
+	if !utils.ValidZeptoMailSignature(c.Body(), c.Get("producer-signature")) {
+		logging.Ctx(c).Warn().Msg("Rejected ZeptoMail webhook with an invalid signature")
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+
 	var payload WebhookPayload
 	if err := c.BodyParser(&payload); err != nil {
 		// Return 200 even on parse error as per ZeptoMail requirements
 		return c.SendStatus(fiber.StatusOK)
 	}
 
+	eventType := tracking.ZeptoMailEventType(payload.EventName)
+	if eventType == "" {
+		logging.Ctx(c).Warn().Strs("event_name", payload.EventName).Msg("Ignoring unknown ZeptoMail event")
+		return c.SendStatus(fiber.StatusOK)
+	}
+
 	// Process each event message
-	for _, msg := range payload.EventMessage {
-		if msg.RequestID == "" {
+	for _, raw := range payload.EventMessage {
+		var msg ZeptoMailEventMessage
+		if err := json.Unmarshal(raw, &msg); err != nil || msg.RequestID == "" {
 			continue
 		}
 
-		// Update email status to failed
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		query := `UPDATE email_logs SET status = 'failed' WHERE request_id = $1`
-		_, err := db.Pool.Exec(ctx, query, msg.RequestID)
-		cancel()
+		var recipients []string
+		for _, to := range msg.EmailInfo.To {
+			recipients = append(recipients, to.EmailAddress.Address)
+		}
+		var reason, diagnostic, eventTime string
+		bounced := make(map[string]bool)
+		for _, data := range msg.EventData {
+			for _, detail := range data.Details {
+				if reason == "" {
+					reason = detail.Reason
+					diagnostic = detail.DiagnosticMessage
+				}
+				if eventTime == "" {
+					eventTime = detail.Time
+				}
+				if detail.BouncedRecipient != "" {
+					bounced[detail.BouncedRecipient] = true
+				}
+			}
+		}
+		// Without a bounced_recipient, the event applies to every addressee of the message
+		affected := recipients
+		if eventType == tracking.EventBounce && len(bounced) > 0 {
+			affected = make([]string, 0, len(bounced))
+			for recipient := range bounced {
+				affected = append(affected, recipient)
+			}
+		}
+		var occurredAt time.Time
+		for _, layout := range zeptoTimeLayouts {
+			if parsed, err := time.Parse(layout, eventTime); err == nil {
+				occurredAt = parsed
+				break
+			}
+		}
 
-		if err != nil {
-			// Log error but still return 200
-			log.Printf("Failed to update email status for request_id %s: %v", msg.RequestID, err)
+		fresh := false
+		for _, recipient := range affected {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			recorded, err := tracking.RecordProviderEvent(ctx, tracking.ProviderEvent{
+				Source:     "zeptomail",
+				EventType:  eventType,
+				RequestID:  msg.RequestID,
+				Email:      recipient,
+				OccurredAt: occurredAt,
+				Details:    raw,
+			})
+			cancel()
+			if err != nil {
+				// Log error but still return 200
+				logging.Ctx(c).Error().Err(err).Str("email_request_id", msg.RequestID).Str("event_type", eventType).Msg("Failed to record email event")
+			}
+			// A failure to store the event must not lose the bounce below
+			fresh = fresh || recorded || err != nil
 		}
+
+		if eventType != tracking.EventBounce || !fresh {
+			continue
+		}
+
+		bounceType := suppression.Classify(payload.EventName, reason, diagnostic)
+		for _, recipient := range affected {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			if err := suppression.RecordBounce(ctx, recipient, bounceType, msg.RequestID, reason); err != nil {
+				logging.Ctx(c).Error().Err(err).Str("bounce_type", bounceType).Str("email", recipient).Msg("Failed to record bounce")
+			}
+			cancel()
+		}
+
+		events.Publish(events.EmailBounced, fiber.Map{
+			"request_id":  msg.RequestID,
+			"subject":     msg.EmailInfo.Subject,
+			"recipients":  recipients,
+			"reason":      reason,
+			"bounce_type": bounceType,
+		})
 	}
 
 	// Always return 200 as required by ZeptoMail
 	return c.SendStatus(fiber.StatusOK)
 }
+
+// TwilioWebhookHandler handles POST /api/webhooks/twilio
+// Receives SMS status callbacks (form fields MessageSid, MessageStatus, ErrorCode) and
+// updates notification_logs. Requests must carry a valid X-Twilio-Signature.
+func TwilioWebhookHandler(c *fiber.Ctx) error {
+	params := make(map[string]string)
+	c.Request().PostArgs().VisitAll(func(key, value []byte) {
+		params[string(key)] = string(value)
+	})
+
+	if !notify.ValidTwilioSignature(notify.TwilioCallbackURL(), c.Get("X-Twilio-Signature"), params) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+
+	messageSID := params["MessageSid"]
+	if messageSID == "" {
+		return c.SendStatus(fiber.StatusOK)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	status := notify.TwilioStatus(params["MessageStatus"])
+	if err := notify.UpdateStatus(ctx, "twilio", messageSID, status, params["ErrorCode"], params["ErrorMessage"]); err != nil {
+		logging.Ctx(c).Error().Err(err).Str("message_sid", messageSID).Msg("Failed to update SMS status")
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+type WhatsAppWebhookPayload struct {
+	Entry []struct {
+		Changes []struct {
+			Value struct {
+				Statuses []struct {
+					ID     string `json:"id"`
+					Status string `json:"status"`
+					Errors []struct {
+						Code  int    `json:"code"`
+						Title string `json:"title"`
+					} `json:"errors"`
+				} `json:"statuses"`
+			} `json:"value"`
+		} `json:"changes"`
+	} `json:"entry"`
+}
+
+// WhatsAppWebhookVerifyHandler handles GET /api/webhooks/whatsapp
+// Answers Meta's subscription check by echoing hub.challenge when hub.verify_token
+// matches WHATSAPP_VERIFY_TOKEN
+func WhatsAppWebhookVerifyHandler(c *fiber.Ctx) error {
+	verifyToken := os.Getenv("WHATSAPP_VERIFY_TOKEN")
+	if verifyToken == "" || c.Query("hub.mode") != "subscribe" || c.Query("hub.verify_token") != verifyToken {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	return c.SendString(c.Query("hub.challenge"))
+}
+
+// WhatsAppWebhookHandler handles POST /api/webhooks/whatsapp
+// Receives message status updates (sent, delivered, read, failed) and updates notification_logs
+func WhatsAppWebhookHandler(c *fiber.Ctx) error {
+	if !notify.ValidWhatsAppSignature(c.Body(), c.Get("X-Hub-Signature-256")) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+
+	var payload WhatsAppWebhookPayload
+	if err := c.BodyParser(&payload); err != nil {
+		// Meta retries non-200 responses, which would not parse any better
+		return c.SendStatus(fiber.StatusOK)
+	}
+
+	for _, entry := range payload.Entry {
+		for _, change := range entry.Changes {
+			for _, status := range change.Value.Statuses {
+				if status.ID == "" {
+					continue
+				}
+				var code, message string
+				if len(status.Errors) > 0 {
+					code, message = strconv.Itoa(status.Errors[0].Code), status.Errors[0].Title
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+				if err := notify.UpdateStatus(ctx, "whatsapp_cloud", status.ID, notify.WhatsAppStatus(status.Status), code, message); err != nil {
+					logging.Ctx(c).Error().Err(err).Str("message_id", status.ID).Msg("Failed to update WhatsApp status")
+				}
+				cancel()
+			}
+		}
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
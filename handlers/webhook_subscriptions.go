@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/events"
+	"mcq-exam/logging"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type WebhookSubscription struct {
+	ID          int       `json:"id"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret,omitempty"`
+	EventTypes  []string  `json:"event_types"`
+	Description *string   `json:"description"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type WebhookSubscriptionRequest struct {
+	URL         string   `json:"url"`
+	Secret      string   `json:"secret"`
+	EventTypes  []string `json:"event_types"`
+	Description string   `json:"description"`
+	Active      *bool    `json:"active"`
+}
+
+// validateWebhookRequest checks the URL and event types of a subscription request
+func validateWebhookRequest(req *WebhookSubscriptionRequest) string {
+	parsed, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "url must be a valid http(s) URL"
+	}
+
+	for _, eventType := range req.EventTypes {
+		if !events.IsValidEventType(eventType) {
+			return fmt.Sprintf("Unknown event type '%s'. Valid types: %s", eventType, strings.Join(events.EventTypes, ", "))
+		}
+	}
+
+	if req.EventTypes == nil {
+		req.EventTypes = []string{}
+	}
+
+	return ""
+}
+
+// CreateWebhookSubscriptionHandler handles POST /api/admin/webhooks
+// Registers a URL to receive signed event notifications.
+// If no secret is provided one is generated and returned only in this response.
+func CreateWebhookSubscriptionHandler(c *fiber.Ctx) error {
+	var req WebhookSubscriptionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if msg := validateWebhookRequest(&req); msg != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, msg)
+	}
+
+	if strings.TrimSpace(req.Secret) == "" {
+		bytes := make([]byte, 32)
+		rand.Read(bytes)
+		req.Secret = hex.EncodeToString(bytes)
+	}
+
+	active := true
+	if req.Active != nil {
+		active = *req.Active
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sub WebhookSubscription
+	query := `
+		INSERT INTO webhook_subscriptions (url, secret, event_types, description, active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, url, secret, event_types, description, active, created_at, updated_at
+	`
+	err := db.Pool.QueryRow(ctx, query, strings.TrimSpace(req.URL), req.Secret, req.EventTypes, nullString(req.Description), active).Scan(
+		&sub.ID, &sub.URL, &sub.Secret, &sub.EventTypes, &sub.Description, &sub.Active, &sub.CreatedAt, &sub.UpdatedAt,
+	)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to create webhook subscription")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to create webhook subscription")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(sub)
+}
+
+// GetWebhookSubscriptionsHandler handles GET /api/admin/webhooks
+func GetWebhookSubscriptionsHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT id, url, event_types, description, active, created_at, updated_at
+		FROM webhook_subscriptions
+		ORDER BY id
+	`
+	rows, err := db.Pool.Query(ctx, query)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch webhook subscriptions")
+	}
+	defer rows.Close()
+
+	subs := []WebhookSubscription{}
+	for rows.Next() {
+		var sub WebhookSubscription
+		if err := rows.Scan(&sub.ID, &sub.URL, &sub.EventTypes, &sub.Description, &sub.Active, &sub.CreatedAt, &sub.UpdatedAt); err != nil {
+			continue
+		}
+		subs = append(subs, sub)
+	}
+
+	return c.JSON(fiber.Map{
+		"count":         len(subs),
+		"subscriptions": subs,
+		"event_types":   events.EventTypes,
+	})
+}
+
+// GetWebhookSubscriptionHandler handles GET /api/admin/webhooks/:id
+func GetWebhookSubscriptionHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid subscription ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sub WebhookSubscription
+	query := `
+		SELECT id, url, event_types, description, active, created_at, updated_at
+		FROM webhook_subscriptions
+		WHERE id = $1
+	`
+	err = db.Pool.QueryRow(ctx, query, id).Scan(&sub.ID, &sub.URL, &sub.EventTypes, &sub.Description, &sub.Active, &sub.CreatedAt, &sub.UpdatedAt)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusNotFound, "Webhook subscription not found")
+	}
+
+	return c.JSON(sub)
+}
+
+// UpdateWebhookSubscriptionHandler handles PUT /api/admin/webhooks/:id
+// Replaces url, event types, description and active flag. The secret is only rotated if provided.
+func UpdateWebhookSubscriptionHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid subscription ID")
+	}
+
+	var req WebhookSubscriptionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if msg := validateWebhookRequest(&req); msg != "" {
+		return apierror.Send(c, fiber.StatusBadRequest, msg)
+	}
+
+	active := true
+	if req.Active != nil {
+		active = *req.Active
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sub WebhookSubscription
+	query := `
+		UPDATE webhook_subscriptions
+		SET url = $1,
+		    event_types = $2,
+		    description = $3,
+		    active = $4,
+		    secret = COALESCE($5, secret),
+		    updated_at = NOW()
+		WHERE id = $6
+		RETURNING id, url, event_types, description, active, created_at, updated_at
+	`
+	err = db.Pool.QueryRow(ctx, query, strings.TrimSpace(req.URL), req.EventTypes, nullString(req.Description), active, nullString(strings.TrimSpace(req.Secret)), id).Scan(
+		&sub.ID, &sub.URL, &sub.EventTypes, &sub.Description, &sub.Active, &sub.CreatedAt, &sub.UpdatedAt,
+	)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusNotFound, "Webhook subscription not found")
+	}
+
+	return c.JSON(sub)
+}
+
+// DeleteWebhookSubscriptionHandler handles DELETE /api/admin/webhooks/:id
+func DeleteWebhookSubscriptionHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid subscription ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := db.Pool.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to delete webhook subscription")
+	}
+
+	if result.RowsAffected() == 0 {
+		return apierror.Send(c, fiber.StatusNotFound, "Webhook subscription not found")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetWebhookDeliveriesHandler handles GET /api/admin/webhooks/:id/deliveries?status=failed&limit=100
+// Returns the delivery log for a subscription, newest first
+func GetWebhookDeliveriesHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid subscription ID")
+	}
+
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > 1000 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Limit must be between 1 and 1000")
+	}
+	status := c.Query("status")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT id, event_id, event_type, status, attempts, response_code, response_body, error_message, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE subscription_id = $1
+		  AND ($2 = '' OR status = $2)
+		ORDER BY id DESC
+		LIMIT $3
+	`
+	rows, err := db.Pool.Query(ctx, query, id, status, limit)
+	if err != nil {
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch webhook deliveries")
+	}
+	defer rows.Close()
+
+	type WebhookDelivery struct {
+		ID           int        `json:"id"`
+		EventID      string     `json:"event_id"`
+		EventType    string     `json:"event_type"`
+		Status       string     `json:"status"`
+		Attempts     int        `json:"attempts"`
+		ResponseCode *int       `json:"response_code"`
+		ResponseBody *string    `json:"response_body"`
+		ErrorMessage *string    `json:"error_message"`
+		CreatedAt    time.Time  `json:"created_at"`
+		DeliveredAt  *time.Time `json:"delivered_at"`
+	}
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &d.ResponseCode, &d.ResponseBody, &d.ErrorMessage, &d.CreatedAt, &d.DeliveredAt); err != nil {
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return c.JSON(fiber.Map{
+		"count":      len(deliveries),
+		"deliveries": deliveries,
+	})
+}
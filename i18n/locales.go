@@ -0,0 +1,66 @@
+// Package i18n holds the languages participants can choose: the locale stored on each
+// student, localized variants of the emails and the labels of the quiz UI.
+package i18n
+
+import (
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Locales are the supported languages, each with built-in UI strings
+var Locales = []string{"en", "hi", "fr", "es"}
+
+// fallbackLocale is the default when DEFAULT_LOCALE is unset or not supported
+const fallbackLocale = "en"
+
+// Default is the locale of students without one (DEFAULT_LOCALE, default en). The built-in
+// emails are written in English whatever it is set to.
+func Default() string {
+	value := os.Getenv("DEFAULT_LOCALE")
+	if strings.TrimSpace(value) == "" {
+		return fallbackLocale
+	}
+	locale, ok := Normalize(value)
+	if !ok {
+		log.Warn().Msgf("Invalid DEFAULT_LOCALE=%q, using %s", value, fallbackLocale)
+		return fallbackLocale
+	}
+	return locale
+}
+
+// Normalize maps a language tag such as "fr-FR", "fr_CA" or "FR" to a supported locale.
+// ok is false for a language that is not supported.
+func Normalize(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if slices.Contains(Locales, tag) {
+		return tag, true
+	}
+	return "", false
+}
+
+// Resolve is the locale to use for a stored locale, which may be empty or no longer supported
+func Resolve(locale string) string {
+	if normalized, ok := Normalize(locale); ok {
+		return normalized
+	}
+	return Default()
+}
+
+// FromAcceptLanguage picks the first supported language of an Accept-Language header,
+// e.g. "fr-CH, fr;q=0.9, en;q=0.8". Browsers list languages by preference, so the
+// q-values are not compared. ok is false when none is supported.
+func FromAcceptLanguage(header string) (string, bool) {
+	for _, part := range strings.Split(header, ",") {
+		tag, _, _ := strings.Cut(part, ";")
+		if locale, ok := Normalize(tag); ok {
+			return locale, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,230 @@
+package i18n
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"regexp"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrInvalidStringKey is returned for a UI string key outside the catalog's shape
+var ErrInvalidStringKey = errors.New("string keys must be 1-100 lowercase letters, digits, '.', '-' or '_'")
+
+var stringKeyPattern = regexp.MustCompile(`^[a-z0-9_.-]{1,100}$`)
+
+// catalog holds the built-in UI strings by locale. Section names are not built in, since
+// they come from the questions file; store them as overrides under "section.<id>.name".
+var catalog = map[string]map[string]string{
+	"en": {
+		"app.title":              "International Online Quiz on Cooperatives",
+		"login.title":            "Start your test",
+		"login.access_code":      "Access code",
+		"login.start":            "Start test",
+		"exam.section":           "Section",
+		"exam.question":          "Question",
+		"exam.of":                "of",
+		"exam.time_remaining":    "Time remaining",
+		"exam.previous":          "Previous",
+		"exam.next":              "Next",
+		"exam.submit_answer":     "Submit answer",
+		"exam.finish_section":    "Finish section",
+		"exam.end_test":          "End test",
+		"exam.end_test_confirm":  "Are you sure you want to end the test? You cannot change your answers afterwards.",
+		"exam.time_up":           "Time is up for this section.",
+		"exam.answer_saved":      "Answer saved",
+		"exam.paused":            "The exam is paused. Please wait.",
+		"results.title":          "Your results",
+		"results.score":          "Score",
+		"results.rank":           "Rank",
+		"results.time_taken":     "Time taken",
+		"results.your_answer":    "Your answer",
+		"results.correct_answer": "Correct answer",
+		"results.certificate":    "Download certificate",
+		"instructions.title":     "Instructions",
+		"instructions.1":         "The quiz is divided into sections, each with its own time limit. When the time runs out the section ends.",
+		"instructions.2":         "Choose one answer for each question. Your answers are saved as you go.",
+		"instructions.3":         "Do not refresh or close the page during the quiz.",
+		"instructions.4":         "The winner is the participant with the highest score; in case of a tie, the faster completion time wins.",
+		"instructions.5":         "After the quiz you can review your answers, the correct answers and your score.",
+	},
+	"hi": {
+		"app.title":              "सहकारिता पर अंतरराष्ट्रीय ऑनलाइन प्रश्नोत्तरी",
+		"login.title":            "अपनी परीक्षा शुरू करें",
+		"login.access_code":      "एक्सेस कोड",
+		"login.start":            "परीक्षा शुरू करें",
+		"exam.section":           "खंड",
+		"exam.question":          "प्रश्न",
+		"exam.of":                "/",
+		"exam.time_remaining":    "शेष समय",
+		"exam.previous":          "पिछला",
+		"exam.next":              "अगला",
+		"exam.submit_answer":     "उत्तर जमा करें",
+		"exam.finish_section":    "खंड समाप्त करें",
+		"exam.end_test":          "परीक्षा समाप्त करें",
+		"exam.end_test_confirm":  "क्या आप वाकई परीक्षा समाप्त करना चाहते हैं? इसके बाद आप अपने उत्तर नहीं बदल सकेंगे।",
+		"exam.time_up":           "इस खंड का समय समाप्त हो गया है।",
+		"exam.answer_saved":      "उत्तर सहेजा गया",
+		"exam.paused":            "परीक्षा रोकी गई है। कृपया प्रतीक्षा करें।",
+		"results.title":          "आपका परिणाम",
+		"results.score":          "अंक",
+		"results.rank":           "रैंक",
+		"results.time_taken":     "लिया गया समय",
+		"results.your_answer":    "आपका उत्तर",
+		"results.correct_answer": "सही उत्तर",
+		"results.certificate":    "प्रमाणपत्र डाउनलोड करें",
+		"instructions.title":     "निर्देश",
+		"instructions.1":         "प्रश्नोत्तरी खंडों में बँटी है और प्रत्येक खंड की अपनी समय सीमा है। समय समाप्त होने पर खंड समाप्त हो जाता है।",
+		"instructions.2":         "प्रत्येक प्रश्न के लिए एक उत्तर चुनें। आपके उत्तर साथ-साथ सहेजे जाते हैं।",
+		"instructions.3":         "प्रश्नोत्तरी के दौरान पेज को रीफ़्रेश या बंद न करें।",
+		"instructions.4":         "सबसे अधिक अंक पाने वाला प्रतिभागी विजेता होगा; अंक बराबर होने पर कम समय में पूरा करने वाले को प्राथमिकता दी जाएगी।",
+		"instructions.5":         "प्रश्नोत्तरी के बाद आप अपने उत्तर, सही उत्तर और अपने अंक देख सकते हैं।",
+	},
+	"fr": {
+		"app.title":              "Quiz international en ligne sur les coopératives",
+		"login.title":            "Commencer votre test",
+		"login.access_code":      "Code d'accès",
+		"login.start":            "Commencer le test",
+		"exam.section":           "Section",
+		"exam.question":          "Question",
+		"exam.of":                "sur",
+		"exam.time_remaining":    "Temps restant",
+		"exam.previous":          "Précédent",
+		"exam.next":              "Suivant",
+		"exam.submit_answer":     "Valider la réponse",
+		"exam.finish_section":    "Terminer la section",
+		"exam.end_test":          "Terminer le test",
+		"exam.end_test_confirm":  "Voulez-vous vraiment terminer le test ? Vous ne pourrez plus modifier vos réponses.",
+		"exam.time_up":           "Le temps imparti pour cette section est écoulé.",
+		"exam.answer_saved":      "Réponse enregistrée",
+		"exam.paused":            "L'examen est en pause. Veuillez patienter.",
+		"results.title":          "Vos résultats",
+		"results.score":          "Score",
+		"results.rank":           "Classement",
+		"results.time_taken":     "Temps mis",
+		"results.your_answer":    "Votre réponse",
+		"results.correct_answer": "Bonne réponse",
+		"results.certificate":    "Télécharger le certificat",
+		"instructions.title":     "Consignes",
+		"instructions.1":         "Le quiz est divisé en sections, chacune avec sa propre limite de temps. Lorsque le temps est écoulé, la section se termine.",
+		"instructions.2":         "Choisissez une réponse pour chaque question. Vos réponses sont enregistrées au fur et à mesure.",
+		"instructions.3":         "N'actualisez pas et ne fermez pas la page pendant le quiz.",
+		"instructions.4":         "Le gagnant est le participant ayant le meilleur score ; en cas d'égalité, le temps le plus court l'emporte.",
+		"instructions.5":         "À la fin du quiz, vous pouvez consulter vos réponses, les bonnes réponses et votre score.",
+	},
+	"es": {
+		"app.title":              "Concurso internacional en línea sobre cooperativas",
+		"login.title":            "Comience su prueba",
+		"login.access_code":      "Código de acceso",
+		"login.start":            "Comenzar la prueba",
+		"exam.section":           "Sección",
+		"exam.question":          "Pregunta",
+		"exam.of":                "de",
+		"exam.time_remaining":    "Tiempo restante",
+		"exam.previous":          "Anterior",
+		"exam.next":              "Siguiente",
+		"exam.submit_answer":     "Enviar respuesta",
+		"exam.finish_section":    "Terminar sección",
+		"exam.end_test":          "Terminar la prueba",
+		"exam.end_test_confirm":  "¿Seguro que desea terminar la prueba? Después no podrá cambiar sus respuestas.",
+		"exam.time_up":           "Se acabó el tiempo de esta sección.",
+		"exam.answer_saved":      "Respuesta guardada",
+		"exam.paused":            "El examen está en pausa. Espere, por favor.",
+		"results.title":          "Sus resultados",
+		"results.score":          "Puntuación",
+		"results.rank":           "Posición",
+		"results.time_taken":     "Tiempo empleado",
+		"results.your_answer":    "Su respuesta",
+		"results.correct_answer": "Respuesta correcta",
+		"results.certificate":    "Descargar certificado",
+		"instructions.title":     "Instrucciones",
+		"instructions.1":         "El concurso se divide en secciones, cada una con su propio límite de tiempo. Cuando se agota el tiempo, la sección termina.",
+		"instructions.2":         "Elija una respuesta para cada pregunta. Sus respuestas se guardan a medida que avanza.",
+		"instructions.3":         "No actualice ni cierre la página durante el concurso.",
+		"instructions.4":         "Gana el participante con la puntuación más alta; en caso de empate, gana quien termine en menos tiempo.",
+		"instructions.5":         "Al terminar, podrá revisar sus respuestas, las respuestas correctas y su puntuación.",
+	},
+}
+
+// SectionNameKey is the UI string holding a section's localized name
+func SectionNameKey(sectionID int) string {
+	return fmt.Sprintf("section.%d.name", sectionID)
+}
+
+// Overrides returns the stored UI strings of a locale
+func Overrides(ctx context.Context, locale string) (map[string]string, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT key, value FROM ui_strings WHERE locale = $1`, locale)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch UI strings: %w", err)
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan UI string: %w", err)
+		}
+		overrides[key] = value
+	}
+	return overrides, rows.Err()
+}
+
+// Strings returns every UI string in the locale. Each key falls back from the locale's
+// override to its built-in string, then to the default locale's, then to English.
+func Strings(ctx context.Context, locale string) (map[string]string, error) {
+	layers := []string{fallbackLocale, Default(), locale}
+	merged := make(map[string]string)
+	for i, layer := range layers {
+		if i > 0 && layer == layers[i-1] {
+			continue
+		}
+		for key, value := range catalog[layer] {
+			merged[key] = value
+		}
+		overrides, err := Overrides(ctx, layer)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range overrides {
+			merged[key] = value
+		}
+	}
+	return merged, nil
+}
+
+// SetStrings stores overrides of a locale's UI strings in one transaction. An empty value
+// removes the override, so the built-in string or fallback applies again.
+func SetStrings(ctx context.Context, locale string, values map[string]string) error {
+	locale, ok := Normalize(locale)
+	if !ok {
+		return ErrUnsupportedLocale
+	}
+	for key := range values {
+		if !stringKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: %q", ErrInvalidStringKey, key)
+		}
+	}
+
+	return db.WithTx(ctx, func(tx pgx.Tx) error {
+		for key, value := range values {
+			if value == "" {
+				if _, err := tx.Exec(ctx, `DELETE FROM ui_strings WHERE locale = $1 AND key = $2`, locale, key); err != nil {
+					return err
+				}
+				continue
+			}
+			query := `
+				INSERT INTO ui_strings (locale, key, value)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (locale, key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()
+			`
+			if _, err := tx.Exec(ctx, query, locale, key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,258 @@
+package i18n
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"mcq-exam/tracking"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// Keys of the built-in emails, with the {{placeholders}} their stored variants may use
+const (
+	// TemplateFirstMail is the conference invitation: {{name}}, {{link}}
+	TemplateFirstMail = string(tracking.FirstMail)
+	// TemplateSecondMail is the test invitation: {{name}}, {{link}}, {{access_code}}
+	TemplateSecondMail = string(tracking.SecondMail)
+	// TemplateRegistration is the registration confirmation: {{name}}, {{link}}, {{hours}}
+	TemplateRegistration = "registration"
+	// TemplateReminder is a conference reminder sent with the default text: {{name}},
+	// {{starts_in}}, {{starts_at}}
+	TemplateReminder = "reminder"
+	// TemplateResults is the results email sent with the default text: the scorecard
+	// placeholders (see campaigns.RenderScorecard)
+	TemplateResults = "results"
+	// TemplateEmailChange is the link confirming a participant's new address, sent to it:
+	// {{name}}, {{link}}, {{hours}}, {{new_email}}
+	TemplateEmailChange = "email_change"
+	// TemplateEmailChangeNotice tells the current address that a change was requested:
+	// {{name}}, {{new_email}}
+	TemplateEmailChangeNotice = "email_change_notice"
+)
+
+// BuiltinTemplates lists the keys the server looks up on its own; other keys are only
+// used when a campaign or send-all copies them with template_key
+var BuiltinTemplates = []string{TemplateFirstMail, TemplateSecondMail, TemplateRegistration, TemplateReminder, TemplateResults,
+	TemplateEmailChange, TemplateEmailChangeNotice}
+
+// builtinPlaceholders are the {{placeholders}} filled in for each built-in template
+var builtinPlaceholders = map[string][]string{
+	TemplateFirstMail:    {"name", "link"},
+	TemplateSecondMail:   {"name", "link", "access_code"},
+	TemplateRegistration: {"name", "link", "hours"},
+	TemplateReminder:     {"name", "starts_in", "starts_at"},
+	TemplateResults: {"name", "score", "max_score", "rank", "participants", "time_taken", "sections",
+		"certificate", "certificate_url", "certificate_type", "results_link", "results_url"},
+	TemplateEmailChange:       {"name", "link", "hours", "new_email"},
+	TemplateEmailChangeNotice: {"name", "new_email"},
+}
+
+// Placeholders lists the {{placeholders}} filled in for a template key. Other templates are
+// sent by a campaign or send-all, which fill in {{name}}.
+func Placeholders(key string) []string {
+	if placeholders, ok := builtinPlaceholders[key]; ok {
+		return placeholders
+	}
+	return []string{"name"}
+}
+
+var (
+	ErrNotFound          = errors.New("template not found")
+	ErrInvalidKey        = errors.New("template key must be 1-50 letters, digits, '-' or '_'")
+	ErrUnsupportedLocale = fmt.Errorf("locale must be one of: %s", strings.Join(Locales, ", "))
+	ErrIncompleteVariant = errors.New("each variant needs a subject and html_body")
+)
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,50}$`)
+
+// Template is the text of an email in one locale
+type Template struct {
+	ID        int       `json:"id"`
+	Key       string    `json:"template_key"`
+	Locale    string    `json:"locale"`
+	Subject   string    `json:"subject"`
+	HTMLBody  string    `json:"html_body"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Variant is the subject and body of a campaign or send-all in one locale
+type Variant struct {
+	Subject  string `json:"subject"`
+	HTMLBody string `json:"html_body"`
+}
+
+// Variants are the subject and body of a campaign or send-all by locale
+type Variants map[string]Variant
+
+// ValidKey reports whether key can name a template
+func ValidKey(key string) bool {
+	return keyPattern.MatchString(key)
+}
+
+const templateColumns = `id, template_key, locale, subject, html_body, created_at, updated_at`
+
+func scanTemplate(row pgx.Row, t *Template) error {
+	return row.Scan(&t.ID, &t.Key, &t.Locale, &t.Subject, &t.HTMLBody, &t.CreatedAt, &t.UpdatedAt)
+}
+
+// List returns the stored templates, of one key or of all when key is empty
+func List(ctx context.Context, key string) ([]Template, error) {
+	query := `SELECT ` + templateColumns + ` FROM email_templates WHERE $1 = '' OR template_key = $1 ORDER BY template_key, locale`
+	rows, err := db.Pool.Query(ctx, query, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch templates: %w", err)
+	}
+	defer rows.Close()
+
+	templates := []Template{}
+	for rows.Next() {
+		var t Template
+		if err := scanTemplate(rows, &t); err != nil {
+			return nil, fmt.Errorf("failed to scan template: %w", err)
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// Put creates or replaces the template's text in a locale
+func Put(ctx context.Context, key, locale, subject, htmlBody string) (*Template, error) {
+	if !ValidKey(key) {
+		return nil, ErrInvalidKey
+	}
+	locale, ok := Normalize(locale)
+	if !ok {
+		return nil, ErrUnsupportedLocale
+	}
+
+	var t Template
+	query := `
+		INSERT INTO email_templates (template_key, locale, subject, html_body)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (template_key, locale)
+		DO UPDATE SET subject = EXCLUDED.subject, html_body = EXCLUDED.html_body, updated_at = NOW()
+		RETURNING ` + templateColumns
+	if err := scanTemplate(db.Pool.QueryRow(ctx, query, key, locale, subject, htmlBody), &t); err != nil {
+		return nil, fmt.Errorf("failed to save template: %w", err)
+	}
+	return &t, nil
+}
+
+// Delete removes the template's text in a locale
+func Delete(ctx context.Context, key, locale string) error {
+	locale, _ = Normalize(locale)
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM email_templates WHERE template_key = $1 AND locale = $2`, key, locale)
+	if err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TemplateVariants returns the template's text by locale, for a campaign or send-all to copy
+func TemplateVariants(ctx context.Context, key string) (Variants, error) {
+	templates, err := List(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	variants := make(Variants, len(templates))
+	for _, t := range templates {
+		variants[t.Locale] = Variant{Subject: t.Subject, HTMLBody: t.HTMLBody}
+	}
+	return variants, nil
+}
+
+// BuildVariants copies the stored variants of templateKey, when not empty, and lays the
+// given variants over them. Locale keys are normalized, so "fr-FR" becomes "fr".
+func BuildVariants(ctx context.Context, templateKey string, given Variants) (Variants, error) {
+	variants := Variants{}
+	if templateKey != "" {
+		if !ValidKey(templateKey) {
+			return nil, ErrInvalidKey
+		}
+		stored, err := TemplateVariants(ctx, templateKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(stored) == 0 {
+			return nil, ErrNotFound
+		}
+		variants = stored
+	}
+	for tag, v := range given {
+		locale, ok := Normalize(tag)
+		if !ok {
+			return nil, fmt.Errorf("%w (got %q)", ErrUnsupportedLocale, tag)
+		}
+		if strings.TrimSpace(v.Subject) == "" || strings.TrimSpace(v.HTMLBody) == "" {
+			return nil, fmt.Errorf("%w (%s)", ErrIncompleteVariant, locale)
+		}
+		variants[locale] = v
+	}
+	return variants, nil
+}
+
+// Pick returns the variant for a recipient's stored locale (empty for the default locale),
+// or the base subject and body when there is none
+func Pick(variants Variants, locale, subject, htmlBody string) (string, string) {
+	if v, ok := variants[Resolve(locale)]; ok {
+		return v.Subject, v.HTMLBody
+	}
+	return subject, htmlBody
+}
+
+// StudentLocale is the student's locale, or the default locale when none is set
+func StudentLocale(ctx context.Context, studentID int) string {
+	var locale string
+	err := db.Pool.QueryRow(ctx, `SELECT COALESCE(locale, '') FROM students WHERE id = $1`, studentID).Scan(&locale)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Warn().Err(err).Int("student_id", studentID).Msg("Failed to load student locale")
+	}
+	return Resolve(locale)
+}
+
+// Localize returns a built-in email in the locale: the stored template for the locale, or
+// else for the default locale, with each {{var}} filled in. Without a stored template, or
+// when the lookup fails (logged), the caller's English subject and body are returned as is.
+func Localize(ctx context.Context, key, locale, subject, htmlBody string, vars map[string]string) (string, string) {
+	var t Template
+	query := `
+		SELECT ` + templateColumns + `
+		FROM email_templates
+		WHERE template_key = $1 AND locale IN ($2, $3)
+		ORDER BY locale = $2 DESC
+		LIMIT 1
+	`
+	err := scanTemplate(db.Pool.QueryRow(ctx, query, key, Resolve(locale), Default()), &t)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return subject, htmlBody
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("template_key", key).Str("locale", locale).Msg("Failed to load email template, sending the built-in one")
+		return subject, htmlBody
+	}
+	return Render(t.Subject, vars), Render(t.HTMLBody, vars)
+}
+
+// ForStudent is Localize in the student's locale
+func ForStudent(ctx context.Context, key string, studentID int, subject, htmlBody string, vars map[string]string) (string, string) {
+	return Localize(ctx, key, StudentLocale(ctx, studentID), subject, htmlBody, vars)
+}
+
+// Render replaces each {{var}} in text with its value
+func Render(text string, vars map[string]string) string {
+	pairs := make([]string, 0, 2*len(vars))
+	for name, value := range vars {
+		pairs = append(pairs, "{{"+name+"}}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
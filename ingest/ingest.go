@@ -0,0 +1,301 @@
+// Package ingest writes submitted answers. Directly, each answer is its own INSERT. With
+// ANSWER_INGEST=batch, answers from all requests are queued and written together every
+// ANSWER_BATCH_INTERVAL_MS (or once ANSWER_BATCH_SIZE are queued) with one COPY, so peak
+// submit rates cost one round trip per batch instead of one per answer. A request still
+// waits for its batch to commit, so an answer acknowledged to the candidate is stored.
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"mcq-exam/db"
+	"mcq-exam/jobs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// Ingest modes (ANSWER_INGEST)
+const (
+	ModeDirect = "direct"
+	ModeBatch  = "batch"
+)
+
+const (
+	defaultInterval = 50 * time.Millisecond
+	defaultSize     = 500
+	// flushTimeout bounds one batch's transaction, including the last one at shutdown
+	flushTimeout = 5 * time.Second
+)
+
+// Answer is a graded answer ready to be stored
+type Answer struct {
+	SessionID  int
+	QuestionID int
+	// Option is the bank's index of the selected option
+	Option     int
+	Correct    bool
+	TimeTaken  int
+	ClientTime int
+}
+
+// Mode is how answers are written (ANSWER_INGEST, default direct)
+func Mode() string {
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("ANSWER_INGEST")), ModeBatch) {
+		return ModeBatch
+	}
+	return ModeDirect
+}
+
+// Interval is how long answers wait for their batch (ANSWER_BATCH_INTERVAL_MS, default 50)
+func Interval() time.Duration {
+	if value := strings.TrimSpace(os.Getenv("ANSWER_BATCH_INTERVAL_MS")); value != "" {
+		if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+		log.Warn().Msgf("Invalid ANSWER_BATCH_INTERVAL_MS=%q, using %d", value, defaultInterval.Milliseconds())
+	}
+	return defaultInterval
+}
+
+// Size is how many queued answers flush a batch early (ANSWER_BATCH_SIZE, default 500)
+func Size() int {
+	if value := strings.TrimSpace(os.Getenv("ANSWER_BATCH_SIZE")); value != "" {
+		if size, err := strconv.Atoi(value); err == nil && size > 0 {
+			return size
+		}
+		log.Warn().Msgf("Invalid ANSWER_BATCH_SIZE=%q, using %d", value, defaultSize)
+	}
+	return defaultSize
+}
+
+// shared is the server's batcher while ANSWER_INGEST=batch
+var shared atomic.Pointer[Batcher]
+
+// Start starts the server's batcher when ANSWER_INGEST=batch. It stops with the server,
+// writing what is still queued.
+func Start() {
+	if Mode() != ModeBatch {
+		return
+	}
+	b := New(db.Pool, Interval(), Size())
+	shared.Store(b)
+	log.Info().Dur("interval", b.interval).Int("size", b.size).Msg("Starting batched answer ingest")
+	jobs.Go(b.Run)
+}
+
+// Insert stores an answer unless the session has one for the question already, reporting
+// whether it was stored. It uses the server's batcher when one is running.
+func Insert(ctx context.Context, a Answer) (bool, error) {
+	if b := shared.Load(); b != nil {
+		return b.Insert(ctx, a)
+	}
+	return InsertDirect(ctx, db.Pool, a)
+}
+
+// InsertDirect stores one answer with its own INSERT, as Insert does without a batcher
+func InsertDirect(ctx context.Context, pool *pgxpool.Pool, a Answer) (bool, error) {
+	query := `
+		INSERT INTO answers (session_id, question_id, selected_option_index, is_correct, time_taken_seconds, client_time_taken_seconds)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (session_id, question_id) DO NOTHING
+	`
+	result, err := pool.Exec(ctx, query, a.SessionID, a.QuestionID, a.Option, a.Correct, a.TimeTaken, a.ClientTime)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// outcome is the result of one queued answer
+type outcome struct {
+	inserted bool
+	err      error
+}
+
+type request struct {
+	answer Answer
+	done   chan outcome
+}
+
+// Batcher queues answers and writes them in batches against one pool
+type Batcher struct {
+	pool     *pgxpool.Pool
+	interval time.Duration
+	size     int
+	requests chan request
+
+	// mu guards closed, so nothing is sent on requests once it is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New returns a batcher flushing every interval or at size answers. It takes answers once
+// Run is started.
+func New(pool *pgxpool.Pool, interval time.Duration, size int) *Batcher {
+	return &Batcher{
+		pool:     pool,
+		interval: interval,
+		size:     size,
+		requests: make(chan request, size*4),
+	}
+}
+
+// Insert queues an answer and waits for its batch, reporting whether it was stored. The
+// first of several answers to one question in a batch wins, as with concurrent INSERTs.
+// Once the batcher has stopped, answers are written directly.
+func (b *Batcher) Insert(ctx context.Context, a Answer) (bool, error) {
+	req := request{answer: a, done: make(chan outcome, 1)}
+
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return InsertDirect(ctx, b.pool, a)
+	}
+	select {
+	case b.requests <- req:
+	case <-ctx.Done():
+		b.mu.RUnlock()
+		return false, ctx.Err()
+	}
+	b.mu.RUnlock()
+
+	select {
+	case out := <-req.done:
+		return out.inserted, out.err
+	case <-ctx.Done():
+		// The answer may still be written with its batch
+		return false, ctx.Err()
+	}
+}
+
+// Run writes queued answers until ctx is done, then writes what is left and returns
+func (b *Batcher) Run(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		b.closed = true
+		close(b.requests)
+		b.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	pending := make([]request, 0, b.size)
+	for {
+		select {
+		case req, ok := <-b.requests:
+			if !ok {
+				b.flush(pending)
+				return
+			}
+			pending = append(pending, req)
+			if len(pending) >= b.size {
+				b.flush(pending)
+				pending = pending[:0]
+			}
+		case <-ticker.C:
+			if len(pending) > 0 {
+				b.flush(pending)
+				pending = pending[:0]
+			}
+		}
+	}
+}
+
+// flush writes a batch and answers its requests. If the batch fails as a whole, such as
+// for one answer of a session deleted meanwhile, its answers are retried one by one.
+func (b *Batcher) flush(batch []request) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	inserted, err := b.write(ctx, batch)
+	if err != nil {
+		log.Warn().Err(err).Int("answers", len(batch)).Msg("Answer batch failed, writing its answers one by one")
+		for _, req := range batch {
+			ok, err := InsertDirect(ctx, b.pool, req.answer)
+			req.done <- outcome{inserted: ok, err: err}
+		}
+		return
+	}
+	for i, req := range batch {
+		req.done <- outcome{inserted: inserted[i]}
+	}
+}
+
+// write copies a batch into a temporary table and moves it into answers, returning which
+// requests were stored
+func (b *Batcher) write(ctx context.Context, batch []request) ([]bool, error) {
+	rows := make([][]any, len(batch))
+	for i, req := range batch {
+		a := req.answer
+		rows[i] = []any{i, a.SessionID, a.QuestionID, a.Option, a.Correct, a.TimeTaken, a.ClientTime}
+	}
+
+	type key struct{ session, question int }
+	stored := make(map[key]bool, len(batch))
+	err := db.WithTxIn(ctx, b.pool, func(tx pgx.Tx) error {
+		// Kept per connection and emptied at commit, so batches do not churn the catalog
+		_, err := tx.Exec(ctx, `
+			CREATE TEMP TABLE IF NOT EXISTS answer_batch (
+				seq INT, session_id INT, question_id INT, selected_option_index INT,
+				is_correct BOOLEAN, time_taken_seconds INT, client_time_taken_seconds INT
+			) ON COMMIT DELETE ROWS
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to prepare answer batch: %w", err)
+		}
+		columns := []string{"seq", "session_id", "question_id", "selected_option_index", "is_correct",
+			"time_taken_seconds", "client_time_taken_seconds"}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"answer_batch"}, columns, pgx.CopyFromRows(rows)); err != nil {
+			return fmt.Errorf("failed to copy answer batch: %w", err)
+		}
+
+		result, err := tx.Query(ctx, `
+			INSERT INTO answers (session_id, question_id, selected_option_index, is_correct, time_taken_seconds, client_time_taken_seconds)
+			SELECT DISTINCT ON (session_id, question_id)
+			       session_id, question_id, selected_option_index, is_correct, time_taken_seconds, client_time_taken_seconds
+			FROM answer_batch
+			ORDER BY session_id, question_id, seq
+			ON CONFLICT (session_id, question_id) DO NOTHING
+			RETURNING session_id, question_id
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to store answer batch: %w", err)
+		}
+		defer result.Close()
+		for result.Next() {
+			var k key
+			if err := result.Scan(&k.session, &k.question); err != nil {
+				return fmt.Errorf("failed to store answer batch: %w", err)
+			}
+			stored[k] = true
+		}
+		return result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the first request for a stored question was the one stored
+	inserted := make([]bool, len(batch))
+	for i, req := range batch {
+		k := key{req.answer.SessionID, req.answer.QuestionID}
+		if stored[k] {
+			inserted[i] = true
+			delete(stored, k)
+		}
+	}
+	return inserted, nil
+}
@@ -0,0 +1,78 @@
+// Package instance identifies this server process and tracks where it is in its lifecycle, so
+// replicas behind a load balancer can be told apart in logs and metrics and are only sent
+// traffic while they can serve it.
+package instance
+
+import (
+	"mcq-exam/metrics"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Lifecycle phases, reported by GET /health/ready
+const (
+	// PhaseStarting is waiting for the schema to be migrated; readiness fails
+	PhaseStarting = "starting"
+	// PhaseServing is ready for traffic
+	PhaseServing = "serving"
+	// PhaseDraining received SIGTERM: readiness fails and keep-alive connections are closed
+	// while the load balancer stops routing here and in-flight requests finish
+	PhaseDraining = "draining"
+)
+
+var (
+	idOnce sync.Once
+	id     string
+
+	mu    sync.RWMutex
+	phase = PhaseStarting
+)
+
+// ID names this process: INSTANCE_ID when set, otherwise the hostname (the pod name on
+// Kubernetes, the container ID on Docker)
+func ID() string {
+	idOnce.Do(func() {
+		id = strings.TrimSpace(os.Getenv("INSTANCE_ID"))
+		if id == "" {
+			id, _ = os.Hostname()
+		}
+		if id == "" {
+			id = "unknown"
+		}
+		metrics.InstanceInfo.WithLabelValues(id).Set(1)
+	})
+	return id
+}
+
+// Phase returns the current lifecycle phase
+func Phase() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return phase
+}
+
+// SetPhase moves the process to p. A draining process never goes back to serving.
+func SetPhase(p string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if phase == PhaseDraining {
+		return
+	}
+	phase = p
+	if p == PhaseServing {
+		metrics.InstanceReady.Set(1)
+	} else {
+		metrics.InstanceReady.Set(0)
+	}
+}
+
+// Serving reports whether the process should receive traffic
+func Serving() bool {
+	return Phase() == PhaseServing
+}
+
+// Draining reports whether the process is shutting down
+func Draining() bool {
+	return Phase() == PhaseDraining
+}
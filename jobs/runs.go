@@ -0,0 +1,148 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"mcq-exam/db"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	StatusRunning     = "running"
+	StatusCompleted   = "completed"
+	StatusInterrupted = "interrupted"
+	StatusFailed      = "failed"
+)
+
+// Run tracks the progress of one execution of a scheduled job. Jobs process students in
+// ascending ID order and record each one, so an interrupted or failed run resumes after LastStudentID.
+type Run struct {
+	ID           int
+	JobID        int
+	FunctionName string
+	// Payload is the job's JSON payload, for functions that take parameters
+	Payload       json.RawMessage
+	Total         int
+	Processed     int
+	Sent          int
+	LastStudentID int
+}
+
+// MarkStaleRuns flags runs left "running" by a previous process (crash or kill -9)
+// as interrupted so the scheduler resumes them
+func MarkStaleRuns(ctx context.Context) error {
+	query := `
+		UPDATE job_runs
+		SET status = 'interrupted', updated_at = NOW()
+		WHERE status = 'running'
+	`
+	result, err := db.Pool.Exec(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() > 0 {
+		log.Info().Int64("runs", result.RowsAffected()).Msg("Job runs left running by a previous process marked as interrupted")
+	}
+	return nil
+}
+
+// StartOrResume continues the job's latest run if it was interrupted or failed, or records a new run.
+// A jobID of 0 is a manual run outside any scheduled job, which always starts from the beginning.
+// If the database is unavailable an untracked run is returned so the job can still proceed.
+func StartOrResume(ctx context.Context, jobID int, functionName string, payload json.RawMessage) *Run {
+	run := &Run{JobID: jobID, FunctionName: functionName, Payload: payload}
+
+	resumeQuery := `
+		UPDATE job_runs
+		SET status = 'running', error = NULL, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM job_runs
+			WHERE job_id = $1
+			ORDER BY id DESC
+			LIMIT 1
+		)
+		  AND function_name = $2
+		  AND status IN ('interrupted', 'failed')
+		RETURNING id, total, processed, sent, last_student_id
+	`
+	err := db.Pool.QueryRow(ctx, resumeQuery, jobID, functionName).Scan(
+		&run.ID, &run.Total, &run.Processed, &run.Sent, &run.LastStudentID,
+	)
+	if err == nil {
+		log.Info().Str("function", functionName).Int("job_id", jobID).Int("run_id", run.ID).Int("last_student_id", run.LastStudentID).
+			Int("processed", run.Processed).Int("total", run.Total).Msg("Resuming job run")
+		return run
+	}
+
+	insertQuery := `
+		INSERT INTO job_runs (function_name, job_id, status)
+		VALUES ($1, NULLIF($2, 0), 'running')
+		RETURNING id
+	`
+	if err := db.Pool.QueryRow(ctx, insertQuery, functionName, jobID).Scan(&run.ID); err != nil {
+		log.Warn().Err(err).Str("function", functionName).Int("job_id", jobID).Msg("Failed to record job run, progress will not be saved")
+	}
+
+	return run
+}
+
+// SetRemaining sets the total from the number of students still to process
+func (r *Run) SetRemaining(remaining int) {
+	r.Total = r.Processed + remaining
+	r.save(StatusRunning, "")
+}
+
+// Record marks a student as processed. It is persisted immediately so a shutdown
+// at any point loses at most the send in progress.
+func (r *Run) Record(studentID int, sent bool) {
+	r.Processed++
+	if sent {
+		r.Sent++
+	}
+	r.LastStudentID = studentID
+	r.save(StatusRunning, "")
+}
+
+// Finish stores the final state of the run: interrupted if ctx was cancelled, failed if the
+// function returned an error, otherwise completed. Returns the status written.
+func (r *Run) Finish(ctx context.Context, runErr error) string {
+	status := StatusCompleted
+	errMsg := ""
+	if ctx.Err() != nil {
+		status = StatusInterrupted
+	} else if runErr != nil {
+		status = StatusFailed
+		errMsg = runErr.Error()
+	}
+	r.save(status, errMsg)
+	return status
+}
+
+func (r *Run) save(status, errMsg string) {
+	if r.ID == 0 {
+		return
+	}
+
+	// Use a fresh context: progress must be saved even while shutting down
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE job_runs
+		SET status = $1,
+		    total = $2,
+		    processed = $3,
+		    sent = $4,
+		    last_student_id = $5,
+		    error = NULLIF($6, ''),
+		    updated_at = NOW(),
+		    finished_at = CASE WHEN $1 IN ('completed', 'failed') THEN NOW() ELSE NULL END
+		WHERE id = $7
+	`
+	if _, err := db.Pool.Exec(ctx, query, status, r.Total, r.Processed, r.Sent, r.LastStudentID, errMsg, r.ID); err != nil {
+		log.Warn().Err(err).Int("run_id", r.ID).Msg("Failed to save job run progress")
+	}
+}
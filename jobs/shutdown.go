@@ -0,0 +1,89 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	rootCtx, cancelRoot = context.WithCancel(context.Background())
+
+	mu       sync.Mutex
+	stopping bool
+	inFlight sync.WaitGroup
+)
+
+// Context is cancelled when the server starts shutting down.
+// Long-running loops should stop at their next safe point once it is done.
+func Context() context.Context {
+	return rootCtx
+}
+
+// Go runs fn in a goroutine that Shutdown waits for. It is a no-op once shutdown has begun.
+func Go(fn func(ctx context.Context)) {
+	if !add() {
+		return
+	}
+
+	go func() {
+		defer inFlight.Done()
+		fn(rootCtx)
+	}()
+}
+
+// Track registers in-flight work running on the caller's goroutine (e.g. a bulk send
+// inside a request handler). Call the returned function when the work is finished.
+func Track() func() {
+	if !add() {
+		return func() {}
+	}
+	return inFlight.Done
+}
+
+func add() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if stopping {
+		return false
+	}
+	inFlight.Add(1)
+	return true
+}
+
+// Shutdown cancels Context and waits up to timeout for tracked work to finish.
+// Returns false if the timeout expired first.
+func Shutdown(timeout time.Duration) bool {
+	mu.Lock()
+	stopping = true
+	mu.Unlock()
+
+	cancelRoot()
+
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Sleep pauses for d, returning false early if ctx is cancelled
+func Sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
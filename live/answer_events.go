@@ -0,0 +1,79 @@
+package live
+
+import (
+	"context"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AnswerStatusError is recorded in answer_events when an answer could not be saved
+// because of a server error
+const AnswerStatusError = "error"
+
+// answerEvent is one answer submission attempt, as stored in answer_events
+type answerEvent struct {
+	QuestionID          int
+	SelectedOptionIndex int
+	ClientTimeTaken     int
+	Outcome             string
+	// Reason is the rejection or error message; empty for saved answers
+	Reason string
+	// PreviousOption is the answer an updated submission replaced, as stored in answers
+	PreviousOption *int
+}
+
+// recordAnswerEvents appends a session's submission attempts to the audit trail. A failure
+// is logged and does not change the response, so the audit never blocks answering.
+func recordAnswerEvents(ctx context.Context, c *fiber.Ctx, sessionID int, batch bool, events []answerEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	questionIDs := make([]int, len(events))
+	options := make([]int, len(events))
+	clientTimes := make([]int, len(events))
+	outcomes := make([]string, len(events))
+	reasons := make([]string, len(events))
+	previous := make([]*int, len(events))
+	for i, e := range events {
+		questionIDs[i], options[i], clientTimes[i] = e.QuestionID, e.SelectedOptionIndex, e.ClientTimeTaken
+		outcomes[i], reasons[i], previous[i] = e.Outcome, e.Reason, e.PreviousOption
+	}
+
+	query := `
+		INSERT INTO answer_events (session_id, question_id, selected_option_index, client_time_taken_seconds,
+		                           outcome, reason, previous_option_index, batch, ip, user_agent)
+		SELECT $1, t.question_id, t.selected_option_index, t.client_time, t.outcome, NULLIF(t.reason, ''), t.previous,
+		       $8, $9, $10
+		FROM unnest($2::int[], $3::int[], $4::int[], $5::text[], $6::text[], $7::int[])
+		     AS t(question_id, selected_option_index, client_time, outcome, reason, previous)
+	`
+	_, err := db.Pool.Exec(ctx, query, sessionID, questionIDs, options, clientTimes, outcomes, reasons, previous,
+		batch, c.IP(), c.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("events", len(events)).Msg("Failed to record answer events")
+	}
+}
+
+// answerOutcome is the answer_events outcome of a submit-answer response
+func answerOutcome(status int, resp SubmitAnswerResponse) string {
+	switch {
+	case status == fiber.StatusConflict:
+		return AnswerStatusConflict
+	case resp.Status != "":
+		return resp.Status
+	case status >= fiber.StatusInternalServerError:
+		return AnswerStatusError
+	}
+	return AnswerStatusRejected
+}
+
+// reasonFor is the answer_events reason of a submit-answer response
+func reasonFor(resp SubmitAnswerResponse) string {
+	if resp.Success {
+		return ""
+	}
+	return resp.Message
+}
@@ -0,0 +1,16 @@
+package live
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/exampause"
+)
+
+// errExamPaused rejects answers and end-session while organizers have paused the exam
+var errExamPaused = errors.New("Exam is paused; try again once it resumes")
+
+// examPaused reports whether organizers have paused the exam
+func examPaused(ctx context.Context) (bool, error) {
+	state, err := exampause.Current(ctx)
+	return state.Paused, err
+}
@@ -0,0 +1,115 @@
+package live
+
+import (
+	"context"
+	"errors"
+	"math"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/exampause"
+	"mcq-exam/examwindow"
+	"mcq-exam/logging"
+	"mcq-exam/presence"
+	"mcq-exam/sessioncache"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// GetExamWindowHandler handles GET /api/live/exam-window?token=<conference_token> or
+// ?timezone=Europe/London. Returns the participant's exam window and entry times, so the
+// page can count down to entry or explain that it has closed. The conference token's student
+// timezone is used when known, then the timezone given, then the event's own.
+func GetExamWindowHandler(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	schedule, err := examwindow.Latest(ctx)
+	if errors.Is(err, examwindow.ErrNoSchedule) {
+		return apierror.Send(c, fiber.StatusNotFound, "No exam scheduled")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to get scheduled time")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load exam window")
+	}
+
+	timezone := strings.TrimSpace(c.Query("timezone"))
+	if timezone != "" && examwindow.ValidTimezone(timezone) != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "timezone must be an IANA timezone, e.g. Asia/Kolkata")
+	}
+	if token := strings.TrimSpace(c.Query("token")); token != "" {
+		var studentTimezone string
+		query := `
+			SELECT COALESCE(s.timezone, '')
+			FROM email_tracking et
+			JOIN students s ON s.id = et.student_id
+			WHERE et.conference_token = $1 AND et.email_type = 'firstMail'
+		`
+		err := db.Pool.QueryRow(ctx, query, token).Scan(&studentTimezone)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to look up conference token")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load exam window")
+		}
+		if studentTimezone != "" {
+			timezone = studentTimezone
+		}
+	}
+
+	// Computed without storing, so unauthenticated lookups leave exam_windows alone
+	window := schedule.Compute(timezone)
+	entry := schedule.Entry(window)
+	now := time.Now()
+
+	response := fiber.Map{
+		"window_policy":       schedule.Policy,
+		"exam_window":         window,
+		"entry":               entry,
+		"status":              entry.Status(now),
+		"early_entry_minutes": schedule.EarlyEntryMinutes,
+		"late_entry_minutes":  schedule.LateEntryMinutes,
+		"server_time":         now.UTC(),
+	}
+	switch entry.Status(now) {
+	case examwindow.EntryNotOpen:
+		response["opens_in_seconds"] = int(math.Ceil(entry.OpensAt.Sub(now).Seconds()))
+	case examwindow.EntryOpen:
+		response["closes_in_seconds"] = int(entry.ClosesAt.Sub(now).Seconds())
+	}
+
+	// Under reduced time, those entering now finish with the on-time entrants
+	duration, timed, err := presence.TestDuration()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load test duration")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load exam window")
+	}
+	if timed {
+		response["test_duration_seconds"] = int(duration.Seconds())
+		if finishBy := schedule.FinishBy(window, now, duration); finishBy != nil {
+			response["finish_by"] = finishBy
+		}
+	}
+	return c.JSON(response)
+}
+
+// errTimeUp rejects answers once a late entrant's reduced time has run out
+var errTimeUp = errors.New("Test time expired")
+
+// timeUp reports whether the session's finish_by, pushed back by exam pauses, has passed.
+// Answers within timeLimitGrace of it still count, as at a section's time limit. Sessions
+// without finish_by are not limited here. finish_by comes from the session cache; pauses only
+// push it back, so the database is read only once the cached finish_by has passed.
+func timeUp(ctx context.Context, session *sessioncache.Session) (bool, error) {
+	if session.FinishBy == nil || time.Now().Before(session.FinishBy.Add(timeLimitGrace)) {
+		return false, nil
+	}
+	query := `
+		SELECT COALESCE(NOW() > s.finish_by + make_interval(secs => ` + exampause.PausedSeconds("s.started_at", "NOW()") + ` + $2), false)
+		FROM sessions s
+		WHERE s.id = $1
+	`
+	var up bool
+	err := db.Pool.QueryRow(ctx, query, session.ID, timeLimitGrace.Seconds()).Scan(&up)
+	return up, err
+}
@@ -3,25 +3,28 @@ package live
 import (
 	"context"
 	"crypto/rand"
-	"log"
+	"errors"
+	"fmt"
+	"math"
+	"mcq-exam/accesscodes"
+	"mcq-exam/apierror"
+	"mcq-exam/attempts"
+	"mcq-exam/clientaudit"
 	"mcq-exam/db"
+	"mcq-exam/events"
+	"mcq-exam/examwindow"
+	"mcq-exam/livemetrics"
+	"mcq-exam/logging"
+	"mcq-exam/presence"
+	"mcq-exam/sessioncache"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
 )
 
-// generateAccessCode generates a 6-character alphanumeric code
-func generateAccessCode() string {
-	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	code := make([]byte, 6)
-	randomBytes := make([]byte, 6)
-	rand.Read(randomBytes)
-	for i := range code {
-		code[i] = charset[int(randomBytes[i])%len(charset)]
-	}
-	return string(code)
-}
-
 // generateSessionToken generates a unique session token
 func generateSessionToken() string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -36,6 +39,8 @@ func generateSessionToken() string {
 
 type VerifyTokenRequest struct {
 	Token string `json:"token"`
+	// Timezone is the browser's IANA timezone, kept for students who did not give one
+	Timezone string `json:"timezone"`
 }
 
 type VerifyTokenResponse struct {
@@ -48,17 +53,11 @@ type VerifyTokenResponse struct {
 func VerifyFirstMailTokenHandler(c *fiber.Ctx) error {
 	var req VerifyTokenRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(VerifyTokenResponse{
-			Success: false,
-			Message: "Invalid request body",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
 	if req.Token == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(VerifyTokenResponse{
-			Success: false,
-			Message: "Token is required",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Token is required")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -74,30 +73,31 @@ func VerifyFirstMailTokenHandler(c *fiber.Ctx) error {
 	`
 	err := db.Pool.QueryRow(ctx, query, req.Token).Scan(&studentId, &attended)
 	if err != nil {
-		log.Printf("Token validation failed: %v", err)
-		return c.Status(fiber.StatusNotFound).JSON(VerifyTokenResponse{
-			Success: false,
-			Message: "Invalid or expired token",
-		})
+		logging.Ctx(c).Warn().Err(err).Msg("Token validation failed")
+		return apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeTokenInvalid, "Invalid or expired token")
+	}
+	logging.SetStudent(c, studentId)
+	if err := clientaudit.Record(ctx, db.Pool, clientaudit.ActionConferenceToken, studentId, 0, requestClient(c)); err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Failed to record conference token verification")
+	}
+
+	// Remember the browser's timezone for local exam windows, unless one was registered
+	if req.Timezone != "" && examwindow.ValidTimezone(req.Timezone) == nil {
+		timezoneQuery := `UPDATE students SET timezone = $1, updated_at = NOW() WHERE id = $2 AND timezone IS NULL`
+		if _, err := db.Pool.Exec(ctx, timezoneQuery, req.Timezone, studentId); err != nil {
+			logging.Ctx(c).Warn().Err(err).Msg("Failed to store timezone")
+		}
 	}
 
-	// Step 2: Mark conference_attended as true and generate access code
+	// Step 2: Mark conference_attended as true and issue the access code (valid for ACCESS_CODE_TTL)
 	if !attended {
-		// Generate 6-digit alphanumeric access code
-		accessCode := generateAccessCode()
-
-		updateQuery := `
-			UPDATE email_tracking
-			SET conference_attended = true, conference_attended_at = NOW(), access_code = $1, updated_at = NOW()
-			WHERE conference_token = $2 AND email_type = 'firstMail'
-		`
-		_, err = db.Pool.Exec(ctx, updateQuery, accessCode, req.Token)
+		_, err = accesscodes.Issue(ctx, studentId, accesscodes.EmailType, accesscodes.Audit{
+			IP:        c.IP(),
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+		})
 		if err != nil {
-			log.Printf("Failed to mark attendance: %v", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(VerifyTokenResponse{
-				Success: false,
-				Message: "Failed to update verification status",
-			})
+			logging.Ctx(c).Error().Err(err).Msg("Failed to mark attendance")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to update verification status")
 		}
 	}
 
@@ -106,11 +106,8 @@ func VerifyFirstMailTokenHandler(c *fiber.Ctx) error {
 	scheduleQuery := `SELECT video_url FROM event_schedule ORDER BY id DESC LIMIT 1`
 	err = db.Pool.QueryRow(ctx, scheduleQuery).Scan(&videoURL)
 	if err != nil || videoURL == "" {
-		log.Printf("Failed to get video URL: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(VerifyTokenResponse{
-			Success: false,
-			Message: "Video URL not configured",
-		})
+		logging.Ctx(c).Error().Err(err).Msg("Failed to get video URL")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Video URL not configured")
 	}
 
 	// Step 4: Return success with YouTube URL
@@ -123,6 +120,9 @@ func VerifyFirstMailTokenHandler(c *fiber.Ctx) error {
 
 type VerifyOTPRequest struct {
 	OTP string `json:"otp"`
+	// Email, when given, must be the address of the code's student; wrong codes then count
+	// toward that student's lockout as well as the IP's
+	Email string `json:"email"`
 }
 
 type VerifyOTPResponse struct {
@@ -133,103 +133,310 @@ type VerifyOTPResponse struct {
 	Message      string `json:"message,omitempty"`
 }
 
+// verifyRetryWindow is how long after an access code starts a session that verifying the
+// same code again returns that session, so double submits, concurrent verifications and
+// retries after a lost response all get the one session instead of an error
+const verifyRetryWindow = time.Minute
+
+// errAttemptTaken means a concurrent verification created the session's attempt first
+var errAttemptTaken = errors.New("attempt already started")
+
+// startedSession finds the open session the access code started within verifyRetryWindow
+func startedSession(ctx context.Context, c *fiber.Ctx, studentID int, code string) (sessionID int, sessionToken string, ok bool) {
+	query := `
+		SELECT id, session_token
+		FROM sessions
+		WHERE student_id = $1 AND access_code = $2 AND completed = false
+		  AND started_at > NOW() - make_interval(secs => $3)
+		ORDER BY id DESC
+		LIMIT 1
+	`
+	err := db.Pool.QueryRow(ctx, query, studentID, code, verifyRetryWindow.Seconds()).Scan(&sessionID, &sessionToken)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		logging.Ctx(c).Warn().Err(err).Msg("Failed to look up session started by access code")
+	}
+	return sessionID, sessionToken, err == nil
+}
+
+// requestClient is the IP and user agent of the request, for clientaudit
+func requestClient(c *fiber.Ctx) clientaudit.Client {
+	return clientaudit.Client{IP: c.IP(), UserAgent: c.Get(fiber.HeaderUserAgent)}
+}
+
+// recordOTPVerified stores where a successful OTP verification came from. It does not fail
+// the request: the session is already started.
+func recordOTPVerified(ctx context.Context, c *fiber.Ctx, studentID, sessionID int) {
+	if err := clientaudit.Record(ctx, db.Pool, clientaudit.ActionOTPVerified, studentID, sessionID, requestClient(c)); err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Failed to record OTP verification")
+	}
+}
+
+// recordOTPAttempt stores a verify-otp attempt for the attempts report and lockouts,
+// returning the lockout it caused. It does not fail the request.
+func recordOTPAttempt(ctx context.Context, c *fiber.Ctx, studentID int, outcome string) *accesscodes.Lockout {
+	client := requestClient(c)
+	lockout, err := accesscodes.RecordAttempt(ctx, accesscodes.LockoutFromEnv(), studentID, outcome,
+		accesscodes.Audit{IP: client.IP, UserAgent: client.UserAgent})
+	if err != nil {
+		logging.Ctx(c).Warn().Err(err).Str("outcome", outcome).Msg("Failed to record OTP attempt")
+	}
+	return lockout
+}
+
+// otpLockedResponse refuses verification while the student's code or the IP is locked out
+func otpLockedResponse(c *fiber.Ctx, lockout *accesscodes.Lockout) error {
+	retryAfter := int(math.Ceil(time.Until(lockout.LockedUntil).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	return apierror.Respond(c, fiber.StatusTooManyRequests, apierror.CodeOTPLocked,
+		"Too many failed attempts. Please try again in "+strconv.Itoa(retryAfter)+" seconds or contact the exam administrator",
+		fiber.Map{"locked_until": lockout.LockedUntil, "retry_after": retryAfter})
+}
+
+// checkOTPLockout refuses the attempt, recording it, when the student's code (studentID not
+// 0) or the request's IP is locked out. Reports whether it answered the request.
+func checkOTPLockout(ctx context.Context, c *fiber.Ctx, studentID int) (bool, error) {
+	lockout, err := accesscodes.ActiveLockout(ctx, studentID, c.IP())
+	if err != nil {
+		// Verification keeps working when the check fails; attempts are still recorded
+		logging.Ctx(c).Warn().Err(err).Msg("Failed to check OTP lockout")
+		return false, nil
+	}
+	if lockout == nil {
+		return false, nil
+	}
+	recordOTPAttempt(ctx, c, studentID, accesscodes.OutcomeLocked)
+	return true, otpLockedResponse(c, lockout)
+}
+
+// existingSessionResponse answers a repeated verification with the session already started
+func existingSessionResponse(ctx context.Context, c *fiber.Ctx, studentID, sessionID int, sessionToken, email, name string) error {
+	logging.SetSession(c, sessionID)
+	logging.Ctx(c).Info().Msg("Repeated OTP verification returned the existing session")
+	recordOTPAttempt(ctx, c, studentID, accesscodes.OutcomeSuccess)
+	recordOTPVerified(ctx, c, studentID, sessionID)
+	return c.JSON(VerifyOTPResponse{
+		Success:      true,
+		SessionToken: sessionToken,
+		Email:        email,
+		Name:         name,
+		Message:      "OTP verified successfully",
+	})
+}
+
+// checkEntry refuses a student outside their entry times, or a late entrant left with no
+// time under reduced time, with the window and entry in the details so the page can say
+// when to come back. Reports whether it answered the request.
+func checkEntry(ctx context.Context, c *fiber.Ctx, studentID int, window examwindow.Window, entry examwindow.Entry, finishBy *time.Time, now time.Time) (bool, error) {
+	details := fiber.Map{"exam_window": window, "entry": entry}
+	switch {
+	case entry.Status(now) == examwindow.EntryNotOpen:
+		recordOTPAttempt(ctx, c, studentID, accesscodes.OutcomeRejected)
+		details["opens_in_seconds"] = int(math.Ceil(entry.OpensAt.Sub(now).Seconds()))
+		message := "The exam has not opened yet. You can join from " + entry.OpensAt.In(time.UTC).Format("2006-01-02 15:04 MST")
+		return true, apierror.Respond(c, fiber.StatusBadRequest, apierror.CodeTestNotStarted, message, details)
+	case now.After(window.EndsAt):
+		recordOTPAttempt(ctx, c, studentID, accesscodes.OutcomeRejected)
+		return true, apierror.Respond(c, fiber.StatusBadRequest, apierror.CodeTestExpired, "Test time expired", details)
+	case entry.Status(now) == examwindow.EntryClosed:
+		recordOTPAttempt(ctx, c, studentID, accesscodes.OutcomeRejected)
+		message := "Late entry closed at " + entry.ClosesAt.In(time.UTC).Format("2006-01-02 15:04 MST")
+		return true, apierror.Respond(c, fiber.StatusBadRequest, apierror.CodeEntryClosed, message, details)
+	case finishBy != nil && !finishBy.After(now):
+		recordOTPAttempt(ctx, c, studentID, accesscodes.OutcomeRejected)
+		details["finish_by"] = finishBy
+		return true, apierror.Respond(c, fiber.StatusBadRequest, apierror.CodeEntryClosed, "The exam time has run out for late entrants", details)
+	}
+	return false, nil
+}
+
 // VerifyOTPHandler handles POST /api/live/verify-otp
 func VerifyOTPHandler(c *fiber.Ctx) error {
 	var req VerifyOTPRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(VerifyOTPResponse{
-			Success: false,
-			Message: "Invalid request body",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
 	if req.OTP == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(VerifyOTPResponse{
-			Success: false,
-			Message: "OTP is required",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "OTP is required")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// Step 0: Refuse IPs locked out for too many wrong codes
+	if answered, err := checkOTPLockout(ctx, c, 0); answered {
+		return err
+	}
+
 	// Step 1: Verify OTP exists and get student details
 	var studentID int
 	var name, email string
+	var timezone string
+	var expiresAt, usedAt, invalidatedAt *time.Time
 	query := `
-		SELECT et.student_id, s.name, s.email
+		SELECT et.student_id, s.name, s.email, COALESCE(s.timezone, ''),
+		       et.access_code_expires_at, et.access_code_used_at, et.access_code_invalidated_at
 		FROM email_tracking et
 		JOIN students s ON et.student_id = s.id
 		WHERE et.access_code = $1 AND et.email_type = 'firstMail' AND et.conference_attended = true
+		  AND ($2 = '' OR LOWER(s.email) = LOWER($2))
 	`
-	err := db.Pool.QueryRow(ctx, query, req.OTP).Scan(&studentID, &name, &email)
+	req.Email = strings.TrimSpace(req.Email)
+	err := db.Pool.QueryRow(ctx, query, req.OTP, req.Email).Scan(&studentID, &name, &email, &timezone, &expiresAt, &usedAt, &invalidatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logging.Ctx(c).Warn().Msg("OTP validation failed")
+		// A wrong code counts against the IP, and against the student when the request names one
+		var namedID int
+		if req.Email != "" {
+			err := db.Pool.QueryRow(ctx, `SELECT id FROM students WHERE LOWER(email) = LOWER($1) ORDER BY id LIMIT 1`, req.Email).Scan(&namedID)
+			if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				logging.Ctx(c).Warn().Err(err).Msg("Failed to look up student of OTP attempt")
+			}
+		}
+		if lockout := recordOTPAttempt(ctx, c, namedID, accesscodes.OutcomeInvalid); lockout != nil {
+			return otpLockedResponse(c, lockout)
+		}
+		return apierror.SendCode(c, fiber.StatusBadRequest, apierror.CodeOTPInvalid, "Already test completed or invalid OTP")
+	}
 	if err != nil {
-		log.Printf("OTP validation failed: %v", err)
-		return c.Status(fiber.StatusBadRequest).JSON(VerifyOTPResponse{
-			Success: false,
-			Message: "Already test completed or invalid OTP",
-		})
+		logging.Ctx(c).Error().Err(err).Msg("Failed to look up OTP")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to verify OTP")
 	}
+	logging.SetStudent(c, studentID)
 
-	// Step 2: Check if session already exists for this student
-	var existingSessionID int
-	checkSessionQuery := `SELECT id FROM sessions WHERE student_id = $1 LIMIT 1`
-	err = db.Pool.QueryRow(ctx, checkSessionQuery, studentID).Scan(&existingSessionID)
-	if err == nil {
-		// Session exists
-		return c.Status(fiber.StatusBadRequest).JSON(VerifyOTPResponse{
-			Success: false,
-			Message: "Already test completed or invalid OTP",
-		})
+	// A locked code stays locked even when the right code is given
+	if answered, err := checkOTPLockout(ctx, c, studentID); answered {
+		return err
 	}
 
-	// Step 3: Validate test time (within 15 minutes of second_scheduled_time)
-	var secondScheduledTime time.Time
-	timeCheckQuery := `SELECT second_scheduled_time FROM event_schedule ORDER BY id DESC LIMIT 1`
-	err = db.Pool.QueryRow(ctx, timeCheckQuery).Scan(&secondScheduledTime)
+	// Codes are single use and expire after ACCESS_CODE_TTL
+	switch accesscodes.StatusAt(&req.OTP, expiresAt, usedAt, invalidatedAt, time.Now()) {
+	case accesscodes.StatusInvalidated:
+		recordOTPAttempt(ctx, c, studentID, accesscodes.OutcomeRevoked)
+		return apierror.SendCode(c, fiber.StatusBadRequest, apierror.CodeOTPRevoked, "This access code has been revoked. Please contact the exam administrator")
+	case accesscodes.StatusUsed:
+		if sessionID, sessionToken, ok := startedSession(ctx, c, studentID, req.OTP); ok {
+			return existingSessionResponse(ctx, c, studentID, sessionID, sessionToken, email, name)
+		}
+		recordOTPAttempt(ctx, c, studentID, accesscodes.OutcomeUsed)
+		return apierror.SendCode(c, fiber.StatusBadRequest, apierror.CodeOTPUsed, "This access code has already been used")
+	case accesscodes.StatusExpired:
+		recordOTPAttempt(ctx, c, studentID, accesscodes.OutcomeExpired)
+		return apierror.SendCode(c, fiber.StatusBadRequest, apierror.CodeOTPExpired, "This access code has expired. Please request a new one")
+	}
+
+	// Step 2: Check the student's earlier attempts. An unfinished attempt blocks a new one,
+	// and at most MAX_ATTEMPTS sessions may be started.
+	attemptCfg := attempts.ConfigFromEnv()
+	var attemptCount int
+	var hasOpenAttempt bool
+	checkSessionQuery := `
+		SELECT COUNT(*), COALESCE(bool_or(NOT completed), false)
+		FROM sessions
+		WHERE student_id = $1
+	`
+	err = db.Pool.QueryRow(ctx, checkSessionQuery, studentID).Scan(&attemptCount, &hasOpenAttempt)
 	if err != nil {
-		log.Printf("Failed to get scheduled time: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(VerifyOTPResponse{
-			Success: false,
-			Message: "Failed to validate test time",
-		})
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check existing sessions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to verify OTP")
 	}
 
-	// Calculate time window: second_scheduled_time to second_scheduled_time + 6 hours
-	currentTime := time.Now()
-	testEndTime := secondScheduledTime.Add(6 * time.Hour)
+	if hasOpenAttempt {
+		recordOTPAttempt(ctx, c, studentID, accesscodes.OutcomeRejected)
+		return apierror.SendCode(c, fiber.StatusBadRequest, apierror.CodeSessionExists, "Already test completed or invalid OTP")
+	}
+	if attemptCount >= attemptCfg.MaxAttempts {
+		recordOTPAttempt(ctx, c, studentID, accesscodes.OutcomeRejected)
+		message := "Already test completed or invalid OTP"
+		if attemptCfg.MaxAttempts > 1 {
+			message = fmt.Sprintf("Maximum number of attempts (%d) reached", attemptCfg.MaxAttempts)
+		}
+		return apierror.SendCode(c, fiber.StatusBadRequest, apierror.CodeAttemptsExhausted, message)
+	}
 
-	if currentTime.Before(secondScheduledTime) {
-		return c.Status(fiber.StatusBadRequest).JSON(VerifyOTPResponse{
-			Success: false,
-			Message: "Test has not started yet",
-		})
+	// Step 3: Validate test time. The window opens at second_scheduled_time, or at the same
+	// wall-clock time in the student's timezone when the event's policy is local; entry opens
+	// and closes around it as the event's entry times say.
+	schedule, err := examwindow.Latest(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to get scheduled time")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to validate test time")
 	}
+	window := examwindow.For(ctx, schedule, timezone)
+	entry := schedule.Entry(window)
 
-	if currentTime.After(testEndTime) {
-		return c.Status(fiber.StatusBadRequest).JSON(VerifyOTPResponse{
-			Success: false,
-			Message: "Test time expired",
-		})
+	currentTime := time.Now()
+	var finishBy *time.Time
+	if duration, timed, err := presence.TestDuration(); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load test duration")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to validate test time")
+	} else if timed {
+		finishBy = schedule.FinishBy(window, currentTime, duration)
+	}
+	if answered, err := checkEntry(ctx, c, studentID, window, entry, finishBy, currentTime); answered {
+		return err
 	}
 
-	// Step 4: Generate session token and create new session
+	// Step 4: Generate session token and create new session. The code is consumed in the same
+	// transaction, so it is only spent if the session is created.
 	sessionToken := generateSessionToken()
 
 	createSessionQuery := `
-		INSERT INTO sessions (student_id, session_token, access_code, started_at)
-		VALUES ($1, $2, $3, NOW())
+		INSERT INTO sessions (student_id, session_token, access_code, started_at, attempt_number, is_sandbox,
+		                      start_ip, start_user_agent, start_country, finish_by)
+		VALUES ($1, $2, $3, NOW(), $4, (SELECT is_sandbox FROM students WHERE id = $1),
+		        NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8)
+		ON CONFLICT (student_id, attempt_number) DO NOTHING
 		RETURNING id
 	`
+	client := requestClient(c)
+	country := clientaudit.Country(client.IP)
 	var sessionID int
-	err = db.Pool.QueryRow(ctx, createSessionQuery, studentID, sessionToken, req.OTP).Scan(&sessionID)
-	if err != nil {
-		log.Printf("Failed to create session: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(VerifyOTPResponse{
-			Success: false,
-			Message: "Failed to create session",
+	err = db.WithTx(ctx, func(tx pgx.Tx) error {
+		err := accesscodes.Claim(ctx, tx, studentID, req.OTP, accesscodes.Audit{
+			IP:        client.IP,
+			UserAgent: client.UserAgent,
 		})
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRow(ctx, createSessionQuery, studentID, sessionToken, req.OTP, attemptCount+1,
+			client.IP, client.UserAgent, country, finishBy).Scan(&sessionID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errAttemptTaken
+		}
+		return err
+	})
+	if errors.Is(err, accesscodes.ErrUnavailable) {
+		// A concurrent verification used the code first, or it expired in between. The claim
+		// waited for the other transaction, so the session it started is visible now.
+		if sessionID, sessionToken, ok := startedSession(ctx, c, studentID, req.OTP); ok {
+			return existingSessionResponse(ctx, c, studentID, sessionID, sessionToken, email, name)
+		}
+		recordOTPAttempt(ctx, c, studentID, accesscodes.OutcomeUsed)
+		return apierror.SendCode(c, fiber.StatusBadRequest, apierror.CodeOTPUsed, "This access code has already been used")
+	}
+	if errors.Is(err, errAttemptTaken) {
+		// A concurrent verification created this attempt first (unique_student_attempt)
+		if sessionID, sessionToken, ok := startedSession(ctx, c, studentID, req.OTP); ok {
+			return existingSessionResponse(ctx, c, studentID, sessionID, sessionToken, email, name)
+		}
+		recordOTPAttempt(ctx, c, studentID, accesscodes.OutcomeRejected)
+		return apierror.SendCode(c, fiber.StatusBadRequest, apierror.CodeSessionExists, "Already test completed or invalid OTP")
 	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to create session")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to create session")
+	}
+	logging.SetSession(c, sessionID)
+	recordOTPAttempt(ctx, c, studentID, accesscodes.OutcomeSuccess)
+	recordOTPVerified(ctx, c, studentID, sessionID)
+
+	// Warm the session cache so the first answers skip the sessions lookup
+	sessioncache.Put(ctx, sessionToken, &sessioncache.Session{ID: sessionID, StudentID: studentID, FinishBy: finishBy})
 
 	// Step 5: Return success with session token
 	return c.JSON(VerifyOTPResponse{
@@ -255,17 +462,11 @@ type GetOTPResponse struct {
 func GetOTPHandler(c *fiber.Ctx) error {
 	var req GetOTPRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(GetOTPResponse{
-			Success: false,
-			Message: "Invalid request body",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
 	if req.Email == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(GetOTPResponse{
-			Success: false,
-			Message: "Email is required",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Email is required")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -276,47 +477,47 @@ func GetOTPHandler(c *fiber.Ctx) error {
 	studentQuery := `SELECT id FROM students WHERE email = $1`
 	err := db.Pool.QueryRow(ctx, studentQuery, req.Email).Scan(&studentID)
 	if err != nil {
-		log.Printf("Student not found: %v", err)
-		return c.Status(fiber.StatusNotFound).JSON(GetOTPResponse{
-			Success: false,
-			Message: "Student not found with this email",
-		})
+		logging.Ctx(c).Warn().Err(err).Msg("Student not found")
+		return apierror.Send(c, fiber.StatusNotFound, "Student not found with this email")
 	}
+	logging.SetStudent(c, studentID)
 
 	// Step 2: Get access code from email_tracking
 	var accessCode *string
 	var conferenceAttended bool
+	var expiresAt, usedAt, invalidatedAt *time.Time
 	otpQuery := `
-		SELECT access_code, conference_attended
+		SELECT access_code, conference_attended, access_code_expires_at, access_code_used_at, access_code_invalidated_at
 		FROM email_tracking
 		WHERE student_id = $1 AND email_type = 'firstMail'
 	`
-	err = db.Pool.QueryRow(ctx, otpQuery, studentID).Scan(&accessCode, &conferenceAttended)
+	err = db.Pool.QueryRow(ctx, otpQuery, studentID).Scan(&accessCode, &conferenceAttended, &expiresAt, &usedAt, &invalidatedAt)
 	if err != nil {
-		log.Printf("Email tracking not found: %v", err)
-		return c.Status(fiber.StatusNotFound).JSON(GetOTPResponse{
-			Success: false,
-			Message: "No OTP generated for this email",
-		})
+		logging.Ctx(c).Warn().Err(err).Msg("Email tracking not found")
+		return apierror.Send(c, fiber.StatusNotFound, "No OTP generated for this email")
 	}
 
 	// Step 3: Check if conference was attended
 	if !conferenceAttended {
-		return c.Status(fiber.StatusBadRequest).JSON(GetOTPResponse{
-			Success: false,
-			Message: "Conference not attended. Please verify the first mail token first.",
-		})
+		return apierror.SendCode(c, fiber.StatusBadRequest, apierror.CodeConferenceNotAttended, "Conference not attended. Please verify the first mail token first.")
 	}
 
 	// Step 4: Check if access code exists
 	if accessCode == nil || *accessCode == "" {
-		return c.Status(fiber.StatusNotFound).JSON(GetOTPResponse{
-			Success: false,
-			Message: "No OTP generated for this email",
-		})
+		return apierror.Send(c, fiber.StatusNotFound, "No OTP generated for this email")
+	}
+
+	// Step 5: Only a code that can still be used is returned
+	switch accesscodes.StatusAt(accessCode, expiresAt, usedAt, invalidatedAt, time.Now()) {
+	case accesscodes.StatusInvalidated:
+		return apierror.SendCode(c, fiber.StatusBadRequest, apierror.CodeOTPRevoked, "This access code has been revoked. Please contact the exam administrator")
+	case accesscodes.StatusExpired:
+		return apierror.SendCode(c, fiber.StatusBadRequest, apierror.CodeOTPExpired, "This access code has expired. Please contact the exam administrator")
+	case accesscodes.StatusUsed:
+		return reissueOTP(ctx, c, studentID)
 	}
 
-	// Step 5: Return the OTP
+	// Step 6: Return the OTP
 	return c.JSON(GetOTPResponse{
 		Success: true,
 		OTP:     *accessCode,
@@ -324,6 +525,41 @@ func GetOTPHandler(c *fiber.Ctx) error {
 	})
 }
 
+// reissueOTP gives a student whose code was used a new one, when their last attempt is
+// finished and MAX_ATTEMPTS allows another
+func reissueOTP(ctx context.Context, c *fiber.Ctx, studentID int) error {
+	var attemptCount int
+	var hasOpenAttempt bool
+	err := db.Pool.QueryRow(ctx, `
+		SELECT COUNT(*), COALESCE(bool_or(NOT completed), false)
+		FROM sessions
+		WHERE student_id = $1
+	`, studentID).Scan(&attemptCount, &hasOpenAttempt)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check existing sessions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to retrieve OTP")
+	}
+	if hasOpenAttempt || attemptCount >= attempts.ConfigFromEnv().MaxAttempts {
+		return apierror.SendCode(c, fiber.StatusBadRequest, apierror.CodeOTPUsed, "This access code has already been used")
+	}
+
+	code, err := accesscodes.Regenerate(ctx, studentID, accesscodes.ActionReissued, accesscodes.Audit{
+		Reason:    fmt.Sprintf("attempt %d", attemptCount+1),
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to reissue access code")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to retrieve OTP")
+	}
+
+	return c.JSON(GetOTPResponse{
+		Success: true,
+		OTP:     *code.AccessCode,
+		Message: "New OTP issued for your next attempt",
+	})
+}
+
 type StartSessionRequest struct {
 	SessionToken string `json:"session_token"`
 }
@@ -337,17 +573,11 @@ type StartSessionResponse struct {
 func StartSessionHandler(c *fiber.Ctx) error {
 	var req StartSessionRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(StartSessionResponse{
-			Success: false,
-			Message: "Invalid request body",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
 	if req.SessionToken == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(StartSessionResponse{
-			Success: false,
-			Message: "Session token is required",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Session token is required")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -358,17 +588,24 @@ func StartSessionHandler(c *fiber.Ctx) error {
 		UPDATE sessions
 		SET started_at = NOW(), updated_at = NOW()
 		WHERE session_token = $1
-		RETURNING id
+		RETURNING id, student_id, started_at
 	`
-	var sessionID int
-	err := db.Pool.QueryRow(ctx, updateQuery, req.SessionToken).Scan(&sessionID)
+	var sessionID, studentID int
+	var startedAt time.Time
+	err := db.Pool.QueryRow(ctx, updateQuery, req.SessionToken).Scan(&sessionID, &studentID, &startedAt)
 	if err != nil {
-		log.Printf("Session validation failed: %v", err)
-		return c.Status(fiber.StatusNotFound).JSON(StartSessionResponse{
-			Success: false,
-			Message: "Invalid session token",
-		})
+		logging.Ctx(c).Warn().Err(err).Msg("Session validation failed")
+		return apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeSessionInvalid, "Invalid session token")
 	}
+	logging.SetStudent(c, studentID)
+	logging.SetSession(c, sessionID)
+
+	livemetrics.RecordSessionStart(sessionID)
+	events.Publish(events.SessionStarted, fiber.Map{
+		"session_id": sessionID,
+		"student_id": studentID,
+		"started_at": startedAt,
+	})
 
 	return c.Status(fiber.StatusCreated).JSON(StartSessionResponse{
 		Success: true,
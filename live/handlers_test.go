@@ -0,0 +1,84 @@
+package live
+
+import (
+	"encoding/json"
+	"io"
+	"mcq-exam/dbtest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestVerifyOTPConcurrent verifies one access code from two requests at once: a single
+// session is started and both callers get its token
+func TestVerifyOTPConcurrent(t *testing.T) {
+	dbtest.Open(t, "test_live")
+
+	dbtest.Exec(t, `
+		INSERT INTO event_schedule (first_function, first_scheduled_time, second_function, second_scheduled_time,
+		                            video_url, timezone)
+		VALUES ('SendFirstMail', NOW() - interval '1 day', 'SendSecondMail', NOW() - interval '1 minute',
+		        'https://example.com/live', 'UTC')
+	`)
+	studentID := dbtest.QueryInt(t, `INSERT INTO students (name, email) VALUES ('Keerthana', 'keerthana@example.com') RETURNING id`)
+	dbtest.Exec(t, `
+		INSERT INTO email_tracking (student_id, email_type, conference_token, conference_attended, conference_attended_at,
+		                            access_code, access_code_issued_at, access_code_expires_at)
+		VALUES ($1, 'firstMail', 'conference-token', true, NOW(), 'ABC123', NOW(), NOW() + interval '1 hour')
+	`, studentID)
+
+	app := fiber.New()
+	app.Post("/api/live/verify-otp", VerifyOTPHandler)
+
+	type result struct {
+		status int
+		body   VerifyOTPResponse
+		err    error
+	}
+	const callers = 2
+	results := make([]result, callers)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			req := httptest.NewRequest(http.MethodPost, "/api/live/verify-otp", strings.NewReader(`{"otp": "ABC123"}`))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				results[i].err = err
+				return
+			}
+			defer resp.Body.Close()
+			results[i].status = resp.StatusCode
+			data, err := io.ReadAll(resp.Body)
+			if err == nil {
+				err = json.Unmarshal(data, &results[i].body)
+			}
+			results[i].err = err
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for i, r := range results {
+		if r.err != nil {
+			t.Fatalf("caller %d: %v", i, r.err)
+		}
+		if r.status != fiber.StatusOK || !r.body.Success || r.body.SessionToken == "" {
+			t.Fatalf("caller %d: status %d, body %+v; want 200 with a session token", i, r.status, r.body)
+		}
+	}
+	if results[0].body.SessionToken != results[1].body.SessionToken {
+		t.Errorf("callers got different session tokens %q and %q", results[0].body.SessionToken, results[1].body.SessionToken)
+	}
+	if n := dbtest.QueryInt(t, `SELECT COUNT(*) FROM sessions WHERE student_id = $1`, studentID); n != 1 {
+		t.Errorf("%d sessions created, want 1", n)
+	}
+}
@@ -0,0 +1,73 @@
+package live
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/presence"
+	"mcq-exam/sessioncache"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type HeartbeatRequest struct {
+	SessionToken string `json:"session_token"`
+}
+
+type HeartbeatResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	// IdleTimeoutSeconds is how long the session may go without a heartbeat or an answer
+	// before it is flagged as abandoned
+	IdleTimeoutSeconds int `json:"idle_timeout_seconds,omitempty"`
+}
+
+// HeartbeatHandler handles POST /api/live/heartbeat
+// Records that the candidate's client is still open. A session flagged as abandoned
+// becomes active again, unless it has been finalized.
+func HeartbeatHandler(c *fiber.Ctx) error {
+	var req HeartbeatRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if req.SessionToken == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Session token is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	session, err := sessioncache.Lookup(ctx, req.SessionToken)
+	if err == sessioncache.ErrCompleted {
+		return apierror.SendCode(c, fiber.StatusForbidden, apierror.CodeTestCompleted, "Test already completed")
+	}
+	if err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Session validation failed")
+		return apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeSessionInvalid, "Invalid session token")
+	}
+	logging.SetStudent(c, session.StudentID)
+	logging.SetSession(c, session.ID)
+
+	wasAbandoned, err := presence.Touch(ctx, session.ID)
+	if errors.Is(err, presence.ErrSessionCompleted) {
+		// Finalized since it was cached, e.g. as abandoned
+		sessioncache.Invalidate(ctx, req.SessionToken)
+		return apierror.SendCode(c, fiber.StatusForbidden, apierror.CodeTestCompleted, "Test already completed")
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to record heartbeat")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to record heartbeat")
+	}
+	if wasAbandoned {
+		logging.Ctx(c).Info().Msg("Abandoned session resumed")
+	}
+
+	return c.JSON(HeartbeatResponse{
+		Success:            true,
+		Message:            "Heartbeat recorded",
+		IdleTimeoutSeconds: int(presence.IdleAfter().Seconds()),
+	})
+}
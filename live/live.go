@@ -4,12 +4,19 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"mcq-exam/config"
 	"mcq-exam/db"
-	"mcq-exam/utils"
-	"os"
+	"mcq-exam/i18n"
+	"mcq-exam/jobs"
+	"mcq-exam/outbox"
+	"mcq-exam/suppression"
+	"mcq-exam/tracking"
 	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
 )
 
 // ============================================
@@ -24,39 +31,49 @@ func generateToken(userId int) string {
 }
 
 // storeTokenInDB stores the token in database
-func storeTokenInDB(userId int, token string, mailType string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
+func storeTokenInDB(ctx context.Context, q db.Querier, userId int, token string, mailType tracking.EmailType) error {
 	query := `
 		INSERT INTO email_tracking (student_id, email_type, conference_token, created_at)
 		VALUES ($1, $2, $3, NOW())
 		ON CONFLICT (student_id, email_type)
-		DO UPDATE SET conference_token = $3, updated_at = NOW()
+		DO UPDATE SET conference_token = $3, recipient_email = NULL, updated_at = NOW()
 	`
-	_, err := db.Pool.Exec(ctx, query, userId, mailType, token)
+	_, err := q.Exec(ctx, query, userId, string(mailType), token)
 	return err
 }
 
-// sendFirstMail sends the first email with token
-func sendFirstMail(userId int, token string) error {
+// storeTokenAndQueue stores the token and queues the mail carrying it in one transaction, so
+// neither is kept without the other
+func storeTokenAndQueue(userId int, mailType tracking.EmailType, queue func(ctx context.Context, tx pgx.Tx, userId int, token string) error) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	token := generateToken(userId)
+	return db.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := storeTokenInDB(ctx, tx, userId, token, mailType); err != nil {
+			return fmt.Errorf("failed to store token: %w", err)
+		}
+		return queue(ctx, tx, userId, token)
+	})
+}
+
+// queueFirstMail queues the first email with token in the outbox
+func queueFirstMail(ctx context.Context, tx pgx.Tx, userId int, token string) error {
 	// Get user details
-	var name, email string
-	query := `SELECT name, email FROM students WHERE id = $1`
-	err := db.Pool.QueryRow(ctx, query, userId).Scan(&name, &email)
+	var name, email, locale string
+	query := `SELECT name, email, COALESCE(locale, '') FROM students WHERE id = $1`
+	err := tx.QueryRow(ctx, query, userId).Scan(&name, &email, &locale)
 	if err != nil {
 		return fmt.Errorf("failed to get user details: %w", err)
 	}
 
-	// Get frontend URL from environment
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "https://nicm.smart-mcq.com"
+	if suppression.IsSuppressed(ctx, email) {
+		return suppression.ErrSuppressed
 	}
 
+	// Get frontend URL from configuration
+	frontendURL := config.FrontendURL()
+
 	// Create conference link with token
 	conferenceLink := fmt.Sprintf("%s/live?token=%s", frontendURL, token)
 
@@ -104,20 +121,22 @@ func sendFirstMail(userId int, token string) error {
 			</p>
 		</div>
 	`, name, conferenceLink)
-
-	params := utils.SendEmailParams{
-		ToEmail:  email,
-		ToName:   name,
-		Subject:  "Invitation: CoopQuest- An International Online Cooperative  Conclave",
-		HTMLBody: htmlBody,
+	subject, htmlBody := i18n.Localize(ctx, i18n.TemplateFirstMail, locale,
+		"Invitation: CoopQuest- An International Online Cooperative  Conclave", htmlBody,
+		map[string]string{"name": name, "link": conferenceLink})
+
+	msg := outbox.Message{
+		StudentID: userId,
+		EmailType: tracking.FirstMail,
+		Source:    "phase1",
+		ToEmail:   email,
+		ToName:    name,
+		Subject:   subject,
+		HTMLBody:  htmlBody,
 	}
-
-	_, err = utils.SendEmail(params)
-	if err != nil {
-		return fmt.Errorf("failed to send email: %w", err)
+	if err := outbox.Enqueue(ctx, tx, msg); err != nil {
+		return fmt.Errorf("failed to queue email: %w", err)
 	}
-
-	log.Printf("Sent first mail to %s with token", email)
 	return nil
 }
 
@@ -125,18 +144,17 @@ func sendFirstMail(userId int, token string) error {
 // PHASE 1 - First Mail Verification
 // ============================================
 
-func Phase1FirstMailVerification() {
-	log.Println("Phase 1: Starting First Mail Verification process")
+func Phase1FirstMailVerification(jobCtx context.Context, run *jobs.Run) error {
+	log.Info().Msg("Phase 1: Starting First Mail Verification process")
 
-	// Get all students from database
+	// Get all students from database (after the last one handled if resuming)
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	query := `SELECT id FROM students ORDER BY id`
-	rows, err := db.Pool.Query(ctx, query)
+	query := `SELECT id FROM students WHERE id > $1 AND is_sandbox = false ORDER BY id`
+	rows, err := db.Pool.Query(ctx, query, run.LastStudentID)
 	if err != nil {
-		log.Printf("ERROR: Failed to fetch students: %v", err)
-		return
+		return fmt.Errorf("failed to fetch students: %w", err)
 	}
 	defer rows.Close()
 
@@ -150,34 +168,47 @@ func Phase1FirstMailVerification() {
 	}
 
 	if len(studentIds) == 0 {
-		log.Println("WARNING: No students found")
-		return
+		log.Warn().Msg("No students found")
+		return nil
 	}
+	run.SetRemaining(len(studentIds))
 
-	// For each student: generate token, store in DB, send first mail
-	sentCount := 0
+	// For each student: generate token, store it and queue the first mail together; the
+	// outbox relay sends the mails
+	queuedCount := 0
+	skippedCount := 0
+	defer outbox.Notify()
 	for _, userId := range studentIds {
-		// Step 1: Generate token
-		token := generateToken(userId)
+		// Stop between students on shutdown; progress so far is already saved
+		if jobCtx.Err() != nil {
+			log.Info().Int("queued", queuedCount).Int("total", len(studentIds)).Msg("Phase 1 interrupted by shutdown")
+			return nil
+		}
 
-		// Step 2: Store token in DB
-		err := storeTokenInDB(userId, token, "firstMail")
-		if err != nil {
-			log.Printf("ERROR: Failed to store token for user %d: %v", userId, err)
+		err := storeTokenAndQueue(userId, tracking.FirstMail, queueFirstMail)
+		if errors.Is(err, suppression.ErrSuppressed) {
+			skippedCount++
+			run.Record(userId, false)
 			continue
 		}
-
-		// Step 3: Send first mail
-		err = sendFirstMail(userId, token)
 		if err != nil {
-			log.Printf("ERROR: Failed to send first mail to user %d: %v", userId, err)
+			log.Error().Err(err).Int("student_id", userId).Msg("Failed to queue first mail")
+			run.Record(userId, false)
 			continue
 		}
 
-		sentCount++
+		queuedCount++
+		run.Record(userId, true)
+		if queuedCount%50 == 0 {
+			outbox.Notify()
+		}
 	}
 
-	log.Printf("Phase 1 completed: Sent %d/%d first mails", sentCount, len(studentIds))
+	log.Info().Int("queued", queuedCount).Int("total", len(studentIds)).Int("suppressed", skippedCount).Msg("Phase 1 completed")
+	if queuedCount == 0 && skippedCount < len(studentIds) {
+		return fmt.Errorf("no first mails queued (0/%d)", len(studentIds))
+	}
+	return nil
 }
 
 // getToken extracts token from request
@@ -222,60 +253,75 @@ func returnYoutubeUrlFromDB(userId int) (string, error) {
 // PHASE 2 - Second Mail Sending
 // ============================================
 
-func Phase2SecondMailSending() {
-	log.Println("Phase 2: Starting Second Mail Sending process")
+func Phase2SecondMailSending(jobCtx context.Context, run *jobs.Run) error {
+	log.Info().Msg("Phase 2: Starting Second Mail Sending process")
 
 	// Step 1: Get all users who verified first mail (conference_attended = true)
-	userIds, err := getVerifiedUsersFromDB("firstMail")
+	userIds, err := getVerifiedUsersFromDB(tracking.FirstMail, run.LastStudentID)
 	if err != nil {
-		log.Printf("ERROR: Failed to get verified users: %v", err)
-		return
+		return fmt.Errorf("failed to get verified users: %w", err)
 	}
 
 	if len(userIds) == 0 {
-		log.Println("WARNING: No verified users found for second mail")
-		return
+		log.Warn().Msg("No verified users found for second mail")
+		return nil
 	}
 
-	log.Printf("Found %d verified users for second mail", len(userIds))
+	log.Info().Int("total", len(userIds)).Msg("Found verified users for second mail")
+	run.SetRemaining(len(userIds))
 
-	// Step 2: For each verified user: generate token, store in DB, send second mail
-	sentCount := 0
+	// Step 2: For each verified user: generate token, store it with mailType = "secondMail"
+	// and queue the second mail together; the outbox relay sends the mails
+	queuedCount := 0
+	skippedCount := 0
+	defer outbox.Notify()
 	for _, userId := range userIds {
-		// Generate token for second mail
-		token := generateToken(userId)
+		// Stop between users on shutdown; progress so far is already saved
+		if jobCtx.Err() != nil {
+			log.Info().Int("queued", queuedCount).Int("total", len(userIds)).Msg("Phase 2 interrupted by shutdown")
+			return nil
+		}
 
-		// Store token in DB with mailType = "secondMail"
-		err := storeTokenInDB(userId, token, "secondMail")
-		if err != nil {
-			log.Printf("ERROR: Failed to store second mail token for user %d: %v", userId, err)
+		err := storeTokenAndQueue(userId, tracking.SecondMail, queueSecondMail)
+		if errors.Is(err, suppression.ErrSuppressed) {
+			skippedCount++
+			run.Record(userId, false)
 			continue
 		}
-
-		// Send second mail with token
-		err = sendSecondMail(userId, token)
 		if err != nil {
-			log.Printf("ERROR: Failed to send second mail to user %d: %v", userId, err)
+			log.Error().Err(err).Int("student_id", userId).Msg("Failed to queue second mail")
+			run.Record(userId, false)
 			continue
 		}
 
-		sentCount++
+		queuedCount++
+		run.Record(userId, true)
+		if queuedCount%50 == 0 {
+			outbox.Notify()
+		}
 	}
 
-	log.Printf("Phase 2 completed: Sent %d/%d second mails", sentCount, len(userIds))
+	log.Info().Int("queued", queuedCount).Int("total", len(userIds)).Int("suppressed", skippedCount).Msg("Phase 2 completed")
+	if queuedCount == 0 && skippedCount < len(userIds) {
+		return fmt.Errorf("no second mails queued (0/%d)", len(userIds))
+	}
+	return nil
 }
 
-// getVerifiedUsersFromDB gets all users who verified first mail
-func getVerifiedUsersFromDB(mailType string) ([]int, error) {
+// getVerifiedUsersFromDB gets all users who verified first mail, in ID order after afterUserId
+func getVerifiedUsersFromDB(mailType tracking.EmailType, afterUserId int) ([]int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	query := `
-		SELECT student_id
-		FROM email_tracking
-		WHERE email_type = $1 AND conference_attended = true
+		SELECT et.student_id
+		FROM email_tracking et
+		JOIN students s ON s.id = et.student_id
+		WHERE et.email_type = $1 AND et.conference_attended = true AND et.student_id > $2
+		  AND s.is_sandbox = false
+		ORDER BY et.student_id
 	`
-	rows, err := db.Pool.Query(ctx, query, mailType)
+	rows, err := db.Pool.Query(ctx, query, string(mailType), afterUserId)
 	if err != nil {
 		return nil, err
 	}
@@ -293,20 +339,17 @@ func getVerifiedUsersFromDB(mailType string) ([]int, error) {
 	return userIds, nil
 }
 
-// sendSecondMail sends the second email with access code (OTP)
-func sendSecondMail(userId int, token string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
+// queueSecondMail queues the second email with access code (OTP) in the outbox
+func queueSecondMail(ctx context.Context, tx pgx.Tx, userId int, token string) error {
 	// Get user details and access code from DB
-	var name, email, accessCode string
+	var name, email, locale, accessCode string
 	query := `
-		SELECT s.name, s.email, et.access_code
+		SELECT s.name, s.email, COALESCE(s.locale, ''), et.access_code
 		FROM students s
 		JOIN email_tracking et ON s.id = et.student_id
 		WHERE s.id = $1 AND et.email_type = 'firstMail' AND et.conference_attended = true
 	`
-	err := db.Pool.QueryRow(ctx, query, userId).Scan(&name, &email, &accessCode)
+	err := tx.QueryRow(ctx, query, userId).Scan(&name, &email, &locale, &accessCode)
 	if err != nil {
 		return fmt.Errorf("failed to get user details: %w", err)
 	}
@@ -315,12 +358,13 @@ func sendSecondMail(userId int, token string) error {
 		return fmt.Errorf("access code not found for user %d", userId)
 	}
 
-	// Get frontend URL from environment
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "https://nicm.smart-mcq.com"
+	if suppression.IsSuppressed(ctx, email) {
+		return suppression.ErrSuppressed
 	}
 
+	// Get frontend URL from configuration
+	frontendURL := config.FrontendURL()
+
 	// Create URL with otp parameter
 	testURL := fmt.Sprintf("%s?otp=%s", frontendURL, accessCode)
 
@@ -336,20 +380,21 @@ func sendSecondMail(userId int, token string) error {
 			<p>Best regards,<br>SmartMCQ Team</p>
 		</div>
 	`, name, testURL, accessCode)
-
-	params := utils.SendEmailParams{
-		ToEmail:  email,
-		ToName:   name,
-		Subject:  "Test Invitation - Your Access Code",
-		HTMLBody: htmlBody,
+	subject, htmlBody := i18n.Localize(ctx, i18n.TemplateSecondMail, locale, "Test Invitation - Your Access Code", htmlBody,
+		map[string]string{"name": name, "link": testURL, "access_code": accessCode})
+
+	msg := outbox.Message{
+		StudentID: userId,
+		EmailType: tracking.SecondMail,
+		Source:    "phase2",
+		ToEmail:   email,
+		ToName:    name,
+		Subject:   subject,
+		HTMLBody:  htmlBody,
 	}
-
-	_, err = utils.SendEmail(params)
-	if err != nil {
-		return fmt.Errorf("failed to send email: %w", err)
+	if err := outbox.Enqueue(ctx, tx, msg); err != nil {
+		return fmt.Errorf("failed to queue email: %w", err)
 	}
-
-	log.Printf("Sent second mail to %s with OTP: %s", email, accessCode)
 	return nil
 }
 
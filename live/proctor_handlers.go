@@ -0,0 +1,106 @@
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/proctoring"
+	"mcq-exam/sessioncache"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ProctorEvent struct {
+	Type       string          `json:"type"`
+	OccurredAt *time.Time      `json:"occurred_at"`
+	Details    json.RawMessage `json:"details"`
+}
+
+type ProctorEventRequest struct {
+	SessionToken string         `json:"session_token"`
+	Events       []ProctorEvent `json:"events"`
+}
+
+type ProctorEventResponse struct {
+	Success  bool   `json:"success"`
+	Message  string `json:"message"`
+	Accepted int    `json:"accepted,omitempty"`
+}
+
+// ProctorEventHandler handles POST /api/live/proctor-event
+// Stores a batch of client-side proctoring events for the session. Events must be
+// flushed before end-session: completed sessions no longer accept events.
+func ProctorEventHandler(c *fiber.Ctx) error {
+	var req ProctorEventRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if req.SessionToken == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Session token is required")
+	}
+
+	if len(req.Events) == 0 || len(req.Events) > proctoring.MaxBatchSize {
+		return apierror.Send(c, fiber.StatusBadRequest, fmt.Sprintf("events must contain 1-%d entries", proctoring.MaxBatchSize))
+	}
+
+	// Validate the whole batch before storing any of it
+	now := time.Now()
+	eventTypes := make([]string, len(req.Events))
+	details := make([]*string, len(req.Events))
+	occurredAt := make([]time.Time, len(req.Events))
+	for i, event := range req.Events {
+		if !proctoring.IsValidEventType(event.Type) {
+			return apierror.Send(c, fiber.StatusBadRequest, fmt.Sprintf("Unknown event type '%s'. Valid types: %s", event.Type, strings.Join(proctoring.EventTypes, ", ")))
+		}
+		if len(event.Details) > proctoring.MaxDetailsBytes {
+			return apierror.Send(c, fiber.StatusBadRequest, fmt.Sprintf("Event details must be at most %d bytes", proctoring.MaxDetailsBytes))
+		}
+
+		eventTypes[i] = event.Type
+		if len(event.Details) > 0 && string(event.Details) != "null" {
+			value := string(event.Details)
+			details[i] = &value
+		}
+		// Client clocks are untrusted: missing or future timestamps use the server time
+		occurredAt[i] = now
+		if event.OccurredAt != nil && event.OccurredAt.Before(now) {
+			occurredAt[i] = *event.OccurredAt
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, err := sessioncache.Lookup(ctx, req.SessionToken)
+	if err == sessioncache.ErrCompleted {
+		return apierror.SendCode(c, fiber.StatusForbidden, apierror.CodeTestCompleted, "Test already completed")
+	}
+	if err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Session validation failed")
+		return apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeSessionInvalid, "Invalid session token")
+	}
+	logging.SetStudent(c, session.StudentID)
+	logging.SetSession(c, session.ID)
+
+	insertQuery := `
+		INSERT INTO proctor_events (session_id, event_type, details, occurred_at)
+		SELECT $1, e.event_type, e.details::jsonb, e.occurred_at
+		FROM unnest($2::text[], $3::text[], $4::timestamptz[]) AS e(event_type, details, occurred_at)
+	`
+	if _, err := db.Pool.Exec(ctx, insertQuery, session.ID, eventTypes, details, occurredAt); err != nil {
+		logging.Ctx(c).Error().Err(err).Int("events", len(eventTypes)).Msg("Failed to store proctor events")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to store events")
+	}
+
+	return c.JSON(ProctorEventResponse{
+		Success:  true,
+		Message:  "Events recorded",
+		Accepted: len(eventTypes),
+	})
+}
@@ -0,0 +1,154 @@
+package live
+
+import (
+	"context"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"mcq-exam/questions"
+	"mcq-exam/sessioncache"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type FlagQuestionRequest struct {
+	SessionToken string `json:"session_token"`
+	QuestionID   int    `json:"question_id"`
+	// Flagged and Skipped set the question's markers; a marker left out is unchanged
+	Flagged *bool `json:"flagged"`
+	Skipped *bool `json:"skipped"`
+}
+
+// QuestionMark is a question's review markers. Skipped is cleared once the question is answered.
+type QuestionMark struct {
+	QuestionID int  `json:"question_id"`
+	SectionID  int  `json:"section_id"`
+	Flagged    bool `json:"flagged"`
+	Skipped    bool `json:"skipped"`
+	Answered   bool `json:"answered"`
+}
+
+type FlagQuestionResponse struct {
+	Success bool          `json:"success"`
+	Message string        `json:"message"`
+	Mark    *QuestionMark `json:"mark,omitempty"`
+}
+
+// FlagQuestionHandler handles POST /api/live/flag-question
+// Body: {"session_token": "...", "question_id": 12, "flagged": true, "skipped": false}.
+// Flags a question for review or marks it skipped; session-state returns the markers so a
+// reloaded page can restore them. Each skipped=true counts as a skip for analytics.
+func FlagQuestionHandler(c *fiber.Ctx) error {
+	var req FlagQuestionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if req.SessionToken == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Session token is required")
+	}
+	if req.QuestionID <= 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid question ID")
+	}
+	if req.Flagged == nil && req.Skipped == nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "flagged or skipped is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, err := sessioncache.Lookup(ctx, req.SessionToken)
+	if err == sessioncache.ErrCompleted {
+		return apierror.SendCode(c, fiber.StatusForbidden, apierror.CodeTestCompleted, "Test already completed")
+	}
+	if err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Session validation failed")
+		return apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeSessionInvalid, "Invalid session token")
+	}
+	logging.SetStudent(c, session.StudentID)
+	logging.SetSession(c, session.ID)
+
+	// Only questions of the session's set can be marked, as for submit-answer
+	sections, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+	}
+	cfg := questions.ConfigFromEnv()
+	if questions.Find(sections, req.QuestionID) == nil ||
+		(questions.Sampled(sections, cfg) && session.QuestionSeed != nil && !questions.Contains(questions.ForSeed(sections, *session.QuestionSeed, cfg), req.QuestionID)) {
+		return apierror.Send(c, fiber.StatusBadRequest, "Question is not part of this session")
+	}
+
+	// With locked navigation, only questions of the section in progress can be marked
+	gate, err := loadSectionGate(ctx, session.ID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load section progress")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to mark question")
+	}
+	if err := gate.check(req.QuestionID); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	mark := QuestionMark{QuestionID: req.QuestionID}
+	query := `
+		INSERT INTO question_marks (session_id, question_id, flagged, skipped, flag_count, skip_count)
+		VALUES ($1, $2, COALESCE($3::boolean, false), COALESCE($4::boolean, false),
+		        CASE WHEN $3::boolean THEN 1 ELSE 0 END, CASE WHEN $4::boolean THEN 1 ELSE 0 END)
+		ON CONFLICT (session_id, question_id) DO UPDATE SET
+			flagged = COALESCE($3::boolean, question_marks.flagged),
+			skipped = COALESCE($4::boolean, question_marks.skipped),
+			flag_count = question_marks.flag_count + CASE WHEN $3::boolean AND NOT question_marks.flagged THEN 1 ELSE 0 END,
+			skip_count = question_marks.skip_count + CASE WHEN $4::boolean THEN 1 ELSE 0 END,
+			updated_at = NOW()
+		RETURNING flagged, skipped, EXISTS (SELECT 1 FROM answers WHERE session_id = $1 AND question_id = $2)
+	`
+	if err := db.Pool.QueryRow(ctx, query, session.ID, req.QuestionID, req.Flagged, req.Skipped).Scan(&mark.Flagged, &mark.Skipped, &mark.Answered); err != nil {
+		logging.Ctx(c).Error().Err(err).Int("question_id", req.QuestionID).Msg("Failed to mark question")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to mark question")
+	}
+	mark.Skipped = mark.Skipped && !mark.Answered
+	mark.SectionID = questionSections(sections)[req.QuestionID]
+
+	return c.JSON(FlagQuestionResponse{Success: true, Message: "Question marked", Mark: &mark})
+}
+
+// questionSections maps each question of the bank to its section
+func questionSections(sections []questions.Section) map[int]int {
+	sectionOf := make(map[int]int)
+	for _, section := range sections {
+		for _, q := range section.Questions {
+			sectionOf[q.ID] = section.ID
+		}
+	}
+	return sectionOf
+}
+
+// loadQuestionMarks returns the session's flagged questions and the skipped ones not
+// answered since, by question ID
+func loadQuestionMarks(ctx context.Context, sessionID int, sectionOf map[int]int) ([]QuestionMark, error) {
+	query := `
+		SELECT m.question_id, m.flagged, m.skipped, a.id IS NOT NULL
+		FROM question_marks m
+		LEFT JOIN answers a ON a.session_id = m.session_id AND a.question_id = m.question_id
+		WHERE m.session_id = $1 AND (m.flagged OR (m.skipped AND a.id IS NULL))
+		ORDER BY m.question_id
+	`
+	rows, err := db.Pool.Query(ctx, query, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	marks := []QuestionMark{}
+	for rows.Next() {
+		var mark QuestionMark
+		if err := rows.Scan(&mark.QuestionID, &mark.Flagged, &mark.Skipped, &mark.Answered); err != nil {
+			return nil, err
+		}
+		mark.Skipped = mark.Skipped && !mark.Answered
+		mark.SectionID = sectionOf[mark.QuestionID]
+		marks = append(marks, mark)
+	}
+	return marks, rows.Err()
+}
@@ -0,0 +1,229 @@
+package live
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/exampause"
+	"mcq-exam/logging"
+	"mcq-exam/questions"
+	"mcq-exam/sessioncache"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Question timing modes (QUESTION_TIMING)
+const (
+	// TimingServer measures time taken from when GET /api/live/question/:id first served
+	// the question; answers to questions never served that way are rejected
+	TimingServer = "server"
+	// TimingClient trusts the client's time_taken_seconds
+	TimingClient = "client"
+)
+
+// timeLimitGrace is added to a section's time limit to allow for network latency
+const timeLimitGrace = 30 * time.Second
+
+// Reasons an answer's timing is rejected
+var (
+	errNotServed    = errors.New("Question must be fetched with GET /api/live/question/:id before answering")
+	errTooFast      = errors.New("Answer submitted too quickly")
+	errTimeExceeded = errors.New("Time limit for this question's section has passed")
+)
+
+type GetQuestionResponse struct {
+	Success   bool                      `json:"success"`
+	Message   string                    `json:"message,omitempty"`
+	SectionID int                       `json:"section_id,omitempty"`
+	TimeLimit int                       `json:"time_limit,omitempty"`
+	Question  *questions.PublicQuestion `json:"question,omitempty"`
+	// ServedAt is when the question was first served; time taken is measured from it
+	ServedAt *time.Time `json:"served_at,omitempty"`
+}
+
+// questionTiming returns the QUESTION_TIMING mode (server or client, default server)
+func questionTiming() string {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv("QUESTION_TIMING")))
+	switch value {
+	case "", TimingServer:
+		return TimingServer
+	case TimingClient:
+		return TimingClient
+	}
+	log.Warn().Msgf("Invalid QUESTION_TIMING=%q, using %s", value, TimingServer)
+	return TimingServer
+}
+
+// minAnswerTime is the fastest plausible answer (QUESTION_MIN_SECONDS, default 1)
+func minAnswerTime() time.Duration {
+	value := strings.TrimSpace(os.Getenv("QUESTION_MIN_SECONDS"))
+	if value == "" {
+		return time.Second
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		log.Warn().Msgf("Invalid QUESTION_MIN_SECONDS=%q, using 1", value)
+		return time.Second
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// questionSession resolves a session token for question fetches, assigning the question
+// seed on first use so every later fetch (e.g. page reloads) gets the same set. The session
+// is returned as it is cached, so the cache can be refreshed with the seed.
+func questionSession(ctx context.Context, sessionToken string) (session *sessioncache.Session, completed bool, err error) {
+	seedQuery := `
+		UPDATE sessions
+		SET question_seed = COALESCE(question_seed, $2)
+		WHERE session_token = $1
+		RETURNING id, student_id, completed, question_seed, finish_by
+	`
+	session = &sessioncache.Session{}
+	err = db.Pool.QueryRow(ctx, seedQuery, sessionToken, questions.NewSeed()).Scan(&session.ID, &session.StudentID, &completed, &session.QuestionSeed, &session.FinishBy)
+	return
+}
+
+// GetQuestionHandler handles GET /api/live/question/:id
+// Session token via "Authorization: Bearer <session_token>" or ?token=<session_token>.
+// Serves one question of the session's set and records when it was first served, which
+// submit-answer measures time taken from.
+func GetQuestionHandler(c *fiber.Ctx) error {
+	sessionToken := strings.TrimSpace(strings.TrimPrefix(c.Get("Authorization"), "Bearer"))
+	if sessionToken == "" {
+		sessionToken = c.Query("token")
+	}
+	if sessionToken == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Session token is required")
+	}
+
+	questionID, err := c.ParamsInt("id")
+	if err != nil || questionID <= 0 {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid question ID")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, completed, err := questionSession(ctx, sessionToken)
+	if err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Session validation failed")
+		return apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeSessionInvalid, "Invalid session token")
+	}
+	sessionID, studentID, seed := session.ID, session.StudentID, *session.QuestionSeed
+	logging.SetStudent(c, studentID)
+	logging.SetSession(c, sessionID)
+
+	if completed {
+		return apierror.SendCode(c, fiber.StatusForbidden, apierror.CodeTestCompleted, "Test already completed")
+	}
+
+	// Keep the cached session's seed in step so submit-answer checks the same question set
+	sessioncache.Put(ctx, sessionToken, session)
+
+	sections, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+	}
+
+	cfg := questions.ConfigFromEnv()
+	var question *questions.Question
+	var section questions.Section
+	for _, s := range questions.ForSeed(sections, seed, cfg) {
+		for i := range s.Questions {
+			if s.Questions[i].ID == questionID {
+				question, section = &s.Questions[i], s
+			}
+		}
+	}
+	if question == nil {
+		return apierror.Send(c, fiber.StatusNotFound, "Question is not part of this session")
+	}
+
+	var servedAt time.Time
+	serveQuery := `
+		INSERT INTO question_serves (session_id, question_id)
+		VALUES ($1, $2)
+		ON CONFLICT (session_id, question_id)
+		DO UPDATE SET last_served_at = NOW(), serve_count = question_serves.serve_count + 1
+		RETURNING first_served_at
+	`
+	if err := db.Pool.QueryRow(ctx, serveQuery, sessionID, questionID).Scan(&servedAt); err != nil {
+		logging.Ctx(c).Error().Err(err).Int("question_id", questionID).Msg("Failed to record question serve")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load question")
+	}
+
+	public := questions.PublicFor(*question, &seed, cfg)
+	return c.JSON(GetQuestionResponse{
+		Success:   true,
+		SectionID: section.ID,
+		TimeLimit: section.TimeLimit,
+		Question:  &public,
+		ServedAt:  &servedAt,
+	})
+}
+
+// serverTimes measures, for each question, the seconds from its first serve to now, less
+// any time the exam spent paused in between, and checks them against QUESTION_MIN_SECONDS and the section's time limit. Questions that
+// fail are returned in rejected instead of times.
+func serverTimes(ctx context.Context, sessionID int, questionIDs []int) (times map[int]int, rejected map[int]error, err error) {
+	sections, err := questions.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	timeLimits := make(map[int]time.Duration)
+	for _, section := range sections {
+		for _, q := range section.Questions {
+			timeLimits[q.ID] = time.Duration(section.TimeLimit) * time.Second
+		}
+	}
+
+	query := `
+		SELECT qs.question_id, qs.first_served_at, NOW(), ` + exampause.PausedSeconds("qs.first_served_at", "NOW()") + `
+		FROM question_serves qs
+		WHERE qs.session_id = $1 AND qs.question_id = ANY($2)
+	`
+	rows, err := db.Pool.Query(ctx, query, sessionID, questionIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	elapsed := make(map[int]time.Duration, len(questionIDs))
+	for rows.Next() {
+		var questionID int
+		var servedAt, now time.Time
+		var pausedSeconds float64
+		if err := rows.Scan(&questionID, &servedAt, &now, &pausedSeconds); err != nil {
+			return nil, nil, err
+		}
+		elapsed[questionID] = now.Sub(servedAt) - time.Duration(pausedSeconds*float64(time.Second))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	times = make(map[int]int, len(questionIDs))
+	rejected = make(map[int]error)
+	minTime := minAnswerTime()
+	for _, questionID := range questionIDs {
+		took, served := elapsed[questionID]
+		switch {
+		case !served:
+			rejected[questionID] = errNotServed
+		case took < minTime:
+			rejected[questionID] = errTooFast
+		case timeLimits[questionID] > 0 && took > timeLimits[questionID]+timeLimitGrace:
+			rejected[questionID] = errTimeExceeded
+		default:
+			times[questionID] = int(took / time.Second)
+		}
+	}
+	return times, rejected, nil
+}
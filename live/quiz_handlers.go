@@ -2,26 +2,83 @@ package live
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"mcq-exam/answerchange"
+	"mcq-exam/apierror"
+	"mcq-exam/attempts"
+	"mcq-exam/campaigns"
 	"mcq-exam/db"
-	"os"
+	"mcq-exam/events"
+	"mcq-exam/ingest"
+	"mcq-exam/livemetrics"
+	"mcq-exam/logging"
+	"mcq-exam/publication"
+	"mcq-exam/questions"
+	"mcq-exam/scoring"
+	"mcq-exam/sessioncache"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
 )
 
 type SubmitAnswerRequest struct {
 	SessionToken        string `json:"session_token"`
 	QuestionID          int    `json:"question_id"`
 	SelectedOptionIndex int    `json:"selected_option_index"`
-	IsCorrect           bool   `json:"is_correct"`
 	TimeTakenSeconds    int    `json:"time_taken_seconds"`
 }
 
+// Outcomes reported in SubmitAnswerResponse.Status and AnswerResult.Status
+const (
+	AnswerStatusCreated   = "created"
+	AnswerStatusDuplicate = "duplicate"
+	AnswerStatusUpdated   = "updated"
+	// Batch only: a different answer was already recorded for the question
+	AnswerStatusConflict = "conflict"
+	// Batch only: the answer failed validation and was not saved
+	AnswerStatusRejected = "rejected"
+)
+
+// maxAnswersPerBatch bounds POST /api/live/submit-answers
+const maxAnswersPerBatch = 200
+
 type SubmitAnswerResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
+	Status  string `json:"status,omitempty"`
+	// Code is the error code of a failure, when it needs a more specific one than its status
+	Code string `json:"-"`
+	// PreviousOption is the answer an update replaced, kept in answer_events
+	PreviousOption *int `json:"-"`
+}
+
+type AnswerItem struct {
+	QuestionID          int `json:"question_id"`
+	SelectedOptionIndex int `json:"selected_option_index"`
+	TimeTakenSeconds    int `json:"time_taken_seconds"`
+}
+
+type SubmitAnswersRequest struct {
+	SessionToken string       `json:"session_token"`
+	Answers      []AnswerItem `json:"answers"`
+}
+
+type AnswerResult struct {
+	QuestionID int    `json:"question_id"`
+	Success    bool   `json:"success"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+}
+
+type SubmitAnswersResponse struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message"`
+	Saved   int            `json:"saved"`
+	Failed  int            `json:"failed"`
+	Results []AnswerResult `json:"results,omitempty"`
 }
 
 type EndSessionRequest struct {
@@ -34,10 +91,13 @@ type EndSessionResponse struct {
 	Score              *int   `json:"score,omitempty"`
 	TotalTimeTaken     *int   `json:"total_time_taken_seconds,omitempty"`
 	TotalQuestions     *int   `json:"total_questions_answered,omitempty"`
+	// ResultToken lets the student come back to POST /api/live/result
+	ResultToken string `json:"result_token,omitempty"`
 }
 
 type GetResultRequest struct {
-	Email string `json:"email"`
+	// Token is the student's result token, from end-session or the results email link
+	Token string `json:"token"`
 }
 
 type StudentInfo struct {
@@ -50,6 +110,8 @@ type SessionInfo struct {
 	TotalTimeTakenSeconds  int  `json:"total_time_taken_seconds"`
 	TotalQuestionsAnswered int  `json:"total_questions_answered"`
 	Completed              bool `json:"completed"`
+	AttemptNumber          int  `json:"attempt_number"`
+	Attempts               int  `json:"attempts"`
 }
 
 type QuestionResult struct {
@@ -76,284 +138,841 @@ type GetResultResponse struct {
 	Student  *StudentInfo    `json:"student,omitempty"`
 	Session  *SessionInfo    `json:"session,omitempty"`
 	Sections []SectionResult `json:"sections,omitempty"`
+	// Published is false until results are published; until then sections (the answer
+	// key) are left out
+	Published          bool       `json:"published"`
+	ResultsPublishedAt *time.Time `json:"results_published_at,omitempty"`
+}
+
+type GetQuestionsResponse struct {
+	Success  bool                      `json:"success"`
+	Message  string                    `json:"message,omitempty"`
+	Sections []questions.PublicSection `json:"sections,omitempty"`
+}
+
+// GetQuestionsHandler handles GET /api/live/questions
+// Session token via "Authorization: Bearer <session_token>" or ?token=<session_token>.
+// Returns the session's own question set: shuffled and/or sampled per QUESTIONS_SHUFFLE
+// and QUESTIONS_PER_SECTION, reproducible from a seed stored on the session.
+func GetQuestionsHandler(c *fiber.Ctx) error {
+	sessionToken := strings.TrimSpace(strings.TrimPrefix(c.Get("Authorization"), "Bearer"))
+	if sessionToken == "" {
+		sessionToken = c.Query("token")
+	}
+
+	if sessionToken == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Session token is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Assign the seed on first fetch; later fetches (e.g. page reloads) get the same set
+	session, completed, err := questionSession(ctx, sessionToken)
+	if err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Session validation failed")
+		return apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeSessionInvalid, "Invalid session token")
+	}
+	sessionID, studentID, seed := session.ID, session.StudentID, *session.QuestionSeed
+	logging.SetStudent(c, studentID)
+	logging.SetSession(c, sessionID)
+
+	if completed {
+		return apierror.SendCode(c, fiber.StatusForbidden, apierror.CodeTestCompleted, "Test already completed")
+	}
+
+	// Keep the cached session's seed in step so submit-answer checks the same question set
+	sessioncache.Put(ctx, sessionToken, session)
+
+	sections, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+	}
+
+	return c.JSON(GetQuestionsResponse{
+		Success:  true,
+		Sections: questions.Public(sections, seed, questions.ConfigFromEnv()),
+	})
 }
 
 // SubmitAnswerHandler handles POST /api/live/submit-answer
 func SubmitAnswerHandler(c *fiber.Ctx) error {
 	var req SubmitAnswerRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(SubmitAnswerResponse{
-			Success: false,
-			Message: "Invalid request body",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
 	// Validate required fields
 	if req.SessionToken == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(SubmitAnswerResponse{
+		return apierror.Send(c, fiber.StatusBadRequest, "Session token is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Step 1 & 2: Validate session token (cached) and check the test is not completed
+	session, err := sessioncache.Lookup(ctx, req.SessionToken)
+	if err != nil && err != sessioncache.ErrCompleted {
+		logging.Ctx(c).Warn().Err(err).Msg("Session validation failed")
+		return apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeSessionInvalid, "Invalid session token")
+	}
+	sessionID, questionSeed, clientTime := session.ID, session.QuestionSeed, req.TimeTakenSeconds
+	logging.SetStudent(c, session.StudentID)
+	logging.SetSession(c, sessionID)
+
+	// Every attempt from here on is kept in answer_events, with its outcome, and counted in
+	// the live metrics
+	respond := func(status int, resp SubmitAnswerResponse) error {
+		written := 0
+		if resp.Status == AnswerStatusCreated || resp.Status == AnswerStatusUpdated {
+			written = 1
+		}
+		livemetrics.RecordSubmission(sessionID, status, written)
+		recordAnswerEvents(ctx, c, sessionID, false, []answerEvent{{
+			QuestionID:          req.QuestionID,
+			SelectedOptionIndex: req.SelectedOptionIndex,
+			ClientTimeTaken:     clientTime,
+			Outcome:             answerOutcome(status, resp),
+			Reason:              reasonFor(resp),
+			PreviousOption:      resp.PreviousOption,
+		}})
+		if status >= fiber.StatusBadRequest {
+			code := resp.Code
+			if code == "" {
+				code = apierror.CodeForStatus(status)
+			}
+			var details interface{}
+			if resp.Status != "" {
+				details = fiber.Map{"status": resp.Status}
+			}
+			return apierror.Respond(c, status, code, resp.Message, details)
+		}
+		return c.Status(status).JSON(resp)
+	}
+	if err == sessioncache.ErrCompleted {
+		return respond(fiber.StatusForbidden, SubmitAnswerResponse{
 			Success: false,
-			Message: "Session token is required",
+			Message: "Test already completed",
+			Code:    apierror.CodeTestCompleted,
 		})
 	}
 
-	if req.QuestionID <= 0 || req.QuestionID > 120 {
-		return c.Status(fiber.StatusBadRequest).JSON(SubmitAnswerResponse{
+	// Step 2a: No answers are taken while organizers have paused the exam
+	paused, err := examPaused(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check exam pause")
+		return respond(fiber.StatusInternalServerError, SubmitAnswerResponse{
 			Success: false,
-			Message: "Invalid question ID (must be 1-120)",
+			Message: "Failed to save answer",
 		})
 	}
-
-	if req.SelectedOptionIndex < 0 || req.SelectedOptionIndex > 3 {
-		return c.Status(fiber.StatusBadRequest).JSON(SubmitAnswerResponse{
+	if paused {
+		return respond(fiber.StatusLocked, SubmitAnswerResponse{
 			Success: false,
-			Message: "Invalid option index (must be 0-3)",
+			Message: errExamPaused.Error(),
+			Status:  AnswerStatusRejected,
+			Code:    apierror.CodeExamPaused,
 		})
 	}
 
-	if req.TimeTakenSeconds < 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(SubmitAnswerResponse{
+	// A late entrant's answers stop at their finish_by, checked as each answer is written
+	up, err := timeUp(ctx, session)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check session deadline")
+		return respond(fiber.StatusInternalServerError, SubmitAnswerResponse{
 			Success: false,
-			Message: "Invalid time taken",
+			Message: "Failed to save answer",
+		})
+	}
+	if up {
+		return respond(fiber.StatusForbidden, SubmitAnswerResponse{
+			Success: false,
+			Message: errTimeUp.Error(),
+			Status:  AnswerStatusRejected,
+			Code:    apierror.CodeTestExpired,
 		})
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	if message := validateAnswer(req.QuestionID, req.SelectedOptionIndex, req.TimeTakenSeconds); message != "" {
+		return respond(fiber.StatusBadRequest, SubmitAnswerResponse{
+			Success: false,
+			Message: message,
+		})
+	}
 
-	// Step 1: Validate session token and get session_id
-	var sessionID int
-	var completed bool
-	sessionQuery := `
-		SELECT id, completed
-		FROM sessions
-		WHERE session_token = $1
-	`
-	err := db.Pool.QueryRow(ctx, sessionQuery, req.SessionToken).Scan(&sessionID, &completed)
+	// Step 2b: Grade on the server, since clients never see the answer key. With per-student
+	// sampling, only questions from the session's set count. Sessions that never fetched
+	// /api/live/questions have no seed and use the full bank with its option order.
+	sections, err := questions.Load()
 	if err != nil {
-		log.Printf("Session validation failed: %v", err)
-		return c.Status(fiber.StatusNotFound).JSON(SubmitAnswerResponse{
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return respond(fiber.StatusInternalServerError, SubmitAnswerResponse{
 			Success: false,
-			Message: "Invalid session token",
+			Message: "Failed to save answer",
 		})
 	}
-
-	// Step 2: Check if test is already completed
-	if completed {
-		return c.Status(fiber.StatusForbidden).JSON(SubmitAnswerResponse{
+	cfg := questions.ConfigFromEnv()
+	question := questions.Find(sections, req.QuestionID)
+	if question == nil || (questions.Sampled(sections, cfg) && questionSeed != nil && !questions.Contains(questions.ForSeed(sections, *questionSeed, cfg), req.QuestionID)) {
+		return respond(fiber.StatusBadRequest, SubmitAnswerResponse{
 			Success: false,
-			Message: "Test already completed",
+			Message: "Question is not part of this session",
+		})
+	}
+	selectedOption, isCorrect, ok := questions.Grade(*question, questionSeed, cfg, req.SelectedOptionIndex)
+	if !ok {
+		return respond(fiber.StatusBadRequest, SubmitAnswerResponse{
+			Success: false,
+			Message: "Invalid option index",
 		})
 	}
 
-	// Step 3: Check if answer already submitted for this question
-	var existingAnswerID int
-	checkQuery := `SELECT id FROM answers WHERE session_id = $1 AND question_id = $2 LIMIT 1`
-	err = db.Pool.QueryRow(ctx, checkQuery, sessionID, req.QuestionID).Scan(&existingAnswerID)
-	if err == nil {
-		return c.Status(fiber.StatusConflict).JSON(SubmitAnswerResponse{
+	// Step 2c: With locked navigation, only the section in progress accepts answers
+	gate, err := loadSectionGate(ctx, sessionID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load section progress")
+		return respond(fiber.StatusInternalServerError, SubmitAnswerResponse{
 			Success: false,
-			Message: "Answer already submitted for this question",
+			Message: "Failed to save answer",
+		})
+	}
+	if err := gate.check(req.QuestionID); err != nil {
+		return respond(fiber.StatusBadRequest, SubmitAnswerResponse{
+			Success: false,
+			Message: err.Error(),
+			Status:  AnswerStatusRejected,
 		})
 	}
 
-	// Step 4: Insert answer into database
-	insertQuery := `
-		INSERT INTO answers (session_id, question_id, selected_option_index, is_correct, time_taken_seconds)
-		VALUES ($1, $2, $3, $4, $5)
-	`
-	_, err = db.Pool.Exec(ctx, insertQuery, sessionID, req.QuestionID, req.SelectedOptionIndex, req.IsCorrect, req.TimeTakenSeconds)
+	// Step 2d: Measure time taken on the server; the client's value is kept for review
+	if questionTiming() == TimingServer {
+		times, rejected, err := serverTimes(ctx, sessionID, []int{req.QuestionID})
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to measure answer time")
+			return respond(fiber.StatusInternalServerError, SubmitAnswerResponse{
+				Success: false,
+				Message: "Failed to save answer",
+			})
+		}
+		if err := rejected[req.QuestionID]; err != nil {
+			return respond(fiber.StatusBadRequest, SubmitAnswerResponse{
+				Success: false,
+				Message: err.Error(),
+				Status:  AnswerStatusRejected,
+			})
+		}
+		req.TimeTakenSeconds = times[req.QuestionID]
+	}
+
+	// Step 3: Upsert the answer. Retries of the same answer are idempotent; a different
+	// answer for the same question replaces the old one (last write wins) only when the
+	// exam allows answer changes. The replaced answer is kept in answer_events.
+	if answerchange.Allowed(ctx) {
+		upsertQuery := `
+			WITH previous AS (
+				SELECT selected_option_index FROM answers
+				WHERE session_id = $1 AND question_id = $2
+				FOR UPDATE
+			)
+			INSERT INTO answers (session_id, question_id, selected_option_index, is_correct, time_taken_seconds, client_time_taken_seconds)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (session_id, question_id)
+			DO UPDATE SET selected_option_index = EXCLUDED.selected_option_index,
+			              is_correct = EXCLUDED.is_correct,
+			              time_taken_seconds = EXCLUDED.time_taken_seconds,
+			              client_time_taken_seconds = EXCLUDED.client_time_taken_seconds,
+			              submitted_at = NOW()
+			WHERE answers.selected_option_index <> EXCLUDED.selected_option_index
+			RETURNING (xmax = 0) AS inserted, (SELECT selected_option_index FROM previous)
+		`
+		var inserted bool
+		var previous *int
+		insertStart := time.Now()
+		err = db.Pool.QueryRow(ctx, upsertQuery, sessionID, req.QuestionID, selectedOption, isCorrect, req.TimeTakenSeconds, clientTime).Scan(&inserted, &previous)
+		livemetrics.RecordInsert(time.Since(insertStart))
+		if errors.Is(err, pgx.ErrNoRows) {
+			// The same option was already recorded, so nothing changed
+			return respond(fiber.StatusOK, SubmitAnswerResponse{
+				Success: true,
+				Message: "Answer already recorded",
+				Status:  AnswerStatusDuplicate,
+			})
+		}
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to upsert answer")
+			return respond(fiber.StatusInternalServerError, SubmitAnswerResponse{
+				Success: false,
+				Message: "Failed to save answer",
+			})
+		}
+
+		if !inserted {
+			return respond(fiber.StatusOK, SubmitAnswerResponse{
+				Success:        true,
+				Message:        "Answer updated successfully",
+				Status:         AnswerStatusUpdated,
+				PreviousOption: previous,
+			})
+		}
+
+		return respond(fiber.StatusCreated, SubmitAnswerResponse{
+			Success: true,
+			Message: "Answer submitted successfully",
+			Status:  AnswerStatusCreated,
+		})
+	}
+
+	// With ANSWER_INGEST=batch the answer is written with others in its batch
+	insertStart := time.Now()
+	inserted, err := ingest.Insert(ctx, ingest.Answer{
+		SessionID:  sessionID,
+		QuestionID: req.QuestionID,
+		Option:     selectedOption,
+		Correct:    isCorrect,
+		TimeTaken:  req.TimeTakenSeconds,
+		ClientTime: clientTime,
+	})
+	livemetrics.RecordInsert(time.Since(insertStart))
 	if err != nil {
-		log.Printf("Failed to insert answer: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(SubmitAnswerResponse{
+		logging.Ctx(c).Error().Err(err).Msg("Failed to insert answer")
+		return respond(fiber.StatusInternalServerError, SubmitAnswerResponse{
 			Success: false,
 			Message: "Failed to save answer",
 		})
 	}
 
+	if !inserted {
+		// Step 4: Already answered - a retry of the same answer succeeds, a different one is rejected
+		var existingOption int
+		existingQuery := `SELECT selected_option_index FROM answers WHERE session_id = $1 AND question_id = $2`
+		err = db.Pool.QueryRow(ctx, existingQuery, sessionID, req.QuestionID).Scan(&existingOption)
+		if err == nil && existingOption == selectedOption {
+			return respond(fiber.StatusOK, SubmitAnswerResponse{
+				Success: true,
+				Message: "Answer already recorded",
+				Status:  AnswerStatusDuplicate,
+			})
+		}
+
+		return respond(fiber.StatusConflict, SubmitAnswerResponse{
+			Success: false,
+			Message: "Answer already submitted for this question",
+			Status:  AnswerStatusDuplicate,
+		})
+	}
+
 	// Step 5: Return success
-	return c.Status(fiber.StatusCreated).JSON(SubmitAnswerResponse{
+	return respond(fiber.StatusCreated, SubmitAnswerResponse{
 		Success: true,
 		Message: "Answer submitted successfully",
+		Status:  AnswerStatusCreated,
 	})
 }
 
+// validateAnswer returns why an answer is invalid, or "" if it is valid
+func validateAnswer(questionID, selectedOptionIndex, timeTakenSeconds int) string {
+	if questionID <= 0 || questionID > 120 {
+		return "Invalid question ID (must be 1-120)"
+	}
+	if selectedOptionIndex < 0 || selectedOptionIndex > 3 {
+		return "Invalid option index (must be 0-3)"
+	}
+	if timeTakenSeconds < 0 {
+		return "Invalid time taken"
+	}
+	return ""
+}
+
+// SubmitAnswersHandler handles POST /api/live/submit-answers
+// Saves up to 200 answers in one statement with the same rules as submit-answer, and
+// reports the outcome of each in request order
+func SubmitAnswersHandler(c *fiber.Ctx) error {
+	var req SubmitAnswersRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if req.SessionToken == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Session token is required")
+	}
+
+	if len(req.Answers) == 0 || len(req.Answers) > maxAnswersPerBatch {
+		return apierror.Send(c, fiber.StatusBadRequest, fmt.Sprintf("Between 1 and %d answers are required", maxAnswersPerBatch))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Step 1: Validate session token (cached) and check the test is not completed
+	session, err := sessioncache.Lookup(ctx, req.SessionToken)
+	if err != nil && err != sessioncache.ErrCompleted {
+		logging.Ctx(c).Warn().Err(err).Msg("Session validation failed")
+		return apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeSessionInvalid, "Invalid session token")
+	}
+	sessionID := session.ID
+	logging.SetStudent(c, session.StudentID)
+	logging.SetSession(c, sessionID)
+
+	// Every answer in the request is kept in answer_events; outcome applies to all of them
+	// when the request fails as a whole
+	recordAll := func(outcome, reason string) {
+		status := fiber.StatusBadRequest
+		if outcome == AnswerStatusError {
+			status = fiber.StatusInternalServerError
+		}
+		livemetrics.RecordSubmission(sessionID, status, 0)
+		events := make([]answerEvent, len(req.Answers))
+		for i, answer := range req.Answers {
+			events[i] = answerEvent{answer.QuestionID, answer.SelectedOptionIndex, answer.TimeTakenSeconds, outcome, reason, nil}
+		}
+		recordAnswerEvents(ctx, c, sessionID, true, events)
+	}
+	if err == sessioncache.ErrCompleted {
+		recordAll(AnswerStatusRejected, "Test already completed")
+		return apierror.SendCode(c, fiber.StatusForbidden, apierror.CodeTestCompleted, "Test already completed")
+	}
+
+	// No answers are taken while organizers have paused the exam
+	paused, err := examPaused(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check exam pause")
+		recordAll(AnswerStatusError, "Failed to save answers")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to save answers")
+	}
+	if paused {
+		recordAll(AnswerStatusRejected, errExamPaused.Error())
+		return apierror.SendCode(c, fiber.StatusLocked, apierror.CodeExamPaused, errExamPaused.Error())
+	}
+
+	// Nor after a late entrant's finish_by
+	up, err := timeUp(ctx, session)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check session deadline")
+		recordAll(AnswerStatusError, "Failed to save answers")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to save answers")
+	}
+	if up {
+		recordAll(AnswerStatusRejected, errTimeUp.Error())
+		return apierror.SendCode(c, fiber.StatusForbidden, apierror.CodeTestExpired, errTimeUp.Error())
+	}
+
+	// Answers are graded on the server. With per-student sampling, only questions from the
+	// session's set count.
+	sections, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		recordAll(AnswerStatusError, "Failed to save answers")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to save answers")
+	}
+	cfg := questions.ConfigFromEnv()
+	var sessionSet []questions.Section
+	if questions.Sampled(sections, cfg) && session.QuestionSeed != nil {
+		sessionSet = questions.ForSeed(sections, *session.QuestionSeed, cfg)
+	}
+
+	// With locked navigation, only the section in progress accepts answers
+	gate, err := loadSectionGate(ctx, sessionID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load section progress")
+		recordAll(AnswerStatusError, "Failed to save answers")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to save answers")
+	}
+
+	// Step 2: Validate each answer; later copies of a question in the same request are rejected
+	results := make([]AnswerResult, len(req.Answers))
+	position := make(map[int]int, len(req.Answers))
+	var questionIDs, options, timesTaken []int
+	var correct []bool
+	for i, answer := range req.Answers {
+		results[i] = AnswerResult{QuestionID: answer.QuestionID, Status: AnswerStatusRejected}
+		message := validateAnswer(answer.QuestionID, answer.SelectedOptionIndex, answer.TimeTakenSeconds)
+		question := questions.Find(sections, answer.QuestionID)
+		if message == "" && (question == nil || sessionSet != nil && !questions.Contains(sessionSet, answer.QuestionID)) {
+			message = "Question is not part of this session"
+		}
+		var selectedOption int
+		var isCorrect bool
+		if message == "" {
+			var ok bool
+			if selectedOption, isCorrect, ok = questions.Grade(*question, session.QuestionSeed, cfg, answer.SelectedOptionIndex); !ok {
+				message = "Invalid option index"
+			}
+		}
+		if err := gate.check(answer.QuestionID); message == "" && err != nil {
+			message = err.Error()
+		}
+		if _, seen := position[answer.QuestionID]; message == "" && seen {
+			message = "Question appears more than once in this request"
+		}
+		if message != "" {
+			results[i].Message = message
+			continue
+		}
+
+		position[answer.QuestionID] = i
+		questionIDs = append(questionIDs, answer.QuestionID)
+		options = append(options, selectedOption)
+		correct = append(correct, isCorrect)
+		timesTaken = append(timesTaken, answer.TimeTakenSeconds)
+	}
+
+	// Step 2b: Measure time taken on the server; answers that fail the timing checks are
+	// rejected and the client's values are kept for review
+	clientTimes := timesTaken
+	if questionTiming() == TimingServer && len(questionIDs) > 0 {
+		times, rejected, err := serverTimes(ctx, sessionID, questionIDs)
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to measure answer times")
+			recordAll(AnswerStatusError, "Failed to save answers")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to save answers")
+		}
+		var keptIDs, keptOptions, keptTimes, keptClientTimes []int
+		var keptCorrect []bool
+		for i, questionID := range questionIDs {
+			if err := rejected[questionID]; err != nil {
+				results[position[questionID]].Message = err.Error()
+				continue
+			}
+			keptIDs = append(keptIDs, questionID)
+			keptOptions = append(keptOptions, options[i])
+			keptCorrect = append(keptCorrect, correct[i])
+			keptTimes = append(keptTimes, times[questionID])
+			keptClientTimes = append(keptClientTimes, timesTaken[i])
+		}
+		questionIDs, options, correct, timesTaken, clientTimes = keptIDs, keptOptions, keptCorrect, keptTimes, keptClientTimes
+	}
+
+	// Step 3: Save the valid answers in one multi-row statement
+	previous := make(map[int]*int)
+	if len(questionIDs) > 0 {
+		insertStart := time.Now()
+		err := saveAnswers(ctx, sessionID, questionIDs, options, correct, timesTaken, clientTimes, func(questionID int, status string, previousOption *int) {
+			result := &results[position[questionID]]
+			result.Status, result.Success = status, status != AnswerStatusConflict
+			previous[questionID] = previousOption
+			if status == AnswerStatusConflict {
+				result.Message = "Answer already submitted for this question"
+			}
+		})
+		livemetrics.RecordInsert(time.Since(insertStart))
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Int("answers", len(questionIDs)).Msg("Failed to save answers")
+			recordAll(AnswerStatusError, "Failed to save answers")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to save answers")
+		}
+	}
+
+	saved, written := 0, 0
+	events := make([]answerEvent, len(results))
+	for i, result := range results {
+		if result.Success {
+			saved++
+		}
+		if result.Status == AnswerStatusCreated || result.Status == AnswerStatusUpdated {
+			written++
+		}
+		answer := req.Answers[i]
+		events[i] = answerEvent{answer.QuestionID, answer.SelectedOptionIndex, answer.TimeTakenSeconds, result.Status, result.Message, nil}
+		if result.Status == AnswerStatusUpdated && position[answer.QuestionID] == i {
+			events[i].PreviousOption = previous[answer.QuestionID]
+		}
+	}
+	recordAnswerEvents(ctx, c, sessionID, true, events)
+	livemetrics.RecordSubmission(sessionID, fiber.StatusOK, written)
+	failed := len(results) - saved
+
+	message := "Answers submitted successfully"
+	if failed > 0 {
+		message = fmt.Sprintf("%d of %d answers were not saved", failed, len(results))
+	}
+	return c.JSON(SubmitAnswersResponse{
+		Success: failed == 0,
+		Message: message,
+		Saved:   saved,
+		Failed:  failed,
+		Results: results,
+	})
+}
+
+// saveAnswers inserts a session's answers from parallel arrays and reports each question's
+// outcome. Unless the exam allows answer changes an existing answer is kept: the same option
+// is a duplicate and a different one a conflict. With them, a different option replaces the
+// existing answer and is reported with the option it replaced.
+func saveAnswers(ctx context.Context, sessionID int, questionIDs, options []int, correct []bool, timesTaken, clientTimes []int, report func(questionID int, status string, previousOption *int)) error {
+	if answerchange.Allowed(ctx) {
+		// Questions whose option did not change are left alone and come back without a row
+		upsertQuery := `
+			WITH previous AS (
+				SELECT question_id, selected_option_index FROM answers
+				WHERE session_id = $1 AND question_id = ANY($2::int[])
+				FOR UPDATE
+			),
+			saved AS (
+				INSERT INTO answers (session_id, question_id, selected_option_index, is_correct, time_taken_seconds, client_time_taken_seconds)
+				SELECT $1, t.question_id, t.selected_option_index, t.is_correct, t.time_taken_seconds, t.client_time_taken_seconds
+				FROM unnest($2::int[], $3::int[], $4::bool[], $5::int[], $6::int[])
+				     AS t(question_id, selected_option_index, is_correct, time_taken_seconds, client_time_taken_seconds)
+				ON CONFLICT (session_id, question_id)
+				DO UPDATE SET selected_option_index = EXCLUDED.selected_option_index,
+				              is_correct = EXCLUDED.is_correct,
+				              time_taken_seconds = EXCLUDED.time_taken_seconds,
+				              client_time_taken_seconds = EXCLUDED.client_time_taken_seconds,
+				              submitted_at = NOW()
+				WHERE answers.selected_option_index <> EXCLUDED.selected_option_index
+				RETURNING question_id, (xmax = 0) AS inserted
+			)
+			SELECT t.question_id, s.question_id IS NOT NULL, COALESCE(s.inserted, false), p.selected_option_index
+			FROM unnest($2::int[]) AS t(question_id)
+			LEFT JOIN saved s ON s.question_id = t.question_id
+			LEFT JOIN previous p ON p.question_id = t.question_id
+		`
+		rows, err := db.Pool.Query(ctx, upsertQuery, sessionID, questionIDs, options, correct, timesTaken, clientTimes)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var questionID int
+			var saved, inserted bool
+			var previousOption *int
+			if err := rows.Scan(&questionID, &saved, &inserted, &previousOption); err != nil {
+				return err
+			}
+			switch {
+			case !saved:
+				report(questionID, AnswerStatusDuplicate, nil)
+			case inserted:
+				report(questionID, AnswerStatusCreated, nil)
+			default:
+				report(questionID, AnswerStatusUpdated, previousOption)
+			}
+		}
+		return rows.Err()
+	}
+
+	// The outer SELECT does not see rows inserted by the CTE, so "existing" is the answer
+	// recorded before this request
+	insertQuery := `
+		WITH input AS (
+			SELECT *
+			FROM unnest($2::int[], $3::int[], $4::bool[], $5::int[], $6::int[])
+			     AS t(question_id, selected_option_index, is_correct, time_taken_seconds, client_time_taken_seconds)
+		),
+		inserted AS (
+			INSERT INTO answers (session_id, question_id, selected_option_index, is_correct, time_taken_seconds, client_time_taken_seconds)
+			SELECT $1, question_id, selected_option_index, is_correct, time_taken_seconds, client_time_taken_seconds
+			FROM input
+			ON CONFLICT (session_id, question_id) DO NOTHING
+			RETURNING question_id
+		)
+		SELECT i.question_id, ins.question_id IS NOT NULL, existing.selected_option_index = i.selected_option_index
+		FROM input i
+		LEFT JOIN inserted ins ON ins.question_id = i.question_id
+		LEFT JOIN answers existing ON existing.session_id = $1 AND existing.question_id = i.question_id
+	`
+	rows, err := db.Pool.Query(ctx, insertQuery, sessionID, questionIDs, options, correct, timesTaken, clientTimes)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var questionID int
+		var inserted bool
+		var sameOption *bool
+		if err := rows.Scan(&questionID, &inserted, &sameOption); err != nil {
+			return err
+		}
+		switch {
+		case inserted:
+			report(questionID, AnswerStatusCreated, nil)
+		case sameOption != nil && *sameOption:
+			report(questionID, AnswerStatusDuplicate, nil)
+		default:
+			report(questionID, AnswerStatusConflict, nil)
+		}
+	}
+	return rows.Err()
+}
+
 // EndSessionHandler handles POST /api/live/end-session
 func EndSessionHandler(c *fiber.Ctx) error {
 	var req EndSessionRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(EndSessionResponse{
-			Success: false,
-			Message: "Invalid request body",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
 	// Validate required fields
 	if req.SessionToken == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(EndSessionResponse{
-			Success: false,
-			Message: "Session token is required",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Session token is required")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	// Step 1: Validate session token and get session_id and started_at
-	var sessionID int
+	var sessionID, studentID int
 	var completed bool
 	var startedAt time.Time
 	sessionQuery := `
-		SELECT id, completed, started_at
+		SELECT id, student_id, completed, started_at
 		FROM sessions
 		WHERE session_token = $1
 	`
-	err := db.Pool.QueryRow(ctx, sessionQuery, req.SessionToken).Scan(&sessionID, &completed, &startedAt)
+	err := db.Pool.QueryRow(ctx, sessionQuery, req.SessionToken).Scan(&sessionID, &studentID, &completed, &startedAt)
 	if err != nil {
-		log.Printf("Session validation failed: %v", err)
-		return c.Status(fiber.StatusNotFound).JSON(EndSessionResponse{
-			Success: false,
-			Message: "Invalid session token",
-		})
+		logging.Ctx(c).Warn().Err(err).Msg("Session validation failed")
+		return apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeSessionInvalid, "Invalid session token")
 	}
+	logging.SetStudent(c, studentID)
+	logging.SetSession(c, sessionID)
 
 	// Step 2: Check if test is already completed
 	if completed {
-		return c.Status(fiber.StatusConflict).JSON(EndSessionResponse{
-			Success: false,
-			Message: "Test already completed",
-		})
+		return apierror.SendCode(c, fiber.StatusConflict, apierror.CodeTestCompleted, "Test already completed")
 	}
 
-	// Step 3: Calculate total score (count of correct answers)
-	var score int
-	scoreQuery := `
-		SELECT COUNT(*)
-		FROM answers
-		WHERE session_id = $1 AND is_correct = true
-	`
-	err = db.Pool.QueryRow(ctx, scoreQuery, sessionID).Scan(&score)
+	// Step 2b: A paused exam cannot be submitted; the candidate ends it after it resumes
+	paused, err := examPaused(ctx)
 	if err != nil {
-		log.Printf("Failed to calculate score: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(EndSessionResponse{
-			Success: false,
-			Message: "Failed to calculate score",
-		})
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check exam pause")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to end session")
+	}
+	if paused {
+		return apierror.SendCode(c, fiber.StatusLocked, apierror.CodeExamPaused, errExamPaused.Error())
 	}
 
-	// Step 4: Calculate total time taken (sum of all answer times)
-	var totalTimeTaken int
-	timeQuery := `
-		SELECT COALESCE(SUM(time_taken_seconds), 0)
-		FROM answers
-		WHERE session_id = $1
-	`
-	err = db.Pool.QueryRow(ctx, timeQuery, sessionID).Scan(&totalTimeTaken)
+	// Step 3: Compute score, total time and questions answered, then complete the session and
+	// store its section results, all in one transaction
+	result, err := scoring.Finalize(ctx, sessionID)
+	if errors.Is(err, scoring.ErrAlreadyCompleted) {
+		// A concurrent end-session finished it first
+		return apierror.SendCode(c, fiber.StatusConflict, apierror.CodeTestCompleted, "Test already completed")
+	}
 	if err != nil {
-		log.Printf("Failed to calculate total time: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(EndSessionResponse{
-			Success: false,
-			Message: "Failed to calculate total time",
-		})
+		logging.Ctx(c).Error().Err(err).Msg("Failed to finalize session")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to end session")
 	}
+	score, totalTimeTaken, totalQuestions := result.Score, result.TotalTimeTaken, result.TotalQuestions
+	livemetrics.RecordCompletion(sessionID)
 
-	// Step 5: Get total questions answered
-	var totalQuestions int
-	countQuery := `
-		SELECT COUNT(*)
-		FROM answers
-		WHERE session_id = $1
-	`
-	err = db.Pool.QueryRow(ctx, countQuery, sessionID).Scan(&totalQuestions)
-	if err != nil {
-		log.Printf("Failed to count questions: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(EndSessionResponse{
-			Success: false,
-			Message: "Failed to count questions answered",
-		})
+	// The section in progress ends with the test
+	if _, err := db.Pool.Exec(ctx, `UPDATE session_sections SET ended_at = NOW() WHERE session_id = $1 AND ended_at IS NULL`, sessionID); err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Failed to end open section")
 	}
 
-	// Step 6: Update session with completion data
-	updateQuery := `
-		UPDATE sessions
-		SET completed = true,
-		    completed_at = NOW(),
-		    score = $1,
-		    total_time_taken_seconds = $2,
-		    updated_at = NOW()
-		WHERE id = $3
-	`
-	_, err = db.Pool.Exec(ctx, updateQuery, score, totalTimeTaken, sessionID)
+	// Completed sessions must stop validating, including on other instances
+	sessioncache.Invalidate(ctx, req.SessionToken)
+
+	events.Publish(events.SessionCompleted, fiber.Map{
+		"session_id":               sessionID,
+		"student_id":               studentID,
+		"score":                    score,
+		"total_time_taken_seconds": totalTimeTaken,
+		"total_questions_answered": totalQuestions,
+	})
+
+	// The result token is how the student views the result later; the test is already
+	// complete, so a failure here does not fail the request
+	resultToken, err := campaigns.ResultToken(ctx, studentID)
 	if err != nil {
-		log.Printf("Failed to update session: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(EndSessionResponse{
-			Success: false,
-			Message: "Failed to end session",
-		})
+		logging.Ctx(c).Warn().Err(err).Msg("Failed to issue result token")
 	}
 
-	// Step 7: Return success with results
+	// Step 4: Return success with results
 	return c.Status(fiber.StatusOK).JSON(EndSessionResponse{
 		Success:        true,
 		Message:        "Test completed successfully",
 		Score:          &score,
 		TotalTimeTaken: &totalTimeTaken,
 		TotalQuestions: &totalQuestions,
+		ResultToken:    resultToken,
 	})
 }
 
 // GetResultHandler handles POST /api/live/result
+// Body: {"token": "<result token>"}
+// Returns the student's score; the questions with their answers and the answer key only
+// once results are published (GET/PUT /api/admin/results-publication)
 func GetResultHandler(c *fiber.Ctx) error {
 	var req GetResultRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(GetResultResponse{
-			Success: false,
-			Message: "Invalid request body",
-		})
+		return apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
 	}
 
-	// Validate email
-	if req.Email == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(GetResultResponse{
-			Success: false,
-			Message: "Email is required",
-		})
+	req.Token = strings.TrimSpace(req.Token)
+	if req.Token == "" {
+		return apierror.Send(c, fiber.StatusUnauthorized, "Result token is required")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Step 1: Get student by email
-	var studentID int
-	var studentName string
-	studentQuery := `
-		SELECT id, name
-		FROM students
-		WHERE email = $1
-	`
-	err := db.Pool.QueryRow(ctx, studentQuery, req.Email).Scan(&studentID, &studentName)
+	// Step 1: Get the student the result token belongs to
+	studentID, err := campaigns.StudentByResultToken(ctx, req.Token)
+	if errors.Is(err, campaigns.ErrInvalidResultToken) {
+		return apierror.Send(c, fiber.StatusUnauthorized, "Invalid result token")
+	}
 	if err != nil {
-		log.Printf("Student not found: %v", err)
-		return c.Status(fiber.StatusNotFound).JSON(GetResultResponse{
-			Success: false,
-			Message: "Student not found",
-		})
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check result token")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch result")
+	}
+	logging.SetStudent(c, studentID)
+
+	var studentName, studentEmail string
+	err = db.Pool.QueryRow(ctx, `SELECT name, email FROM students WHERE id = $1`, studentID).Scan(&studentName, &studentEmail)
+	if err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Student not found")
+		return apierror.Send(c, fiber.StatusNotFound, "Student not found")
 	}
 
-	// Step 2: Get session by student_id
-	var sessionID int
+	// Step 2: Get the student's counted attempt (ATTEMPT_POLICY), or the latest one if none is completed
+	var sessionID, attemptNumber, attemptCount int
 	var score, totalTimeTaken int
 	var completed bool
+	var questionSeed *int64
 	sessionQuery := `
-		SELECT id, COALESCE(score, 0), COALESCE(total_time_taken_seconds, 0), completed
+		SELECT id, COALESCE(score, 0), COALESCE(total_time_taken_seconds, 0), completed, question_seed,
+		       attempt_number, COUNT(*) OVER ()
 		FROM sessions
 		WHERE student_id = $1
+		ORDER BY completed DESC, ` + attempts.ConfigFromEnv().OrderBy() + `
+		LIMIT 1
 	`
-	err = db.Pool.QueryRow(ctx, sessionQuery, studentID).Scan(&sessionID, &score, &totalTimeTaken, &completed)
+	err = db.Pool.QueryRow(ctx, sessionQuery, studentID).Scan(&sessionID, &score, &totalTimeTaken, &completed, &questionSeed,
+		&attemptNumber, &attemptCount)
 	if err != nil {
-		log.Printf("Session not found: %v", err)
-		return c.Status(fiber.StatusNotFound).JSON(GetResultResponse{
-			Success: false,
-			Message: "No session found for this student",
+		logging.Ctx(c).Warn().Err(err).Msg("Session not found")
+		return apierror.Send(c, fiber.StatusNotFound, "No session found for this student")
+	}
+	logging.SetSession(c, sessionID)
+
+	// Before publication only the score is returned, so the answer key cannot reach
+	// candidates still sitting the exam
+	published := publication.Published(ctx)
+	if !published.Published {
+		var answeredCount int
+		if err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM answers WHERE session_id = $1`, sessionID).Scan(&answeredCount); err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to count answers")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch answers")
+		}
+		return c.Status(fiber.StatusOK).JSON(GetResultResponse{
+			Success: true,
+			Message: "Answers are shown once results are published",
+			Student: &StudentInfo{
+				Name:  studentName,
+				Email: studentEmail,
+			},
+			Session: &SessionInfo{
+				Score:                  score,
+				TotalTimeTakenSeconds:  totalTimeTaken,
+				TotalQuestionsAnswered: answeredCount,
+				Completed:              completed,
+				AttemptNumber:          attemptNumber,
+				Attempts:               attemptCount,
+			},
+			Published:          false,
+			ResultsPublishedAt: published.PublishedAt,
 		})
 	}
 
@@ -365,11 +984,8 @@ func GetResultHandler(c *fiber.Ctx) error {
 	`
 	rows, err := db.Pool.Query(ctx, answersQuery, sessionID)
 	if err != nil {
-		log.Printf("Failed to fetch answers: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(GetResultResponse{
-			Success: false,
-			Message: "Failed to fetch answers",
-		})
+		logging.Ctx(c).Error().Err(err).Msg("Failed to fetch answers")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to fetch answers")
 	}
 	defer rows.Close()
 
@@ -385,7 +1001,7 @@ func GetResultHandler(c *fiber.Ctx) error {
 		var questionID, selectedOption, timeTaken int
 		var isCorrect bool
 		if err := rows.Scan(&questionID, &selectedOption, &isCorrect, &timeTaken); err != nil {
-			log.Printf("Failed to scan answer: %v", err)
+			logging.Ctx(c).Error().Err(err).Msg("Failed to scan answer")
 			continue
 		}
 		answersMap[questionID] = struct {
@@ -396,38 +1012,14 @@ func GetResultHandler(c *fiber.Ctx) error {
 		answeredCount++
 	}
 
-	// Step 4: Load questions from JSON file
-	questionsFile, err := os.ReadFile("questions_with_timer.json")
+	// Step 4: Load questions, in the order this session was served them
+	jsonSections, err := questions.Load()
 	if err != nil {
-		log.Printf("Failed to read questions file: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(GetResultResponse{
-			Success: false,
-			Message: "Failed to load questions",
-		})
-	}
-
-	// Define structure for parsing JSON
-	type JSONQuestion struct {
-		ID          int      `json:"id"`
-		Question    string   `json:"question"`
-		Description string   `json:"description"`
-		Options     []string `json:"options"`
-		CorrectAnswer int    `json:"correctAnswer"`
-	}
-	type JSONSection struct {
-		ID        int            `json:"id"`
-		Name      string         `json:"name"`
-		TimeLimit int            `json:"time_limit"`
-		Questions []JSONQuestion `json:"questions"`
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
 	}
-	var jsonSections []JSONSection
-
-	if err := json.Unmarshal(questionsFile, &jsonSections); err != nil {
-		log.Printf("Failed to parse questions JSON: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(GetResultResponse{
-			Success: false,
-			Message: "Failed to parse questions",
-		})
+	if questionSeed != nil {
+		jsonSections = questions.ForSeed(jsonSections, *questionSeed, questions.ConfigFromEnv())
 	}
 
 	// Step 5: Merge answers into questions
@@ -472,14 +1064,18 @@ func GetResultHandler(c *fiber.Ctx) error {
 		Success: true,
 		Student: &StudentInfo{
 			Name:  studentName,
-			Email: req.Email,
+			Email: studentEmail,
 		},
 		Session: &SessionInfo{
 			Score:                  score,
 			TotalTimeTakenSeconds:  totalTimeTaken,
 			TotalQuestionsAnswered: answeredCount,
 			Completed:              completed,
+			AttemptNumber:          attemptNumber,
+			Attempts:               attemptCount,
 		},
-		Sections: sections,
+		Sections:           sections,
+		Published:          true,
+		ResultsPublishedAt: published.PublishedAt,
 	})
 }
@@ -0,0 +1,509 @@
+package live
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/exampause"
+	"mcq-exam/logging"
+	"mcq-exam/questions"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// Section navigation modes (SECTION_NAVIGATION)
+const (
+	// NavigationLocked takes sections in order through start-section and end-section, and
+	// accepts answers only for the section in progress
+	NavigationLocked = "locked"
+	// NavigationFree accepts answers for any section without starting it
+	NavigationFree = "free"
+)
+
+// Section statuses reported by session-state, start-section and end-section
+const (
+	SectionNotStarted = "not_started"
+	SectionInProgress = "in_progress"
+	SectionEnded      = "ended"
+	// SectionExpired is a section whose time limit passed before end-section was called
+	SectionExpired = "expired"
+)
+
+// Reasons an answer is rejected under NavigationLocked
+var (
+	errSectionNotStarted = errors.New("Section has not been started")
+	errSectionEnded      = errors.New("Section has already ended")
+)
+
+type SectionRequest struct {
+	SessionToken string `json:"session_token"`
+	SectionID    int    `json:"section_id"`
+}
+
+type SectionState struct {
+	SectionID int    `json:"section_id"`
+	Name      string `json:"name"`
+	TimeLimit int    `json:"time_limit"`
+	Status    string `json:"status"`
+	// Answered counts the session's answers to questions in this section
+	Answered int `json:"answered"`
+	// Flagged and Skipped count the section's questions flagged for review and skipped
+	// without an answer since (see flag-question)
+	Flagged   int        `json:"flagged"`
+	Skipped   int        `json:"skipped"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	// EndsAt is when the time limit runs out; RemainingSeconds counts down to it while in progress
+	EndsAt           *time.Time `json:"ends_at,omitempty"`
+	RemainingSeconds *int       `json:"remaining_seconds,omitempty"`
+}
+
+type SectionResponse struct {
+	Success bool          `json:"success"`
+	Message string        `json:"message"`
+	Section *SectionState `json:"section,omitempty"`
+}
+
+type SessionStateResponse struct {
+	Success        bool           `json:"success"`
+	Message        string         `json:"message,omitempty"`
+	SessionID      int            `json:"session_id,omitempty"`
+	StartedAt      *time.Time     `json:"started_at,omitempty"`
+	Completed      bool           `json:"completed"`
+	Navigation     string         `json:"navigation,omitempty"`
+	CurrentSection *SectionState  `json:"current_section"`
+	Sections       []SectionState `json:"sections,omitempty"`
+	// Marks lists the questions flagged for review or skipped without an answer since
+	Marks []QuestionMark `json:"marks"`
+	// ExamPaused is set while organizers have paused the exam: answers and end-session are
+	// refused and section timers stand still until it resumes
+	ExamPaused  bool       `json:"exam_paused"`
+	PausedSince *time.Time `json:"paused_since,omitempty"`
+	PauseReason string     `json:"pause_reason,omitempty"`
+	// FinishBy is when a late entrant's reduced time runs out, pushed back by exam pauses;
+	// the test ends then even if a section has time left
+	FinishBy *time.Time `json:"finish_by,omitempty"`
+}
+
+// sectionProgress is a session_sections row
+type sectionProgress struct {
+	StartedAt time.Time
+	EndedAt   *time.Time
+	// Paused is how long the exam was paused while the section ran
+	Paused time.Duration
+}
+
+// endsAt is when a section's time limit runs out; exam pauses push it back
+func (p *sectionProgress) endsAt(timeLimit int) time.Time {
+	return p.StartedAt.Add(time.Duration(timeLimit)*time.Second + p.Paused)
+}
+
+// sectionNavigation returns the SECTION_NAVIGATION mode (locked or free, default locked)
+func sectionNavigation() string {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv("SECTION_NAVIGATION")))
+	switch value {
+	case "", NavigationLocked:
+		return NavigationLocked
+	case NavigationFree:
+		return NavigationFree
+	}
+	log.Warn().Msgf("Invalid SECTION_NAVIGATION=%q, using %s", value, NavigationLocked)
+	return NavigationLocked
+}
+
+// sectionStatus works out a section's status at now
+func sectionStatus(progress *sectionProgress, timeLimit int, now time.Time) string {
+	switch {
+	case progress == nil:
+		return SectionNotStarted
+	case progress.EndedAt != nil:
+		return SectionEnded
+	case timeLimit > 0 && now.After(progress.endsAt(timeLimit)):
+		return SectionExpired
+	}
+	return SectionInProgress
+}
+
+// newSectionState describes a section and its progress at now
+func newSectionState(section questions.Section, progress *sectionProgress, answered int, now time.Time) SectionState {
+	state := SectionState{
+		SectionID: section.ID,
+		Name:      section.Name,
+		TimeLimit: section.TimeLimit,
+		Status:    sectionStatus(progress, section.TimeLimit, now),
+		Answered:  answered,
+	}
+	if progress == nil {
+		return state
+	}
+	state.StartedAt = &progress.StartedAt
+	state.EndedAt = progress.EndedAt
+	if section.TimeLimit > 0 {
+		endsAt := progress.endsAt(section.TimeLimit)
+		state.EndsAt = &endsAt
+		if state.Status == SectionInProgress {
+			remaining := int(endsAt.Sub(now) / time.Second)
+			state.RemainingSeconds = &remaining
+		}
+	}
+	return state
+}
+
+// loadSectionProgress returns a session's started sections and the database's current time
+func loadSectionProgress(ctx context.Context, q interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}, sessionID int) (map[int]*sectionProgress, time.Time, error) {
+	query := `
+		SELECT ss.section_id, ss.started_at, ss.ended_at, NOW(),
+		       ` + exampause.PausedSeconds("ss.started_at", "COALESCE(ss.ended_at, NOW())") + `
+		FROM session_sections ss
+		WHERE ss.session_id = $1
+	`
+	rows, err := q.Query(ctx, query, sessionID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	progress := make(map[int]*sectionProgress)
+	now := time.Now()
+	for rows.Next() {
+		var sectionID int
+		var p sectionProgress
+		var pausedSeconds float64
+		if err := rows.Scan(&sectionID, &p.StartedAt, &p.EndedAt, &now, &pausedSeconds); err != nil {
+			return nil, time.Time{}, err
+		}
+		p.Paused = time.Duration(pausedSeconds * float64(time.Second))
+		progress[sectionID] = &p
+	}
+	return progress, now, rows.Err()
+}
+
+// sectionGate checks answers against the section in progress
+type sectionGate struct {
+	locked    bool
+	sectionOf map[int]questions.Section
+	progress  map[int]*sectionProgress
+	now       time.Time
+}
+
+// loadSectionGate reads the session's section progress; under NavigationFree it lets everything through
+func loadSectionGate(ctx context.Context, sessionID int) (*sectionGate, error) {
+	if sectionNavigation() != NavigationLocked {
+		return &sectionGate{}, nil
+	}
+	sections, err := questions.Load()
+	if err != nil {
+		return nil, err
+	}
+	progress, now, err := loadSectionProgress(ctx, db.Pool, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	gate := &sectionGate{locked: true, sectionOf: make(map[int]questions.Section), progress: progress, now: now}
+	for _, section := range sections {
+		for _, q := range section.Questions {
+			gate.sectionOf[q.ID] = section
+		}
+	}
+	return gate, nil
+}
+
+// check returns why an answer to questionID is not accepted, or nil. Answers arriving
+// within timeLimitGrace of the time limit still count, to allow for network latency.
+func (g *sectionGate) check(questionID int) error {
+	if !g.locked {
+		return nil
+	}
+	section, ok := g.sectionOf[questionID]
+	if !ok {
+		return nil
+	}
+	progress := g.progress[section.ID]
+	switch {
+	case progress == nil:
+		return errSectionNotStarted
+	case progress.EndedAt != nil:
+		return errSectionEnded
+	case section.TimeLimit > 0 && g.now.After(progress.endsAt(section.TimeLimit).Add(timeLimitGrace)):
+		return errSectionEnded
+	}
+	return nil
+}
+
+// lockSession starts a transaction holding the session row, so section changes of one
+// session run one at a time
+func lockSession(ctx context.Context, sessionToken string) (tx pgx.Tx, sessionID, studentID int, completed bool, err error) {
+	tx, err = db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, 0, 0, false, err
+	}
+	query := `SELECT id, student_id, completed FROM sessions WHERE session_token = $1 FOR UPDATE`
+	if err = tx.QueryRow(ctx, query, sessionToken).Scan(&sessionID, &studentID, &completed); err != nil {
+		tx.Rollback(ctx)
+		return nil, 0, 0, false, err
+	}
+	return tx, sessionID, studentID, completed, nil
+}
+
+// parseSectionRequest reads the body and finds the section in the question bank
+func parseSectionRequest(c *fiber.Ctx) (*SectionRequest, []questions.Section, *questions.Section, error) {
+	var req SectionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return nil, nil, nil, apierror.Send(c, fiber.StatusBadRequest, "Invalid request body")
+	}
+	if req.SessionToken == "" {
+		return nil, nil, nil, apierror.Send(c, fiber.StatusBadRequest, "Session token is required")
+	}
+
+	sections, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return nil, nil, nil, apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+	}
+	section := questions.FindSection(sections, req.SectionID)
+	if section == nil {
+		return nil, nil, nil, apierror.Send(c, fiber.StatusBadRequest, "Invalid section ID")
+	}
+	return &req, sections, section, nil
+}
+
+// StartSectionHandler handles POST /api/live/start-section
+// Body: {"session_token": "...", "section_id": 2}. Sections are taken in order: starting
+// a section ends the one in progress, and a section cannot be started again once ended.
+func StartSectionHandler(c *fiber.Ctx) error {
+	req, sections, section, err := parseSectionRequest(c)
+	if req == nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, sessionID, studentID, completed, err := lockSession(ctx, req.SessionToken)
+	if err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Session validation failed")
+		return apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeSessionInvalid, "Invalid session token")
+	}
+	defer tx.Rollback(ctx)
+	logging.SetStudent(c, studentID)
+	logging.SetSession(c, sessionID)
+
+	if completed {
+		return apierror.SendCode(c, fiber.StatusForbidden, apierror.CodeTestCompleted, "Test already completed")
+	}
+
+	progress, now, err := loadSectionProgress(ctx, tx, sessionID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load section progress")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to start section")
+	}
+
+	switch sectionStatus(progress[section.ID], section.TimeLimit, now) {
+	case SectionInProgress:
+		state := newSectionState(*section, progress[section.ID], 0, now)
+		return c.JSON(SectionResponse{Success: true, Message: "Section already in progress", Section: &state})
+	case SectionEnded, SectionExpired:
+		return apierror.Send(c, fiber.StatusConflict, errSectionEnded.Error())
+	}
+
+	// Every earlier section must have been started; the one in progress is ended
+	for _, earlier := range sections {
+		if earlier.ID == section.ID {
+			break
+		}
+		if progress[earlier.ID] == nil {
+			return apierror.Send(c, fiber.StatusConflict, "Sections must be taken in order; start "+earlier.Name+" first")
+		}
+	}
+	if _, err := tx.Exec(ctx, `UPDATE session_sections SET ended_at = NOW() WHERE session_id = $1 AND ended_at IS NULL`, sessionID); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to end previous section")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to start section")
+	}
+
+	var started sectionProgress
+	insertQuery := `INSERT INTO session_sections (session_id, section_id) VALUES ($1, $2) RETURNING started_at`
+	if err := tx.QueryRow(ctx, insertQuery, sessionID, section.ID).Scan(&started.StartedAt); err != nil {
+		logging.Ctx(c).Error().Err(err).Int("section_id", section.ID).Msg("Failed to start section")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to start section")
+	}
+	if err := tx.Commit(ctx); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to commit section start")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to start section")
+	}
+
+	state := newSectionState(*section, &started, 0, started.StartedAt)
+	return c.Status(fiber.StatusCreated).JSON(SectionResponse{Success: true, Message: "Section started", Section: &state})
+}
+
+// EndSectionHandler handles POST /api/live/end-section
+// Body: {"session_token": "...", "section_id": 2}. Ending an ended section succeeds again.
+func EndSectionHandler(c *fiber.Ctx) error {
+	req, _, section, err := parseSectionRequest(c)
+	if req == nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, sessionID, studentID, completed, err := lockSession(ctx, req.SessionToken)
+	if err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Session validation failed")
+		return apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeSessionInvalid, "Invalid session token")
+	}
+	defer tx.Rollback(ctx)
+	logging.SetStudent(c, studentID)
+	logging.SetSession(c, sessionID)
+
+	if completed {
+		return apierror.SendCode(c, fiber.StatusForbidden, apierror.CodeTestCompleted, "Test already completed")
+	}
+
+	var progress sectionProgress
+	var pausedSeconds float64
+	query := `
+		UPDATE session_sections ss SET ended_at = COALESCE(ss.ended_at, NOW())
+		WHERE ss.session_id = $1 AND ss.section_id = $2
+		RETURNING ss.started_at, ss.ended_at, ` + exampause.PausedSeconds("ss.started_at", "ss.ended_at") + `
+	`
+	err = tx.QueryRow(ctx, query, sessionID, section.ID).Scan(&progress.StartedAt, &progress.EndedAt, &pausedSeconds)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return apierror.Send(c, fiber.StatusConflict, errSectionNotStarted.Error())
+	}
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Int("section_id", section.ID).Msg("Failed to end section")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to end section")
+	}
+	progress.Paused = time.Duration(pausedSeconds * float64(time.Second))
+	if err := tx.Commit(ctx); err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to commit section end")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to end section")
+	}
+
+	state := newSectionState(*section, &progress, 0, *progress.EndedAt)
+	return c.JSON(SectionResponse{Success: true, Message: "Section ended", Section: &state})
+}
+
+// GetSessionStateHandler handles GET /api/live/session-state
+// Session token via "Authorization: Bearer <session_token>" or ?token=<session_token>.
+// Returns every section's status and the section in progress, so a reloaded page can resume.
+func GetSessionStateHandler(c *fiber.Ctx) error {
+	sessionToken := strings.TrimSpace(strings.TrimPrefix(c.Get("Authorization"), "Bearer"))
+	if sessionToken == "" {
+		sessionToken = c.Query("token")
+	}
+	if sessionToken == "" {
+		return apierror.Send(c, fiber.StatusBadRequest, "Session token is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var sessionID, studentID int
+	var startedAt time.Time
+	var completed bool
+	var finishBy *time.Time
+	query := `
+		SELECT s.id, s.student_id, s.started_at, s.completed,
+		       s.finish_by + make_interval(secs => ` + exampause.PausedSeconds("s.started_at", "NOW()") + `)
+		FROM sessions s
+		WHERE s.session_token = $1
+	`
+	if err := db.Pool.QueryRow(ctx, query, sessionToken).Scan(&sessionID, &studentID, &startedAt, &completed, &finishBy); err != nil {
+		logging.Ctx(c).Warn().Err(err).Msg("Session validation failed")
+		return apierror.SendCode(c, fiber.StatusNotFound, apierror.CodeSessionInvalid, "Invalid session token")
+	}
+	logging.SetStudent(c, studentID)
+	logging.SetSession(c, sessionID)
+
+	sections, err := questions.Load()
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load questions")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load questions")
+	}
+	progress, now, err := loadSectionProgress(ctx, db.Pool, sessionID)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load section progress")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load session state")
+	}
+	pause, err := exampause.Current(ctx)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to check exam pause")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load session state")
+	}
+
+	answered := make(map[int]int)
+	questionIDs, sectionIDs := questions.SectionMapping(sections)
+	countQuery := `
+		SELECT m.section_id, COUNT(*)
+		FROM answers a
+		JOIN unnest($2::int[], $3::int[]) AS m(question_id, section_id) ON m.question_id = a.question_id
+		WHERE a.session_id = $1
+		GROUP BY m.section_id
+	`
+	rows, err := db.Pool.Query(ctx, countQuery, sessionID, questionIDs, sectionIDs)
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to count answers")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load session state")
+	}
+	for rows.Next() {
+		var sectionID, count int
+		if err := rows.Scan(&sectionID, &count); err != nil {
+			rows.Close()
+			logging.Ctx(c).Error().Err(err).Msg("Failed to count answers")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load session state")
+		}
+		answered[sectionID] = count
+	}
+	rows.Close()
+
+	marks, err := loadQuestionMarks(ctx, sessionID, questionSections(sections))
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Failed to load question marks")
+		return apierror.Send(c, fiber.StatusInternalServerError, "Failed to load session state")
+	}
+	flagged, skipped := make(map[int]int), make(map[int]int)
+	for _, mark := range marks {
+		if mark.Flagged {
+			flagged[mark.SectionID]++
+		}
+		if mark.Skipped {
+			skipped[mark.SectionID]++
+		}
+	}
+
+	response := SessionStateResponse{
+		Success:    true,
+		SessionID:  sessionID,
+		StartedAt:  &startedAt,
+		Completed:  completed,
+		Navigation: sectionNavigation(),
+		Sections:   make([]SectionState, 0, len(sections)),
+		Marks:      marks,
+		ExamPaused: pause.Paused,
+		FinishBy:   finishBy,
+	}
+	if pause.Paused {
+		response.PausedSince = &pause.Pause.PausedAt
+		response.PauseReason = pause.Pause.Reason
+	}
+	for _, section := range sections {
+		state := newSectionState(section, progress[section.ID], answered[section.ID], now)
+		state.Flagged, state.Skipped = flagged[section.ID], skipped[section.ID]
+		response.Sections = append(response.Sections, state)
+		if state.Status == SectionInProgress && !completed {
+			current := state
+			response.CurrentSection = &current
+		}
+	}
+	return c.JSON(response)
+}
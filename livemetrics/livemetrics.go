@@ -0,0 +1,265 @@
+// Package livemetrics keeps the last 15 minutes of answer submissions and session completions
+// in memory, one bucket per second, and the sessions currently answering, so organizers can
+// see during the exam whether this instance keeps up without waiting for a Prometheus scrape.
+// Counts are per instance and reset on restart.
+package livemetrics
+
+import (
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// windowSeconds is how far back the ring reaches
+	windowSeconds = 15 * 60
+	// samplesPerSecond bounds the insert latencies kept per second; beyond it a uniform
+	// sample is kept, so a burst costs no more memory than a quiet second
+	samplesPerSecond = 32
+	// currentSeconds is the span "current" rates are averaged over, ending at the last full second
+	currentSeconds = 10
+	// activeAfter is how long a started session counts as active without a new answer
+	activeAfter = 5 * time.Minute
+)
+
+// bucket is one second of submissions
+type bucket struct {
+	second      int64
+	submissions int
+	answers     int
+	rejected    int
+	errors      int
+	completions int
+	// seen is how many insert latencies were observed; samples holds up to samplesPerSecond of them
+	seen    int
+	samples [samplesPerSecond]time.Duration
+}
+
+type ring struct {
+	mu      sync.Mutex
+	buckets [windowSeconds]bucket
+}
+
+var submissions = &ring{}
+
+// active holds the open sessions seen on this instance, by when they last started or saved
+// an answer (unix seconds)
+var active = struct {
+	mu       sync.Mutex
+	sessions map[int]int64
+}{sessions: map[int]int64{}}
+
+// Totals since the instance started
+var (
+	startedAt        = time.Now()
+	answersTotal     atomic.Int64
+	completionsTotal atomic.Int64
+)
+
+// at returns the bucket for now, clearing it when it still holds a second from a previous lap.
+// Callers hold mu.
+func (r *ring) at(now time.Time) *bucket {
+	second := now.Unix()
+	b := &r.buckets[second%windowSeconds]
+	if b.second != second {
+		*b = bucket{second: second}
+	}
+	return b
+}
+
+// RecordSessionStart counts a session as active from its start
+func RecordSessionStart(sessionID int) {
+	touch(sessionID, time.Now())
+}
+
+// RecordSubmission counts one submit-answer or submit-answers request of a session by its
+// HTTP status, with the number of answers it created or changed. An accepted request keeps
+// the session active.
+func RecordSubmission(sessionID, status, answers int) {
+	now := time.Now()
+	if status < 400 {
+		touch(sessionID, now)
+	}
+	answersTotal.Add(int64(answers))
+
+	submissions.mu.Lock()
+	defer submissions.mu.Unlock()
+	b := submissions.at(now)
+	b.submissions++
+	b.answers += answers
+	switch {
+	case status >= 500:
+		b.errors++
+	case status >= 400:
+		b.rejected++
+	}
+}
+
+// RecordCompletion counts a session ended by the candidate, an admin or the server; it is no
+// longer active
+func RecordCompletion(sessionID int) {
+	active.mu.Lock()
+	delete(active.sessions, sessionID)
+	active.mu.Unlock()
+	completionsTotal.Add(1)
+
+	submissions.mu.Lock()
+	defer submissions.mu.Unlock()
+	submissions.at(time.Now()).completions++
+}
+
+func touch(sessionID int, now time.Time) {
+	active.mu.Lock()
+	defer active.mu.Unlock()
+	active.sessions[sessionID] = now.Unix()
+}
+
+// activeSessions counts the sessions active within activeAfter, forgetting the others
+func activeSessions(now time.Time) int {
+	cutoff := now.Add(-activeAfter).Unix()
+	active.mu.Lock()
+	defer active.mu.Unlock()
+	for id, last := range active.sessions {
+		if last < cutoff {
+			delete(active.sessions, id)
+		}
+	}
+	return len(active.sessions)
+}
+
+// RecordInsert observes how long saving a submission's answers took in the database
+func RecordInsert(latency time.Duration) {
+	submissions.mu.Lock()
+	defer submissions.mu.Unlock()
+	b := submissions.at(time.Now())
+	b.seen++
+	if b.seen <= samplesPerSecond {
+		b.samples[b.seen-1] = latency
+		return
+	}
+	// Reservoir sampling: every latency of the second is equally likely to be kept
+	if i := rand.IntN(b.seen); i < samplesPerSecond {
+		b.samples[i] = latency
+	}
+}
+
+// Window summarizes the submissions of the last Minutes minutes
+type Window struct {
+	Minutes              int     `json:"minutes"`
+	Submissions          int     `json:"submissions"`
+	Answers              int     `json:"answers"`
+	Rejected             int     `json:"rejected"`
+	Errors               int     `json:"errors"`
+	AnswersPerSecond     float64 `json:"answers_per_second"`
+	SubmissionsPerSecond float64 `json:"submissions_per_second"`
+	// P95InsertLatencyMs is estimated from the sampled latencies; nil when none were recorded
+	P95InsertLatencyMs *float64 `json:"p95_insert_latency_ms"`
+	InsertSamples      int      `json:"insert_samples"`
+}
+
+// Rate is the throughput over the last few full seconds
+type Rate struct {
+	Seconds              int     `json:"seconds"`
+	AnswersPerSecond     float64 `json:"answers_per_second"`
+	SubmissionsPerSecond float64 `json:"submissions_per_second"`
+}
+
+// Snapshot is the current rate and a Window for each of the given spans in minutes
+type Snapshot struct {
+	Current Rate     `json:"current"`
+	Windows []Window `json:"windows"`
+}
+
+// Counters are the rolling figures of a live "answers received" screen
+type Counters struct {
+	// ActiveSessions started or saved an answer in the last 5 minutes and have not ended
+	ActiveSessions int `json:"active_sessions"`
+	// The per-minute figures cover the last 60 full seconds
+	AnswersPerMinute     int `json:"answers_per_minute"`
+	SubmissionsPerMinute int `json:"submissions_per_minute"`
+	CompletionsPerMinute int `json:"completions_per_minute"`
+	// Completions15m counts the sessions ended in the last 15 minutes
+	Completions15m int `json:"completions_15m"`
+	// AnswersTotal and CompletionsTotal count since Since, when the instance started
+	AnswersTotal     int64     `json:"answers_total"`
+	CompletionsTotal int64     `json:"completions_total"`
+	Since            time.Time `json:"since"`
+}
+
+// Live reads the rolling counters
+func Live() Counters {
+	now := time.Now()
+	counters := Counters{
+		ActiveSessions:   activeSessions(now),
+		AnswersTotal:     answersTotal.Load(),
+		CompletionsTotal: completionsTotal.Load(),
+		Since:            startedAt.UTC(),
+	}
+
+	second := now.Unix()
+	submissions.mu.Lock()
+	defer submissions.mu.Unlock()
+	submissions.each(second-60, second-1, func(b *bucket) {
+		counters.AnswersPerMinute += b.answers
+		counters.SubmissionsPerMinute += b.submissions
+		counters.CompletionsPerMinute += b.completions
+	})
+	submissions.each(second-windowSeconds+1, second, func(b *bucket) {
+		counters.Completions15m += b.completions
+	})
+	return counters
+}
+
+// Summarize reads the ring. Windows longer than 15 minutes are cut to 15.
+func Summarize(minutes ...int) Snapshot {
+	now := time.Now().Unix()
+	submissions.mu.Lock()
+	defer submissions.mu.Unlock()
+
+	snapshot := Snapshot{Current: Rate{Seconds: currentSeconds}}
+	var answers, count int
+	submissions.each(now-currentSeconds, now-1, func(b *bucket) {
+		answers += b.answers
+		count += b.submissions
+	})
+	snapshot.Current.AnswersPerSecond = perSecond(answers, currentSeconds)
+	snapshot.Current.SubmissionsPerSecond = perSecond(count, currentSeconds)
+
+	for _, m := range minutes {
+		seconds := min(m*60, windowSeconds)
+		window := Window{Minutes: seconds / 60}
+		var latencies []time.Duration
+		submissions.each(now-int64(seconds)+1, now, func(b *bucket) {
+			window.Submissions += b.submissions
+			window.Answers += b.answers
+			window.Rejected += b.rejected
+			window.Errors += b.errors
+			latencies = append(latencies, b.samples[:min(b.seen, samplesPerSecond)]...)
+		})
+		window.AnswersPerSecond = perSecond(window.Answers, seconds)
+		window.SubmissionsPerSecond = perSecond(window.Submissions, seconds)
+		window.InsertSamples = len(latencies)
+		if len(latencies) > 0 {
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			p95 := float64(latencies[(len(latencies)*95+99)/100-1].Microseconds()) / 1000
+			window.P95InsertLatencyMs = &p95
+		}
+		snapshot.Windows = append(snapshot.Windows, window)
+	}
+	return snapshot
+}
+
+// each calls fn for the buckets of the seconds from..to that hold data. Callers hold mu.
+func (r *ring) each(from, to int64, fn func(b *bucket)) {
+	for second := from; second <= to; second++ {
+		if b := &r.buckets[second%windowSeconds]; b.second == second {
+			fn(b)
+		}
+	}
+}
+
+func perSecond(count, seconds int) float64 {
+	return float64(int(float64(count)/float64(seconds)*100+0.5)) / 100
+}
@@ -0,0 +1,217 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"mcq-exam/attempts"
+	"mcq-exam/questions"
+	"mcq-exam/ranking"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// statsPageSize is the default page of GET /api/stats/comprehensive
+const statsPageSize = 100
+
+// IsReadTarget reports whether the target replays a results endpoint, which needs
+// finished sessions seeded rather than access codes to claim
+func IsReadTarget(target string) bool {
+	switch target {
+	case TargetLeaderboardOverall, TargetLeaderboardSection, TargetComprehensiveStats:
+		return true
+	}
+	return false
+}
+
+// sectionIDs are the exam's sections, from the questions file the handlers read
+func sectionIDs() ([]int, error) {
+	sections, err := questions.Load()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(sections))
+	for _, section := range sections {
+		ids = append(ids, section.ID)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("questions file has no sections")
+	}
+	return ids, nil
+}
+
+// seedResults gives every sandbox student a completed attempt, and every fourth student a
+// second one so the attempt policy has a choice, each with a score for every section. The
+// tables are analyzed afterwards so the planner works from statistics, as it would on a
+// database that has been through an exam.
+func seedResults(ctx context.Context, pool *pgxpool.Pool, sections []int) error {
+	sessionsQuery := `
+		INSERT INTO sessions (student_id, session_token, access_code, started_at, attempt_number,
+		                      completed, completed_at, score, total_time_taken_seconds)
+		SELECT s.id, md5(s.id || '-' || a || '-' || random()), 'LT' || lpad(s.id::text, 8, '0'), x.started, a,
+		       true, x.started + make_interval(secs => x.taken), floor(random() * 121)::int, x.taken
+		FROM students s
+		CROSS JOIN LATERAL generate_series(1, CASE WHEN s.id % 4 = 0 THEN 2 ELSE 1 END) a
+		CROSS JOIN LATERAL (
+			SELECT NOW() - INTERVAL '3 hours' + a * INTERVAL '1 hour' AS started, 600 + floor(random() * 3000)::int AS taken
+		) x
+	`
+	if _, err := pool.Exec(ctx, sessionsQuery); err != nil {
+		return fmt.Errorf("failed to seed sandbox sessions: %w", err)
+	}
+
+	scoresQuery := `
+		INSERT INTO session_section_scores (session_id, student_id, section_id, score, time_taken_seconds, questions_answered)
+		SELECT sess.id, sess.student_id, sec, floor(random() * 31)::int, 60 + floor(random() * 900)::int, 1 + floor(random() * 30)::int
+		FROM sessions sess
+		CROSS JOIN unnest($1::int[]) sec
+	`
+	if _, err := pool.Exec(ctx, scoresQuery, sections); err != nil {
+		return fmt.Errorf("failed to seed sandbox section scores: %w", err)
+	}
+
+	if _, err := pool.Exec(ctx, `ANALYZE students, email_tracking, sessions, session_section_scores`); err != nil {
+		return fmt.Errorf("failed to analyze sandbox: %w", err)
+	}
+	return nil
+}
+
+// drain reads every row, so transferring the result is part of the measured latency
+func drain(rows pgx.Rows, err error) error {
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+// leaderboardOverall replays GET /api/leaderboard/overall. The ranking policy is read from
+// the real exam_settings, as every request does.
+func (r *runner) leaderboardOverall(ctx context.Context) error {
+	policy := ranking.Current(ctx)
+	query := `
+		SELECT
+			s.id,
+			s.name,
+			s.email,
+			COALESCE(sess.score, 0) as score,
+			COALESCE(sess.total_time_taken_seconds, 0) as total_time_taken_seconds,
+			` + policy.DenseRank("sess") + ` as rank,
+			sess.auto_completed
+		FROM students s
+		INNER JOIN ` + attempts.CountedSessions() + ` sess ON s.id = sess.student_id
+		ORDER BY ` + policy.OrderBy("sess") + `, s.id ASC
+		LIMIT 100
+	`
+	if err := drain(r.pool.Query(ctx, query)); err != nil {
+		return fmt.Errorf("leaderboard: %w", err)
+	}
+
+	var total int
+	countQuery := `SELECT COUNT(*) FROM ` + attempts.CountedSessions() + ` sess`
+	if err := r.pool.QueryRow(ctx, countQuery).Scan(&total); err != nil {
+		return fmt.Errorf("count: %w", err)
+	}
+	return nil
+}
+
+// leaderboardSection replays GET /api/leaderboard/section/:section_id
+func (r *runner) leaderboardSection(ctx context.Context, p prepared) error {
+	query := `
+		SELECT
+			s.id,
+			s.name,
+			s.email,
+			sss.score,
+			sss.time_taken_seconds,
+			sess.auto_completed,
+			` + ranking.SectionDenseRank("sss") + ` as rank,
+			COUNT(*) OVER () as total
+		FROM session_section_scores sss
+		INNER JOIN ` + attempts.CountedSessions() + ` sess ON sess.id = sss.session_id
+		INNER JOIN students s ON s.id = sss.student_id
+		WHERE sss.section_id = $1
+		AND sss.questions_answered > 0
+		ORDER BY ` + ranking.SectionOrderBy("sss") + `, s.id ASC
+		LIMIT 100
+	`
+	if err := drain(r.pool.Query(ctx, query, p.sectionID)); err != nil {
+		return fmt.Errorf("section leaderboard: %w", err)
+	}
+	return nil
+}
+
+// comprehensiveStats replays GET /api/stats/comprehensive with every block and the first
+// page of 100. The questions file is read once per run rather than per request.
+func (r *runner) comprehensiveStats(ctx context.Context) error {
+	policy := ranking.Current(ctx)
+	overallQuery := `
+		SELECT
+			s.id,
+			s.name,
+			s.email,
+			COALESCE(sess.score, 0) as score,
+			COALESCE(sess.total_time_taken_seconds, 0) as total_time_taken_seconds,
+			` + policy.DenseRank("sess") + ` as rank,
+			COUNT(*) OVER () as total,
+			sess.auto_completed
+		FROM students s
+		INNER JOIN ` + attempts.CountedSessions() + ` sess ON s.id = sess.student_id
+		ORDER BY ` + policy.OrderBy("sess") + `, s.id ASC
+		LIMIT $1 OFFSET $2
+	`
+	if err := drain(r.pool.Query(ctx, overallQuery, statsPageSize, 0)); err != nil {
+		return fmt.Errorf("overall: %w", err)
+	}
+
+	sectionQuery := `
+		SELECT s.id, s.name, s.email, sss.score, sss.time_taken_seconds, sess.auto_completed,
+		       ` + ranking.SectionDenseRank("sss") + ` as rank,
+		       COUNT(*) OVER () as total
+		FROM ` + attempts.CountedSessions() + ` sess
+		JOIN session_section_scores sss ON sss.session_id = sess.id AND sss.section_id = $1
+		JOIN students s ON s.id = sess.student_id
+		WHERE sss.questions_answered > 0
+		ORDER BY ` + ranking.SectionOrderBy("sss") + `, s.id ASC
+		LIMIT $2 OFFSET $3
+	`
+	for _, sectionID := range r.sections {
+		if err := drain(r.pool.Query(ctx, sectionQuery, sectionID, statsPageSize, 0)); err != nil {
+			return fmt.Errorf("section %d: %w", sectionID, err)
+		}
+	}
+
+	attendeesQuery := `
+		SELECT s.id, s.name, s.email, sess.attempt_number, sess.started_at, sess.completed, sess.completed_at, sess.score, sess.total_time_taken_seconds,
+		       sess.disqualified_at, sess.auto_completed_reason
+		FROM sessions sess
+		INNER JOIN students s ON sess.student_id = s.id
+		WHERE sess.is_sandbox = false
+		ORDER BY s.name ASC, s.id ASC, sess.attempt_number ASC
+		LIMIT $1 OFFSET $2
+	`
+	if err := drain(r.pool.Query(ctx, attendeesQuery, statsPageSize, 0)); err != nil {
+		return fmt.Errorf("attendees: %w", err)
+	}
+	var attemptsTotal int
+	if err := r.pool.QueryRow(ctx, `SELECT COUNT(*) FROM sessions WHERE is_sandbox = false`).Scan(&attemptsTotal); err != nil {
+		return fmt.Errorf("attendees count: %w", err)
+	}
+
+	funnelQuery := `
+		SELECT
+			(SELECT COUNT(*) FROM students WHERE is_sandbox = false),
+			(SELECT COUNT(DISTINCT et.student_id) FROM email_tracking et JOIN students s ON s.id = et.student_id AND s.is_sandbox = false),
+			(SELECT COUNT(DISTINCT et.student_id) FROM email_tracking et JOIN students s ON s.id = et.student_id AND s.is_sandbox = false WHERE et.opened = true),
+			(SELECT COUNT(DISTINCT et.student_id) FROM email_tracking et JOIN students s ON s.id = et.student_id AND s.is_sandbox = false WHERE et.conference_attended = true),
+			(SELECT COUNT(DISTINCT student_id) FROM sessions WHERE is_sandbox = false),
+			(SELECT COUNT(DISTINCT student_id) FROM sessions WHERE completed = true AND is_sandbox = false)
+	`
+	var total, invited, opened, attended, started, completed int
+	if err := r.pool.QueryRow(ctx, funnelQuery).Scan(&total, &invited, &opened, &attended, &started, &completed); err != nil {
+		return fmt.Errorf("funnel: %w", err)
+	}
+	return nil
+}
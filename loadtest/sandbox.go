@@ -0,0 +1,122 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"mcq-exam/db"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// SandboxSchema holds copies of the exam tables that scenarios run against, so a run
+// never touches real students, sessions or answers. It is rebuilt from the public
+// tables for every run and dropped afterwards.
+const SandboxSchema = "loadtest"
+
+// sandboxTables are copied from public in dependency order
+var sandboxTables = []string{"students", "email_tracking", "event_schedule", "sessions", "answers", "answer_events", "question_serves", "session_sections", "session_section_scores", "access_code_events"}
+
+// sandboxForeignKeys mirror the public schema's foreign keys, which LIKE does not copy,
+// so inserts pay the same constraint checks as in production
+var sandboxForeignKeys = []string{
+	`ALTER TABLE email_tracking ADD FOREIGN KEY (student_id) REFERENCES students(id) ON DELETE CASCADE`,
+	`ALTER TABLE sessions ADD FOREIGN KEY (student_id) REFERENCES students(id) ON DELETE CASCADE`,
+	`ALTER TABLE answers ADD FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE`,
+	`ALTER TABLE answer_events ADD FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE`,
+	`ALTER TABLE question_serves ADD FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE`,
+	`ALTER TABLE session_sections ADD FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE`,
+	`ALTER TABLE session_section_scores ADD FOREIGN KEY (session_id) REFERENCES sessions(id) ON DELETE CASCADE`,
+	`ALTER TABLE session_section_scores ADD FOREIGN KEY (student_id) REFERENCES students(id) ON DELETE CASCADE`,
+	`ALTER TABLE access_code_events ADD FOREIGN KEY (student_id) REFERENCES students(id) ON DELETE CASCADE`,
+}
+
+// openSandbox connects to the sandbox with the same pool settings as the application, so
+// connection waits are part of the measured latency, and rebuilds it with the given
+// number of students who have attended the conference and hold access codes. With
+// sections, the students also have finished attempts scored in those sections.
+func openSandbox(ctx context.Context, students int, sections []int) (*pgxpool.Pool, error) {
+	config := db.Pool.Config()
+	config.ConnConfig.RuntimeParams["search_path"] = SandboxSchema
+	config.MinConns = 0
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to sandbox: %w", err)
+	}
+
+	err = buildSandbox(ctx, pool, students)
+	if err == nil && len(sections) > 0 {
+		err = seedResults(ctx, pool, sections)
+	}
+	if err != nil {
+		pool.Close()
+		if dropErr := dropSandbox(); dropErr != nil {
+			log.Warn().Err(dropErr).Msg("Failed to clean up load test sandbox")
+		}
+		return nil, err
+	}
+	return pool, nil
+}
+
+// buildSandbox recreates the schema. Tables are copied with LIKE ... INCLUDING ALL so they
+// keep the current indexes and unique constraints, and get their own ID sequences rather
+// than sharing the public ones.
+func buildSandbox(ctx context.Context, pool *pgxpool.Pool, students int) error {
+	var ddl strings.Builder
+	fmt.Fprintf(&ddl, "DROP SCHEMA IF EXISTS %[1]s CASCADE; CREATE SCHEMA %[1]s;\n", SandboxSchema)
+	for _, table := range sandboxTables {
+		fmt.Fprintf(&ddl, "CREATE TABLE %[1]s (LIKE public.%[1]s INCLUDING ALL);\n", table)
+		fmt.Fprintf(&ddl, "CREATE SEQUENCE %[1]s_id_seq OWNED BY %[1]s.id;\n", table)
+		fmt.Fprintf(&ddl, "ALTER TABLE %[1]s ALTER COLUMN id SET DEFAULT nextval('%[1]s_id_seq');\n", table)
+	}
+	for _, fk := range sandboxForeignKeys {
+		ddl.WriteString(fk + ";\n")
+	}
+	if _, err := pool.Exec(ctx, ddl.String()); err != nil {
+		return fmt.Errorf("failed to create sandbox schema: %w", err)
+	}
+
+	seedStudents := `
+		INSERT INTO students (name, email)
+		SELECT 'Load Test ' || g, 'loadtest-' || g || '@sandbox.invalid'
+		FROM generate_series(1, $1::int) g
+	`
+	if _, err := pool.Exec(ctx, seedStudents, students); err != nil {
+		return fmt.Errorf("failed to seed sandbox students: %w", err)
+	}
+
+	seedQueries := []string{
+		`INSERT INTO email_tracking (student_id, email_type, access_code, conference_attended, conference_attended_at)
+		 SELECT id, 'firstMail', 'LT' || lpad(id::text, 8, '0'), true, NOW()
+		 FROM students`,
+		// The exam window opened an hour ago
+		`INSERT INTO event_schedule (first_scheduled_time, second_scheduled_time)
+		 VALUES (NOW() - INTERVAL '2 hours', NOW() - INTERVAL '1 hour')`,
+	}
+	for _, query := range seedQueries {
+		if _, err := pool.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to seed sandbox: %w", err)
+		}
+	}
+	return nil
+}
+
+// accessCode is the access code seeded for a sandbox student
+func accessCode(studentID int) string {
+	return fmt.Sprintf("LT%08d", studentID)
+}
+
+// dropSandbox removes the sandbox schema. It uses a fresh context so cleanup happens
+// even when the run was cancelled by shutdown.
+func dropSandbox() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := db.Pool.Exec(ctx, "DROP SCHEMA IF EXISTS "+SandboxSchema+" CASCADE"); err != nil {
+		return fmt.Errorf("failed to drop sandbox schema: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,625 @@
+package loadtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"mcq-exam/db"
+	"mcq-exam/ingest"
+	"mcq-exam/jobs"
+	"mcq-exam/scoring"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// Scenario targets, each replaying the database work of one live exam endpoint or one
+// results read
+const (
+	TargetVerifyOTP          = "verify-otp"
+	TargetSubmitAnswer       = "submit-answer"
+	TargetEndSession         = "end-session"
+	TargetLeaderboardOverall = "leaderboard-overall"
+	TargetLeaderboardSection = "leaderboard-section"
+	TargetComprehensiveStats = "comprehensive-stats"
+)
+
+// Targets lists the supported scenario targets
+var Targets = []string{
+	TargetVerifyOTP, TargetSubmitAnswer, TargetEndSession,
+	TargetLeaderboardOverall, TargetLeaderboardSection, TargetComprehensiveStats,
+}
+
+// Limits on a scenario, keeping a run from starving the live exam of connections for long
+const (
+	MaxGoroutines      = 200
+	MaxDurationSeconds = 300
+	MaxStudents        = 100000
+
+	DefaultGoroutines      = 10
+	DefaultDurationSeconds = 30
+	DefaultStudents        = 1000
+)
+
+const (
+	// questionCount matches the question IDs accepted by submit-answer
+	questionCount = 120
+	// answersPerSession is how many answers an end-session operation scores
+	answersPerSession = 60
+	// operationTimeout matches the handlers' request timeouts
+	operationTimeout = 10 * time.Second
+	// maxErrorKinds caps the distinct error messages reported for a run
+	maxErrorKinds = 20
+)
+
+// ErrBusy is returned when another scenario is already running
+var ErrBusy = errors.New("a load test scenario is already running")
+
+// running allows one scenario at a time, since they share the sandbox schema
+var running sync.Mutex
+
+// Config describes a scenario run. Zero values are replaced by the defaults.
+type Config struct {
+	Target          string `json:"target"`
+	Goroutines      int    `json:"goroutines"`
+	DurationSeconds int    `json:"duration_seconds"`
+	Students        int    `json:"students"`
+	// Ingest is how submit-answer writes answers: ingest.ModeDirect (default) or
+	// ingest.ModeBatch, batched as with ANSWER_INGEST=batch
+	Ingest string `json:"ingest"`
+	Notes  string `json:"notes"`
+}
+
+// Result is a finished run, as recorded in test_results
+type Result struct {
+	ID                 int              `json:"result_id"`
+	TestType           string           `json:"test_type"`
+	Target             string           `json:"target"`
+	Goroutines         int              `json:"goroutines"`
+	DurationSeconds    int              `json:"duration_seconds"`
+	Students           int              `json:"students"`
+	Ingest             string           `json:"ingest,omitempty"`
+	TotalRequests      int64            `json:"total_requests"`
+	SuccessfulRequests int64            `json:"successful_requests"`
+	FailedRequests     int64            `json:"failed_requests"`
+	ErrorRate          float64          `json:"error_rate"`
+	RequestsPerSecond  float64          `json:"requests_per_second"`
+	SetupFailures      int64            `json:"setup_failures"`
+	Errors             map[string]int64 `json:"errors,omitempty"`
+	Latency            Distribution     `json:"latency"`
+	Interrupted        bool             `json:"interrupted"`
+	CreatedAt          time.Time        `json:"created_at"`
+}
+
+// IsValidTarget reports whether the target is supported
+func IsValidTarget(target string) bool {
+	for _, t := range Targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalize applies defaults and checks the limits
+func (cfg *Config) Normalize() error {
+	cfg.Target = strings.ToLower(strings.TrimSpace(cfg.Target))
+	if !IsValidTarget(cfg.Target) {
+		return fmt.Errorf("target must be one of: %s", strings.Join(Targets, ", "))
+	}
+	if cfg.Goroutines == 0 {
+		cfg.Goroutines = DefaultGoroutines
+	}
+	if cfg.DurationSeconds == 0 {
+		cfg.DurationSeconds = DefaultDurationSeconds
+	}
+	if cfg.Students == 0 {
+		cfg.Students = DefaultStudents
+	}
+	cfg.Ingest = strings.ToLower(strings.TrimSpace(cfg.Ingest))
+	if cfg.Ingest == "" && cfg.Target == TargetSubmitAnswer {
+		cfg.Ingest = ingest.ModeDirect
+	}
+	if cfg.Goroutines < 1 || cfg.Goroutines > MaxGoroutines {
+		return fmt.Errorf("goroutines must be between 1 and %d", MaxGoroutines)
+	}
+	if cfg.DurationSeconds < 1 || cfg.DurationSeconds > MaxDurationSeconds {
+		return fmt.Errorf("duration_seconds must be between 1 and %d", MaxDurationSeconds)
+	}
+	if cfg.Students < 1 || cfg.Students > MaxStudents {
+		return fmt.Errorf("students must be between 1 and %d", MaxStudents)
+	}
+	if cfg.Ingest != "" && cfg.Target != TargetSubmitAnswer {
+		return fmt.Errorf("ingest only applies to %s", TargetSubmitAnswer)
+	}
+	if cfg.Ingest != "" && cfg.Ingest != ingest.ModeDirect && cfg.Ingest != ingest.ModeBatch {
+		return fmt.Errorf("ingest must be %s or %s", ingest.ModeDirect, ingest.ModeBatch)
+	}
+	return nil
+}
+
+// runner is the state shared by a scenario's goroutines
+type runner struct {
+	cfg         Config
+	pool        *pgxpool.Pool
+	nextStudent atomic.Int64
+	// sections are the exam's section IDs, for the read targets
+	sections []int
+	// batcher writes submit-answer's answers with ingest=batch
+	batcher *ingest.Batcher
+}
+
+// worker is one goroutine's state and measurements
+type worker struct {
+	rng *mathrand.Rand
+	// the session submit-answer is filling, and the next question to answer
+	sessionToken string
+	nextQuestion int
+
+	latencies     []time.Duration
+	failed        int64
+	setupFailures int64
+	errors        map[string]int64
+}
+
+// Run executes a scenario against a freshly built sandbox and records the latency
+// distribution in test_results. It blocks for the configured duration; shutdown ends
+// the run early and the partial result is still recorded.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	if err := cfg.Normalize(); err != nil {
+		return nil, err
+	}
+	if !running.TryLock() {
+		return nil, ErrBusy
+	}
+	defer running.Unlock()
+
+	setupCtx, cancelSetup := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancelSetup()
+
+	var sections []int
+	if IsReadTarget(cfg.Target) {
+		ids, err := sectionIDs()
+		if err != nil {
+			return nil, err
+		}
+		sections = ids
+	}
+
+	pool, err := openSandbox(setupCtx, cfg.Students, sections)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		pool.Close()
+		if err := dropSandbox(); err != nil {
+			log.Warn().Err(err).Msg("Failed to clean up load test sandbox")
+		}
+	}()
+
+	log.Info().Str("target", cfg.Target).Int("goroutines", cfg.Goroutines).Int("duration_seconds", cfg.DurationSeconds).
+		Int("students", cfg.Students).Msg("Load test scenario started")
+
+	r := &runner{cfg: cfg, pool: pool, sections: sections}
+	runCtx, cancel := context.WithTimeout(jobs.Context(), time.Duration(cfg.DurationSeconds)*time.Second)
+	defer cancel()
+
+	// The batcher writes its last batch once the run ends, before the sandbox is dropped
+	if cfg.Ingest == ingest.ModeBatch {
+		r.batcher = ingest.New(pool, ingest.Interval(), ingest.Size())
+		batcherDone := make(chan struct{})
+		go func() {
+			defer close(batcherDone)
+			r.batcher.Run(runCtx)
+		}()
+		defer func() {
+			cancel()
+			<-batcherDone
+		}()
+	}
+
+	started := time.Now()
+	workers := make([]*worker, cfg.Goroutines)
+	var wg sync.WaitGroup
+	for i := range workers {
+		w := &worker{
+			rng:    mathrand.New(mathrand.NewSource(time.Now().UnixNano() + int64(i))),
+			errors: make(map[string]int64),
+		}
+		workers[i] = w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.loop(runCtx, w)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(started)
+
+	result := &Result{
+		TestType:        "scenario:" + cfg.Target,
+		Target:          cfg.Target,
+		Goroutines:      cfg.Goroutines,
+		DurationSeconds: cfg.DurationSeconds,
+		Students:        cfg.Students,
+		Ingest:          cfg.Ingest,
+		Errors:          make(map[string]int64),
+		Interrupted:     jobs.Context().Err() != nil,
+	}
+	var latencies []time.Duration
+	for _, w := range workers {
+		latencies = append(latencies, w.latencies...)
+		result.FailedRequests += w.failed
+		result.SetupFailures += w.setupFailures
+		for message, count := range w.errors {
+			if _, seen := result.Errors[message]; seen || len(result.Errors) < maxErrorKinds {
+				result.Errors[message] += count
+			}
+		}
+	}
+	result.SuccessfulRequests = int64(len(latencies))
+	result.TotalRequests = result.SuccessfulRequests + result.FailedRequests
+	if result.TotalRequests > 0 {
+		result.ErrorRate = float64(result.FailedRequests) / float64(result.TotalRequests) * 100
+	}
+	if elapsed > 0 {
+		result.RequestsPerSecond = float64(result.TotalRequests) / elapsed.Seconds()
+	}
+	result.Latency = distribution(latencies)
+
+	if err := record(result, cfg.Notes); err != nil {
+		return result, err
+	}
+
+	log.Info().Int("result_id", result.ID).Str("target", cfg.Target).Int64("total_requests", result.TotalRequests).
+		Int64("failed_requests", result.FailedRequests).Float64("p99_ms", result.Latency.P99Ms).Msg("Load test scenario finished")
+	return result, nil
+}
+
+// loop runs operations back to back until the run ends. Only the endpoint's own queries are
+// timed; preparing its input (such as a session to end) is not. Operations cut off by the
+// end of the run are discarded.
+func (r *runner) loop(ctx context.Context, w *worker) {
+	for ctx.Err() == nil {
+		opCtx, cancel := context.WithTimeout(ctx, operationTimeout)
+		prepared, err := r.prepare(opCtx, w)
+		if err != nil {
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			w.setupFailures++
+			continue
+		}
+
+		started := time.Now()
+		err = r.operation(opCtx, w, prepared)
+		latency := time.Since(started)
+		cancel()
+
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			w.failed++
+			w.errors[err.Error()]++
+			continue
+		}
+		w.latencies = append(w.latencies, latency)
+	}
+}
+
+// student hands out sandbox students round robin
+func (r *runner) student() int {
+	return int((r.nextStudent.Add(1)-1)%int64(r.cfg.Students)) + 1
+}
+
+// prepared is the untimed input of one operation
+type prepared struct {
+	studentID    int
+	sessionToken string
+	questionID   int
+	sectionID    int
+}
+
+func (r *runner) prepare(ctx context.Context, w *worker) (prepared, error) {
+	switch r.cfg.Target {
+	case TargetSubmitAnswer:
+		// Answer every question of a session, then move to a new one
+		if w.sessionToken == "" || w.nextQuestion > questionCount {
+			token, _, err := r.createSession(ctx, r.student())
+			if err != nil {
+				return prepared{}, err
+			}
+			w.sessionToken, w.nextQuestion = token, 1
+		}
+		p := prepared{sessionToken: w.sessionToken, questionID: w.nextQuestion}
+		w.nextQuestion++
+		return p, nil
+	case TargetEndSession:
+		token, sessionID, err := r.createSession(ctx, r.student())
+		if err != nil {
+			return prepared{}, err
+		}
+		answersQuery := `
+			INSERT INTO answers (session_id, question_id, selected_option_index, is_correct, time_taken_seconds)
+			SELECT $1, q, floor(random() * 4)::int, random() < 0.6, 5 + floor(random() * 55)::int
+			FROM generate_series(1, $2::int) q
+		`
+		if _, err := r.pool.Exec(ctx, answersQuery, sessionID, answersPerSession); err != nil {
+			return prepared{}, err
+		}
+		return prepared{sessionToken: token}, nil
+	case TargetLeaderboardSection:
+		return prepared{sectionID: r.sections[w.rng.Intn(len(r.sections))]}, nil
+	default:
+		return prepared{studentID: r.student()}, nil
+	}
+}
+
+func (r *runner) operation(ctx context.Context, w *worker, p prepared) error {
+	switch r.cfg.Target {
+	case TargetSubmitAnswer:
+		return r.submitAnswer(ctx, w, p)
+	case TargetEndSession:
+		return r.endSession(ctx, p)
+	case TargetLeaderboardOverall:
+		return r.leaderboardOverall(ctx)
+	case TargetLeaderboardSection:
+		return r.leaderboardSection(ctx, p)
+	case TargetComprehensiveStats:
+		return r.comprehensiveStats(ctx)
+	default:
+		return r.verifyOTP(ctx, p)
+	}
+}
+
+// createSession starts the student's next attempt, returning its token and ID
+func (r *runner) createSession(ctx context.Context, studentID int) (string, int, error) {
+	token := sessionToken()
+	query := `
+		INSERT INTO sessions (student_id, session_token, access_code, started_at, attempt_number)
+		SELECT $1, $2, $3, NOW(), COALESCE(MAX(attempt_number), 0) + 1
+		FROM sessions
+		WHERE student_id = $1
+		ON CONFLICT (student_id, attempt_number) DO NOTHING
+		RETURNING id
+	`
+	var sessionID int
+	if err := r.pool.QueryRow(ctx, query, studentID, token, accessCode(studentID)).Scan(&sessionID); err != nil {
+		return "", 0, err
+	}
+	return token, sessionID, nil
+}
+
+// verifyOTP replays POST /api/live/verify-otp. The attempt limit and one-time use of the
+// access code are not enforced, so every operation goes on to create a session instead of
+// stopping at the student's first attempt.
+func (r *runner) verifyOTP(ctx context.Context, p prepared) error {
+	code := accessCode(p.studentID)
+
+	var studentID int
+	var name, email, timezone string
+	var expiresAt, usedAt, invalidatedAt *time.Time
+	query := `
+		SELECT et.student_id, s.name, s.email, COALESCE(s.timezone, ''),
+		       et.access_code_expires_at, et.access_code_used_at, et.access_code_invalidated_at
+		FROM email_tracking et
+		JOIN students s ON et.student_id = s.id
+		WHERE et.access_code = $1 AND et.email_type = 'firstMail' AND et.conference_attended = true
+	`
+	if err := r.pool.QueryRow(ctx, query, code).Scan(&studentID, &name, &email, &timezone, &expiresAt, &usedAt, &invalidatedAt); err != nil {
+		return fmt.Errorf("otp lookup: %w", err)
+	}
+
+	var attemptCount int
+	var hasOpenAttempt bool
+	checkSessionQuery := `
+		SELECT COUNT(*), COALESCE(bool_or(NOT completed), false)
+		FROM sessions
+		WHERE student_id = $1
+	`
+	if err := r.pool.QueryRow(ctx, checkSessionQuery, studentID).Scan(&attemptCount, &hasOpenAttempt); err != nil {
+		return fmt.Errorf("attempt check: %w", err)
+	}
+
+	var scheduleID, windowMinutes int
+	var secondScheduledTime time.Time
+	var windowPolicy, scheduleTimezone string
+	timeCheckQuery := `
+		SELECT id, second_scheduled_time, window_minutes, window_policy, timezone
+		FROM event_schedule
+		ORDER BY id DESC
+		LIMIT 1
+	`
+	if err := r.pool.QueryRow(ctx, timeCheckQuery).Scan(&scheduleID, &secondScheduledTime, &windowMinutes, &windowPolicy, &scheduleTimezone); err != nil {
+		return fmt.Errorf("schedule check: %w", err)
+	}
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	claimQuery := `
+		UPDATE email_tracking
+		SET access_code_used_at = NOW(), updated_at = NOW()
+		WHERE student_id = $1 AND email_type = 'firstMail' AND access_code = $2
+		RETURNING student_id
+	`
+	if err := tx.QueryRow(ctx, claimQuery, studentID, code).Scan(&studentID); err != nil {
+		return fmt.Errorf("claim access code: %w", err)
+	}
+	eventQuery := `
+		INSERT INTO access_code_events (student_id, action, ip, user_agent)
+		VALUES ($1, 'used', '127.0.0.1', 'loadtest')
+	`
+	if _, err := tx.Exec(ctx, eventQuery, studentID); err != nil {
+		return fmt.Errorf("record access code event: %w", err)
+	}
+
+	createSessionQuery := `
+		INSERT INTO sessions (student_id, session_token, access_code, started_at, attempt_number)
+		VALUES ($1, $2, $3, NOW(), $4)
+		ON CONFLICT (student_id, attempt_number) DO NOTHING
+		RETURNING id
+	`
+	var sessionID int
+	err = tx.QueryRow(ctx, createSessionQuery, studentID, sessionToken(), code, attemptCount+1).Scan(&sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errors.New("create session: concurrent attempt for the same student")
+	}
+	if err != nil {
+		return fmt.Errorf("create session: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// submitAnswer replays POST /api/live/submit-answer on a session cache miss, the slowest path
+func (r *runner) submitAnswer(ctx context.Context, w *worker, p prepared) error {
+	var sessionID, studentID int
+	var completed bool
+	var questionSeed *int64
+	query := `
+		SELECT id, student_id, completed, question_seed
+		FROM sessions
+		WHERE session_token = $1
+	`
+	if err := r.pool.QueryRow(ctx, query, p.sessionToken).Scan(&sessionID, &studentID, &completed, &questionSeed); err != nil {
+		return fmt.Errorf("session lookup: %w", err)
+	}
+
+	// Server-side timing reads the question's serve time; sandbox questions are never
+	// served, so the time taken is made up as the client would report it
+	serveQuery := `SELECT question_id, first_served_at, NOW() FROM question_serves WHERE session_id = $1 AND question_id = ANY($2)`
+	rows, err := r.pool.Query(ctx, serveQuery, sessionID, []int{p.questionID})
+	if err != nil {
+		return fmt.Errorf("serve lookup: %w", err)
+	}
+	rows.Close()
+
+	// Locked section navigation reads the session's section progress; sandbox sessions
+	// never start a section, so the answer is saved regardless
+	rows, err = r.pool.Query(ctx, `SELECT section_id, started_at, ended_at, NOW() FROM session_sections WHERE session_id = $1`, sessionID)
+	if err != nil {
+		return fmt.Errorf("section lookup: %w", err)
+	}
+	rows.Close()
+
+	timeTaken := 5 + w.rng.Intn(55)
+	option := w.rng.Intn(4)
+	answer := ingest.Answer{SessionID: sessionID, QuestionID: p.questionID, Option: option,
+		Correct: w.rng.Intn(10) < 6, TimeTaken: timeTaken, ClientTime: timeTaken}
+	if r.batcher != nil {
+		_, err = r.batcher.Insert(ctx, answer)
+	} else {
+		_, err = ingest.InsertDirect(ctx, r.pool, answer)
+	}
+	if err != nil {
+		return fmt.Errorf("insert answer: %w", err)
+	}
+
+	eventQuery := `
+		INSERT INTO answer_events (session_id, question_id, selected_option_index, client_time_taken_seconds, outcome, ip, user_agent)
+		VALUES ($1, $2, $3, $4, 'created', '127.0.0.1', 'loadtest')
+	`
+	if _, err := r.pool.Exec(ctx, eventQuery, sessionID, p.questionID, option, timeTaken); err != nil {
+		return fmt.Errorf("insert answer event: %w", err)
+	}
+	return nil
+}
+
+// endSession replays POST /api/live/end-session. Cache invalidation and the
+// session.completed event are skipped so a run does not reach Redis or webhook subscribers.
+func (r *runner) endSession(ctx context.Context, p prepared) error {
+	var sessionID, studentID int
+	var completed bool
+	var startedAt time.Time
+	sessionQuery := `
+		SELECT id, student_id, completed, started_at
+		FROM sessions
+		WHERE session_token = $1
+	`
+	if err := r.pool.QueryRow(ctx, sessionQuery, p.sessionToken).Scan(&sessionID, &studentID, &completed, &startedAt); err != nil {
+		return fmt.Errorf("session lookup: %w", err)
+	}
+
+	if _, err := scoring.FinalizeIn(ctx, r.pool, sessionID); err != nil {
+		return fmt.Errorf("finalize: %w", err)
+	}
+	return nil
+}
+
+// sessionToken generates a token shaped like the live handlers' session tokens
+func sessionToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// record stores the run in test_results with a fresh context, so a run ended by shutdown
+// is still saved. The *_db_time_ms columns hold whole milliseconds; latency_distribution
+// keeps microsecond precision and the histogram.
+func record(result *Result, notes string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	distribution, err := json.Marshal(result.Latency)
+	if err != nil {
+		return err
+	}
+
+	if notes == "" {
+		notes = fmt.Sprintf("%d goroutines for %ds against %d sandbox students", result.Goroutines, result.DurationSeconds, result.Students)
+	}
+	if result.Ingest == ingest.ModeBatch {
+		notes += " (batched answer ingest)"
+	}
+	if result.Interrupted {
+		notes += " (interrupted by shutdown)"
+	}
+
+	query := `
+		INSERT INTO test_results (
+			test_type, total_requests, successful_requests, failed_requests,
+			error_rate, min_db_time_ms, max_db_time_ms, avg_db_time_ms,
+			p50_db_time_ms, p95_db_time_ms, p99_db_time_ms,
+			test_duration_seconds, notes, concurrency, latency_distribution
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING id, created_at
+	`
+	err = db.Pool.QueryRow(ctx, query,
+		result.TestType,
+		result.TotalRequests,
+		result.SuccessfulRequests,
+		result.FailedRequests,
+		result.ErrorRate,
+		int64(result.Latency.MinMs),
+		int64(result.Latency.MaxMs),
+		int64(result.Latency.AvgMs),
+		int64(result.Latency.P50Ms),
+		int64(result.Latency.P95Ms),
+		int64(result.Latency.P99Ms),
+		result.DurationSeconds,
+		notes,
+		result.Goroutines,
+		distribution,
+	).Scan(&result.ID, &result.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save test results: %w", err)
+	}
+	return nil
+}
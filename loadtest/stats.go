@@ -0,0 +1,81 @@
+package loadtest
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// histogramBoundsMs are the upper bounds of the latency histogram buckets; a final
+// bucket collects everything slower
+var histogramBoundsMs = []float64{0.5, 1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// Bucket counts operations with FromMs < latency <= ToMs. ToMs is null for the last bucket.
+type Bucket struct {
+	FromMs float64  `json:"from_ms"`
+	ToMs   *float64 `json:"to_ms"`
+	Count  int      `json:"count"`
+}
+
+// Distribution summarises the latency of every successful operation in a run
+type Distribution struct {
+	Count     int      `json:"count"`
+	MinMs     float64  `json:"min_ms"`
+	MaxMs     float64  `json:"max_ms"`
+	AvgMs     float64  `json:"avg_ms"`
+	P50Ms     float64  `json:"p50_ms"`
+	P90Ms     float64  `json:"p90_ms"`
+	P95Ms     float64  `json:"p95_ms"`
+	P99Ms     float64  `json:"p99_ms"`
+	P999Ms    float64  `json:"p999_ms"`
+	Histogram []Bucket `json:"histogram"`
+}
+
+// distribution sorts the latencies in place and summarises them
+func distribution(latencies []time.Duration) Distribution {
+	d := Distribution{Count: len(latencies), Histogram: make([]Bucket, len(histogramBoundsMs)+1)}
+	from := 0.0
+	for i, bound := range histogramBoundsMs {
+		to := bound
+		d.Histogram[i] = Bucket{FromMs: from, ToMs: &to}
+		from = bound
+	}
+	d.Histogram[len(histogramBoundsMs)] = Bucket{FromMs: from}
+
+	if len(latencies) == 0 {
+		return d
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+		ms := toMs(latency)
+		d.Histogram[sort.SearchFloat64s(histogramBoundsMs, ms)].Count++
+	}
+
+	d.MinMs = toMs(latencies[0])
+	d.MaxMs = toMs(latencies[len(latencies)-1])
+	d.AvgMs = toMs(total / time.Duration(len(latencies)))
+	d.P50Ms = percentile(latencies, 0.50)
+	d.P90Ms = percentile(latencies, 0.90)
+	d.P95Ms = percentile(latencies, 0.95)
+	d.P99Ms = percentile(latencies, 0.99)
+	d.P999Ms = percentile(latencies, 0.999)
+	return d
+}
+
+// percentile uses the nearest-rank method on sorted latencies
+func percentile(sorted []time.Duration, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return toMs(sorted[rank])
+}
+
+// toMs converts to milliseconds with microsecond precision
+func toMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,43 @@
+package logging
+
+import (
+	stdlog "log"
+	"mcq-exam/instance"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Init configures the global logger from LOG_LEVEL (debug|info|warn|error, default info)
+// and LOG_FORMAT (json, default, or console for local development). Every line carries the
+// instance ID (see instance.ID). Output from the standard library log package, used by
+// dependencies, is routed through it.
+func Init() {
+	zerolog.TimeFieldFormat = time.RFC3339Nano
+	zerolog.DurationFieldUnit = time.Millisecond
+
+	var logger zerolog.Logger
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "console") {
+		logger = zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339})
+	} else {
+		logger = zerolog.New(os.Stdout)
+	}
+	log.Logger = logger.With().Timestamp().Str("instance", instance.ID()).Logger()
+
+	level := zerolog.InfoLevel
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		parsed, err := zerolog.ParseLevel(strings.ToLower(value))
+		if err != nil || parsed == zerolog.NoLevel {
+			log.Warn().Str("value", value).Msg("Invalid LOG_LEVEL, using info")
+		} else {
+			level = parsed
+		}
+	}
+	zerolog.SetGlobalLevel(level)
+
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(log.Logger.Level(zerolog.InfoLevel))
+}
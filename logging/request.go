@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// HeaderRequestID carries the request ID in both directions
+const HeaderRequestID = "X-Request-ID"
+
+const (
+	loggerKey    = "logger"
+	requestIDKey = "request_id"
+)
+
+// Attach stores the request logger, tagged with the request ID, on the context
+func Attach(c *fiber.Ctx, requestID string) {
+	logger := log.Logger.With().Str("request_id", requestID).Logger()
+	c.Locals(requestIDKey, requestID)
+	c.Locals(loggerKey, &logger)
+}
+
+// RequestID returns the ID assigned by the request ID middleware, or "" outside a request
+func RequestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(requestIDKey).(string)
+	return id
+}
+
+// Ctx returns the request's logger: the global logger with request_id and, once known,
+// student_id and session_id. It falls back to the global logger outside the middleware.
+func Ctx(c *fiber.Ctx) *zerolog.Logger {
+	if logger, ok := c.Locals(loggerKey).(*zerolog.Logger); ok {
+		return logger
+	}
+	return &log.Logger
+}
+
+// SetStudent adds student_id to every later log line of the request, including the access log
+func SetStudent(c *fiber.Ctx, studentID int) {
+	logger := Ctx(c).With().Int("student_id", studentID).Logger()
+	c.Locals(loggerKey, &logger)
+}
+
+// SetSession adds session_id to every later log line of the request, including the access log
+func SetSession(c *fiber.Ctx, sessionID int) {
+	logger := Ctx(c).With().Int("session_id", sessionID).Logger()
+	c.Locals(loggerKey, &logger)
+}
+
+// SetAPIKey adds api_key_id to every later log line of the request, including the access log
+func SetAPIKey(c *fiber.Ctx, keyID int) {
+	logger := Ctx(c).With().Int("api_key_id", keyID).Logger()
+	c.Locals(loggerKey, &logger)
+}
@@ -0,0 +1,167 @@
+package mailhtml
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// gmailClipBytes is the size above which Gmail clips a message behind "View entire message",
+// hiding its end and the tracking pixel with it
+const gmailClipBytes = 102 * 1024
+
+// trackOpenPath is in the src of the pixel the send pipeline adds (tracking.Instrument)
+const trackOpenPath = "/api/track-open"
+
+// variablePattern matches a template variable, capturing what is between the braces
+var variablePattern = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+
+// Issue is one finding of Lint
+type Issue struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Report is what Lint found. Errors stop the email from being sent; warnings are for the
+// author to judge.
+type Report struct {
+	Valid    bool        `json:"valid"`
+	Size     int         `json:"size"`
+	MaxSize  int         `json:"max_size"`
+	Errors   []Issue     `json:"errors"`
+	Warnings []Issue     `json:"warnings"`
+	Removed  []string    `json:"removed"`
+	Links    []LinkError `json:"invalid_links"`
+}
+
+// Lint checks an email before it is sent: the body's size and links as Sanitize does, what
+// sanitizing would remove, images without alt text, a missing tracking pixel and template
+// variables in the subject or body that are not among variables or are malformed.
+func Lint(subject, body string, variables []string) Report {
+	report := Report{
+		Size:     len(body),
+		MaxSize:  MaxBytes(),
+		Errors:   []Issue{},
+		Warnings: []Issue{},
+		Removed:  []string{},
+		Links:    []LinkError{},
+	}
+
+	// Check only fails on a body over MaxBytes
+	result, links, err := Check(body)
+	if err != nil {
+		report.Errors = append(report.Errors, Issue{Code: "too_large", Message: err.Error()})
+	} else {
+		report.Removed = result.Removed
+		report.Links = links
+	}
+	for _, link := range links {
+		report.Errors = append(report.Errors, Issue{Code: "invalid_link", Message: link.String()})
+	}
+	if len(report.Removed) > 0 {
+		report.Warnings = append(report.Warnings, Issue{Code: "sanitized",
+			Message: "Removed when sent: " + strings.Join(report.Removed, ", ")})
+	}
+	if report.Size > gmailClipBytes {
+		report.Warnings = append(report.Warnings, Issue{Code: "gmail_clipping",
+			Message: fmt.Sprintf("The body is %d bytes; Gmail clips messages over %d bytes, hiding the end and the tracking pixel", report.Size, gmailClipBytes)})
+	}
+
+	missingAlt, pixel := lintImages(body)
+	if missingAlt > 0 {
+		report.Warnings = append(report.Warnings, Issue{Code: "missing_alt",
+			Message: fmt.Sprintf("%d image(s) have no alt text; readers with images blocked or screen readers see nothing", missingAlt)})
+	}
+	if !pixel {
+		report.Warnings = append(report.Warnings, Issue{Code: "no_tracking_pixel",
+			Message: "No open-tracking pixel; opens are only counted for emails sent to students, which get it when sent (not POST /api/mail/send or untracked campaigns)"})
+	}
+
+	report.Warnings = append(report.Warnings, lintVariables(subject+"\n"+body, variables)...)
+	report.Valid = len(report.Errors) == 0
+	return report
+}
+
+// lintImages counts the images without an alt attribute and reports whether the body has
+// the open-tracking pixel
+func lintImages(body string) (missingAlt int, pixel bool) {
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return missingAlt, pixel
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		token := z.Token()
+		if token.Data != "img" {
+			continue
+		}
+		hasAlt, isPixel := false, false
+		for _, attr := range token.Attr {
+			switch attr.Key {
+			case "alt":
+				hasAlt = true
+			case "src":
+				isPixel = strings.Contains(attr.Val, trackOpenPath)
+			}
+		}
+		pixel = pixel || isPixel
+		if !hasAlt && !isPixel {
+			missingAlt++
+		}
+	}
+}
+
+// lintVariables reports template variables that would be sent as written: names not among
+// variables, names with spaces inside the braces and braces that do not pair up
+func lintVariables(text string, variables []string) []Issue {
+	known := make(map[string]bool, len(variables))
+	for _, name := range variables {
+		known[name] = true
+	}
+
+	unknown := make(map[string]bool)
+	malformed := make(map[string]bool)
+	for _, match := range variablePattern.FindAllStringSubmatch(text, -1) {
+		name := strings.TrimSpace(match[1])
+		switch {
+		case name != match[1] && known[name]:
+			malformed[match[0]] = true
+		case !known[name]:
+			unknown[match[0]] = true
+		}
+	}
+
+	issues := []Issue{}
+	if len(unknown) > 0 {
+		message := "Not replaced when sent: " + strings.Join(sortedKeys(unknown), ", ")
+		if len(variables) > 0 {
+			message += "; this email replaces {{" + strings.Join(variables, "}}, {{") + "}}"
+		}
+		issues = append(issues, Issue{Code: "unknown_variable", Message: message})
+	}
+	if len(malformed) > 0 {
+		issues = append(issues, Issue{Code: "malformed_variable",
+			Message: "Variables must be written without spaces, e.g. {{name}}: " + strings.Join(sortedKeys(malformed), ", ")})
+	}
+	rest := variablePattern.ReplaceAllString(text, "")
+	if strings.Contains(rest, "{{") || strings.Contains(rest, "}}") {
+		issues = append(issues, Issue{Code: "unbalanced_braces",
+			Message: "A variable is missing its opening or closing braces"})
+	}
+	return issues
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,293 @@
+// Package mailhtml checks the HTML of emails written by admins before it goes out under the
+// institute's domain. Sanitize keeps an allow-list of tags and attributes, rejects links
+// that would not work or could run code in a mail client, and bounds the size of a body;
+// Lint reports what a reader would find wrong with an email before it is sent.
+package mailhtml
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/html"
+)
+
+// DefaultMaxBytes bounds an HTML body when MAIL_HTML_MAX_BYTES is unset
+const DefaultMaxBytes = 512 * 1024
+
+var (
+	// ErrTooLarge is wrapped with the size of a body over MaxBytes
+	ErrTooLarge = errors.New("html_body too large")
+	// ErrInvalidLinks is returned by Sanitize for a body with links that would not work in
+	// an email
+	ErrInvalidLinks = errors.New("html_body has invalid links")
+)
+
+var (
+	maxBytesOnce sync.Once
+	maxBytes     = DefaultMaxBytes
+)
+
+// MaxBytes is the largest HTML body accepted (MAIL_HTML_MAX_BYTES, default 512 KB)
+func MaxBytes() int {
+	maxBytesOnce.Do(func() {
+		if value := strings.TrimSpace(os.Getenv("MAIL_HTML_MAX_BYTES")); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				log.Warn().Msgf("Invalid MAIL_HTML_MAX_BYTES=%q, using %d", value, DefaultMaxBytes)
+				return
+			}
+			maxBytes = parsed
+		}
+	})
+	return maxBytes
+}
+
+// allowedTags are kept with their allowed attributes; other tags are dropped and their
+// content kept, except for droppedWithContent
+var allowedTags = map[string]bool{
+	"html": true, "head": true, "body": true, "title": true, "meta": true, "style": true,
+	"a": true, "abbr": true, "address": true, "area": true, "article": true, "b": true,
+	"big": true, "blockquote": true, "br": true, "caption": true, "center": true, "cite": true,
+	"code": true, "col": true, "colgroup": true, "dd": true, "del": true, "div": true,
+	"dl": true, "dt": true, "em": true, "figcaption": true, "figure": true, "font": true,
+	"footer": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "i": true, "img": true, "ins": true, "kbd": true, "li": true,
+	"main": true, "map": true, "ol": true, "p": true, "pre": true, "q": true, "s": true,
+	"section": true, "small": true, "span": true, "strike": true, "strong": true, "sub": true,
+	"sup": true, "table": true, "tbody": true, "td": true, "tfoot": true, "th": true,
+	"thead": true, "tr": true, "tt": true, "u": true, "ul": true, "wbr": true,
+}
+
+// droppedWithContent are removed along with everything inside them: they run code, embed
+// other documents or collect input
+var droppedWithContent = map[string]bool{
+	"script": true, "iframe": true, "frame": true, "frameset": true, "object": true,
+	"embed": true, "applet": true, "noscript": true, "template": true, "svg": true,
+	"math": true, "form": true, "textarea": true, "select": true, "button": true,
+}
+
+// voidElements of droppedWithContent never have content, so dropping them drops nothing else
+var voidElements = map[string]bool{"embed": true, "frame": true}
+
+// globalAttributes are allowed on every kept tag
+var globalAttributes = map[string]bool{
+	"style": true, "class": true, "id": true, "dir": true, "lang": true, "title": true,
+	"align": true, "valign": true, "width": true, "height": true, "bgcolor": true,
+	"background": true, "border": true, "role": true,
+}
+
+// tagAttributes are allowed on specific tags, on top of globalAttributes
+var tagAttributes = map[string]map[string]bool{
+	"a":        {"href": true, "target": true, "rel": true, "name": true},
+	"area":     {"href": true, "target": true, "rel": true, "alt": true, "shape": true, "coords": true},
+	"img":      {"src": true, "alt": true, "usemap": true, "hspace": true, "vspace": true},
+	"map":      {"name": true},
+	"meta":     {"charset": true, "name": true, "content": true},
+	"table":    {"cellpadding": true, "cellspacing": true, "summary": true},
+	"td":       {"colspan": true, "rowspan": true, "nowrap": true},
+	"th":       {"colspan": true, "rowspan": true, "nowrap": true, "scope": true},
+	"col":      {"span": true},
+	"colgroup": {"span": true},
+	"font":     {"color": true, "face": true, "size": true},
+	"ol":       {"start": true, "type": true},
+	"ul":       {"type": true},
+	"li":       {"value": true},
+}
+
+// urlAttributes hold links, checked by checkLink
+var urlAttributes = map[string]bool{"href": true, "src": true, "background": true}
+
+// unsafeCSS matches CSS that runs code or loads bindings in old mail clients
+var unsafeCSS = regexp.MustCompile(`(?i)expression\s*\(|javascript:|vbscript:|behavior\s*:|-moz-binding|@import`)
+
+// placeholderPattern matches a template variable such as {{name}}
+var placeholderPattern = regexp.MustCompile(`\{\{[^{}]*\}\}`)
+
+// Result is a sanitized body
+type Result struct {
+	HTML string `json:"-"`
+	// Removed describes what was taken out, e.g. "<script>" or "onclick on <a>", once each
+	Removed []string `json:"removed"`
+}
+
+// LinkError is a link Sanitize rejects
+type LinkError struct {
+	Tag       string `json:"tag"`
+	Attribute string `json:"attribute"`
+	URL       string `json:"url"`
+	Reason    string `json:"reason"`
+}
+
+func (e LinkError) String() string {
+	return fmt.Sprintf("%s on <%s>: %s (%q)", e.Attribute, e.Tag, e.Reason, e.URL)
+}
+
+// checkLink returns why a link in attribute of tag would not work in an email, or "" when
+// it is fine. Template variables stand in for the value they are replaced with, so
+// href="{{link}}" passes.
+func checkLink(tag, attribute, value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "link is empty"
+	}
+	if placeholderPattern.ReplaceAllString(value, "") == "" {
+		return ""
+	}
+	if strings.HasPrefix(value, "#") {
+		return ""
+	}
+	u, err := url.Parse(placeholderPattern.ReplaceAllString(value, "x"))
+	if err != nil {
+		return "not a valid URL"
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return "link has no host"
+		}
+		return ""
+	case "mailto", "tel":
+		if attribute != "href" {
+			return u.Scheme + " links only work in href"
+		}
+		return ""
+	case "cid":
+		if tag != "img" || attribute != "src" {
+			return "cid links only work as an image src"
+		}
+		return ""
+	case "data":
+		if tag == "img" && attribute == "src" && strings.HasPrefix(strings.ToLower(u.Opaque), "image/") {
+			return ""
+		}
+		return "data links are only allowed for images"
+	case "":
+		return "relative links do not resolve in an email; use an absolute https URL"
+	default:
+		return "scheme " + u.Scheme + " is not allowed"
+	}
+}
+
+// Check sanitizes body and collects its invalid links, without failing on them. The body
+// must not exceed MaxBytes.
+func Check(body string) (Result, []LinkError, error) {
+	if len(body) > MaxBytes() {
+		return Result{}, nil, fmt.Errorf("%w: %d bytes exceed the limit of %d", ErrTooLarge, len(body), MaxBytes())
+	}
+
+	var out strings.Builder
+	out.Grow(len(body))
+	removed := make(map[string]bool)
+	links := []LinkError{}
+	// skip names the tag whose content is being dropped; depth counts its nested copies
+	skip, depth := "", 0
+	inStyle := false
+
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		// Token unescapes text in place, so the raw bytes are copied first
+		raw := append([]byte(nil), z.Raw()...)
+		token := z.Token()
+
+		if skip != "" {
+			switch {
+			case tt == html.StartTagToken && token.Data == skip:
+				depth++
+			case tt == html.EndTagToken && token.Data == skip:
+				depth--
+				if depth == 0 {
+					skip = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.TextToken:
+			if inStyle && unsafeCSS.Match(raw) {
+				removed["unsafe CSS in <style>"] = true
+				continue
+			}
+			out.Write(raw)
+		case html.DoctypeToken:
+			out.Write(raw)
+		case html.CommentToken:
+			removed["comment"] = true
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedWithContent[token.Data] {
+				removed["<"+token.Data+">"] = true
+				if tt == html.StartTagToken && !voidElements[token.Data] {
+					skip, depth = token.Data, 1
+				}
+				continue
+			}
+			if !allowedTags[token.Data] {
+				removed["<"+token.Data+">"] = true
+				continue
+			}
+			out.WriteString("<" + token.Data)
+			for _, attr := range token.Attr {
+				name := strings.ToLower(attr.Key)
+				if attr.Namespace != "" || !(globalAttributes[name] || tagAttributes[token.Data][name]) {
+					removed[name+" on <"+token.Data+">"] = true
+					continue
+				}
+				if name == "style" && unsafeCSS.MatchString(attr.Val) {
+					removed["unsafe style on <"+token.Data+">"] = true
+					continue
+				}
+				if urlAttributes[name] {
+					if reason := checkLink(token.Data, name, attr.Val); reason != "" {
+						links = append(links, LinkError{Tag: token.Data, Attribute: name, URL: attr.Val, Reason: reason})
+						continue
+					}
+				}
+				out.WriteString(" " + name + `="` + html.EscapeString(attr.Val) + `"`)
+			}
+			if tt == html.SelfClosingTagToken {
+				out.WriteString(" /")
+			}
+			out.WriteString(">")
+			inStyle = token.Data == "style" && tt == html.StartTagToken
+		case html.EndTagToken:
+			// The start tag of a dropped element was already reported
+			if allowedTags[token.Data] {
+				out.WriteString("</" + token.Data + ">")
+			}
+			inStyle = false
+		}
+	}
+
+	result := Result{HTML: out.String(), Removed: make([]string, 0, len(removed))}
+	for item := range removed {
+		result.Removed = append(result.Removed, item)
+	}
+	sort.Strings(result.Removed)
+	return result, links, nil
+}
+
+// Sanitize returns body with only allowed tags and attributes. It fails with ErrTooLarge
+// for a body over MaxBytes and with ErrInvalidLinks, along with the links, when a link
+// would not work in an email.
+func Sanitize(body string) (Result, []LinkError, error) {
+	result, links, err := Check(body)
+	if err != nil {
+		return Result{}, nil, err
+	}
+	if len(links) > 0 {
+		return Result{}, links, ErrInvalidLinks
+	}
+	return result, nil, nil
+}
@@ -1,121 +1,490 @@
 package main
 
 import (
-	"log"
+	"context"
+	"mcq-exam/apierror"
+	"mcq-exam/apikeys"
+	"mcq-exam/backup"
+	"mcq-exam/campaigns"
+	"mcq-exam/config"
 	"mcq-exam/db"
+	"mcq-exam/errorreport"
+	"mcq-exam/events"
 	"mcq-exam/handlers"
+	"mcq-exam/ingest"
+	"mcq-exam/instance"
+	"mcq-exam/jobs"
 	"mcq-exam/live"
+	"mcq-exam/logging"
+	"mcq-exam/metrics"
+	"mcq-exam/middleware"
+	"mcq-exam/origins"
+	"mcq-exam/outbox"
+	"mcq-exam/presence"
 	"mcq-exam/scheduler"
+	"mcq-exam/sessioncache"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog/log"
 )
 
+// migrationPollInterval is how often a server started with MIGRATE_ON_START=false checks
+// whether the migration job has finished
+const migrationPollInterval = 5 * time.Second
+
 func main() {
+	// Load .env before anything reads configuration, including the logger
+	envErr := godotenv.Load()
+	logging.Init()
+	if envErr != nil {
+		log.Info().Msg("No .env file found, using environment variables")
+	}
+
+	// Validate every setting before touching the database, so a bad deployment fails
+	// here with the full list of problems instead of misbehaving later
+	cfg, err := config.Load()
+	if err != nil {
+		for _, problem := range strings.Split(err.Error(), "\n") {
+			log.Error().Msg("Invalid configuration: " + problem)
+		}
+		log.Fatal().Msg("Refusing to start with invalid configuration")
+	}
+
+	// Panics and server errors are grouped locally and, with SENTRY_DSN, sent to Sentry
+	errorreport.Init()
+
 	// Initialize database
 	if err := db.InitDB(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		log.Fatal().Err(err).Msg("Failed to initialize database")
 	}
 	defer db.Close()
 
-	// Run migrations
-	databaseURL := os.Getenv("DATABASE_URL")
-	if err := db.RunMigrations(databaseURL); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+	// "migrate" manages the schema and exits without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		code := runMigrateCommand(os.Args[2:])
+		db.Close()
+		os.Exit(code)
+	}
+
+	// "apikey" mints or revokes API keys, e.g. the first admin key once API_KEYS_REQUIRED is on
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		code := runAPIKeyCommand(os.Args[2:])
+		db.Close()
+		os.Exit(code)
+	}
+
+	// "seed" fills a staging or development database with fake students and activity
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		code := runSeedCommand(os.Args[2:])
+		db.Close()
+		os.Exit(code)
 	}
 
-	// Start scheduler
-	scheduler.StartScheduler()
+	// Initialize optional Redis (shared rate limit counters across instances)
+	if err := db.InitRedis(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize redis")
+	}
+	defer db.CloseRedis()
+	middleware.InitRateLimitStore()
+	sessioncache.Init()
+
+	// Background work needs the current schema, so it starts once migrations are applied
+	startBackground := func() {
+		// Start scheduler
+		scheduler.StartScheduler()
+
+		// Start outbound webhook dispatcher
+		events.StartDispatcher(4)
+
+		// Flag (and optionally finalize) sessions whose candidate went idle
+		presence.StartSweeper()
+
+		// Batch answer writes when ANSWER_INGEST=batch
+		ingest.Start()
+
+		// Browser origins allowed to call the API, kept in step with the stored allow-list
+		origins.StartRefresher()
+
+		// Send the emails queued with their tokens
+		outbox.Start()
+
+		// Continue email campaigns that were sending when the server last stopped
+		campaignCtx, campaignCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := campaigns.ResumeRunning(campaignCtx); err != nil {
+			log.Error().Err(err).Msg("Failed to resume email campaigns")
+		}
+		campaignCancel()
+	}
+
+	// With several replicas, MIGRATE_ON_START=false leaves migrating to one job (./main migrate up);
+	// the server then listens straight away but stays unready until the schema is current
+	migrateOnStart := true
+	if value := os.Getenv("MIGRATE_ON_START"); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			migrateOnStart = parsed
+		} else {
+			log.Warn().Msgf("Invalid MIGRATE_ON_START=%q, using true", value)
+		}
+	}
+	if migrateOnStart {
+		if err := db.RunMigrations(cfg.DatabaseURL); err != nil {
+			log.Fatal().Err(err).Msg("Failed to run migrations")
+		}
+		startBackground()
+		instance.SetPhase(instance.PhaseServing)
+	} else {
+		log.Info().Msg("Skipping migrations (MIGRATE_ON_START=false); waiting for the schema to be current")
+		jobs.Go(func(ctx context.Context) {
+			if err := db.WaitForMigrations(ctx, migrationPollInterval); err != nil {
+				return
+			}
+			startBackground()
+			instance.SetPhase(instance.PhaseServing)
+			log.Info().Msg("Schema is current, instance ready")
+		})
+	}
 
 	// Create Fiber app
-	app := fiber.New(fiber.Config{
-		AppName: "MCQ Exam API",
-	})
+	appConfig := fiber.Config{
+		AppName:      "MCQ Exam API",
+		ErrorHandler: apierror.Handler,
+	}
+
+	// Behind a reverse proxy, take the client IP from a header set by the trusted proxy
+	if len(cfg.TrustedProxies) > 0 {
+		appConfig.EnableTrustedProxyCheck = true
+		appConfig.TrustedProxies = cfg.TrustedProxies
+		appConfig.ProxyHeader = cfg.ProxyHeader
+	}
+
+	// Backups are far larger than any other request body. While they may be imported, bodies
+	// are streamed so the import alone can take BACKUP_IMPORT_MAX_MB (see middleware.BodyLimit);
+	// multipart forms are not parsed ahead, so they are held to the limit too.
+	importEnabled := backup.ImportEnabled()
+	if importEnabled {
+		appConfig.StreamRequestBody = true
+		appConfig.DisablePreParseMultipartForm = true
+	}
+
+	app := fiber.New(appConfig)
+	if importEnabled {
+		app.Use(middleware.BodyLimit(fiber.DefaultBodyLimit, "/api/admin/import"))
+	}
 
-	// Middleware
-	app.Use(recover.New())
-	app.Use(logger.New())
-	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders: "*",
+	// Middleware. The request logger runs first so recovered panics are logged with their request ID.
+	app.Use(middleware.RequestLogger())
+	app.Use(recover.New(recover.Config{
+		EnableStackTrace: true,
+		StackTraceHandler: func(c *fiber.Ctx, e interface{}) {
+			middleware.ReportPanic(c, e, debug.Stack())
+		},
 	}))
+	app.Use(middleware.ReportErrors())
+	app.Use(metrics.Middleware())
+	app.Use(middleware.CloseWhenDraining())
+	app.Use(middleware.TrustedOrigins())
+	app.Use(middleware.CORS())
 
 	// Routes
 	api := app.Group("/api")
 
+	// API keys (X-API-Key) by scope; a key is only required with API_KEYS_REQUIRED=true
+	statsKey := middleware.RequireAPIKey(apikeys.ScopeStats)
+	mailKey := middleware.RequireAPIKey(apikeys.ScopeMail)
+	adminKey := middleware.RequireAPIKey(apikeys.ScopeAdmin)
+
 	// Student endpoints
-	students := api.Group("/students")
+	students := api.Group("/students", adminKey)
 	students.Post("/bulk", handlers.BulkCreateStudentsFiber)
 	students.Get("/", handlers.GetAllStudentsFiber)
+	students.Get("/tags", handlers.GetStudentTagsHandler)
+	students.Post("/tags/bulk", handlers.BulkTagStudentsHandler)
 	students.Post("/", handlers.CreateStudentFiber)
 	students.Get("/:id", handlers.GetStudentFiber)
 	students.Put("/:id", handlers.UpdateStudentFiber)
+	students.Patch("/:id", handlers.PatchStudentFiber)
+	students.Get("/:id/email-history", handlers.GetStudentEmailHistoryFiber)
+	students.Get("/:id/emails", handlers.GetStudentEmailsFiber)
+	students.Post("/:id/tags", handlers.AddStudentTagsHandler)
+	students.Delete("/:id/tags/:tag", handlers.RemoveStudentTagHandler)
 	students.Delete("/:id", handlers.DeleteStudentFiber)
 
 	// Admin endpoints
-	admin := api.Group("/admin")
+	admin := api.Group("/admin", adminKey)
 	admin.Post("/reset-db", handlers.ResetDatabaseHandler)
+	admin.Post("/section-scores/rebuild", handlers.RebuildSectionScoresHandler)
+	admin.Get("/sessions/inconsistent", handlers.GetInconsistentSessionsHandler)
+	admin.Get("/sessions/activity", handlers.GetSessionActivityHandler)
+	admin.Get("/sessions/ip-flagged", handlers.GetIPFlaggedSessionsHandler)
+	admin.Post("/sessions/reconcile", handlers.ReconcileSessionsHandler)
+	admin.Post("/exams/:id/archive", handlers.ArchiveExamHandler)
+	admin.Delete("/archives/:id", handlers.DeleteArchiveHandler)
+	admin.Post("/regrade", handlers.RegradeHandler)
+	admin.Get("/regrades", handlers.GetRegradesHandler)
+	admin.Get("/sessions/:session_id/answer-events", handlers.GetSessionAnswerEventsHandler)
+	admin.Get("/sessions/:session_id", handlers.GetSessionDetailHandler)
+	admin.Post("/sessions/:session_id/force-end", handlers.ForceEndSessionHandler)
+	admin.Post("/sessions/:session_id/disqualify", handlers.DisqualifySessionHandler)
+	admin.Delete("/sessions/:session_id/disqualify", handlers.ReinstateSessionHandler)
+	admin.Get("/dashboard", handlers.GetAdminDashboardHandler)
+	admin.Get("/live-metrics", handlers.GetLiveMetricsHandler)
+	admin.Get("/exam/state", handlers.GetExamStateHandler)
+	admin.Post("/exam/pause", handlers.PauseExamHandler)
+	admin.Post("/exam/resume", handlers.ResumeExamHandler)
+	admin.Get("/ranking-policy", handlers.GetRankingPolicyHandler)
+	admin.Get("/config", handlers.GetConfigHandler)
+	admin.Put("/ranking-policy", handlers.UpdateRankingPolicyHandler)
+	admin.Get("/scoring-scheme", handlers.GetScoringSchemeHandler)
+	admin.Put("/scoring-scheme", handlers.UpdateScoringSchemeHandler)
+	admin.Get("/answer-change", handlers.GetAnswerChangeHandler)
+	admin.Put("/answer-change", handlers.UpdateAnswerChangeHandler)
+	admin.Delete("/answer-change", handlers.ResetAnswerChangeHandler)
+	admin.Get("/results-publication", handlers.GetResultsPublicationHandler)
+	admin.Put("/results-publication", handlers.UpdateResultsPublicationHandler)
+	admin.Delete("/results-publication", handlers.ResetResultsPublicationHandler)
+	admin.Get("/retention/policies", handlers.GetRetentionPoliciesHandler)
+	admin.Put("/retention/policies", handlers.UpdateRetentionPoliciesHandler)
+	admin.Get("/retention/preview", handlers.PreviewRetentionHandler)
+	admin.Post("/retention/run", handlers.RunRetentionHandler)
+	admin.Get("/retention/runs", handlers.GetRetentionRunsHandler)
+	admin.Get("/errors", handlers.GetErrorGroupsHandler)
+	admin.Delete("/errors", handlers.ClearErrorGroupsHandler)
+	admin.Post("/questions/import", handlers.ImportQuestionsHandler)
+	admin.Get("/questions/composition", handlers.GetQuestionCompositionHandler)
+	admin.Put("/questions/composition", handlers.UpdateQuestionCompositionHandler)
+	admin.Put("/questions/metadata", handlers.UpdateQuestionMetadataHandler)
+	admin.Get("/migrations", handlers.GetMigrationsHandler)
+	admin.Post("/migrations/up", handlers.MigrateUpHandler)
+	admin.Post("/migrations/down", handlers.MigrateDownHandler)
+	admin.Post("/test-run", handlers.CreateTestRunHandler)
+	admin.Delete("/test-run", handlers.DeleteTestRunsHandler)
+	admin.Post("/tokens/rotate", handlers.RotateTokensHandler)
+	admin.Get("/export", handlers.ExportBackupHandler)
+	admin.Post("/import", middleware.LargeBody(backup.ImportLimit()), handlers.ImportBackupHandler)
+	admin.Post("/seed", handlers.SeedHandler)
+	admin.Get("/i18n/strings/:locale", handlers.GetUIStringOverridesHandler)
+	admin.Put("/i18n/strings/:locale", handlers.UpdateUIStringsHandler)
+	admin.Get("/cors", handlers.GetCORSHandler)
+	admin.Post("/cors/origins", handlers.AddOriginHandler)
+	admin.Delete("/cors/origins/:id", handlers.RemoveOriginHandler)
+
+	// API keys for machine-to-machine callers
+	adminAPIKeys := admin.Group("/api-keys")
+	adminAPIKeys.Post("/", handlers.CreateAPIKeyHandler)
+	adminAPIKeys.Get("/", handlers.GetAPIKeysHandler)
+	adminAPIKeys.Delete("/:id", handlers.RevokeAPIKeyHandler)
+	adminAPIKeys.Get("/:id/usage", handlers.GetAPIKeyUsageHandler)
+
+	// Student access codes (expiry, regeneration, audit trail), duplicate merges and activity timelines
+	adminStudents := admin.Group("/students")
+	adminStudents.Post("/merge", handlers.MergeStudentsHandler)
+	adminStudents.Get("/merges", handlers.GetStudentMergesHandler)
+	adminStudents.Post("/revalidate-emails", handlers.RevalidateEmailsHandler)
+	adminStudents.Get("/:id/access-code", handlers.GetAccessCodeHandler)
+	adminStudents.Post("/:id/access-code/regenerate", handlers.RegenerateAccessCodeHandler)
+	adminStudents.Post("/:id/access-code/invalidate", handlers.InvalidateAccessCodeHandler)
+	adminStudents.Get("/:id/timeline", handlers.GetStudentTimelineHandler)
+
+	// verify-otp attempts and lockouts
+	admin.Get("/otp-attempts", handlers.GetOTPAttemptsHandler)
+	admin.Post("/otp-attempts/unlock", handlers.UnlockOTPHandler)
+	admin.Get("/outbox", handlers.GetOutboxHandler)
+	admin.Post("/outbox/retry", handlers.RetryOutboxHandler)
+
+	// Scheduled jobs
+	adminJobs := admin.Group("/jobs")
+	adminJobs.Post("/", handlers.CreateScheduledJobHandler)
+	adminJobs.Get("/", handlers.GetScheduledJobsHandler)
+	adminJobs.Get("/history", handlers.GetJobHistoryHandler)
+	adminJobs.Post("/:name/trigger", handlers.TriggerJobHandler)
+	adminJobs.Get("/:id", handlers.GetScheduledJobHandler)
+	adminJobs.Put("/:id", handlers.UpdateScheduledJobHandler)
+	adminJobs.Delete("/:id", handlers.DeleteScheduledJobHandler)
+	adminJobs.Post("/:id/pause", handlers.PauseScheduledJobHandler)
+	adminJobs.Post("/:id/resume", handlers.ResumeScheduledJobHandler)
+	adminJobs.Post("/:id/run", handlers.RunScheduledJobHandler)
+
+	// Proctoring review
+	adminProctoring := admin.Group("/proctoring")
+	adminProctoring.Get("/flagged", handlers.GetFlaggedSessionsHandler)
+	adminProctoring.Get("/sessions/:session_id/events", handlers.GetSessionProctorEventsHandler)
+
+	// Outbound webhook subscriptions
+	adminWebhooks := admin.Group("/webhooks")
+	adminWebhooks.Post("/", handlers.CreateWebhookSubscriptionHandler)
+	adminWebhooks.Get("/", handlers.GetWebhookSubscriptionsHandler)
+	adminWebhooks.Get("/:id", handlers.GetWebhookSubscriptionHandler)
+	adminWebhooks.Put("/:id", handlers.UpdateWebhookSubscriptionHandler)
+	adminWebhooks.Delete("/:id", handlers.DeleteWebhookSubscriptionHandler)
+	adminWebhooks.Get("/:id/deliveries", handlers.GetWebhookDeliveriesHandler)
 
 	// Mail endpoints
-	mail := api.Group("/mail")
+	mail := api.Group("/mail", mailKey)
 	mail.Post("/send", handlers.SendEmailHandler)
+	mail.Post("/lint", handlers.LintEmailHandler)
 	mail.Post("/send-all", handlers.SendAllEmailsHandler)
+	mail.Get("/send-all/:id", handlers.GetSendAllStatusHandler)
+	mail.Get("/send-all/:id/stream", handlers.StreamSendAllHandler)
 	mail.Post("/resend-conference", handlers.ResendConferenceInvitationHandler)
 	mail.Post("/resend-test-invitation", handlers.ResendTestInvitationHandler)
+	mail.Post("/resend", handlers.ResendHandler)
+	mail.Post("/send-results", handlers.SendResultsHandler)
+	mail.Get("/send-results/preview", handlers.PreviewResultsHandler)
 	mail.Get("/stats", handlers.GetEmailStatsHandler)
+	mail.Get("/deliverability", handlers.GetEmailDeliverabilityHandler)
 	mail.Get("/search", handlers.SearchEmailHandler)
 	mail.Get("/logs", handlers.GetEmailLogsHandler)
+	mail.Get("/suppressions", handlers.GetSuppressionsHandler)
+	mail.Post("/suppressions", handlers.AddSuppressionHandler)
+	mail.Delete("/suppressions/:email", handlers.RemoveSuppressionHandler)
+	mail.Get("/templates", handlers.GetEmailTemplatesHandler)
+	mail.Put("/templates/:key/:locale", handlers.PutEmailTemplateHandler)
+	mail.Delete("/templates/:key/:locale", handlers.DeleteEmailTemplateHandler)
+
+	// Email campaigns (segment targeting, background sending with pause/resume)
+	mailCampaigns := mail.Group("/campaigns")
+	mailCampaigns.Post("/", handlers.CreateCampaignHandler)
+	mailCampaigns.Get("/", handlers.GetCampaignsHandler)
+	mailCampaigns.Get("/preview", handlers.PreviewSegmentHandler)
+	mailCampaigns.Get("/:id", handlers.GetCampaignHandler)
+	mailCampaigns.Delete("/:id", handlers.DeleteCampaignHandler)
+	mailCampaigns.Get("/:id/preview", handlers.PreviewCampaignHandler)
+	mailCampaigns.Get("/:id/recipients", handlers.GetCampaignRecipientsHandler)
+	mailCampaigns.Get("/:id/variables", handlers.GetCampaignVariablesHandler)
+	mailCampaigns.Put("/:id/variables", handlers.SetCampaignVariablesHandler)
+	mailCampaigns.Post("/:id/launch", handlers.LaunchCampaignHandler)
+	mailCampaigns.Post("/:id/pause", handlers.PauseCampaignHandler)
+	mailCampaigns.Post("/:id/resume", handlers.ResumeCampaignHandler)
+
+	// SMS and WhatsApp notifications
+	notifications := api.Group("/notify", mailKey)
+	notifications.Post("/access-code", handlers.SendAccessCodeNotificationHandler)
+	notifications.Post("/start-reminder", handlers.SendStartReminderNotificationHandler)
+	notifications.Get("/logs", handlers.GetNotificationLogsHandler)
+	notifications.Get("/channels", handlers.GetNotificationChannelsHandler)
 
 	// Webhook endpoints
 	webhooks := api.Group("/webhooks")
 	webhooks.Post("/zeptomail", handlers.ZeptoMailWebhookHandler)
+	webhooks.Post("/twilio", handlers.TwilioWebhookHandler)
+	webhooks.Get("/whatsapp", handlers.WhatsAppWebhookVerifyHandler)
+	webhooks.Post("/whatsapp", handlers.WhatsAppWebhookHandler)
 
 	// Event scheduling endpoints
-	event := api.Group("/event")
+	event := api.Group("/event", adminKey)
 	event.Post("/schedule", handlers.CreateEventScheduleHandler)
 	event.Get("/schedule", handlers.GetEventScheduleHandler)
+	event.Put("/schedule/window", handlers.UpdateExamWindowHandler)
+	event.Get("/schedule/windows", handlers.GetExamWindowsHandler)
+	event.Put("/schedule/registration", handlers.UpdateRegistrationWindowHandler)
+	event.Get("/attendance/live", handlers.GetLiveAttendanceHandler)
+	event.Get("/reminders", handlers.GetRemindersHandler)
+	event.Post("/reminders", handlers.CreateRemindersHandler)
+	event.Get("/reminders/:id", handlers.GetReminderHandler)
+	event.Put("/reminders/:id", handlers.UpdateReminderHandler)
+	event.Delete("/reminders/:id", handlers.DeleteReminderHandler)
 
-	// Email tracking endpoints
-	api.Get("/track-open", handlers.TrackEmailOpenHandler)
-	tracking := api.Group("/tracking")
+	// Email tracking endpoints. Opens and clicks write on every hit; mail providers fetch
+	// pixels through shared proxies, so the per-IP limit is generous.
+	trackLimiter := middleware.RateLimit(middleware.RateLimitFromEnv("track-ip", "RATE_LIMIT_TRACK_IP", "600/1m", middleware.KeyByIP))
+	api.Get("/track-open", trackLimiter, handlers.TrackEmailOpenHandler)
+	api.Get("/track-click", trackLimiter, handlers.TrackClickHandler)
+	tracking := api.Group("/tracking", statsKey)
+	tracking.Use(middleware.RateLimit(middleware.RateLimitFromEnv("tracking-ip", "RATE_LIMIT_TRACKING_IP", "120/1m", middleware.KeyByIP)))
 	tracking.Get("/opened-first", handlers.GetStudentsWhoOpenedHandler)
 	tracking.Get("/not-attended", handlers.GetStudentsNotAttendedHandler)
 	tracking.Get("/not-started-test", handlers.GetStudentsNotStartedTestHandler)
+	tracking.Get("/campaigns", handlers.GetCampaignSummaryHandler)
+	tracking.Get("/campaigns/:email_type", handlers.GetCampaignCohortHandler)
+	tracking.Get("/segment", handlers.GetSegmentHandler)
+	tracking.Get("/export", handlers.ExportTrackingHandler)
 
 	// Conference token verification
-	api.Post("/verify-token", handlers.VerifyConferenceTokenHandler)
+	verifyTokenLimiter := middleware.RateLimit(middleware.RateLimitFromEnv("verify-token-ip", "RATE_LIMIT_VERIFY_TOKEN_IP", "60/1m", middleware.KeyByIP))
+	api.Post("/verify-token", verifyTokenLimiter, handlers.VerifyConferenceTokenHandler)
 
 	// Live endpoints
 	liveAPI := api.Group("/live")
-	liveAPI.Post("/verify-first-mail", live.VerifyFirstMailTokenHandler)
-	liveAPI.Post("/get-otp", live.GetOTPHandler)
-	liveAPI.Post("/verify-otp", live.VerifyOTPHandler)
+	liveAPI.Use(middleware.RateLimit(middleware.RateLimitFromEnv("live-ip", "RATE_LIMIT_LIVE_IP", "600/1m", middleware.KeyByIP)))
+	liveAPI.Use(middleware.RateLimit(middleware.RateLimitFromEnv("live-token", "RATE_LIMIT_LIVE_TOKEN", "60/1m", middleware.KeyByToken)))
+
+	// Stricter per-IP limit on code/token verification to stop brute forcing
+	authLimiter := middleware.RateLimit(middleware.RateLimitFromEnv("live-auth-ip", "RATE_LIMIT_AUTH_IP", "20/1m", middleware.KeyByIP))
+	liveAPI.Post("/verify-first-mail", authLimiter, live.VerifyFirstMailTokenHandler)
+	liveAPI.Post("/get-otp", authLimiter, live.GetOTPHandler)
+	liveAPI.Post("/verify-otp", authLimiter, live.VerifyOTPHandler)
+	liveAPI.Get("/exam-window", live.GetExamWindowHandler)
 	liveAPI.Post("/start-session", live.StartSessionHandler)
+	liveAPI.Get("/questions", live.GetQuestionsHandler)
+	liveAPI.Get("/question/:id", live.GetQuestionHandler)
+	liveAPI.Get("/session-state", live.GetSessionStateHandler)
+	liveAPI.Post("/start-section", live.StartSectionHandler)
+	liveAPI.Post("/end-section", live.EndSectionHandler)
 	liveAPI.Post("/submit-answer", live.SubmitAnswerHandler)
+	liveAPI.Post("/submit-answers", live.SubmitAnswersHandler)
+	liveAPI.Post("/flag-question", live.FlagQuestionHandler)
+	liveAPI.Post("/proctor-event", live.ProctorEventHandler)
+	liveAPI.Post("/heartbeat", live.HeartbeatHandler)
 	liveAPI.Post("/end-session", live.EndSessionHandler)
 	liveAPI.Post("/result", live.GetResultHandler)
+	liveAPI.Get("/stats/stream", statsKey, handlers.StreamLiveStatsHandler)
 
 	// Leaderboard endpoints
 	leaderboard := api.Group("/leaderboard")
 	leaderboard.Get("/overall", handlers.GetOverallLeaderboardHandler)
 	leaderboard.Get("/section/:section_id", handlers.GetSectionLeaderboardHandler)
 	leaderboard.Get("/user-sections", handlers.GetUserSectionRanksHandler)
+	leaderboard.Get("/around", handlers.GetLeaderboardAroundHandler)
 
 	// Results endpoints
-	api.Get("/results", handlers.GetAllResultsHandler)
+	resultsLookupLimiter := middleware.RateLimit(middleware.RateLimitFromEnv("results-lookup-ip", "RATE_LIMIT_RESULTS_LOOKUP_IP", "10/1m", middleware.KeyByIP))
+	api.Get("/results", adminKey, handlers.GetAllResultsHandler)
+	api.Post("/results/lookup", resultsLookupLimiter, handlers.LookupResultHandler)
+	api.Get("/results/scorecard", resultsLookupLimiter, handlers.GetScorecardPDFHandler)
+	api.Get("/results/export", statsKey, handlers.ExportResultsHandler)
+
+	// Localized quiz UI labels
+	api.Get("/i18n/strings", handlers.GetUIStringsHandler)
 
-	// Comprehensive stats endpoint (combines all 6 statistics)
-	stats := api.Group("/stats")
+	// Self-registration with email verification
+	registerLimiter := middleware.RateLimit(middleware.RateLimitFromEnv("register-ip", "RATE_LIMIT_REGISTER_IP", "20/1m", middleware.KeyByIP))
+	api.Get("/register/status", handlers.GetRegistrationStatusHandler)
+	api.Post("/register", registerLimiter, handlers.RegisterHandler)
+	api.Post("/register/verify", registerLimiter, handlers.VerifyRegistrationHandler)
+
+	// Participant email change, confirmed from the new address
+	emailChangeLimiter := middleware.RateLimit(middleware.RateLimitFromEnv("email-change-ip", "RATE_LIMIT_EMAIL_CHANGE_IP", "10/1m", middleware.KeyByIP))
+	api.Post("/participants/change-email", emailChangeLimiter, handlers.ChangeEmailHandler)
+	api.Post("/participants/change-email/confirm", emailChangeLimiter, handlers.ConfirmEmailChangeHandler)
+
+	// Archived events, kept across database resets
+	archives := api.Group("/archives", statsKey)
+	archives.Get("/", handlers.ListArchivesHandler)
+	archives.Get("/:id", handlers.GetArchiveHandler)
+	archives.Get("/:id/results", handlers.GetArchiveResultsHandler)
+	archives.Get("/:id/sections/:section_id", handlers.GetArchiveSectionHandler)
+
+	// Question analytics (item analysis)
+	analytics := api.Group("/analytics", statsKey)
+	analytics.Get("/questions", handlers.GetQuestionAnalyticsHandler)
+
+	// Comprehensive stats endpoint (overall, sections, attendees and funnel; ?include= selects)
+	// and read-only GraphQL reporting
+	stats := api.Group("/stats", statsKey)
 	stats.Get("/comprehensive", handlers.GetComprehensiveStatsHandler)
+	stats.Post("/graphql", handlers.GraphQLHandler)
 
 	// Load test endpoints (isolated)
-	loadTest := api.Group("/load-test")
+	loadTest := api.Group("/load-test", adminKey)
 	loadTest.Post("/individual", handlers.LoadTestIndividualHandler)
 	loadTest.Post("/batch", handlers.LoadTestBatchHandler)
 	loadTest.Get("/metrics/individual", handlers.GetIndividualMetricsHandler)
@@ -124,33 +493,83 @@ func main() {
 	loadTest.Delete("/cleanup", handlers.CleanupLoadTestDataHandler)
 	loadTest.Post("/results/save", handlers.SaveTestResultsHandler)
 	loadTest.Get("/results", handlers.GetAllTestResultsHandler)
+	loadTest.Post("/run-scenario", handlers.RunScenarioHandler)
 
 	// Serve static files
 	app.Static("/", "./public")
 
-	// Health check
+	// Prometheus metrics
+	app.Get("/metrics", metrics.Handler())
+
+	// Health checks: /health stays a plain "OK" for existing monitors;
+	// load balancers should use /health/live and /health/ready
 	app.Get("/health", func(c *fiber.Ctx) error {
 		return c.SendString("OK")
 	})
+	app.Get("/health/live", handlers.LivenessHandler)
+	app.Get("/health/ready", handlers.ReadinessHandler)
+
+	// Graceful shutdown: fail readiness and keep serving for SHUTDOWN_DRAIN_DELAY while the load
+	// balancer stops routing here, then stop background jobs at their next safe point, drain
+	// in-flight requests and wait for jobs to save their progress before exiting
+	drainDelay := time.Duration(0)
+	if value := os.Getenv("SHUTDOWN_DRAIN_DELAY"); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil && parsed >= 0 {
+			drainDelay = parsed
+		} else {
+			log.Warn().Msgf("Invalid SHUTDOWN_DRAIN_DELAY=%q, using %s", value, drainDelay)
+		}
+	}
+	shutdownTimeout := 30 * time.Second
+	if value := os.Getenv("SHUTDOWN_TIMEOUT"); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			shutdownTimeout = parsed
+		} else {
+			log.Warn().Msgf("Invalid SHUTDOWN_TIMEOUT=%q, using %s", value, shutdownTimeout)
+		}
+	}
 
-	// Graceful shutdown
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	shutdownDone := make(chan struct{})
 
 	go func() {
 		<-c
-		log.Println("Shutting down server...")
-		app.Shutdown()
+		instance.SetPhase(instance.PhaseDraining)
+		if drainDelay > 0 {
+			log.Info().Dur("delay", drainDelay).Msg("Draining: readiness failing, still serving until the delay ends (signal again to skip)")
+			select {
+			case <-time.After(drainDelay):
+			case <-c:
+			}
+		}
+		log.Info().Msg("Shutting down server...")
+
+		jobsDone := make(chan bool, 1)
+		go func() {
+			jobsDone <- jobs.Shutdown(shutdownTimeout)
+		}()
+
+		if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+			log.Error().Err(err).Msg("Server shutdown error")
+		}
+
+		if <-jobsDone {
+			log.Info().Msg("Background jobs stopped")
+		} else {
+			log.Warn().Msgf("Background jobs still running after %s, exiting anyway", shutdownTimeout)
+		}
+		close(shutdownDone)
 	}()
 
 	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+	port := cfg.Port
 
-	log.Printf("Server starting on port %s", port)
+	log.Info().Str("port", port).Str("phase", instance.Phase()).Msg("Server starting")
 	if err := app.Listen(":" + port); err != nil {
-		log.Fatalf("Server failed: %v", err)
+		log.Fatal().Err(err).Msg("Server failed")
 	}
+
+	<-shutdownDone
+	log.Info().Msg("Server stopped")
 }
@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "mcq_exam"
+
+var (
+	// HTTPRequestsTotal counts handled requests per route and status code
+	HTTPRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration observes request latency per route
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route.",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"method", "route"})
+
+	// EmailsSentTotal counts email send attempts by result (success/failure)
+	EmailsSentTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_sent_total",
+		Help:      "Email send attempts by result.",
+	}, []string{"result"})
+
+	// EmailThrottleWaitSeconds observes how long sends waited for their provider's rate limit
+	EmailThrottleWaitSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "email_throttle_wait_seconds",
+		Help:      "Time email sends waited for the provider rate limit.",
+		Buckets:   []float64{0, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"provider"})
+
+	// SchedulerChecksTotal counts scheduler ticks that looked for due functions
+	SchedulerChecksTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scheduler_checks_total",
+		Help:      "Number of times the scheduler checked for due functions.",
+	})
+
+	// SchedulerExecutionsTotal counts scheduled function executions by function and result
+	SchedulerExecutionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scheduler_executions_total",
+		Help:      "Scheduled function executions by function name and result.",
+	}, []string{"function", "result"})
+
+	// SchedulerExecutionDuration observes how long each scheduled function ran
+	SchedulerExecutionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scheduler_execution_duration_seconds",
+		Help:      "Duration of scheduled function executions.",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 15, 30, 60, 120, 300, 600, 1800},
+	}, []string{"function"})
+
+	// SessionCacheLookupsTotal counts session token lookups by cache result (hit/miss)
+	SessionCacheLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "session_cache_lookups_total",
+		Help:      "Session token lookups by cache result.",
+	}, []string{"result"})
+
+	// CORSBlockedTotal counts requests from browser origins that are not allowed
+	CORSBlockedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cors_blocked_requests_total",
+		Help:      "Requests from browser origins that are not allowed.",
+	})
+
+	// InstanceInfo is 1, labelled with this process's instance ID, so series can be joined
+	// to the replica that produced them
+	InstanceInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "instance_info",
+		Help:      "Always 1; labelled with the instance ID of this process.",
+	}, []string{"instance_id"})
+
+	// InstanceReady is 1 while the instance accepts traffic (see GET /health/ready)
+	InstanceReady = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "instance_ready",
+		Help:      "1 while this instance is ready for traffic, 0 while starting or draining.",
+	})
+
+	// ErrorsReportedTotal counts panics and server errors passed to package errorreport
+	ErrorsReportedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_reported_total",
+		Help:      "Panics and server errors reported, by kind.",
+	}, []string{"kind"})
+)
+
+func init() {
+	prometheus.MustRegister(poolCollector{})
+}
+
+// RecordEmailSend increments the email counter for a send attempt
+func RecordEmailSend(err error) {
+	if err != nil {
+		EmailsSentTotal.WithLabelValues("failure").Inc()
+		return
+	}
+	EmailsSentTotal.WithLabelValues("success").Inc()
+}
+
+// RecordEmailThrottle observes the time a send waited for the provider's rate limit
+func RecordEmailThrottle(provider string, waited time.Duration) {
+	EmailThrottleWaitSeconds.WithLabelValues(provider).Observe(waited.Seconds())
+}
+
+// Handler returns the Fiber handler serving metrics in Prometheus text format
+func Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.Handler())
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"mcq-exam/db"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	poolAcquiredConns = prometheus.NewDesc(namespace+"_db_pool_acquired_connections",
+		"Connections currently in use.", nil, nil)
+	poolIdleConns = prometheus.NewDesc(namespace+"_db_pool_idle_connections",
+		"Idle connections in the pool.", nil, nil)
+	poolTotalConns = prometheus.NewDesc(namespace+"_db_pool_total_connections",
+		"Total connections (acquired + idle + constructing).", nil, nil)
+	poolMaxConns = prometheus.NewDesc(namespace+"_db_pool_max_connections",
+		"Maximum size of the pool.", nil, nil)
+	poolAcquireCount = prometheus.NewDesc(namespace+"_db_pool_acquires_total",
+		"Cumulative successful connection acquires.", nil, nil)
+	poolEmptyAcquireCount = prometheus.NewDesc(namespace+"_db_pool_empty_acquires_total",
+		"Cumulative acquires that had to wait because the pool was empty.", nil, nil)
+	poolCanceledAcquireCount = prometheus.NewDesc(namespace+"_db_pool_canceled_acquires_total",
+		"Cumulative acquires canceled by their context.", nil, nil)
+	poolAcquireWaitSeconds = prometheus.NewDesc(namespace+"_db_pool_acquire_wait_seconds_total",
+		"Cumulative time spent waiting to acquire a connection.", nil, nil)
+	slowQueries = prometheus.NewDesc(namespace+"_db_slow_queries_total",
+		"Statements that took at least DB_SLOW_QUERY_THRESHOLD, by statement kind.", []string{"statement"}, nil)
+	statementTimeouts = prometheus.NewDesc(namespace+"_db_statement_timeouts_total",
+		"Statements canceled by the server, mostly for DB_STATEMENT_TIMEOUT, by statement kind.", []string{"statement"}, nil)
+)
+
+// poolCollector exports pgxpool statistics and slow-query counts at scrape time
+type poolCollector struct{}
+
+func (poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredConns
+	ch <- poolIdleConns
+	ch <- poolTotalConns
+	ch <- poolMaxConns
+	ch <- poolAcquireCount
+	ch <- poolEmptyAcquireCount
+	ch <- poolCanceledAcquireCount
+	ch <- poolAcquireWaitSeconds
+	ch <- slowQueries
+	ch <- statementTimeouts
+}
+
+func (poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for kind, count := range db.SlowQueryCounts() {
+		ch <- prometheus.MustNewConstMetric(slowQueries, prometheus.CounterValue, float64(count), kind)
+	}
+	for kind, count := range db.TimeoutCounts() {
+		ch <- prometheus.MustNewConstMetric(statementTimeouts, prometheus.CounterValue, float64(count), kind)
+	}
+
+	if db.Pool == nil {
+		return
+	}
+
+	stat := db.Pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireCount, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquireCount, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolCanceledAcquireCount, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireWaitSeconds, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/apierror"
+	"mcq-exam/apikeys"
+	"mcq-exam/logging"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HeaderAPIKey carries an API key from machine-to-machine callers
+const HeaderAPIKey = "X-API-Key"
+
+// apiKeyLocal holds the authenticated key on the request context
+const apiKeyLocal = "api_key"
+
+// apiKeysRequired reports whether requests without an API key are turned away (API_KEYS_REQUIRED, default false)
+func apiKeysRequired() bool {
+	required, _ := strconv.ParseBool(os.Getenv("API_KEYS_REQUIRED"))
+	return required
+}
+
+// APIKey returns the key that authenticated the request, or nil
+func APIKey(c *fiber.Ctx) *apikeys.Key {
+	key, _ := c.Locals(apiKeyLocal).(*apikeys.Key)
+	return key
+}
+
+// KeyAllows reports whether the request was authenticated with a key of scope or above.
+// Unlike RequireAPIKey it is false without a key, even when keys are not required.
+func KeyAllows(c *fiber.Ctx, scope string) bool {
+	key := APIKey(c)
+	return key != nil && key.Allows(scope)
+}
+
+// RequireAPIKey checks the X-API-Key header against scope, applies the key's per-minute
+// rate limit and records the request in the key's usage. Requests without a key pass
+// unless API_KEYS_REQUIRED is set; a key that is present must always be valid.
+func RequireAPIKey(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		raw := c.Get(HeaderAPIKey)
+		if raw == "" {
+			if !apiKeysRequired() {
+				return c.Next()
+			}
+			return apierror.Send(c, fiber.StatusUnauthorized, "API key required. Send it in the "+HeaderAPIKey+" header")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		key, err := apikeys.Authenticate(ctx, raw)
+		if errors.Is(err, apikeys.ErrInvalidKey) || errors.Is(err, apikeys.ErrRevoked) || errors.Is(err, apikeys.ErrExpired) {
+			return apierror.SendCode(c, fiber.StatusUnauthorized, apierror.CodeAPIKeyInvalid, "Invalid API key")
+		}
+		if err != nil {
+			logging.Ctx(c).Error().Err(err).Msg("Failed to validate API key")
+			return apierror.Send(c, fiber.StatusInternalServerError, "Failed to validate API key")
+		}
+		logging.SetAPIKey(c, key.ID)
+
+		if !key.Allows(scope) {
+			return apierror.Send(c, fiber.StatusForbidden, "API key scope "+key.Scope+" cannot access this endpoint (requires "+scope+")")
+		}
+
+		if rateLimitStore != nil {
+			count, err := rateLimitStore.Hit(ctx, "api-key:"+strconv.Itoa(key.ID), time.Minute)
+			if err != nil {
+				logging.Ctx(c).Warn().Err(err).Msg("Rate limiter store error")
+			} else if count > float64(key.RateLimitPerMinute) {
+				c.Set(fiber.HeaderRetryAfter, "60")
+				return apierror.Send(c, fiber.StatusTooManyRequests, "API key rate limit of "+strconv.Itoa(key.RateLimitPerMinute)+" requests per minute exceeded")
+			}
+		}
+
+		c.Locals(apiKeyLocal, key)
+		err = c.Next()
+
+		failed := err != nil || c.Response().StatusCode() >= fiber.StatusBadRequest
+		usageCtx, usageCancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer usageCancel()
+		if usageErr := apikeys.RecordUsage(usageCtx, key.ID, c.Method(), c.Route().Path, failed); usageErr != nil {
+			logging.Ctx(c).Warn().Err(usageErr).Msg("Failed to record API key usage")
+		}
+		return err
+	}
+}
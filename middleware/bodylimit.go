@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"io"
+	"mcq-exam/apierror"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BodyLimit caps request bodies at limit bytes when the server streams them
+// (fiber.Config.StreamRequestBody), so a route given a larger limit with LargeBody does not
+// raise it for every other route. largePaths are left for LargeBody. A body within the limit
+// is read in full, so handlers see it as they would without streaming.
+func BodyLimit(limit int, largePaths ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if slices.Contains(largePaths, c.Path()) {
+			return c.Next()
+		}
+		return readBody(c, limit)
+	}
+}
+
+// LargeBody reads the body of a route left out of BodyLimit, up to limit bytes. Mount it after
+// the route's API key check, so only authorized callers can send that much.
+func LargeBody(limit int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return readBody(c, limit)
+	}
+}
+
+// readBody reads a streamed body of at most limit bytes into the request
+func readBody(c *fiber.Ctx, limit int) error {
+	if !c.Request().IsBodyStream() {
+		return c.Next()
+	}
+	if c.Request().Header.ContentLength() > limit {
+		return tooLarge(c)
+	}
+	// Chunked bodies have no length up front, so they are cut off one byte past the limit
+	body, err := io.ReadAll(io.LimitReader(c.Context().RequestBodyStream(), int64(limit)+1))
+	if err != nil {
+		return apierror.Send(c, fiber.StatusBadRequest, "Failed to read request body")
+	}
+	if len(body) > limit {
+		return tooLarge(c)
+	}
+	c.Request().SetBody(body)
+	return c.Next()
+}
+
+// tooLarge refuses a body over the limit. The rest of it is never read, so the connection is
+// closed rather than read on as the next request.
+func tooLarge(c *fiber.Ctx) error {
+	c.Context().SetConnectionClose()
+	return apierror.Send(c, fiber.StatusRequestEntityTooLarge, "Request Entity Too Large")
+}
@@ -1,20 +1,53 @@
 package middleware
 
 import (
-	"net/http"
+	"mcq-exam/apierror"
+	"mcq-exam/config"
+	"mcq-exam/logging"
+	"mcq-exam/metrics"
+	"mcq-exam/origins"
+	"strings"
 
-	"github.com/rs/cors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
 )
 
-// SetupCORS returns CORS middleware that allows all origins
-func SetupCORS() func(http.Handler) http.Handler {
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"*"},
-		AllowCredentials: false,
-		MaxAge:           300, // 5 minutes preflight cache
+// CORS answers preflight requests and sets the CORS headers for allowed origins only (see
+// package origins). Browsers on any other origin cannot read the responses.
+func CORS() fiber.Handler {
+	return cors.New(cors.Config{
+		AllowOriginsFunc: origins.Allowed,
+		AllowMethods:     "GET,POST,PUT,PATCH,DELETE,OPTIONS",
+		AllowHeaders:     "*",
+		ExposeHeaders:    logging.HeaderRequestID,
+		MaxAge:           300,
 	})
+}
+
+// sameOrigin reports whether origin is this API's own, as for the pages under public/
+func sameOrigin(c *fiber.Ctx, origin string) bool {
+	if origin == c.Protocol()+"://"+c.Hostname() {
+		return true
+	}
+	base := config.Get().BaseURL
+	return base != "" && strings.EqualFold(origin, base)
+}
+
+// TrustedOrigins records requests whose Origin header is neither allowed nor the API's own,
+// and rejects them with 403 when CORS_ENFORCE is on. Requests without an Origin header, such
+// as server-to-server calls, provider webhooks and link clicks, always pass.
+func TrustedOrigins() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		origin := c.Get(fiber.HeaderOrigin)
+		if origin == "" || sameOrigin(c, origin) || origins.Allowed(origin) {
+			return c.Next()
+		}
 
-	return c.Handler
+		origins.RecordBlocked(origin, c.Path(), c.IP())
+		metrics.CORSBlockedTotal.Inc()
+		if !origins.Enforce() {
+			return c.Next()
+		}
+		return apierror.Send(c, fiber.StatusForbidden, "Origin not allowed")
+	}
 }
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"mcq-exam/instance"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CloseWhenDraining asks clients to close keep-alive connections once the instance is
+// draining, so their next request opens a connection to a replica that is still serving
+func CloseWhenDraining() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if instance.Draining() {
+			c.Set(fiber.HeaderConnection, "close")
+		}
+		return c.Next()
+	}
+}
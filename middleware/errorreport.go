@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mcq-exam/apierror"
+	"mcq-exam/errorreport"
+	"mcq-exam/logging"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ReportErrors passes server errors (5xx) to package errorreport, with the message of the
+// error response as what went wrong. 503 is left out: the server sends it on purpose while
+// starting, draining or shedding load. It runs inside the recover middleware, so a panic is
+// reported once, by ReportPanic.
+func ReportErrors() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		var fiberErr *fiber.Error
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			if errors.As(err, &fiberErr) {
+				status = fiberErr.Code
+			}
+		}
+		if status < fiber.StatusInternalServerError || status == fiber.StatusServiceUnavailable {
+			return err
+		}
+
+		var message string
+		if err != nil {
+			message = err.Error()
+		} else {
+			var body apierror.Response
+			if json.Unmarshal(c.Response().Body(), &body) == nil {
+				message = body.Message
+			}
+		}
+		if message == "" {
+			message = http.StatusText(status)
+		}
+		errorreport.Report(requestEvent(c, errorreport.KindError, message, status))
+		return err
+	}
+}
+
+// ReportPanic is the recover middleware's stack trace handler: it logs the panic and
+// reports it with the stack
+func ReportPanic(c *fiber.Ctx, recovered any, stack []byte) {
+	logging.Ctx(c).Error().Interface("panic", recovered).Bytes("stack", stack).Msg("Recovered from panic")
+
+	event := requestEvent(c, errorreport.KindPanic, fmt.Sprint(recovered), fiber.StatusInternalServerError)
+	event.Stack = string(stack)
+	errorreport.Report(event)
+}
+
+func requestEvent(c *fiber.Ctx, kind, message string, status int) errorreport.Event {
+	return errorreport.Event{
+		Kind:      kind,
+		Message:   message,
+		Method:    c.Method(),
+		Route:     c.Route().Path,
+		Path:      c.Path(),
+		Status:    status,
+		RequestID: logging.RequestID(c),
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+}
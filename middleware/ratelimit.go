@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"mcq-exam/apierror"
+	"mcq-exam/db"
+	"mcq-exam/logging"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// RateLimitConfig configures a single rate limiter
+type RateLimitConfig struct {
+	// Name namespaces the counters (e.g. "live-ip") so limiters never share keys
+	Name string
+	// Max requests allowed per sliding Window
+	Max    int
+	Window time.Duration
+	// KeyFunc identifies the caller; returning "" skips limiting for the request
+	KeyFunc func(c *fiber.Ctx) string
+}
+
+var rateLimitStore RateLimitStore
+
+// InitRateLimitStore selects the Redis store when Redis is configured, otherwise in-memory
+func InitRateLimitStore() {
+	if db.Redis != nil {
+		rateLimitStore = NewRedisRateLimitStore(db.Redis)
+		log.Info().Msg("Rate limiter using Redis store")
+		return
+	}
+	rateLimitStore = NewMemoryRateLimitStore()
+	log.Info().Msg("Rate limiter using in-memory store")
+}
+
+// RateLimitFromEnv builds a config from an env var formatted as "<max>/<window>" (e.g. "20/1m").
+// Setting the variable to "off" or "0" disables the limiter.
+func RateLimitFromEnv(name, envVar, defaultValue string, keyFunc func(c *fiber.Ctx) string) RateLimitConfig {
+	value := strings.TrimSpace(os.Getenv(envVar))
+	if value == "" {
+		value = defaultValue
+	}
+
+	cfg := RateLimitConfig{Name: name, KeyFunc: keyFunc}
+	if value == "off" || value == "0" {
+		return cfg
+	}
+
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		log.Warn().Msgf("Invalid %s=%q (expected <max>/<window>), using %s", envVar, value, defaultValue)
+		parts = strings.SplitN(defaultValue, "/", 2)
+	}
+
+	max, err := strconv.Atoi(parts[0])
+	window, werr := time.ParseDuration(parts[1])
+	if err != nil || werr != nil || max < 0 || window <= 0 {
+		log.Warn().Msgf("Invalid %s=%q, using %s", envVar, value, defaultValue)
+		parts = strings.SplitN(defaultValue, "/", 2)
+		max, _ = strconv.Atoi(parts[0])
+		window, _ = time.ParseDuration(parts[1])
+	}
+
+	cfg.Max = max
+	cfg.Window = window
+	return cfg
+}
+
+// RateLimit returns middleware enforcing cfg. Requests over the limit get 429 with Retry-After.
+// If the store is unavailable the request is allowed through (fail open) and the error logged.
+func RateLimit(cfg RateLimitConfig) fiber.Handler {
+	if cfg.Max <= 0 || cfg.Window <= 0 {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+
+	log.Info().Str("limiter", cfg.Name).Int("max", cfg.Max).Dur("window", cfg.Window).Msg("Rate limit configured")
+
+	return func(c *fiber.Ctx) error {
+		if rateLimitStore == nil {
+			return c.Next()
+		}
+
+		key := cfg.KeyFunc(c)
+		if key == "" {
+			return c.Next()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		count, err := rateLimitStore.Hit(ctx, cfg.Name+":"+key, cfg.Window)
+		if err != nil {
+			logging.Ctx(c).Warn().Err(err).Str("limiter", cfg.Name).Msg("Rate limiter store error")
+			return c.Next()
+		}
+
+		remaining := cfg.Max - int(math.Ceil(count))
+		if remaining < 0 {
+			remaining = 0
+		}
+		c.Set("X-RateLimit-Limit", strconv.Itoa(cfg.Max))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+
+		if count > float64(cfg.Max) {
+			// The estimate only drops once the current fixed window rolls over
+			now := time.Now().UnixNano()
+			untilReset := time.Duration(int64(cfg.Window) - now%int64(cfg.Window))
+			retryAfter := int(math.Ceil(untilReset.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+			return apierror.Respond(c, fiber.StatusTooManyRequests, apierror.CodeRateLimited,
+				"Too many requests. Please try again in "+strconv.Itoa(retryAfter)+" seconds", fiber.Map{"retry_after": retryAfter})
+		}
+
+		return c.Next()
+	}
+}
+
+// KeyByIP identifies callers by client IP
+func KeyByIP(c *fiber.Ctx) string {
+	return c.IP()
+}
+
+// KeyByToken identifies callers by the credential they present: the otp, token or
+// session_token field of a JSON body, a Bearer Authorization header, or a token query param.
+// The value is hashed so raw credentials never end up in the rate limit store.
+func KeyByToken(c *fiber.Ctx) string {
+	var token string
+
+	if authHeader := c.Get("Authorization"); authHeader != "" {
+		token = strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer"))
+	}
+
+	if token == "" && len(c.Body()) > 0 {
+		var body struct {
+			OTP          string `json:"otp"`
+			Token        string `json:"token"`
+			SessionToken string `json:"session_token"`
+		}
+		if err := json.Unmarshal(c.Body(), &body); err == nil {
+			switch {
+			case body.OTP != "":
+				token = body.OTP
+			case body.Token != "":
+				token = body.Token
+			case body.SessionToken != "":
+				token = body.SessionToken
+			}
+		}
+	}
+
+	if token == "" {
+		token = c.Query("token")
+	}
+
+	if token == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return hex.EncodeToString(sum[:16])
+}
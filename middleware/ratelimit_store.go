@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitStore counts hits per key using a sliding window counter:
+// the estimate is the current fixed window's count plus the previous window's
+// count weighted by how much of it still overlaps the sliding window.
+type RateLimitStore interface {
+	// Hit records one request for key and returns the estimated number of
+	// requests in the sliding window ending now (including this one)
+	Hit(ctx context.Context, key string, window time.Duration) (float64, error)
+}
+
+// slidingEstimate combines previous and current window counts into a sliding window estimate
+func slidingEstimate(prev, curr int64, now time.Time, window time.Duration) float64 {
+	elapsed := now.UnixNano() % int64(window)
+	weight := 1 - float64(elapsed)/float64(window)
+	return float64(prev)*weight + float64(curr)
+}
+
+// ============================================
+// IN-MEMORY STORE
+// ============================================
+
+type memoryCounter struct {
+	window      int64
+	windowStart int64
+	prev        int64
+	curr        int64
+}
+
+// MemoryRateLimitStore keeps counters in process memory (single instance deployments)
+type MemoryRateLimitStore struct {
+	mu       sync.Mutex
+	counters map[string]*memoryCounter
+}
+
+// NewMemoryRateLimitStore creates an in-memory store and starts its cleanup loop
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{counters: make(map[string]*memoryCounter)}
+	go s.cleanup()
+	return s
+}
+
+func (s *MemoryRateLimitStore) Hit(_ context.Context, key string, window time.Duration) (float64, error) {
+	now := time.Now()
+	windowStart := now.UnixNano() - now.UnixNano()%int64(window)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, exists := s.counters[key]
+	if !exists {
+		counter = &memoryCounter{window: int64(window), windowStart: windowStart}
+		s.counters[key] = counter
+	}
+
+	switch {
+	case counter.windowStart == windowStart:
+		// Same window, nothing to roll
+	case counter.windowStart == windowStart-int64(window):
+		// Moved into the next window
+		counter.prev = counter.curr
+		counter.curr = 0
+		counter.windowStart = windowStart
+	default:
+		// More than one full window has passed
+		counter.prev = 0
+		counter.curr = 0
+		counter.windowStart = windowStart
+	}
+
+	counter.curr++
+	return slidingEstimate(counter.prev, counter.curr, now, window), nil
+}
+
+// cleanup periodically removes counters that have not been hit recently
+func (s *MemoryRateLimitStore) cleanup() {
+	ticker := time.NewTicker(1 * time.Minute)
+	for range ticker.C {
+		now := time.Now().UnixNano()
+
+		s.mu.Lock()
+		for key, counter := range s.counters {
+			// Counters older than two windows no longer affect the estimate
+			if counter.windowStart < now-2*counter.window {
+				delete(s.counters, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// ============================================
+// REDIS STORE
+// ============================================
+
+// RedisRateLimitStore shares counters across instances through Redis
+type RedisRateLimitStore struct {
+	client *redis.Client
+}
+
+// NewRedisRateLimitStore creates a Redis-backed store
+func NewRedisRateLimitStore(client *redis.Client) *RedisRateLimitStore {
+	return &RedisRateLimitStore{client: client}
+}
+
+func (s *RedisRateLimitStore) Hit(ctx context.Context, key string, window time.Duration) (float64, error) {
+	now := time.Now()
+	windowIndex := now.UnixNano() / int64(window)
+	currKey := fmt.Sprintf("ratelimit:%s:%d", key, windowIndex)
+	prevKey := fmt.Sprintf("ratelimit:%s:%d", key, windowIndex-1)
+
+	pipe := s.client.Pipeline()
+	incr := pipe.Incr(ctx, currKey)
+	pipe.Expire(ctx, currKey, 2*window)
+	prevCmd := pipe.Get(ctx, prevKey)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	var prev int64
+	if v, err := prevCmd.Result(); err == nil {
+		prev, _ = strconv.ParseInt(v, 10, 64)
+	}
+
+	return slidingEstimate(prev, incr.Val(), now, window), nil
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"mcq-exam/logging"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+)
+
+// requestIDPattern limits client-supplied IDs to something safe to log and echo back
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestLogger assigns every request an ID (reusing the client's X-Request-ID when it
+// looks sane), echoes it in the response, attaches a request logger for handlers and
+// writes one structured access log line per request. It replaces Fiber's text logger.
+func RequestLogger() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		requestID := c.Get(logging.HeaderRequestID)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		c.Set(logging.HeaderRequestID, requestID)
+		logging.Attach(c, requestID)
+
+		// Let the app's error handler write the response now so the logged status is final
+		if chainErr := c.Next(); chainErr != nil {
+			if err := c.App().ErrorHandler(c, chainErr); err != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		status := c.Response().StatusCode()
+		var event *zerolog.Event
+		logger := logging.Ctx(c)
+		switch {
+		case status >= fiber.StatusInternalServerError:
+			event = logger.Error()
+		case status >= fiber.StatusBadRequest:
+			event = logger.Warn()
+		case strings.HasPrefix(c.Path(), "/health") || c.Path() == "/metrics":
+			event = logger.Debug()
+		default:
+			event = logger.Info()
+		}
+
+		event.
+			Str("method", c.Method()).
+			Str("path", c.Path()).
+			Int("status", status).
+			Dur("latency", time.Since(start)).
+			Str("ip", c.IP()).
+			Msg("request")
+		return nil
+	}
+}
+
+func newRequestID() string {
+	bytes := make([]byte, 12)
+	_, _ = rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
@@ -2,68 +2,47 @@ package middleware
 
 import (
 	"context"
-	"mcq-exam/db"
+	"mcq-exam/apierror"
+	"mcq-exam/logging"
+	"mcq-exam/sessioncache"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-type SessionMiddlewareResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-}
-
 // ValidateSessionToken middleware validates the session token from Authorization header
 func ValidateSessionToken(c *fiber.Ctx) error {
 	// Get Authorization header
 	authHeader := c.Get("Authorization")
 	if authHeader == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(SessionMiddlewareResponse{
-			Success: false,
-			Message: "Authorization header required",
-		})
+		return apierror.Send(c, fiber.StatusUnauthorized, "Authorization header required")
 	}
 
 	// Extract token from "Bearer <token>" format
 	token := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer"))
 	if token == "" || token == authHeader {
-		return c.Status(fiber.StatusUnauthorized).JSON(SessionMiddlewareResponse{
-			Success: false,
-			Message: "Invalid authorization format. Use: Bearer <token>",
-		})
+		return apierror.Send(c, fiber.StatusUnauthorized, "Invalid authorization format. Use: Bearer <token>")
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Validate token exists in sessions table
-	var studentID int
-	var completed bool
-	query := `
-		SELECT student_id, completed
-		FROM sessions
-		WHERE session_token = $1
-	`
-	err := db.Pool.QueryRow(ctx, query, token).Scan(&studentID, &completed)
-	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(SessionMiddlewareResponse{
-			Success: false,
-			Message: "Invalid or expired session token",
-		})
+	// Validate token against the session cache, falling back to the sessions table
+	session, err := sessioncache.Lookup(ctx, token)
+	if err == sessioncache.ErrCompleted {
+		return apierror.SendCode(c, fiber.StatusForbidden, apierror.CodeTestCompleted, "Test already completed")
 	}
-
-	// Check if test already completed
-	if completed {
-		return c.Status(fiber.StatusForbidden).JSON(SessionMiddlewareResponse{
-			Success: false,
-			Message: "Test already completed",
-		})
+	if err != nil {
+		return apierror.SendCode(c, fiber.StatusUnauthorized, apierror.CodeSessionInvalid, "Invalid or expired session token")
 	}
 
-	// Store student_id and session_token in context for use in handlers
-	c.Locals("student_id", studentID)
+	// Store student_id, session_id and session_token in context for use in handlers
+	c.Locals("student_id", session.StudentID)
+	c.Locals("session_id", session.ID)
 	c.Locals("session_token", token)
+	logging.SetStudent(c, session.StudentID)
+	logging.SetSession(c, session.ID)
 
 	return c.Next()
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mcq-exam/db"
+	"os"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = `Usage: %s migrate <command>
+
+Commands:
+  status         show the applied version, dirty flag and every migration
+  up [N]         apply the next N pending migrations (all when N is omitted)
+  down N         roll back the last N migrations (never the initial schema)
+  force VERSION  record VERSION as applied and clear the dirty flag, without
+                 running anything; use after fixing a failed migration by hand
+`
+
+// runMigrateCommand handles "<binary> migrate ..." and returns the process exit code.
+// It needs only DATABASE_URL and the migrations directory, so it works while the server
+// cannot start because of a failed migration.
+func runMigrateCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, migrateUsage, os.Args[0])
+		return 2
+	}
+
+	var err error
+	switch command := args[0]; {
+	case command == "status" && len(args) == 1:
+		err = printMigrationStatus()
+	case command == "up" && len(args) <= 2:
+		steps := 0
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, "N must be a positive number")
+				return 2
+			}
+		}
+		if err = db.MigrateUp(steps); err == nil {
+			err = printMigrationStatus()
+		}
+	case command == "down" && len(args) == 2:
+		steps, convErr := strconv.Atoi(args[1])
+		if convErr != nil || steps < 1 {
+			fmt.Fprintln(os.Stderr, "N must be a positive number")
+			return 2
+		}
+		if err = db.MigrateDown(context.Background(), steps); err == nil {
+			err = printMigrationStatus()
+		}
+	case command == "force" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil || version < 0 {
+			fmt.Fprintln(os.Stderr, "VERSION must be a migration number")
+			return 2
+		}
+		if err = db.ForceVersion(version); err == nil {
+			err = printMigrationStatus()
+		}
+	default:
+		fmt.Fprintf(os.Stderr, migrateUsage, os.Args[0])
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func printMigrationStatus() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	state, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	files, err := db.MigrationFiles()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("version: %d (latest %d)\n", state.Version, state.Latest)
+	fmt.Printf("dirty:   %t\n\n", state.Dirty)
+	for _, file := range files {
+		status := "applied"
+		switch {
+		case file.Version > state.Version:
+			status = "pending"
+		case file.Version == state.Version && state.Dirty:
+			status = "DIRTY"
+		}
+		fmt.Printf("  %06d  %-8s %s\n", file.Version, status, file.Name)
+	}
+	return nil
+}
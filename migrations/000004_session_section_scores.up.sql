@@ -0,0 +1,16 @@
+-- Per-section score and time, persisted when a session is finalized
+CREATE TABLE IF NOT EXISTS session_section_scores (
+    id SERIAL PRIMARY KEY,
+    session_id INT REFERENCES sessions(id) ON DELETE CASCADE,
+    student_id INT REFERENCES students(id) ON DELETE CASCADE,
+    section_id INT NOT NULL,
+    score INT NOT NULL DEFAULT 0,
+    time_taken_seconds INT NOT NULL DEFAULT 0,
+    questions_answered INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    CONSTRAINT unique_session_section UNIQUE (session_id, section_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_section_scores_rank ON session_section_scores(section_id, score DESC, time_taken_seconds ASC) WHERE questions_answered > 0;
+CREATE INDEX IF NOT EXISTS idx_session_section_scores_student_id ON session_section_scores(student_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_sessions_completed_at;
+DROP INDEX IF EXISTS idx_answers_submitted_at;
+ALTER TABLE answers DROP COLUMN IF EXISTS submitted_at;
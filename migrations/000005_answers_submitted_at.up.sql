@@ -0,0 +1,7 @@
+-- Record when each answer was submitted (used by the admin dashboard time series).
+-- Existing rows stay NULL since their submission time is unknown.
+ALTER TABLE answers ADD COLUMN IF NOT EXISTS submitted_at TIMESTAMPTZ;
+ALTER TABLE answers ALTER COLUMN submitted_at SET DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS idx_answers_submitted_at ON answers(submitted_at);
+CREATE INDEX IF NOT EXISTS idx_sessions_completed_at ON sessions(completed_at) WHERE completed = true;
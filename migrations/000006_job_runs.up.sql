@@ -0,0 +1,17 @@
+-- Progress of bulk email jobs, so a run cut short by a shutdown can resume on restart
+CREATE TABLE IF NOT EXISTS job_runs (
+    id SERIAL PRIMARY KEY,
+    function_name VARCHAR(100) NOT NULL,
+    schedule_id INT REFERENCES event_schedule(id) ON DELETE CASCADE,
+    slot VARCHAR(20),
+    status VARCHAR(20) NOT NULL DEFAULT 'running',
+    total INT NOT NULL DEFAULT 0,
+    processed INT NOT NULL DEFAULT 0,
+    sent INT NOT NULL DEFAULT 0,
+    last_student_id INT NOT NULL DEFAULT 0,
+    started_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_runs_resumable ON job_runs(schedule_id, slot) WHERE status IN ('running', 'interrupted');
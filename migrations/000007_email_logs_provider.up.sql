@@ -0,0 +1,6 @@
+-- Which provider sent each email and its raw response (normalized fields stay in
+-- request_id / response_code / response_message)
+ALTER TABLE email_logs ADD COLUMN IF NOT EXISTS provider VARCHAR(50);
+ALTER TABLE email_logs ADD COLUMN IF NOT EXISTS provider_response JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_email_logs_request_id ON email_logs(request_id);
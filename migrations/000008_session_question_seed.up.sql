@@ -0,0 +1,2 @@
+-- Seed for the session's shuffled/sampled question set, assigned on first GET /api/live/questions
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS question_seed BIGINT;
@@ -0,0 +1 @@
+ALTER TABLE answers DROP CONSTRAINT IF EXISTS unique_session_question;
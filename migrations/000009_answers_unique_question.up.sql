@@ -0,0 +1,10 @@
+-- One answer per question per session. Keep the earliest row of any existing duplicates
+-- (the old SELECT-then-INSERT flow could race) before adding the constraint.
+DELETE FROM answers a
+USING answers b
+WHERE a.session_id = b.session_id
+  AND a.question_id = b.question_id
+  AND a.id > b.id;
+
+ALTER TABLE answers DROP CONSTRAINT IF EXISTS unique_session_question;
+ALTER TABLE answers ADD CONSTRAINT unique_session_question UNIQUE (session_id, question_id);
@@ -0,0 +1,23 @@
+-- Tracked links in outgoing emails; cid is the opaque id used by /api/track-click
+CREATE TABLE IF NOT EXISTS email_links (
+    id SERIAL PRIMARY KEY,
+    cid VARCHAR(64) NOT NULL UNIQUE,
+    student_id INT REFERENCES students(id) ON DELETE CASCADE,
+    email_type VARCHAR(50) NOT NULL,
+    url TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Per-student email events: sent, open, click
+CREATE TABLE IF NOT EXISTS email_events (
+    id SERIAL PRIMARY KEY,
+    student_id INT REFERENCES students(id) ON DELETE CASCADE,
+    email_type VARCHAR(50) NOT NULL,
+    event_type VARCHAR(20) NOT NULL,
+    link_id INT REFERENCES email_links(id) ON DELETE SET NULL,
+    ip_address VARCHAR(64),
+    user_agent TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_events_type_student ON email_events(email_type, event_type, student_id);
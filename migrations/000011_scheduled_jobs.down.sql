@@ -0,0 +1,41 @@
+ALTER TABLE event_schedule ADD COLUMN IF NOT EXISTS first_function VARCHAR(100) NOT NULL DEFAULT 'Phase1FirstMailVerification';
+ALTER TABLE event_schedule ADD COLUMN IF NOT EXISTS first_executed BOOLEAN DEFAULT false;
+ALTER TABLE event_schedule ADD COLUMN IF NOT EXISTS first_executed_at TIMESTAMPTZ;
+ALTER TABLE event_schedule ADD COLUMN IF NOT EXISTS second_function VARCHAR(100) NOT NULL DEFAULT 'Phase2SecondMailSending';
+ALTER TABLE event_schedule ADD COLUMN IF NOT EXISTS second_executed BOOLEAN DEFAULT false;
+ALTER TABLE event_schedule ADD COLUMN IF NOT EXISTS second_executed_at TIMESTAMPTZ;
+
+UPDATE event_schedule es
+SET first_executed = true, first_executed_at = sj.last_run_at
+FROM scheduled_jobs sj
+WHERE (sj.payload->>'event_schedule_id')::int = es.id
+  AND sj.function_name = es.first_function
+  AND sj.status = 'completed';
+
+UPDATE event_schedule es
+SET second_executed = true, second_executed_at = sj.last_run_at
+FROM scheduled_jobs sj
+WHERE (sj.payload->>'event_schedule_id')::int = es.id
+  AND sj.function_name = es.second_function
+  AND sj.status = 'completed';
+
+CREATE INDEX IF NOT EXISTS idx_event_schedule_first_time ON event_schedule(first_scheduled_time) WHERE first_executed = false;
+CREATE INDEX IF NOT EXISTS idx_event_schedule_second_time ON event_schedule(second_scheduled_time) WHERE second_executed = false;
+
+ALTER TABLE job_runs ADD COLUMN IF NOT EXISTS schedule_id INT REFERENCES event_schedule(id) ON DELETE CASCADE;
+ALTER TABLE job_runs ADD COLUMN IF NOT EXISTS slot VARCHAR(20);
+
+UPDATE job_runs jr
+SET schedule_id = es.id,
+    slot = CASE WHEN jr.function_name = es.first_function THEN 'first' ELSE 'second' END
+FROM scheduled_jobs sj
+JOIN event_schedule es ON es.id = (sj.payload->>'event_schedule_id')::int
+WHERE jr.job_id = sj.id;
+
+DROP INDEX IF EXISTS idx_job_runs_job;
+ALTER TABLE job_runs DROP COLUMN IF EXISTS error;
+ALTER TABLE job_runs DROP COLUMN IF EXISTS job_id;
+
+CREATE INDEX IF NOT EXISTS idx_job_runs_resumable ON job_runs(schedule_id, slot) WHERE status IN ('running', 'interrupted');
+
+DROP TABLE IF EXISTS scheduled_jobs;
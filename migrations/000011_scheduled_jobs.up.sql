@@ -0,0 +1,62 @@
+-- Generic scheduled jobs: any registered function, run once at run_at or repeatedly on a
+-- cron expression, replacing the two fixed function slots of event_schedule
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    function_name VARCHAR(100) NOT NULL,
+    cron_expression VARCHAR(100),
+    run_at TIMESTAMPTZ,
+    payload JSONB NOT NULL DEFAULT '{}',
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    next_run_at TIMESTAMPTZ,
+    max_retries INT NOT NULL DEFAULT 3,
+    attempts INT NOT NULL DEFAULT 0,
+    last_run_at TIMESTAMPTZ,
+    last_error TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    CONSTRAINT scheduled_jobs_cron_or_run_at CHECK ((cron_expression IS NULL) <> (run_at IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_jobs_due ON scheduled_jobs(next_run_at) WHERE status = 'active';
+
+-- Existing event schedules become two one-shot jobs each
+INSERT INTO scheduled_jobs (name, function_name, run_at, payload, status, next_run_at, last_run_at)
+SELECT 'Event ' || id || ' first mail', first_function, first_scheduled_time,
+       jsonb_build_object('event_schedule_id', id),
+       CASE WHEN first_executed THEN 'completed' ELSE 'active' END,
+       CASE WHEN first_executed THEN NULL ELSE first_scheduled_time END,
+       first_executed_at
+FROM event_schedule;
+
+INSERT INTO scheduled_jobs (name, function_name, run_at, payload, status, next_run_at, last_run_at)
+SELECT 'Event ' || id || ' second mail', second_function, second_scheduled_time,
+       jsonb_build_object('event_schedule_id', id),
+       CASE WHEN second_executed THEN 'completed' ELSE 'active' END,
+       CASE WHEN second_executed THEN NULL ELSE second_scheduled_time END,
+       second_executed_at
+FROM event_schedule;
+
+-- Runs belong to a job instead of an event schedule slot
+ALTER TABLE job_runs ADD COLUMN IF NOT EXISTS job_id INT REFERENCES scheduled_jobs(id) ON DELETE CASCADE;
+ALTER TABLE job_runs ADD COLUMN IF NOT EXISTS error TEXT;
+
+UPDATE job_runs jr
+SET job_id = sj.id
+FROM scheduled_jobs sj
+WHERE (sj.payload->>'event_schedule_id')::int = jr.schedule_id
+  AND sj.function_name = jr.function_name;
+
+DROP INDEX IF EXISTS idx_job_runs_resumable;
+ALTER TABLE job_runs DROP COLUMN IF EXISTS schedule_id;
+ALTER TABLE job_runs DROP COLUMN IF EXISTS slot;
+
+CREATE INDEX IF NOT EXISTS idx_job_runs_job ON job_runs(job_id, id DESC);
+
+-- event_schedule keeps the event details (times, video URL); execution state lives in scheduled_jobs
+ALTER TABLE event_schedule DROP COLUMN IF EXISTS first_function;
+ALTER TABLE event_schedule DROP COLUMN IF EXISTS first_executed;
+ALTER TABLE event_schedule DROP COLUMN IF EXISTS first_executed_at;
+ALTER TABLE event_schedule DROP COLUMN IF EXISTS second_function;
+ALTER TABLE event_schedule DROP COLUMN IF EXISTS second_executed;
+ALTER TABLE event_schedule DROP COLUMN IF EXISTS second_executed_at;
@@ -0,0 +1,2 @@
+ALTER TABLE sessions DROP CONSTRAINT IF EXISTS unique_student_attempt;
+ALTER TABLE sessions DROP COLUMN IF EXISTS attempt_number;
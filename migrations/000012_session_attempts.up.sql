@@ -0,0 +1,14 @@
+-- Students may take the exam more than once (MAX_ATTEMPTS); sessions are numbered per student
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS attempt_number INT NOT NULL DEFAULT 1;
+
+UPDATE sessions s
+SET attempt_number = numbered.n
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY student_id ORDER BY id) AS n
+    FROM sessions
+) numbered
+WHERE s.id = numbered.id
+  AND numbered.n <> 1;
+
+-- Also stops two concurrent OTP verifications creating the same attempt
+ALTER TABLE sessions ADD CONSTRAINT unique_student_attempt UNIQUE (student_id, attempt_number);
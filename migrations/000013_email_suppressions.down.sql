@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS suppressed_emails;
+DROP TABLE IF EXISTS email_bounces;
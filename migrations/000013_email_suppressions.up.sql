@@ -0,0 +1,21 @@
+-- Every bounce reported by the provider, classified hard or soft
+CREATE TABLE IF NOT EXISTS email_bounces (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    bounce_type VARCHAR(10) NOT NULL,
+    request_id VARCHAR(255),
+    reason TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_bounces_email ON email_bounces(email, bounce_type);
+
+-- Addresses every send path skips. Emails are stored lowercased.
+-- reason: hard_bounce, soft_bounce (repeated soft bounces) or manual
+CREATE TABLE IF NOT EXISTS suppressed_emails (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    reason VARCHAR(20) NOT NULL,
+    details TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
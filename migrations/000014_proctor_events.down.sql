@@ -0,0 +1 @@
+DROP TABLE IF EXISTS proctor_events;
@@ -0,0 +1,11 @@
+-- Client-reported proctoring events (tab switch, fullscreen exit, copy attempt, webcam flag)
+CREATE TABLE IF NOT EXISTS proctor_events (
+    id BIGSERIAL PRIMARY KEY,
+    session_id INT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    event_type VARCHAR(30) NOT NULL,
+    details JSONB,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    received_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_proctor_events_session ON proctor_events(session_id, event_type);
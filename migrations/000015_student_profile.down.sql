@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_students_country;
+
+ALTER TABLE students DROP COLUMN IF EXISTS designation;
+ALTER TABLE students DROP COLUMN IF EXISTS phone;
+ALTER TABLE students DROP COLUMN IF EXISTS country;
+ALTER TABLE students DROP COLUMN IF EXISTS institution;
@@ -0,0 +1,7 @@
+-- Optional profile details used on certificates and in analytics
+ALTER TABLE students ADD COLUMN IF NOT EXISTS institution VARCHAR(255);
+ALTER TABLE students ADD COLUMN IF NOT EXISTS country CHAR(2);
+ALTER TABLE students ADD COLUMN IF NOT EXISTS phone VARCHAR(32);
+ALTER TABLE students ADD COLUMN IF NOT EXISTS designation VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_students_country ON students(country);
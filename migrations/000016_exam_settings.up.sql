@@ -0,0 +1,6 @@
+-- Exam-level settings changed at runtime through admin endpoints, one JSON value per key
+CREATE TABLE IF NOT EXISTS exam_settings (
+    key VARCHAR(100) PRIMARY KEY,
+    value JSONB NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
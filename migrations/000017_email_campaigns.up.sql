@@ -0,0 +1,32 @@
+-- Email campaigns: one template sent to an audience segment, launched, paused and resumed by admins
+-- status: draft, running, paused, completed
+CREATE TABLE IF NOT EXISTS email_campaigns (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    subject VARCHAR(500) NOT NULL,
+    html_body TEXT NOT NULL,
+    segment VARCHAR(50) NOT NULL,
+    source_email_type VARCHAR(50),
+    status VARCHAR(20) NOT NULL DEFAULT 'draft',
+    started_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Recipients are snapshotted at launch.
+-- status: pending, sending (claimed by a sender), sent, failed, skipped (suppressed)
+CREATE TABLE IF NOT EXISTS email_campaign_recipients (
+    id SERIAL PRIMARY KEY,
+    campaign_id INT NOT NULL REFERENCES email_campaigns(id) ON DELETE CASCADE,
+    student_id INT NOT NULL REFERENCES students(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    request_id VARCHAR(255),
+    error TEXT,
+    sent_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    CONSTRAINT unique_campaign_student UNIQUE (campaign_id, student_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_campaign_recipients_status ON email_campaign_recipients(campaign_id, status, id);
@@ -0,0 +1,2 @@
+ALTER TABLE test_results DROP COLUMN IF EXISTS latency_distribution;
+ALTER TABLE test_results DROP COLUMN IF EXISTS concurrency;
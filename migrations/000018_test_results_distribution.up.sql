@@ -0,0 +1,4 @@
+-- Scenario runs (POST /api/load-test/run-scenario) record their concurrency and full latency
+-- distribution: microsecond percentiles up to p99.9 and a histogram
+ALTER TABLE test_results ADD COLUMN IF NOT EXISTS concurrency INT;
+ALTER TABLE test_results ADD COLUMN IF NOT EXISTS latency_distribution JSONB;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS access_code_events;
+DROP INDEX IF EXISTS idx_email_tracking_access_code;
+ALTER TABLE email_tracking DROP COLUMN IF EXISTS access_code_invalidated_at;
+ALTER TABLE email_tracking DROP COLUMN IF EXISTS access_code_used_at;
+ALTER TABLE email_tracking DROP COLUMN IF EXISTS access_code_expires_at;
+ALTER TABLE email_tracking DROP COLUMN IF EXISTS access_code_issued_at;
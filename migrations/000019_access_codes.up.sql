@@ -0,0 +1,40 @@
+-- Access code lifecycle: codes expire, are consumed by the session they start, and can be
+-- invalidated by an admin. Existing codes count as issued when the conference was attended
+-- and keep no expiry.
+ALTER TABLE email_tracking ADD COLUMN IF NOT EXISTS access_code_issued_at TIMESTAMPTZ;
+ALTER TABLE email_tracking ADD COLUMN IF NOT EXISTS access_code_expires_at TIMESTAMPTZ;
+ALTER TABLE email_tracking ADD COLUMN IF NOT EXISTS access_code_used_at TIMESTAMPTZ;
+ALTER TABLE email_tracking ADD COLUMN IF NOT EXISTS access_code_invalidated_at TIMESTAMPTZ;
+
+UPDATE email_tracking
+SET access_code_issued_at = COALESCE(conference_attended_at, opened_at, created_at)
+WHERE access_code IS NOT NULL AND access_code_issued_at IS NULL;
+
+-- Codes already used to start a session
+UPDATE email_tracking et
+SET access_code_used_at = first_session.started_at
+FROM (
+    SELECT student_id, MIN(started_at) AS started_at
+    FROM sessions
+    GROUP BY student_id
+) first_session
+WHERE et.student_id = first_session.student_id
+  AND et.email_type = 'firstMail'
+  AND et.access_code IS NOT NULL;
+
+-- verify-otp looks codes up directly
+CREATE INDEX IF NOT EXISTS idx_email_tracking_access_code ON email_tracking(access_code) WHERE access_code IS NOT NULL;
+
+-- Audit trail of every change to a student's access code
+CREATE TABLE IF NOT EXISTS access_code_events (
+    id SERIAL PRIMARY KEY,
+    student_id INT REFERENCES students(id) ON DELETE CASCADE,
+    action VARCHAR(20) NOT NULL,
+    reason TEXT,
+    expires_at TIMESTAMPTZ,
+    ip VARCHAR(64),
+    user_agent TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_code_events_student_id ON access_code_events(student_id, created_at DESC);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS exam_windows;
+ALTER TABLE event_schedule DROP COLUMN IF EXISTS timezone;
+ALTER TABLE event_schedule DROP COLUMN IF EXISTS window_minutes;
+ALTER TABLE event_schedule DROP COLUMN IF EXISTS window_policy;
+ALTER TABLE students DROP COLUMN IF EXISTS timezone;
@@ -0,0 +1,22 @@
+-- Participant timezones (IANA names such as "Europe/London"), from registration or the
+-- browser when the conference link is opened
+ALTER TABLE students ADD COLUMN IF NOT EXISTS timezone VARCHAR(64);
+
+-- Exam window policy per event: 'global' opens the window at second_scheduled_time for
+-- everyone; 'local' opens it at the same wall-clock time in each participant's timezone.
+-- timezone is the zone second_scheduled_time was entered in.
+ALTER TABLE event_schedule ADD COLUMN IF NOT EXISTS window_policy VARCHAR(20) NOT NULL DEFAULT 'global';
+ALTER TABLE event_schedule ADD COLUMN IF NOT EXISTS window_minutes INT NOT NULL DEFAULT 360;
+ALTER TABLE event_schedule ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'Asia/Kolkata';
+
+-- Resolved exam windows in UTC, one per event and participant timezone
+CREATE TABLE IF NOT EXISTS exam_windows (
+    id SERIAL PRIMARY KEY,
+    event_schedule_id INT NOT NULL REFERENCES event_schedule(id) ON DELETE CASCADE,
+    timezone VARCHAR(64) NOT NULL,
+    utc_offset_minutes INT NOT NULL,
+    starts_at TIMESTAMPTZ NOT NULL,
+    ends_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    UNIQUE (event_schedule_id, timezone)
+);
@@ -0,0 +1,21 @@
+-- SMS and WhatsApp messages, logged like email_logs. request_id is the provider's message
+-- id; delivery reports update status and status_updated_at by (provider, request_id).
+CREATE TABLE IF NOT EXISTS notification_logs (
+    id SERIAL PRIMARY KEY,
+    student_id INT REFERENCES students(id) ON DELETE CASCADE,
+    channel VARCHAR(20) NOT NULL,
+    phone VARCHAR(20) NOT NULL,
+    template VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    provider VARCHAR(50) NOT NULL,
+    request_id VARCHAR(255),
+    response_code VARCHAR(50),
+    response_message TEXT,
+    provider_response JSONB,
+    sent_at TIMESTAMPTZ DEFAULT NOW(),
+    status_updated_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_logs_request_id ON notification_logs(provider, request_id);
+CREATE INDEX IF NOT EXISTS idx_notification_logs_student_id ON notification_logs(student_id);
+CREATE INDEX IF NOT EXISTS idx_notification_logs_sent_at ON notification_logs(sent_at DESC);
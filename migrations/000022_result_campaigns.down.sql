@@ -0,0 +1 @@
+ALTER TABLE email_campaigns DROP COLUMN IF EXISTS kind;
@@ -0,0 +1,3 @@
+-- Campaign kind: 'custom' sends html_body as written; 'results' fills in each recipient's
+-- scorecard (score, rank, sections, certificate link) at send time
+ALTER TABLE email_campaigns ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT 'custom';
@@ -0,0 +1,2 @@
+ALTER TABLE answers DROP COLUMN IF EXISTS client_time_taken_seconds;
+DROP TABLE IF EXISTS question_serves;
@@ -0,0 +1,15 @@
+-- When each question was first (and last) served to a session by GET /api/live/question/:id.
+-- Answer time is measured from first_served_at on the server, so re-fetching a question
+-- cannot shorten it.
+CREATE TABLE IF NOT EXISTS question_serves (
+    id SERIAL PRIMARY KEY,
+    session_id INT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    question_id INT NOT NULL,
+    first_served_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_served_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    serve_count INT NOT NULL DEFAULT 1,
+    CONSTRAINT unique_session_question_serve UNIQUE (session_id, question_id)
+);
+
+-- The time the client reported, kept for review; time_taken_seconds holds the server's measurement
+ALTER TABLE answers ADD COLUMN IF NOT EXISTS client_time_taken_seconds INT;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_students_sandbox;
+ALTER TABLE sessions DROP COLUMN IF EXISTS is_sandbox;
+ALTER TABLE students DROP COLUMN IF EXISTS is_sandbox;
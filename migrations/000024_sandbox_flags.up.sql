@@ -0,0 +1,6 @@
+-- Sandbox students are created by POST /api/admin/test-run for QA. Their sessions are
+-- flagged too, and both are left out of leaderboards, results, stats and bulk mail.
+ALTER TABLE students ADD COLUMN IF NOT EXISTS is_sandbox BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS is_sandbox BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS idx_students_sandbox ON students(id) WHERE is_sandbox = true;
@@ -0,0 +1,25 @@
+-- Keys for machine-to-machine callers. Only the SHA-256 of a key is stored; prefix is
+-- its first characters, shown in listings so a key can be recognised.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(20) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    scope VARCHAR(20) NOT NULL,
+    rate_limit_per_minute INT NOT NULL DEFAULT 600,
+    expires_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Requests per key, route and day
+CREATE TABLE IF NOT EXISTS api_key_usage (
+    api_key_id INT NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    method VARCHAR(10) NOT NULL,
+    route VARCHAR(255) NOT NULL,
+    request_count INT NOT NULL DEFAULT 0,
+    error_count INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (api_key_id, day, method, route)
+);
@@ -0,0 +1,10 @@
+-- When each section of a session was started and ended (POST /api/live/start-section and
+-- end-section). A section past its time limit without ended_at has expired.
+CREATE TABLE IF NOT EXISTS session_sections (
+    id SERIAL PRIMARY KEY,
+    session_id INT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    section_id INT NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ended_at TIMESTAMPTZ,
+    UNIQUE(session_id, section_id)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS answer_events CASCADE;
+DROP FUNCTION IF EXISTS answer_events_immutable();
@@ -0,0 +1,32 @@
+-- Every submit-answer / submit-answers attempt, accepted or not, for settling disputes.
+-- Rows are never updated; they are deleted only together with their session.
+CREATE TABLE IF NOT EXISTS answer_events (
+    id BIGSERIAL PRIMARY KEY,
+    session_id INT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    question_id INT NOT NULL,
+    selected_option_index INT NOT NULL,
+    client_time_taken_seconds INT,
+    -- created, duplicate, updated, conflict, rejected or error
+    outcome VARCHAR(20) NOT NULL,
+    reason TEXT,
+    batch BOOLEAN NOT NULL DEFAULT false,
+    ip VARCHAR(64),
+    user_agent TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp()
+);
+
+CREATE INDEX IF NOT EXISTS idx_answer_events_session ON answer_events(session_id, question_id);
+
+CREATE OR REPLACE FUNCTION answer_events_immutable() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' AND NOT EXISTS (SELECT 1 FROM sessions WHERE id = OLD.session_id) THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION 'answer_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS answer_events_immutable ON answer_events;
+CREATE TRIGGER answer_events_immutable
+    BEFORE UPDATE OR DELETE ON answer_events
+    FOR EACH ROW EXECUTE FUNCTION answer_events_immutable();
@@ -0,0 +1,3 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS student_email;
+ALTER TABLE email_tracking DROP COLUMN IF EXISTS recipient_email;
+DROP TABLE IF EXISTS student_email_changes;
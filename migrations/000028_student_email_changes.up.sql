@@ -0,0 +1,17 @@
+-- Every change of a student's email address (PUT/PATCH /api/students/:id), so invitations,
+-- tokens and logs sent to an earlier address stay traceable to it
+CREATE TABLE IF NOT EXISTS student_email_changes (
+    id SERIAL PRIMARY KEY,
+    student_id INT NOT NULL REFERENCES students(id) ON DELETE CASCADE,
+    old_email VARCHAR(255) NOT NULL,
+    new_email VARCHAR(255) NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_student_email_changes_student ON student_email_changes(student_id, changed_at);
+CREATE INDEX IF NOT EXISTS idx_student_email_changes_old_email ON student_email_changes(LOWER(old_email));
+
+-- The address a tracked email was sent to, or a session was taken under, once the student's
+-- email has changed since. NULL means the student's current address.
+ALTER TABLE email_tracking ADD COLUMN IF NOT EXISTS recipient_email VARCHAR(255);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS student_email VARCHAR(255);
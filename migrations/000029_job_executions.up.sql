@@ -0,0 +1,22 @@
+-- One row per execution of a scheduled function, whether started by the scheduler or by
+-- POST /api/admin/jobs/:name/trigger. A resumed job run spans several executions. Rows are
+-- kept when the scheduled job is deleted.
+CREATE TABLE IF NOT EXISTS job_executions (
+    id SERIAL PRIMARY KEY,
+    job_id INT REFERENCES scheduled_jobs(id) ON DELETE SET NULL,
+    run_id INT REFERENCES job_runs(id) ON DELETE SET NULL,
+    function_name VARCHAR(100) NOT NULL,
+    triggered_by VARCHAR(20) NOT NULL DEFAULT 'scheduler',
+    forced BOOLEAN NOT NULL DEFAULT false,
+    status VARCHAR(20) NOT NULL DEFAULT 'running',
+    processed INT NOT NULL DEFAULT 0,
+    sent INT NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMPTZ,
+    duration_ms BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_executions_started ON job_executions(started_at DESC);
+CREATE INDEX IF NOT EXISTS idx_job_executions_function ON job_executions(function_name, started_at DESC);
+CREATE INDEX IF NOT EXISTS idx_job_executions_job ON job_executions(job_id, id DESC);
@@ -0,0 +1,8 @@
+-- Per-student tokens for the result link in results emails, accepted by the public
+-- POST /api/results/lookup instead of email and access code
+CREATE TABLE IF NOT EXISTS result_tokens (
+    student_id INT PRIMARY KEY REFERENCES students(id) ON DELETE CASCADE,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_sessions_open;
+ALTER TABLE sessions DROP COLUMN IF EXISTS abandoned_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_seen_at;
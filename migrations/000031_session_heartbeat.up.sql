@@ -0,0 +1,6 @@
+-- Last heartbeat from the candidate's client (POST /api/live/heartbeat), and when an open
+-- session was flagged as abandoned for going idle. A later heartbeat clears abandoned_at.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS abandoned_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_open ON sessions(id) WHERE completed = false;
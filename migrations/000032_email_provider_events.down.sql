@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_email_events_provider_unique;
+DELETE FROM email_events WHERE source <> 'tracking';
+ALTER TABLE email_events ALTER COLUMN email_type SET NOT NULL;
+ALTER TABLE email_events DROP COLUMN IF EXISTS details;
+ALTER TABLE email_events DROP COLUMN IF EXISTS email;
+ALTER TABLE email_events DROP COLUMN IF EXISTS request_id;
+ALTER TABLE email_events DROP COLUMN IF EXISTS source;
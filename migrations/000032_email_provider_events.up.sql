@@ -0,0 +1,12 @@
+-- Delivery events reported by the email provider's webhook (delivered, open, click, spam,
+-- bounce) are stored next to the tracking pixel/link events. source tells them apart;
+-- provider events are matched to the message by request_id and carry no email_type.
+ALTER TABLE email_events ADD COLUMN IF NOT EXISTS source VARCHAR(20) NOT NULL DEFAULT 'tracking';
+ALTER TABLE email_events ADD COLUMN IF NOT EXISTS request_id VARCHAR(255);
+ALTER TABLE email_events ADD COLUMN IF NOT EXISTS email VARCHAR(255);
+ALTER TABLE email_events ADD COLUMN IF NOT EXISTS details JSONB;
+ALTER TABLE email_events ALTER COLUMN email_type DROP NOT NULL;
+
+-- Webhook retries deliver the same event again
+CREATE UNIQUE INDEX IF NOT EXISTS idx_email_events_provider_unique
+    ON email_events(request_id, event_type, email, created_at) WHERE request_id IS NOT NULL;
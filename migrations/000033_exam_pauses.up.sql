@@ -0,0 +1,12 @@
+-- Exam-wide pauses set by organizers during incidents. A row without resumed_at means the
+-- exam is paused; section and question timers do not run during any pause.
+CREATE TABLE IF NOT EXISTS exam_pauses (
+    id SERIAL PRIMARY KEY,
+    reason TEXT NOT NULL DEFAULT '',
+    paused_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resumed_at TIMESTAMPTZ
+);
+
+-- At most one pause is open at a time
+CREATE UNIQUE INDEX IF NOT EXISTS idx_exam_pauses_open ON exam_pauses ((true)) WHERE resumed_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_exam_pauses_paused_at ON exam_pauses(paused_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS registrations;
+ALTER TABLE event_schedule DROP COLUMN IF EXISTS registration_closes_at;
+ALTER TABLE event_schedule DROP COLUMN IF EXISTS registration_opens_at;
@@ -0,0 +1,27 @@
+-- Self-registration through POST /api/register is open between these times of an event;
+-- with no opening time set, registration is closed
+ALTER TABLE event_schedule ADD COLUMN IF NOT EXISTS registration_opens_at TIMESTAMPTZ;
+ALTER TABLE event_schedule ADD COLUMN IF NOT EXISTS registration_closes_at TIMESTAMPTZ;
+
+-- Pending self-registrations. The student is only created once the emailed verification
+-- token is confirmed (double opt-in).
+CREATE TABLE IF NOT EXISTS registrations (
+    id SERIAL PRIMARY KEY,
+    event_schedule_id INT REFERENCES event_schedule(id) ON DELETE SET NULL,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    institution VARCHAR(255),
+    country CHAR(2),
+    phone VARCHAR(32),
+    designation VARCHAR(255),
+    timezone VARCHAR(64),
+    token VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    verified_at TIMESTAMPTZ,
+    student_id INT REFERENCES students(id) ON DELETE SET NULL,
+    ip VARCHAR(64),
+    user_agent TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_registrations_email ON registrations(LOWER(email), created_at DESC);
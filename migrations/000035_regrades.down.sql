@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS regrade_sessions;
+DROP TABLE IF EXISTS regrades;
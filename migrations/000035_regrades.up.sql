@@ -0,0 +1,21 @@
+-- Audit trail of answer-key corrections applied after an exam. actions holds the per-question
+-- actions as requested; regrade_sessions records every session it rescored.
+CREATE TABLE IF NOT EXISTS regrades (
+    id SERIAL PRIMARY KEY,
+    reason TEXT NOT NULL DEFAULT '',
+    actions JSONB NOT NULL,
+    answers_changed INT NOT NULL DEFAULT 0,
+    sessions_rescored INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS regrade_sessions (
+    regrade_id INT NOT NULL REFERENCES regrades(id) ON DELETE CASCADE,
+    session_id INT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    student_id INT NOT NULL,
+    old_score INT,
+    new_score INT NOT NULL,
+    PRIMARY KEY (regrade_id, session_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_regrade_sessions_session_id ON regrade_sessions(session_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_email_events_student_created;
+DROP INDEX IF EXISTS idx_email_logs_sent_at;
+DROP INDEX IF EXISTS idx_email_logs_email_lower;
@@ -0,0 +1,5 @@
+-- Email log search filters by address and date range; the per-student email timeline reads
+-- email_events by student
+CREATE INDEX IF NOT EXISTS idx_email_logs_email_lower ON email_logs (LOWER(email));
+CREATE INDEX IF NOT EXISTS idx_email_logs_sent_at ON email_logs(sent_at);
+CREATE INDEX IF NOT EXISTS idx_email_events_student_created ON email_events(student_id, created_at);
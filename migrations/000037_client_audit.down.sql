@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS client_events;
+ALTER TABLE sessions DROP COLUMN IF EXISTS start_country;
+ALTER TABLE sessions DROP COLUMN IF EXISTS start_user_agent;
+ALTER TABLE sessions DROP COLUMN IF EXISTS start_ip;
@@ -0,0 +1,20 @@
+-- Where critical actions came from, for fraud review. Answer submissions already carry ip and
+-- user_agent in answer_events; country is the GeoIP lookup, NULL without a GeoIP database.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS start_ip VARCHAR(64);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS start_user_agent TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS start_country CHAR(2);
+
+-- Conference-token and OTP verifications
+CREATE TABLE IF NOT EXISTS client_events (
+    id BIGSERIAL PRIMARY KEY,
+    action VARCHAR(30) NOT NULL,
+    student_id INT REFERENCES students(id) ON DELETE CASCADE,
+    session_id INT REFERENCES sessions(id) ON DELETE CASCADE,
+    ip VARCHAR(64),
+    user_agent TEXT,
+    country CHAR(2),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_client_events_session ON client_events(session_id) WHERE session_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_client_events_student ON client_events(student_id, created_at);
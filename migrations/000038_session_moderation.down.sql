@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS session_admin_actions;
+ALTER TABLE sessions DROP COLUMN IF EXISTS disqualify_reason;
+ALTER TABLE sessions DROP COLUMN IF EXISTS disqualified_at;
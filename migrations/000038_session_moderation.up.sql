@@ -0,0 +1,18 @@
+-- Disqualified sessions stay in the database but never count toward leaderboards or results
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS disqualified_at TIMESTAMPTZ;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS disqualify_reason TEXT;
+
+-- Audit trail of admin force-ends, disqualifications and reinstatements
+CREATE TABLE IF NOT EXISTS session_admin_actions (
+    id SERIAL PRIMARY KEY,
+    session_id INT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    student_id INT NOT NULL REFERENCES students(id) ON DELETE CASCADE,
+    action VARCHAR(30) NOT NULL,
+    reason TEXT,
+    details JSONB,
+    ip VARCHAR(64),
+    user_agent TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_admin_actions_session ON session_admin_actions(session_id, created_at);
@@ -0,0 +1,3 @@
+ALTER TABLE email_campaigns DROP COLUMN IF EXISTS filter;
+DROP INDEX IF EXISTS idx_students_tags;
+ALTER TABLE students DROP COLUMN IF EXISTS tags;
@@ -0,0 +1,7 @@
+-- Free-form labels such as "vip" or "speaker", matched by tag= in segment filters
+ALTER TABLE students ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_students_tags ON students USING GIN (tags);
+
+-- A campaign's audience is its segment narrowed by an optional segment filter
+ALTER TABLE email_campaigns ADD COLUMN IF NOT EXISTS filter TEXT;
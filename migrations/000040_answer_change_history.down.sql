@@ -0,0 +1 @@
+ALTER TABLE answer_events DROP COLUMN IF EXISTS previous_option_index;
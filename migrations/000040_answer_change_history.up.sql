@@ -0,0 +1,3 @@
+-- The answer an "updated" submission replaced, as stored in answers, so the history of a
+-- changed answer can be read from answer_events alone
+ALTER TABLE answer_events ADD COLUMN IF NOT EXISTS previous_option_index INT;
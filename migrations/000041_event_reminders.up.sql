@@ -0,0 +1,14 @@
+-- Conference reminders: each offset before an event's first_scheduled_time owns a one-shot
+-- scheduled job that launches a campaign to the students who have not attended yet
+CREATE TABLE IF NOT EXISTS event_reminders (
+    id SERIAL PRIMARY KEY,
+    event_schedule_id INT NOT NULL REFERENCES event_schedule(id) ON DELETE CASCADE,
+    offset_minutes INT NOT NULL CHECK (offset_minutes > 0),
+    subject VARCHAR(500),
+    html_body TEXT,
+    job_id INT REFERENCES scheduled_jobs(id) ON DELETE SET NULL,
+    campaign_id INT REFERENCES email_campaigns(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    CONSTRAINT unique_event_reminder_offset UNIQUE (event_schedule_id, offset_minutes)
+);
@@ -0,0 +1,2 @@
+-- Which rows used the legacy names is not kept, so the rewrite is not undone
+SELECT 1;
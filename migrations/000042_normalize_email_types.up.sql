@@ -0,0 +1,40 @@
+-- The open pixel and the original scheduler functions tracked the conference and test
+-- invitations as 'first' and 'second' while the live flow uses 'firstMail' and 'secondMail',
+-- so opens never joined attendance. Fold the legacy names into the canonical ones.
+
+-- A student with both rows keeps one, combining what each recorded
+UPDATE email_tracking c
+SET conference_token = COALESCE(c.conference_token, l.conference_token),
+    conference_attended = COALESCE(c.conference_attended, false) OR COALESCE(l.conference_attended, false),
+    conference_attended_at = LEAST(c.conference_attended_at, l.conference_attended_at),
+    -- The access code moves with its lifecycle when only the legacy row has one
+    access_code = COALESCE(c.access_code, l.access_code),
+    access_code_issued_at = CASE WHEN c.access_code IS NULL THEN l.access_code_issued_at ELSE c.access_code_issued_at END,
+    access_code_expires_at = CASE WHEN c.access_code IS NULL THEN l.access_code_expires_at ELSE c.access_code_expires_at END,
+    access_code_used_at = CASE WHEN c.access_code IS NULL THEN l.access_code_used_at ELSE c.access_code_used_at END,
+    access_code_invalidated_at = CASE WHEN c.access_code IS NULL THEN l.access_code_invalidated_at ELSE c.access_code_invalidated_at END,
+    opened = COALESCE(c.opened, false) OR COALESCE(l.opened, false),
+    opened_at = LEAST(c.opened_at, l.opened_at),
+    updated_at = NOW()
+FROM email_tracking l
+WHERE l.student_id = c.student_id
+  AND ((c.email_type = 'firstMail' AND l.email_type = 'first')
+    OR (c.email_type = 'secondMail' AND l.email_type = 'second'));
+
+DELETE FROM email_tracking l
+USING email_tracking c
+WHERE l.student_id = c.student_id
+  AND ((l.email_type = 'first' AND c.email_type = 'firstMail')
+    OR (l.email_type = 'second' AND c.email_type = 'secondMail'));
+
+UPDATE email_tracking SET email_type = 'firstMail', updated_at = NOW() WHERE email_type = 'first';
+UPDATE email_tracking SET email_type = 'secondMail', updated_at = NOW() WHERE email_type = 'second';
+
+UPDATE email_events SET email_type = 'firstMail' WHERE email_type = 'first';
+UPDATE email_events SET email_type = 'secondMail' WHERE email_type = 'second';
+
+UPDATE email_links SET email_type = 'firstMail' WHERE email_type = 'first';
+UPDATE email_links SET email_type = 'secondMail' WHERE email_type = 'second';
+
+UPDATE email_campaigns SET source_email_type = 'firstMail' WHERE source_email_type = 'first';
+UPDATE email_campaigns SET source_email_type = 'secondMail' WHERE source_email_type = 'second';
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS ui_strings;
+ALTER TABLE email_campaigns DROP COLUMN IF EXISTS variants;
+DROP TABLE IF EXISTS email_templates;
+ALTER TABLE registrations DROP COLUMN IF EXISTS locale;
+ALTER TABLE students DROP COLUMN IF EXISTS locale;
@@ -0,0 +1,29 @@
+-- Preferred language for emails and the quiz UI; NULL uses DEFAULT_LOCALE
+ALTER TABLE students ADD COLUMN IF NOT EXISTS locale VARCHAR(10);
+ALTER TABLE registrations ADD COLUMN IF NOT EXISTS locale VARCHAR(10);
+
+-- Localized variants of the built-in emails (firstMail, secondMail, registration, reminder,
+-- results) and of any template a campaign copies by key
+CREATE TABLE IF NOT EXISTS email_templates (
+    id SERIAL PRIMARY KEY,
+    template_key VARCHAR(50) NOT NULL,
+    locale VARCHAR(10) NOT NULL,
+    subject VARCHAR(500) NOT NULL,
+    html_body TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    CONSTRAINT unique_email_template_locale UNIQUE (template_key, locale)
+);
+
+-- A campaign's subject and body per locale, copied when it is created; recipients in
+-- other locales get the campaign's own subject and body
+ALTER TABLE email_campaigns ADD COLUMN IF NOT EXISTS variants JSONB NOT NULL DEFAULT '{}';
+
+-- Admin overrides of the built-in UI strings served by GET /api/i18n/strings
+CREATE TABLE IF NOT EXISTS ui_strings (
+    locale VARCHAR(10) NOT NULL,
+    key VARCHAR(100) NOT NULL,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (locale, key)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS auto_completed_reason;
+ALTER TABLE sessions DROP COLUMN IF EXISTS auto_completed;
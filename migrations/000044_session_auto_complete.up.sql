@@ -0,0 +1,4 @@
+-- Sessions the server finalized on the candidate's behalf, because the test's time ran out
+-- (time_expired) or the candidate went idle (idle), rather than through end-session
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS auto_completed BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS auto_completed_reason VARCHAR(20);
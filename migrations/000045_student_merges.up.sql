@@ -0,0 +1,20 @@
+-- Audit trail of duplicate students merged by POST /api/admin/students/merge. The merged
+-- student is deleted, so its row is kept as merged_student; moved counts the rows that
+-- moved to the kept student, by table.
+CREATE TABLE IF NOT EXISTS student_merges (
+    id SERIAL PRIMARY KEY,
+    kept_student_id INT REFERENCES students(id) ON DELETE SET NULL,
+    merged_student_id INT NOT NULL,
+    kept_email VARCHAR(255) NOT NULL,
+    merged_email VARCHAR(255) NOT NULL,
+    final_email VARCHAR(255) NOT NULL,
+    merged_student JSONB NOT NULL,
+    moved JSONB NOT NULL DEFAULT '{}',
+    reason TEXT,
+    ip VARCHAR(64),
+    user_agent TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_student_merges_kept ON student_merges(kept_student_id);
+CREATE INDEX IF NOT EXISTS idx_student_merges_merged ON student_merges(merged_student_id);
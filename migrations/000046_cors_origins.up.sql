@@ -0,0 +1,9 @@
+-- Browser origins allowed to call the API in addition to CORS_ALLOWED_ORIGINS, edited at
+-- runtime through /api/admin/cors/origins. An origin may start its host with *. to match
+-- every subdomain.
+CREATE TABLE IF NOT EXISTS cors_origins (
+    id SERIAL PRIMARY KEY,
+    origin VARCHAR(255) NOT NULL UNIQUE,
+    note TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
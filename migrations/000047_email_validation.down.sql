@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_students_email_invalid;
+ALTER TABLE students DROP COLUMN IF EXISTS email_checked_at;
+ALTER TABLE students DROP COLUMN IF EXISTS email_invalid_reason;
+ALTER TABLE students DROP COLUMN IF EXISTS email_valid;
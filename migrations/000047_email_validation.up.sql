@@ -0,0 +1,7 @@
+-- Whether the student's address passed validation: RFC 5322 syntax, plus a mail server for
+-- its domain when EMAIL_MX_CHECK is on. Campaigns and send-alls skip invalid addresses.
+ALTER TABLE students ADD COLUMN IF NOT EXISTS email_valid BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE students ADD COLUMN IF NOT EXISTS email_invalid_reason VARCHAR(32);
+ALTER TABLE students ADD COLUMN IF NOT EXISTS email_checked_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_students_email_invalid ON students(id) WHERE email_valid = false;
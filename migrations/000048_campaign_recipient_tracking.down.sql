@@ -0,0 +1,10 @@
+DELETE FROM email_campaigns WHERE kind = 'system';
+DROP INDEX IF EXISTS idx_campaign_recipients_student;
+DROP INDEX IF EXISTS idx_campaign_recipients_token;
+ALTER TABLE email_campaign_recipients DROP COLUMN IF EXISTS click_count;
+ALTER TABLE email_campaign_recipients DROP COLUMN IF EXISTS clicked_at;
+ALTER TABLE email_campaign_recipients DROP COLUMN IF EXISTS open_count;
+ALTER TABLE email_campaign_recipients DROP COLUMN IF EXISTS opened_at;
+ALTER TABLE email_campaign_recipients DROP COLUMN IF EXISTS token;
+DROP INDEX IF EXISTS idx_email_campaigns_email_type;
+ALTER TABLE email_campaigns DROP COLUMN IF EXISTS email_type;
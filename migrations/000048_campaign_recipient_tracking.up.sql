@@ -0,0 +1,86 @@
+-- Delivery tracking moves from email_tracking (one row per student and email type, whose
+-- token a resend overwrote) to email_campaign_recipients (one row per campaign and student).
+-- Emails sent outside campaigns (firstMail, secondMail, broadcast, ...) are tracked under a
+-- system campaign per email type, created on first send, so a new type needs no change.
+-- email_tracking keeps the conference and access code state of the firstMail flow.
+ALTER TABLE email_campaigns ADD COLUMN IF NOT EXISTS email_type VARCHAR(50);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_email_campaigns_email_type ON email_campaigns(email_type) WHERE email_type IS NOT NULL;
+
+-- token identifies the recipient and survives resends; the counts include repeat opens and clicks
+ALTER TABLE email_campaign_recipients ADD COLUMN IF NOT EXISTS token VARCHAR(100) NOT NULL DEFAULT md5(random()::text || clock_timestamp()::text);
+ALTER TABLE email_campaign_recipients ADD COLUMN IF NOT EXISTS opened_at TIMESTAMPTZ;
+ALTER TABLE email_campaign_recipients ADD COLUMN IF NOT EXISTS open_count INT NOT NULL DEFAULT 0;
+ALTER TABLE email_campaign_recipients ADD COLUMN IF NOT EXISTS clicked_at TIMESTAMPTZ;
+ALTER TABLE email_campaign_recipients ADD COLUMN IF NOT EXISTS click_count INT NOT NULL DEFAULT 0;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_campaign_recipients_token ON email_campaign_recipients(token);
+CREATE INDEX IF NOT EXISTS idx_campaign_recipients_student ON email_campaign_recipients(student_id);
+
+-- A system campaign for every email type tracked so far other than campaigns' own
+INSERT INTO email_campaigns (name, kind, subject, html_body, segment, status, email_type, started_at)
+SELECT t.email_type, 'system', '', '', 'all', 'tracking', t.email_type, MIN(t.first_at)
+FROM (
+    SELECT email_type, MIN(created_at) AS first_at FROM email_tracking GROUP BY email_type
+    UNION ALL
+    SELECT email_type, MIN(created_at) FROM email_events WHERE event_type = 'sent' GROUP BY email_type
+) t
+WHERE t.email_type !~ '^campaign-[0-9]+$'
+GROUP BY t.email_type
+ON CONFLICT (email_type) WHERE email_type IS NOT NULL DO NOTHING;
+
+-- email_tracking rows become recipients, keeping the token they were sent with and the
+-- address they were issued to
+INSERT INTO email_campaign_recipients (campaign_id, student_id, email, status, token, sent_at,
+                                       opened_at, open_count, clicked_at, click_count, updated_at)
+SELECT c.id, et.student_id, COALESCE(et.recipient_email, s.email), 'sent',
+       CASE WHEN et.conference_token IS NOT NULL AND COUNT(*) OVER (PARTITION BY et.conference_token) = 1
+            THEN et.conference_token ELSE md5(random()::text || clock_timestamp()::text) END,
+       COALESCE(ev.sent_at, et.created_at),
+       CASE WHEN et.opened THEN COALESCE(et.opened_at, ev.opened_at) ELSE ev.opened_at END,
+       GREATEST(ev.opens, CASE WHEN et.opened THEN 1 ELSE 0 END),
+       ev.clicked_at, ev.clicks, COALESCE(et.updated_at, NOW())
+FROM email_tracking et
+JOIN students s ON s.id = et.student_id
+JOIN email_campaigns c ON c.email_type = et.email_type
+CROSS JOIN LATERAL (
+    SELECT MIN(e.created_at) FILTER (WHERE e.event_type = 'sent') AS sent_at,
+           MIN(e.created_at) FILTER (WHERE e.event_type = 'open') AS opened_at,
+           COUNT(*) FILTER (WHERE e.event_type = 'open') AS opens,
+           MIN(e.created_at) FILTER (WHERE e.event_type = 'click') AS clicked_at,
+           COUNT(*) FILTER (WHERE e.event_type = 'click') AS clicks
+    FROM email_events e
+    WHERE e.student_id = et.student_id AND e.email_type = et.email_type
+) ev
+ON CONFLICT DO NOTHING;
+
+-- Sends recorded only as events, such as send-all broadcasts
+INSERT INTO email_campaign_recipients (campaign_id, student_id, email, status, sent_at,
+                                       opened_at, open_count, clicked_at, click_count, updated_at)
+SELECT c.id, e.student_id, s.email, 'sent',
+       MIN(e.created_at) FILTER (WHERE e.event_type = 'sent'),
+       MIN(e.created_at) FILTER (WHERE e.event_type = 'open'),
+       COUNT(*) FILTER (WHERE e.event_type = 'open'),
+       MIN(e.created_at) FILTER (WHERE e.event_type = 'click'),
+       COUNT(*) FILTER (WHERE e.event_type = 'click'),
+       MAX(e.created_at)
+FROM email_events e
+JOIN students s ON s.id = e.student_id
+JOIN email_campaigns c ON c.email_type = e.email_type
+GROUP BY c.id, e.student_id, s.email
+HAVING COUNT(*) FILTER (WHERE e.event_type = 'sent') > 0
+ON CONFLICT DO NOTHING;
+
+-- Opens and clicks of campaigns sent before this migration
+UPDATE email_campaign_recipients r
+SET opened_at = ev.opened_at, open_count = ev.opens, clicked_at = ev.clicked_at, click_count = ev.clicks
+FROM (
+    SELECT substring(e.email_type FROM 10)::int AS campaign_id, e.student_id,
+           MIN(e.created_at) FILTER (WHERE e.event_type = 'open') AS opened_at,
+           COUNT(*) FILTER (WHERE e.event_type = 'open') AS opens,
+           MIN(e.created_at) FILTER (WHERE e.event_type = 'click') AS clicked_at,
+           COUNT(*) FILTER (WHERE e.event_type = 'click') AS clicks
+    FROM email_events e
+    WHERE e.email_type ~ '^campaign-[0-9]+$' AND e.event_type IN ('open', 'click')
+    GROUP BY 1, 2
+) ev
+WHERE r.campaign_id = ev.campaign_id AND r.student_id = ev.student_id;
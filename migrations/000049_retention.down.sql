@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS retention_runs;
+ALTER TABLE students DROP COLUMN IF EXISTS anonymized_at;
@@ -0,0 +1,20 @@
+-- When a retention policy removed the student's personal data. The row, sessions and results
+-- stay for statistics under a placeholder name and an undeliverable address.
+ALTER TABLE students ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMPTZ;
+
+-- Audit trail of retention runs (scheduled ApplyRetentionPolicies or POST
+-- /api/admin/retention/run). policies is the configuration applied; classes holds, per data
+-- class, the action, cutoff, rows affected by table and the students anonymized or deleted.
+CREATE TABLE IF NOT EXISTS retention_runs (
+    id SERIAL PRIMARY KEY,
+    trigger VARCHAR(20) NOT NULL,
+    policies JSONB NOT NULL,
+    classes JSONB NOT NULL DEFAULT '[]',
+    error TEXT,
+    ip VARCHAR(64),
+    user_agent TEXT,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_retention_runs_started ON retention_runs(started_at DESC);
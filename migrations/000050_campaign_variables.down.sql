@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS email_campaign_variables;
+ALTER TABLE email_campaigns DROP COLUMN IF EXISTS strict_variables;
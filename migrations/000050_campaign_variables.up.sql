@@ -0,0 +1,12 @@
+-- Strict campaigns fail a recipient whose email would go out with a variable left empty
+ALTER TABLE email_campaigns ADD COLUMN IF NOT EXISTS strict_variables BOOLEAN NOT NULL DEFAULT false;
+
+-- Custom variables per recipient of a campaign, e.g. {"seat": "A12"} for {{seat}}. Set
+-- before or during sending; pending recipients get the values current when they are sent.
+CREATE TABLE IF NOT EXISTS email_campaign_variables (
+    campaign_id INT NOT NULL REFERENCES email_campaigns(id) ON DELETE CASCADE,
+    student_id INT NOT NULL REFERENCES students(id) ON DELETE CASCADE,
+    variables JSONB NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (campaign_id, student_id)
+);
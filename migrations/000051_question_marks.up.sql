@@ -0,0 +1,17 @@
+-- Review markers a candidate sets while taking the test (POST /api/live/flag-question):
+-- flagged for review, and skipped (moved past without answering). session-state returns them
+-- so a reloaded page can restore them; question analytics count them.
+CREATE TABLE IF NOT EXISTS question_marks (
+    session_id INT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    question_id INT NOT NULL,
+    flagged BOOLEAN NOT NULL DEFAULT false,
+    skipped BOOLEAN NOT NULL DEFAULT false,
+    -- How many times the question was flagged and skipped, for analytics
+    flag_count INT NOT NULL DEFAULT 0,
+    skip_count INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (session_id, question_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_question_marks_question ON question_marks(question_id);
@@ -0,0 +1,2 @@
+-- Campaigns created with "tracking": false send without the open pixel and click tracking
+ALTER TABLE email_campaigns ADD COLUMN IF NOT EXISTS tracking BOOLEAN NOT NULL DEFAULT true;
@@ -0,0 +1,31 @@
+-- Every verify-otp attempt, so guessing can be seen and stopped. student_id is set when the
+-- attempt can be tied to a student: the code matched, or the request named the student.
+CREATE TABLE IF NOT EXISTS otp_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    student_id INT REFERENCES students(id) ON DELETE CASCADE,
+    outcome VARCHAR(20) NOT NULL,
+    ip VARCHAR(64),
+    user_agent TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_otp_attempts_created_at ON otp_attempts(created_at);
+CREATE INDEX IF NOT EXISTS idx_otp_attempts_student_id ON otp_attempts(student_id, created_at) WHERE student_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_otp_attempts_ip ON otp_attempts(ip, created_at);
+
+-- A student's code or an IP locked out of verify-otp after too many failures. Exactly one
+-- of student_id and ip is set.
+CREATE TABLE IF NOT EXISTS otp_lockouts (
+    id SERIAL PRIMARY KEY,
+    student_id INT REFERENCES students(id) ON DELETE CASCADE,
+    ip VARCHAR(64),
+    failures INT NOT NULL,
+    locked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ NOT NULL,
+    unlocked_at TIMESTAMPTZ,
+    unlock_reason TEXT,
+    CONSTRAINT otp_lockouts_one_key CHECK ((student_id IS NULL) <> (ip IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_otp_lockouts_student_id ON otp_lockouts(student_id, locked_at DESC) WHERE student_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_otp_lockouts_ip ON otp_lockouts(ip, locked_at DESC) WHERE ip IS NOT NULL;
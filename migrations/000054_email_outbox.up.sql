@@ -0,0 +1,27 @@
+-- Emails written in the same transaction as the token or record they carry, and sent by the
+-- outbox relay afterwards. The relay claims due rows (status pending, or sending whose lease
+-- ran out), retries failures with backoff and records the outcome here.
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    student_id INT REFERENCES students(id) ON DELETE CASCADE,
+    email_type VARCHAR(50),
+    source VARCHAR(50) NOT NULL,
+    to_email VARCHAR(255) NOT NULL,
+    to_name VARCHAR(255) NOT NULL DEFAULT '',
+    subject TEXT NOT NULL,
+    html_body TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'sending', 'sent', 'failed', 'skipped')),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    provider VARCHAR(50),
+    request_id VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_outbox_due ON email_outbox(next_attempt_at) WHERE status IN ('pending', 'sending');
+CREATE INDEX IF NOT EXISTS idx_email_outbox_status ON email_outbox(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_email_outbox_student_id ON email_outbox(student_id) WHERE student_id IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS email_change_requests;
+ALTER TABLE student_email_changes DROP COLUMN IF EXISTS source;
@@ -0,0 +1,22 @@
+-- Who made an email change: an admin (PUT/PATCH /api/students/:id) or the participant
+-- through a confirmed change-email request
+ALTER TABLE student_email_changes ADD COLUMN IF NOT EXISTS source VARCHAR(20) NOT NULL DEFAULT 'admin';
+
+-- Participants' requests to change their own address. The change is made when the link sent
+-- to the new address is confirmed; a newer request cancels the pending ones.
+CREATE TABLE IF NOT EXISTS email_change_requests (
+    id SERIAL PRIMARY KEY,
+    student_id INT NOT NULL REFERENCES students(id) ON DELETE CASCADE,
+    old_email VARCHAR(255) NOT NULL,
+    new_email VARCHAR(255) NOT NULL,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    confirmed_at TIMESTAMPTZ,
+    cancelled_at TIMESTAMPTZ,
+    email_change_id INT REFERENCES student_email_changes(id) ON DELETE SET NULL,
+    ip VARCHAR(64),
+    user_agent TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_change_requests_student_id ON email_change_requests(student_id, created_at);
@@ -0,0 +1,4 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS finish_by;
+ALTER TABLE event_schedule DROP COLUMN IF EXISTS late_entry_reduced_time;
+ALTER TABLE event_schedule DROP COLUMN IF EXISTS late_entry_minutes;
+ALTER TABLE event_schedule DROP COLUMN IF EXISTS early_entry_minutes;
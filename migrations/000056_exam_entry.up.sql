@@ -0,0 +1,9 @@
+-- Entry around the exam window, per event: participants may start early_entry_minutes before
+-- the window opens and up to late_entry_minutes after (0 keeps entry open until the window
+-- ends). With late_entry_reduced_time, late entrants finish when those who started on time do.
+ALTER TABLE event_schedule ADD COLUMN IF NOT EXISTS early_entry_minutes INT NOT NULL DEFAULT 0;
+ALTER TABLE event_schedule ADD COLUMN IF NOT EXISTS late_entry_minutes INT NOT NULL DEFAULT 0;
+ALTER TABLE event_schedule ADD COLUMN IF NOT EXISTS late_entry_reduced_time BOOLEAN NOT NULL DEFAULT false;
+
+-- When a late entrant's time runs out under reduced time; NULL gives the full test duration
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS finish_by TIMESTAMPTZ;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS exam_archive_section_results;
+DROP TABLE IF EXISTS exam_archive_results;
+DROP TABLE IF EXISTS exam_archives;
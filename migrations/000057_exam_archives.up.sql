@@ -0,0 +1,59 @@
+-- Snapshots of finished events: results, section results and statistics. POST
+-- /api/admin/reset-db does not drop these tables, so past events stay browsable; student and
+-- event IDs are those of the database the event ran in.
+CREATE TABLE IF NOT EXISTS exam_archives (
+    id SERIAL PRIMARY KEY,
+    event_schedule_id INT NOT NULL,
+    -- Tells the event apart from one with the same ID after a reset
+    event_created_at TIMESTAMPTZ NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    notes TEXT,
+    exam_starts_at TIMESTAMPTZ NOT NULL,
+    timezone VARCHAR(64) NOT NULL,
+    ranking JSONB NOT NULL,
+    sections JSONB NOT NULL,
+    stats JSONB NOT NULL,
+    participants INT NOT NULL DEFAULT 0,
+    ip VARCHAR(64),
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (event_schedule_id, event_created_at)
+);
+
+-- Each ranked student's counted attempt, as the results export showed it
+CREATE TABLE IF NOT EXISTS exam_archive_results (
+    archive_id INT NOT NULL REFERENCES exam_archives(id) ON DELETE CASCADE,
+    student_id INT NOT NULL,
+    rank INT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    institution VARCHAR(255),
+    country CHAR(2),
+    designation VARCHAR(255),
+    score INT NOT NULL,
+    total_time_taken_seconds INT NOT NULL,
+    questions_answered INT NOT NULL,
+    correct_answers INT NOT NULL,
+    attempt_number INT NOT NULL,
+    started_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ,
+    auto_completed_reason VARCHAR(20),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (archive_id, student_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_exam_archive_results_rank ON exam_archive_results(archive_id, rank, student_id);
+
+-- Section results of the counted attempts, ranked per section as the section leaderboards were
+CREATE TABLE IF NOT EXISTS exam_archive_section_results (
+    archive_id INT NOT NULL,
+    student_id INT NOT NULL,
+    section_id INT NOT NULL,
+    rank INT NOT NULL,
+    score INT NOT NULL,
+    time_taken_seconds INT NOT NULL,
+    questions_answered INT NOT NULL,
+    PRIMARY KEY (archive_id, section_id, student_id),
+    FOREIGN KEY (archive_id, student_id) REFERENCES exam_archive_results(archive_id, student_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_exam_archive_section_results_rank ON exam_archive_section_results(archive_id, section_id, rank, student_id);
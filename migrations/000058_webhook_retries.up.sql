@@ -0,0 +1,8 @@
+-- When a retrying webhook delivery is next attempted. Retries are picked up from here by
+-- every instance's dispatcher, so they outlive a restart or deploy.
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ;
+
+-- Deliveries left retrying by in-memory timers are attempted again
+UPDATE webhook_deliveries SET next_attempt_at = NOW() WHERE status = 'retrying' AND next_attempt_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_next_attempt ON webhook_deliveries(next_attempt_at) WHERE status = 'retrying';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_email_tracking_access_code;
+CREATE INDEX IF NOT EXISTS idx_email_tracking_access_code ON email_tracking(access_code) WHERE access_code IS NOT NULL;
@@ -0,0 +1,12 @@
+-- verify-otp finds the student by access code alone, so no two tracking rows may hold the
+-- same code. A code held twice stays with the row that was issued it last; the others lose
+-- it and are given a new one with POST /api/admin/students/:id/access-code/regenerate.
+UPDATE email_tracking et
+SET access_code = NULL, updated_at = NOW()
+FROM email_tracking newer
+WHERE newer.access_code = et.access_code
+  AND newer.id <> et.id
+  AND (COALESCE(newer.access_code_issued_at, '-infinity'), newer.id) > (COALESCE(et.access_code_issued_at, '-infinity'), et.id);
+
+DROP INDEX IF EXISTS idx_email_tracking_access_code;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_email_tracking_access_code ON email_tracking(access_code) WHERE access_code IS NOT NULL;
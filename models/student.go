@@ -3,19 +3,67 @@ package models
 import "time"
 
 type Student struct {
-	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Email       string    `json:"email"`
+	Institution *string   `json:"institution"`
+	Country     *string   `json:"country"`
+	Phone       *string   `json:"phone"`
+	Designation *string   `json:"designation"`
+	Timezone    *string   `json:"timezone"`
+	Locale      *string   `json:"locale"`
+	IsSandbox   bool      `json:"is_sandbox"`
+	Tags        []string  `json:"tags"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// EmailValid is false when the address failed validation (see package emailcheck);
+	// EmailInvalidReason says why
+	EmailValid         bool    `json:"email_valid"`
+	EmailInvalidReason *string `json:"email_invalid_reason"`
+	// AnonymizedAt is set once a retention policy has removed the personal data (see package
+	// retention)
+	AnonymizedAt *time.Time `json:"anonymized_at"`
+}
+
+// StudentProfile holds the optional details. On create an empty field is stored as NULL;
+// on update an omitted field keeps its value and an empty string clears it.
+type StudentProfile struct {
+	Institution *string `json:"institution"`
+	// Country is an ISO 3166-1 alpha-2 code, e.g. "IN"
+	Country     *string `json:"country"`
+	Phone       *string `json:"phone"`
+	Designation *string `json:"designation"`
+	// Timezone is an IANA name, e.g. "Europe/London", used for local exam windows
+	Timezone *string `json:"timezone"`
+	// Locale is the language of emails and the quiz UI, e.g. "fr"; empty uses DEFAULT_LOCALE
+	Locale *string `json:"locale"`
 }
 
 type CreateStudentRequest struct {
 	Name  string `json:"name"`
 	Email string `json:"email"`
+	StudentProfile
 }
 
 type UpdateStudentRequest struct {
 	Name  string `json:"name"`
 	Email string `json:"email"`
+	StudentProfile
+}
+
+// PatchStudentRequest changes only the fields present in the body
+type PatchStudentRequest struct {
+	Name  *string `json:"name"`
+	Email *string `json:"email"`
+	StudentProfile
+}
+
+// StudentEmailChange is one change of a student's email address
+type StudentEmailChange struct {
+	ID       int    `json:"id"`
+	OldEmail string `json:"old_email"`
+	NewEmail string `json:"new_email"`
+	// Source is admin, or self_service for a change the participant confirmed
+	Source    string    `json:"source"`
+	ChangedAt time.Time `json:"changed_at"`
 }
@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const msg91URL = "https://control.msg91.com/api/v5/flow/"
+
+// MSG91Provider sends SMS through MSG91 flows. Indian DLT rules require pre-approved
+// templates, so the text lives in the flow and only the variables are sent.
+type MSG91Provider struct {
+	authKey   string
+	templates map[string]string
+	client    *http.Client
+}
+
+// NewMSG91Provider reads MSG91_AUTH_KEY, MSG91_TEMPLATE_ACCESS_CODE and
+// MSG91_TEMPLATE_START_REMINDER (flow template IDs)
+func NewMSG91Provider() (*MSG91Provider, error) {
+	authKey := os.Getenv("MSG91_AUTH_KEY")
+	if authKey == "" {
+		return nil, fmt.Errorf("MSG91 configuration missing in environment")
+	}
+
+	return &MSG91Provider{
+		authKey: authKey,
+		templates: map[string]string{
+			TemplateAccessCode:    os.Getenv("MSG91_TEMPLATE_ACCESS_CODE"),
+			TemplateStartReminder: os.Getenv("MSG91_TEMPLATE_START_REMINDER"),
+		},
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *MSG91Provider) Name() string {
+	return "msg91"
+}
+
+// Send triggers the template's flow with the params as var1, var2, ...
+func (p *MSG91Provider) Send(ctx context.Context, msg Message) (*Result, error) {
+	templateID := p.templates[msg.Template]
+	if templateID == "" {
+		return nil, fmt.Errorf("no MSG91 template configured for %q", msg.Template)
+	}
+
+	recipient := map[string]string{
+		// MSG91 expects the number with country code and without "+"
+		"mobiles": strings.TrimPrefix(msg.To, "+"),
+	}
+	for i, param := range msg.Params {
+		recipient["var"+strconv.Itoa(i+1)] = param
+	}
+	payload := map[string]any{
+		"template_id": templateID,
+		"short_url":   "0",
+		"recipients":  []map[string]string{recipient},
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal SMS request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", msg91URL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("authkey", p.authKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send SMS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("SMS send failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	// MSG91 answers 200 with {"type": "error"} for rejected requests
+	var msg91Resp struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &msg91Resp); err != nil {
+		return nil, fmt.Errorf("failed to parse MSG91 response: %w", err)
+	}
+	if msg91Resp.Type != "success" {
+		return nil, fmt.Errorf("SMS send failed: %s", msg91Resp.Message)
+	}
+
+	return &Result{
+		Provider:  p.Name(),
+		RequestID: msg91Resp.Message,
+		Code:      strconv.Itoa(resp.StatusCode),
+		Message:   msg91Resp.Type,
+		Raw:       json.RawMessage(body),
+	}, nil
+}
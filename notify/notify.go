@@ -0,0 +1,236 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Channels a message can be sent over
+const (
+	ChannelSMS      = "sms"
+	ChannelWhatsApp = "whatsapp"
+)
+
+// Templates. Params are positional: {1}, {2}, ... in the SMS text, var1, var2, ... for
+// MSG91 flows and the body parameters of WhatsApp templates.
+const (
+	// TemplateAccessCode params: name, access code, test URL
+	TemplateAccessCode = "access_code"
+	// TemplateStartReminder params: name, start time in the student's timezone, access code
+	TemplateStartReminder = "start_reminder"
+)
+
+// Delivery statuses stored in notification_logs.status
+const (
+	StatusSent        = "sent"
+	StatusFailed      = "failed"
+	StatusDelivered   = "delivered"
+	StatusUndelivered = "undelivered"
+	StatusRead        = "read"
+)
+
+// smsText is the text sent by providers that take a free-form body (Twilio)
+var smsText = map[string]string{
+	TemplateAccessCode:    "Dear {1}, your SmartMCQ test access code is {2}. Start the test at {3}",
+	TemplateStartReminder: "Dear {1}, your SmartMCQ test opens at {2}. Your access code is {3}",
+}
+
+// ErrNotConfigured is returned when no provider is configured for a channel
+var ErrNotConfigured = errors.New("channel not configured")
+
+// Message is one templated notification to a phone number in E.164 format
+type Message struct {
+	To       string
+	Template string
+	Params   []string
+}
+
+// Result is a provider-neutral view of a send response, in the shape notification_logs stores
+type Result struct {
+	Provider  string          `json:"provider"`
+	RequestID string          `json:"request_id"`
+	Code      string          `json:"code"`
+	Message   string          `json:"message"`
+	Raw       json.RawMessage `json:"raw,omitempty"`
+}
+
+// Provider sends a single message through one SMS or WhatsApp service
+type Provider interface {
+	Name() string
+	Send(ctx context.Context, msg Message) (*Result, error)
+}
+
+var (
+	providersOnce sync.Once
+	providers     = map[string]Provider{}
+	providerErrs  = map[string]error{}
+)
+
+// loadProviders reads SMS_PROVIDER (twilio or msg91) and WHATSAPP_PROVIDER (meta).
+// A channel without a provider stays disabled.
+func loadProviders() {
+	configure := func(channel, envVar string, build func(name string) (Provider, error)) {
+		name := strings.ToLower(strings.TrimSpace(os.Getenv(envVar)))
+		if name == "" {
+			providerErrs[channel] = fmt.Errorf("%w: set %s", ErrNotConfigured, envVar)
+			return
+		}
+		provider, err := build(name)
+		if err != nil {
+			providerErrs[channel] = err
+			log.Error().Err(err).Str("channel", channel).Msg("Notification provider not configured")
+			return
+		}
+		providers[channel] = provider
+		log.Info().Str("channel", channel).Str("provider", provider.Name()).Msg("Notification provider configured")
+	}
+
+	configure(ChannelSMS, "SMS_PROVIDER", func(name string) (Provider, error) {
+		switch name {
+		case "twilio":
+			return NewTwilioProvider()
+		case "msg91":
+			return NewMSG91Provider()
+		}
+		return nil, fmt.Errorf("unknown SMS provider %q (expected twilio or msg91)", name)
+	})
+	configure(ChannelWhatsApp, "WHATSAPP_PROVIDER", func(name string) (Provider, error) {
+		if name == "meta" {
+			return NewWhatsAppProvider()
+		}
+		return nil, fmt.Errorf("unknown WhatsApp provider %q (expected meta)", name)
+	})
+}
+
+// ValidChannel reports whether channel is a known channel
+func ValidChannel(channel string) bool {
+	return channel == ChannelSMS || channel == ChannelWhatsApp
+}
+
+// ChannelStatus returns the provider name for each channel, or the error that keeps it from sending
+func ChannelStatus() map[string]string {
+	providersOnce.Do(loadProviders)
+	status := make(map[string]string)
+	for _, channel := range []string{ChannelSMS, ChannelWhatsApp} {
+		if provider, ok := providers[channel]; ok {
+			status[channel] = provider.Name()
+		} else {
+			status[channel] = "disabled: " + providerErrs[channel].Error()
+		}
+	}
+	return status
+}
+
+// Enabled returns nil if the channel can send, or why it cannot
+func Enabled(channel string) error {
+	providersOnce.Do(loadProviders)
+	if _, ok := providers[channel]; ok {
+		return nil
+	}
+	if err := providerErrs[channel]; err != nil {
+		return err
+	}
+	return fmt.Errorf("unknown channel %q", channel)
+}
+
+// Send delivers a message over channel and logs it in notification_logs, failed sends included
+func Send(ctx context.Context, channel string, studentID int, msg Message) (*Result, error) {
+	if err := Enabled(channel); err != nil {
+		return nil, err
+	}
+	provider := providers[channel]
+
+	result, err := provider.Send(ctx, msg)
+
+	status := StatusSent
+	var requestID, responseCode, responseMessage, providerResponse *string
+	if err != nil {
+		status = StatusFailed
+		errMessage := err.Error()
+		responseMessage = &errMessage
+	} else {
+		requestID, responseCode, responseMessage = &result.RequestID, &result.Code, &result.Message
+		if len(result.Raw) > 0 {
+			raw := string(result.Raw)
+			providerResponse = &raw
+		}
+	}
+
+	logQuery := `
+		INSERT INTO notification_logs (student_id, channel, phone, template, status, provider, request_id, response_code, response_message, provider_response, sent_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, $10, NOW())
+	`
+	logCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, logErr := db.Pool.Exec(logCtx, logQuery, studentID, channel, msg.To, msg.Template, status, provider.Name(),
+		requestID, responseCode, responseMessage, providerResponse); logErr != nil {
+		log.Warn().Err(logErr).Int("student_id", studentID).Str("channel", channel).Msg("Failed to log notification")
+	}
+
+	return result, err
+}
+
+// UpdateStatus records a delivery report from a provider. Reports can arrive out of order,
+// so a final status (delivered, undelivered, failed, read) is not replaced by "sent".
+func UpdateStatus(ctx context.Context, provider, requestID, status, code, message string) error {
+	query := `
+		UPDATE notification_logs
+		SET status = $3,
+		    response_code = COALESCE(NULLIF($4, ''), response_code),
+		    response_message = COALESCE(NULLIF($5, ''), response_message),
+		    status_updated_at = NOW()
+		WHERE provider = $1 AND request_id = $2
+		  AND NOT ($3 = 'sent' AND status <> 'sent')
+		  AND NOT ($3 = 'delivered' AND status = 'read')
+	`
+	_, err := db.Pool.Exec(ctx, query, provider, requestID, status, code, message)
+	return err
+}
+
+// render fills a template's positional placeholders
+func render(text string, params []string) string {
+	for i, param := range params {
+		text = strings.ReplaceAll(text, "{"+strconv.Itoa(i+1)+"}", param)
+	}
+	return text
+}
+
+var phoneSeparators = regexp.MustCompile(`[\s()-]`)
+
+// NormalizePhone converts a stored phone number to E.164 (+<country code><number>).
+// Numbers without a country code are accepted only for India (country IN, 10 digits).
+func NormalizePhone(phone, country string) (string, error) {
+	digits := phoneSeparators.ReplaceAllString(strings.TrimSpace(phone), "")
+	switch {
+	case strings.HasPrefix(digits, "+"):
+	case strings.HasPrefix(digits, "00"):
+		digits = "+" + digits[2:]
+	case strings.EqualFold(country, "IN") && len(digits) == 10:
+		digits = "+91" + digits
+	case strings.EqualFold(country, "IN") && len(digits) == 11 && digits[0] == '0':
+		digits = "+91" + digits[1:]
+	default:
+		return "", fmt.Errorf("phone %q has no country code", phone)
+	}
+
+	if len(digits) < 8 || len(digits) > 16 {
+		return "", fmt.Errorf("phone %q is not a valid international number", phone)
+	}
+	for _, r := range digits[1:] {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("phone %q is not a valid international number", phone)
+		}
+	}
+	return digits, nil
+}
@@ -0,0 +1,167 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mcq-exam/config"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const twilioURL = "https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json"
+
+// TwilioProvider sends SMS through the Twilio Messages API
+type TwilioProvider struct {
+	accountSID     string
+	authToken      string
+	from           string
+	statusCallback string
+	client         *http.Client
+}
+
+// NewTwilioProvider reads TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN, TWILIO_FROM (number or
+// messaging service SID) and TWILIO_STATUS_CALLBACK_URL, which defaults to
+// BASE_URL + /api/webhooks/twilio when BASE_URL is set
+func NewTwilioProvider() (*TwilioProvider, error) {
+	accountSID := os.Getenv("TWILIO_ACCOUNT_SID")
+	authToken := os.Getenv("TWILIO_AUTH_TOKEN")
+	from := os.Getenv("TWILIO_FROM")
+	if accountSID == "" || authToken == "" || from == "" {
+		return nil, fmt.Errorf("Twilio configuration missing in environment")
+	}
+
+	callback := os.Getenv("TWILIO_STATUS_CALLBACK_URL")
+	if base := config.Get().BaseURL; callback == "" && base != "" {
+		callback = base + "/api/webhooks/twilio"
+	}
+
+	return &TwilioProvider{
+		accountSID:     accountSID,
+		authToken:      authToken,
+		from:           from,
+		statusCallback: callback,
+		client:         &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *TwilioProvider) Name() string {
+	return "twilio"
+}
+
+// Send renders the SMS text and posts it to Twilio
+func (p *TwilioProvider) Send(ctx context.Context, msg Message) (*Result, error) {
+	text, ok := smsText[msg.Template]
+	if !ok {
+		return nil, fmt.Errorf("unknown template %q", msg.Template)
+	}
+
+	form := url.Values{}
+	form.Set("To", msg.To)
+	form.Set("Body", render(text, msg.Params))
+	if strings.HasPrefix(p.from, "MG") {
+		form.Set("MessagingServiceSid", p.from)
+	} else {
+		form.Set("From", p.from)
+	}
+	if p.statusCallback != "" {
+		form.Set("StatusCallback", p.statusCallback)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf(twilioURL, p.accountSID), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.SetBasicAuth(p.accountSID, p.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send SMS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("SMS send failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var twilioResp struct {
+		SID    string `json:"sid"`
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &twilioResp); err != nil {
+		return nil, fmt.Errorf("failed to parse Twilio response: %w", err)
+	}
+
+	return &Result{
+		Provider:  p.Name(),
+		RequestID: twilioResp.SID,
+		Code:      strconv.Itoa(resp.StatusCode),
+		Message:   twilioResp.Status,
+		Raw:       json.RawMessage(body),
+	}, nil
+}
+
+// TwilioStatus maps a Twilio MessageStatus to a notification status. Intermediate
+// states (accepted, queued, sending) map to "sent".
+func TwilioStatus(status string) string {
+	switch status {
+	case "delivered":
+		return StatusDelivered
+	case "undelivered":
+		return StatusUndelivered
+	case "failed":
+		return StatusFailed
+	case "read":
+		return StatusRead
+	}
+	return StatusSent
+}
+
+// ValidTwilioSignature checks X-Twilio-Signature: base64 HMAC-SHA1, keyed with the auth
+// token, of the callback URL followed by the sorted form parameters. Returns false when
+// TWILIO_AUTH_TOKEN is unset, since a callback cannot be verified without it.
+func ValidTwilioSignature(callbackURL, signature string, params map[string]string) bool {
+	authToken := os.Getenv("TWILIO_AUTH_TOKEN")
+	if authToken == "" {
+		return false
+	}
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var payload strings.Builder
+	payload.WriteString(callbackURL)
+	for _, key := range keys {
+		payload.WriteString(key)
+		payload.WriteString(params[key])
+	}
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(payload.String()))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// TwilioCallbackURL is the URL Twilio signs status callbacks with
+func TwilioCallbackURL() string {
+	providersOnce.Do(loadProviders)
+	if twilio, ok := providers[ChannelSMS].(*TwilioProvider); ok {
+		return twilio.statusCallback
+	}
+	return os.Getenv("TWILIO_STATUS_CALLBACK_URL")
+}
@@ -0,0 +1,155 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const whatsAppURL = "https://graph.facebook.com/v19.0/%s/messages"
+
+// WhatsAppProvider sends approved template messages through the WhatsApp Cloud API
+type WhatsAppProvider struct {
+	token         string
+	phoneNumberID string
+	language      string
+	templates     map[string]string
+	client        *http.Client
+}
+
+// NewWhatsAppProvider reads WHATSAPP_TOKEN, WHATSAPP_PHONE_NUMBER_ID,
+// WHATSAPP_TEMPLATE_ACCESS_CODE, WHATSAPP_TEMPLATE_START_REMINDER (template names)
+// and WHATSAPP_TEMPLATE_LANGUAGE (default "en")
+func NewWhatsAppProvider() (*WhatsAppProvider, error) {
+	token := os.Getenv("WHATSAPP_TOKEN")
+	phoneNumberID := os.Getenv("WHATSAPP_PHONE_NUMBER_ID")
+	if token == "" || phoneNumberID == "" {
+		return nil, fmt.Errorf("WhatsApp configuration missing in environment")
+	}
+
+	language := os.Getenv("WHATSAPP_TEMPLATE_LANGUAGE")
+	if language == "" {
+		language = "en"
+	}
+
+	return &WhatsAppProvider{
+		token:         token,
+		phoneNumberID: phoneNumberID,
+		language:      language,
+		templates: map[string]string{
+			TemplateAccessCode:    os.Getenv("WHATSAPP_TEMPLATE_ACCESS_CODE"),
+			TemplateStartReminder: os.Getenv("WHATSAPP_TEMPLATE_START_REMINDER"),
+		},
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *WhatsAppProvider) Name() string {
+	return "whatsapp_cloud"
+}
+
+// Send posts a template message with the params as body parameters
+func (p *WhatsAppProvider) Send(ctx context.Context, msg Message) (*Result, error) {
+	templateName := p.templates[msg.Template]
+	if templateName == "" {
+		return nil, fmt.Errorf("no WhatsApp template configured for %q", msg.Template)
+	}
+
+	parameters := make([]map[string]string, 0, len(msg.Params))
+	for _, param := range msg.Params {
+		parameters = append(parameters, map[string]string{"type": "text", "text": param})
+	}
+	payload := map[string]any{
+		"messaging_product": "whatsapp",
+		"to":                strings.TrimPrefix(msg.To, "+"),
+		"type":              "template",
+		"template": map[string]any{
+			"name":     templateName,
+			"language": map[string]string{"code": p.language},
+			"components": []map[string]any{
+				{"type": "body", "parameters": parameters},
+			},
+		},
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal WhatsApp request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf(whatsAppURL, p.phoneNumberID), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send WhatsApp message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("WhatsApp send failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var waResp struct {
+		Messages []struct {
+			ID            string `json:"id"`
+			MessageStatus string `json:"message_status"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &waResp); err != nil {
+		return nil, fmt.Errorf("failed to parse WhatsApp response: %w", err)
+	}
+	if len(waResp.Messages) == 0 {
+		return nil, fmt.Errorf("WhatsApp response has no message id: %s", string(body))
+	}
+
+	return &Result{
+		Provider:  p.Name(),
+		RequestID: waResp.Messages[0].ID,
+		Code:      strconv.Itoa(resp.StatusCode),
+		Message:   waResp.Messages[0].MessageStatus,
+		Raw:       json.RawMessage(body),
+	}, nil
+}
+
+// WhatsAppStatus maps a Cloud API status webhook value to a notification status
+func WhatsAppStatus(status string) string {
+	switch status {
+	case "delivered":
+		return StatusDelivered
+	case "read":
+		return StatusRead
+	case "failed":
+		return StatusFailed
+	}
+	return StatusSent
+}
+
+// ValidWhatsAppSignature checks X-Hub-Signature-256 ("sha256=<hex HMAC of the body>")
+// against WHATSAPP_APP_SECRET. Returns true when no app secret is configured.
+func ValidWhatsAppSignature(body []byte, signature string) bool {
+	secret := os.Getenv("WHATSAPP_APP_SECRET")
+	if secret == "" {
+		return true
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
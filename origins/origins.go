@@ -0,0 +1,355 @@
+// Package origins decides which browser origins may call the API: the origins configured for
+// the environment (CORS_ALLOWED_ORIGINS) plus an allow-list kept in the database that admins
+// edit at runtime. Requests from any other origin are recorded, so a frontend that was left
+// off the list shows up in the admin view and the logs rather than failing silently.
+package origins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/config"
+	"mcq-exam/db"
+	"mcq-exam/jobs"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// refreshInterval is how often the stored allow-list is re-read, so entries added on another
+// instance take effect here too
+const refreshInterval = 30 * time.Second
+
+// maxBlocked bounds the distinct blocked origins remembered; the least recently seen is
+// dropped first
+const maxBlocked = 200
+
+// blockedLogEvery is how often a blocked origin is logged again while it keeps calling
+const blockedLogEvery = 10 * time.Minute
+
+var (
+	ErrInvalidOrigin = errors.New("origin must be scheme://host[:port] with scheme http or https, e.g. https://quiz.example.com; the host may start with *. to match subdomains")
+	ErrNotFound      = errors.New("origin not found")
+	ErrExists        = errors.New("origin is already allowed")
+)
+
+// Origin is an allowed origin stored in the database
+type Origin struct {
+	ID        int       `json:"id"`
+	Origin    string    `json:"origin"`
+	Note      *string   `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Blocked is an origin whose requests were refused CORS headers, or rejected when enforcing
+type Blocked struct {
+	Origin    string    `json:"origin"`
+	Count     int64     `json:"count"`
+	LastPath  string    `json:"last_path"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	loggedAt  time.Time
+}
+
+var (
+	configuredOnce sync.Once
+	configured     []string
+	anyOrigin      bool
+	enforceOnce    sync.Once
+	enforce        bool
+
+	mu     sync.RWMutex
+	stored []string
+
+	blockedMu sync.Mutex
+	blocked   = make(map[string]*Blocked)
+)
+
+// Normalize checks an origin and returns it as browsers send it: lowercase, without a path
+// or trailing slash, and without the scheme's default port
+func Normalize(origin string) (string, error) {
+	u, err := url.Parse(strings.ToLower(strings.TrimSpace(origin)))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return "", ErrInvalidOrigin
+	}
+	host := u.Hostname()
+	if host == "" || strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+		return "", ErrInvalidOrigin
+	}
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", ErrInvalidOrigin
+		}
+		host += ":" + port
+	}
+	return u.Scheme + "://" + host, nil
+}
+
+// Configured returns the environment's origins: CORS_ALLOWED_ORIGINS, a comma-separated
+// list, or the origin of FRONTEND_URL when unset. "*" allows every origin. Invalid entries
+// are logged and skipped.
+func Configured() []string {
+	configuredOnce.Do(func() {
+		value := strings.TrimSpace(os.Getenv("CORS_ALLOWED_ORIGINS"))
+		if value == "" {
+			if u, err := url.Parse(config.FrontendURL()); err == nil {
+				value = u.Scheme + "://" + u.Host
+			}
+		}
+		for _, entry := range strings.Split(value, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			if entry == "*" {
+				anyOrigin = true
+				configured = append(configured, entry)
+				continue
+			}
+			origin, err := Normalize(entry)
+			if err != nil {
+				log.Warn().Msgf("Invalid origin %q in CORS_ALLOWED_ORIGINS, skipping it", entry)
+				continue
+			}
+			configured = append(configured, origin)
+		}
+	})
+	return configured
+}
+
+// Enforce reports whether requests from an origin that is not allowed are rejected with 403
+// (CORS_ENFORCE, default true). When false they are still served, without CORS headers, so
+// browsers hide the response from the calling page.
+func Enforce() bool {
+	enforceOnce.Do(func() {
+		enforce = true
+		if value := strings.TrimSpace(os.Getenv("CORS_ENFORCE")); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				log.Warn().Msgf("Invalid CORS_ENFORCE=%q, using true", value)
+				return
+			}
+			enforce = parsed
+		}
+	})
+	return enforce
+}
+
+// matches reports whether origin is pattern, or a subdomain of a "*." pattern's domain on the
+// same scheme and port
+func matches(pattern, origin string) bool {
+	if pattern == origin {
+		return true
+	}
+	scheme, host, ok := strings.Cut(pattern, "://*.")
+	if !ok || !strings.HasPrefix(origin, scheme+"://") {
+		return false
+	}
+	return strings.HasSuffix(strings.TrimPrefix(origin, scheme+"://"), "."+host)
+}
+
+// Allowed reports whether a browser on origin may call the API
+func Allowed(origin string) bool {
+	Configured()
+	if anyOrigin {
+		return true
+	}
+	normalized, err := Normalize(origin)
+	if err != nil || strings.Contains(normalized, "*") {
+		return false
+	}
+	for _, pattern := range configured {
+		if matches(pattern, normalized) {
+			return true
+		}
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, pattern := range stored {
+		if matches(pattern, normalized) {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordBlocked remembers a request from an origin that is not allowed and logs it, at most
+// once every blockedLogEvery per origin
+func RecordBlocked(origin, path, ip string) {
+	if len(origin) > 200 {
+		origin = origin[:200]
+	}
+	now := time.Now()
+	blockedMu.Lock()
+	entry, ok := blocked[origin]
+	if !ok {
+		if len(blocked) >= maxBlocked {
+			evictOldest()
+		}
+		entry = &Blocked{Origin: origin, FirstSeen: now}
+		blocked[origin] = entry
+	}
+	entry.Count++
+	entry.LastPath = path
+	entry.LastSeen = now
+	logIt := now.Sub(entry.loggedAt) >= blockedLogEvery
+	if logIt {
+		entry.loggedAt = now
+	}
+	count := entry.Count
+	blockedMu.Unlock()
+
+	if logIt {
+		log.Warn().Str("origin", origin).Str("path", path).Str("ip", ip).Int64("count", count).
+			Bool("enforced", Enforce()).Msg("Request from an origin that is not allowed")
+	}
+}
+
+// evictOldest drops the least recently seen blocked origin; callers hold blockedMu
+func evictOldest() {
+	var oldest *Blocked
+	for _, entry := range blocked {
+		if oldest == nil || entry.LastSeen.Before(oldest.LastSeen) {
+			oldest = entry
+		}
+	}
+	if oldest != nil {
+		delete(blocked, oldest.Origin)
+	}
+}
+
+// RecentlyBlocked lists the blocked origins this instance has seen since it started, most
+// recent first
+func RecentlyBlocked() []Blocked {
+	blockedMu.Lock()
+	defer blockedMu.Unlock()
+	list := make([]Blocked, 0, len(blocked))
+	for _, entry := range blocked {
+		list = append(list, *entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeen.After(list[j].LastSeen) })
+	return list
+}
+
+const originColumns = `id, origin, note, created_at`
+
+func scanOrigin(row pgx.Row, o *Origin) error {
+	return row.Scan(&o.ID, &o.Origin, &o.Note, &o.CreatedAt)
+}
+
+// List returns the stored allow-list
+func List(ctx context.Context) ([]Origin, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT `+originColumns+` FROM cors_origins ORDER BY origin`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch origins: %w", err)
+	}
+	defer rows.Close()
+
+	list := []Origin{}
+	for rows.Next() {
+		var o Origin
+		if err := scanOrigin(rows, &o); err != nil {
+			return nil, fmt.Errorf("failed to scan origin: %w", err)
+		}
+		list = append(list, o)
+	}
+	return list, rows.Err()
+}
+
+// Add stores an allowed origin; it applies on this instance at once and on the others
+// within refreshInterval
+func Add(ctx context.Context, origin, note string) (*Origin, error) {
+	origin, err := Normalize(origin)
+	if err != nil {
+		return nil, err
+	}
+	var o Origin
+	query := `
+		INSERT INTO cors_origins (origin, note)
+		VALUES ($1, NULLIF($2, ''))
+		ON CONFLICT (origin) DO NOTHING
+		RETURNING ` + originColumns
+	err = scanOrigin(db.Pool.QueryRow(ctx, query, origin, strings.TrimSpace(note)), &o)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save origin: %w", err)
+	}
+	if err := Refresh(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to reload allowed origins")
+	}
+	return &o, nil
+}
+
+// Remove deletes a stored allowed origin
+func Remove(ctx context.Context, id int) error {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM cors_origins WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete origin: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if err := Refresh(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to reload allowed origins")
+	}
+	return nil
+}
+
+// Refresh reloads the stored allow-list. On failure the previous list stays in use.
+func Refresh(ctx context.Context) error {
+	list, err := List(ctx)
+	if err != nil {
+		return err
+	}
+	loaded := make([]string, 0, len(list))
+	for _, o := range list {
+		loaded = append(loaded, o.Origin)
+	}
+	mu.Lock()
+	stored = loaded
+	mu.Unlock()
+	return nil
+}
+
+// StartRefresher loads the stored allow-list and reloads it every refreshInterval until
+// jobs.Context() is cancelled
+func StartRefresher() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := Refresh(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to load allowed origins")
+	}
+	cancel()
+	log.Info().Strs("configured", Configured()).Bool("enforce", Enforce()).Msg("CORS origins loaded")
+
+	jobs.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refreshCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+				if err := Refresh(refreshCtx); err != nil && ctx.Err() == nil {
+					log.Warn().Err(err).Msg("Failed to reload allowed origins")
+				}
+				cancel()
+			}
+		}
+	})
+}
@@ -0,0 +1,327 @@
+// Package outbox sends emails written in the same transaction as the token or record they
+// carry, so a rolled back transaction leaves no email and a committed one is never left
+// without its email. Enqueue stores the rendered message; the relay started by Start claims
+// due messages, sends them and records the outcome, retrying failures with backoff up to
+// OUTBOX_MAX_ATTEMPTS. Every instance may run the relay: a message is claimed by one of them.
+package outbox
+
+import (
+	"context"
+	"mcq-exam/db"
+	"mcq-exam/jobs"
+	"mcq-exam/suppression"
+	"mcq-exam/tracking"
+	"mcq-exam/utils"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Message statuses
+const (
+	StatusPending = "pending"
+	StatusSending = "sending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	defaultMaxAttempts  = 5
+	// batchSize is how many due messages one relay pass claims; kept small so a batch is sent
+	// well within its lease even at low provider rate limits
+	batchSize = 10
+	// lease is how long a claimed message stays with its relay; a message still sending after
+	// that (its instance stopped mid-send) is claimed again
+	lease = 10 * time.Minute
+	// retryBase is the wait after the first failed attempt, doubling per attempt up to retryMax
+	retryBase = 30 * time.Second
+	retryMax  = time.Hour
+	// finishTimeout bounds recording an outcome, including after shutdown cancels the send
+	finishTimeout = 5 * time.Second
+)
+
+// Message is an email to send once the transaction that queued it commits
+type Message struct {
+	// StudentID and EmailType are set for tracked student emails; the body is instrumented
+	// for opens and clicks when sent and the send is recorded against the student
+	StudentID int
+	EmailType tracking.EmailType
+	// Source names what queued the message, e.g. "phase1" or "registration"
+	Source   string
+	ToEmail  string
+	ToName   string
+	Subject  string
+	HTMLBody string
+}
+
+// PollInterval is how often the relay looks for due messages (OUTBOX_POLL_INTERVAL, default
+// 5s). Notify wakes it sooner.
+func PollInterval() time.Duration {
+	if value := strings.TrimSpace(os.Getenv("OUTBOX_POLL_INTERVAL")); value != "" {
+		if interval, err := time.ParseDuration(value); err == nil && interval > 0 {
+			return interval
+		}
+		log.Warn().Msgf("Invalid OUTBOX_POLL_INTERVAL=%q, using %s", value, defaultPollInterval)
+	}
+	return defaultPollInterval
+}
+
+// MaxAttempts is how many sends a message gets before it is marked failed
+// (OUTBOX_MAX_ATTEMPTS, default 5)
+func MaxAttempts() int {
+	if value := strings.TrimSpace(os.Getenv("OUTBOX_MAX_ATTEMPTS")); value != "" {
+		if attempts, err := strconv.Atoi(value); err == nil && attempts > 0 {
+			return attempts
+		}
+		log.Warn().Msgf("Invalid OUTBOX_MAX_ATTEMPTS=%q, using %d", value, defaultMaxAttempts)
+	}
+	return defaultMaxAttempts
+}
+
+// Enqueue stores messages for the relay. Pass the transaction writing the token or record
+// the messages carry, so they are stored only if it commits; call Notify after the commit
+// to send them without waiting for the next poll.
+func Enqueue(ctx context.Context, q db.Querier, msgs ...Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	studentIDs := make([]int, len(msgs))
+	emailTypes := make([]string, len(msgs))
+	sources := make([]string, len(msgs))
+	toEmails := make([]string, len(msgs))
+	toNames := make([]string, len(msgs))
+	subjects := make([]string, len(msgs))
+	bodies := make([]string, len(msgs))
+	for i, m := range msgs {
+		studentIDs[i] = m.StudentID
+		emailTypes[i] = string(m.EmailType)
+		sources[i] = m.Source
+		toEmails[i] = m.ToEmail
+		toNames[i] = m.ToName
+		subjects[i] = m.Subject
+		bodies[i] = m.HTMLBody
+	}
+
+	query := `
+		INSERT INTO email_outbox (student_id, email_type, source, to_email, to_name, subject, html_body)
+		SELECT NULLIF(m.student_id, 0), NULLIF(m.email_type, ''), m.source, m.to_email, m.to_name, m.subject, m.html_body
+		FROM unnest($1::int[], $2::text[], $3::text[], $4::text[], $5::text[], $6::text[], $7::text[])
+		     AS m(student_id, email_type, source, to_email, to_name, subject, html_body)
+	`
+	_, err := q.Exec(ctx, query, studentIDs, emailTypes, sources, toEmails, toNames, subjects, bodies)
+	return err
+}
+
+// wake nudges a waiting relay; buffered so Notify never blocks
+var wake = make(chan struct{}, 1)
+
+// Notify wakes this instance's relay to send newly committed messages now
+func Notify() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the relay until jobs.Context() is cancelled, sending due messages every
+// PollInterval and whenever Notify is called
+func Start() {
+	interval := PollInterval()
+	log.Info().Dur("interval", interval).Int("max_attempts", MaxAttempts()).Msg("Starting email outbox relay")
+
+	jobs.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := Drain(ctx); err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Msg("Email outbox relay failed")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-wake:
+			}
+		}
+	})
+}
+
+// Drain sends due messages batch by batch until none are left or ctx is cancelled
+func Drain(ctx context.Context) error {
+	for ctx.Err() == nil {
+		claimed, err := relay(ctx)
+		if err != nil {
+			return err
+		}
+		if claimed < batchSize {
+			return nil
+		}
+	}
+	return nil
+}
+
+// claimed is a message taken by this relay
+type claimed struct {
+	ID       int64
+	Message  Message
+	Attempts int
+}
+
+// relay claims one batch of due messages and sends them, returning how many it claimed
+func relay(ctx context.Context) (int, error) {
+	query := `
+		UPDATE email_outbox o
+		SET status = 'sending', attempts = o.attempts + 1,
+		    next_attempt_at = NOW() + make_interval(secs => $2), updated_at = NOW()
+		WHERE o.id IN (
+			SELECT id FROM email_outbox
+			WHERE status IN ('pending', 'sending') AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at, id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING o.id, COALESCE(o.student_id, 0), COALESCE(o.email_type, ''), o.source,
+		          o.to_email, o.to_name, o.subject, o.html_body, o.attempts
+	`
+	rows, err := db.Pool.Query(ctx, query, batchSize, lease.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	var batch []claimed
+	for rows.Next() {
+		var c claimed
+		var emailType string
+		if err := rows.Scan(&c.ID, &c.Message.StudentID, &emailType, &c.Message.Source,
+			&c.Message.ToEmail, &c.Message.ToName, &c.Message.Subject, &c.Message.HTMLBody, &c.Attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		c.Message.EmailType = tracking.EmailType(emailType)
+		batch = append(batch, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	maxAttempts := MaxAttempts()
+	for i, c := range batch {
+		// Messages not reached before shutdown go back to the queue untouched
+		if ctx.Err() != nil {
+			release(batch[i:])
+			break
+		}
+		deliver(ctx, c, maxAttempts)
+	}
+	return len(batch), nil
+}
+
+// deliver sends one claimed message and records the outcome
+func deliver(ctx context.Context, c claimed, maxAttempts int) {
+	m := c.Message
+	// Claimed again after its lease ran out on a stopped instance, with no attempts left
+	if c.Attempts > maxAttempts {
+		finish(c.ID, StatusFailed, "no attempts left after an interrupted send", nil, 0)
+		return
+	}
+	if suppression.IsSuppressed(ctx, m.ToEmail) {
+		finish(c.ID, StatusSkipped, "address is suppressed", nil, 0)
+		return
+	}
+
+	params := utils.SendEmailParams{
+		ToEmail:  m.ToEmail,
+		ToName:   m.ToName,
+		Subject:  m.Subject,
+		HTMLBody: m.HTMLBody,
+	}
+	tracked := m.StudentID != 0 && m.EmailType != ""
+	if tracked {
+		params.Tracking = &tracking.Target{StudentID: m.StudentID, EmailType: m.EmailType}
+	}
+
+	result, err := utils.SendEmail(ctx, params)
+	if err != nil {
+		// Shutdown cut the wait for the rate limit short; the attempt does not count
+		if ctx.Err() != nil {
+			release([]claimed{c})
+			return
+		}
+		if c.Attempts >= maxAttempts {
+			log.Error().Err(err).Int64("outbox_id", c.ID).Int("attempts", c.Attempts).Str("source", m.Source).
+				Msg("Outbox email failed, giving up")
+			finish(c.ID, StatusFailed, err.Error(), nil, 0)
+			return
+		}
+		retryIn := retryDelay(c.Attempts)
+		log.Warn().Err(err).Int64("outbox_id", c.ID).Int("attempts", c.Attempts).Dur("retry_in", retryIn).
+			Msg("Outbox email failed, will retry")
+		finish(c.ID, StatusPending, err.Error(), nil, retryIn)
+		return
+	}
+
+	finish(c.ID, StatusSent, "", result, 0)
+	if tracked {
+		tracking.RecordSent(m.StudentID, m.EmailType)
+	}
+	log.Info().Int64("outbox_id", c.ID).Int("student_id", m.StudentID).Str("email", m.ToEmail).Str("source", m.Source).
+		Msg("Sent outbox email")
+}
+
+// retryDelay is the wait after a message's attempts-th failure
+func retryDelay(attempts int) time.Duration {
+	delay := retryBase
+	for i := 1; i < attempts && delay < retryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMax)
+}
+
+// finish records a message's outcome. A pending status schedules the next attempt after
+// retryIn; sent stores the provider's response.
+func finish(id int64, status, lastError string, result *utils.EmailResult, retryIn time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), finishTimeout)
+	defer cancel()
+
+	var provider, requestID string
+	if result != nil {
+		provider, requestID = result.Provider, result.RequestID
+	}
+	query := `
+		UPDATE email_outbox
+		SET status = $2, last_error = NULLIF($3, ''),
+		    provider = COALESCE(NULLIF($4, ''), provider), request_id = COALESCE(NULLIF($5, ''), request_id),
+		    next_attempt_at = NOW() + make_interval(secs => $6),
+		    sent_at = CASE WHEN $2 = 'sent' THEN NOW() ELSE sent_at END,
+		    updated_at = NOW()
+		WHERE id = $1
+	`
+	if _, err := db.Pool.Exec(ctx, query, id, status, lastError, provider, requestID, retryIn.Seconds()); err != nil {
+		log.Error().Err(err).Int64("outbox_id", id).Str("status", status).Msg("Failed to record outbox email outcome")
+	}
+}
+
+// release returns claimed messages to the queue without counting the attempt
+func release(batch []claimed) {
+	ctx, cancel := context.WithTimeout(context.Background(), finishTimeout)
+	defer cancel()
+
+	ids := make([]int64, len(batch))
+	for i, c := range batch {
+		ids[i] = c.ID
+	}
+	query := `
+		UPDATE email_outbox
+		SET status = 'pending', attempts = attempts - 1, next_attempt_at = NOW(), updated_at = NOW()
+		WHERE id = ANY($1) AND status = 'sending'
+	`
+	if _, err := db.Pool.Exec(ctx, query, ids); err != nil {
+		log.Error().Err(err).Int("messages", len(ids)).Msg("Failed to release outbox emails")
+	}
+}
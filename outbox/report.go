@@ -0,0 +1,130 @@
+package outbox
+
+import (
+	"context"
+	"mcq-exam/db"
+	"time"
+)
+
+// Entry is a stored message without its body
+type Entry struct {
+	ID            int64      `json:"id"`
+	StudentID     *int       `json:"student_id"`
+	EmailType     *string    `json:"email_type"`
+	Source        string     `json:"source"`
+	ToEmail       string     `json:"to_email"`
+	Subject       string     `json:"subject"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastError     *string    `json:"last_error"`
+	Provider      *string    `json:"provider"`
+	RequestID     *string    `json:"request_id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at"`
+}
+
+// Report is the outbox's state: message counts by status, how long the oldest unsent
+// message has waited, and the latest messages matching a filter
+type Report struct {
+	Counts map[string]int `json:"counts"`
+	// OldestPendingSeconds is the age of the oldest pending or sending message, 0 when none
+	OldestPendingSeconds int     `json:"oldest_pending_seconds"`
+	Messages             []Entry `json:"messages"`
+}
+
+// Query returns the outbox report with the latest limit messages, optionally only those with
+// status or for studentID (0 for any)
+func Query(ctx context.Context, status string, studentID, limit int) (*Report, error) {
+	report := &Report{
+		Counts:   map[string]int{StatusPending: 0, StatusSending: 0, StatusSent: 0, StatusFailed: 0, StatusSkipped: 0},
+		Messages: []Entry{},
+	}
+
+	countQuery := `SELECT status, COUNT(*) FROM email_outbox GROUP BY status`
+	rows, err := db.Pool.Query(ctx, countQuery)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s string
+		var n int
+		if err := rows.Scan(&s, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.Counts[s] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	oldestQuery := `
+		SELECT COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(created_at))::int, 0)
+		FROM email_outbox
+		WHERE status IN ('pending', 'sending')
+	`
+	if err := db.Pool.QueryRow(ctx, oldestQuery).Scan(&report.OldestPendingSeconds); err != nil {
+		return nil, err
+	}
+
+	listQuery := `
+		SELECT id, student_id, email_type, source, to_email, subject, status, attempts, next_attempt_at,
+		       last_error, provider, request_id, created_at, sent_at
+		FROM email_outbox
+		WHERE ($1 = '' OR status = $1) AND ($2 = 0 OR student_id = $2)
+		ORDER BY id DESC
+		LIMIT $3
+	`
+	rows, err = db.Pool.Query(ctx, listQuery, status, studentID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.ID, &e.StudentID, &e.EmailType, &e.Source, &e.ToEmail, &e.Subject, &e.Status, &e.Attempts,
+			&e.NextAttemptAt, &e.LastError, &e.Provider, &e.RequestID, &e.CreatedAt, &e.SentAt); err != nil {
+			return nil, err
+		}
+		report.Messages = append(report.Messages, e)
+	}
+	return report, rows.Err()
+}
+
+// Retry queues failed messages for another round of MaxAttempts sends: those in ids, or every
+// failed message when ids is empty. Returns the IDs queued; messages that are not failed are
+// left alone.
+func Retry(ctx context.Context, ids []int64) ([]int64, error) {
+	var filter []int64
+	if len(ids) > 0 {
+		filter = ids
+	}
+	query := `
+		UPDATE email_outbox
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), updated_at = NOW()
+		WHERE status = 'failed' AND ($1::bigint[] IS NULL OR id = ANY($1))
+		RETURNING id
+	`
+	rows, err := db.Pool.Query(ctx, query, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	queued := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		queued = append(queued, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(queued) > 0 {
+		Notify()
+	}
+	return queued, nil
+}
@@ -0,0 +1,266 @@
+// Package personalize fills in the {{variables}} of emails sent to many students (send-all
+// and campaigns): the student's own fields, values computed for them and, for campaigns, a
+// JSON payload per recipient. Strict sends fail a recipient whose email would go out with a
+// variable left empty; otherwise such variables are replaced with nothing.
+package personalize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/attempts"
+	"mcq-exam/config"
+	"mcq-exam/db"
+	"mcq-exam/ranking"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Computed variables
+const (
+	// VarAccessCode is the exam access code issued when the student attended the conference
+	VarAccessCode = "access_code"
+	// VarConferenceLink is the student's link to the conference
+	VarConferenceLink = "conference_link"
+	// VarRank is the student's rank on the overall leaderboard (ranking and attempt policy)
+	VarRank = "rank"
+	// VarScore is the score of the student's counted attempt
+	VarScore = "score"
+)
+
+// StudentFields are the student's own fields usable as variables, e.g. {{institution}}.
+// tags are joined with ", " and created_at is a date such as "05 Mar 2025".
+var StudentFields = []string{"id", "name", "email", "institution", "country", "phone", "designation", "timezone", "locale", "tags", "created_at"}
+
+// Computed lists the computed variables
+var Computed = []string{VarAccessCode, VarConferenceLink, VarRank, VarScore}
+
+var (
+	// ErrUnresolved is wrapped with the variables a strict send could not fill in
+	ErrUnresolved = errors.New("unresolved variables")
+	// ErrInvalidPayload is wrapped with the reason custom variables were rejected
+	ErrInvalidPayload = errors.New("invalid custom variables")
+)
+
+// maxPayloadVariables bounds the custom variables of one recipient
+const maxPayloadVariables = 50
+
+var (
+	variablePattern = regexp.MustCompile(`\{\{([A-Za-z0-9_]+)\}\}`)
+	namePattern     = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+)
+
+// Builtin reports whether name is a student field or a computed variable
+func Builtin(name string) bool {
+	for _, list := range [][]string{StudentFields, Computed} {
+		for _, builtin := range list {
+			if name == builtin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Names returns the distinct variables used in texts, sorted
+func Names(texts ...string) []string {
+	seen := make(map[string]bool)
+	for _, text := range texts {
+		for _, match := range variablePattern.FindAllStringSubmatch(text, -1) {
+			seen[match[1]] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Unknown returns the variables of texts that are neither built in nor custom, which a
+// strict send-all can never fill in
+func Unknown(texts ...string) []string {
+	unknown := []string{}
+	for _, name := range Names(texts...) {
+		if !Builtin(name) {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown
+}
+
+// ValidatePayload checks a recipient's custom variables and turns them into text: names are
+// lowercase letters, digits and '_' that do not shadow a built-in variable, and values are
+// strings, numbers or booleans (null is left unresolved)
+func ValidatePayload(payload map[string]any) (map[string]string, error) {
+	if len(payload) > maxPayloadVariables {
+		return nil, fmt.Errorf("%w: at most %d variables per recipient", ErrInvalidPayload, maxPayloadVariables)
+	}
+	values := make(map[string]string, len(payload))
+	for name, value := range payload {
+		if !namePattern.MatchString(name) {
+			return nil, fmt.Errorf("%w: name %q must be 1-50 lowercase letters, digits or '_', starting with a letter", ErrInvalidPayload, name)
+		}
+		if Builtin(name) {
+			return nil, fmt.Errorf("%w: %q is a built-in variable", ErrInvalidPayload, name)
+		}
+		switch v := value.(type) {
+		case nil:
+		case string:
+			values[name] = v
+		case bool:
+			values[name] = strconv.FormatBool(v)
+		case float64:
+			values[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return nil, fmt.Errorf("%w: %q must be a string, number or boolean", ErrInvalidPayload, name)
+		}
+	}
+	return values, nil
+}
+
+type result struct {
+	rank  int
+	score int
+}
+
+// Renderer fills in the variables of one send's texts. It loads ranks once, and a
+// student's fields only when the texts use more than {{name}}.
+type Renderer struct {
+	strict bool
+	uses   map[string]bool
+	// results holds each student's rank and score when the texts use them
+	results map[int]result
+}
+
+// New prepares the variables used in texts, e.g. every subject and body of a send
+func New(ctx context.Context, strict bool, texts ...string) (*Renderer, error) {
+	r := &Renderer{strict: strict, uses: make(map[string]bool)}
+	for _, name := range Names(texts...) {
+		r.uses[name] = true
+	}
+	if r.uses[VarRank] || r.uses[VarScore] {
+		results, err := loadResults(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r.results = results
+	}
+	return r, nil
+}
+
+// loadResults ranks every counted attempt like the overall leaderboard
+func loadResults(ctx context.Context) (map[int]result, error) {
+	policy := ranking.Current(ctx)
+	query := `
+		SELECT sess.student_id, ` + policy.DenseRank("sess") + `, COALESCE(sess.score, 0)
+		FROM ` + attempts.CountedSessions() + ` sess`
+	rows, err := db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rank students: %w", err)
+	}
+	defer rows.Close()
+
+	results := make(map[int]result)
+	for rows.Next() {
+		var studentID int
+		var res result
+		if err := rows.Scan(&studentID, &res.rank, &res.score); err != nil {
+			return nil, fmt.Errorf("failed to scan rank: %w", err)
+		}
+		results[studentID] = res
+	}
+	return results, rows.Err()
+}
+
+// needsStudent reports whether the texts use a variable read from the student's row
+func (r *Renderer) needsStudent() bool {
+	for name := range r.uses {
+		if name != "name" && name != VarRank && name != VarScore && Builtin(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// values resolves the built-in variables used for a student; missing ones are left out
+func (r *Renderer) values(ctx context.Context, studentID int, name string) (map[string]string, error) {
+	values := map[string]string{"name": name}
+	if r.needsStudent() {
+		query := `
+			SELECT s.id::text, s.name, s.email, COALESCE(s.institution, ''), COALESCE(s.country, ''),
+			       COALESCE(s.phone, ''), COALESCE(s.designation, ''), COALESCE(s.timezone, ''),
+			       COALESCE(s.locale, ''), array_to_string(s.tags, ', '), to_char(s.created_at, 'DD Mon YYYY'),
+			       COALESCE(et.access_code, ''), COALESCE(et.conference_token, '')
+			FROM students s
+			LEFT JOIN email_tracking et ON et.student_id = s.id AND et.email_type = 'firstMail'
+			WHERE s.id = $1
+		`
+		fields := make([]string, len(StudentFields))
+		targets := make([]any, 0, len(fields)+2)
+		for i := range fields {
+			targets = append(targets, &fields[i])
+		}
+		var accessCode, conferenceToken string
+		targets = append(targets, &accessCode, &conferenceToken)
+		if err := db.Pool.QueryRow(ctx, query, studentID).Scan(targets...); err != nil {
+			return nil, fmt.Errorf("failed to load student %d: %w", studentID, err)
+		}
+		for i, field := range StudentFields {
+			if fields[i] != "" {
+				values[field] = fields[i]
+			}
+		}
+		if accessCode != "" {
+			values[VarAccessCode] = accessCode
+		}
+		if conferenceToken != "" {
+			values[VarConferenceLink] = config.FrontendURL() + "/live?token=" + conferenceToken
+		}
+	}
+	if res, ok := r.results[studentID]; ok {
+		values[VarRank] = strconv.Itoa(res.rank)
+		values[VarScore] = strconv.Itoa(res.score)
+	}
+	return values, nil
+}
+
+// Render fills in texts for one student. custom holds the recipient's own variables;
+// name is the student's name, known to every sender. A strict renderer fails with
+// ErrUnresolved when a variable has no value.
+func (r *Renderer) Render(ctx context.Context, studentID int, name string, custom map[string]string, texts ...string) ([]string, error) {
+	values, err := r.values(ctx, studentID, name)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range custom {
+		if !Builtin(key) {
+			values[key] = value
+		}
+	}
+
+	unresolved := make(map[string]bool)
+	rendered := make([]string, len(texts))
+	for i, text := range texts {
+		rendered[i] = variablePattern.ReplaceAllStringFunc(text, func(match string) string {
+			key := match[2 : len(match)-2]
+			value, ok := values[key]
+			if !ok || value == "" {
+				unresolved[key] = true
+			}
+			return value
+		})
+	}
+	if r.strict && len(unresolved) > 0 {
+		names := make([]string, 0, len(unresolved))
+		for key := range unresolved {
+			names = append(names, "{{"+key+"}}")
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%w: %s", ErrUnresolved, strings.Join(names, ", "))
+	}
+	return rendered, nil
+}
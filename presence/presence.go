@@ -0,0 +1,402 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/db"
+	"mcq-exam/events"
+	"mcq-exam/exampause"
+	"mcq-exam/examwindow"
+	"mcq-exam/jobs"
+	"mcq-exam/livemetrics"
+	"mcq-exam/questions"
+	"mcq-exam/scoring"
+	"mcq-exam/sessioncache"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// sweepInterval is how often open sessions are checked for going idle or running out of time
+const sweepInterval = time.Minute
+
+// expiryGrace is how long a session may stay open after its time runs out, so the client's
+// own end-session arrives first
+const expiryGrace = 2 * time.Minute
+
+// Activity states of an open session
+const (
+	StateActive    = "active"
+	StateStalled   = "stalled"
+	StateAbandoned = "abandoned"
+)
+
+// ErrSessionCompleted is returned by Touch for a session that has already ended
+var ErrSessionCompleted = errors.New("session already completed")
+
+// lastActivity is when an open session (aliased as s) last showed signs of life: its start,
+// its latest heartbeat or its latest saved answer, so clients that never send heartbeats
+// are not flagged while they keep answering
+const lastActivity = `GREATEST(s.started_at, s.last_seen_at,
+	(SELECT MAX(a.submitted_at) FROM answers a WHERE a.session_id = s.id))`
+
+// IdleAfter is how long an open session may go without activity (SESSION_IDLE_MINUTES,
+// default 10) before it is flagged as abandoned
+func IdleAfter() time.Duration {
+	minutes := 10
+	if value := strings.TrimSpace(os.Getenv("SESSION_IDLE_MINUTES")); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			log.Warn().Msgf("Invalid SESSION_IDLE_MINUTES=%q, using %d", value, minutes)
+		} else {
+			minutes = parsed
+		}
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// AutoFinalize reports whether abandoned sessions are finalized with the answers saved so
+// far (SESSION_AUTO_FINALIZE, default false)
+func AutoFinalize() bool {
+	value := strings.TrimSpace(os.Getenv("SESSION_AUTO_FINALIZE"))
+	if value == "" {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Warn().Msgf("Invalid SESSION_AUTO_FINALIZE=%q, using false", value)
+		return false
+	}
+	return enabled
+}
+
+// Touch records a heartbeat for an open session and clears its abandoned flag, since the
+// candidate is back. Reports whether the session had been flagged as abandoned.
+func Touch(ctx context.Context, sessionID int) (bool, error) {
+	query := `
+		UPDATE sessions s
+		SET last_seen_at = NOW(), abandoned_at = NULL
+		FROM (SELECT id, abandoned_at FROM sessions WHERE id = $1) old
+		WHERE s.id = old.id AND s.completed = false
+		RETURNING old.abandoned_at IS NOT NULL
+	`
+	var wasAbandoned bool
+	err := db.Pool.QueryRow(ctx, query, sessionID).Scan(&wasAbandoned)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrSessionCompleted
+	}
+	return wasAbandoned, err
+}
+
+// TestDuration is how long a session may run: the sum of the section time limits. ok is
+// false when a section has no time limit, so sessions never run out of time.
+func TestDuration() (duration time.Duration, ok bool, err error) {
+	sections, err := questions.Load()
+	if err != nil {
+		return 0, false, err
+	}
+	for _, section := range sections {
+		if section.TimeLimit <= 0 {
+			return 0, false, nil
+		}
+		duration += time.Duration(section.TimeLimit) * time.Second
+	}
+	return duration, len(sections) > 0, nil
+}
+
+// StartSweeper finalizes open sessions whose time has run out and flags those that go idle
+// as abandoned, checking every minute until jobs.Context() is cancelled. Every instance may
+// run it: a session is flagged and finalized by exactly one of them.
+func StartSweeper() {
+	log.Info().Dur("idle_after", IdleAfter()).Bool("auto_finalize", AutoFinalize()).Msg("Starting abandoned session sweeper")
+
+	jobs.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := Sweep(ctx); err != nil && ctx.Err() == nil {
+					log.Error().Err(err).Msg("Session sweep failed")
+				}
+			}
+		}
+	})
+}
+
+// sweptSession is an open session found by a sweep
+type sweptSession struct {
+	ID           int
+	StudentID    int
+	SessionToken string
+	LastActivity time.Time
+}
+
+// Sweep finalizes the open sessions whose time or exam window ran out more than expiryGrace
+// ago, then flags the ones idle for longer than IdleAfter and, with AutoFinalize, finalizes
+// those too. Both keep the answers saved so far and mark the session auto_completed. Nothing
+// is flagged or finalized while the exam is paused.
+func Sweep(ctx context.Context) error {
+	// Candidates waiting out an exam pause are not flagged
+	pause, err := exampause.Current(ctx)
+	if err != nil {
+		return err
+	}
+	if pause.Paused {
+		return nil
+	}
+
+	if err := sweepExpired(ctx); err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE sessions s
+		SET abandoned_at = NOW(), updated_at = NOW()
+		WHERE s.completed = false AND s.abandoned_at IS NULL
+		  AND ` + lastActivity + ` < NOW() - make_interval(secs => $1)
+		RETURNING s.id, s.student_id, s.session_token, ` + lastActivity + `
+	`
+	rows, err := db.Pool.Query(ctx, query, IdleAfter().Seconds())
+	if err != nil {
+		return err
+	}
+	flagged, err := scanSwept(rows)
+	if err != nil {
+		return err
+	}
+
+	autoFinalize := AutoFinalize()
+	for _, session := range flagged {
+		log.Info().Int("session_id", session.ID).Int("student_id", session.StudentID).Time("last_activity", session.LastActivity).
+			Msg("Session flagged as abandoned")
+		events.Publish(events.SessionAbandoned, map[string]interface{}{
+			"session_id":    session.ID,
+			"student_id":    session.StudentID,
+			"last_activity": session.LastActivity,
+			"finalized":     autoFinalize,
+		})
+		if autoFinalize && ctx.Err() == nil {
+			finalizeSession(ctx, session, scoring.AutoIdle)
+		}
+	}
+	return nil
+}
+
+// sweepExpired finalizes the open sessions whose time ran out. A session's time runs from
+// its start for TestDuration, or until its finish_by for a late entrant with reduced time,
+// and never past the end of its participant's exam window, which also ends untimed
+// sessions; all pushed back by exam pauses.
+func sweepExpired(ctx context.Context) error {
+	duration, timed, err := TestDuration()
+	if err != nil {
+		return err
+	}
+	schedule, err := examwindow.Latest(ctx)
+	if errors.Is(err, examwindow.ErrNoSchedule) {
+		schedule = nil
+	} else if err != nil {
+		return err
+	}
+	// Untimed sessions still end with the exam window
+	if !timed && schedule == nil {
+		return nil
+	}
+
+	query := `
+		SELECT s.id, s.student_id, s.session_token, ` + lastActivity + `,
+		       s.started_at, s.finish_by, COALESCE(st.timezone, ''),
+		       ` + exampause.PausedSeconds("s.started_at", "NOW()") + `
+		FROM sessions s
+		JOIN students st ON st.id = s.student_id
+		WHERE s.completed = false
+		ORDER BY s.id
+	`
+	rows, err := db.Pool.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	windowEnds := make(map[string]time.Time)
+	var expired []sweptSession
+	for rows.Next() {
+		var session sweptSession
+		var startedAt time.Time
+		var finishBy *time.Time
+		var timezone string
+		var pausedSeconds float64
+		if err := rows.Scan(&session.ID, &session.StudentID, &session.SessionToken, &session.LastActivity,
+			&startedAt, &finishBy, &timezone, &pausedSeconds); err != nil {
+			return err
+		}
+
+		// The earliest of the session's own time, its reduced time and the end of its
+		// participant's exam window
+		var deadline time.Time
+		if timed {
+			deadline = startedAt.Add(duration)
+		}
+		if finishBy != nil && (deadline.IsZero() || finishBy.Before(deadline)) {
+			deadline = *finishBy
+		}
+		if schedule != nil {
+			ends, ok := windowEnds[timezone]
+			if !ok {
+				ends = schedule.Compute(timezone).EndsAt
+				windowEnds[timezone] = ends
+			}
+			if deadline.IsZero() || ends.Before(deadline) {
+				deadline = ends
+			}
+		}
+		paused := time.Duration(pausedSeconds * float64(time.Second))
+		if deadline.Add(expiryGrace + paused).Before(now) {
+			expired = append(expired, session)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, session := range expired {
+		if ctx.Err() != nil {
+			break
+		}
+		finalizeSession(ctx, session, scoring.AutoTimeExpired)
+	}
+	return nil
+}
+
+func scanSwept(rows pgx.Rows) ([]sweptSession, error) {
+	defer rows.Close()
+	var sessions []sweptSession
+	for rows.Next() {
+		var session sweptSession
+		if err := rows.Scan(&session.ID, &session.StudentID, &session.SessionToken, &session.LastActivity); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// finalizeSession completes a session the candidate never ended the way end-session does,
+// marking it auto_completed for the reason
+func finalizeSession(ctx context.Context, session sweptSession, reason string) {
+	result, err := scoring.AutoFinalize(ctx, session.ID, reason)
+	if errors.Is(err, scoring.ErrAlreadyCompleted) {
+		// The candidate, or another instance, ended it in the meantime
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Int("session_id", session.ID).Str("reason", reason).Msg("Failed to finalize session")
+		return
+	}
+
+	// The section in progress ends with the test
+	if _, err := db.Pool.Exec(ctx, `UPDATE session_sections SET ended_at = NOW() WHERE session_id = $1 AND ended_at IS NULL`, session.ID); err != nil {
+		log.Warn().Err(err).Int("session_id", session.ID).Msg("Failed to end open section")
+	}
+	sessioncache.Invalidate(ctx, session.SessionToken)
+	livemetrics.RecordCompletion(session.ID)
+
+	events.Publish(events.SessionCompleted, map[string]interface{}{
+		"session_id":               session.ID,
+		"student_id":               session.StudentID,
+		"score":                    result.Score,
+		"total_time_taken_seconds": result.TotalTimeTaken,
+		"total_questions_answered": result.TotalQuestions,
+		"abandoned":                reason == scoring.AutoIdle,
+		"auto_completed":           true,
+		"auto_completed_reason":    reason,
+	})
+	log.Info().Int("session_id", session.ID).Str("reason", reason).Int("score", result.Score).Int("answered", result.TotalQuestions).
+		Time("last_activity", session.LastActivity).Msg("Session auto-completed")
+}
+
+// SessionActivity is an open session with how long it has been idle
+type SessionActivity struct {
+	SessionID    int        `json:"session_id"`
+	StudentID    int        `json:"student_id"`
+	Name         string     `json:"name"`
+	Email        string     `json:"email"`
+	State        string     `json:"state"`
+	StartedAt    time.Time  `json:"started_at"`
+	LastSeenAt   *time.Time `json:"last_seen_at"`
+	LastActivity time.Time  `json:"last_activity"`
+	IdleSeconds  int        `json:"idle_seconds"`
+	Answered     int        `json:"answered"`
+	AbandonedAt  *time.Time `json:"abandoned_at"`
+	IsSandbox    bool       `json:"is_sandbox"`
+}
+
+// Activity lists the open sessions, most recently active first. A session idle for longer
+// than stalledAfter is stalled; one flagged by the sweeper is abandoned.
+func Activity(ctx context.Context, stalledAfter time.Duration) ([]SessionActivity, error) {
+	query := `
+		SELECT s.id, s.student_id, st.name, st.email, s.started_at, s.last_seen_at, s.abandoned_at, s.is_sandbox,
+		       ` + lastActivity + ` AS last_activity,
+		       (SELECT COUNT(*) FROM answers a WHERE a.session_id = s.id)
+		FROM sessions s
+		JOIN students st ON st.id = s.student_id
+		WHERE s.completed = false
+		ORDER BY last_activity DESC, s.id ASC
+	`
+	rows, err := db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	sessions := []SessionActivity{}
+	for rows.Next() {
+		var a SessionActivity
+		if err := rows.Scan(&a.SessionID, &a.StudentID, &a.Name, &a.Email, &a.StartedAt, &a.LastSeenAt, &a.AbandonedAt,
+			&a.IsSandbox, &a.LastActivity, &a.Answered); err != nil {
+			return nil, err
+		}
+		idle := now.Sub(a.LastActivity)
+		a.IdleSeconds = int(idle.Seconds())
+		switch {
+		case a.AbandonedAt != nil:
+			a.State = StateAbandoned
+		case idle > stalledAfter:
+			a.State = StateStalled
+		default:
+			a.State = StateActive
+		}
+		sessions = append(sessions, a)
+	}
+	return sessions, rows.Err()
+}
+
+// Counts is how many open sessions are in each state, as Activity would list them, without
+// loading every session
+func Counts(ctx context.Context, stalledAfter time.Duration) (map[string]int, error) {
+	query := `
+		SELECT COUNT(*) FILTER (WHERE abandoned_at IS NULL AND last_activity >= NOW() - make_interval(secs => $1)),
+		       COUNT(*) FILTER (WHERE abandoned_at IS NULL AND last_activity < NOW() - make_interval(secs => $1)),
+		       COUNT(*) FILTER (WHERE abandoned_at IS NOT NULL)
+		FROM (
+			SELECT s.abandoned_at, ` + lastActivity + ` AS last_activity
+			FROM sessions s
+			WHERE s.completed = false
+		) open
+	`
+	var active, stalled, abandoned int
+	if err := db.Pool.QueryRow(ctx, query, stalledAfter.Seconds()).Scan(&active, &stalled, &abandoned); err != nil {
+		return nil, err
+	}
+	return map[string]int{StateActive: active, StateStalled: stalled, StateAbandoned: abandoned}, nil
+}
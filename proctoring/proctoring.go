@@ -0,0 +1,57 @@
+package proctoring
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Client-reported event types stored in proctor_events
+const (
+	TabSwitch      = "tab_switch"
+	FullscreenExit = "fullscreen_exit"
+	CopyAttempt    = "copy_attempt"
+	WebcamFlag     = "webcam_flag"
+)
+
+// EventTypes lists every event type the client may report
+var EventTypes = []string{
+	TabSwitch,
+	FullscreenExit,
+	CopyAttempt,
+	WebcamFlag,
+}
+
+const (
+	// MaxBatchSize caps the events accepted in one request
+	MaxBatchSize = 100
+	// MaxDetailsBytes caps the free-form JSON attached to one event
+	MaxDetailsBytes = 2048
+)
+
+// IsValidEventType reports whether eventType is a known proctoring event type
+func IsValidEventType(eventType string) bool {
+	for _, known := range EventTypes {
+		if known == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// FlagThreshold is the number of events (PROCTOR_FLAG_THRESHOLD, default 3) at which
+// a session is listed for review
+func FlagThreshold() int {
+	threshold := 3
+	if value := strings.TrimSpace(os.Getenv("PROCTOR_FLAG_THRESHOLD")); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			log.Warn().Msgf("Invalid PROCTOR_FLAG_THRESHOLD=%q, using %d", value, threshold)
+		} else {
+			threshold = parsed
+		}
+	}
+	return threshold
+}
@@ -0,0 +1,95 @@
+// Package publication holds the exam setting for when results are published. Until then a
+// student who has finished sees their score but not the answer key, so it cannot be passed
+// on to candidates still sitting the exam.
+package publication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// settingsKey is the exam_settings row holding the publication time
+const settingsKey = "results_published_at"
+
+// Setting is when results, answer key included, are published. PublishedAt is nil until an
+// admin publishes them, and may be in the future to publish at a set time.
+type Setting struct {
+	PublishedAt *time.Time `json:"results_published_at"`
+	Published   bool       `json:"published"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+}
+
+func (s *Setting) refresh(now time.Time) {
+	s.Published = s.PublishedAt != nil && !now.Before(*s.PublishedAt)
+}
+
+// Load reads the publication time. It reads the database on every call, so all instances
+// agree as soon as it changes.
+func Load(ctx context.Context) (Setting, error) {
+	var value []byte
+	var updatedAt time.Time
+	err := db.Pool.QueryRow(ctx, `SELECT value, updated_at FROM exam_settings WHERE key = $1`, settingsKey).Scan(&value, &updatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Setting{}, nil
+	}
+	if err != nil {
+		return Setting{}, fmt.Errorf("failed to load results publication: %w", err)
+	}
+
+	var at time.Time
+	if err := json.Unmarshal(value, &at); err != nil {
+		return Setting{}, fmt.Errorf("stored results publication is invalid: %s", value)
+	}
+	setting := Setting{PublishedAt: &at, UpdatedAt: &updatedAt}
+	setting.refresh(time.Now())
+	return setting, nil
+}
+
+// Published is Load for student-facing results: a failed lookup is logged and results are
+// treated as unpublished, so the answer key is never shown by mistake
+func Published(ctx context.Context) Setting {
+	setting, err := Load(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Treating results as unpublished")
+		return Setting{}
+	}
+	return setting
+}
+
+// Save publishes results at at, which may be in the past (now) or the future
+func Save(ctx context.Context, at time.Time) (Setting, error) {
+	at = at.UTC()
+	value, err := json.Marshal(at)
+	if err != nil {
+		return Setting{}, err
+	}
+
+	query := `
+		INSERT INTO exam_settings (key, value, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()
+		RETURNING updated_at
+	`
+	var updatedAt time.Time
+	if err := db.Pool.QueryRow(ctx, query, settingsKey, value).Scan(&updatedAt); err != nil {
+		return Setting{}, fmt.Errorf("failed to save results publication: %w", err)
+	}
+	setting := Setting{PublishedAt: &at, UpdatedAt: &updatedAt}
+	setting.refresh(time.Now())
+	return setting, nil
+}
+
+// Reset withdraws the publication, hiding the answer key again
+func Reset(ctx context.Context) (Setting, error) {
+	if _, err := db.Pool.Exec(ctx, `DELETE FROM exam_settings WHERE key = $1`, settingsKey); err != nil {
+		return Setting{}, fmt.Errorf("failed to reset results publication: %w", err)
+	}
+	return Setting{}, nil
+}
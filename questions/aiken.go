@@ -0,0 +1,90 @@
+package questions
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	aikenOption = regexp.MustCompile(`^([A-Za-z])[.)]\s+(.*)$`)
+	aikenAnswer = regexp.MustCompile(`(?i)^ANSWER\s*:\s*([A-Za-z])\s*$`)
+)
+
+// parseAiken reads Aiken text: the question, options "A. ..." (or "A) ..."), then
+// "ANSWER: B", with blank lines between questions. A question's text may span lines.
+func parseAiken(data []byte) *Parsed {
+	p := newParsed(FormatAiken)
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+
+	var current *ImportedQuestion
+	var stem []string
+	// broken skips the rest of a question after an error, up to its ANSWER or a blank line
+	broken := false
+	finish := func() {
+		current, stem, broken = nil, nil, false
+	}
+
+	for i, raw := range lines {
+		line := strings.TrimSpace(raw)
+		lineItem := fmt.Sprintf("line %d", i+1)
+
+		if line == "" {
+			if current != nil && !broken && len(current.Options) > 0 {
+				p.fail(current.Item, "missing ANSWER line")
+				finish()
+			}
+			if broken {
+				finish()
+			}
+			continue
+		}
+		if current == nil {
+			current = &ImportedQuestion{Item: lineItem}
+		}
+
+		if match := aikenAnswer.FindStringSubmatch(line); match != nil {
+			if !broken {
+				switch {
+				case len(current.Options) == 0:
+					p.fail(current.Item, "ANSWER on %s comes before any option", lineItem)
+				default:
+					answer := int(strings.ToUpper(match[1])[0] - 'A')
+					if answer >= len(current.Options) {
+						p.fail(current.Item, "ANSWER %s on %s is not one of the options", strings.ToUpper(match[1]), lineItem)
+					} else {
+						current.Question = strings.Join(stem, " ")
+						current.Correct = []int{answer}
+						p.add(*current)
+					}
+				}
+			}
+			finish()
+			continue
+		}
+		if broken {
+			continue
+		}
+
+		if match := aikenOption.FindStringSubmatch(line); match != nil && len(stem) > 0 {
+			want := byte('A' + len(current.Options))
+			if strings.ToUpper(match[1])[0] != want {
+				p.fail(current.Item, "expected option %c on %s, found %s", want, lineItem, strings.ToUpper(match[1]))
+				broken = true
+				continue
+			}
+			current.Options = append(current.Options, strings.TrimSpace(match[2]))
+			continue
+		}
+		if len(current.Options) > 0 {
+			p.fail(current.Item, "expected another option or the ANSWER line on %s", lineItem)
+			broken = true
+			continue
+		}
+		stem = append(stem, line)
+	}
+	if current != nil && !broken {
+		p.fail(current.Item, "missing ANSWER line")
+	}
+	return p
+}
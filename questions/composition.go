@@ -0,0 +1,283 @@
+package questions
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Difficulty tiers a question may be tagged with
+const (
+	DifficultyEasy   = "easy"
+	DifficultyMedium = "medium"
+	DifficultyHard   = "hard"
+)
+
+// Difficulties lists the tiers from easiest
+var Difficulties = []string{DifficultyEasy, DifficultyMedium, DifficultyHard}
+
+// maxTopicLength bounds a question's topic
+const maxTopicLength = 100
+
+// ErrInvalidComposition is wrapped with the reason question metadata or composition rules
+// were rejected
+var ErrInvalidComposition = errors.New("invalid section composition")
+
+// Rule draws Count questions of a difficulty and topic from the section's pool; an empty
+// Difficulty or Topic matches any. Topics match ignoring case.
+type Rule struct {
+	Count      int    `json:"count"`
+	Difficulty string `json:"difficulty,omitempty"`
+	Topic      string `json:"topic,omitempty"`
+}
+
+func (r Rule) matches(q Question) bool {
+	return (r.Difficulty == "" || q.Difficulty == r.Difficulty) && (r.Topic == "" || strings.EqualFold(q.Topic, r.Topic))
+}
+
+// overlaps reports whether a question could match both rules
+func (r Rule) overlaps(other Rule) bool {
+	return (r.Difficulty == "" || other.Difficulty == "" || r.Difficulty == other.Difficulty) &&
+		(r.Topic == "" || other.Topic == "" || strings.EqualFold(r.Topic, other.Topic))
+}
+
+func (r Rule) String() string {
+	s := fmt.Sprintf("%d", r.Count)
+	if r.Difficulty != "" {
+		s += " " + r.Difficulty
+	}
+	if r.Topic != "" {
+		s += fmt.Sprintf(" from topic %q", r.Topic)
+	}
+	return s
+}
+
+// NormalizeDifficulty lowercases and checks a difficulty; empty means untagged
+func NormalizeDifficulty(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return "", nil
+	}
+	for _, d := range Difficulties {
+		if value == d {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("%w: difficulty %q must be %s", ErrInvalidComposition, value, strings.Join(Difficulties, ", "))
+}
+
+// NormalizeTopic trims and checks a topic; empty means untagged
+func NormalizeTopic(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if len(value) > maxTopicLength {
+		return "", fmt.Errorf("%w: topic must be at most %d characters", ErrInvalidComposition, maxTopicLength)
+	}
+	return value, nil
+}
+
+// ValidateComposition checks rules against a section's pool: every rule draws at least one
+// question, no two rules could draw the same question, and the pool holds enough questions
+// for each rule. Since the rules are disjoint, a pool that passes satisfies them for every
+// session.
+func ValidateComposition(section Section, rules []Rule) error {
+	for i, rule := range rules {
+		if rule.Count < 1 {
+			return fmt.Errorf("%w: section %d rule %d must draw at least 1 question", ErrInvalidComposition, section.ID, i+1)
+		}
+		for j := 0; j < i; j++ {
+			if rule.overlaps(rules[j]) {
+				return fmt.Errorf("%w: section %d rules %d (%s) and %d (%s) could draw the same questions; give them different difficulties or topics",
+					ErrInvalidComposition, section.ID, j+1, rules[j], i+1, rule)
+			}
+		}
+		available := 0
+		for _, q := range section.Questions {
+			if rule.matches(q) {
+				available++
+			}
+		}
+		if available < rule.Count {
+			return fmt.Errorf("%w: section %d rule %d (%s) needs %d questions but the pool has %d",
+				ErrInvalidComposition, section.ID, i+1, rule, rule.Count, available)
+		}
+	}
+	return nil
+}
+
+// normalizeRules checks and normalizes the difficulty and topic of each rule
+func normalizeRules(rules []Rule) ([]Rule, error) {
+	normalized := make([]Rule, len(rules))
+	for i, rule := range rules {
+		difficulty, err := NormalizeDifficulty(rule.Difficulty)
+		if err != nil {
+			return nil, err
+		}
+		topic, err := NormalizeTopic(rule.Topic)
+		if err != nil {
+			return nil, err
+		}
+		normalized[i] = Rule{Count: rule.Count, Difficulty: difficulty, Topic: topic}
+	}
+	return normalized, nil
+}
+
+// compose keeps the questions of picked, already shuffled, that the rules draw: the first
+// Count matches of each rule, in picked's order
+func compose(picked []Question, rules []Rule) []Question {
+	remaining := make([]int, len(rules))
+	for i, rule := range rules {
+		remaining[i] = rule.Count
+	}
+	composed := make([]Question, 0, len(picked))
+	for _, q := range picked {
+		for i, rule := range rules {
+			if remaining[i] > 0 && rule.matches(q) {
+				remaining[i]--
+				composed = append(composed, q)
+				break
+			}
+		}
+	}
+	return composed
+}
+
+// Sampled reports whether sessions see a subset of the bank, so a question's presence in
+// the bank does not mean it is in a session's set
+func Sampled(sections []Section, cfg SetConfig) bool {
+	return cfg.PerSection > 0 || Composed(sections)
+}
+
+// Composed reports whether any section has composition rules
+func Composed(sections []Section) bool {
+	for _, section := range sections {
+		if len(section.Composition) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// PoolCount is how many of a section's questions have a difficulty and topic
+type PoolCount struct {
+	Difficulty string `json:"difficulty"`
+	Topic      string `json:"topic"`
+	Count      int    `json:"count"`
+}
+
+// Pool counts a section's questions by difficulty and topic, untagged as ""
+func Pool(section Section) []PoolCount {
+	counts := make(map[[2]string]int)
+	for _, q := range section.Questions {
+		counts[[2]string{q.Difficulty, q.Topic}]++
+	}
+	pool := make([]PoolCount, 0, len(counts))
+	for key, count := range counts {
+		pool = append(pool, PoolCount{Difficulty: key[0], Topic: key[1], Count: count})
+	}
+	sort.Slice(pool, func(i, j int) bool {
+		if pool[i].Difficulty != pool[j].Difficulty {
+			return pool[i].Difficulty < pool[j].Difficulty
+		}
+		return pool[i].Topic < pool[j].Topic
+	})
+	return pool
+}
+
+// MetadataUpdate tags a question; a nil field keeps its value and "" clears it
+type MetadataUpdate struct {
+	ID         int     `json:"id"`
+	Difficulty *string `json:"difficulty"`
+	Topic      *string `json:"topic"`
+}
+
+// copyBank copies the sections and their question and rule slices, so the cached bank is
+// never modified
+func copyBank(bank []Section) []Section {
+	copied := make([]Section, len(bank))
+	for i, section := range bank {
+		section.Questions = append([]Question(nil), section.Questions...)
+		section.Composition = append([]Rule(nil), section.Composition...)
+		copied[i] = section
+	}
+	return copied
+}
+
+// validateBank checks every section's composition against its pool
+func validateBank(bank []Section) error {
+	for _, section := range bank {
+		if err := ValidateComposition(section, section.Composition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetMetadata returns a copy of bank with the questions tagged. The sections' rules must
+// still be satisfiable afterwards.
+func SetMetadata(bank []Section, updates []MetadataUpdate) ([]Section, error) {
+	updated := copyBank(bank)
+	for _, update := range updates {
+		q := Find(updated, update.ID)
+		if q == nil {
+			return nil, fmt.Errorf("%w: question %d does not exist", ErrInvalidComposition, update.ID)
+		}
+		if update.Difficulty != nil {
+			difficulty, err := NormalizeDifficulty(*update.Difficulty)
+			if err != nil {
+				return nil, err
+			}
+			q.Difficulty = difficulty
+		}
+		if update.Topic != nil {
+			topic, err := NormalizeTopic(*update.Topic)
+			if err != nil {
+				return nil, err
+			}
+			q.Topic = topic
+		}
+	}
+	if err := validateBank(updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// SetComposition returns a copy of bank with the rules of the given sections replaced;
+// empty rules serve the section as before (all questions, or QUESTIONS_PER_SECTION)
+func SetComposition(bank []Section, rules map[int][]Rule) ([]Section, error) {
+	updated := copyBank(bank)
+	for sectionID, sectionRules := range rules {
+		section := FindSection(updated, sectionID)
+		if section == nil {
+			return nil, fmt.Errorf("%w: section %d does not exist", ErrInvalidComposition, sectionID)
+		}
+		normalized, err := normalizeRules(sectionRules)
+		if err != nil {
+			return nil, err
+		}
+		if err := ValidateComposition(*section, normalized); err != nil {
+			return nil, err
+		}
+		section.Composition = normalized
+		if len(normalized) == 0 {
+			section.Composition = nil
+		}
+	}
+	return updated, nil
+}
+
+// Serves is how many questions a session sees of a section
+func Serves(section Section, cfg SetConfig) int {
+	if len(section.Composition) > 0 {
+		total := 0
+		for _, rule := range section.Composition {
+			total += rule.Count
+		}
+		return total
+	}
+	if cfg.PerSection > 0 && cfg.PerSection < len(section.Questions) {
+		return cfg.PerSection
+	}
+	return len(section.Questions)
+}
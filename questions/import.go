@@ -0,0 +1,338 @@
+package questions
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Import formats
+const (
+	FormatAiken  = "aiken"
+	FormatMoodle = "moodle"
+	FormatQTI    = "qti"
+)
+
+// maxImportOptions bounds the options of an imported question
+const maxImportOptions = 10
+
+var (
+	// ErrUnknownFormat is returned by Parse for formats other than aiken, moodle and qti
+	ErrUnknownFormat = errors.New("format must be aiken, moodle or qti")
+	// ErrInvalidImport is wrapped by Merge when parsed questions cannot be placed in the bank
+	ErrInvalidImport = errors.New("invalid import")
+)
+
+// ImportError is a problem with one item of an import file. Item says where: a line for
+// Aiken, a question number and name for Moodle XML, a file or identifier for QTI.
+type ImportError struct {
+	Item    string `json:"item"`
+	Message string `json:"message"`
+}
+
+// ImportedQuestion is a parsed question before it is placed in the bank
+type ImportedQuestion struct {
+	Item string
+	// Section is the section named by the file (Moodle categories), if any
+	Section     string
+	Question    string
+	Description string
+	Options     []string
+	// Correct are the indexes of the options marked correct
+	Correct []int
+}
+
+// Parsed is an import file: the valid questions, the errors that block the import, and
+// warnings about items that were skipped or look suspicious
+type Parsed struct {
+	Format    string             `json:"format"`
+	Questions []ImportedQuestion `json:"-"`
+	Errors    []ImportError      `json:"errors"`
+	Warnings  []ImportError      `json:"warnings"`
+}
+
+func newParsed(format string) *Parsed {
+	return &Parsed{Format: format, Errors: make([]ImportError, 0), Warnings: make([]ImportError, 0)}
+}
+
+func (p *Parsed) fail(item, format string, args ...any) {
+	p.Errors = append(p.Errors, ImportError{Item: item, Message: fmt.Sprintf(format, args...)})
+}
+
+func (p *Parsed) warn(item, format string, args ...any) {
+	p.Warnings = append(p.Warnings, ImportError{Item: item, Message: fmt.Sprintf(format, args...)})
+}
+
+// add validates q and keeps it if it fits the bank: one correct answer among 2 to 10
+// distinct, non-empty options
+func (p *Parsed) add(q ImportedQuestion) {
+	switch {
+	case q.Question == "":
+		p.fail(q.Item, "question text is empty")
+		return
+	case len(q.Options) < 2:
+		p.fail(q.Item, "has %d options; at least 2 are required", len(q.Options))
+		return
+	case len(q.Options) > maxImportOptions:
+		p.fail(q.Item, "has %d options; at most %d are allowed", len(q.Options), maxImportOptions)
+		return
+	case len(q.Correct) == 0:
+		p.fail(q.Item, "no option is marked correct")
+		return
+	case len(q.Correct) > 1:
+		p.fail(q.Item, "has %d correct options; only single-answer questions are supported", len(q.Correct))
+		return
+	}
+	seen := make(map[string]bool, len(q.Options))
+	for i, option := range q.Options {
+		if option == "" {
+			p.fail(q.Item, "option %d is empty", i+1)
+			return
+		}
+		if seen[strings.ToLower(option)] {
+			p.fail(q.Item, "option %q appears twice", option)
+			return
+		}
+		seen[strings.ToLower(option)] = true
+	}
+	p.Questions = append(p.Questions, q)
+}
+
+// DetectFormat guesses the format of an import file: a zip or an assessmentItem is QTI, a
+// <quiz> document is Moodle XML, anything else is Aiken
+func DetectFormat(data []byte) string {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return FormatQTI
+	}
+	head := data
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	switch {
+	case bytes.Contains(head, []byte("<quiz")):
+		return FormatMoodle
+	case bytes.Contains(head, []byte("assessmentItem")), bytes.Contains(head, []byte("questestinterop")):
+		return FormatQTI
+	}
+	return FormatAiken
+}
+
+// Parse reads an import file in the given format. Problems with the file's contents are
+// reported in the result; the error is only for an unknown format.
+func Parse(format string, data []byte) (*Parsed, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	switch format {
+	case FormatAiken:
+		return parseAiken(data), nil
+	case FormatMoodle:
+		return parseMoodle(data), nil
+	case FormatQTI:
+		return parseQTI(data), nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+var (
+	blockTagPattern = regexp.MustCompile(`(?i)</?(p|br|div|li|ul|ol|table|tr|td|th|h[1-6])\b[^>]*>`)
+	tagPattern      = regexp.MustCompile(`<[^>]*>`)
+	imagePattern    = regexp.MustCompile(`(?i)<(img|object|svg|video|audio)\b|@@PLUGINFILE@@`)
+)
+
+// plainText turns HTML from Moodle or QTI into the single-line text the bank stores
+func plainText(s string) string {
+	s = strings.ReplaceAll(s, "<![CDATA[", "")
+	s = strings.ReplaceAll(s, "]]>", "")
+	// Blocks become spaces; inline tags such as <b> vanish so "<b>RBI</b>?" stays "RBI?"
+	s = blockTagPattern.ReplaceAllString(s, " ")
+	s = tagPattern.ReplaceAllString(s, "")
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// hasMedia reports whether HTML shows something the bank cannot, such as an image
+func hasMedia(s string) bool {
+	return imagePattern.MatchString(s)
+}
+
+// ImportOptions says where imported questions go
+type ImportOptions struct {
+	// Replace discards the current bank instead of adding to it
+	Replace bool
+	// SectionID puts every question in this existing section
+	SectionID int
+	// SectionName puts every question in the section with this name, created if needed;
+	// without SectionID or SectionName questions go to the sections the file names
+	SectionName string
+	// TimeLimit in seconds is given to sections the import creates
+	TimeLimit int
+	// Difficulty and Topic tag every imported question; empty leaves them untagged
+	Difficulty string
+	Topic      string
+}
+
+// ImportedSection is a section that received imported questions
+type ImportedSection struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	TimeLimit int        `json:"time_limit"`
+	New       bool       `json:"new"`
+	Questions []Question `json:"questions"`
+}
+
+// Merge places the parsed questions in a copy of bank, giving them IDs after the highest
+// question ID in the bank (from 1 when replacing). It returns the new bank, the sections
+// that received questions in file order, and warnings about duplicate question texts.
+func Merge(bank []Section, parsed *Parsed, opts ImportOptions) ([]Section, []ImportedSection, []ImportError, error) {
+	merged := make([]Section, 0, len(bank)+1)
+	if !opts.Replace {
+		for _, section := range bank {
+			section.Questions = append([]Question(nil), section.Questions...)
+			merged = append(merged, section)
+		}
+	}
+
+	nextQuestion, nextSection := 1, 1
+	existing := make(map[string]int)
+	for _, section := range merged {
+		if section.ID >= nextSection {
+			nextSection = section.ID + 1
+		}
+		for _, q := range section.Questions {
+			if q.ID >= nextQuestion {
+				nextQuestion = q.ID + 1
+			}
+			existing[strings.ToLower(q.Question)] = q.ID
+		}
+	}
+
+	var target *Section
+	if opts.SectionID > 0 {
+		if target = FindSection(merged, opts.SectionID); target == nil {
+			return nil, nil, nil, fmt.Errorf("%w: section %d does not exist", ErrInvalidImport, opts.SectionID)
+		}
+	}
+
+	// Sections are found by ID, since appending to merged moves them
+	imported := make([]ImportedSection, 0)
+	importedAt := make(map[int]int)
+	warnings := make([]ImportError, 0)
+	inFile := make(map[string]string)
+	for _, parsedQ := range parsed.Questions {
+		sectionID := 0
+		if target != nil {
+			sectionID = target.ID
+		} else {
+			name := opts.SectionName
+			if name == "" {
+				name = parsedQ.Section
+			}
+			if name == "" {
+				return nil, nil, nil, fmt.Errorf("%w: %s has no section; pass section_id or section_name", ErrInvalidImport, parsedQ.Item)
+			}
+			for _, section := range merged {
+				if strings.EqualFold(section.Name, name) {
+					sectionID = section.ID
+					break
+				}
+			}
+			if sectionID == 0 {
+				if opts.TimeLimit < 1 {
+					return nil, nil, nil, fmt.Errorf("%w: section %q would be created; pass time_limit in seconds", ErrInvalidImport, name)
+				}
+				sectionID = nextSection
+				nextSection++
+				merged = append(merged, Section{ID: sectionID, Name: name, TimeLimit: opts.TimeLimit, Questions: make([]Question, 0)})
+			}
+		}
+
+		key := strings.ToLower(parsedQ.Question)
+		if id, ok := existing[key]; ok {
+			warnings = append(warnings, ImportError{Item: parsedQ.Item, Message: fmt.Sprintf("same text as question %d already in the bank", id)})
+		} else if item, ok := inFile[key]; ok {
+			warnings = append(warnings, ImportError{Item: parsedQ.Item, Message: "same text as " + item})
+		}
+		inFile[key] = parsedQ.Item
+
+		q := Question{
+			ID:            nextQuestion,
+			Question:      parsedQ.Question,
+			Description:   parsedQ.Description,
+			Options:       parsedQ.Options,
+			CorrectAnswer: parsedQ.Correct[0],
+			Difficulty:    opts.Difficulty,
+			Topic:         opts.Topic,
+		}
+		nextQuestion++
+		section := FindSection(merged, sectionID)
+		section.Questions = append(section.Questions, q)
+
+		i, ok := importedAt[sectionID]
+		if !ok {
+			i = len(imported)
+			importedAt[sectionID] = i
+			imported = append(imported, ImportedSection{ID: section.ID, Name: section.Name, TimeLimit: section.TimeLimit,
+				New: FindSection(bank, sectionID) == nil || opts.Replace, Questions: make([]Question, 0)})
+		}
+		imported[i].Questions = append(imported[i].Questions, q)
+	}
+	return merged, imported, warnings, nil
+}
+
+// Save replaces the question bank file and returns where the previous file was copied.
+// The new bank is written to a temporary file and renamed over File, so readers never see
+// a partial bank, and Load serves it from then on.
+func Save(sections []Section) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(sections); err != nil {
+		return "", err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	previous, err := os.ReadFile(File)
+	if err != nil {
+		return "", fmt.Errorf("failed to read questions file: %w", err)
+	}
+	backup := fmt.Sprintf("%s.%s.bak", File, time.Now().Format("20060102-150405"))
+	if err := os.WriteFile(backup, previous, 0o644); err != nil {
+		return "", fmt.Errorf("failed to back up questions file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(File), ".questions-*.json")
+	if err != nil {
+		return "", fmt.Errorf("failed to write questions file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write questions file: %w", err)
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write questions file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write questions file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), File); err != nil {
+		return "", fmt.Errorf("failed to replace questions file: %w", err)
+	}
+
+	info, err := os.Stat(File)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat questions file: %w", err)
+	}
+	cached = sections
+	cachedAt = info.ModTime()
+	return backup, nil
+}
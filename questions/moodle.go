@@ -0,0 +1,129 @@
+package questions
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type moodleText struct {
+	Text string `xml:"text"`
+}
+
+type moodleAnswer struct {
+	Fraction string `xml:"fraction,attr"`
+	Text     string `xml:"text"`
+}
+
+type moodleQuestion struct {
+	Type         string         `xml:"type,attr"`
+	Category     moodleText     `xml:"category"`
+	Name         moodleText     `xml:"name"`
+	QuestionText moodleText     `xml:"questiontext"`
+	Single       string         `xml:"single"`
+	Answers      []moodleAnswer `xml:"answer"`
+}
+
+type moodleQuiz struct {
+	XMLName   xml.Name         `xml:"quiz"`
+	Questions []moodleQuestion `xml:"question"`
+}
+
+// moodleCategory splits a category path such as "$course$/top/Section 2/Banking Law" into
+// the section (its first level) and the description (the levels below it)
+func moodleCategory(path string) (string, string) {
+	var levels []string
+	for _, level := range strings.Split(path, "/") {
+		level = strings.TrimSpace(level)
+		if level == "" || level == "top" || (strings.HasPrefix(level, "$") && strings.HasSuffix(level, "$")) {
+			continue
+		}
+		levels = append(levels, level)
+	}
+	if len(levels) == 0 {
+		return "", ""
+	}
+	return levels[0], strings.Join(levels[1:], " / ")
+}
+
+// parseMoodle reads Moodle XML. Multiple-choice and true/false questions are imported;
+// category entries set the section of the questions after them; other types are skipped
+// with a warning.
+func parseMoodle(data []byte) *Parsed {
+	p := newParsed(FormatMoodle)
+	var quiz moodleQuiz
+	if err := decodeXML(data, &quiz); err != nil {
+		p.fail("file", "not Moodle XML: %v", err)
+		return p
+	}
+	if len(quiz.Questions) == 0 {
+		p.fail("file", "no questions found")
+		return p
+	}
+
+	section, description := "", ""
+	number := 0
+	for _, mq := range quiz.Questions {
+		if mq.Type == "category" {
+			section, description = moodleCategory(mq.Category.Text)
+			continue
+		}
+		number++
+		item := fmt.Sprintf("question %d", number)
+		if name := strings.TrimSpace(mq.Name.Text); name != "" {
+			item = fmt.Sprintf("question %d (%s)", number, name)
+		}
+
+		if mq.Type != "multichoice" && mq.Type != "truefalse" {
+			p.warn(item, "skipped: %s questions are not supported", mq.Type)
+			continue
+		}
+		if mq.Type == "multichoice" && strings.TrimSpace(mq.Single) == "false" {
+			p.fail(item, "allows several answers; only single-answer questions are supported")
+			continue
+		}
+		if hasMedia(mq.QuestionText.Text) {
+			p.fail(item, "question text contains an image or other media, which the question bank cannot show")
+			continue
+		}
+
+		q := ImportedQuestion{
+			Item:        item,
+			Section:     section,
+			Question:    plainText(mq.QuestionText.Text),
+			Description: description,
+			Options:     make([]string, 0, len(mq.Answers)),
+			Correct:     make([]int, 0, 1),
+		}
+		invalid := ""
+		for i, answer := range mq.Answers {
+			fraction, err := strconv.ParseFloat(strings.TrimSpace(answer.Fraction), 64)
+			if err != nil {
+				invalid = fmt.Sprintf("answer %d has an invalid fraction %q", i+1, answer.Fraction)
+				break
+			}
+			if hasMedia(answer.Text) {
+				invalid = fmt.Sprintf("answer %d contains an image or other media, which the question bank cannot show", i+1)
+				break
+			}
+			text := plainText(answer.Text)
+			if mq.Type == "truefalse" && text != "" {
+				// Moodle stores the answers as "true" and "false"
+				text = strings.ToUpper(text[:1]) + text[1:]
+			}
+			q.Options = append(q.Options, text)
+			if fraction >= 100 {
+				q.Correct = append(q.Correct, i)
+			} else if fraction > 0 {
+				p.warn(item, "answer %d gives partial credit (%s%%), which is imported as wrong", i+1, answer.Fraction)
+			}
+		}
+		if invalid != "" {
+			p.fail(item, "%s", invalid)
+			continue
+		}
+		p.add(q)
+	}
+	return p
+}
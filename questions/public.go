@@ -0,0 +1,85 @@
+package questions
+
+import (
+	mathrand "math/rand"
+)
+
+// PublicQuestion is a question as sent to a student: without the answer key, with the
+// options in the order the student's session shows them
+type PublicQuestion struct {
+	ID          int      `json:"id"`
+	Question    string   `json:"question"`
+	Description string   `json:"description"`
+	Options     []string `json:"options"`
+}
+
+type PublicSection struct {
+	ID        int              `json:"id"`
+	Name      string           `json:"name"`
+	TimeLimit int              `json:"time_limit"`
+	Questions []PublicQuestion `json:"questions"`
+}
+
+// OptionOrder is the order a session shows a question's options in: position i shows
+// option order[i] of the bank. It is the bank's order unless ShuffleOptions is set and
+// the session has a seed.
+func OptionOrder(q Question, seed *int64, cfg SetConfig) []int {
+	order := make([]int, len(q.Options))
+	for i := range order {
+		order[i] = i
+	}
+	if cfg.ShuffleOptions && seed != nil {
+		rng := mathrand.New(mathrand.NewSource(*seed ^ int64(q.ID)*0x2545F4914F6CDD1D))
+		rng.Shuffle(len(order), func(i, j int) {
+			order[i], order[j] = order[j], order[i]
+		})
+	}
+	return order
+}
+
+// PublicFor strips the answer key from q and puts its options in the session's order
+func PublicFor(q Question, seed *int64, cfg SetConfig) PublicQuestion {
+	order := OptionOrder(q, seed, cfg)
+	options := make([]string, len(order))
+	for i, option := range order {
+		options[i] = q.Options[option]
+	}
+	return PublicQuestion{ID: q.ID, Question: q.Question, Description: q.Description, Options: options}
+}
+
+// Public is the session's question set as sent to the student
+func Public(sections []Section, seed int64, cfg SetConfig) []PublicSection {
+	result := make([]PublicSection, 0, len(sections))
+	for _, section := range ForSeed(sections, seed, cfg) {
+		public := PublicSection{ID: section.ID, Name: section.Name, TimeLimit: section.TimeLimit,
+			Questions: make([]PublicQuestion, len(section.Questions))}
+		for i, q := range section.Questions {
+			public.Questions[i] = PublicFor(q, &seed, cfg)
+		}
+		result = append(result, public)
+	}
+	return result
+}
+
+// Find returns the question with the given ID, or nil if it is not in sections
+func Find(sections []Section, questionID int) *Question {
+	for i := range sections {
+		for j := range sections[i].Questions {
+			if sections[i].Questions[j].ID == questionID {
+				return &sections[i].Questions[j]
+			}
+		}
+	}
+	return nil
+}
+
+// Grade maps the option a session selected (its position as shown) to the bank's index and
+// reports whether it is the correct answer. ok is false when the position is out of range.
+func Grade(q Question, seed *int64, cfg SetConfig, shown int) (option int, correct bool, ok bool) {
+	order := OptionOrder(q, seed, cfg)
+	if shown < 0 || shown >= len(order) {
+		return 0, false, false
+	}
+	option = order[shown]
+	return option, option == q.CorrectAnswer, true
+}
@@ -0,0 +1,214 @@
+package questions
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// xmlNode is any XML element, for formats whose layout varies between exporters
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   string     `xml:",innerxml"`
+	Nodes   []xmlNode  `xml:",any"`
+}
+
+func (n *xmlNode) attr(name string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// find returns the descendants named local, outermost first, not looking inside matches
+func (n *xmlNode) find(local string) []*xmlNode {
+	var found []*xmlNode
+	for i := range n.Nodes {
+		child := &n.Nodes[i]
+		if child.XMLName.Local == local {
+			found = append(found, child)
+			continue
+		}
+		found = append(found, child.find(local)...)
+	}
+	return found
+}
+
+// decodeXML unmarshals exported quiz XML, which often carries HTML entities such as &nbsp;
+func decodeXML(data []byte, v any) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	return decoder.Decode(v)
+}
+
+// qtiInteractions are the QTI 2 interaction types, of which only choiceInteraction is imported
+var qtiInteractions = []string{"choiceInteraction", "orderInteraction", "associateInteraction", "matchInteraction",
+	"gapMatchInteraction", "inlineChoiceInteraction", "textEntryInteraction", "extendedTextInteraction",
+	"hottextInteraction", "hotspotInteraction", "sliderInteraction", "uploadInteraction", "drawingInteraction"}
+
+// parseQTI reads QTI 2.x: a single assessmentItem, an XML file holding several, or a
+// content package (zip) with one item per file. Single-choice items are imported.
+func parseQTI(data []byte) *Parsed {
+	p := newParsed(FormatQTI)
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		parseQTIFile(p, "file", data)
+		if len(p.Questions) == 0 && len(p.Errors) == 0 && len(p.Warnings) == 0 {
+			p.fail("file", "no assessmentItem found")
+		}
+		return p
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		p.fail("file", "not a valid zip: %v", err)
+		return p
+	}
+	files := make([]*zip.File, 0, len(archive.File))
+	for _, file := range archive.File {
+		name := path.Base(file.Name)
+		if file.FileInfo().IsDir() || !strings.EqualFold(path.Ext(name), ".xml") || strings.EqualFold(name, "imsmanifest.xml") {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	for _, file := range files {
+		rc, err := file.Open()
+		if err != nil {
+			p.fail(file.Name, "cannot open: %v", err)
+			continue
+		}
+		content, err := io.ReadAll(io.LimitReader(rc, 10<<20))
+		rc.Close()
+		if err != nil {
+			p.fail(file.Name, "cannot read: %v", err)
+			continue
+		}
+		parseQTIFile(p, file.Name, content)
+	}
+	if len(p.Questions) == 0 && len(p.Errors) == 0 && len(p.Warnings) == 0 {
+		p.fail("file", "no assessmentItem found in the package")
+	}
+	return p
+}
+
+func parseQTIFile(p *Parsed, name string, data []byte) {
+	var root xmlNode
+	if err := decodeXML(data, &root); err != nil {
+		p.fail(name, "not valid XML: %v", err)
+		return
+	}
+	switch root.XMLName.Local {
+	case "questestinterop":
+		p.fail(name, "QTI 1.2 is not supported; export the questions as QTI 2.1")
+		return
+	case "assessmentItem":
+		parseQTIItem(p, name, &root)
+		return
+	}
+	for _, item := range root.find("assessmentItem") {
+		parseQTIItem(p, name, item)
+	}
+}
+
+func parseQTIItem(p *Parsed, file string, item *xmlNode) {
+	label := item.attr("title")
+	if label == "" {
+		label = item.attr("identifier")
+	}
+	name := file
+	if label != "" {
+		name = fmt.Sprintf("%s (%s)", file, label)
+	}
+
+	var body *xmlNode
+	for i := range item.Nodes {
+		if item.Nodes[i].XMLName.Local == "itemBody" {
+			body = &item.Nodes[i]
+		}
+	}
+	if body == nil {
+		p.fail(name, "has no itemBody")
+		return
+	}
+	choices := body.find("choiceInteraction")
+	if len(choices) != 1 {
+		for _, interaction := range qtiInteractions[1:] {
+			if len(body.find(interaction)) > 0 {
+				p.warn(name, "skipped: %s items are not supported", interaction)
+				return
+			}
+		}
+		p.fail(name, "has %d choice interactions; exactly one is supported", len(choices))
+		return
+	}
+	choice := choices[0]
+	if maxChoices := choice.attr("maxChoices"); maxChoices != "" && maxChoices != "1" {
+		p.fail(name, "allows %s choices; only single-answer questions are supported", maxChoices)
+		return
+	}
+
+	// The correct choices are in the response declaration the interaction answers
+	responseID := choice.attr("responseIdentifier")
+	correct := make(map[string]bool)
+	for _, declaration := range item.find("responseDeclaration") {
+		if declaration.attr("identifier") != responseID {
+			continue
+		}
+		for _, value := range declaration.find("value") {
+			correct[plainText(value.Inner)] = true
+		}
+	}
+
+	var stem string
+	if prompts := choice.find("prompt"); len(prompts) > 0 {
+		stem = prompts[0].Inner
+	} else {
+		stem = qtiStem(body)
+	}
+	if hasMedia(stem) {
+		p.fail(name, "question text contains an image or other media, which the question bank cannot show")
+		return
+	}
+
+	q := ImportedQuestion{Item: name, Question: plainText(stem), Correct: make([]int, 0, 1)}
+	for i, option := range choice.find("simpleChoice") {
+		if hasMedia(option.Inner) {
+			p.fail(name, "choice %d contains an image or other media, which the question bank cannot show", i+1)
+			return
+		}
+		q.Options = append(q.Options, plainText(option.Inner))
+		if correct[option.attr("identifier")] {
+			q.Correct = append(q.Correct, i)
+		}
+	}
+	p.add(q)
+}
+
+// qtiStem is the item body without its interaction, for items that put the question
+// outside the interaction's prompt
+func qtiStem(n *xmlNode) string {
+	var parts []string
+	for i := range n.Nodes {
+		child := &n.Nodes[i]
+		switch {
+		case child.XMLName.Local == "choiceInteraction":
+			continue
+		case len(child.find("choiceInteraction")) > 0:
+			parts = append(parts, qtiStem(child))
+		default:
+			parts = append(parts, child.Inner)
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,96 @@
+package questions
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// File is the question bank shipped alongside the binary
+const File = "questions_with_timer.json"
+
+type Question struct {
+	ID            int      `json:"id"`
+	Question      string   `json:"question"`
+	Description   string   `json:"description"`
+	Options       []string `json:"options"`
+	CorrectAnswer int      `json:"correctAnswer"`
+	// Difficulty (easy, medium or hard) and Topic are optional tags that composition rules
+	// draw by
+	Difficulty string `json:"difficulty,omitempty"`
+	Topic      string `json:"topic,omitempty"`
+}
+
+type Section struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	TimeLimit int        `json:"time_limit"`
+	Questions []Question `json:"questions"`
+	// Composition, when set, is what each session draws from the section instead of all
+	// questions or QUESTIONS_PER_SECTION (see Rule)
+	Composition []Rule `json:"composition,omitempty"`
+}
+
+var (
+	mu       sync.RWMutex
+	cached   []Section
+	cachedAt time.Time
+)
+
+// Load returns the parsed question bank. The file is re-read only when its
+// modification time changes, so edits on disk are picked up without a restart.
+func Load() ([]Section, error) {
+	info, err := os.Stat(File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat questions file: %w", err)
+	}
+
+	mu.RLock()
+	if cached != nil && info.ModTime().Equal(cachedAt) {
+		sections := cached
+		mu.RUnlock()
+		return sections, nil
+	}
+	mu.RUnlock()
+
+	data, err := os.ReadFile(File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read questions file: %w", err)
+	}
+
+	var sections []Section
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return nil, fmt.Errorf("failed to parse questions file: %w", err)
+	}
+
+	mu.Lock()
+	cached = sections
+	cachedAt = info.ModTime()
+	mu.Unlock()
+
+	return sections, nil
+}
+
+// FindSection returns the section with the given ID, or nil if it does not exist
+func FindSection(sections []Section, sectionID int) *Section {
+	for i := range sections {
+		if sections[i].ID == sectionID {
+			return &sections[i]
+		}
+	}
+	return nil
+}
+
+// SectionMapping returns parallel slices of question IDs and the section each belongs to,
+// suitable for passing to SQL as unnest($1::int[], $2::int[])
+func SectionMapping(sections []Section) (questionIDs []int, sectionIDs []int) {
+	for _, section := range sections {
+		for _, q := range section.Questions {
+			questionIDs = append(questionIDs, q.ID)
+			sectionIDs = append(sectionIDs, section.ID)
+		}
+	}
+	return questionIDs, sectionIDs
+}
@@ -0,0 +1,120 @@
+package questions
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	mathrand "math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SetConfig controls how a session's question set is derived from the bank
+type SetConfig struct {
+	// Shuffle randomizes question order within each section
+	Shuffle bool
+	// PerSection samples this many questions from each section's pool (0 = all)
+	PerSection int
+	// ShuffleOptions randomizes the order of each question's options per session
+	ShuffleOptions bool
+}
+
+// ConfigFromEnv reads QUESTIONS_SHUFFLE (default true), QUESTIONS_PER_SECTION (default 0,
+// all questions) and QUESTIONS_SHUFFLE_OPTIONS (default false)
+func ConfigFromEnv() SetConfig {
+	cfg := SetConfig{Shuffle: true}
+
+	if value := strings.TrimSpace(os.Getenv("QUESTIONS_SHUFFLE")); value != "" {
+		shuffle, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Warn().Msgf("Invalid QUESTIONS_SHUFFLE=%q, using true", value)
+		} else {
+			cfg.Shuffle = shuffle
+		}
+	}
+
+	if value := strings.TrimSpace(os.Getenv("QUESTIONS_PER_SECTION")); value != "" {
+		perSection, err := strconv.Atoi(value)
+		if err != nil || perSection < 0 {
+			log.Warn().Msgf("Invalid QUESTIONS_PER_SECTION=%q, serving all questions", value)
+		} else {
+			cfg.PerSection = perSection
+		}
+	}
+
+	if value := strings.TrimSpace(os.Getenv("QUESTIONS_SHUFFLE_OPTIONS")); value != "" {
+		shuffle, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Warn().Msgf("Invalid QUESTIONS_SHUFFLE_OPTIONS=%q, using false", value)
+		} else {
+			cfg.ShuffleOptions = shuffle
+		}
+	}
+
+	return cfg
+}
+
+// NewSeed returns a random seed for a session's question set
+func NewSeed() int64 {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return int64(binary.BigEndian.Uint64(b[:]) >> 1)
+}
+
+// ForSeed builds the question set for a seed. The same seed, config and bank always
+// produce the same set, so it never needs to be stored. A section with composition rules
+// gets the questions its rules draw; otherwise PerSection applies. Section order is
+// unchanged; the cached bank is never modified.
+func ForSeed(sections []Section, seed int64, cfg SetConfig) []Section {
+	result := make([]Section, 0, len(sections))
+
+	for _, section := range sections {
+		picked := make([]Question, len(section.Questions))
+		copy(picked, section.Questions)
+
+		// Each section gets its own stream so one section's size doesn't affect another's order
+		rng := mathrand.New(mathrand.NewSource(seed ^ int64(section.ID)*0x5851F42D4C957F2D))
+		if cfg.Shuffle || cfg.PerSection > 0 || len(section.Composition) > 0 {
+			rng.Shuffle(len(picked), func(i, j int) {
+				picked[i], picked[j] = picked[j], picked[i]
+			})
+		}
+
+		if len(section.Composition) > 0 {
+			picked = compose(picked, section.Composition)
+		} else if cfg.PerSection > 0 && cfg.PerSection < len(picked) {
+			picked = picked[:cfg.PerSection]
+		}
+
+		// Sampling without shuffling keeps the bank's order
+		if !cfg.Shuffle {
+			position := make(map[int]int, len(section.Questions))
+			for i, q := range section.Questions {
+				position[q.ID] = i
+			}
+			sort.Slice(picked, func(i, j int) bool {
+				return position[picked[i].ID] < position[picked[j].ID]
+			})
+		}
+
+		section.Questions = picked
+		result = append(result, section)
+	}
+
+	return result
+}
+
+// Contains reports whether a question ID is part of the given sections
+func Contains(sections []Section, questionID int) bool {
+	for _, section := range sections {
+		for _, q := range section.Questions {
+			if q.ID == questionID {
+				return true
+			}
+		}
+	}
+	return false
+}
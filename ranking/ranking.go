@@ -0,0 +1,173 @@
+package ranking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"mcq-exam/questions"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// Ranking criteria, applied in the policy's order until two students differ
+const (
+	// CriterionScore ranks a higher total score first
+	CriterionScore = "score"
+	// CriterionTime ranks less total time taken first
+	CriterionTime = "time"
+	// CriterionCompletedAt ranks the earliest completion first
+	CriterionCompletedAt = "completed_at"
+	// SectionPrefix followed by a section ID ("section:4") ranks a higher score in that section first
+	SectionPrefix = "section:"
+)
+
+// settingsKey is the exam_settings row holding the policy
+const settingsKey = "ranking_policy"
+
+// DefaultCriteria is the ranking used until an admin sets a policy
+var DefaultCriteria = []string{CriterionScore, CriterionTime}
+
+// Policy is the exam-level ordering used by the leaderboard, results and exports.
+// Students equal on every criterion share a rank (dense ranking: 1, 1, 2).
+type Policy struct {
+	Criteria  []string   `json:"criteria"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// Validate checks that every criterion is known, appears once, and that section criteria
+// refer to sections in the question bank
+func Validate(criteria []string, sections []questions.Section) error {
+	if len(criteria) == 0 {
+		return errors.New("criteria must contain at least one entry")
+	}
+
+	seen := make(map[string]bool, len(criteria))
+	for _, criterion := range criteria {
+		if seen[criterion] {
+			return fmt.Errorf("criterion '%s' is listed more than once", criterion)
+		}
+		seen[criterion] = true
+
+		switch criterion {
+		case CriterionScore, CriterionTime, CriterionCompletedAt:
+			continue
+		}
+		if !strings.HasPrefix(criterion, SectionPrefix) {
+			return fmt.Errorf("unknown criterion '%s'. Valid criteria: %s", criterion, strings.Join(Available(sections), ", "))
+		}
+		sectionID, err := strconv.Atoi(strings.TrimPrefix(criterion, SectionPrefix))
+		if err != nil || questions.FindSection(sections, sectionID) == nil {
+			return fmt.Errorf("unknown section in criterion '%s'", criterion)
+		}
+	}
+	return nil
+}
+
+// Available lists every criterion the current question bank supports
+func Available(sections []questions.Section) []string {
+	available := []string{CriterionScore, CriterionTime, CriterionCompletedAt}
+	for _, section := range sections {
+		available = append(available, SectionPrefix+strconv.Itoa(section.ID))
+	}
+	return available
+}
+
+// Load reads the stored policy, returning the default policy when none has been saved
+func Load(ctx context.Context) (Policy, error) {
+	var value []byte
+	var updatedAt time.Time
+	err := db.Pool.QueryRow(ctx, `SELECT value, updated_at FROM exam_settings WHERE key = $1`, settingsKey).Scan(&value, &updatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Policy{Criteria: DefaultCriteria}, nil
+	}
+	if err != nil {
+		return Policy{Criteria: DefaultCriteria}, fmt.Errorf("failed to load ranking policy: %w", err)
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(value, &policy); err != nil || len(policy.Criteria) == 0 {
+		return Policy{Criteria: DefaultCriteria}, fmt.Errorf("stored ranking policy is invalid: %s", value)
+	}
+	policy.UpdatedAt = &updatedAt
+	return policy, nil
+}
+
+// Current is Load for ranking queries: a failed lookup is logged and the default policy
+// is used so leaderboards keep working
+func Current(ctx context.Context) Policy {
+	policy, err := Load(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Using default ranking policy")
+	}
+	return policy
+}
+
+// Save stores the criteria as the exam's policy. Callers validate them first.
+func Save(ctx context.Context, criteria []string) (Policy, error) {
+	value, err := json.Marshal(Policy{Criteria: criteria})
+	if err != nil {
+		return Policy{}, err
+	}
+
+	query := `
+		INSERT INTO exam_settings (key, value, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()
+		RETURNING updated_at
+	`
+	var updatedAt time.Time
+	if err := db.Pool.QueryRow(ctx, query, settingsKey, value).Scan(&updatedAt); err != nil {
+		return Policy{}, fmt.Errorf("failed to save ranking policy: %w", err)
+	}
+	return Policy{Criteria: criteria, UpdatedAt: &updatedAt}, nil
+}
+
+// OrderBy is the ORDER BY expression for the policy over a sessions row aliased as sess.
+// It does not break full ties; append a unique column for a stable order.
+func (p Policy) OrderBy(sess string) string {
+	terms := make([]string, 0, len(p.Criteria))
+	for _, criterion := range p.Criteria {
+		switch criterion {
+		case CriterionScore:
+			terms = append(terms, "COALESCE("+sess+".score, 0) DESC")
+		case CriterionTime:
+			terms = append(terms, "COALESCE("+sess+".total_time_taken_seconds, 0) ASC")
+		case CriterionCompletedAt:
+			terms = append(terms, sess+".completed_at ASC")
+		default:
+			// Validated on save, so the ID is an integer
+			sectionID, err := strconv.Atoi(strings.TrimPrefix(criterion, SectionPrefix))
+			if err != nil {
+				continue
+			}
+			terms = append(terms, fmt.Sprintf(
+				"COALESCE((SELECT score FROM session_section_scores WHERE session_id = %s.id AND section_id = %d), 0) DESC",
+				sess, sectionID))
+		}
+	}
+	return strings.Join(terms, ", ")
+}
+
+// DenseRank is a window expression numbering rows by the policy, with ties sharing a rank
+func (p Policy) DenseRank(sess string) string {
+	return "DENSE_RANK() OVER (ORDER BY " + p.OrderBy(sess) + ")"
+}
+
+// SectionOrderBy is the ORDER BY of a section leaderboard over a session_section_scores row
+// aliased as sss: the higher section score first, then less time in the section. The ranking
+// policy does not apply to sections.
+func SectionOrderBy(sss string) string {
+	return sss + ".score DESC, " + sss + ".time_taken_seconds ASC"
+}
+
+// SectionDenseRank is a window expression numbering section results by SectionOrderBy, with
+// ties sharing a rank. It is partitioned by section, so one query can rank every section.
+func SectionDenseRank(sss string) string {
+	return "DENSE_RANK() OVER (PARTITION BY " + sss + ".section_id ORDER BY " + SectionOrderBy(sss) + ")"
+}
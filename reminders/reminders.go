@@ -0,0 +1,420 @@
+// Package reminders schedules conference reminder emails at fixed offsets (T-minus) before an
+// event's first_scheduled_time. Each reminder owns a one-shot scheduled job running
+// SendConferenceReminder, so reminders show up in the jobs history like the mail phases do.
+package reminders
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mcq-exam/campaigns"
+	"mcq-exam/db"
+	"mcq-exam/i18n"
+	"mcq-exam/jobs"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// FunctionName is the scheduled job function that sends a reminder
+const FunctionName = "SendConferenceReminder"
+
+// MaxOffset is the furthest ahead of the event a reminder may be sent
+const MaxOffset = 7 * 24 * time.Hour
+
+// audienceFilter picks the students a reminder goes to (see package segments)
+const audienceFilter = "NOT attended"
+
+// Default subject and body, used when a reminder has none. Besides {{name}}, which the
+// campaign sender fills in, {{starts_in}} and {{starts_at}} are replaced when the reminder fires.
+const (
+	DefaultSubject  = "Reminder: the conference starts in {{starts_in}}"
+	DefaultHTMLBody = `<p>Dear {{name}},</p>
+<p>This is a reminder that the conference starts in {{starts_in}}, at {{starts_at}}.</p>
+<p>Use the link in your conference invitation email to join.</p>`
+)
+
+var (
+	ErrNotFound         = errors.New("reminder not found")
+	ErrScheduleNotFound = errors.New("event schedule not found")
+	// ErrInvalidOffset is wrapped with the reason an offset was rejected
+	ErrInvalidOffset = errors.New("invalid offset")
+	ErrDuplicate     = errors.New("the event already has a reminder at this offset")
+	// ErrAlreadySent is returned when changing a reminder whose campaign was already created
+	ErrAlreadySent = errors.New("reminder already sent")
+)
+
+// Reminder is one T-minus offset of an event with its job and campaign
+type Reminder struct {
+	ID              int       `json:"id"`
+	EventScheduleID int       `json:"event_schedule_id"`
+	Offset          string    `json:"offset"`
+	OffsetMinutes   int       `json:"offset_minutes"`
+	SendAt          time.Time `json:"send_at"`
+	// Subject and HTMLBody are nil when the defaults are used
+	Subject        *string   `json:"subject"`
+	HTMLBody       *string   `json:"html_body"`
+	JobID          *int      `json:"job_id"`
+	JobStatus      *string   `json:"job_status"`
+	JobLastError   *string   `json:"job_last_error"`
+	CampaignID     *int      `json:"campaign_id"`
+	CampaignStatus *string   `json:"campaign_status"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	// firstTime and timezone are the event's, for the send time and the email text
+	firstTime time.Time
+	timezone  string
+}
+
+// ParseOffset reads an offset such as "24h", "90m" or "1h30m" as whole minutes
+func ParseOffset(offset string) (int, error) {
+	d, err := time.ParseDuration(strings.TrimSpace(offset))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is not a duration such as 24h, 1h or 10m", ErrInvalidOffset, offset)
+	}
+	if d <= 0 || d > MaxOffset || d%time.Minute != 0 {
+		return 0, fmt.Errorf("%w: %q must be a whole number of minutes between 1m and %s", ErrInvalidOffset, offset, FormatOffset(int(MaxOffset/time.Minute)))
+	}
+	return int(d / time.Minute), nil
+}
+
+// FormatOffset writes minutes the way ParseOffset reads them, e.g. 24h, 1h30m or 10m
+func FormatOffset(minutes int) string {
+	switch {
+	case minutes%60 == 0:
+		return fmt.Sprintf("%dh", minutes/60)
+	case minutes < 60:
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh%dm", minutes/60, minutes%60)
+}
+
+// humanOffset is an offset for email text, e.g. "1 hour 30 minutes"
+func humanOffset(minutes int) string {
+	unit := func(n int, name string) string {
+		if n == 1 {
+			return "1 " + name
+		}
+		return fmt.Sprintf("%d %ss", n, name)
+	}
+	hours, mins := minutes/60, minutes%60
+	switch {
+	case hours == 0:
+		return unit(mins, "minute")
+	case mins == 0:
+		return unit(hours, "hour")
+	}
+	return unit(hours, "hour") + " " + unit(mins, "minute")
+}
+
+func jobName(scheduleID, offsetMinutes int) string {
+	return fmt.Sprintf("Event %d reminder T-%s", scheduleID, FormatOffset(offsetMinutes))
+}
+
+// reminderColumns selects a reminder with its event time, job and campaign; see scanReminder
+const reminderColumns = `
+	SELECT r.id, r.event_schedule_id, r.offset_minutes, r.subject, r.html_body,
+	       r.job_id, j.status, j.last_error, r.campaign_id, c.status,
+	       r.created_at, r.updated_at, e.first_scheduled_time, e.timezone
+	FROM event_reminders r
+	JOIN event_schedule e ON e.id = r.event_schedule_id
+	LEFT JOIN scheduled_jobs j ON j.id = r.job_id
+	LEFT JOIN email_campaigns c ON c.id = r.campaign_id`
+
+func scanReminder(row pgx.Row) (*Reminder, error) {
+	var r Reminder
+	err := row.Scan(&r.ID, &r.EventScheduleID, &r.OffsetMinutes, &r.Subject, &r.HTMLBody,
+		&r.JobID, &r.JobStatus, &r.JobLastError, &r.CampaignID, &r.CampaignStatus,
+		&r.CreatedAt, &r.UpdatedAt, &r.firstTime, &r.timezone)
+	if err != nil {
+		return nil, err
+	}
+	r.Offset = FormatOffset(r.OffsetMinutes)
+	r.SendAt = r.firstTime.Add(-time.Duration(r.OffsetMinutes) * time.Minute)
+	return &r, nil
+}
+
+// LatestScheduleID returns the most recent event schedule, the one reminders default to
+func LatestScheduleID(ctx context.Context) (int, error) {
+	var id int
+	err := db.Pool.QueryRow(ctx, `SELECT id FROM event_schedule ORDER BY id DESC LIMIT 1`).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrScheduleNotFound
+	}
+	return id, err
+}
+
+// List returns an event's reminders, the earliest to be sent first
+func List(ctx context.Context, scheduleID int) ([]Reminder, error) {
+	rows, err := db.Pool.Query(ctx, reminderColumns+` WHERE r.event_schedule_id = $1 ORDER BY r.offset_minutes DESC`, scheduleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch reminders: %w", err)
+	}
+	defer rows.Close()
+
+	reminders := []Reminder{}
+	for rows.Next() {
+		r, err := scanReminder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan reminder: %w", err)
+		}
+		reminders = append(reminders, *r)
+	}
+	return reminders, rows.Err()
+}
+
+// Get loads one reminder
+func Get(ctx context.Context, id int) (*Reminder, error) {
+	r, err := scanReminder(db.Pool.QueryRow(ctx, reminderColumns+` WHERE r.id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch reminder: %w", err)
+	}
+	return r, nil
+}
+
+// sendAt checks that a reminder offset minutes before firstTime is still in the future
+func sendAt(firstTime time.Time, offsetMinutes int) (time.Time, error) {
+	at := firstTime.Add(-time.Duration(offsetMinutes) * time.Minute)
+	if !at.After(time.Now()) {
+		return at, fmt.Errorf("%w: %s before the event is already past", ErrInvalidOffset, FormatOffset(offsetMinutes))
+	}
+	return at, nil
+}
+
+// Create adds a reminder to the event for each offset, in minutes, scheduling their jobs.
+// Subject and htmlBody are nil to use the defaults. Either all reminders are created or none.
+func Create(ctx context.Context, scheduleID int, offsets []int, subject, htmlBody *string) ([]Reminder, error) {
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		var firstTime time.Time
+		err := tx.QueryRow(ctx, `SELECT first_scheduled_time FROM event_schedule WHERE id = $1`, scheduleID).Scan(&firstTime)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrScheduleNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch event schedule: %w", err)
+		}
+
+		for _, offset := range offsets {
+			at, err := sendAt(firstTime, offset)
+			if err != nil {
+				return err
+			}
+
+			var id int
+			insertQuery := `
+				INSERT INTO event_reminders (event_schedule_id, offset_minutes, subject, html_body)
+				VALUES ($1, $2, $3, $4)
+				ON CONFLICT (event_schedule_id, offset_minutes) DO NOTHING
+				RETURNING id
+			`
+			err = tx.QueryRow(ctx, insertQuery, scheduleID, offset, subject, htmlBody).Scan(&id)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("%w (%s)", ErrDuplicate, FormatOffset(offset))
+			}
+			if err != nil {
+				return fmt.Errorf("failed to create reminder: %w", err)
+			}
+
+			jobQuery := `
+				INSERT INTO scheduled_jobs (name, function_name, run_at, next_run_at, payload)
+				VALUES ($1, $2, $3, $3, jsonb_build_object('event_schedule_id', $4::int, 'reminder_id', $5::int))
+				RETURNING id
+			`
+			var jobID int
+			if err := tx.QueryRow(ctx, jobQuery, jobName(scheduleID, offset), FunctionName, at, scheduleID, id).Scan(&jobID); err != nil {
+				return fmt.Errorf("failed to schedule reminder: %w", err)
+			}
+			if _, err := tx.Exec(ctx, `UPDATE event_reminders SET job_id = $1 WHERE id = $2`, jobID, id); err != nil {
+				return fmt.Errorf("failed to link reminder job: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return List(ctx, scheduleID)
+}
+
+// Update changes a reminder that has not been sent; nil arguments are left as they are and
+// an empty subject or body goes back to the default. A new offset moves the reminder's job.
+func Update(ctx context.Context, id int, offset *int, subject, htmlBody *string) (*Reminder, error) {
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		r, err := scanReminder(tx.QueryRow(ctx, reminderColumns+` WHERE r.id = $1 FOR UPDATE OF r`, id))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch reminder: %w", err)
+		}
+		if r.CampaignID != nil || (r.JobStatus != nil && *r.JobStatus == "completed") {
+			return ErrAlreadySent
+		}
+
+		if offset != nil {
+			r.OffsetMinutes = *offset
+		}
+		at, err := sendAt(r.firstTime, r.OffsetMinutes)
+		if err != nil {
+			return err
+		}
+		var taken bool
+		takenQuery := `SELECT EXISTS (SELECT 1 FROM event_reminders WHERE event_schedule_id = $1 AND offset_minutes = $2 AND id <> $3)`
+		if err := tx.QueryRow(ctx, takenQuery, r.EventScheduleID, r.OffsetMinutes, id).Scan(&taken); err != nil {
+			return fmt.Errorf("failed to check reminder offset: %w", err)
+		}
+		if taken {
+			return fmt.Errorf("%w (%s)", ErrDuplicate, FormatOffset(r.OffsetMinutes))
+		}
+
+		updateQuery := `
+			UPDATE event_reminders
+			SET offset_minutes = $1,
+			    subject = CASE WHEN $2::text IS NULL THEN subject ELSE NULLIF($2, '') END,
+			    html_body = CASE WHEN $3::text IS NULL THEN html_body ELSE NULLIF($3, '') END,
+			    updated_at = NOW()
+			WHERE id = $4
+		`
+		if _, err := tx.Exec(ctx, updateQuery, r.OffsetMinutes, subject, htmlBody, id); err != nil {
+			return fmt.Errorf("failed to update reminder: %w", err)
+		}
+
+		// A failed job is retried from scratch at the new time; a paused one stays paused
+		jobQuery := `
+			UPDATE scheduled_jobs
+			SET name = $1, run_at = $2, next_run_at = $2, attempts = 0, last_error = NULL,
+			    status = CASE WHEN status = 'paused' THEN status ELSE 'active' END, updated_at = NOW()
+			WHERE id = $3
+		`
+		if r.JobID != nil {
+			if _, err := tx.Exec(ctx, jobQuery, jobName(r.EventScheduleID, r.OffsetMinutes), at, *r.JobID); err != nil {
+				return fmt.Errorf("failed to reschedule reminder: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return Get(ctx, id)
+}
+
+// Delete removes a reminder and, unless it already ran, its job. A campaign it launched
+// keeps sending; pause it through the campaigns API.
+func Delete(ctx context.Context, id int) error {
+	return db.WithTx(ctx, func(tx pgx.Tx) error {
+		var jobID *int
+		err := tx.QueryRow(ctx, `DELETE FROM event_reminders WHERE id = $1 RETURNING job_id`, id).Scan(&jobID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete reminder: %w", err)
+		}
+		if jobID != nil {
+			if _, err := tx.Exec(ctx, `DELETE FROM scheduled_jobs WHERE id = $1 AND status <> 'completed'`, *jobID); err != nil {
+				return fmt.Errorf("failed to delete reminder job: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// render fills in {{starts_in}} and {{starts_at}}, leaving {{name}} to the campaign sender
+func (r *Reminder) render(text string) string {
+	location, err := time.LoadLocation(r.timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	return strings.NewReplacer(
+		"{{starts_in}}", humanOffset(r.OffsetMinutes),
+		"{{starts_at}}", r.firstTime.In(location).Format("02 Jan 2006, 3:04 PM MST"),
+	).Replace(text)
+}
+
+// SendConferenceReminder is the scheduled job function of a reminder: it creates a campaign
+// to every student who has not attended the conference and launches it. Re-running it
+// (retry or resume) launches the campaign it already created instead of a new one.
+func SendConferenceReminder(jobCtx context.Context, run *jobs.Run) error {
+	var payload struct {
+		ReminderID int `json:"reminder_id"`
+	}
+	if err := json.Unmarshal(run.Payload, &payload); err != nil || payload.ReminderID == 0 {
+		return fmt.Errorf("payload must contain reminder_id")
+	}
+
+	ctx, cancel := context.WithTimeout(jobCtx, 30*time.Second)
+	defer cancel()
+
+	r, err := Get(ctx, payload.ReminderID)
+	if errors.Is(err, ErrNotFound) {
+		log.Warn().Int("reminder_id", payload.ReminderID).Msg("Reminder was deleted, nothing to send")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// A reminder delayed past the start (server down, job paused) would say the wrong thing
+	if r.CampaignID == nil && !time.Now().Before(r.firstTime) {
+		log.Warn().Int("reminder_id", r.ID).Int("event_schedule_id", r.EventScheduleID).Str("offset", r.Offset).
+			Msg("Event already started, skipping reminder")
+		return nil
+	}
+
+	if r.CampaignID == nil {
+		subject, body := DefaultSubject, DefaultHTMLBody
+		if r.Subject != nil {
+			subject = *r.Subject
+		}
+		if r.HTMLBody != nil {
+			body = *r.HTMLBody
+		}
+		// The default text goes out in each student's language where a reminder template has one
+		var variants i18n.Variants
+		if r.Subject == nil && r.HTMLBody == nil {
+			stored, err := i18n.TemplateVariants(ctx, i18n.TemplateReminder)
+			if err != nil {
+				return err
+			}
+			variants = make(i18n.Variants, len(stored))
+			for locale, v := range stored {
+				variants[locale] = i18n.Variant{Subject: r.render(v.Subject), HTMLBody: r.render(v.HTMLBody)}
+			}
+		}
+		filter := audienceFilter
+		name := fmt.Sprintf("Event %d reminder T-%s", r.EventScheduleID, r.Offset)
+		campaign, err := campaigns.Create(ctx, name, r.render(subject), r.render(body), variants, campaigns.SegmentAll, nil, &filter, campaigns.Options{})
+		if err != nil {
+			return err
+		}
+		if _, err := db.Pool.Exec(ctx, `UPDATE event_reminders SET campaign_id = $1, updated_at = NOW() WHERE id = $2`, campaign.ID, r.ID); err != nil {
+			return fmt.Errorf("failed to link reminder campaign: %w", err)
+		}
+		r.CampaignID = &campaign.ID
+	}
+
+	campaign, err := campaigns.Launch(ctx, *r.CampaignID)
+	var stateErr *campaigns.StateError
+	switch {
+	case errors.As(err, &stateErr):
+		// Launched by an earlier attempt
+		log.Info().Int("reminder_id", r.ID).Int("campaign_id", *r.CampaignID).Str("status", stateErr.Status).Msg("Reminder campaign already launched")
+		return nil
+	case errors.Is(err, campaigns.ErrNoRecipients):
+		log.Info().Int("reminder_id", r.ID).Int("campaign_id", *r.CampaignID).Msg("Every student has attended, reminder campaign not launched")
+		return nil
+	case err != nil:
+		return err
+	}
+
+	log.Info().Int("reminder_id", r.ID).Int("campaign_id", campaign.ID).Int("recipients", campaign.Recipients.Total).
+		Str("offset", r.Offset).Msg("Conference reminder campaign launched")
+	return nil
+}
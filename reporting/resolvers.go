@@ -0,0 +1,546 @@
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"mcq-exam/attempts"
+	"mcq-exam/db"
+	"mcq-exam/ranking"
+	"strings"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxPageSize bounds first on every list
+const maxPageSize = 500
+
+type resolver struct{}
+
+// conditions builds a WHERE clause; each added condition uses ? for its one argument
+type conditions struct {
+	clauses []string
+	args    []any
+}
+
+func (c *conditions) add(clause string, arg any) {
+	c.args = append(c.args, arg)
+	c.clauses = append(c.clauses, strings.ReplaceAll(clause, "?", fmt.Sprintf("$%d", len(c.args))))
+}
+
+func (c *conditions) where() string {
+	if len(c.clauses) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(c.clauses, " AND ")
+}
+
+// pageArgs are the paging arguments every list takes. Arguments with a default in the
+// schema are never null, so First is not a pointer.
+type pageArgs struct {
+	First int32
+	After *int32
+}
+
+// pageInfo is shared by every page type
+type pageInfo struct {
+	TotalCount  int32
+	HasNextPage bool
+	EndCursor   *int32
+}
+
+// page counts the rows matching cond and fetches the next first rows after the cursor, by
+// id. columns must start with the id; scan reads one row.
+func page(ctx context.Context, table, columns string, cond conditions, args pageArgs, scan func(pgx.Rows) (int32, error)) (pageInfo, error) {
+	var info pageInfo
+	first := args.First
+	if first < 1 || first > maxPageSize {
+		return info, fmt.Errorf("first must be between 1 and %d", maxPageSize)
+	}
+
+	countQuery := `SELECT COUNT(*)::int FROM ` + table + ` ` + cond.where()
+	if err := db.Pool.QueryRow(ctx, countQuery, cond.args...).Scan(&info.TotalCount); err != nil {
+		return info, err
+	}
+
+	words := strings.Fields(table)
+	alias := words[len(words)-1]
+	if args.After != nil {
+		cond.add(alias+".id > ?", *args.After)
+	}
+	cond.args = append(cond.args, first+1)
+	query := fmt.Sprintf(`SELECT %s FROM %s %s ORDER BY %s.id ASC LIMIT $%d`, columns, table, cond.where(), alias, len(cond.args))
+	rows, err := db.Pool.Query(ctx, query, cond.args...)
+	if err != nil {
+		return info, err
+	}
+	defer rows.Close()
+
+	var count int32
+	for rows.Next() {
+		if count == first {
+			info.HasNextPage = true
+			break
+		}
+		id, err := scan(rows)
+		if err != nil {
+			return info, err
+		}
+		info.EndCursor = &id
+		count++
+	}
+	return info, rows.Err()
+}
+
+// toTime converts a nullable timestamp to the GraphQL scalar
+func toTime(t *time.Time) *graphql.Time {
+	if t == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *t}
+}
+
+// ============================================
+// STUDENTS
+// ============================================
+
+type Student struct {
+	ID          int32
+	Name        string
+	Email       string
+	Institution *string
+	Country     *string
+	Designation *string
+	Timezone    *string
+	IsSandbox   bool
+	CreatedAt   *graphql.Time
+}
+
+type StudentPage struct {
+	pageInfo
+	Nodes []Student
+}
+
+type studentFilter struct {
+	Country        *string
+	Institution    *string
+	Search         *string
+	IncludeSandbox bool
+}
+
+func (r *resolver) Students(ctx context.Context, args struct {
+	Filter *studentFilter
+	pageArgs
+}) (*StudentPage, error) {
+	var cond conditions
+	f := args.Filter
+	if f == nil {
+		f = &studentFilter{}
+	}
+	if !f.IncludeSandbox {
+		cond.add("s.is_sandbox = ?", false)
+	}
+	if f.Country != nil {
+		cond.add("s.country = ?", strings.ToUpper(*f.Country))
+	}
+	if f.Institution != nil {
+		cond.add("s.institution = ?", *f.Institution)
+	}
+	if f.Search != nil {
+		cond.add("(s.name ILIKE '%' || ? || '%' OR s.email ILIKE '%' || ? || '%')", *f.Search)
+	}
+
+	result := &StudentPage{Nodes: []Student{}}
+	columns := `s.id, s.name, s.email, s.institution, s.country, s.designation, s.timezone, s.is_sandbox, s.created_at`
+	info, err := page(ctx, "students s", columns, cond, args.pageArgs, func(rows pgx.Rows) (int32, error) {
+		var s Student
+		var createdAt *time.Time
+		if err := rows.Scan(&s.ID, &s.Name, &s.Email, &s.Institution, &s.Country, &s.Designation, &s.Timezone, &s.IsSandbox, &createdAt); err != nil {
+			return 0, err
+		}
+		s.CreatedAt = toTime(createdAt)
+		result.Nodes = append(result.Nodes, s)
+		return s.ID, nil
+	})
+	result.pageInfo = info
+	return result, err
+}
+
+// ============================================
+// SESSIONS
+// ============================================
+
+type Session struct {
+	ID                    int32
+	StudentID             int32
+	AttemptNumber         int32
+	Completed             bool
+	Score                 *int32
+	TotalTimeTakenSeconds *int32
+	StartedAt             *graphql.Time
+	CompletedAt           *graphql.Time
+	IsSandbox             bool
+}
+
+type SessionPage struct {
+	pageInfo
+	Nodes []Session
+}
+
+type sessionFilter struct {
+	StudentID      *int32
+	Completed      *bool
+	MinScore       *int32
+	MaxScore       *int32
+	Country        *string
+	StartedAfter   *graphql.Time
+	StartedBefore  *graphql.Time
+	IncludeSandbox bool
+}
+
+func (r *resolver) Sessions(ctx context.Context, args struct {
+	Filter *sessionFilter
+	pageArgs
+}) (*SessionPage, error) {
+	var cond conditions
+	f := args.Filter
+	if f == nil {
+		f = &sessionFilter{}
+	}
+	if !f.IncludeSandbox {
+		cond.add("ss.is_sandbox = ?", false)
+	}
+	if f.StudentID != nil {
+		cond.add("ss.student_id = ?", *f.StudentID)
+	}
+	if f.Completed != nil {
+		cond.add("COALESCE(ss.completed, false) = ?", *f.Completed)
+	}
+	if f.MinScore != nil {
+		cond.add("ss.score >= ?", *f.MinScore)
+	}
+	if f.MaxScore != nil {
+		cond.add("ss.score <= ?", *f.MaxScore)
+	}
+	if f.Country != nil {
+		cond.add("ss.student_id IN (SELECT id FROM students WHERE country = ?)", strings.ToUpper(*f.Country))
+	}
+	if f.StartedAfter != nil {
+		cond.add("ss.started_at >= ?", f.StartedAfter.Time)
+	}
+	if f.StartedBefore != nil {
+		cond.add("ss.started_at < ?", f.StartedBefore.Time)
+	}
+
+	result := &SessionPage{Nodes: []Session{}}
+	columns := `ss.id, ss.student_id, ss.attempt_number, COALESCE(ss.completed, false), ss.score,
+		ss.total_time_taken_seconds, ss.started_at, ss.completed_at, ss.is_sandbox`
+	info, err := page(ctx, "sessions ss", columns, cond, args.pageArgs, func(rows pgx.Rows) (int32, error) {
+		var s Session
+		var startedAt, completedAt *time.Time
+		if err := rows.Scan(&s.ID, &s.StudentID, &s.AttemptNumber, &s.Completed, &s.Score,
+			&s.TotalTimeTakenSeconds, &startedAt, &completedAt, &s.IsSandbox); err != nil {
+			return 0, err
+		}
+		s.StartedAt, s.CompletedAt = toTime(startedAt), toTime(completedAt)
+		result.Nodes = append(result.Nodes, s)
+		return s.ID, nil
+	})
+	result.pageInfo = info
+	return result, err
+}
+
+// ============================================
+// ANSWERS
+// ============================================
+
+type Answer struct {
+	ID                     int32
+	SessionID              int32
+	QuestionID             int32
+	SelectedOptionIndex    int32
+	IsCorrect              bool
+	TimeTakenSeconds       int32
+	ClientTimeTakenSeconds *int32
+	SubmittedAt            *graphql.Time
+}
+
+type AnswerPage struct {
+	pageInfo
+	Nodes []Answer
+}
+
+type answerFilter struct {
+	SessionID  *int32
+	StudentID  *int32
+	QuestionID *int32
+	IsCorrect  *bool
+}
+
+func (r *resolver) Answers(ctx context.Context, args struct {
+	Filter *answerFilter
+	pageArgs
+}) (*AnswerPage, error) {
+	var cond conditions
+	if f := args.Filter; f != nil {
+		if f.SessionID != nil {
+			cond.add("a.session_id = ?", *f.SessionID)
+		}
+		if f.StudentID != nil {
+			cond.add("a.session_id IN (SELECT id FROM sessions WHERE student_id = ?)", *f.StudentID)
+		}
+		if f.QuestionID != nil {
+			cond.add("a.question_id = ?", *f.QuestionID)
+		}
+		if f.IsCorrect != nil {
+			cond.add("a.is_correct = ?", *f.IsCorrect)
+		}
+	}
+
+	result := &AnswerPage{Nodes: []Answer{}}
+	columns := `a.id, a.session_id, a.question_id, a.selected_option_index, a.is_correct,
+		a.time_taken_seconds, a.client_time_taken_seconds, a.submitted_at`
+	info, err := page(ctx, "answers a", columns, cond, args.pageArgs, func(rows pgx.Rows) (int32, error) {
+		var a Answer
+		var submittedAt *time.Time
+		if err := rows.Scan(&a.ID, &a.SessionID, &a.QuestionID, &a.SelectedOptionIndex, &a.IsCorrect,
+			&a.TimeTakenSeconds, &a.ClientTimeTakenSeconds, &submittedAt); err != nil {
+			return 0, err
+		}
+		a.SubmittedAt = toTime(submittedAt)
+		result.Nodes = append(result.Nodes, a)
+		return a.ID, nil
+	})
+	result.pageInfo = info
+	return result, err
+}
+
+// ============================================
+// EMAIL TRACKING
+// ============================================
+
+// EmailTracking leaves out conference tokens and access codes, which are credentials
+type EmailTracking struct {
+	ID                   int32
+	StudentID            *int32
+	EmailType            string
+	Opened               bool
+	OpenedAt             *graphql.Time
+	ConferenceAttended   bool
+	ConferenceAttendedAt *graphql.Time
+	CreatedAt            *graphql.Time
+}
+
+type EmailTrackingPage struct {
+	pageInfo
+	Nodes []EmailTracking
+}
+
+type emailTrackingFilter struct {
+	StudentID          *int32
+	EmailType          *string
+	Opened             *bool
+	ConferenceAttended *bool
+}
+
+func (r *resolver) EmailTracking(ctx context.Context, args struct {
+	Filter *emailTrackingFilter
+	pageArgs
+}) (*EmailTrackingPage, error) {
+	var cond conditions
+	if f := args.Filter; f != nil {
+		if f.StudentID != nil {
+			cond.add("et.student_id = ?", *f.StudentID)
+		}
+		if f.EmailType != nil {
+			cond.add("et.email_type = ?", *f.EmailType)
+		}
+		if f.Opened != nil {
+			cond.add("COALESCE(et.opened, false) = ?", *f.Opened)
+		}
+		if f.ConferenceAttended != nil {
+			cond.add("COALESCE(et.conference_attended, false) = ?", *f.ConferenceAttended)
+		}
+	}
+
+	result := &EmailTrackingPage{Nodes: []EmailTracking{}}
+	columns := `et.id, et.student_id, et.email_type, COALESCE(et.opened, false), et.opened_at,
+		COALESCE(et.conference_attended, false), et.conference_attended_at, et.created_at`
+	info, err := page(ctx, "email_tracking et", columns, cond, args.pageArgs, func(rows pgx.Rows) (int32, error) {
+		var e EmailTracking
+		var openedAt, attendedAt, createdAt *time.Time
+		if err := rows.Scan(&e.ID, &e.StudentID, &e.EmailType, &e.Opened, &openedAt,
+			&e.ConferenceAttended, &attendedAt, &createdAt); err != nil {
+			return 0, err
+		}
+		e.OpenedAt, e.ConferenceAttendedAt, e.CreatedAt = toTime(openedAt), toTime(attendedAt), toTime(createdAt)
+		result.Nodes = append(result.Nodes, e)
+		return e.ID, nil
+	})
+	result.pageInfo = info
+	return result, err
+}
+
+// ============================================
+// AGGREGATES
+// ============================================
+
+type LeaderboardEntry struct {
+	Rank                  int32
+	StudentID             int32
+	Name                  string
+	Country               *string
+	Institution           *string
+	Score                 int32
+	TotalTimeTakenSeconds int32
+}
+
+type LeaderboardPage struct {
+	TotalCount int32
+	Ranking    []string
+	Entries    []LeaderboardEntry
+}
+
+func (r *resolver) Leaderboard(ctx context.Context, args struct {
+	First  int32
+	Offset int32
+}) (*LeaderboardPage, error) {
+	first, offset := args.First, args.Offset
+	if first < 1 || first > maxPageSize {
+		return nil, fmt.Errorf("first must be between 1 and %d", maxPageSize)
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("offset must not be negative")
+	}
+
+	policy := ranking.Current(ctx)
+	result := &LeaderboardPage{Ranking: policy.Criteria, Entries: []LeaderboardEntry{}}
+	query := `
+		SELECT s.id, s.name, s.country, s.institution,
+		       COALESCE(sess.score, 0), COALESCE(sess.total_time_taken_seconds, 0),
+		       ` + policy.DenseRank("sess") + `, COUNT(*) OVER ()::int
+		FROM students s
+		INNER JOIN ` + attempts.CountedSessions() + ` sess ON s.id = sess.student_id
+		ORDER BY ` + policy.OrderBy("sess") + `, s.id ASC
+		LIMIT $1 OFFSET $2
+	`
+	rows, err := db.Pool.Query(ctx, query, first, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e LeaderboardEntry
+		if err := rows.Scan(&e.StudentID, &e.Name, &e.Country, &e.Institution, &e.Score, &e.TotalTimeTakenSeconds,
+			&e.Rank, &result.TotalCount); err != nil {
+			return nil, err
+		}
+		result.Entries = append(result.Entries, e)
+	}
+	return result, rows.Err()
+}
+
+type ScoreGroupStats struct {
+	Key                *string
+	Participants       int32
+	AverageScore       float64
+	MinScore           int32
+	MaxScore           int32
+	AverageTimeSeconds float64
+}
+
+// scoreGroupColumns maps ScoreGroup values to students columns
+var scoreGroupColumns = map[string]string{
+	"COUNTRY":     "s.country",
+	"INSTITUTION": "s.institution",
+	"DESIGNATION": "s.designation",
+}
+
+func (r *resolver) ScoreStats(ctx context.Context, args struct{ GroupBy string }) ([]ScoreGroupStats, error) {
+	column, ok := scoreGroupColumns[args.GroupBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown groupBy %q", args.GroupBy)
+	}
+
+	query := `
+		SELECT ` + column + `::text, COUNT(*)::int,
+		       AVG(COALESCE(sess.score, 0))::float8, MIN(COALESCE(sess.score, 0))::int, MAX(COALESCE(sess.score, 0))::int,
+		       AVG(COALESCE(sess.total_time_taken_seconds, 0))::float8
+		FROM students s
+		INNER JOIN ` + attempts.CountedSessions() + ` sess ON s.id = sess.student_id
+		GROUP BY 1
+		ORDER BY 2 DESC, 1 ASC
+	`
+	rows, err := db.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []ScoreGroupStats{}
+	for rows.Next() {
+		var g ScoreGroupStats
+		if err := rows.Scan(&g.Key, &g.Participants, &g.AverageScore, &g.MinScore, &g.MaxScore, &g.AverageTimeSeconds); err != nil {
+			return nil, err
+		}
+		stats = append(stats, g)
+	}
+	return stats, rows.Err()
+}
+
+type ActivityCount struct {
+	Bucket string
+	Count  int32
+}
+
+// bucketFormats maps TimeBucket values to a to_char expression over a UTC timestamp
+var bucketFormats = map[string]string{
+	"HOUR":        `to_char(date_trunc('hour', e.created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD"T"HH24:00:00"Z"')`,
+	"DAY":         `to_char(e.created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD')`,
+	"HOUR_OF_DAY": `to_char(e.created_at AT TIME ZONE 'UTC', 'HH24')`,
+}
+
+func (r *resolver) EmailActivity(ctx context.Context, args struct {
+	Bucket    string
+	Event     string
+	EmailType *string
+	Since     *graphql.Time
+	Until     *graphql.Time
+}) ([]ActivityCount, error) {
+	bucket, ok := bucketFormats[args.Bucket]
+	if !ok {
+		return nil, fmt.Errorf("unknown bucket %q", args.Bucket)
+	}
+	var cond conditions
+	cond.add("e.event_type = ?", strings.ToLower(args.Event))
+	// Provider webhook events (delivered, spam, bounce and their own opens/clicks) are not activity
+	cond.add("e.source = ?", "tracking")
+	cond.add("NOT EXISTS (SELECT 1 FROM students st WHERE st.id = e.student_id AND st.is_sandbox = ?)", true)
+	if args.EmailType != nil {
+		cond.add("e.email_type = ?", *args.EmailType)
+	}
+	if args.Since != nil {
+		cond.add("e.created_at >= ?", args.Since.Time)
+	}
+	if args.Until != nil {
+		cond.add("e.created_at < ?", args.Until.Time)
+	}
+
+	query := `SELECT ` + bucket + `, COUNT(*)::int FROM email_events e ` + cond.where() + ` GROUP BY 1 ORDER BY 1`
+	rows, err := db.Pool.Query(ctx, query, cond.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []ActivityCount{}
+	for rows.Next() {
+		var a ActivityCount
+		if err := rows.Scan(&a.Bucket, &a.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, a)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,197 @@
+package reporting
+
+import (
+	"context"
+
+	"github.com/graph-gophers/graphql-go"
+)
+
+// schemaSDL is the read-only reporting schema. Lists are paged by id: pass a page's
+// endCursor as after to get the next one. Sandbox students and sessions are left out
+// unless includeSandbox is set.
+const schemaSDL = `
+scalar Time
+
+schema {
+	query: Query
+}
+
+type Query {
+	students(filter: StudentFilter, first: Int = 50, after: Int): StudentPage!
+	sessions(filter: SessionFilter, first: Int = 50, after: Int): SessionPage!
+	answers(filter: AnswerFilter, first: Int = 50, after: Int): AnswerPage!
+	emailTracking(filter: EmailTrackingFilter, first: Int = 50, after: Int): EmailTrackingPage!
+	# Ranked by the ranking policy, over each student's counted attempt
+	leaderboard(first: Int = 10, offset: Int = 0): LeaderboardPage!
+	# Scores of counted attempts per country, institution or designation
+	scoreStats(groupBy: ScoreGroup!): [ScoreGroupStats!]!
+	# Email events per time bucket (UTC)
+	emailActivity(bucket: TimeBucket!, event: EmailEvent = OPEN, emailType: String, since: Time, until: Time): [ActivityCount!]!
+}
+
+input StudentFilter {
+	country: String
+	institution: String
+	# Matches name or email, case-insensitively
+	search: String
+	includeSandbox: Boolean = false
+}
+
+type Student {
+	id: Int!
+	name: String!
+	email: String!
+	institution: String
+	country: String
+	designation: String
+	timezone: String
+	isSandbox: Boolean!
+	createdAt: Time
+}
+
+type StudentPage {
+	totalCount: Int!
+	hasNextPage: Boolean!
+	endCursor: Int
+	nodes: [Student!]!
+}
+
+input SessionFilter {
+	studentId: Int
+	completed: Boolean
+	minScore: Int
+	maxScore: Int
+	country: String
+	startedAfter: Time
+	startedBefore: Time
+	includeSandbox: Boolean = false
+}
+
+type Session {
+	id: Int!
+	studentId: Int!
+	attemptNumber: Int!
+	completed: Boolean!
+	score: Int
+	totalTimeTakenSeconds: Int
+	startedAt: Time
+	completedAt: Time
+	isSandbox: Boolean!
+}
+
+type SessionPage {
+	totalCount: Int!
+	hasNextPage: Boolean!
+	endCursor: Int
+	nodes: [Session!]!
+}
+
+input AnswerFilter {
+	sessionId: Int
+	studentId: Int
+	questionId: Int
+	isCorrect: Boolean
+}
+
+type Answer {
+	id: Int!
+	sessionId: Int!
+	questionId: Int!
+	selectedOptionIndex: Int!
+	isCorrect: Boolean!
+	timeTakenSeconds: Int!
+	clientTimeTakenSeconds: Int
+	submittedAt: Time
+}
+
+type AnswerPage {
+	totalCount: Int!
+	hasNextPage: Boolean!
+	endCursor: Int
+	nodes: [Answer!]!
+}
+
+input EmailTrackingFilter {
+	studentId: Int
+	emailType: String
+	opened: Boolean
+	conferenceAttended: Boolean
+}
+
+type EmailTracking {
+	id: Int!
+	studentId: Int
+	emailType: String!
+	opened: Boolean!
+	openedAt: Time
+	conferenceAttended: Boolean!
+	conferenceAttendedAt: Time
+	createdAt: Time
+}
+
+type EmailTrackingPage {
+	totalCount: Int!
+	hasNextPage: Boolean!
+	endCursor: Int
+	nodes: [EmailTracking!]!
+}
+
+type LeaderboardEntry {
+	rank: Int!
+	studentId: Int!
+	name: String!
+	country: String
+	institution: String
+	score: Int!
+	totalTimeTakenSeconds: Int!
+}
+
+type LeaderboardPage {
+	totalCount: Int!
+	ranking: [String!]!
+	entries: [LeaderboardEntry!]!
+}
+
+enum ScoreGroup {
+	COUNTRY
+	INSTITUTION
+	DESIGNATION
+}
+
+type ScoreGroupStats {
+	# null groups students without the field
+	key: String
+	participants: Int!
+	averageScore: Float!
+	minScore: Int!
+	maxScore: Int!
+	averageTimeSeconds: Float!
+}
+
+enum TimeBucket {
+	HOUR
+	DAY
+	HOUR_OF_DAY
+}
+
+enum EmailEvent {
+	SENT
+	OPEN
+	CLICK
+}
+
+type ActivityCount {
+	bucket: String!
+	count: Int!
+}
+`
+
+// maxDepth bounds query nesting; the schema itself is at most four levels deep
+const maxDepth = 6
+
+var schema = graphql.MustParseSchema(schemaSDL, &resolver{}, graphql.UseFieldResolvers(), graphql.MaxDepth(maxDepth))
+
+// Exec runs a reporting query. Only queries exist, so nothing can be changed through it.
+func Exec(ctx context.Context, query, operationName string, variables map[string]interface{}) *graphql.Response {
+	return schema.Exec(ctx, query, operationName, variables)
+}
@@ -0,0 +1,139 @@
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// settingsKey is the exam_settings row holding the policies
+const settingsKey = "retention_policies"
+
+// Actions a policy takes on data older than its days
+const (
+	// ActionAnonymize blanks the personal fields and keeps the rest for statistics
+	ActionAnonymize = "anonymize"
+	// ActionDelete removes the rows
+	ActionDelete = "delete"
+)
+
+// ErrInvalidPolicy is wrapped with the reason a policy was rejected
+var ErrInvalidPolicy = errors.New("invalid retention policy")
+
+// Policy is how long one data class is kept. Days 0 keeps it indefinitely.
+type Policy struct {
+	Days   int    `json:"days"`
+	Action string `json:"action,omitempty"`
+}
+
+// Policies maps each data class to its policy; a class without an entry is kept indefinitely
+type Policies map[string]Policy
+
+// Setting is the stored policies with when they were last saved
+type Setting struct {
+	Policies  Policies   `json:"policies"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// Validate checks every class and policy
+func (p Policies) Validate() error {
+	for name, policy := range p {
+		if classByName(name) == nil {
+			return fmt.Errorf("%w: unknown data class %q (valid: %s)", ErrInvalidPolicy, name, strings.Join(ClassNames(), ", "))
+		}
+		if policy.Days < 0 {
+			return fmt.Errorf("%w: %s days must not be negative", ErrInvalidPolicy, name)
+		}
+		if policy.Days > 0 && policy.Action != ActionAnonymize && policy.Action != ActionDelete {
+			return fmt.Errorf("%w: %s action must be %s or %s", ErrInvalidPolicy, name, ActionAnonymize, ActionDelete)
+		}
+	}
+	return nil
+}
+
+// withDefaults lists every class, those without a policy as kept indefinitely
+func (p Policies) withDefaults() Policies {
+	all := make(Policies, len(classes))
+	for _, c := range classes {
+		all[c.name] = Policy{}
+	}
+	for name, policy := range p {
+		if policy.Days == 0 {
+			policy.Action = ""
+		}
+		all[name] = policy
+	}
+	return all
+}
+
+// active returns the class names with a policy in effect, in class order
+func (p Policies) active() []string {
+	var names []string
+	for _, c := range classes {
+		if p[c.name].Days > 0 {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// Load reads the stored policies; with none stored every class is kept indefinitely
+func Load(ctx context.Context) (Setting, error) {
+	var value []byte
+	var updatedAt time.Time
+	err := db.Pool.QueryRow(ctx, `SELECT value, updated_at FROM exam_settings WHERE key = $1`, settingsKey).Scan(&value, &updatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Setting{Policies: Policies{}.withDefaults()}, nil
+	}
+	if err != nil {
+		return Setting{}, fmt.Errorf("failed to load retention policies: %w", err)
+	}
+
+	var policies Policies
+	if err := json.Unmarshal(value, &policies); err != nil {
+		return Setting{}, fmt.Errorf("stored retention policies are invalid: %s", value)
+	}
+	return Setting{Policies: policies.withDefaults(), UpdatedAt: &updatedAt}, nil
+}
+
+// Save validates and stores the policies, replacing the previous ones; classes left out are
+// kept indefinitely
+func Save(ctx context.Context, policies Policies) (Setting, error) {
+	if err := policies.Validate(); err != nil {
+		return Setting{}, err
+	}
+	policies = policies.withDefaults()
+	value, err := json.Marshal(policies)
+	if err != nil {
+		return Setting{}, err
+	}
+
+	query := `
+		INSERT INTO exam_settings (key, value, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()
+		RETURNING updated_at
+	`
+	var updatedAt time.Time
+	if err := db.Pool.QueryRow(ctx, query, settingsKey, value).Scan(&updatedAt); err != nil {
+		return Setting{}, fmt.Errorf("failed to save retention policies: %w", err)
+	}
+	return Setting{Policies: policies, UpdatedAt: &updatedAt}, nil
+}
+
+// ClassNames lists the data classes, sorted
+func ClassNames() []string {
+	names := make([]string, 0, len(classes))
+	for _, c := range classes {
+		names = append(names, c.name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,486 @@
+// Package retention removes personal data once it is no longer needed after the event. Each
+// data class has its own policy: after a number of days its rows are anonymized (personal
+// fields blanked, the rest kept for statistics) or deleted. Policies are applied by the
+// scheduled function ApplyRetentionPolicies or on demand; a dry run reports what they would
+// affect without changing anything, and every applied run is recorded in retention_runs.
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"mcq-exam/jobs"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// FunctionName is the scheduled job function that applies the policies
+const FunctionName = "ApplyRetentionPolicies"
+
+// Data classes
+const (
+	// ClassStudents is the student record, aged by the student's last activity (created,
+	// updated, or a session started or completed). Anonymizing keeps sessions and results
+	// under a placeholder name and blanks the student's copies in logs and audit trails;
+	// deleting removes the student with everything they own.
+	ClassStudents = "students"
+	// ClassRegistrations is self-registration requests
+	ClassRegistrations = "registrations"
+	// ClassEmailLogs is sent email logs, tracking and provider events, bounces and the email
+	// outbox
+	ClassEmailLogs = "email_logs"
+	// ClassNotificationLogs is SMS and WhatsApp message logs
+	ClassNotificationLogs = "notification_logs"
+	// ClassClientEvents is the IP addresses and browsers recorded by verifications, access
+	// code changes and verify-otp attempts
+	ClassClientEvents = "client_events"
+	// ClassArchives is the students' names and emails in archived results, aged by when they
+	// were archived. Anonymizing keeps the ranks and scores; deleting removes the results and
+	// keeps each archive's statistics.
+	ClassArchives = "exam_archives"
+)
+
+// Triggers recorded on a run
+const (
+	TriggerScheduler = "scheduler"
+	TriggerManual    = "manual"
+)
+
+// redactedEmail replaces addresses in rows that are anonymized without their student
+const redactedEmail = "redacted@anonymized.invalid"
+
+// AnonymizedReason is the email_invalid_reason of anonymized students, so every send path
+// skips them
+const AnonymizedReason = "anonymized"
+
+// batchSize is how many students are anonymized or deleted per transaction
+const batchSize = 500
+
+// ErrRunning is returned while another run is applying the policies on this instance
+var ErrRunning = errors.New("a retention run is already in progress")
+
+// target is one table of a data class, aged by its own timestamp column
+type target struct {
+	table string
+	age   string
+	// anonymize is the SET clause blanking the personal columns; done matches rows it has
+	// already blanked, so they are not counted again
+	anonymize string
+	done      string
+}
+
+// class is a kind of personal data with its own policy. The students class has no targets;
+// it acts on whole students (see anonymizeStudents and deleteStudents).
+type class struct {
+	name    string
+	targets []target
+}
+
+var classes = []class{
+	{name: ClassStudents},
+	{name: ClassRegistrations, targets: []target{
+		{table: "registrations", age: "created_at",
+			anonymize: `name = 'Anonymized', email = '` + redactedEmail + `', institution = NULL, phone = NULL, designation = NULL, ip = NULL, user_agent = NULL`,
+			done:      `email = '` + redactedEmail + `'`},
+	}},
+	{name: ClassEmailLogs, targets: []target{
+		{table: "email_logs", age: "sent_at",
+			anonymize: `email = '` + redactedEmail + `', response_message = NULL, provider_response = NULL`,
+			done:      `email = '` + redactedEmail + `'`},
+		{table: "email_events", age: "created_at",
+			anonymize: `email = NULL, ip_address = NULL, user_agent = NULL, details = NULL`,
+			done:      `email IS NULL AND ip_address IS NULL AND user_agent IS NULL AND details IS NULL`},
+		{table: "email_bounces", age: "created_at",
+			anonymize: `email = '` + redactedEmail + `', reason = NULL`,
+			done:      `email = '` + redactedEmail + `'`},
+		{table: "email_outbox", age: "created_at",
+			anonymize: `to_email = '` + redactedEmail + `', to_name = '', html_body = '', last_error = NULL,
+			           status = CASE WHEN status IN ('pending', 'sending') THEN 'skipped' ELSE status END`,
+			done: `to_email = '` + redactedEmail + `'`},
+	}},
+	{name: ClassNotificationLogs, targets: []target{
+		{table: "notification_logs", age: "sent_at",
+			anonymize: `phone = '', response_message = NULL, provider_response = NULL`,
+			done:      `phone = ''`},
+	}},
+	{name: ClassClientEvents, targets: []target{
+		{table: "client_events", age: "created_at",
+			anonymize: `ip = NULL, user_agent = NULL`,
+			done:      `ip IS NULL AND user_agent IS NULL`},
+		{table: "access_code_events", age: "created_at",
+			anonymize: `ip = NULL, user_agent = NULL`,
+			done:      `ip IS NULL AND user_agent IS NULL`},
+		{table: "otp_attempts", age: "created_at",
+			anonymize: `ip = NULL, user_agent = NULL`,
+			done:      `ip IS NULL AND user_agent IS NULL`},
+		{table: "email_change_requests", age: "created_at",
+			anonymize: `ip = NULL, user_agent = NULL`,
+			done:      `ip IS NULL AND user_agent IS NULL`},
+	}},
+	{name: ClassArchives, targets: []target{
+		{table: "exam_archive_results", age: "created_at",
+			anonymize: `name = 'Anonymized student', email = '` + redactedEmail + `', institution = NULL, designation = NULL`,
+			done:      `email = '` + redactedEmail + `'`},
+	}},
+}
+
+func classByName(name string) *class {
+	for i := range classes {
+		if classes[i].name == name {
+			return &classes[i]
+		}
+	}
+	return nil
+}
+
+// ClassReport is what one class's policy affected, or would affect in a dry run
+type ClassReport struct {
+	Class  string    `json:"class"`
+	Action string    `json:"action"`
+	Days   int       `json:"days"`
+	Cutoff time.Time `json:"cutoff"`
+	// Rows counts the rows anonymized or deleted by table. A dry run counts students and the
+	// target tables only, not the students' rows in other tables.
+	Rows       map[string]int64 `json:"rows"`
+	StudentIDs []int            `json:"student_ids,omitempty"`
+}
+
+// Report is the outcome of a run
+type Report struct {
+	// RunID is the retention_runs row of an applied run
+	RunID      int           `json:"run_id,omitempty"`
+	DryRun     bool          `json:"dry_run"`
+	Policies   Policies      `json:"policies"`
+	Classes    []ClassReport `json:"classes"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+}
+
+// Options says how a run was requested
+type Options struct {
+	DryRun    bool
+	Trigger   string
+	IP        string
+	UserAgent string
+}
+
+// running keeps two runs on this instance from applying the policies at once
+var running sync.Mutex
+
+// Run applies the stored policies, oldest data first by class, or with opts.DryRun only
+// reports what they would affect. An applied run is recorded in retention_runs, with the
+// classes finished so far when it fails part way.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	setting, err := Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{DryRun: opts.DryRun, Policies: setting.Policies, Classes: []ClassReport{}, StartedAt: time.Now()}
+
+	if opts.DryRun {
+		for _, name := range setting.Policies.active() {
+			classReport, err := preview(ctx, name, setting.Policies[name], report.StartedAt)
+			if err != nil {
+				return nil, err
+			}
+			report.Classes = append(report.Classes, classReport)
+		}
+		report.FinishedAt = time.Now()
+		return report, nil
+	}
+
+	if !running.TryLock() {
+		return nil, ErrRunning
+	}
+	defer running.Unlock()
+
+	policiesJSON, err := json.Marshal(setting.Policies)
+	if err != nil {
+		return nil, err
+	}
+	insertQuery := `
+		INSERT INTO retention_runs (trigger, policies, ip, user_agent)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''))
+		RETURNING id, started_at
+	`
+	if err := db.Pool.QueryRow(ctx, insertQuery, opts.Trigger, policiesJSON, opts.IP, opts.UserAgent).Scan(&report.RunID, &report.StartedAt); err != nil {
+		return nil, fmt.Errorf("failed to record retention run: %w", err)
+	}
+
+	var runErr error
+	for _, name := range setting.Policies.active() {
+		classReport, err := apply(ctx, name, setting.Policies[name], report.StartedAt)
+		report.Classes = append(report.Classes, classReport)
+		if err != nil {
+			runErr = fmt.Errorf("%s: %w", name, err)
+			break
+		}
+		log.Info().Int("run_id", report.RunID).Str("class", name).Str("action", classReport.Action).
+			Interface("rows", classReport.Rows).Msg("Retention policy applied")
+	}
+	report.FinishedAt = time.Now()
+
+	// Recorded even when the run was cancelled, so the audit shows what was already removed
+	finishCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	classesJSON, err := json.Marshal(report.Classes)
+	if err != nil {
+		return nil, err
+	}
+	var errMsg *string
+	if runErr != nil {
+		msg := runErr.Error()
+		errMsg = &msg
+	}
+	finishQuery := `UPDATE retention_runs SET classes = $2, error = $3, finished_at = $4 WHERE id = $1`
+	if _, err := db.Pool.Exec(finishCtx, finishQuery, report.RunID, classesJSON, errMsg, report.FinishedAt); err != nil {
+		log.Error().Err(err).Int("run_id", report.RunID).Msg("Failed to record retention run result")
+	}
+	if runErr != nil {
+		return report, runErr
+	}
+	return report, nil
+}
+
+// cutoffFor returns the time before which a policy applies
+func cutoffFor(policy Policy, now time.Time) time.Time {
+	return now.AddDate(0, 0, -policy.Days)
+}
+
+// dueStudents returns the students whose last activity is before cutoff; for anonymizing,
+// those not anonymized yet
+func dueStudents(ctx context.Context, cutoff time.Time, action string) ([]int, error) {
+	query := `
+		SELECT s.id
+		FROM students s
+		LEFT JOIN LATERAL (
+			SELECT MAX(GREATEST(started_at, completed_at)) AS last_active
+			FROM sessions
+			WHERE student_id = s.id
+		) sess ON true
+		WHERE GREATEST(s.created_at, s.updated_at, sess.last_active) < $1
+		  AND ($2 OR s.anonymized_at IS NULL)
+		ORDER BY s.id
+	`
+	rows, err := db.Pool.Query(ctx, query, cutoff, action == ActionDelete)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find students: %w", err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, fmt.Errorf("failed to find students: %w", err)
+	}
+	return ids, nil
+}
+
+// where returns the condition selecting a target's rows the policy applies to
+func (t target) where(action string) string {
+	if action == ActionDelete {
+		return t.age + ` < $1`
+	}
+	return t.age + ` < $1 AND NOT (` + t.done + `)`
+}
+
+func preview(ctx context.Context, name string, policy Policy, now time.Time) (ClassReport, error) {
+	report := ClassReport{Class: name, Action: policy.Action, Days: policy.Days, Cutoff: cutoffFor(policy, now), Rows: map[string]int64{}}
+
+	if name == ClassStudents {
+		ids, err := dueStudents(ctx, report.Cutoff, policy.Action)
+		if err != nil {
+			return report, err
+		}
+		report.Rows["students"] = int64(len(ids))
+		report.StudentIDs = ids
+		return report, nil
+	}
+
+	for _, t := range classByName(name).targets {
+		var count int64
+		if err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM `+t.table+` WHERE `+t.where(policy.Action), report.Cutoff).Scan(&count); err != nil {
+			return report, fmt.Errorf("failed to count %s: %w", t.table, err)
+		}
+		report.Rows[t.table] = count
+	}
+	return report, nil
+}
+
+func apply(ctx context.Context, name string, policy Policy, now time.Time) (ClassReport, error) {
+	report := ClassReport{Class: name, Action: policy.Action, Days: policy.Days, Cutoff: cutoffFor(policy, now), Rows: map[string]int64{}}
+
+	if name == ClassStudents {
+		ids, err := dueStudents(ctx, report.Cutoff, policy.Action)
+		if err != nil {
+			return report, err
+		}
+		report.StudentIDs = []int{}
+		for start := 0; start < len(ids); start += batchSize {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			batch := ids[start:min(start+batchSize, len(ids))]
+			err := db.WithTx(ctx, func(tx pgx.Tx) error {
+				if policy.Action == ActionDelete {
+					return deleteStudents(ctx, tx, batch, report.Rows)
+				}
+				return anonymizeStudents(ctx, tx, batch, report.Rows)
+			})
+			if err != nil {
+				return report, err
+			}
+			report.StudentIDs = append(report.StudentIDs, batch...)
+		}
+		return report, nil
+	}
+
+	for _, t := range classByName(name).targets {
+		query := `UPDATE ` + t.table + ` SET ` + t.anonymize + ` WHERE ` + t.where(policy.Action)
+		if policy.Action == ActionDelete {
+			query = `DELETE FROM ` + t.table + ` WHERE ` + t.where(policy.Action)
+		}
+		tag, err := db.Pool.Exec(ctx, query, report.Cutoff)
+		if err != nil {
+			return report, fmt.Errorf("failed to %s %s: %w", policy.Action, t.table, err)
+		}
+		report.Rows[t.table] = tag.RowsAffected()
+	}
+	return report, nil
+}
+
+// anonymizedStudentQueries blank a student's personal data, $1 being the student IDs. The
+// student gets a placeholder name and an address on the reserved .invalid domain, which the
+// student's copies in campaigns, logs and registrations take too. Answer events are
+// append-only and keep their IP address and browser.
+var anonymizedStudentQueries = []struct {
+	table string
+	query string
+}{
+	{"students", `
+		UPDATE students
+		SET name = 'Anonymized student', email = 'anonymized-' || id || '@anonymized.invalid',
+		    institution = NULL, phone = NULL, designation = NULL,
+		    email_valid = false, email_invalid_reason = '` + AnonymizedReason + `', anonymized_at = NOW()
+		WHERE id = ANY($1)`},
+	{"sessions", `UPDATE sessions SET student_email = NULL, start_ip = NULL, start_user_agent = NULL WHERE student_id = ANY($1)`},
+	{"email_tracking", `UPDATE email_tracking SET recipient_email = NULL WHERE student_id = ANY($1)`},
+	{"email_campaign_recipients", `
+		UPDATE email_campaign_recipients r SET email = s.email
+		FROM students s
+		WHERE s.id = r.student_id AND r.student_id = ANY($1)`},
+	{"email_logs", `
+		UPDATE email_logs l SET email = s.email, response_message = NULL, provider_response = NULL
+		FROM students s
+		WHERE s.id = l.student_id AND l.student_id = ANY($1)`},
+	{"email_outbox", `DELETE FROM email_outbox WHERE student_id = ANY($1)`},
+	{"email_events", `UPDATE email_events SET email = NULL, ip_address = NULL, user_agent = NULL, details = NULL WHERE student_id = ANY($1)`},
+	{"notification_logs", `UPDATE notification_logs SET phone = '', response_message = NULL, provider_response = NULL WHERE student_id = ANY($1)`},
+	{"registrations", `
+		UPDATE registrations r
+		SET name = 'Anonymized student', email = s.email, institution = NULL, phone = NULL, designation = NULL, ip = NULL, user_agent = NULL
+		FROM students s
+		WHERE s.id = r.student_id AND r.student_id = ANY($1)`},
+	{"access_code_events", `UPDATE access_code_events SET ip = NULL, user_agent = NULL WHERE student_id = ANY($1)`},
+	{"otp_attempts", `UPDATE otp_attempts SET ip = NULL, user_agent = NULL WHERE student_id = ANY($1)`},
+	{"client_events", `UPDATE client_events SET ip = NULL, user_agent = NULL WHERE student_id = ANY($1)`},
+	{"student_merges", `
+		UPDATE student_merges m
+		SET kept_email = s.email, merged_email = s.email, final_email = s.email,
+		    merged_student = jsonb_build_object('id', m.merged_student_id)
+		FROM students s
+		WHERE s.id = m.kept_student_id AND m.kept_student_id = ANY($1)`},
+	{"student_email_changes", `DELETE FROM student_email_changes WHERE student_id = ANY($1)`},
+	{"email_change_requests", `DELETE FROM email_change_requests WHERE student_id = ANY($1)`},
+	{"result_tokens", `DELETE FROM result_tokens WHERE student_id = ANY($1)`},
+}
+
+func anonymizeStudents(ctx context.Context, tx pgx.Tx, ids []int, rows map[string]int64) error {
+	for _, q := range anonymizedStudentQueries {
+		tag, err := tx.Exec(ctx, q.query, ids)
+		if err != nil {
+			return fmt.Errorf("failed to anonymize %s: %w", q.table, err)
+		}
+		rows[q.table] += tag.RowsAffected()
+	}
+	return nil
+}
+
+// deleteStudents removes the students; their sessions, answers, logs and tokens go with them
+// (ON DELETE CASCADE). Registrations and merge records would outlive them, so they are
+// removed first.
+func deleteStudents(ctx context.Context, tx pgx.Tx, ids []int, rows map[string]int64) error {
+	queries := []struct {
+		table string
+		query string
+	}{
+		{"registrations", `DELETE FROM registrations WHERE student_id = ANY($1)`},
+		{"student_merges", `DELETE FROM student_merges WHERE kept_student_id = ANY($1)`},
+		{"students", `DELETE FROM students WHERE id = ANY($1)`},
+	}
+	for _, q := range queries {
+		tag, err := tx.Exec(ctx, q.query, ids)
+		if err != nil {
+			return fmt.Errorf("failed to delete %s: %w", q.table, err)
+		}
+		rows[q.table] += tag.RowsAffected()
+	}
+	return nil
+}
+
+// ApplyPolicies is the scheduled function: it applies the stored policies like
+// POST /api/admin/retention/run. A run already in progress is left to finish.
+func ApplyPolicies(ctx context.Context, run *jobs.Run) error {
+	report, err := Run(ctx, Options{Trigger: TriggerScheduler})
+	if errors.Is(err, ErrRunning) {
+		log.Info().Msg("Retention run already in progress, skipping")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(report.Classes) == 0 {
+		log.Info().Int("run_id", report.RunID).Msg("No retention policies in effect")
+	}
+	return nil
+}
+
+// RunRecord is a retention_runs row
+type RunRecord struct {
+	ID         int           `json:"id"`
+	Trigger    string        `json:"trigger"`
+	Policies   Policies      `json:"policies"`
+	Classes    []ClassReport `json:"classes"`
+	Error      *string       `json:"error"`
+	IP         *string       `json:"ip"`
+	UserAgent  *string       `json:"user_agent"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt *time.Time    `json:"finished_at"`
+}
+
+// Runs returns the latest applied runs, newest first
+func Runs(ctx context.Context, limit int) ([]RunRecord, error) {
+	query := `
+		SELECT id, trigger, policies, classes, error, ip, user_agent, started_at, finished_at
+		FROM retention_runs
+		ORDER BY id DESC
+		LIMIT $1
+	`
+	rows, err := db.Pool.Query(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch retention runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []RunRecord{}
+	for rows.Next() {
+		var r RunRecord
+		if err := rows.Scan(&r.ID, &r.Trigger, &r.Policies, &r.Classes, &r.Error, &r.IP, &r.UserAgent, &r.StartedAt, &r.FinishedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan retention run: %w", err)
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
@@ -2,84 +2,73 @@ package scheduler
 
 import (
 	"context"
-	"log"
-	"mcq-exam/db"
+	"mcq-exam/jobs"
+	"mcq-exam/metrics"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
-// StartScheduler starts the cron job that checks for scheduled functions every minute
+// StartScheduler starts the cron job that checks for due scheduled jobs every minute.
+// It stops when jobs.Context() is cancelled, after the function in progress has saved its state.
 func StartScheduler() {
-	log.Println("Starting event scheduler (checks every minute)...")
-
-	ticker := time.NewTicker(1 * time.Minute)
-	go func() {
-		for range ticker.C {
-			checkAndExecuteSchedules()
-		}
-	}()
-
-	// Also check immediately on start
-	go checkAndExecuteSchedules()
-}
+	log.Info().Msg("Starting job scheduler (checks every minute)...")
 
-// checkAndExecuteSchedules checks for pending scheduled functions and executes them
-func checkAndExecuteSchedules() {
+	// Runs cut short by a crash are resumed like ones interrupted by a graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// Use UTC for comparison (database stores times in UTC)
-	now := time.Now().UTC()
-
-	// Check for first function to execute
-	query := `
-		SELECT id, first_function
-		FROM event_schedule
-		WHERE first_executed = false
-		  AND first_scheduled_time <= $1
-		ORDER BY first_scheduled_time ASC
-		LIMIT 1
-	`
+	if err := jobs.MarkStaleRuns(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to check for stale job runs")
+	}
+	if err := markStaleExecutions(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to check for stale job executions")
+	}
+	cancel()
 
-	var scheduleID int
-	var functionName string
-	err := db.Pool.QueryRow(ctx, query, now).Scan(&scheduleID, &functionName)
-	if err == nil {
-		log.Printf("Found scheduled first function: %s (schedule_id: %d)", functionName, scheduleID)
+	jobs.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
 
-		// Execute function
-		success := ExecuteFunction(functionName)
+		// Also check immediately on start
+		checkAndExecuteSchedules(ctx)
 
-		if success {
-			// Mark as executed
-			updateQuery := `UPDATE event_schedule SET first_executed = true, first_executed_at = NOW() WHERE id = $1`
-			_, _ = db.Pool.Exec(context.Background(), updateQuery, scheduleID)
-			log.Printf("Marked first function as executed (schedule_id: %d)", scheduleID)
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info().Msg("Job scheduler stopped")
+				return
+			case <-ticker.C:
+				checkAndExecuteSchedules(ctx)
+			}
 		}
-	}
+	})
+}
 
-	// Check for second function to execute
-	query = `
-		SELECT id, second_function
-		FROM event_schedule
-		WHERE second_executed = false
-		  AND second_scheduled_time <= $1
-		  AND first_executed = true
-		ORDER BY second_scheduled_time ASC
-		LIMIT 1
-	`
+// checkAndExecuteSchedules runs every due job, earliest first, one at a time
+func checkAndExecuteSchedules(jobCtx context.Context) {
+	metrics.SchedulerChecksTotal.Inc()
 
-	err = db.Pool.QueryRow(ctx, query, now).Scan(&scheduleID, &functionName)
-	if err == nil {
-		log.Printf("Found scheduled second function: %s (schedule_id: %d)", functionName, scheduleID)
+	// Jobs due at the start of this check; anything rescheduled into the past
+	// while we run (e.g. a re-run request) is picked up by the next check
+	now := time.Now().UTC()
+
+	for jobCtx.Err() == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		job, err := nextDueJob(ctx, now)
+		cancel()
+		if err != nil {
+			return
+		}
 
-		// Execute function
-		success := ExecuteFunction(functionName)
+		log.Info().Int("job_id", job.ID).Str("name", job.Name).Str("function", job.FunctionName).Msg("Found due job")
 
-		if success {
-			// Mark as executed
-			updateQuery := `UPDATE event_schedule SET second_executed = true, second_executed_at = NOW() WHERE id = $1`
-			_, _ = db.Pool.Exec(context.Background(), updateQuery, scheduleID)
-			log.Printf("Marked second function as executed (schedule_id: %d)", scheduleID)
+		switch status, runErr := ExecuteFunction(jobCtx, job); status {
+		case jobs.StatusCompleted:
+			recordSuccess(job)
+		case jobs.StatusFailed:
+			recordFailure(job, runErr)
+		default:
+			// Interrupted: the job stays due and its run resumes on restart
+			return
 		}
 	}
 }
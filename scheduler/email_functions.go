@@ -5,73 +5,80 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
-	"log"
+	"mcq-exam/config"
 	"mcq-exam/db"
+	"mcq-exam/i18n"
+	"mcq-exam/jobs"
+	"mcq-exam/outbox"
+	"mcq-exam/suppression"
+	"mcq-exam/tracking"
 	"mcq-exam/utils"
-	"os"
 	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
 )
 
-// SendFirstEmailToAll sends conference email to all students with tracking pixel
-func SendFirstEmailToAll() {
-	log.Printf("[%s] EXECUTING: SendFirstEmailToAll - Sending conference emails", time.Now().Format(time.RFC3339))
+// SendFirstEmailToAll queues the conference email, with tracking pixel, to all students in the
+// outbox, in the same transaction as each student's new conference token
+func SendFirstEmailToAll(jobCtx context.Context, run *jobs.Run) error {
+	log.Info().Str("function", "SendFirstEmailToAll").Msg("Sending conference emails")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Get all students
-	query := `SELECT id, name, email FROM students ORDER BY id`
-	rows, err := db.Pool.Query(ctx, query)
+	// Get all students (after the last one handled if resuming)
+	query := `SELECT id, name, email, COALESCE(locale, '') FROM students WHERE id > $1 AND is_sandbox = false ORDER BY id`
+	rows, err := db.Pool.Query(ctx, query, run.LastStudentID)
 	if err != nil {
-		log.Printf("ERROR: Failed to fetch students: %v", err)
-		return
+		return fmt.Errorf("failed to fetch students: %w", err)
 	}
 	defer rows.Close()
 
 	type Student struct {
-		ID    int
-		Name  string
-		Email string
+		ID     int
+		Name   string
+		Email  string
+		Locale string
 	}
 
 	var students []Student
 	for rows.Next() {
 		var s Student
-		if err := rows.Scan(&s.ID, &s.Name, &s.Email); err != nil {
+		if err := rows.Scan(&s.ID, &s.Name, &s.Email, &s.Locale); err != nil {
 			continue
 		}
 		students = append(students, s)
 	}
 
 	if len(students) == 0 {
-		log.Printf("WARNING: No students found to send emails")
-		return
+		log.Warn().Msg("No students found to send emails")
+		return nil
 	}
+	run.SetRemaining(len(students))
 
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "https://nicm.smart-mcq.com"
-	}
+	frontendURL := config.FrontendURL()
 
-	sentCount := 0
+	queuedCount := 0
+	skippedCount := 0
+	defer outbox.Notify()
 	for _, student := range students {
-		// Generate conference token
-		token := generateConferenceToken()
+		// Stop between students on shutdown; progress so far is already saved
+		if jobCtx.Err() != nil {
+			log.Info().Str("function", "SendFirstEmailToAll").Int("queued", queuedCount).Int("total", len(students)).Msg("Interrupted by shutdown")
+			return nil
+		}
 
-		// Store token in email_tracking
-		insertQuery := `
-			INSERT INTO email_tracking (student_id, email_type, conference_token, opened, created_at)
-			VALUES ($1, 'first', $2, false, NOW())
-			ON CONFLICT (student_id, email_type)
-			DO UPDATE SET conference_token = $2, updated_at = NOW()
-		`
-		// Note: Need unique constraint on (student_id, email_type) - will add in migration
-		_, err := db.Pool.Exec(context.Background(), insertQuery, student.ID, token)
-		if err != nil {
-			log.Printf("Failed to store token for student %d: %v", student.ID, err)
+		// Skip known-bad addresses
+		if suppression.IsSuppressed(jobCtx, student.Email) {
+			skippedCount++
+			run.Record(student.ID, false)
 			continue
 		}
 
+		// Generate conference token
+		token := generateConferenceToken()
+
 		// Conference link with token
 		conferenceLink := fmt.Sprintf("%s/live?token=%s", frontendURL, token)
 
@@ -87,26 +94,52 @@ func SendFirstEmailToAll() {
 				<p>Best regards,<br>SmartMCQ Team</p>
 			</div>
 		`, student.Name, conferenceLink)
+		subject, htmlBody := i18n.Localize(jobCtx, i18n.TemplateFirstMail, student.Locale, "Conference Invitation - SmartMCQ", htmlBody,
+			map[string]string{"name": student.Name, "link": conferenceLink})
 
-		params := utils.SendEmailParams{
-			ToEmail:  student.Email,
-			ToName:   student.Name,
-			Subject:  "Conference Invitation - SmartMCQ",
-			HTMLBody: htmlBody,
+		msg := outbox.Message{
+			StudentID: student.ID,
+			EmailType: tracking.FirstMail,
+			Source:    "scheduler",
+			ToEmail:   student.Email,
+			ToName:    student.Name,
+			Subject:   subject,
+			HTMLBody:  htmlBody,
 		}
 
-		_, err = utils.SendEmail(params)
+		// Store the token in email_tracking and queue the email carrying it together; the
+		// outbox relay sends it
+		insertQuery := `
+			INSERT INTO email_tracking (student_id, email_type, conference_token, opened, created_at)
+			VALUES ($1, 'firstMail', $2, false, NOW())
+			ON CONFLICT (student_id, email_type)
+			DO UPDATE SET conference_token = $2, recipient_email = NULL, updated_at = NOW()
+		`
+		txCtx, txCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := db.WithTx(txCtx, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(txCtx, insertQuery, student.ID, token); err != nil {
+				return fmt.Errorf("failed to store conference token: %w", err)
+			}
+			return outbox.Enqueue(txCtx, tx, msg)
+		})
+		txCancel()
 		if err != nil {
-			log.Printf("Failed to send email to %s: %v", student.Email, err)
+			log.Error().Err(err).Int("student_id", student.ID).Str("email", student.Email).Msg("Failed to queue email")
 		} else {
-			sentCount++
+			queuedCount++
+			if queuedCount%50 == 0 {
+				outbox.Notify()
+			}
 		}
-
-		// Small delay to avoid rate limiting
-		time.Sleep(100 * time.Millisecond)
+		run.Record(student.ID, err == nil)
 	}
 
-	log.Printf("[%s] COMPLETED: SendFirstEmailToAll - Sent %d/%d emails", time.Now().Format(time.RFC3339), sentCount, len(students))
+	log.Info().Str("function", "SendFirstEmailToAll").Int("queued", queuedCount).Int("total", len(students)).Int("suppressed", skippedCount).
+		Msg("Conference emails queued")
+	if queuedCount == 0 && skippedCount < len(students) {
+		return fmt.Errorf("no emails queued (0/%d)", len(students))
+	}
+	return nil
 }
 
 // generateConferenceToken generates a secure random token
@@ -117,25 +150,25 @@ func generateConferenceToken() string {
 }
 
 // SendSecondEmailToEligible sends test invitation to students who attended conference
-func SendSecondEmailToEligible() {
-	log.Printf("[%s] EXECUTING: SendSecondEmailToEligible - Sending test invitations", time.Now().Format(time.RFC3339))
+func SendSecondEmailToEligible(jobCtx context.Context, run *jobs.Run) error {
+	log.Info().Str("function", "SendSecondEmailToEligible").Msg("Sending test invitations")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	// Get students who attended conference (verified token)
 	query := `
-		SELECT et.student_id, s.name, s.email, et.access_code
+		SELECT et.student_id, s.name, s.email, COALESCE(s.locale, ''), et.access_code
 		FROM email_tracking et
 		JOIN students s ON et.student_id = s.id
-		WHERE et.email_type = 'first' AND et.conference_attended = true AND et.access_code IS NOT NULL
-		ORDER BY et.conference_attended_at DESC
+		WHERE et.email_type = 'firstMail' AND et.conference_attended = true AND et.access_code IS NOT NULL
+		  AND et.student_id > $1 AND s.is_sandbox = false
+		ORDER BY et.student_id ASC
 	`
 
-	rows, err := db.Pool.Query(ctx, query)
+	rows, err := db.Pool.Query(ctx, query, run.LastStudentID)
 	if err != nil {
-		log.Printf("ERROR: Failed to fetch eligible students: %v", err)
-		return
+		return fmt.Errorf("failed to fetch eligible students: %w", err)
 	}
 	defer rows.Close()
 
@@ -143,30 +176,43 @@ func SendSecondEmailToEligible() {
 		ID         int
 		Name       string
 		Email      string
+		Locale     string
 		AccessCode string
 	}
 
 	var students []EligibleStudent
 	for rows.Next() {
 		var s EligibleStudent
-		if err := rows.Scan(&s.ID, &s.Name, &s.Email, &s.AccessCode); err != nil {
+		if err := rows.Scan(&s.ID, &s.Name, &s.Email, &s.Locale, &s.AccessCode); err != nil {
 			continue
 		}
 		students = append(students, s)
 	}
 
 	if len(students) == 0 {
-		log.Printf("WARNING: No eligible students found (no one attended conference)")
-		return
+		log.Warn().Msg("No eligible students found (no one attended conference)")
+		return nil
 	}
+	run.SetRemaining(len(students))
 
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "https://nicm.smart-mcq.com"
-	}
+	frontendURL := config.FrontendURL()
 
 	sentCount := 0
+	skippedCount := 0
 	for _, student := range students {
+		// Stop between sends on shutdown; progress so far is already saved
+		if jobCtx.Err() != nil {
+			log.Info().Str("function", "SendSecondEmailToEligible").Int("sent", sentCount).Int("total", len(students)).Msg("Interrupted by shutdown")
+			return nil
+		}
+
+		// Skip known-bad addresses
+		if suppression.IsSuppressed(jobCtx, student.Email) {
+			skippedCount++
+			run.Record(student.ID, false)
+			continue
+		}
+
 		// Email body with access code
 		htmlBody := fmt.Sprintf(`
 			<div style="font-family: Arial, sans-serif; max-width: 600px; margin: 0 auto;">
@@ -182,24 +228,31 @@ func SendSecondEmailToEligible() {
 				<p>Best regards,<br>SmartMCQ Team</p>
 			</div>
 		`, student.Name, student.AccessCode, frontendURL)
+		subject, htmlBody := i18n.Localize(jobCtx, i18n.TemplateSecondMail, student.Locale, "Test Invitation - Your Access Code", htmlBody,
+			map[string]string{"name": student.Name, "link": frontendURL + "/test", "access_code": student.AccessCode})
 
 		params := utils.SendEmailParams{
 			ToEmail:  student.Email,
 			ToName:   student.Name,
-			Subject:  "Test Invitation - Your Access Code",
+			Subject:  subject,
 			HTMLBody: htmlBody,
+			Tracking: &tracking.Target{StudentID: student.ID, EmailType: tracking.SecondMail},
 		}
 
-		_, err := utils.SendEmail(params)
+		_, err := utils.SendEmail(jobCtx, params)
 		if err != nil {
-			log.Printf("Failed to send email to %s: %v", student.Email, err)
+			log.Error().Err(err).Int("student_id", student.ID).Str("email", student.Email).Msg("Failed to send email")
 		} else {
 			sentCount++
+			tracking.RecordSent(student.ID, tracking.SecondMail)
 		}
-
-		// Small delay to avoid rate limiting
-		time.Sleep(100 * time.Millisecond)
+		run.Record(student.ID, err == nil)
 	}
 
-	log.Printf("[%s] COMPLETED: SendSecondEmailToEligible - Sent %d/%d emails", time.Now().Format(time.RFC3339), sentCount, len(students))
+	log.Info().Str("function", "SendSecondEmailToEligible").Int("sent", sentCount).Int("total", len(students)).Int("suppressed", skippedCount).
+		Msg("Test invitations sent")
+	if sentCount == 0 && skippedCount < len(students) {
+		return fmt.Errorf("no emails sent (0/%d)", len(students))
+	}
+	return nil
 }
@@ -0,0 +1,195 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mcq-exam/db"
+	"mcq-exam/jobs"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Who started an execution
+const (
+	TriggerScheduler = "scheduler"
+	TriggerManual    = "manual"
+)
+
+var (
+	ErrUnknownFunction  = errors.New("unknown function")
+	ErrAlreadyRunning   = errors.New("function is already running")
+	ErrAlreadyCompleted = errors.New("function has already completed a run; set force to run it again")
+)
+
+// Execution is a row of job_executions: one call of a registered function
+type Execution struct {
+	ID           int        `json:"id"`
+	JobID        *int       `json:"job_id"`
+	RunID        *int       `json:"run_id"`
+	FunctionName string     `json:"function_name"`
+	TriggeredBy  string     `json:"triggered_by"`
+	Forced       bool       `json:"forced"`
+	Status       string     `json:"status"`
+	Processed    int        `json:"processed"`
+	Sent         int        `json:"sent"`
+	Error        *string    `json:"error"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at"`
+	DurationMs   *int64     `json:"duration_ms"`
+}
+
+// ExecutionColumns is the column list matching ScanExecution
+const ExecutionColumns = `id, job_id, run_id, function_name, triggered_by, forced, status, processed, sent, error,
+	started_at, finished_at, duration_ms`
+
+// ScanExecution reads a row selected with ExecutionColumns
+func ScanExecution(row interface{ Scan(dest ...any) error }) (*Execution, error) {
+	var e Execution
+	err := row.Scan(&e.ID, &e.JobID, &e.RunID, &e.FunctionName, &e.TriggeredBy, &e.Forced, &e.Status, &e.Processed,
+		&e.Sent, &e.Error, &e.StartedAt, &e.FinishedAt, &e.DurationMs)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+var (
+	runningMu sync.Mutex
+	// running holds the functions executing in this process, so a manual trigger and the
+	// scheduler never send the same emails twice at once
+	running = map[string]bool{}
+)
+
+func claimFunction(functionName string) bool {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	if running[functionName] {
+		return false
+	}
+	running[functionName] = true
+	return true
+}
+
+func releaseFunction(functionName string) {
+	runningMu.Lock()
+	delete(running, functionName)
+	runningMu.Unlock()
+}
+
+// markStaleExecutions flags executions left running by a previous process as interrupted
+func markStaleExecutions(ctx context.Context) error {
+	query := `
+		UPDATE job_executions
+		SET status = 'interrupted', finished_at = NOW(),
+		    duration_ms = (EXTRACT(EPOCH FROM NOW() - started_at) * 1000)::bigint
+		WHERE status = 'running'
+	`
+	result, err := db.Pool.Exec(ctx, query)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() > 0 {
+		log.Info().Int64("executions", result.RowsAffected()).Msg("Job executions left running by a previous process marked as interrupted")
+	}
+	return nil
+}
+
+// startExecution records an execution as running. Returns 0 if it could not be recorded;
+// the function still runs, it is only missing from the history.
+func startExecution(jobID int, functionName, triggeredBy string, forced bool) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var id int
+	query := `
+		INSERT INTO job_executions (job_id, function_name, triggered_by, forced)
+		VALUES (NULLIF($1, 0), $2, $3, $4)
+		RETURNING id
+	`
+	if err := db.Pool.QueryRow(ctx, query, jobID, functionName, triggeredBy, forced).Scan(&id); err != nil {
+		log.Warn().Err(err).Str("function", functionName).Msg("Failed to record job execution")
+		return 0
+	}
+	return id
+}
+
+// finishExecution stores the outcome of an execution; run is nil if the function never started
+func finishExecution(id int, status string, run *jobs.Run, runErr error) {
+	if id == 0 {
+		return
+	}
+
+	// Use a fresh context: the outcome must be saved even while shutting down
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var runID, processed, sent int
+	if run != nil {
+		runID, processed, sent = run.ID, run.Processed, run.Sent
+	}
+	errMsg := ""
+	if runErr != nil {
+		errMsg = runErr.Error()
+	}
+
+	query := `
+		UPDATE job_executions
+		SET status = $2, run_id = NULLIF($3, 0), processed = $4, sent = $5, error = NULLIF($6, ''),
+		    finished_at = clock_timestamp(),
+		    duration_ms = (EXTRACT(EPOCH FROM clock_timestamp() - started_at) * 1000)::bigint
+		WHERE id = $1
+	`
+	if _, err := db.Pool.Exec(ctx, query, id, status, runID, processed, sent, errMsg); err != nil {
+		log.Warn().Err(err).Int("execution_id", id).Msg("Failed to save job execution outcome")
+	}
+}
+
+// Trigger runs a registered function now, outside any scheduled job, in the background.
+// It refuses while the function is running and, unless force is set, once it has completed
+// a run before, since running a mail phase again re-sends every email. Manual runs always
+// start from the first student; use the job's re-run to resume a scheduled one instead.
+func Trigger(ctx context.Context, functionName string, payload json.RawMessage, force bool) (*Execution, error) {
+	if !IsRegistered(functionName) {
+		return nil, ErrUnknownFunction
+	}
+
+	var runningElsewhere, completed bool
+	query := `
+		SELECT EXISTS(SELECT 1 FROM job_executions WHERE function_name = $1 AND status = 'running'),
+		       EXISTS(SELECT 1 FROM job_runs WHERE function_name = $1 AND status = 'completed')
+	`
+	if err := db.Pool.QueryRow(ctx, query, functionName).Scan(&runningElsewhere, &completed); err != nil {
+		return nil, err
+	}
+	if runningElsewhere {
+		return nil, ErrAlreadyRunning
+	}
+	if completed && !force {
+		return nil, ErrAlreadyCompleted
+	}
+
+	if !claimFunction(functionName) {
+		return nil, ErrAlreadyRunning
+	}
+	if len(payload) == 0 || string(payload) == "null" {
+		payload = json.RawMessage(`{}`)
+	}
+
+	id := startExecution(0, functionName, TriggerManual, force)
+	log.Info().Str("function", functionName).Int("execution_id", id).Bool("forced", force).Msg("Function triggered manually")
+
+	jobs.Go(func(jobCtx context.Context) {
+		defer releaseFunction(functionName)
+		runFunction(jobCtx, id, 0, functionName, payload)
+	})
+
+	execution, err := ScanExecution(db.Pool.QueryRow(ctx, `SELECT `+ExecutionColumns+` FROM job_executions WHERE id = $1`, id))
+	if err != nil {
+		// Started anyway; the caller only misses the row
+		return &Execution{ID: id, FunctionName: functionName, TriggeredBy: TriggerManual, Forced: force, Status: jobs.StatusRunning, StartedAt: time.Now()}, nil
+	}
+	return execution, nil
+}
@@ -1,46 +1,114 @@
 package scheduler
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mcq-exam/jobs"
 	"mcq-exam/live"
+	"mcq-exam/metrics"
+	"mcq-exam/reminders"
+	"mcq-exam/retention"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
 // DummyFirstEmail simulates sending first email (conference invitation)
-func DummyFirstEmail() {
-	log.Printf("[%s] EXECUTING: DummyFirstEmail - Sending conference invitations to all students", time.Now().Format(time.RFC3339))
+func DummyFirstEmail(ctx context.Context, run *jobs.Run) error {
+	log.Info().Str("function", "DummyFirstEmail").Msg("Sending conference invitations to all students")
 	// Simulate work
-	time.Sleep(500 * time.Millisecond)
-	log.Printf("[%s] COMPLETED: DummyFirstEmail - Conference invitations sent successfully", time.Now().Format(time.RFC3339))
+	if !jobs.Sleep(ctx, 500*time.Millisecond) {
+		return nil
+	}
+	log.Info().Str("function", "DummyFirstEmail").Msg("Conference invitations sent successfully")
+	return nil
 }
 
 // DummySecondEmail simulates sending second email (test invitation)
-func DummySecondEmail() {
-	log.Printf("[%s] EXECUTING: DummySecondEmail - Sending test invitations to eligible students", time.Now().Format(time.RFC3339))
+func DummySecondEmail(ctx context.Context, run *jobs.Run) error {
+	log.Info().Str("function", "DummySecondEmail").Msg("Sending test invitations to eligible students")
 	// Simulate work
-	time.Sleep(500 * time.Millisecond)
-	log.Printf("[%s] COMPLETED: DummySecondEmail - Test invitations sent successfully", time.Now().Format(time.RFC3339))
+	if !jobs.Sleep(ctx, 500*time.Millisecond) {
+		return nil
+	}
+	log.Info().Str("function", "DummySecondEmail").Msg("Test invitations sent successfully")
+	return nil
 }
 
-// FunctionRegistry maps function names to actual functions
-var FunctionRegistry = map[string]func(){
-	"DummyFirstEmail":            DummyFirstEmail,
-	"DummySecondEmail":           DummySecondEmail,
-	"SendFirstEmailToAll":        SendFirstEmailToAll,
-	"SendSecondEmailToEligible":  SendSecondEmailToEligible,
+// FunctionRegistry maps function names to actual functions.
+// Functions must return promptly once ctx is cancelled, recording progress on run as they go.
+// A returned error fails the run and the job is retried, resuming after the last recorded student.
+var FunctionRegistry = map[string]func(ctx context.Context, run *jobs.Run) error{
+	"DummyFirstEmail":             DummyFirstEmail,
+	"DummySecondEmail":            DummySecondEmail,
+	"SendFirstEmailToAll":         SendFirstEmailToAll,
+	"SendSecondEmailToEligible":   SendSecondEmailToEligible,
 	"Phase1FirstMailVerification": live.Phase1FirstMailVerification,
-	"Phase2SecondMailSending":    live.Phase2SecondMailSending,
+	"Phase2SecondMailSending":     live.Phase2SecondMailSending,
+	reminders.FunctionName:        reminders.SendConferenceReminder,
+	retention.FunctionName:        retention.ApplyPolicies,
+}
+
+// ExecuteFunction runs a job's function, resuming the job's last run if it was interrupted or failed.
+// Returns the run status (completed, failed or interrupted) and, for failures, the error.
+// A function already running from a manual trigger is reported as interrupted, so the job
+// stays due and is picked up by a later check.
+func ExecuteFunction(ctx context.Context, job *Job) (string, error) {
+	if !claimFunction(job.FunctionName) {
+		log.Info().Str("function", job.FunctionName).Int("job_id", job.ID).Msg("Function already running, will retry at the next check")
+		return jobs.StatusInterrupted, nil
+	}
+	defer releaseFunction(job.FunctionName)
+
+	executionID := startExecution(job.ID, job.FunctionName, TriggerScheduler, false)
+	return runFunction(ctx, executionID, job.ID, job.FunctionName, job.Payload)
 }
 
-// ExecuteFunction calls a registered function by name
-func ExecuteFunction(functionName string) bool {
+// runFunction executes a registered function and records the outcome on its execution
+func runFunction(ctx context.Context, executionID, jobID int, functionName string, payload json.RawMessage) (string, error) {
 	fn, exists := FunctionRegistry[functionName]
 	if !exists {
-		log.Printf("ERROR: Function '%s' not found in registry", functionName)
-		return false
+		log.Error().Str("function", functionName).Int("job_id", jobID).Msg("Function not found in registry")
+		metrics.SchedulerExecutionsTotal.WithLabelValues(functionName, "not_found").Inc()
+		err := fmt.Errorf("function '%s' not found in registry", functionName)
+		finishExecution(executionID, jobs.StatusFailed, nil, err)
+		return jobs.StatusFailed, err
+	}
+
+	log.Info().Str("function", functionName).Int("job_id", jobID).Int("execution_id", executionID).Msg("Executing function")
+	run := jobs.StartOrResume(ctx, jobID, functionName, payload)
+	start := time.Now()
+	err := callFunction(ctx, fn, run)
+	metrics.SchedulerExecutionDuration.WithLabelValues(functionName).Observe(time.Since(start).Seconds())
+
+	status := run.Finish(ctx, err)
+	finishExecution(executionID, status, run, err)
+	result := "success"
+	switch status {
+	case jobs.StatusInterrupted:
+		msg := "Function interrupted by shutdown, will resume on restart"
+		if jobID == 0 {
+			msg = "Manually triggered function interrupted by shutdown"
+		}
+		log.Info().Str("function", functionName).Int("job_id", jobID).Int("processed", run.Processed).Int("total", run.Total).Msg(msg)
+		result = "interrupted"
+	case jobs.StatusFailed:
+		log.Error().Err(err).Str("function", functionName).Int("job_id", jobID).Int("processed", run.Processed).Int("total", run.Total).
+			Msg("Function failed")
+		result = "failed"
 	}
 
-	log.Printf("Executing function: %s", functionName)
-	fn()
-	return true
+	metrics.SchedulerExecutionsTotal.WithLabelValues(functionName, result).Inc()
+	return status, err
+}
+
+// callFunction runs fn, turning a panic into an error so one bad job cannot stop the scheduler
+func callFunction(ctx context.Context, fn func(ctx context.Context, run *jobs.Run) error, run *jobs.Run) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx, run)
 }
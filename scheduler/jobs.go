@@ -0,0 +1,170 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mcq-exam/db"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// Scheduled job statuses
+const (
+	JobStatusActive    = "active"
+	JobStatusPaused    = "paused"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+)
+
+// retryBackoff is the wait before each retry of a failed job; the last entry repeats
+var retryBackoff = []time.Duration{
+	1 * time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+}
+
+// cronLocation is the zone cron expressions are evaluated in, matching the IST times used
+// by the event schedule. An expression can override it with a CRON_TZ= prefix.
+var cronLocation = func() *time.Location {
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load IST timezone, cron expressions will use UTC")
+		return time.UTC
+	}
+	return loc
+}()
+
+// Job is a row of scheduled_jobs: a registered function run once at RunAt or on CronExpression
+type Job struct {
+	ID             int             `json:"id"`
+	Name           string          `json:"name"`
+	FunctionName   string          `json:"function_name"`
+	CronExpression *string         `json:"cron_expression"`
+	RunAt          *time.Time      `json:"run_at"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	NextRunAt      *time.Time      `json:"next_run_at"`
+	MaxRetries     int             `json:"max_retries"`
+	Attempts       int             `json:"attempts"`
+	LastRunAt      *time.Time      `json:"last_run_at"`
+	LastError      *string         `json:"last_error"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// JobColumns is the column list matching ScanJob
+const JobColumns = `id, name, function_name, cron_expression, run_at, payload, status, next_run_at,
+	max_retries, attempts, last_run_at, last_error, created_at, updated_at`
+
+// ScanJob reads a row selected with JobColumns
+func ScanJob(row interface{ Scan(dest ...any) error }) (*Job, error) {
+	var job Job
+	err := row.Scan(&job.ID, &job.Name, &job.FunctionName, &job.CronExpression, &job.RunAt, &job.Payload, &job.Status,
+		&job.NextRunAt, &job.MaxRetries, &job.Attempts, &job.LastRunAt, &job.LastError, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// IsRegistered reports whether a function name can be scheduled
+func IsRegistered(functionName string) bool {
+	_, exists := FunctionRegistry[functionName]
+	return exists
+}
+
+// FunctionNames lists the registered functions, sorted
+func FunctionNames() []string {
+	names := make([]string, 0, len(FunctionRegistry))
+	for name := range FunctionRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NextCronRun returns the first time after 'after' matching a standard 5-field cron expression
+// (or a descriptor such as @daily), evaluated in IST
+func NextCronRun(expression string, after time.Time) (time.Time, error) {
+	schedule, err := cron.ParseStandard(strings.TrimSpace(expression))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid cron expression: %w", err)
+	}
+	return schedule.Next(after.In(cronLocation)), nil
+}
+
+// nextDueJob returns the earliest active job whose next run time has passed
+func nextDueJob(ctx context.Context, now time.Time) (*Job, error) {
+	query := `
+		SELECT ` + JobColumns + `
+		FROM scheduled_jobs
+		WHERE status = 'active'
+		  AND next_run_at <= $1
+		ORDER BY next_run_at ASC, id ASC
+		LIMIT 1
+	`
+	return ScanJob(db.Pool.QueryRow(ctx, query, now))
+}
+
+// recordSuccess resets the retry count and moves the job to its next occurrence,
+// or completes it if it was a one-shot job
+func recordSuccess(job *Job) {
+	status := JobStatusCompleted
+	var nextRun *time.Time
+	if job.CronExpression != nil {
+		if next, err := NextCronRun(*job.CronExpression, time.Now()); err == nil {
+			status = JobStatusActive
+			nextRun = &next
+		}
+	}
+
+	query := `
+		UPDATE scheduled_jobs
+		SET status = CASE WHEN status = 'paused' THEN status ELSE $1 END,
+		    next_run_at = $2, attempts = 0, last_run_at = NOW(), last_error = NULL, updated_at = NOW()
+		WHERE id = $3
+	`
+	saveJobOutcome(job, query, status, nextRun, job.ID)
+}
+
+// recordFailure schedules a retry with backoff, or marks the job failed once max_retries
+// retries have been used. Failed jobs stay failed until re-run through the admin API.
+func recordFailure(job *Job, runErr error) {
+	attempts := job.Attempts + 1
+	status := JobStatusFailed
+	var nextRun *time.Time
+	if attempts <= job.MaxRetries {
+		status = JobStatusActive
+		backoff := retryBackoff[min(attempts, len(retryBackoff))-1]
+		retryAt := time.Now().Add(backoff)
+		nextRun = &retryAt
+		log.Warn().Err(runErr).Int("job_id", job.ID).Str("function", job.FunctionName).Int("attempt", attempts).Int("max_retries", job.MaxRetries).
+			Time("retry_at", retryAt).Msg("Job failed, will retry")
+	} else {
+		log.Error().Err(runErr).Int("job_id", job.ID).Str("function", job.FunctionName).Int("max_retries", job.MaxRetries).Msg("Job failed after all retries")
+	}
+
+	query := `
+		UPDATE scheduled_jobs
+		SET status = CASE WHEN status = 'paused' THEN status ELSE $1 END,
+		    next_run_at = $2, attempts = $3, last_run_at = NOW(), last_error = $4, updated_at = NOW()
+		WHERE id = $5
+	`
+	saveJobOutcome(job, query, status, nextRun, attempts, runErr.Error(), job.ID)
+}
+
+// saveJobOutcome runs an outcome update. A job paused while it was running stays paused.
+func saveJobOutcome(job *Job, query string, args ...any) {
+	// Use a fresh context: the outcome must be saved even while shutting down
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.Pool.Exec(ctx, query, args...); err != nil {
+		log.Warn().Err(err).Int("job_id", job.ID).Msg("Failed to save job outcome")
+	}
+}
@@ -0,0 +1,193 @@
+package scorecard
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mcq-exam/campaigns"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/rs/zerolog/log"
+)
+
+// layoutVersion is part of every cache key; bump it when Render changes so cached files are redrawn
+const layoutVersion = "1"
+
+// store holds rendered scorecards by key
+type store interface {
+	get(ctx context.Context, key string) ([]byte, bool, error)
+	put(ctx context.Context, key string, data []byte) error
+}
+
+// diskStore keeps scorecards as files in a directory on this instance
+type diskStore struct {
+	dir string
+}
+
+func (d diskStore) get(ctx context.Context, key string) ([]byte, bool, error) {
+	data, err := os.ReadFile(filepath.Join(d.dir, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// put writes a temporary file and renames it, so a concurrent get never reads half a PDF
+func (d diskStore) put(ctx context.Context, key string, data []byte) error {
+	if err := os.MkdirAll(d.dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(d.dir, ".scorecard-*.pdf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(d.dir, key))
+}
+
+// s3Store keeps scorecards in a bucket shared by every instance
+type s3Store struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func (s s3Store) get(ctx context.Context, key string) ([]byte, bool, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(s.prefix + key)})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func (s s3Store) put(ctx context.Context, key string, data []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.prefix + key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/pdf"),
+	})
+	return err
+}
+
+var (
+	cacheOnce sync.Once
+	cache     store
+)
+
+// cacheStore builds the cache SCORECARD_CACHE selects: disk (default, SCORECARD_CACHE_DIR),
+// s3 (SCORECARD_S3_BUCKET and SCORECARD_S3_PREFIX, credentials from the standard AWS
+// chain) or off. A cache that cannot be set up is logged and skipped.
+func cacheStore(ctx context.Context) store {
+	cacheOnce.Do(func() {
+		switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("SCORECARD_CACHE"))); mode {
+		case "off":
+		case "s3":
+			bucket := strings.TrimSpace(os.Getenv("SCORECARD_S3_BUCKET"))
+			if bucket == "" {
+				log.Warn().Msg("SCORECARD_CACHE=s3 needs SCORECARD_S3_BUCKET; scorecards will not be cached")
+				return
+			}
+			cfg, err := awsconfig.LoadDefaultConfig(ctx)
+			if err != nil {
+				log.Warn().Err(err).Msg("Failed to load AWS configuration; scorecards will not be cached")
+				return
+			}
+			if cfg.Region == "" {
+				log.Warn().Msg("SCORECARD_CACHE=s3 needs AWS_REGION; scorecards will not be cached")
+				return
+			}
+			prefix, ok := os.LookupEnv("SCORECARD_S3_PREFIX")
+			if !ok {
+				prefix = "scorecards/"
+			}
+			cache = s3Store{client: s3.NewFromConfig(cfg), bucket: bucket, prefix: strings.TrimSpace(prefix)}
+		default:
+			if mode != "" && mode != "disk" {
+				log.Warn().Str("value", mode).Msg("Invalid SCORECARD_CACHE, using disk")
+			}
+			dir := strings.TrimSpace(os.Getenv("SCORECARD_CACHE_DIR"))
+			if dir == "" {
+				dir = "scorecards"
+			}
+			cache = diskStore{dir: dir}
+		}
+	})
+	return cache
+}
+
+// cacheKey names a rendered scorecard after its contents, so a regrade, a new participant
+// or a renamed student yields a new file instead of serving a stale one
+func cacheKey(name string, card *campaigns.Scorecard) (string, error) {
+	data, err := json.Marshal(card)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	for _, part := range [][]byte{[]byte(layoutVersion), []byte(Brand()), []byte(os.Getenv("SCORECARD_LOGO")), []byte(name), data} {
+		hash.Write(part)
+		hash.Write([]byte{0})
+	}
+	return fmt.Sprintf("%d-%s.pdf", card.StudentID, hex.EncodeToString(hash.Sum(nil))[:16]), nil
+}
+
+// PDF returns the participant's scorecard from the cache, rendering and storing it on a
+// miss. Cache errors are logged and the scorecard is rendered anyway.
+func PDF(ctx context.Context, name string, card *campaigns.Scorecard) ([]byte, error) {
+	key, err := cacheKey(name, card)
+	if err != nil {
+		return nil, err
+	}
+	stored := cacheStore(ctx)
+	if stored != nil {
+		data, ok, err := stored.get(ctx, key)
+		if err != nil {
+			log.Warn().Err(err).Str("key", key).Msg("Failed to read cached scorecard")
+		}
+		if ok {
+			return data, nil
+		}
+	}
+
+	data, err := Render(name, card)
+	if err != nil {
+		return nil, err
+	}
+	if stored != nil {
+		if err := stored.put(ctx, key, data); err != nil {
+			log.Warn().Err(err).Str("key", key).Msg("Failed to cache scorecard")
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,170 @@
+// Package scorecard renders a participant's result as a PDF and keeps the rendered files in
+// a disk or S3 cache, so repeated downloads from the result page do not re-render them.
+package scorecard
+
+import (
+	"bytes"
+	"fmt"
+	"mcq-exam/campaigns"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultBrand is the name in the scorecard header when SCORECARD_BRAND is unset
+const DefaultBrand = "SmartMCQ"
+
+// brandColor is the header band and table heading colour
+var brandColor = [3]int{31, 78, 121}
+
+// Brand is the name printed in the scorecard header (SCORECARD_BRAND)
+func Brand() string {
+	if brand := strings.TrimSpace(os.Getenv("SCORECARD_BRAND")); brand != "" {
+		return brand
+	}
+	return DefaultBrand
+}
+
+// logo reads SCORECARD_LOGO, a PNG or JPEG shown in the header. A missing or unreadable
+// file leaves the logo out rather than failing every download.
+func logo() ([]byte, string) {
+	path := strings.TrimSpace(os.Getenv("SCORECARD_LOGO"))
+	if path == "" {
+		return nil, ""
+	}
+	imageType := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if imageType == "jpeg" {
+		imageType = "jpg"
+	}
+	if imageType != "png" && imageType != "jpg" {
+		log.Warn().Str("path", path).Msg("SCORECARD_LOGO must be a .png or .jpg file; leaving the logo out")
+		return nil, ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("Failed to read SCORECARD_LOGO; leaving the logo out")
+		return nil, ""
+	}
+	return data, imageType
+}
+
+// Render draws the scorecard of the named participant on one A4 page. The core PDF fonts
+// cover Western European text only; other characters in names print as "?".
+func Render(name string, card *campaigns.Scorecard) ([]byte, error) {
+	brand := Brand()
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle(brand+" Scorecard", true)
+	pdf.SetCreator(brand, true)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AddPage()
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	width := pageWidth - left - right
+
+	// Header band with the optional logo on the left
+	pdf.SetFillColor(brandColor[0], brandColor[1], brandColor[2])
+	pdf.Rect(0, 0, pageWidth, 32, "F")
+	textX := left
+	if data, imageType := logo(); data != nil {
+		options := fpdf.ImageOptions{ImageType: imageType}
+		pdf.RegisterImageOptionsReader("logo", options, bytes.NewReader(data))
+		if pdf.Ok() {
+			pdf.ImageOptions("logo", left, 6, 0, 20, false, options, 0, "")
+			textX = left + 30
+		} else {
+			log.Warn().Err(pdf.Error()).Msg("Failed to load SCORECARD_LOGO; leaving the logo out")
+			pdf.ClearError()
+		}
+	}
+	pdf.SetTextColor(255, 255, 255)
+	pdf.SetXY(textX, 8)
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.CellFormat(0, 9, tr(brand), "", 1, "L", false, 0, "")
+	pdf.SetX(textX)
+	pdf.SetFont("Helvetica", "", 12)
+	pdf.CellFormat(0, 7, "Candidate Scorecard", "", 1, "L", false, 0, "")
+
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetY(42)
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 9, tr(name), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.SetTextColor(90, 90, 90)
+	pdf.CellFormat(0, 6, fmt.Sprintf("Candidate ID %d", card.StudentID), "", 1, "L", false, 0, "")
+	pdf.Ln(6)
+
+	// Summary: four boxes across the page
+	summary := [][2]string{
+		{"Score", fmt.Sprintf("%d / %d", card.Score, card.MaxScore)},
+		{"Rank", fmt.Sprintf("%d of %d", card.Rank, card.Participants)},
+		{"Percentile", strconv.FormatFloat(card.Percentile, 'f', 1, 64)},
+		{"Time taken", campaigns.FormatDuration(card.TimeTakenSeconds)},
+	}
+	boxWidth := width / float64(len(summary))
+	top := pdf.GetY()
+	pdf.SetDrawColor(200, 200, 200)
+	for i, item := range summary {
+		x := left + float64(i)*boxWidth
+		pdf.Rect(x, top, boxWidth-3, 22, "D")
+		pdf.SetXY(x, top+3)
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.SetTextColor(90, 90, 90)
+		pdf.CellFormat(boxWidth-3, 5, item[0], "", 0, "C", false, 0, "")
+		pdf.SetXY(x, top+10)
+		pdf.SetFont("Helvetica", "B", 14)
+		pdf.SetTextColor(0, 0, 0)
+		pdf.CellFormat(boxWidth-3, 8, item[1], "", 0, "C", false, 0, "")
+	}
+	pdf.SetXY(left, top+26)
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 6, fmt.Sprintf("Ranked ahead of %s%% of participants.",
+		strconv.FormatFloat(card.Percentile, 'f', 1, 64)), "", 1, "L", false, 0, "")
+	pdf.Ln(6)
+
+	// Section breakdown
+	pdf.SetFont("Helvetica", "B", 12)
+	pdf.CellFormat(0, 8, "Section breakdown", "", 1, "L", false, 0, "")
+	columns := []float64{width * 0.55, width * 0.225, width * 0.225}
+	pdf.SetFillColor(brandColor[0], brandColor[1], brandColor[2])
+	pdf.SetTextColor(255, 255, 255)
+	pdf.SetFont("Helvetica", "B", 10)
+	for i, heading := range []string{"Section", "Score", "Time"} {
+		pdf.CellFormat(columns[i], 8, heading, "1", 0, "L", true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.SetFillColor(242, 245, 249)
+	for i, section := range card.Sections {
+		fill := i%2 == 1
+		pdf.CellFormat(columns[0], 7, tr(section.Name), "1", 0, "L", fill, 0, "")
+		pdf.CellFormat(columns[1], 7, fmt.Sprintf("%d / %d", section.Score, section.MaxScore), "1", 0, "L", fill, 0, "")
+		pdf.CellFormat(columns[2], 7, campaigns.FormatDuration(section.TimeTakenSeconds), "1", 1, "L", fill, 0, "")
+	}
+	pdf.Ln(8)
+
+	certificate := "Certificate of Participation"
+	if card.CertificateType == campaigns.CertificateMerit {
+		certificate = "Certificate of Merit"
+	}
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(0, 7, "Awarded: "+certificate, "", 1, "L", false, 0, "")
+
+	pdf.SetY(-20)
+	pdf.SetFont("Helvetica", "I", 8)
+	pdf.SetTextColor(120, 120, 120)
+	pdf.CellFormat(0, 5, tr(fmt.Sprintf("Issued by %s on %s. Rank and percentile are among %d participants at that time.",
+		brand, time.Now().UTC().Format("2 January 2006"), card.Participants)), "", 1, "C", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("failed to render scorecard: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,203 @@
+package scoring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrAlreadyCompleted is returned by Finalize when the session was completed already,
+// including by a concurrent end-session
+var ErrAlreadyCompleted = errors.New("session already completed")
+
+// ErrNotCompleted is returned by Refinalize for sessions that were never completed
+var ErrNotCompleted = errors.New("session is not completed")
+
+// Reasons the server finalizes a session on the candidate's behalf, stored in
+// sessions.auto_completed_reason
+const (
+	// AutoTimeExpired is a session still open after the test's time ran out
+	AutoTimeExpired = "time_expired"
+	// AutoIdle is a session abandoned for going idle
+	AutoIdle = "idle"
+)
+
+// Result is what a session is finalized with
+type Result struct {
+	Score          int `json:"score"`
+	TotalTimeTaken int `json:"total_time_taken_seconds"`
+	TotalQuestions int `json:"total_questions_answered"`
+}
+
+// Finalize completes an open session: score, total time and per-section results are
+// written in one transaction, so a failure leaves the session open and retryable
+func Finalize(ctx context.Context, sessionID int) (*Result, error) {
+	return FinalizeIn(ctx, db.Pool, sessionID)
+}
+
+// FinalizeIn is Finalize against another pool, such as the load test sandbox
+func FinalizeIn(ctx context.Context, pool *pgxpool.Pool, sessionID int) (*Result, error) {
+	return finalize(ctx, pool, sessionID, false, "")
+}
+
+// AutoFinalize is Finalize for a session the candidate never ended, marking it
+// auto_completed with the reason (AutoTimeExpired or AutoIdle)
+func AutoFinalize(ctx context.Context, sessionID int, reason string) (*Result, error) {
+	return finalize(ctx, db.Pool, sessionID, false, reason)
+}
+
+// Refinalize recomputes the stored results of a completed session from its answers
+func Refinalize(ctx context.Context, sessionID int) (*Result, error) {
+	return finalize(ctx, db.Pool, sessionID, true, "")
+}
+
+// RefinalizeAll is Refinalize for many completed sessions in one transaction, such as
+// sessions inserted already completed by the seed command
+func RefinalizeAll(ctx context.Context, sessionIDs []int) error {
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+	g, err := newGrader(ctx, db.Pool)
+	if err != nil {
+		return err
+	}
+	return db.WithTx(ctx, func(tx pgx.Tx) error {
+		sessions, err := loadCompleted(ctx, tx, sessionIDs, true)
+		if err != nil {
+			return err
+		}
+		if _, _, err := g.rescore(ctx, tx, sessions, true); err != nil {
+			return fmt.Errorf("failed to finalize sessions: %w", err)
+		}
+		return nil
+	})
+}
+
+// finalize scores a session; a non-empty autoReason marks it auto_completed, while an
+// empty one keeps the mark it has
+func finalize(ctx context.Context, pool *pgxpool.Pool, sessionID int, completed bool, autoReason string) (*Result, error) {
+	// Loaded before the transaction so slow reads do not hold the session row lock. The scheme
+	// and regrades are exam-wide, so they come from the main database even for the sandbox.
+	g, err := newGrader(ctx, db.Pool)
+	if err != nil {
+		return nil, err
+	}
+
+	var result Result
+	err = db.WithTxIn(ctx, pool, func(tx pgx.Tx) error {
+		// completed selects open sessions (end-session) or completed ones (reconciliation)
+		var seed *int64
+		err := tx.QueryRow(ctx, `SELECT question_seed FROM sessions WHERE id = $1 AND completed = $2 FOR UPDATE`,
+			sessionID, completed).Scan(&seed)
+		if errors.Is(err, pgx.ErrNoRows) {
+			if completed {
+				return ErrNotCompleted
+			}
+			return ErrAlreadyCompleted
+		}
+		if err != nil {
+			return fmt.Errorf("failed to finalize session %d: %w", sessionID, err)
+		}
+
+		answers, err := loadAnswers(ctx, tx, sessionID)
+		if err != nil {
+			return err
+		}
+		var sections []sectionResult
+		result, sections = g.score(seed, answers)
+
+		// A completed session keeps its original completed_at
+		_, err = tx.Exec(ctx, `
+			UPDATE sessions
+			SET completed = true,
+			    completed_at = COALESCE(completed_at, NOW()),
+			    score = $2,
+			    total_time_taken_seconds = $3,
+			    auto_completed = auto_completed OR $4 <> '',
+			    auto_completed_reason = COALESCE(NULLIF($4, ''), auto_completed_reason),
+			    updated_at = NOW()
+			WHERE id = $1
+		`, sessionID, result.Score, result.TotalTimeTaken, autoReason)
+		if err != nil {
+			return fmt.Errorf("failed to finalize session %d: %w", sessionID, err)
+		}
+
+		var rows sectionRows
+		rows.add(sessionID, sections)
+		_, err = rows.write(ctx, tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Inconsistency is a completed session whose stored results disagree with its answers
+type Inconsistency struct {
+	SessionID      int        `json:"session_id"`
+	StudentID      int        `json:"student_id"`
+	StoredScore    *int       `json:"stored_score"`
+	ActualScore    int        `json:"actual_score"`
+	StoredTime     *int       `json:"stored_total_time_taken_seconds"`
+	ActualTime     int        `json:"actual_total_time_taken_seconds"`
+	CompletedAt    *time.Time `json:"completed_at"`
+	Reasons        []string   `json:"reasons"`
+	hasSectionRows bool
+}
+
+// FindInconsistent lists up to limit completed sessions whose score or total time does not
+// match their answers under the current scoring scheme, that have no completed_at, or that
+// are missing section results
+func FindInconsistent(ctx context.Context, limit int) ([]Inconsistency, error) {
+	g, err := newGrader(ctx, db.Pool)
+	if err != nil {
+		return nil, err
+	}
+	checkSections := len(g.sectionOf) > 0
+
+	sessions, err := loadCompleted(ctx, db.Pool, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]Inconsistency, 0)
+	for _, session := range sessions {
+		if len(found) >= limit {
+			break
+		}
+		result, _ := g.score(session.Seed, session.Answers)
+		item := Inconsistency{
+			SessionID:      session.ID,
+			StudentID:      session.StudentID,
+			StoredScore:    session.Score,
+			ActualScore:    result.Score,
+			StoredTime:     session.TotalTime,
+			ActualTime:     result.TotalTimeTaken,
+			CompletedAt:    session.CompletedAt,
+			Reasons:        make([]string, 0),
+			hasSectionRows: session.HasSections,
+		}
+		if item.StoredScore == nil || *item.StoredScore != item.ActualScore {
+			item.Reasons = append(item.Reasons, "score_mismatch")
+		}
+		if item.StoredTime == nil || *item.StoredTime != item.ActualTime {
+			item.Reasons = append(item.Reasons, "time_mismatch")
+		}
+		if item.CompletedAt == nil {
+			item.Reasons = append(item.Reasons, "missing_completed_at")
+		}
+		if checkSections && !item.hasSectionRows {
+			item.Reasons = append(item.Reasons, "missing_section_scores")
+		}
+		if len(item.Reasons) > 0 {
+			found = append(found, item)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,280 @@
+package scoring
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"mcq-exam/questions"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Regrade actions
+const (
+	// ActionVoid drops the question from scoring: no answer to it counts as correct, and wrong
+	// or missing answers to it cost nothing under negative or unanswered marking
+	ActionVoid = "void"
+	// ActionAcceptMultiple counts any of CorrectOptions as correct
+	ActionAcceptMultiple = "accept_multiple"
+	// ActionChangeKey makes the single option in CorrectOptions the correct one
+	ActionChangeKey = "change_key"
+)
+
+// ErrInvalidRegrade is wrapped by Regrade for actions that cannot be applied
+var ErrInvalidRegrade = errors.New("invalid regrade")
+
+// errDryRun rolls back a dry-run regrade
+var errDryRun = errors.New("regrade dry run")
+
+// RegradeAction corrects the key of one question
+type RegradeAction struct {
+	QuestionID     int    `json:"question_id"`
+	Action         string `json:"action"`
+	CorrectOptions []int  `json:"correct_options,omitempty"`
+}
+
+// RegradedSession is a completed session whose score a regrade changed
+type RegradedSession struct {
+	SessionID int  `json:"session_id"`
+	StudentID int  `json:"student_id"`
+	OldScore  *int `json:"old_score"`
+	NewScore  int  `json:"new_score"`
+}
+
+// RegradeResult describes a regrade; ID is 0 for a dry run
+type RegradeResult struct {
+	ID               int               `json:"id,omitempty"`
+	DryRun           bool              `json:"dry_run"`
+	AnswersChanged   int               `json:"answers_changed"`
+	SessionsRescored int               `json:"sessions_rescored"`
+	Sessions         []RegradedSession `json:"sessions"`
+}
+
+// RegradeRecord is a regrades audit row
+type RegradeRecord struct {
+	ID               int             `json:"id"`
+	Reason           string          `json:"reason"`
+	Actions          json.RawMessage `json:"actions"`
+	AnswersChanged   int             `json:"answers_changed"`
+	SessionsRescored int             `json:"sessions_rescored"`
+	CreatedAt        time.Time       `json:"created_at"`
+}
+
+// validateRegrade checks the actions against the question bank
+func validateRegrade(actions []RegradeAction) error {
+	if len(actions) == 0 {
+		return fmt.Errorf("%w: at least one action is required", ErrInvalidRegrade)
+	}
+	sections, err := questions.Load()
+	if err != nil {
+		return err
+	}
+	optionCounts := make(map[int]int)
+	for _, section := range sections {
+		for _, q := range section.Questions {
+			optionCounts[q.ID] = len(q.Options)
+		}
+	}
+
+	seen := make(map[int]bool)
+	for _, action := range actions {
+		count, ok := optionCounts[action.QuestionID]
+		if !ok {
+			return fmt.Errorf("%w: question %d is not in the question bank", ErrInvalidRegrade, action.QuestionID)
+		}
+		if seen[action.QuestionID] {
+			return fmt.Errorf("%w: question %d has more than one action", ErrInvalidRegrade, action.QuestionID)
+		}
+		seen[action.QuestionID] = true
+
+		switch action.Action {
+		case ActionVoid:
+			if len(action.CorrectOptions) > 0 {
+				return fmt.Errorf("%w: question %d: void takes no correct_options", ErrInvalidRegrade, action.QuestionID)
+			}
+		case ActionAcceptMultiple:
+			if len(action.CorrectOptions) < 2 {
+				return fmt.Errorf("%w: question %d: accept_multiple needs at least two correct_options", ErrInvalidRegrade, action.QuestionID)
+			}
+		case ActionChangeKey:
+			if len(action.CorrectOptions) != 1 {
+				return fmt.Errorf("%w: question %d: change_key needs exactly one correct_options entry", ErrInvalidRegrade, action.QuestionID)
+			}
+		default:
+			return fmt.Errorf("%w: question %d: action must be void, accept_multiple or change_key", ErrInvalidRegrade, action.QuestionID)
+		}
+		for _, option := range action.CorrectOptions {
+			if option < 0 || option >= count {
+				return fmt.Errorf("%w: question %d has no option %d", ErrInvalidRegrade, action.QuestionID, option)
+			}
+		}
+	}
+	return nil
+}
+
+// Regrade applies answer-key corrections: is_correct is recomputed for every answer to the
+// listed questions, then every affected completed session gets its score and section results
+// recomputed and an audit entry is written, all in one transaction. A dry run reports the
+// same result and rolls back.
+func Regrade(ctx context.Context, actions []RegradeAction, reason string, dryRun bool) (*RegradeResult, error) {
+	if err := validateRegrade(actions); err != nil {
+		return nil, err
+	}
+
+	result := &RegradeResult{DryRun: dryRun, Sessions: []RegradedSession{}}
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		if err := applyRegrade(ctx, tx, actions, reason, result); err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		result.ID = 0
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// applyRegrade does the work of Regrade inside tx, filling in result
+func applyRegrade(ctx context.Context, tx pgx.Tx, actions []RegradeAction, reason string, result *RegradeResult) error {
+	affected := make(map[int]bool)
+	var sessionIDs []int
+	for _, action := range actions {
+		// A void question is correct for no one; otherwise the selected option decides
+		options := action.CorrectOptions
+		if options == nil {
+			options = []int{}
+		}
+		rows, err := tx.Query(ctx, `
+			UPDATE answers
+			SET is_correct = selected_option_index = ANY($2::int[])
+			WHERE question_id = $1 AND is_correct IS DISTINCT FROM (selected_option_index = ANY($2::int[]))
+			RETURNING session_id
+		`, action.QuestionID, options)
+		if err != nil {
+			return fmt.Errorf("failed to regrade question %d: %w", action.QuestionID, err)
+		}
+		for rows.Next() {
+			var sessionID int
+			if err := rows.Scan(&sessionID); err != nil {
+				rows.Close()
+				return err
+			}
+			result.AnswersChanged++
+			if !affected[sessionID] {
+				affected[sessionID] = true
+				sessionIDs = append(sessionIDs, sessionID)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to regrade question %d: %w", action.QuestionID, err)
+		}
+	}
+
+	// Voids take effect with this regrade, before its audit row is written
+	g, err := newGrader(ctx, tx)
+	if err != nil {
+		return err
+	}
+	voidChanged := false
+	for _, action := range actions {
+		void := action.Action == ActionVoid
+		if g.voided[action.QuestionID] != void {
+			voidChanged = true
+		}
+		if void {
+			g.voided[action.QuestionID] = true
+		} else {
+			delete(g.voided, action.QuestionID)
+		}
+	}
+
+	// Voiding a question (or restoring it) also changes what wrong and unanswered copies of it
+	// are worth, which can touch every session when the scheme marks those
+	rescoreAll := voidChanged && (g.scheme.NegativeMarks != 0 || g.scheme.UnansweredMarks != 0)
+	if rescoreAll || len(sessionIDs) > 0 {
+		ids := sessionIDs
+		if rescoreAll {
+			ids = nil
+		}
+		// Open sessions are scored from the corrected answers when they end
+		sessions, err := loadCompleted(ctx, tx, ids, true)
+		if err != nil {
+			return fmt.Errorf("failed to load regraded sessions: %w", err)
+		}
+		changed, _, err := g.rescore(ctx, tx, sessions, true)
+		if err != nil {
+			return err
+		}
+		result.Sessions = changed
+		result.SessionsRescored = len(sessions)
+	}
+
+	actionsJSON, err := json.Marshal(actions)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO regrades (reason, actions, answers_changed, sessions_rescored)
+		VALUES ($1, $2::jsonb, $3, $4)
+		RETURNING id
+	`, reason, string(actionsJSON), result.AnswersChanged, result.SessionsRescored).Scan(&result.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record regrade: %w", err)
+	}
+
+	if len(result.Sessions) > 0 {
+		ids := make([]int, len(result.Sessions))
+		students := make([]int, len(result.Sessions))
+		oldScores := make([]*int, len(result.Sessions))
+		newScores := make([]int, len(result.Sessions))
+		for i, session := range result.Sessions {
+			ids[i], students[i], oldScores[i], newScores[i] = session.SessionID, session.StudentID, session.OldScore, session.NewScore
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO regrade_sessions (regrade_id, session_id, student_id, old_score, new_score)
+			SELECT $1, s.session_id, s.student_id, s.old_score, s.new_score
+			FROM unnest($2::int[], $3::int[], $4::int[], $5::int[]) AS s(session_id, student_id, old_score, new_score)
+		`, result.ID, ids, students, oldScores, newScores)
+		if err != nil {
+			return fmt.Errorf("failed to record regraded sessions: %w", err)
+		}
+	}
+	return nil
+}
+
+// RecentRegrades lists the latest regrades, newest first
+func RecentRegrades(ctx context.Context, limit int) ([]RegradeRecord, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, reason, actions, answers_changed, sessions_rescored, created_at
+		FROM regrades
+		ORDER BY id DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []RegradeRecord{}
+	for rows.Next() {
+		var record RegradeRecord
+		var actions []byte
+		if err := rows.Scan(&record.ID, &record.Reason, &actions, &record.AnswersChanged, &record.SessionsRescored, &record.CreatedAt); err != nil {
+			return nil, err
+		}
+		record.Actions = actions
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,319 @@
+package scoring
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"mcq-exam/questions"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// schemeSettingsKey is the exam_settings row holding the scoring scheme
+const schemeSettingsKey = "scoring_scheme"
+
+// ErrInvalidScheme is wrapped by UpdateScheme for schemes that cannot be applied
+var ErrInvalidScheme = errors.New("invalid scoring scheme")
+
+// Scheme is the exam-level marking applied when sessions are finalized, regraded or rescored.
+// Marks are whole numbers so scores stay integers; use e.g. +4/-1 rather than +1/-0.25.
+type Scheme struct {
+	// MarksPerQuestion is awarded for a correct answer (default 1)
+	MarksPerQuestion int `json:"marks_per_question"`
+	// NegativeMarks is deducted for a wrong answer (default 0)
+	NegativeMarks int `json:"negative_marks"`
+	// UnansweredMarks is added for every question of the session's set left unanswered; it
+	// may be negative to penalize skipping (default 0)
+	UnansweredMarks int `json:"unanswered_marks"`
+	// QuestionMarks overrides MarksPerQuestion for single questions, by question ID
+	QuestionMarks map[int]int `json:"question_marks,omitempty"`
+	// SectionWeights multiplies every mark in a section, by section ID (default 1)
+	SectionWeights map[int]int `json:"section_weights,omitempty"`
+	UpdatedAt      *time.Time  `json:"updated_at,omitempty"`
+}
+
+// DefaultScheme is one mark per correct answer, the score used until an admin sets a scheme
+func DefaultScheme() Scheme {
+	return Scheme{MarksPerQuestion: 1}
+}
+
+// Validate checks the marks and that overridden questions and weighted sections are in the bank
+func (s Scheme) Validate(sections []questions.Section) error {
+	if s.MarksPerQuestion < 1 {
+		return fmt.Errorf("%w: marks_per_question must be at least 1", ErrInvalidScheme)
+	}
+	if s.NegativeMarks < 0 {
+		return fmt.Errorf("%w: negative_marks is deducted and must not be negative", ErrInvalidScheme)
+	}
+	for questionID, marks := range s.QuestionMarks {
+		if questions.Find(sections, questionID) == nil {
+			return fmt.Errorf("%w: question %d is not in the question bank", ErrInvalidScheme, questionID)
+		}
+		if marks < 0 {
+			return fmt.Errorf("%w: question %d: marks must not be negative", ErrInvalidScheme, questionID)
+		}
+	}
+	for sectionID, weight := range s.SectionWeights {
+		if questions.FindSection(sections, sectionID) == nil {
+			return fmt.Errorf("%w: section %d is not in the question bank", ErrInvalidScheme, sectionID)
+		}
+		if weight < 0 {
+			return fmt.Errorf("%w: section %d: weight must not be negative", ErrInvalidScheme, sectionID)
+		}
+	}
+	return nil
+}
+
+// LoadScheme reads the stored scheme, returning the default scheme when none has been saved
+func LoadScheme(ctx context.Context) (Scheme, error) {
+	return loadScheme(ctx, db.Pool)
+}
+
+func loadScheme(ctx context.Context, q Querier) (Scheme, error) {
+	var value []byte
+	var updatedAt time.Time
+	err := q.QueryRow(ctx, `SELECT value, updated_at FROM exam_settings WHERE key = $1`, schemeSettingsKey).Scan(&value, &updatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DefaultScheme(), nil
+	}
+	if err != nil {
+		return Scheme{}, fmt.Errorf("failed to load scoring scheme: %w", err)
+	}
+
+	scheme := DefaultScheme()
+	if err := json.Unmarshal(value, &scheme); err != nil {
+		return Scheme{}, fmt.Errorf("stored scoring scheme is invalid: %s", value)
+	}
+	scheme.UpdatedAt = &updatedAt
+	return scheme, nil
+}
+
+// SchemeUpdate describes a scheme change and the rescoring it caused
+type SchemeUpdate struct {
+	Scheme           Scheme `json:"scheme"`
+	DryRun           bool   `json:"dry_run"`
+	SessionsRescored int    `json:"sessions_rescored"`
+	ScoresChanged    int    `json:"scores_changed"`
+}
+
+// UpdateScheme stores the scheme and rescores every completed session with it in one
+// transaction, so stored scores never mix schemes. A dry run reports the same result and
+// rolls back.
+func UpdateScheme(ctx context.Context, scheme Scheme, dryRun bool) (*SchemeUpdate, error) {
+	sections, err := questions.Load()
+	if err != nil {
+		return nil, err
+	}
+	if err := scheme.Validate(sections); err != nil {
+		return nil, err
+	}
+	scheme.UpdatedAt = nil
+	value, err := json.Marshal(scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	update := &SchemeUpdate{DryRun: dryRun}
+	err = db.WithTx(ctx, func(tx pgx.Tx) error {
+		var updatedAt time.Time
+		err := tx.QueryRow(ctx, `
+			INSERT INTO exam_settings (key, value, updated_at)
+			VALUES ($1, $2, NOW())
+			ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()
+			RETURNING updated_at
+		`, schemeSettingsKey, value).Scan(&updatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save scoring scheme: %w", err)
+		}
+		scheme.UpdatedAt = &updatedAt
+		update.Scheme = scheme
+
+		g, err := newGrader(ctx, tx)
+		if err != nil {
+			return err
+		}
+		sessions, err := loadCompleted(ctx, tx, nil, true)
+		if err != nil {
+			return err
+		}
+		changed, _, err := g.rescore(ctx, tx, sessions, true)
+		if err != nil {
+			return err
+		}
+		update.SessionsRescored = len(sessions)
+		update.ScoresChanged = len(changed)
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		update.Scheme.UpdatedAt = nil
+		return update, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return update, nil
+}
+
+// grader scores sessions under a scheme
+type grader struct {
+	scheme    Scheme
+	sections  []questions.Section
+	setConfig questions.SetConfig
+	// sectionOf maps question ID to section ID for the whole bank
+	sectionOf map[int]int
+	// voided questions score nothing for anyone (see ActionVoid)
+	voided map[int]bool
+}
+
+// newGrader loads the bank, the scheme and the voided questions. q reads exam_settings and
+// regrades, so a grader built inside a transaction sees its uncommitted changes.
+func newGrader(ctx context.Context, q Querier) (*grader, error) {
+	sections, err := questions.Load()
+	if err != nil {
+		return nil, err
+	}
+	scheme, err := loadScheme(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	voided, err := voidedQuestions(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &grader{
+		scheme:    scheme,
+		sections:  sections,
+		setConfig: questions.ConfigFromEnv(),
+		sectionOf: make(map[int]int),
+		voided:    voided,
+	}
+	for _, section := range sections {
+		for _, question := range section.Questions {
+			g.sectionOf[question.ID] = section.ID
+		}
+	}
+	return g, nil
+}
+
+// voidedQuestions are the questions whose latest regrade action is void
+func voidedQuestions(ctx context.Context, q Querier) (map[int]bool, error) {
+	rows, err := q.Query(ctx, `
+		SELECT question_id FROM (
+			SELECT DISTINCT ON (a.question_id) a.question_id, a.action
+			FROM regrades r
+			CROSS JOIN jsonb_to_recordset(r.actions) AS a(question_id int, action text)
+			ORDER BY a.question_id, r.id DESC
+		) latest
+		WHERE action = $1
+	`, ActionVoid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load voided questions: %w", err)
+	}
+	defer rows.Close()
+
+	voided := make(map[int]bool)
+	for rows.Next() {
+		var questionID int
+		if err := rows.Scan(&questionID); err != nil {
+			return nil, err
+		}
+		voided[questionID] = true
+	}
+	return voided, rows.Err()
+}
+
+// weight is the multiplier for a section's marks
+func (g *grader) weight(sectionID int) int {
+	if weight, ok := g.scheme.SectionWeights[sectionID]; ok {
+		return weight
+	}
+	return 1
+}
+
+// marks is what one answer to a question is worth before the section weight
+func (g *grader) marks(questionID int, correct bool) int {
+	switch {
+	case g.voided[questionID]:
+		return 0
+	case !correct:
+		return -g.scheme.NegativeMarks
+	}
+	if marks, ok := g.scheme.QuestionMarks[questionID]; ok {
+		return marks
+	}
+	return g.scheme.MarksPerQuestion
+}
+
+// sessionAnswer is one stored answer as far as scoring is concerned
+type sessionAnswer struct {
+	QuestionID int
+	Correct    bool
+	TimeTaken  int
+}
+
+// sectionResult is one session_section_scores row
+type sectionResult struct {
+	SectionID int
+	Score     int
+	TimeTaken int
+	Answered  int
+}
+
+// score computes a session's result and a row for every section of the bank. Answers to
+// questions no longer in the bank still count toward the total, unweighted.
+func (g *grader) score(seed *int64, answers []sessionAnswer) (Result, []sectionResult) {
+	var result Result
+	bySection := make(map[int]*sectionResult, len(g.sections))
+	results := make([]sectionResult, len(g.sections))
+	for i, section := range g.sections {
+		results[i].SectionID = section.ID
+		bySection[section.ID] = &results[i]
+	}
+
+	answered := make(map[int]bool, len(answers))
+	for _, answer := range answers {
+		answered[answer.QuestionID] = true
+		result.TotalTimeTaken += answer.TimeTaken
+		result.TotalQuestions++
+		marks := g.marks(answer.QuestionID, answer.Correct)
+
+		sectionID, ok := g.sectionOf[answer.QuestionID]
+		if !ok {
+			result.Score += marks
+			continue
+		}
+		section := bySection[sectionID]
+		section.Score += marks
+		section.TimeTaken += answer.TimeTaken
+		section.Answered++
+	}
+
+	// Unanswered questions are those of the set the session was served
+	if g.scheme.UnansweredMarks != 0 {
+		set := g.sections
+		if questions.Sampled(g.sections, g.setConfig) && seed != nil {
+			set = questions.ForSeed(g.sections, *seed, g.setConfig)
+		}
+		for _, section := range set {
+			for _, question := range section.Questions {
+				if !answered[question.ID] && !g.voided[question.ID] {
+					bySection[section.ID].Score += g.scheme.UnansweredMarks
+				}
+			}
+		}
+	}
+
+	for i := range results {
+		results[i].Score *= g.weight(results[i].SectionID)
+		result.Score += results[i].Score
+	}
+	return result, results
+}
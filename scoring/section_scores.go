@@ -0,0 +1,194 @@
+package scoring
+
+import (
+	"context"
+	"fmt"
+	"mcq-exam/db"
+	"time"
+)
+
+// Querier is satisfied by both *pgxpool.Pool and pgx.Tx
+type Querier = db.Querier
+
+// upsertSectionScoresQuery stores section results; $1-$5 are parallel arrays of session,
+// section, score, time and answer count
+const upsertSectionScoresQuery = `
+	INSERT INTO session_section_scores (session_id, student_id, section_id, score, time_taken_seconds, questions_answered)
+	SELECT sess.id, sess.student_id, r.section_id, r.score, r.time_taken, r.answered
+	FROM unnest($1::int[], $2::int[], $3::int[], $4::int[], $5::int[]) AS r(session_id, section_id, score, time_taken, answered)
+	JOIN sessions sess ON sess.id = r.session_id
+	ON CONFLICT (session_id, section_id)
+	DO UPDATE SET score = EXCLUDED.score,
+	              time_taken_seconds = EXCLUDED.time_taken_seconds,
+	              questions_answered = EXCLUDED.questions_answered,
+	              updated_at = NOW()
+`
+
+// completedSession is a completed session with its stored results and its answers
+type completedSession struct {
+	ID          int
+	StudentID   int
+	Seed        *int64
+	Score       *int
+	TotalTime   *int
+	CompletedAt *time.Time
+	HasSections bool
+	Answers     []sessionAnswer
+}
+
+// loadCompleted loads completed sessions with their answers, in ID order; every completed
+// session when ids is nil. With lock the sessions stay locked until the transaction ends.
+func loadCompleted(ctx context.Context, q Querier, ids []int, lock bool) ([]completedSession, error) {
+	lockClause := ""
+	if lock {
+		lockClause = "FOR UPDATE"
+	}
+	query := `
+		WITH picked AS (
+			SELECT id, student_id, question_seed, score, total_time_taken_seconds, completed_at
+			FROM sessions
+			WHERE completed = true AND ($1::int[] IS NULL OR id = ANY($1))
+			ORDER BY id
+			` + lockClause + `
+		)
+		SELECT p.id, p.student_id, p.question_seed, p.score, p.total_time_taken_seconds, p.completed_at,
+		       EXISTS (SELECT 1 FROM session_section_scores sss WHERE sss.session_id = p.id),
+		       COALESCE(array_agg(a.question_id ORDER BY a.id) FILTER (WHERE a.id IS NOT NULL), '{}'),
+		       COALESCE(array_agg(a.is_correct ORDER BY a.id) FILTER (WHERE a.id IS NOT NULL), '{}'),
+		       COALESCE(array_agg(a.time_taken_seconds ORDER BY a.id) FILTER (WHERE a.id IS NOT NULL), '{}')
+		FROM picked p
+		LEFT JOIN answers a ON a.session_id = p.id
+		GROUP BY p.id, p.student_id, p.question_seed, p.score, p.total_time_taken_seconds, p.completed_at
+		ORDER BY p.id
+	`
+	rows, err := q.Query(ctx, query, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load completed sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]completedSession, 0)
+	for rows.Next() {
+		var s completedSession
+		var questionIDs, times []int
+		var correct []bool
+		if err := rows.Scan(&s.ID, &s.StudentID, &s.Seed, &s.Score, &s.TotalTime, &s.CompletedAt, &s.HasSections,
+			&questionIDs, &correct, &times); err != nil {
+			return nil, err
+		}
+		s.Answers = make([]sessionAnswer, len(questionIDs))
+		for i := range questionIDs {
+			s.Answers[i] = sessionAnswer{QuestionID: questionIDs[i], Correct: correct[i], TimeTaken: times[i]}
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load completed sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// loadAnswers loads one session's answers
+func loadAnswers(ctx context.Context, q Querier, sessionID int) ([]sessionAnswer, error) {
+	rows, err := q.Query(ctx, `SELECT question_id, is_correct, time_taken_seconds FROM answers WHERE session_id = $1 ORDER BY id`, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load answers of session %d: %w", sessionID, err)
+	}
+	defer rows.Close()
+
+	answers := make([]sessionAnswer, 0)
+	for rows.Next() {
+		var answer sessionAnswer
+		if err := rows.Scan(&answer.QuestionID, &answer.Correct, &answer.TimeTaken); err != nil {
+			return nil, err
+		}
+		answers = append(answers, answer)
+	}
+	return answers, rows.Err()
+}
+
+// sectionRows collects section results for upsertSectionScoresQuery
+type sectionRows struct {
+	sessionIDs, sectionIDs, scores, times, answered []int
+}
+
+func (r *sectionRows) add(sessionID int, results []sectionResult) {
+	for _, result := range results {
+		r.sessionIDs = append(r.sessionIDs, sessionID)
+		r.sectionIDs = append(r.sectionIDs, result.SectionID)
+		r.scores = append(r.scores, result.Score)
+		r.times = append(r.times, result.TimeTaken)
+		r.answered = append(r.answered, result.Answered)
+	}
+}
+
+// write upserts the collected rows, returning the number written
+func (r *sectionRows) write(ctx context.Context, q Querier) (int64, error) {
+	if len(r.sessionIDs) == 0 {
+		return 0, nil
+	}
+	tag, err := q.Exec(ctx, upsertSectionScoresQuery, r.sessionIDs, r.sectionIDs, r.scores, r.times, r.answered)
+	if err != nil {
+		return 0, fmt.Errorf("failed to persist section scores: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// rescore recomputes section results for completed sessions and, with updateSessions, their
+// score and total time. It returns the sessions whose score changed and the section rows written.
+func (g *grader) rescore(ctx context.Context, q Querier, sessions []completedSession, updateSessions bool) ([]RegradedSession, int64, error) {
+	changed := make([]RegradedSession, 0)
+	var sections sectionRows
+	var ids, scores, times []int
+	for _, session := range sessions {
+		result, results := g.score(session.Seed, session.Answers)
+		sections.add(session.ID, results)
+
+		scoreChanged := session.Score == nil || *session.Score != result.Score
+		if scoreChanged {
+			changed = append(changed, RegradedSession{SessionID: session.ID, StudentID: session.StudentID,
+				OldScore: session.Score, NewScore: result.Score})
+		}
+		if scoreChanged || session.TotalTime == nil || *session.TotalTime != result.TotalTimeTaken {
+			ids = append(ids, session.ID)
+			scores = append(scores, result.Score)
+			times = append(times, result.TotalTimeTaken)
+		}
+	}
+
+	if updateSessions && len(ids) > 0 {
+		_, err := q.Exec(ctx, `
+			UPDATE sessions s
+			SET score = u.score, total_time_taken_seconds = u.total_time, updated_at = NOW()
+			FROM unnest($1::int[], $2::int[], $3::int[]) AS u(id, score, total_time)
+			WHERE s.id = u.id
+		`, ids, scores, times)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to store rescored sessions: %w", err)
+		}
+	}
+
+	written, err := sections.write(ctx, q)
+	if err != nil {
+		return nil, 0, err
+	}
+	return changed, written, nil
+}
+
+// RebuildSectionScores recomputes section results for every completed session under the
+// current scoring scheme, returning the number of rows written
+func RebuildSectionScores(ctx context.Context) (int64, error) {
+	g, err := newGrader(ctx, db.Pool)
+	if err != nil {
+		return 0, err
+	}
+	sessions, err := loadCompleted(ctx, db.Pool, nil, false)
+	if err != nil {
+		return 0, err
+	}
+	_, written, err := g.rescore(ctx, db.Pool, sessions, false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to rebuild section scores: %w", err)
+	}
+	return written, nil
+}
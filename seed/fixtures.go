@@ -0,0 +1,198 @@
+package seed
+
+import (
+	"fmt"
+	"math/rand"
+	"mcq-exam/questions"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	firstNames = []string{"Aarav", "Aditi", "Akash", "Ananya", "Arjun", "Bhavna", "Deepak", "Divya", "Farhan", "Gayathri",
+		"Harish", "Ishita", "Karthik", "Kavya", "Lakshmi", "Manoj", "Meera", "Naveen", "Nisha", "Pooja",
+		"Priya", "Rahul", "Rajesh", "Ramya", "Sanjay", "Shreya", "Suresh", "Tanvi", "Varun", "Vidya"}
+	lastNames = []string{"Agarwal", "Bose", "Chatterjee", "Das", "Gupta", "Iyer", "Joshi", "Kumar", "Menon", "Mishra",
+		"Nair", "Patel", "Pillai", "Rao", "Reddy", "Sharma", "Singh", "Subramanian", "Verma", "Yadav"}
+	institutions = []string{"Tamil Nadu Cooperative Union", "Kerala State Cooperative Bank", "NCUI New Delhi",
+		"Vaikunth Mehta National Institute", "Gujarat State Cooperative Union", "Anand Milk Union",
+		"Karnataka Cooperative Federation", "Maharashtra Rajya Sahakari Sangh"}
+	designations = []string{"Student", "Research Scholar", "Assistant Manager", "Cooperative Inspector", "Lecturer", "Secretary"}
+	countries    = []string{"IN", "IN", "IN", "IN", "IN", "IN", "LK", "NP", "BD", "AE"}
+	locales      = []string{"en", "en", "en", "hi"}
+	userAgents   = []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36",
+		"Mozilla/5.0 (Linux; Android 14; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Mobile Safari/537.36",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+	}
+)
+
+// Funnel shares, each of the step before it
+const (
+	openShare     = 0.7
+	attendShare   = 0.75
+	startShare    = 0.8
+	completeShare = 0.75
+)
+
+// student is one generated student and what happened to them
+type student struct {
+	name, email, institution, country, phone, designation, locale string
+	createdAt                                                     time.Time
+
+	conferenceToken string
+	invitedAt       time.Time
+	openedAt        *time.Time
+	attendedAt      *time.Time
+	accessCode      string
+	secondMailAt    *time.Time
+	session         *session
+}
+
+// session is a generated attempt with its answers
+type session struct {
+	token       string
+	seed        int64
+	startedAt   time.Time
+	lastSeenAt  time.Time
+	completedAt *time.Time
+	userAgent   string
+	ip          string
+	answers     []answer
+	// served are questions shown but left unanswered
+	served []int
+}
+
+type answer struct {
+	questionID, option, seconds int
+	correct                     bool
+	submittedAt                 time.Time
+}
+
+// generator derives every student from the run's seed and their number alone, so the same
+// seed always yields the same students however many are asked for
+type generator struct {
+	seed     int64
+	now      time.Time
+	sections []questions.Section
+	cfg      questions.SetConfig
+}
+
+// rng is student n's random source
+func (g *generator) rng(n int) *rand.Rand {
+	return rand.New(rand.NewSource(g.seed*1_000_003 + int64(n)))
+}
+
+// student generates student n (from 1). Times are relative to the run: invitations went
+// out two days ago and attempts happened in the last three hours.
+func (g *generator) student(n int) student {
+	r := g.rng(n)
+	first, last := firstNames[r.Intn(len(firstNames))], lastNames[r.Intn(len(lastNames))]
+	s := student{
+		name:        first + " " + last,
+		email:       fmt.Sprintf("%s.%s.%d@seed%d.invalid", strings.ToLower(first), strings.ToLower(last), n, g.seed),
+		institution: institutions[r.Intn(len(institutions))],
+		country:     countries[r.Intn(len(countries))],
+		phone:       fmt.Sprintf("+919%09d", r.Intn(1_000_000_000)),
+		designation: designations[r.Intn(len(designations))],
+		locale:      locales[r.Intn(len(locales))],
+		createdAt:   g.now.Add(-72*time.Hour - minutes(r, 0, 24*60)),
+
+		conferenceToken: token(r, 32),
+		invitedAt:       g.now.Add(-48*time.Hour + minutes(r, 0, 60)),
+	}
+
+	if r.Float64() >= openShare {
+		return s
+	}
+	opened := s.invitedAt.Add(minutes(r, 5, 600))
+	s.openedAt = &opened
+
+	if r.Float64() >= attendShare {
+		return s
+	}
+	attended := opened.Add(minutes(r, 1, 120))
+	second := attended.Add(10 * time.Minute)
+	s.attendedAt, s.secondMailAt = &attended, &second
+	s.accessCode = accessCode(g.seed, n)
+
+	if r.Float64() >= startShare {
+		return s
+	}
+	s.session = g.session(r, r.Float64() < completeShare)
+	return s
+}
+
+// session generates an attempt: completed ones answer most of the student's question set,
+// ones still in progress only part of it. ability is the chance of a correct answer.
+func (g *generator) session(r *rand.Rand, completed bool) *session {
+	sess := &session{
+		token:     token(r, 64),
+		seed:      r.Int63(),
+		startedAt: g.now.Add(-3*time.Hour + minutes(r, 0, 120)),
+		userAgent: userAgents[r.Intn(len(userAgents))],
+		ip:        fmt.Sprintf("10.%d.%d.%d", r.Intn(256), r.Intn(256), 1+r.Intn(254)),
+	}
+	share := 0.1 + r.Float64()*0.5
+	if completed {
+		share = 0.7 + r.Float64()*0.3
+	}
+	ability := 0.35 + r.Float64()*0.55
+
+	at := sess.startedAt
+	for _, section := range questions.ForSeed(g.sections, sess.seed, g.cfg) {
+		for _, q := range section.Questions {
+			if r.Float64() >= share {
+				// Candidates often look at a question and move on
+				if r.Float64() < 0.3 {
+					sess.served = append(sess.served, q.ID)
+				}
+				continue
+			}
+			option := q.CorrectAnswer
+			if r.Float64() >= ability && len(q.Options) > 1 {
+				option = (q.CorrectAnswer + 1 + r.Intn(len(q.Options)-1)) % len(q.Options)
+			}
+			seconds := 5 + r.Intn(56)
+			at = at.Add(time.Duration(seconds) * time.Second)
+			sess.answers = append(sess.answers, answer{
+				questionID:  q.ID,
+				option:      option,
+				seconds:     seconds,
+				correct:     option == q.CorrectAnswer,
+				submittedAt: at,
+			})
+		}
+	}
+	sess.lastSeenAt = at
+	if completed {
+		done := at.Add(minutes(r, 0, 5))
+		sess.completedAt, sess.lastSeenAt = &done, done
+	}
+	return sess
+}
+
+// minutes is a random duration of lo to hi minutes
+func minutes(r *rand.Rand, lo, hi int) time.Duration {
+	return time.Duration(lo+r.Intn(hi-lo+1)) * time.Minute
+}
+
+// token is a random lowercase hex string of length n
+func token(r *rand.Rand, n int) string {
+	const hex = "0123456789abcdef"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = hex[r.Intn(len(hex))]
+	}
+	return string(b)
+}
+
+// accessCode is student n's 6-character code: n counted up from an offset taken from the
+// seed, in base 36, so codes of one seed never repeat
+func accessCode(seed int64, n int) string {
+	const space = 36 * 36 * 36 * 36 * 36 * 36
+	offset := rand.New(rand.NewSource(seed)).Int63n(space)
+	code := strings.ToUpper(strconv.FormatInt((offset+int64(n))%space, 36))
+	return strings.Repeat("0", 6-len(code)) + code
+}
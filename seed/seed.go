@@ -0,0 +1,373 @@
+// Package seed fills a database with fake students and what they did: invitation emails and
+// their tracking, conference attendance and access codes, and sessions both completed and
+// still in progress. Students come from deterministic generators, so the same seed gives the
+// same data on every machine, for staging, load tests and new developer environments.
+//
+// Seeded students are tagged "seed", use undeliverable .invalid addresses and are marked
+// invalid for email, so no send path ever mails them.
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/accesscodes"
+	"mcq-exam/db"
+	"mcq-exam/questions"
+	"mcq-exam/scoring"
+	"mcq-exam/tracking"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// MaxStudents bounds the students of one run
+const MaxStudents = 20000
+
+// Tag is the tag of every seeded student
+const Tag = "seed"
+
+// ReasonSeeded is the email_invalid_reason of seeded students, so every send path skips them
+const ReasonSeeded = "seeded"
+
+// batchSize is how many students are written per transaction
+const batchSize = 500
+
+// ErrInvalidOptions is wrapped with the reason Run rejected its options
+var ErrInvalidOptions = errors.New("invalid seed options")
+
+// Options select what Run generates
+type Options struct {
+	// Students is how many students to generate, 1 to MaxStudents
+	Students int `json:"students"`
+	// Seed picks the data set; runs with the same seed generate the same students
+	Seed int64 `json:"seed"`
+}
+
+// Report counts what a run wrote
+type Report struct {
+	Seed              int64 `json:"seed"`
+	Students          int   `json:"students"`
+	Existing          int   `json:"existing"`
+	Opened            int   `json:"opened"`
+	Attended          int   `json:"attended"`
+	Sessions          int   `json:"sessions"`
+	CompletedSessions int   `json:"completed_sessions"`
+	Answers           int   `json:"answers"`
+	EmailLogs         int   `json:"email_logs"`
+	DurationMs        int64 `json:"duration_ms"`
+}
+
+// Enabled reports whether the seed endpoint may be used (SEED_ENABLED, default false). The
+// seed command always works.
+func Enabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("SEED_ENABLED"))
+	return enabled
+}
+
+// Run generates opts.Students students and their activity. Students whose address exists
+// already, such as from an earlier run with the same seed, are left as they are, so running
+// again with a larger count only adds the missing ones.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	if opts.Students < 1 || opts.Students > MaxStudents {
+		return nil, fmt.Errorf("%w: students must be between 1 and %d", ErrInvalidOptions, MaxStudents)
+	}
+	if opts.Seed < 0 {
+		return nil, fmt.Errorf("%w: seed must not be negative", ErrInvalidOptions)
+	}
+	start := time.Now()
+
+	sections, err := questions.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load questions: %w", err)
+	}
+	g := &generator{seed: opts.Seed, now: time.Now().UTC().Truncate(time.Second), sections: sections, cfg: questions.ConfigFromEnv()}
+
+	// Invitations are tracked under the system campaigns their real sends use
+	var campaigns [2]int
+	for i, emailType := range []tracking.EmailType{tracking.FirstMail, tracking.SecondMail} {
+		if campaigns[i], err = tracking.SystemCampaign(ctx, emailType); err != nil {
+			return nil, fmt.Errorf("failed to prepare %s campaign: %w", emailType, err)
+		}
+	}
+
+	report := &Report{Seed: opts.Seed}
+	for first := 1; first <= opts.Students; first += batchSize {
+		last := min(first+batchSize-1, opts.Students)
+		students := make([]student, 0, last-first+1)
+		for n := first; n <= last; n++ {
+			students = append(students, g.student(n))
+		}
+		if err := writeBatch(ctx, students, campaigns, report); err != nil {
+			return nil, err
+		}
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+	return report, nil
+}
+
+// writeBatch inserts students and their activity in one transaction, then scores the
+// completed sessions
+func writeBatch(ctx context.Context, students []student, campaigns [2]int, report *Report) error {
+	var completed []int
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		ids, err := insertStudents(ctx, tx, students)
+		if err != nil {
+			return err
+		}
+		report.Existing += len(students) - len(ids)
+
+		var w writer
+		var sessions []sessionRow
+		for _, s := range students {
+			id, ok := ids[s.email]
+			if !ok {
+				continue
+			}
+			report.Students++
+			w.addStudent(id, s, campaigns)
+			if s.openedAt != nil {
+				report.Opened++
+			}
+			if s.attendedAt != nil {
+				report.Attended++
+			}
+			if s.session != nil {
+				sessions = append(sessions, sessionRow{studentID: id, accessCode: s.accessCode, session: s.session})
+			}
+		}
+		if err := w.flush(ctx, tx); err != nil {
+			return err
+		}
+		report.EmailLogs += len(w.rows["email_logs"])
+
+		completed, err = insertSessions(ctx, tx, sessions, report)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := scoring.RefinalizeAll(ctx, completed); err != nil {
+		return fmt.Errorf("failed to score seeded sessions: %w", err)
+	}
+	return nil
+}
+
+// insertStudents adds the students that do not exist yet and returns their IDs by email
+func insertStudents(ctx context.Context, tx pgx.Tx, students []student) (map[string]int, error) {
+	n := len(students)
+	names, emails, institutions, countries := make([]string, n), make([]string, n), make([]string, n), make([]string, n)
+	phones, designations, locales := make([]string, n), make([]string, n), make([]string, n)
+	created := make([]time.Time, n)
+	for i, s := range students {
+		names[i], emails[i], institutions[i], countries[i] = s.name, s.email, s.institution, s.country
+		phones[i], designations[i], locales[i] = s.phone, s.designation, s.locale
+		created[i] = s.createdAt
+	}
+
+	query := `
+		INSERT INTO students (name, email, institution, country, phone, designation, locale, timezone, tags,
+		                      email_valid, email_invalid_reason, created_at, updated_at)
+		SELECT s.name, s.email, s.institution, s.country, s.phone, s.designation, s.locale, 'Asia/Kolkata', ARRAY[$9::text],
+		       false, $10, s.created_at, s.created_at
+		FROM unnest($1::text[], $2::text[], $3::text[], $4::text[], $5::text[], $6::text[], $7::text[], $8::timestamptz[])
+		     AS s(name, email, institution, country, phone, designation, locale, created_at)
+		ON CONFLICT (email) DO NOTHING
+		RETURNING id, email
+	`
+	rows, err := tx.Query(ctx, query, names, emails, institutions, countries, phones, designations, locales, created, Tag, ReasonSeeded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert students: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[string]int, n)
+	for rows.Next() {
+		var id int
+		var email string
+		if err := rows.Scan(&id, &email); err != nil {
+			return nil, fmt.Errorf("failed to insert students: %w", err)
+		}
+		ids[email] = id
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to insert students: %w", err)
+	}
+	return ids, nil
+}
+
+// writer collects the rows of new students' email activity for COPY, by table
+type writer struct {
+	rows map[string][][]any
+}
+
+// columns are the columns written to each table
+var columns = map[string][]string{
+	"email_tracking": {"student_id", "email_type", "conference_token", "conference_attended", "conference_attended_at",
+		"access_code", "access_code_issued_at", "access_code_expires_at", "access_code_used_at", "opened", "opened_at",
+		"created_at", "updated_at"},
+	"email_logs":   {"student_id", "email", "subject", "status", "request_id", "response_code", "provider", "sent_at"},
+	"email_events": {"student_id", "email_type", "event_type", "ip_address", "user_agent", "created_at"},
+	"email_campaign_recipients": {"campaign_id", "student_id", "email", "status", "token", "sent_at", "opened_at",
+		"open_count", "updated_at"},
+	"access_code_events": {"student_id", "action", "reason", "expires_at", "created_at"},
+}
+
+// tables is the order rows are written in
+var tables = []string{"email_tracking", "email_logs", "email_events", "email_campaign_recipients", "access_code_events"}
+
+func (w *writer) add(table string, values ...any) {
+	if w.rows == nil {
+		w.rows = make(map[string][][]any)
+	}
+	w.rows[table] = append(w.rows[table], values)
+}
+
+// addStudent adds the invitation (firstMail) every student got and, once they attended the
+// conference, their access code and the secondMail that sends it
+func (w *writer) addStudent(id int, s student, campaigns [2]int) {
+	var expiresAt, usedAt *time.Time
+	if s.attendedAt != nil {
+		if ttl := accesscodes.TTL(); ttl > 0 {
+			expires := s.attendedAt.Add(ttl)
+			expiresAt = &expires
+		}
+		if s.session != nil {
+			usedAt = &s.session.startedAt
+		}
+	}
+	var accessCode *string
+	if s.accessCode != "" {
+		accessCode = &s.accessCode
+	}
+	updated := s.invitedAt
+	for _, t := range []*time.Time{s.openedAt, s.attendedAt} {
+		if t != nil {
+			updated = *t
+		}
+	}
+	w.add("email_tracking", id, string(tracking.FirstMail), s.conferenceToken, s.attendedAt != nil, s.attendedAt,
+		accessCode, s.attendedAt, expiresAt, usedAt, s.openedAt != nil, s.openedAt, s.invitedAt, updated)
+	w.add("email_logs", id, s.email, "Conference Invitation", "sent", fmt.Sprintf("seed-%d-1", id), "200", "seed", s.invitedAt)
+	w.add("email_events", id, string(tracking.FirstMail), tracking.EventSent, nil, nil, s.invitedAt)
+	openCount := 0
+	if s.openedAt != nil {
+		openCount = 1
+		w.add("email_events", id, string(tracking.FirstMail), tracking.EventOpen, "10.0.0.1", userAgents[id%len(userAgents)], *s.openedAt)
+	}
+	w.add("email_campaign_recipients", campaigns[0], id, s.email, "sent", s.conferenceToken, s.invitedAt, s.openedAt, openCount, updated)
+
+	if s.attendedAt == nil {
+		return
+	}
+	w.add("access_code_events", id, accesscodes.ActionIssued, "seeded", expiresAt, *s.attendedAt)
+	if usedAt != nil {
+		w.add("access_code_events", id, accesscodes.ActionUsed, nil, nil, *usedAt)
+	}
+	second := *s.secondMailAt
+	w.add("email_tracking", id, string(tracking.SecondMail), nil, false, nil, nil, nil, nil, nil, false, nil, second, second)
+	w.add("email_logs", id, s.email, "Your Exam Access Code", "sent", fmt.Sprintf("seed-%d-2", id), "200", "seed", second)
+	w.add("email_events", id, string(tracking.SecondMail), tracking.EventSent, nil, nil, second)
+	w.add("email_campaign_recipients", campaigns[1], id, s.email, "sent", fmt.Sprintf("%s-2", s.conferenceToken), second, nil, 0, second)
+}
+
+// flush copies the collected rows into their tables
+func (w *writer) flush(ctx context.Context, tx pgx.Tx) error {
+	for _, table := range tables {
+		if len(w.rows[table]) == 0 {
+			continue
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{table}, columns[table], pgx.CopyFromRows(w.rows[table])); err != nil {
+			return fmt.Errorf("failed to write %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// sessionRow is a generated session of an inserted student
+type sessionRow struct {
+	studentID  int
+	accessCode string
+	session    *session
+}
+
+// insertSessions adds the sessions with their answers and served questions, returning the
+// IDs of the completed ones. Completed sessions are inserted completed and scored afterwards.
+func insertSessions(ctx context.Context, tx pgx.Tx, rows []sessionRow, report *Report) ([]int, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	n := len(rows)
+	studentIDs, seeds := make([]int, n), make([]int64, n)
+	tokens, codes, ips, agents := make([]string, n), make([]string, n), make([]string, n), make([]string, n)
+	started, lastSeen, completedAt := make([]time.Time, n), make([]time.Time, n), make([]*time.Time, n)
+	for i, row := range rows {
+		s := row.session
+		studentIDs[i], seeds[i] = row.studentID, s.seed
+		tokens[i], codes[i], ips[i], agents[i] = s.token, row.accessCode, s.ip, s.userAgent
+		started[i], lastSeen[i], completedAt[i] = s.startedAt, s.lastSeenAt, s.completedAt
+	}
+
+	query := `
+		INSERT INTO sessions (student_id, session_token, access_code, question_seed, started_at, last_seen_at,
+		                      completed, completed_at, start_ip, start_user_agent, start_country, created_at, updated_at)
+		SELECT s.student_id, s.token, s.code, s.seed, s.started_at, s.last_seen_at,
+		       s.completed_at IS NOT NULL, s.completed_at, s.ip, s.agent, st.country, s.started_at, s.last_seen_at
+		FROM unnest($1::int[], $2::text[], $3::text[], $4::bigint[], $5::timestamptz[], $6::timestamptz[],
+		            $7::timestamptz[], $8::text[], $9::text[])
+		     AS s(student_id, token, code, seed, started_at, last_seen_at, completed_at, ip, agent)
+		JOIN students st ON st.id = s.student_id
+		RETURNING id, student_id
+	`
+	result, err := tx.Query(ctx, query, studentIDs, tokens, codes, seeds, started, lastSeen, completedAt, ips, agents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert sessions: %w", err)
+	}
+	sessionIDs := make(map[int]int, n)
+	for result.Next() {
+		var id, studentID int
+		if err := result.Scan(&id, &studentID); err != nil {
+			result.Close()
+			return nil, fmt.Errorf("failed to insert sessions: %w", err)
+		}
+		sessionIDs[studentID] = id
+	}
+	result.Close()
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("failed to insert sessions: %w", err)
+	}
+
+	var answers, serves [][]any
+	var completed []int
+	for _, row := range rows {
+		id := sessionIDs[row.studentID]
+		s := row.session
+		report.Sessions++
+		if s.completedAt != nil {
+			report.CompletedSessions++
+			completed = append(completed, id)
+		}
+		for _, a := range s.answers {
+			served := a.submittedAt.Add(-time.Duration(a.seconds) * time.Second)
+			answers = append(answers, []any{id, a.questionID, a.option, a.correct, a.seconds, a.seconds, a.submittedAt})
+			serves = append(serves, []any{id, a.questionID, served, served, 1})
+		}
+		for _, questionID := range s.served {
+			serves = append(serves, []any{id, questionID, s.lastSeenAt, s.lastSeenAt, 1})
+		}
+		report.Answers += len(s.answers)
+	}
+
+	answerColumns := []string{"session_id", "question_id", "selected_option_index", "is_correct", "time_taken_seconds",
+		"client_time_taken_seconds", "submitted_at"}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"answers"}, answerColumns, pgx.CopyFromRows(answers)); err != nil {
+		return nil, fmt.Errorf("failed to write answers: %w", err)
+	}
+	serveColumns := []string{"session_id", "question_id", "first_served_at", "last_served_at", "serve_count"}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"question_serves"}, serveColumns, pgx.CopyFromRows(serves)); err != nil {
+		return nil, fmt.Errorf("failed to write served questions: %w", err)
+	}
+	return completed, nil
+}
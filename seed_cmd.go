@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mcq-exam/seed"
+	"os"
+	"strconv"
+	"time"
+)
+
+const seedUsage = `Usage: %s seed STUDENTS [SEED]
+
+Fills the database with STUDENTS fake students (at most %d) with email tracking and logs,
+and sessions both completed and in progress. SEED (default 1) picks the data set: the same
+seed always generates the same students, and students that exist already are skipped.
+Run "migrate up" first on a new database.
+`
+
+// runSeedCommand handles "<binary> seed ..." and returns the process exit code
+func runSeedCommand(args []string) int {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintf(os.Stderr, seedUsage, os.Args[0], seed.MaxStudents)
+		return 2
+	}
+	opts := seed.Options{Seed: 1}
+	var err error
+	if opts.Students, err = strconv.Atoi(args[0]); err != nil {
+		fmt.Fprintln(os.Stderr, "STUDENTS must be a number")
+		return 2
+	}
+	if len(args) == 2 {
+		if opts.Seed, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+			fmt.Fprintln(os.Stderr, "SEED must be a number")
+			return 2
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	report, err := seed.Run(ctx, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	return 0
+}
@@ -0,0 +1,393 @@
+// Package segments parses the student filter language used to pick campaign audiences,
+// bulk-tag students and narrow tracking exports, e.g.
+//
+//	country=IN AND tag=vip AND NOT attended
+//	(domain=iitm.ac.in OR institution~"IIT") AND completed
+//
+// A filter compiles to a WHERE condition over students aliased as s.
+package segments
+
+import (
+	"errors"
+	"fmt"
+	"mcq-exam/i18n"
+	"mcq-exam/tracking"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxFilterLength and maxDepth bound what a filter may cost to parse
+const (
+	maxFilterLength = 1000
+	maxDepth        = 20
+)
+
+// ErrInvalidFilter is wrapped by Compile for a filter that does not parse
+var ErrInvalidFilter = errors.New("invalid filter")
+
+var (
+	tagPattern     = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+	countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// NormalizeTag lowercases and trims a tag and checks it: letters, digits, '-' and '_',
+// up to 50 characters, starting with a letter or digit
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if !tagPattern.MatchString(tag) {
+		return "", fmt.Errorf("tag %q must be 1-50 letters, digits, '-' or '_', starting with a letter or digit", tag)
+	}
+	return tag, nil
+}
+
+// field is a comparison such as country=IN. Every field accepts '=' and its negation '!=';
+// text fields also accept '~' (contains, ignoring case).
+type field struct {
+	// ops are the comparisons the field accepts
+	ops string
+	// normalize checks and rewrites the value; nil keeps it as typed
+	normalize func(string) (string, error)
+	// build is the condition for a comparison against the placeholder arg
+	build func(op, arg string) string
+}
+
+func textField(column string) field {
+	return field{ops: "=~", build: func(op, arg string) string {
+		if op == "~" {
+			return column + ` ILIKE '%' || ` + arg + ` || '%'`
+		}
+		return `LOWER(` + column + `) = LOWER(` + arg + `)`
+	}}
+}
+
+func eventField(eventType string) field {
+	return field{ops: "=", normalize: func(value string) (string, error) {
+		return string(tracking.ParseEmailType(value)), nil
+	}, build: func(op, arg string) string {
+		return `EXISTS (SELECT 1 FROM email_events e WHERE e.student_id = s.id AND e.email_type = ` + arg +
+			` AND e.event_type = '` + eventType + `')`
+	}}
+}
+
+var fields = map[string]field{
+	"tag": {ops: "=", normalize: NormalizeTag, build: func(op, arg string) string {
+		return arg + ` = ANY(s.tags)`
+	}},
+	"country": {ops: "=", normalize: func(value string) (string, error) {
+		value = strings.ToUpper(value)
+		if !countryPattern.MatchString(value) {
+			return "", fmt.Errorf("country %q must be a two-letter ISO 3166-1 code", value)
+		}
+		return value, nil
+	}, build: func(op, arg string) string {
+		return `s.country = ` + arg
+	}},
+	"domain":      textField(`split_part(s.email, '@', 2)`),
+	"email":       textField(`s.email`),
+	"name":        textField(`s.name`),
+	"institution": textField(`s.institution`),
+	"designation": textField(`s.designation`),
+	// locale= matches students without one when it is the default locale
+	"locale": {ops: "=", normalize: func(value string) (string, error) {
+		locale, ok := i18n.Normalize(value)
+		if !ok {
+			return "", fmt.Errorf("locale %q is not supported (%s)", value, strings.Join(i18n.Locales, ", "))
+		}
+		return locale, nil
+	}, build: func(op, arg string) string {
+		return `COALESCE(s.locale, '` + i18n.Default() + `') = ` + arg
+	}},
+	// sent=, opened= and clicked= take an email type such as firstMail or campaign-3
+	"sent":    eventField("sent"),
+	"opened":  eventField("open"),
+	"clicked": eventField("click"),
+}
+
+// flags are conditions that take no value
+var flags = map[string]string{
+	"invited":    `EXISTS (SELECT 1 FROM email_tracking et WHERE et.student_id = s.id AND et.email_type = 'firstMail')`,
+	"opened":     `EXISTS (SELECT 1 FROM email_events e WHERE e.student_id = s.id AND e.event_type = 'open')`,
+	"clicked":    `EXISTS (SELECT 1 FROM email_events e WHERE e.student_id = s.id AND e.event_type = 'click')`,
+	"attended":   `EXISTS (SELECT 1 FROM email_tracking et WHERE et.student_id = s.id AND et.email_type = 'firstMail' AND et.conference_attended = true)`,
+	"started":    `EXISTS (SELECT 1 FROM sessions sess WHERE sess.student_id = s.id)`,
+	"completed":  `EXISTS (SELECT 1 FROM sessions sess WHERE sess.student_id = s.id AND sess.completed = true)`,
+	"suppressed": `EXISTS (SELECT 1 FROM suppressed_emails se WHERE se.email = LOWER(TRIM(s.email)))`,
+	"tagged":     `cardinality(s.tags) > 0`,
+	// invalid_email is an address that failed validation (see package emailcheck)
+	"invalid_email": `s.email_valid = false`,
+}
+
+// Flag returns the condition of a flag such as attended, for callers that filter on one
+// without parsing a filter; the name must be one of Flags
+func Flag(name string) string {
+	condition, ok := flags[name]
+	if !ok {
+		panic("segments: unknown flag " + name)
+	}
+	return condition
+}
+
+// Fields and Flags list the language's vocabulary for error messages and docs
+func Fields() []string { return sortedKeys(fields) }
+func Flags() []string  { return sortedKeys(flags) }
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Filter is a compiled filter: SQL is a condition over students aliased as s whose
+// placeholders start at the firstArg given to Compile
+type Filter struct {
+	SQL  string
+	Args []any
+}
+
+// Compile parses a filter. Placeholders are numbered from firstArg so the condition can
+// join a query that already has arguments. An empty filter matches every student.
+func Compile(query string, firstArg int) (*Filter, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return &Filter{SQL: "TRUE"}, nil
+	}
+	if len(query) > maxFilterLength {
+		return nil, fmt.Errorf("%w: longer than %d characters", ErrInvalidFilter, maxFilterLength)
+	}
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, firstArg: firstArg}
+	sql, err := p.or(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected %s", p.tokens[p.pos])
+	}
+	return &Filter{SQL: sql, Args: p.args}, nil
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOp
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// pos is the 1-based character offset of the token, for error messages
+	pos int
+}
+
+func (t token) String() string {
+	if t.kind == tokenString {
+		return fmt.Sprintf("%q at position %d", t.text, t.pos)
+	}
+	return fmt.Sprintf("'%s' at position %d", t.text, t.pos)
+}
+
+// keyword reports whether the token is AND, OR or NOT, in any case
+func (t token) keyword(word string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, word)
+}
+
+func lex(query string) ([]token, error) {
+	runes := []rune(query)
+	var tokens []token
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokenOpen, "(", i + 1})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenClose, ")", i + 1})
+			i++
+		case r == '=' || r == '~':
+			tokens = append(tokens, token{tokenOp, string(r), i + 1})
+			i++
+		case r == '!':
+			if i+1 >= len(runes) || runes[i+1] != '=' {
+				return nil, fmt.Errorf("%w: '!' at position %d must be part of '!='", ErrInvalidFilter, i+1)
+			}
+			tokens = append(tokens, token{tokenOp, "!=", i + 1})
+			i += 2
+		case r == '"':
+			start := i
+			var text strings.Builder
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				text.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("%w: unterminated string at position %d", ErrInvalidFilter, start+1)
+			}
+			tokens = append(tokens, token{tokenString, text.String(), start + 1})
+			i++
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`()=~!"`, runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenWord, string(runes[start:i]), start + 1})
+		}
+	}
+	return tokens, nil
+}
+
+// parser is a recursive descent over: or := and {OR and}; and := not {AND not};
+// not := NOT not | ( or ) | field op value | flag
+type parser struct {
+	tokens   []token
+	pos      int
+	firstArg int
+	args     []any
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidFilter, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) or(depth int) (string, error) {
+	left, err := p.and(depth)
+	if err != nil {
+		return "", err
+	}
+	for {
+		next, ok := p.peek()
+		if !ok || !next.keyword("OR") {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.and(depth)
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " OR " + right + ")"
+	}
+}
+
+func (p *parser) and(depth int) (string, error) {
+	left, err := p.not(depth)
+	if err != nil {
+		return "", err
+	}
+	for {
+		next, ok := p.peek()
+		if !ok || !next.keyword("AND") {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.not(depth)
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " AND " + right + ")"
+	}
+}
+
+func (p *parser) not(depth int) (string, error) {
+	if depth > maxDepth {
+		return "", p.errorf("nested more than %d levels", maxDepth)
+	}
+	next, ok := p.peek()
+	if !ok {
+		return "", p.errorf("expected a condition at the end")
+	}
+	switch {
+	case next.keyword("NOT"):
+		p.pos++
+		inner, err := p.not(depth + 1)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + inner + ")", nil
+	case next.kind == tokenOpen:
+		p.pos++
+		inner, err := p.or(depth + 1)
+		if err != nil {
+			return "", err
+		}
+		if closing, ok := p.peek(); !ok || closing.kind != tokenClose {
+			return "", p.errorf("missing ')' for '(' at position %d", next.pos)
+		}
+		p.pos++
+		return inner, nil
+	case next.kind != tokenWord || next.keyword("AND") || next.keyword("OR"):
+		return "", p.errorf("expected a condition, found %s", next)
+	}
+	return p.condition()
+}
+
+// condition is field op value, or a flag. Conditions are never NULL, so NOT inverts them.
+func (p *parser) condition() (string, error) {
+	name := p.tokens[p.pos]
+	p.pos++
+	key := strings.ToLower(name.text)
+
+	op, ok := p.peek()
+	if !ok || op.kind != tokenOp {
+		if sql, isFlag := flags[key]; isFlag {
+			return sql, nil
+		}
+		if _, isField := fields[key]; isField {
+			return "", p.errorf("%s needs a value, e.g. %s=...", name, key)
+		}
+		return "", p.errorf("unknown condition %s; fields: %s; flags: %s", name,
+			strings.Join(Fields(), ", "), strings.Join(Flags(), ", "))
+	}
+	f, isField := fields[key]
+	if !isField {
+		return "", p.errorf("unknown field %s; fields: %s", name, strings.Join(Fields(), ", "))
+	}
+	p.pos++
+	comparison := strings.TrimPrefix(op.text, "!")
+	if !strings.Contains(f.ops, comparison) {
+		return "", p.errorf("%s does not support '%s'", name, op.text)
+	}
+
+	value, ok := p.peek()
+	if !ok || (value.kind != tokenWord && value.kind != tokenString) {
+		return "", p.errorf("expected a value after %s", op)
+	}
+	p.pos++
+	text := value.text
+	if f.normalize != nil {
+		normalized, err := f.normalize(text)
+		if err != nil {
+			return "", p.errorf("%v (position %d)", err, value.pos)
+		}
+		text = normalized
+	}
+	p.args = append(p.args, text)
+	arg := fmt.Sprintf("$%d::text", p.firstArg+len(p.args)-1)
+
+	sql := "COALESCE(" + f.build(comparison, arg) + ", false)"
+	if op.text == "!=" {
+		return "NOT " + sql, nil
+	}
+	return sql, nil
+}
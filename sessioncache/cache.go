@@ -0,0 +1,166 @@
+package sessioncache
+
+import (
+	"context"
+	"errors"
+	"mcq-exam/db"
+	"mcq-exam/metrics"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrNotFound means no session has the token
+	ErrNotFound = errors.New("session not found")
+	// ErrCompleted means the session's test has already been submitted
+	ErrCompleted = errors.New("session already completed")
+)
+
+// Session is the part of a sessions row needed to validate a token and accept answers.
+// Only sessions that are not completed are cached.
+type Session struct {
+	ID           int    `json:"id"`
+	StudentID    int    `json:"student_id"`
+	QuestionSeed *int64 `json:"question_seed"`
+	// FinishBy is a late entrant's reduced deadline, before exam pauses push it back
+	FinishBy *time.Time `json:"finish_by,omitempty"`
+}
+
+// Store keeps active sessions by token
+type Store interface {
+	Get(ctx context.Context, token string) (*Session, bool, error)
+	Set(ctx context.Context, token string, session *Session, ttl time.Duration) error
+	Delete(ctx context.Context, token string) error
+	Clear(ctx context.Context) error
+}
+
+var (
+	store Store
+	ttl   time.Duration
+)
+
+// Init selects the session cache from SESSION_CACHE: "redis" (default when Redis is configured),
+// "memory" (default otherwise, single instance only) or "off". SESSION_CACHE_TTL (default 4h)
+// bounds how long an entry lives; SESSION_CACHE_SIZE (default 10000) caps the in-memory LRU.
+func Init() {
+	ttl = 4 * time.Hour
+	if value := os.Getenv("SESSION_CACHE_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			log.Warn().Msgf("Invalid SESSION_CACHE_TTL=%q, using %s", value, ttl)
+		} else {
+			ttl = parsed
+		}
+	}
+
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("SESSION_CACHE")))
+	if mode == "" {
+		mode = "memory"
+		if db.Redis != nil {
+			mode = "redis"
+		}
+	}
+
+	if mode == "redis" && db.Redis == nil {
+		log.Warn().Msg("SESSION_CACHE=redis but REDIS_URL is not set, using in-memory session cache")
+		mode = "memory"
+	}
+
+	switch mode {
+	case "off":
+		store = nil
+		log.Info().Msg("Session cache disabled")
+	case "redis":
+		store = NewRedisStore(db.Redis)
+		log.Info().Dur("ttl", ttl).Msg("Session cache using Redis")
+	default:
+		if mode != "memory" {
+			log.Warn().Msgf("Unknown SESSION_CACHE=%q, using in-memory session cache", mode)
+		}
+		size := cacheSize()
+		store = NewMemoryStore(size)
+		log.Info().Int("size", size).Dur("ttl", ttl).Msg("Session cache using in-memory LRU")
+	}
+}
+
+func cacheSize() int {
+	size := 10000
+	if value := os.Getenv("SESSION_CACHE_SIZE"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			log.Warn().Msgf("Invalid SESSION_CACHE_SIZE=%q, using %d", value, size)
+		} else {
+			size = parsed
+		}
+	}
+	return size
+}
+
+// Lookup returns the active session for token, from the cache or else from Postgres
+// (caching the result). Returns ErrNotFound or ErrCompleted if the token cannot be used;
+// with ErrCompleted the session is returned too, so the attempt can still be attributed.
+// Cache errors are logged and fall through to the database.
+func Lookup(ctx context.Context, token string) (*Session, error) {
+	if store != nil {
+		session, found, err := store.Get(ctx, token)
+		if err != nil {
+			log.Error().Err(err).Msg("Session cache read failed")
+		} else if found {
+			metrics.SessionCacheLookupsTotal.WithLabelValues("hit").Inc()
+			return session, nil
+		}
+		metrics.SessionCacheLookupsTotal.WithLabelValues("miss").Inc()
+	}
+
+	var session Session
+	var completed bool
+	query := `
+		SELECT id, student_id, completed, question_seed, finish_by
+		FROM sessions
+		WHERE session_token = $1
+	`
+	err := db.Pool.QueryRow(ctx, query, token).Scan(&session.ID, &session.StudentID, &completed, &session.QuestionSeed, &session.FinishBy)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if completed {
+		return &session, ErrCompleted
+	}
+
+	Put(ctx, token, &session)
+	return &session, nil
+}
+
+// Put caches an active session, e.g. when it is created or its question seed is assigned
+func Put(ctx context.Context, token string, session *Session) {
+	if store == nil {
+		return
+	}
+	if err := store.Set(ctx, token, session, ttl); err != nil {
+		log.Error().Err(err).Msg("Session cache write failed")
+	}
+}
+
+// Invalidate drops a session from the cache; call it when the session completes
+func Invalidate(ctx context.Context, token string) {
+	if store == nil {
+		return
+	}
+	if err := store.Delete(ctx, token); err != nil {
+		log.Warn().Err(err).Msg("Session cache invalidation failed")
+	}
+}
+
+// Clear empties the cache, e.g. after the database is reset
+func Clear(ctx context.Context) {
+	if store == nil {
+		return
+	}
+	if err := store.Clear(ctx); err != nil {
+		log.Error().Err(err).Msg("Session cache clear failed")
+	}
+}
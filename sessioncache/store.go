@@ -0,0 +1,166 @@
+package sessioncache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ============================================
+// IN-MEMORY STORE
+// ============================================
+
+type memoryEntry struct {
+	token     string
+	session   Session
+	expiresAt time.Time
+}
+
+// MemoryStore is a size-bounded LRU in process memory. Invalidation is not shared,
+// so it is only safe with a single instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front = most recently used
+	entries map[string]*list.Element
+}
+
+// NewMemoryStore creates an LRU holding at most size sessions
+func NewMemoryStore(size int) *MemoryStore {
+	return &MemoryStore{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryStore) Get(_ context.Context, token string) (*Session, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, exists := s.entries[token]
+	if !exists {
+		return nil, false, nil
+	}
+
+	entry := element.Value.(*memoryEntry)
+	if time.Now().After(entry.expiresAt) {
+		s.order.Remove(element)
+		delete(s.entries, token)
+		return nil, false, nil
+	}
+
+	s.order.MoveToFront(element)
+	session := entry.session
+	return &session, true, nil
+}
+
+func (s *MemoryStore) Set(_ context.Context, token string, session *Session, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := &memoryEntry{token: token, session: *session, expiresAt: time.Now().Add(ttl)}
+	if element, exists := s.entries[token]; exists {
+		element.Value = entry
+		s.order.MoveToFront(element)
+		return nil
+	}
+
+	s.entries[token] = s.order.PushFront(entry)
+	for s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryEntry).token)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, exists := s.entries[token]; exists {
+		s.order.Remove(element)
+		delete(s.entries, token)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Clear(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.order.Init()
+	s.entries = make(map[string]*list.Element)
+	return nil
+}
+
+// ============================================
+// REDIS STORE
+// ============================================
+
+const redisKeyPrefix = "session:"
+
+// RedisStore shares cached sessions across instances through Redis
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a Redis-backed store
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Get(ctx context.Context, token string) (*Session, bool, error) {
+	value, err := s.client.Get(ctx, redisKeyPrefix+token).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var session Session
+	if err := json.Unmarshal(value, &session); err != nil {
+		return nil, false, err
+	}
+	return &session, true, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, token string, session *Session, ttl time.Duration) error {
+	value, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, redisKeyPrefix+token, value, ttl).Err()
+}
+
+func (s *RedisStore) Delete(ctx context.Context, token string) error {
+	return s.client.Del(ctx, redisKeyPrefix+token).Err()
+}
+
+// Clear deletes every cached session key, scanning in batches
+func (s *RedisStore) Clear(ctx context.Context) error {
+	iter := s.client.Scan(ctx, 0, redisKeyPrefix+"*", 500).Iterator()
+	keys := make([]string, 0, 500)
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == 500 {
+			if err := s.client.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return s.client.Del(ctx, keys...).Err()
+	}
+	return nil
+}
@@ -0,0 +1,419 @@
+// Package studentmerge folds a duplicate student record, usually a second registration
+// under a typo'd email, into the record that is kept. Everything the duplicate owns moves
+// to the kept student in one transaction and the duplicate is deleted.
+//
+// Conflicts are resolved as follows:
+//   - Name and sandbox flag are the kept student's; an empty profile field (institution,
+//     country, phone, designation, timezone, locale) takes the merged student's value, and
+//     tags are the union of both.
+//   - The email is the kept student's unless the merged student's address is chosen, and
+//     its validation result comes with it.
+//     Tracked emails and sessions issued to an address that is not the final one are
+//     pinned to it, as after an email change.
+//   - Where both have a tracking row of the same email type, one row remains: attendance
+//     and opens are combined, taking the earliest times, and the kept student's conference
+//     token and access code stand unless only the merged student has one.
+//   - Sessions of both are renumbered as attempts in the order they started.
+//   - Where both were recipients of a campaign, the row that was sent remains, else the
+//     kept student's, with the opens and clicks of both; the kept student's result link
+//     token stands if it has one.
+//   - Logs and audit trails of both are kept in full.
+package studentmerge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrSameStudent = errors.New("cannot merge a student into itself")
+	ErrNotFound    = errors.New("student not found")
+	ErrSandbox     = errors.New("sandbox students can only be merged with sandbox students")
+	ErrSessionOpen = errors.New("a student has a test in progress; merge after it ends")
+	ErrEmail       = errors.New("email must be the address of one of the two students")
+)
+
+// movedTables take the merged student's rows as they are; the tables with conflict rules
+// are handled before them
+var movedTables = []string{
+	"session_section_scores",
+	"email_logs",
+	"email_events",
+	"email_outbox",
+	"email_links",
+	"access_code_events",
+	"otp_attempts",
+	"otp_lockouts",
+	"notification_logs",
+	"student_email_changes",
+	"email_change_requests",
+	"client_events",
+	"session_admin_actions",
+	"regrade_sessions",
+	"registrations",
+}
+
+// Request names the two students and the email to keep
+type Request struct {
+	KeepID  int
+	MergeID int
+	// Email is the address the kept student ends up with; empty keeps its own
+	Email     string
+	Reason    string
+	IP        string
+	UserAgent string
+}
+
+// Merge is a student_merges row
+type Merge struct {
+	ID              int              `json:"id"`
+	KeptStudentID   *int             `json:"kept_student_id"`
+	MergedStudentID int              `json:"merged_student_id"`
+	KeptEmail       string           `json:"kept_email"`
+	MergedEmail     string           `json:"merged_email"`
+	FinalEmail      string           `json:"final_email"`
+	MergedStudent   json.RawMessage  `json:"merged_student"`
+	Moved           map[string]int64 `json:"moved"`
+	Reason          *string          `json:"reason"`
+	IP              *string          `json:"ip"`
+	UserAgent       *string          `json:"user_agent"`
+	CreatedAt       time.Time        `json:"created_at"`
+}
+
+const mergeColumns = `id, kept_student_id, merged_student_id, kept_email, merged_email, final_email, merged_student, moved, reason, ip, user_agent, created_at`
+
+func scanMerge(row pgx.Row, m *Merge) error {
+	return row.Scan(&m.ID, &m.KeptStudentID, &m.MergedStudentID, &m.KeptEmail, &m.MergedEmail, &m.FinalEmail,
+		&m.MergedStudent, &m.Moved, &m.Reason, &m.IP, &m.UserAgent, &m.CreatedAt)
+}
+
+type student struct {
+	email     string
+	isSandbox bool
+}
+
+// lockStudents locks both students, in id order so concurrent merges cannot deadlock
+func lockStudents(ctx context.Context, tx pgx.Tx, keepID, mergeID int) (student, student, error) {
+	var keep, merge student
+	rows, err := tx.Query(ctx, `SELECT id, email, is_sandbox FROM students WHERE id IN ($1, $2) ORDER BY id FOR UPDATE`, keepID, mergeID)
+	if err != nil {
+		return keep, merge, err
+	}
+	defer rows.Close()
+
+	found := 0
+	for rows.Next() {
+		var id int
+		var s student
+		if err := rows.Scan(&id, &s.email, &s.isSandbox); err != nil {
+			return keep, merge, err
+		}
+		if id == keepID {
+			keep = s
+		} else {
+			merge = s
+		}
+		found++
+	}
+	if err := rows.Err(); err != nil {
+		return keep, merge, err
+	}
+	if found < 2 {
+		return keep, merge, ErrNotFound
+	}
+	return keep, merge, nil
+}
+
+// Students merges req.MergeID into req.KeepID and records the merge
+func Students(ctx context.Context, req Request) (*Merge, error) {
+	if req.KeepID == req.MergeID {
+		return nil, ErrSameStudent
+	}
+
+	var merge Merge
+	err := db.WithTx(ctx, func(tx pgx.Tx) error {
+		keep, merged, err := lockStudents(ctx, tx, req.KeepID, req.MergeID)
+		if err != nil {
+			return err
+		}
+		if keep.isSandbox != merged.isSandbox {
+			return ErrSandbox
+		}
+
+		finalEmail := keep.email
+		switch {
+		case req.Email == "" || strings.EqualFold(req.Email, keep.email):
+		case strings.EqualFold(req.Email, merged.email):
+			finalEmail = merged.email
+		default:
+			return ErrEmail
+		}
+
+		var open bool
+		err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM sessions WHERE student_id IN ($1, $2) AND completed = false)`,
+			req.KeepID, req.MergeID).Scan(&open)
+		if err != nil {
+			return err
+		}
+		if open {
+			return ErrSessionOpen
+		}
+
+		var snapshot json.RawMessage
+		if err := tx.QueryRow(ctx, `SELECT to_jsonb(s) FROM students s WHERE id = $1`, req.MergeID).Scan(&snapshot); err != nil {
+			return err
+		}
+
+		// Rows issued to an address other than the final one keep pointing at it
+		for _, pin := range []struct {
+			id    int
+			email string
+		}{{req.KeepID, keep.email}, {req.MergeID, merged.email}} {
+			if pin.email == finalEmail {
+				continue
+			}
+			if _, err := tx.Exec(ctx, `UPDATE email_tracking SET recipient_email = $2 WHERE student_id = $1 AND recipient_email IS NULL`, pin.id, pin.email); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, `UPDATE sessions SET student_email = $2 WHERE student_id = $1 AND student_email IS NULL`, pin.id, pin.email); err != nil {
+				return err
+			}
+		}
+
+		moved := make(map[string]int64)
+		steps := []func(context.Context, pgx.Tx, int, int, map[string]int64) error{
+			mergeTracking, mergeSessions, mergeCampaignRecipients, mergeResultTokens,
+		}
+		for _, step := range steps {
+			if err := step(ctx, tx, req.KeepID, req.MergeID, moved); err != nil {
+				return err
+			}
+		}
+		for _, table := range movedTables {
+			tag, err := tx.Exec(ctx, `UPDATE `+table+` SET student_id = $1 WHERE student_id = $2`, req.KeepID, req.MergeID)
+			if err != nil {
+				return fmt.Errorf("failed to move %s: %w", table, err)
+			}
+			moved[table] = tag.RowsAffected()
+		}
+
+		if _, err := tx.Exec(ctx, `DELETE FROM students WHERE id = $1`, req.MergeID); err != nil {
+			return err
+		}
+		query := `
+			UPDATE students k
+			SET email = $3,
+			    email_valid = CASE WHEN $3 = k.email THEN k.email_valid ELSE m.email_valid END,
+			    email_invalid_reason = CASE WHEN $3 = k.email THEN k.email_invalid_reason ELSE m.email_invalid_reason END,
+			    email_checked_at = CASE WHEN $3 = k.email THEN k.email_checked_at ELSE m.email_checked_at END,
+			    institution = COALESCE(k.institution, m.institution),
+			    country = COALESCE(k.country, m.country),
+			    phone = COALESCE(k.phone, m.phone),
+			    designation = COALESCE(k.designation, m.designation),
+			    timezone = COALESCE(k.timezone, m.timezone),
+			    locale = COALESCE(k.locale, m.locale),
+			    tags = ARRAY(SELECT DISTINCT tag FROM unnest(k.tags || m.tags) AS tag ORDER BY tag),
+			    created_at = LEAST(k.created_at, m.created_at),
+			    updated_at = NOW()
+			FROM jsonb_populate_record(NULL::students, $2) m
+			WHERE k.id = $1
+		`
+		if _, err := tx.Exec(ctx, query, req.KeepID, snapshot, finalEmail); err != nil {
+			return err
+		}
+
+		// The address given up goes into the kept student's email history
+		for _, old := range []string{keep.email, merged.email} {
+			if old == finalEmail {
+				continue
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO student_email_changes (student_id, old_email, new_email) VALUES ($1, $2, $3)`,
+				req.KeepID, old, finalEmail); err != nil {
+				return err
+			}
+		}
+
+		movedJSON, err := json.Marshal(moved)
+		if err != nil {
+			return err
+		}
+		insertQuery := `
+			INSERT INTO student_merges (kept_student_id, merged_student_id, kept_email, merged_email, final_email,
+			                            merged_student, moved, reason, ip, user_agent)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''), NULLIF($10, ''))
+			RETURNING ` + mergeColumns
+		return scanMerge(tx.QueryRow(ctx, insertQuery, req.KeepID, req.MergeID, keep.email, merged.email, finalEmail,
+			snapshot, movedJSON, req.Reason, req.IP, req.UserAgent), &merge)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &merge, nil
+}
+
+// mergeTracking folds each tracking row of the merged student into the kept student's row of
+// the same email type, then moves the rest. The folded rows are deleted before their values
+// are copied, so an access code moving to the kept row is never held twice, which the unique
+// index on access_code would refuse.
+func mergeTracking(ctx context.Context, tx pgx.Tx, keepID, mergeID int, moved map[string]int64) error {
+	combineQuery := `
+		WITH m AS (
+			DELETE FROM email_tracking m
+			USING email_tracking k
+			WHERE m.student_id = $2 AND k.student_id = $1 AND k.email_type = m.email_type
+			RETURNING m.*
+		)
+		UPDATE email_tracking k
+		SET conference_token = COALESCE(k.conference_token, m.conference_token),
+		    conference_attended = COALESCE(k.conference_attended, false) OR COALESCE(m.conference_attended, false),
+		    conference_attended_at = LEAST(k.conference_attended_at, m.conference_attended_at),
+		    access_code = COALESCE(k.access_code, m.access_code),
+		    access_code_issued_at = CASE WHEN k.access_code IS NULL THEN m.access_code_issued_at ELSE k.access_code_issued_at END,
+		    access_code_expires_at = CASE WHEN k.access_code IS NULL THEN m.access_code_expires_at ELSE k.access_code_expires_at END,
+		    access_code_used_at = CASE WHEN k.access_code IS NULL THEN m.access_code_used_at ELSE k.access_code_used_at END,
+		    access_code_invalidated_at = CASE WHEN k.access_code IS NULL THEN m.access_code_invalidated_at ELSE k.access_code_invalidated_at END,
+		    recipient_email = CASE WHEN k.access_code IS NULL AND m.access_code IS NOT NULL THEN m.recipient_email ELSE k.recipient_email END,
+		    opened = COALESCE(k.opened, false) OR COALESCE(m.opened, false),
+		    opened_at = LEAST(k.opened_at, m.opened_at),
+		    updated_at = NOW()
+		FROM m
+		WHERE k.student_id = $1 AND k.email_type = m.email_type
+	`
+	tag, err := tx.Exec(ctx, combineQuery, keepID, mergeID)
+	if err != nil {
+		return fmt.Errorf("failed to combine email_tracking: %w", err)
+	}
+	moved["email_tracking_combined"] = tag.RowsAffected()
+
+	tag, err = tx.Exec(ctx, `UPDATE email_tracking SET student_id = $1, updated_at = NOW() WHERE student_id = $2`, keepID, mergeID)
+	if err != nil {
+		return fmt.Errorf("failed to move email_tracking: %w", err)
+	}
+	moved["email_tracking"] = tag.RowsAffected()
+	return nil
+}
+
+// mergeSessions moves the merged student's sessions and renumbers every attempt by start
+// time. unique_student_attempt is checked row by row, so the moved sessions are numbered
+// after the kept student's first and the new numbers pass through negatives.
+func mergeSessions(ctx context.Context, tx pgx.Tx, keepID, mergeID int, moved map[string]int64) error {
+	moveQuery := `
+		UPDATE sessions
+		SET student_id = $1,
+		    attempt_number = attempt_number + (SELECT COALESCE(MAX(attempt_number), 0) FROM sessions WHERE student_id = $1),
+		    updated_at = NOW()
+		WHERE student_id = $2
+	`
+	tag, err := tx.Exec(ctx, moveQuery, keepID, mergeID)
+	if err != nil {
+		return fmt.Errorf("failed to move sessions: %w", err)
+	}
+	moved["sessions"] = tag.RowsAffected()
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	renumberQuery := `
+		UPDATE sessions s
+		SET attempt_number = -numbered.n
+		FROM (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY started_at, id) AS n
+			FROM sessions
+			WHERE student_id = $1
+		) numbered
+		WHERE s.id = numbered.id
+	`
+	if _, err := tx.Exec(ctx, renumberQuery, keepID); err != nil {
+		return fmt.Errorf("failed to renumber attempts: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `UPDATE sessions SET attempt_number = -attempt_number WHERE student_id = $1`, keepID); err != nil {
+		return fmt.Errorf("failed to renumber attempts: %w", err)
+	}
+	return nil
+}
+
+// mergeCampaignRecipients keeps one recipient row per campaign: the one that was sent, else
+// the kept student's, with the opens and clicks of both
+func mergeCampaignRecipients(ctx context.Context, tx pgx.Tx, keepID, mergeID int, moved map[string]int64) error {
+	combineQuery := `
+		UPDATE email_campaign_recipients r
+		SET opened_at = LEAST(k.opened_at, m.opened_at), open_count = k.open_count + m.open_count,
+		    clicked_at = LEAST(k.clicked_at, m.clicked_at), click_count = k.click_count + m.click_count
+		FROM email_campaign_recipients k
+		JOIN email_campaign_recipients m ON m.campaign_id = k.campaign_id AND m.student_id = $2
+		WHERE k.student_id = $1 AND r.id IN (k.id, m.id)
+	`
+	if _, err := tx.Exec(ctx, combineQuery, keepID, mergeID); err != nil {
+		return fmt.Errorf("failed to combine campaign recipients: %w", err)
+	}
+	sentQuery := `
+		DELETE FROM email_campaign_recipients k
+		USING email_campaign_recipients m
+		WHERE k.student_id = $1 AND m.student_id = $2 AND k.campaign_id = m.campaign_id
+		  AND m.status = 'sent' AND k.status <> 'sent'
+	`
+	if _, err := tx.Exec(ctx, sentQuery, keepID, mergeID); err != nil {
+		return fmt.Errorf("failed to combine campaign recipients: %w", err)
+	}
+	restQuery := `
+		DELETE FROM email_campaign_recipients m
+		USING email_campaign_recipients k
+		WHERE m.student_id = $2 AND k.student_id = $1 AND k.campaign_id = m.campaign_id
+	`
+	if _, err := tx.Exec(ctx, restQuery, keepID, mergeID); err != nil {
+		return fmt.Errorf("failed to combine campaign recipients: %w", err)
+	}
+	tag, err := tx.Exec(ctx, `UPDATE email_campaign_recipients SET student_id = $1, updated_at = NOW() WHERE student_id = $2`, keepID, mergeID)
+	if err != nil {
+		return fmt.Errorf("failed to move campaign recipients: %w", err)
+	}
+	moved["email_campaign_recipients"] = tag.RowsAffected()
+	return nil
+}
+
+// mergeResultTokens keeps the kept student's result link token, else takes the merged one's
+func mergeResultTokens(ctx context.Context, tx pgx.Tx, keepID, mergeID int, moved map[string]int64) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM result_tokens WHERE student_id = $2 AND EXISTS (SELECT 1 FROM result_tokens WHERE student_id = $1)`, keepID, mergeID); err != nil {
+		return fmt.Errorf("failed to combine result tokens: %w", err)
+	}
+	tag, err := tx.Exec(ctx, `UPDATE result_tokens SET student_id = $1 WHERE student_id = $2`, keepID, mergeID)
+	if err != nil {
+		return fmt.Errorf("failed to move result tokens: %w", err)
+	}
+	moved["result_tokens"] = tag.RowsAffected()
+	return nil
+}
+
+// List returns the recorded merges, newest first; studentID, when not zero, limits them to
+// merges that kept or removed that student
+func List(ctx context.Context, studentID, limit int) ([]Merge, error) {
+	query := `
+		SELECT ` + mergeColumns + `
+		FROM student_merges
+		WHERE $1 = 0 OR kept_student_id = $1 OR merged_student_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+	rows, err := db.Pool.Query(ctx, query, studentID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch merges: %w", err)
+	}
+	defer rows.Close()
+
+	merges := []Merge{}
+	for rows.Next() {
+		var m Merge
+		if err := scanMerge(rows, &m); err != nil {
+			return nil, fmt.Errorf("failed to scan merge: %w", err)
+		}
+		merges = append(merges, m)
+	}
+	return merges, rows.Err()
+}
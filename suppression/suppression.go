@@ -0,0 +1,196 @@
+package suppression
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Bounce types stored in email_bounces
+const (
+	BounceHard = "hard"
+	BounceSoft = "soft"
+)
+
+// Reasons stored in suppressed_emails
+const (
+	ReasonHardBounce = "hard_bounce"
+	ReasonSoftBounce = "soft_bounce"
+	ReasonManual     = "manual"
+)
+
+// ErrSuppressed is returned by send helpers when the recipient is on the suppression list
+var ErrSuppressed = errors.New("recipient is on the suppression list")
+
+var (
+	// enhancedStatusPattern matches RFC 3463 status codes such as 5.1.1 or 4.2.2
+	enhancedStatusPattern = regexp.MustCompile(`\b([45])\.\d{1,3}\.\d{1,3}\b`)
+	// replyCodePattern matches plain SMTP reply codes such as 550 or 452
+	replyCodePattern = regexp.MustCompile(`\b([45])\d\d\b`)
+
+	// Checked before the hard hints: a full mailbox often comes back as a 5xx
+	softHints = []string{"mailbox full", "over quota", "quota exceeded", "insufficient storage",
+		"temporar", "try again", "rate limit", "too many", "greylist", "deferred", "timeout", "timed out"}
+	hardHints = []string{"user unknown", "unknown user", "unknown recipient", "no such user", "does not exist",
+		"invalid recipient", "invalid address", "address rejected", "recipient rejected", "mailbox unavailable",
+		"mailbox not found", "no mx", "domain not found", "host not found"}
+
+	softLimitOnce sync.Once
+	softLimit     int
+)
+
+// Normalize lowercases and trims an address so lookups are case-insensitive
+func Normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Classify decides whether a bounce is hard or soft. ZeptoMail names the event
+// (hardbounce/softbounce); otherwise the reason and diagnostic text are inspected.
+// Anything unrecognised is treated as soft so one odd bounce never blocks an address.
+func Classify(eventNames []string, reason, diagnostic string) string {
+	for _, name := range eventNames {
+		switch strings.ToLower(strings.ReplaceAll(name, "_", "")) {
+		case "hardbounce":
+			return BounceHard
+		case "softbounce":
+			return BounceSoft
+		}
+	}
+
+	text := strings.ToLower(reason + " " + diagnostic)
+	for _, hint := range softHints {
+		if strings.Contains(text, hint) {
+			return BounceSoft
+		}
+	}
+	for _, hint := range hardHints {
+		if strings.Contains(text, hint) {
+			return BounceHard
+		}
+	}
+
+	match := enhancedStatusPattern.FindStringSubmatch(text)
+	if match == nil {
+		match = replyCodePattern.FindStringSubmatch(text)
+	}
+	if match != nil && match[1] == "5" {
+		return BounceHard
+	}
+	return BounceSoft
+}
+
+// SoftBounceLimit is the number of soft bounces (SOFT_BOUNCE_LIMIT, default 3) after which
+// an address is suppressed
+func SoftBounceLimit() int {
+	softLimitOnce.Do(func() {
+		softLimit = 3
+		if value := os.Getenv("SOFT_BOUNCE_LIMIT"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				log.Warn().Msgf("Invalid SOFT_BOUNCE_LIMIT=%q, using %d", value, softLimit)
+				return
+			}
+			softLimit = parsed
+		}
+	})
+	return softLimit
+}
+
+// RecordBounce stores a bounce and suppresses the address on a hard bounce, or once it
+// has soft-bounced SoftBounceLimit times
+func RecordBounce(ctx context.Context, email, bounceType, requestID, reason string) error {
+	email = Normalize(email)
+	if email == "" {
+		return nil
+	}
+
+	query := `
+		INSERT INTO email_bounces (email, bounce_type, request_id, reason)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''))
+	`
+	if _, err := db.Pool.Exec(ctx, query, email, bounceType, requestID, reason); err != nil {
+		return fmt.Errorf("failed to store bounce: %w", err)
+	}
+
+	if bounceType == BounceHard {
+		_, err := Add(ctx, email, ReasonHardBounce, reason)
+		return err
+	}
+
+	var softCount int
+	countQuery := `SELECT COUNT(*) FROM email_bounces WHERE email = $1 AND bounce_type = $2`
+	if err := db.Pool.QueryRow(ctx, countQuery, email, BounceSoft).Scan(&softCount); err != nil {
+		return fmt.Errorf("failed to count soft bounces: %w", err)
+	}
+	if softCount < SoftBounceLimit() {
+		return nil
+	}
+
+	_, err := Add(ctx, email, ReasonSoftBounce, fmt.Sprintf("%d soft bounces, last: %s", softCount, reason))
+	return err
+}
+
+// Add puts an address on the suppression list. It reports false if it was already there.
+func Add(ctx context.Context, email, reason, details string) (bool, error) {
+	query := `
+		INSERT INTO suppressed_emails (email, reason, details)
+		VALUES ($1, $2, NULLIF($3, ''))
+		ON CONFLICT (email) DO NOTHING
+	`
+	tag, err := db.Pool.Exec(ctx, query, Normalize(email), reason, details)
+	if err != nil {
+		return false, fmt.Errorf("failed to suppress email: %w", err)
+	}
+	if tag.RowsAffected() > 0 {
+		log.Info().Str("email", Normalize(email)).Str("reason", reason).Msg("Email suppressed")
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Remove takes an address off the suppression list and clears its bounce history, so old
+// soft bounces do not immediately suppress it again. It reports false if it was not listed.
+func Remove(ctx context.Context, email string) (bool, error) {
+	email = Normalize(email)
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `DELETE FROM suppressed_emails WHERE email = $1`, email)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove suppression: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM email_bounces WHERE email = $1`, email); err != nil {
+		return false, fmt.Errorf("failed to clear bounces: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit: %w", err)
+	}
+	return true, nil
+}
+
+// IsSuppressed reports whether sends to the address should be skipped. Lookup failures
+// are logged and treated as not suppressed so a database hiccup never stops a campaign.
+func IsSuppressed(ctx context.Context, email string) bool {
+	var suppressed bool
+	query := `SELECT EXISTS(SELECT 1 FROM suppressed_emails WHERE email = $1)`
+	if err := db.Pool.QueryRow(ctx, query, Normalize(email)).Scan(&suppressed); err != nil {
+		log.Error().Err(err).Str("email", email).Msg("Failed to check suppression")
+		return false
+	}
+	return suppressed
+}
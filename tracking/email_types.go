@@ -0,0 +1,36 @@
+package tracking
+
+import "strings"
+
+// EmailType names an email in email_tracking, email_events and email_links: one of the
+// constants below or a campaign's "campaign-N". Any other type works too; its sends are
+// tracked under a system campaign created on first use. SQL that filters on a fixed type
+// uses the constant's value as a literal, e.g. email_type = 'firstMail'.
+type EmailType string
+
+const (
+	// FirstMail is the conference invitation carrying the conference token
+	FirstMail EmailType = "firstMail"
+	// SecondMail is the test invitation carrying the access code
+	SecondMail EmailType = "secondMail"
+	// Broadcast is an email sent to every student through POST /api/mail/send-all
+	Broadcast EmailType = "broadcast"
+)
+
+// legacyEmailTypes are the names the open pixel and the original scheduler functions used
+// before they were unified with the live flow. Stored rows were rewritten by migration
+// 000042; the mapping keeps pixels in emails already delivered and old API input working.
+var legacyEmailTypes = map[string]EmailType{
+	"first":  FirstMail,
+	"second": SecondMail,
+}
+
+// ParseEmailType maps an email type from a request, a pixel URL or a stored row to its
+// canonical name. Legacy names are matched ignoring case; any other type is kept as given.
+func ParseEmailType(name string) EmailType {
+	name = strings.TrimSpace(name)
+	if canonical, legacy := legacyEmailTypes[strings.ToLower(name)]; legacy {
+		return canonical
+	}
+	return EmailType(name)
+}
@@ -0,0 +1,130 @@
+package tracking
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mcq-exam/db"
+	"strings"
+	"time"
+)
+
+// Event types reported by the email provider's webhook, stored in email_events with the
+// provider as source. Opens and clicks share the tracking event types.
+const (
+	EventDelivered = "delivered"
+	EventSpam      = "spam"
+	EventBounce    = "bounce"
+)
+
+// email_logs statuses set from provider events. A log only moves forward through
+// sent → delivered → opened → clicked; spam and bounced override all of those.
+const (
+	LogStatusDelivered = "delivered"
+	LogStatusOpened    = "opened"
+	LogStatusClicked   = "clicked"
+	LogStatusSpam      = "spam"
+	LogStatusBounced   = "bounced"
+)
+
+// logStatusOrder ranks email_logs statuses; a provider event never moves a log back.
+// Statuses outside the list (e.g. "failed", a send error) are left alone.
+var logStatusOrder = []string{"sent", LogStatusDelivered, LogStatusOpened, LogStatusClicked, LogStatusSpam, LogStatusBounced}
+
+// logStatusByEvent is the email_logs status each provider event leads to
+var logStatusByEvent = map[string]string{
+	EventDelivered: LogStatusDelivered,
+	EventOpen:      LogStatusOpened,
+	EventClick:     LogStatusClicked,
+	EventSpam:      LogStatusSpam,
+	EventBounce:    LogStatusBounced,
+}
+
+// zeptoEventTypes maps ZeptoMail event names, lowercased without underscores, to event types
+var zeptoEventTypes = map[string]string{
+	"delivered":      EventDelivered,
+	"emaildelivered": EventDelivered,
+	"delivery":       EventDelivered,
+	"emailopen":      EventOpen,
+	"open":           EventOpen,
+	"opened":         EventOpen,
+	"emaillinkclick": EventClick,
+	"linkclick":      EventClick,
+	"click":          EventClick,
+	"clicked":        EventClick,
+	"spam":           EventSpam,
+	"emailspam":      EventSpam,
+	"feedbackloop":   EventSpam,
+	"complaint":      EventSpam,
+	"hardbounce":     EventBounce,
+	"softbounce":     EventBounce,
+	"bounce":         EventBounce,
+}
+
+// ZeptoMailEventType returns the event type for ZeptoMail event names, or "" if none is known
+func ZeptoMailEventType(eventNames []string) string {
+	for _, name := range eventNames {
+		if eventType, ok := zeptoEventTypes[strings.ToLower(strings.ReplaceAll(name, "_", ""))]; ok {
+			return eventType
+		}
+	}
+	return ""
+}
+
+// ProviderEvent is one delivery event for one recipient of a sent message
+type ProviderEvent struct {
+	Source    string
+	EventType string
+	RequestID string
+	Email     string
+	// OccurredAt is the provider's event time; zero means now
+	OccurredAt time.Time
+	// Details is the provider's payload for the event, stored as is
+	Details json.RawMessage
+}
+
+// RecordProviderEvent stores a provider event in email_events, linked to the student the
+// message was logged for, and moves the message's email_logs status forward. Reports false
+// if the event was a duplicate delivery and nothing changed.
+func RecordProviderEvent(ctx context.Context, event ProviderEvent) (bool, error) {
+	occurredAt := event.OccurredAt
+	if occurredAt.IsZero() {
+		occurredAt = time.Now()
+	}
+	var details *string
+	if len(event.Details) > 0 && json.Valid(event.Details) {
+		value := string(event.Details)
+		details = &value
+	}
+
+	insertQuery := `
+		INSERT INTO email_events (student_id, event_type, source, request_id, email, details, created_at)
+		SELECT (SELECT student_id FROM email_logs
+		        WHERE request_id = $3 AND ($4 = '' OR LOWER(email) = LOWER($4))
+		        ORDER BY id DESC LIMIT 1),
+		       $1, $2, $3, NULLIF($4, ''), $5::jsonb, $6
+		ON CONFLICT (request_id, event_type, email, created_at) WHERE request_id IS NOT NULL DO NOTHING
+	`
+	tag, err := db.Pool.Exec(ctx, insertQuery, event.EventType, event.Source, event.RequestID, event.Email, details, occurredAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to record %s event: %w", event.EventType, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	status, ok := logStatusByEvent[event.EventType]
+	if !ok {
+		return true, nil
+	}
+	updateQuery := `
+		UPDATE email_logs
+		SET status = $2
+		WHERE request_id = $1 AND ($4 = '' OR LOWER(email) = LOWER($4))
+		  AND array_position($3::text[], status::text) < array_position($3::text[], $2::text)
+	`
+	if _, err := db.Pool.Exec(ctx, updateQuery, event.RequestID, status, logStatusOrder, event.Email); err != nil {
+		return true, fmt.Errorf("failed to update email log status: %w", err)
+	}
+	return true, nil
+}
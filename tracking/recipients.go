@@ -0,0 +1,117 @@
+package tracking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mcq-exam/db"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// campaignTypePattern matches the email type of a campaign's own emails, "campaign-N"
+var campaignTypePattern = regexp.MustCompile(`^campaign-([0-9]+)$`)
+
+// systemCampaigns caches the id of each email type's system campaign
+var systemCampaigns sync.Map
+
+// campaignFor returns the campaign an email type is tracked under: the campaign itself for
+// "campaign-N", otherwise the type's system campaign, created when create is true. It
+// returns 0 when there is none, e.g. for an open of a type that was never sent.
+func campaignFor(ctx context.Context, emailType EmailType, create bool) (int, error) {
+	if match := campaignTypePattern.FindStringSubmatch(string(emailType)); match != nil {
+		id, err := strconv.Atoi(match[1])
+		if err != nil {
+			return 0, nil
+		}
+		var exists bool
+		if err := db.Pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM email_campaigns WHERE id = $1 AND email_type IS NULL)`, id).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, nil
+		}
+		return id, nil
+	}
+
+	if id, ok := systemCampaigns.Load(emailType); ok {
+		return id.(int), nil
+	}
+	if create {
+		query := `
+			INSERT INTO email_campaigns (name, kind, subject, html_body, segment, status, email_type, started_at)
+			VALUES ($1, 'system', '', '', 'all', 'tracking', $1, NOW())
+			ON CONFLICT (email_type) WHERE email_type IS NOT NULL DO NOTHING
+		`
+		if _, err := db.Pool.Exec(ctx, query, string(emailType)); err != nil {
+			return 0, err
+		}
+	}
+	var id int
+	err := db.Pool.QueryRow(ctx, `SELECT id FROM email_campaigns WHERE email_type = $1`, string(emailType)).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	systemCampaigns.Store(emailType, id)
+	return id, nil
+}
+
+// SystemCampaign returns the id of the system campaign an email type outside campaigns is
+// tracked under, creating it on first use
+func SystemCampaign(ctx context.Context, emailType EmailType) (int, error) {
+	return campaignFor(ctx, emailType, true)
+}
+
+// recordRecipient updates the student's row in the campaign the email type is tracked
+// under. A send of a system campaign's type adds or re-marks the row as sent, keeping its
+// token; a campaign's own sends are recorded by its sender. Opens and clicks stamp the first
+// time and are counted, on rows that exist only, so a forged pixel creates nothing.
+func recordRecipient(ctx context.Context, studentID int, emailType EmailType, eventType string) error {
+	system := !campaignTypePattern.MatchString(string(emailType))
+	if eventType == EventSent && !system {
+		return nil
+	}
+	campaignID, err := campaignFor(ctx, emailType, eventType == EventSent)
+	if err != nil || campaignID == 0 {
+		return err
+	}
+
+	var query string
+	switch eventType {
+	case EventSent:
+		query = `
+			INSERT INTO email_campaign_recipients (campaign_id, student_id, email, status, sent_at)
+			SELECT $1, s.id, s.email, 'sent', NOW()
+			FROM students s
+			WHERE s.id = $2
+			ON CONFLICT (campaign_id, student_id)
+			DO UPDATE SET email = EXCLUDED.email, status = 'sent', error = NULL, sent_at = NOW(), updated_at = NOW()
+		`
+	case EventOpen:
+		query = `
+			UPDATE email_campaign_recipients
+			SET opened_at = COALESCE(opened_at, NOW()), open_count = open_count + 1, updated_at = NOW()
+			WHERE campaign_id = $1 AND student_id = $2
+		`
+	case EventClick:
+		query = `
+			UPDATE email_campaign_recipients
+			SET clicked_at = COALESCE(clicked_at, NOW()), click_count = click_count + 1, updated_at = NOW()
+			WHERE campaign_id = $1 AND student_id = $2
+		`
+	default:
+		return nil
+	}
+	if _, err := db.Pool.Exec(ctx, query, campaignID, studentID); err != nil {
+		// The cached campaign may be gone after a database reset
+		systemCampaigns.Delete(emailType)
+		return fmt.Errorf("failed to update campaign %d recipient: %w", campaignID, err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package tracking
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mcq-exam/config"
+	"mcq-exam/db"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Event types stored in email_events
+const (
+	EventSent  = "sent"
+	EventOpen  = "open"
+	EventClick = "click"
+)
+
+// Paths of the tracking endpoints in instrumented emails
+const (
+	openPath  = "/api/track-open"
+	clickPath = "/api/track-click"
+)
+
+var (
+	// hrefPattern matches double-quoted http(s) href attributes
+	hrefPattern = regexp.MustCompile(`(?i)href\s*=\s*"(https?://[^"]+)"`)
+	// bodyEndPattern matches the closing body tag the open pixel goes before
+	bodyEndPattern = regexp.MustCompile(`(?i)</body\s*>`)
+)
+
+// Target is the student and email type an outgoing email is tracked for. Set on
+// utils.SendEmailParams, the send pipeline instruments the body with Instrument.
+type Target struct {
+	StudentID int
+	EmailType EmailType
+}
+
+// baseURL is the public API URL used in tracked links, e.g. https://api.smart-mcq.com
+func baseURL() string {
+	return config.Get().BaseURL
+}
+
+// Instrument prepares an outgoing email for tracking: every http(s) link is rewritten to
+// go through /api/track-click and an open pixel carrying the student and email type (for a
+// campaign, "campaign-N") goes before </body>, or at the end without one. Links and pixels
+// already tracked, e.g. embedded by hand, are left alone. If BASE_URL is unset or the links
+// cannot be stored, the original HTML is returned so the email still goes out.
+func Instrument(studentID int, emailType EmailType, html string) string {
+	base := baseURL()
+	if base == "" {
+		return html
+	}
+
+	matches := hrefPattern.FindAllStringSubmatch(html, -1)
+	cids := make([]string, 0, len(matches))
+	urls := make([]string, 0, len(matches))
+	cidByURL := make(map[string]string)
+	for _, match := range matches {
+		target := match[1]
+		if _, exists := cidByURL[target]; exists || strings.Contains(target, clickPath) {
+			continue
+		}
+		cid, err := newCID()
+		if err != nil {
+			return html
+		}
+		cidByURL[target] = cid
+		cids = append(cids, cid)
+		urls = append(urls, target)
+	}
+
+	if len(cids) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		query := `
+			INSERT INTO email_links (cid, student_id, email_type, url)
+			SELECT cid, $3, $4, url
+			FROM unnest($1::text[], $2::text[]) AS l(cid, url)
+		`
+		if _, err := db.Pool.Exec(ctx, query, cids, urls, studentID, string(emailType)); err != nil {
+			log.Error().Err(err).Int("student_id", studentID).Msg("Failed to store tracked links")
+			return html
+		}
+
+		html = hrefPattern.ReplaceAllStringFunc(html, func(attr string) string {
+			cid, tracked := cidByURL[hrefPattern.FindStringSubmatch(attr)[1]]
+			if !tracked {
+				return attr
+			}
+			return fmt.Sprintf(`href="%s%s?cid=%s"`, base, clickPath, cid)
+		})
+	}
+
+	if strings.Contains(html, openPath) {
+		return html
+	}
+	pixel := fmt.Sprintf(`<img src="%s%s?student_id=%d&type=%s" width="1" height="1" alt="" style="display:none;">`,
+		base, openPath, studentID, url.QueryEscape(string(emailType)))
+	if loc := bodyEndPattern.FindAllStringIndex(html, -1); len(loc) > 0 {
+		end := loc[len(loc)-1][0]
+		return html[:end] + pixel + html[end:]
+	}
+	return html + pixel
+}
+
+// RecordSent logs a successful send, marking the student as a recipient of the email type
+// and, outside campaigns, of the type's system campaign
+func RecordSent(studentID int, emailType EmailType) {
+	RecordEvent(context.Background(), studentID, emailType, EventSent, nil, "", "")
+}
+
+// RecordEvent inserts a row into email_events and updates the student's campaign recipient
+// row for the email type (see recordRecipient). Failures are logged, never returned:
+// tracking must not break sending or redirects. The queries are bounded by ctx, e.g. the
+// request recording the event.
+func RecordEvent(ctx context.Context, studentID int, emailType EmailType, eventType string, linkID *int, ip, userAgent string) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO email_events (student_id, email_type, event_type, link_id, ip_address, user_agent)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''))
+	`
+	if _, err := db.Pool.Exec(ctx, query, studentID, string(emailType), eventType, linkID, ip, userAgent); err != nil {
+		log.Error().Err(err).Int("student_id", studentID).Str("event_type", eventType).Msg("Failed to record email event")
+	}
+	if err := recordRecipient(ctx, studentID, emailType, eventType); err != nil {
+		log.Error().Err(err).Int("student_id", studentID).Str("email_type", string(emailType)).Str("event_type", eventType).
+			Msg("Failed to update campaign recipient")
+	}
+}
+
+func newCID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
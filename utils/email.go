@@ -1,116 +1,153 @@
 package utils
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"mcq-exam/metrics"
+	"mcq-exam/tracking"
 	"os"
-	"time"
-)
-
-const ZeptoMailURL = "https://api.zeptomail.in/v1.1/email"
-
-type EmailRecipient struct {
-	Address string `json:"address"`
-	Name    string `json:"name,omitempty"`
-}
+	"strings"
+	"sync"
 
-type EmailRequest struct {
-	From struct {
-		Address string `json:"address"`
-		Name    string `json:"name,omitempty"`
-	} `json:"from"`
-	To []struct {
-		EmailAddress EmailRecipient `json:"email_address"`
-	} `json:"to"`
-	Subject  string `json:"subject"`
-	HTMLBody string `json:"htmlbody"`
-}
+	"github.com/rs/zerolog/log"
+)
 
 type SendEmailParams struct {
-	ToEmail   string
-	ToName    string
-	Subject   string
-	HTMLBody  string
+	ToEmail  string
+	ToName   string
+	Subject  string
+	HTMLBody string
+	// Attachments are files and inline images; see Attachment
+	Attachments []Attachment
+	// Tracking, when set, adds the open pixel and click tracking to HTMLBody before it is
+	// sent (see tracking.Instrument); leave it nil for emails not sent to a student
+	Tracking *tracking.Target
 }
 
-type ZeptoMailResponse struct {
-	Data []struct {
-		Code           string   `json:"code"`
-		AdditionalInfo []string `json:"additional_info"`
-		Message        string   `json:"message"`
-	} `json:"data"`
-	Message   string `json:"message"`
+// EmailResult is a provider-neutral view of a send response, in the shape email_logs stores
+type EmailResult struct {
+	// Provider that accepted the email (after any fallback)
+	Provider  string `json:"provider"`
 	RequestID string `json:"request_id"`
-	Object    string `json:"object"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	// Raw is the provider's own response, kept for debugging
+	Raw json.RawMessage `json:"raw,omitempty"`
 }
 
-// SendEmail sends email via ZeptoMail API and returns the response
-func SendEmail(params SendEmailParams) (*ZeptoMailResponse, error) {
-	apiKey := os.Getenv("ZEPTO_API_KEY")
-	fromEmail := os.Getenv("ZEPTO_FROM_EMAIL")
-	fromName := os.Getenv("ZEPTO_FROM_NAME")
+// EmailProvider sends a single email through one delivery service
+type EmailProvider interface {
+	Name() string
+	Send(params SendEmailParams) (*EmailResult, error)
+	// AttachmentLimit is the most attachment bytes, before encoding, one email may carry
+	AttachmentLimit() int
+}
+
+var (
+	providersOnce    sync.Once
+	primaryProvider  EmailProvider
+	fallbackProvider EmailProvider
+	providerErr      error
+	// emailLimiters throttles each provider, keyed by name; nil entries are unthrottled
+	emailLimiters = make(map[string]*tokenBucket)
+)
 
-	if apiKey == "" || fromEmail == "" {
-		return nil, fmt.Errorf("ZeptoMail configuration missing in environment")
+// newEmailProvider builds a provider by name from its environment configuration
+func newEmailProvider(name string) (EmailProvider, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "zeptomail", "zepto":
+		return NewZeptoMailProvider()
+	case "smtp":
+		return NewSMTPProvider()
+	case "ses":
+		return NewSESProvider()
+	default:
+		return nil, fmt.Errorf("unknown email provider %q (expected zeptomail, smtp or ses)", name)
 	}
+}
 
-	// Construct request body
-	emailReq := EmailRequest{
-		Subject:  params.Subject,
-		HTMLBody: params.HTMLBody,
+// loadEmailProviders reads EMAIL_PROVIDER (default zeptomail) and the optional EMAIL_FALLBACK_PROVIDER
+func loadEmailProviders() {
+	primaryProvider, providerErr = newEmailProvider(os.Getenv("EMAIL_PROVIDER"))
+	if providerErr != nil {
+		log.Error().Err(providerErr).Msg("Email provider not configured")
+		return
 	}
-	emailReq.From.Address = fromEmail
-	emailReq.From.Name = fromName
-	emailReq.To = []struct {
-		EmailAddress EmailRecipient `json:"email_address"`
-	}{
-		{
-			EmailAddress: EmailRecipient{
-				Address: params.ToEmail,
-				Name:    params.ToName,
-			},
-		},
+	log.Info().Str("provider", primaryProvider.Name()).Msg("Email provider configured")
+	emailLimiters[primaryProvider.Name()] = newEmailRateLimiter(primaryProvider.Name())
+
+	if name := os.Getenv("EMAIL_FALLBACK_PROVIDER"); name != "" {
+		fallback, err := newEmailProvider(name)
+		if err != nil {
+			log.Warn().Err(err).Msg("Fallback email provider disabled")
+			return
+		}
+		fallbackProvider = fallback
+		log.Info().Str("provider", fallbackProvider.Name()).Msg("Fallback email provider configured")
+		if _, shared := emailLimiters[fallbackProvider.Name()]; !shared {
+			emailLimiters[fallbackProvider.Name()] = newEmailRateLimiter(fallbackProvider.Name())
+		}
 	}
+}
 
-	// Marshal to JSON
-	jsonData, err := json.Marshal(emailReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal email request: %w", err)
+// EmailProviderStatus returns the configured primary and fallback provider names ("" when
+// there is no fallback), or the error that keeps the primary from sending
+func EmailProviderStatus() (primary, fallback string, err error) {
+	providersOnce.Do(loadEmailProviders)
+	if providerErr != nil {
+		return "", "", providerErr
+	}
+	if fallbackProvider != nil {
+		fallback = fallbackProvider.Name()
 	}
+	return primaryProvider.Name(), fallback, nil
+}
 
-	// Create HTTP request
-	req, err := http.NewRequest("POST", ZeptoMailURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// SendEmail sends an email through the configured provider, retrying once through
+// the fallback provider (if configured) when the primary fails. Each provider's rate
+// limit is shared by all senders; ctx bounds only the wait for it. Malformed attachments
+// fail with ErrInvalidAttachment; attachments over a provider's limit fail that provider
+// with ErrAttachmentsTooLarge, so a fallback with a higher limit may still send.
+func SendEmail(ctx context.Context, params SendEmailParams) (*EmailResult, error) {
+	providersOnce.Do(loadEmailProviders)
+	if providerErr != nil {
+		metrics.RecordEmailSend(providerErr)
+		return nil, providerErr
+	}
+	if err := validateAttachments(params.Attachments); err != nil {
+		return nil, err
+	}
+	// Instrumented once, so the primary and the fallback send the same links
+	if params.Tracking != nil {
+		params.HTMLBody = tracking.Instrument(params.Tracking.StudentID, params.Tracking.EmailType, params.HTMLBody)
 	}
 
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", apiKey)
+	result, err := sendVia(ctx, primaryProvider, params)
+	if err == nil || fallbackProvider == nil {
+		return result, err
+	}
 
-	// Send request
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send email: %w", err)
+	log.Warn().Err(err).Str("email", params.ToEmail).Str("provider", primaryProvider.Name()).Str("fallback", fallbackProvider.Name()).
+		Msg("Email failed, trying fallback provider")
+	result, fallbackErr := sendVia(ctx, fallbackProvider, params)
+	if fallbackErr != nil {
+		return nil, fmt.Errorf("%s: %v; %s: %w", primaryProvider.Name(), err, fallbackProvider.Name(), fallbackErr)
 	}
-	defer resp.Body.Close()
 
-	// Read response
-	body, _ := io.ReadAll(resp.Body)
+	return result, nil
+}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("email send failed with status %d: %s", resp.StatusCode, string(body))
+// sendVia sends through one provider once its rate limit allows. Attachments over the
+// provider's limit are refused without calling it and are not counted as a send.
+func sendVia(ctx context.Context, provider EmailProvider, params SendEmailParams) (*EmailResult, error) {
+	if err := checkAttachmentLimit(provider, params.Attachments); err != nil {
+		return nil, err
 	}
-
-	// Parse ZeptoMail response
-	var zeptoResp ZeptoMailResponse
-	if err := json.Unmarshal(body, &zeptoResp); err != nil {
-		return nil, fmt.Errorf("failed to parse ZeptoMail response: %w", err)
+	if err := throttle(ctx, provider); err != nil {
+		return nil, err
 	}
-
-	return &zeptoResp, nil
+	result, err := provider.Send(params)
+	metrics.RecordEmailSend(err)
+	return result, err
 }
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+)
+
+// maxAttachments bounds the files, inline images included, sent with one email
+const maxAttachments = 10
+
+// Per-provider limits on the attachments of one email, counted before base64 encoding.
+// Encoding adds a third, so these stay below each service's message size limit.
+const (
+	// ZeptoMail accepts messages up to 15 MB
+	zeptoAttachmentLimit = 10 << 20
+	// SES v2 accepts messages up to 40 MB
+	sesAttachmentLimit = 28 << 20
+	// Relays commonly refuse messages over 20 to 25 MB
+	smtpAttachmentLimit = 14 << 20
+)
+
+var (
+	// ErrInvalidAttachment is wrapped by SendEmail when an attachment is malformed
+	ErrInvalidAttachment = errors.New("invalid attachment")
+	// ErrAttachmentsTooLarge is wrapped by SendEmail when the attachments exceed the
+	// provider's limit
+	ErrAttachmentsTooLarge = errors.New("attachments too large")
+)
+
+// contentIDPattern is what a cid: reference in HTML can name without escaping
+var contentIDPattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,100}$`)
+
+// Attachment is a file sent with an email. An attachment with a ContentID is an inline
+// image, shown where the HTML body has <img src="cid:ContentID">, rather than a download.
+type Attachment struct {
+	Name      string
+	MimeType  string
+	ContentID string
+	Data      []byte
+}
+
+// Inline reports whether the attachment is an image embedded in the body
+func (a Attachment) Inline() bool {
+	return a.ContentID != ""
+}
+
+// validateAttachments checks what every provider needs: a plain file name, a MIME type,
+// content, image types for inline images and unique content IDs
+func validateAttachments(attachments []Attachment) error {
+	if len(attachments) > maxAttachments {
+		return fmt.Errorf("%w: %d attachments; at most %d are allowed", ErrInvalidAttachment, len(attachments), maxAttachments)
+	}
+	contentIDs := make(map[string]bool)
+	for i, attachment := range attachments {
+		label := fmt.Sprintf("attachment %d", i+1)
+		name := attachment.Name
+		if name == "" || len(name) > 255 || strings.ContainsAny(name, "/\\\"\r\n") {
+			return fmt.Errorf("%w: %s needs a file name without slashes, quotes or line breaks", ErrInvalidAttachment, label)
+		}
+		mediaType, _, err := mime.ParseMediaType(attachment.MimeType)
+		if err != nil || !strings.Contains(mediaType, "/") {
+			return fmt.Errorf("%w: %s (%s) has an invalid MIME type %q", ErrInvalidAttachment, label, name, attachment.MimeType)
+		}
+		if len(attachment.Data) == 0 {
+			return fmt.Errorf("%w: %s (%s) is empty", ErrInvalidAttachment, label, name)
+		}
+		if !attachment.Inline() {
+			continue
+		}
+		if !strings.HasPrefix(mediaType, "image/") {
+			return fmt.Errorf("%w: %s (%s) is inline but not an image", ErrInvalidAttachment, label, name)
+		}
+		if !contentIDPattern.MatchString(attachment.ContentID) {
+			return fmt.Errorf("%w: %s (%s) content ID may only use letters, digits and . _ @ -", ErrInvalidAttachment, label, name)
+		}
+		if contentIDs[attachment.ContentID] {
+			return fmt.Errorf("%w: content ID %q is used twice", ErrInvalidAttachment, attachment.ContentID)
+		}
+		contentIDs[attachment.ContentID] = true
+	}
+	return nil
+}
+
+// checkAttachmentLimit rejects attachments the provider would refuse, before it is called
+func checkAttachmentLimit(provider EmailProvider, attachments []Attachment) error {
+	size := 0
+	for _, attachment := range attachments {
+		size += len(attachment.Data)
+	}
+	if limit := provider.AttachmentLimit(); size > limit {
+		return fmt.Errorf("%w: %d bytes exceed the %s limit of %d bytes", ErrAttachmentsTooLarge, size, provider.Name(), limit)
+	}
+	return nil
+}
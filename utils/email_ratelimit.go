@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"context"
+	"math"
+	"mcq-exam/metrics"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// emailRateDefaults are each provider's sending limit ("<max>/<window>") and the env
+// prefix that overrides it through <PREFIX>_RATE_LIMIT and <PREFIX>_RATE_BURST
+var emailRateDefaults = map[string]struct {
+	envPrefix string
+	limit     string
+}{
+	"zeptomail": {"ZEPTO", "10/1s"},
+	"smtp":      {"SMTP", "5/1s"},
+	"ses":       {"SES", "14/1s"},
+}
+
+// defaultEmailBurst is how many emails go out back to back before throttling starts,
+// so single emails and small sends never wait
+const defaultEmailBurst = 20
+
+// tokenBucket is a rate limiter shared by every sender using one provider. It refills
+// at rate tokens per second up to burst; each email takes one token.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token and returns how long the caller must wait before using it.
+// Tokens may go negative, which queues concurrent callers behind each other.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns a reserved token that was not used
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+}
+
+// Wait blocks until the caller may send, or ctx is done. It returns the time spent waiting.
+func (b *tokenBucket) Wait(ctx context.Context) (time.Duration, error) {
+	delay := b.reserve()
+	if delay == 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		b.cancel()
+		return 0, ctx.Err()
+	}
+}
+
+// newEmailRateLimiter builds the limiter for a provider from <PREFIX>_RATE_LIMIT (e.g. "10/1s")
+// and <PREFIX>_RATE_BURST (default 20). Returns nil, meaning unthrottled, for "off" or "0".
+func newEmailRateLimiter(provider string) *tokenBucket {
+	defaults, known := emailRateDefaults[provider]
+	if !known {
+		return nil
+	}
+
+	limitVar := defaults.envPrefix + "_RATE_LIMIT"
+	value := strings.TrimSpace(os.Getenv(limitVar))
+	if value == "" {
+		value = defaults.limit
+	}
+	if value == "off" || value == "0" {
+		log.Info().Str("provider", provider).Msg("Email rate limit disabled")
+		return nil
+	}
+	max, window, ok := parseEmailRate(value)
+	if !ok {
+		log.Warn().Msgf("Invalid %s=%q (expected <max>/<window>), using %s", limitVar, value, defaults.limit)
+		max, window, _ = parseEmailRate(defaults.limit)
+	}
+
+	burstVar := defaults.envPrefix + "_RATE_BURST"
+	burst := defaultEmailBurst
+	if value := strings.TrimSpace(os.Getenv(burstVar)); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			log.Warn().Msgf("Invalid %s=%q, using %d", burstVar, value, defaultEmailBurst)
+		} else {
+			burst = parsed
+		}
+	}
+
+	rate := float64(max) / window.Seconds()
+	log.Info().Str("provider", provider).Float64("per_second", rate).Int("burst", burst).Msg("Email rate limit configured")
+	return newTokenBucket(rate, burst)
+}
+
+// parseEmailRate parses "<max>/<window>", e.g. "10/1s" or "600/1m"
+func parseEmailRate(value string) (int, time.Duration, bool) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	max, err := strconv.Atoi(parts[0])
+	window, werr := time.ParseDuration(parts[1])
+	if err != nil || werr != nil || max <= 0 || window <= 0 {
+		return 0, 0, false
+	}
+	return max, window, true
+}
+
+// throttle waits for the provider's rate limiter, if it has one, and records the wait
+func throttle(ctx context.Context, provider EmailProvider) error {
+	limiter := emailLimiters[provider.Name()]
+	if limiter == nil {
+		return nil
+	}
+	waited, err := limiter.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	metrics.RecordEmailThrottle(provider.Name(), waited)
+	return nil
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// SESProvider sends through Amazon SES (v2 API). Credentials and region come from the
+// standard AWS chain (AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY, AWS_REGION, instance role, ...).
+type SESProvider struct {
+	client           *sesv2.Client
+	fromEmail        string
+	fromName         string
+	configurationSet string
+}
+
+// NewSESProvider reads SES_FROM_EMAIL, SES_FROM_NAME and the optional SES_CONFIGURATION_SET
+func NewSESProvider() (*SESProvider, error) {
+	fromEmail := os.Getenv("SES_FROM_EMAIL")
+	if fromEmail == "" {
+		return nil, fmt.Errorf("SES configuration missing in environment")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("AWS_REGION must be set for SES")
+	}
+
+	return &SESProvider{
+		client:           sesv2.NewFromConfig(cfg),
+		fromEmail:        fromEmail,
+		fromName:         os.Getenv("SES_FROM_NAME"),
+		configurationSet: os.Getenv("SES_CONFIGURATION_SET"),
+	}, nil
+}
+
+func (p *SESProvider) Name() string {
+	return "ses"
+}
+
+func (p *SESProvider) AttachmentLimit() int {
+	return sesAttachmentLimit
+}
+
+// Send calls SendEmail; the SES MessageId is used as the request ID
+func (p *SESProvider) Send(params SendEmailParams) (*EmailResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	from := mail.Address{Name: p.fromName, Address: p.fromEmail}
+	to := mail.Address{Name: params.ToName, Address: params.ToEmail}
+
+	input := &sesv2.SendEmailInput{
+		FromEmailAddress: aws.String(from.String()),
+		Destination: &types.Destination{
+			ToAddresses: []string{to.String()},
+		},
+		Content: &types.EmailContent{
+			Simple: &types.Message{
+				Subject: &types.Content{Data: aws.String(params.Subject), Charset: aws.String("UTF-8")},
+				Body: &types.Body{
+					Html: &types.Content{Data: aws.String(params.HTMLBody), Charset: aws.String("UTF-8")},
+				},
+			},
+		},
+	}
+	for _, attachment := range params.Attachments {
+		encoded := types.Attachment{
+			FileName:                aws.String(attachment.Name),
+			RawContent:              attachment.Data,
+			ContentType:             aws.String(attachment.MimeType),
+			ContentDisposition:      types.AttachmentContentDispositionAttachment,
+			ContentTransferEncoding: types.AttachmentContentTransferEncodingBase64,
+		}
+		if attachment.Inline() {
+			encoded.ContentDisposition = types.AttachmentContentDispositionInline
+			encoded.ContentId = aws.String(attachment.ContentID)
+		}
+		input.Content.Simple.Attachments = append(input.Content.Simple.Attachments, encoded)
+	}
+	if p.configurationSet != "" {
+		input.ConfigurationSetName = aws.String(p.configurationSet)
+	}
+
+	output, err := p.client.SendEmail(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send email: %w", err)
+	}
+
+	messageID := aws.ToString(output.MessageId)
+	raw, _ := json.Marshal(map[string]string{"message_id": messageID})
+	return &EmailResult{
+		Provider:  p.Name(),
+		RequestID: messageID,
+		Code:      "200",
+		Message:   "Accepted by SES",
+		Raw:       raw,
+	}, nil
+}
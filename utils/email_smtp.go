@@ -0,0 +1,266 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// SMTPProvider sends through any SMTP relay. Port 465 uses implicit TLS,
+// other ports upgrade with STARTTLS when the server offers it.
+type SMTPProvider struct {
+	host      string
+	port      string
+	username  string
+	password  string
+	fromEmail string
+	fromName  string
+}
+
+// NewSMTPProvider reads SMTP_HOST, SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD,
+// SMTP_FROM_EMAIL and SMTP_FROM_NAME
+func NewSMTPProvider() (*SMTPProvider, error) {
+	p := &SMTPProvider{
+		host:      os.Getenv("SMTP_HOST"),
+		port:      os.Getenv("SMTP_PORT"),
+		username:  os.Getenv("SMTP_USERNAME"),
+		password:  os.Getenv("SMTP_PASSWORD"),
+		fromEmail: os.Getenv("SMTP_FROM_EMAIL"),
+		fromName:  os.Getenv("SMTP_FROM_NAME"),
+	}
+
+	if p.host == "" || p.fromEmail == "" {
+		return nil, fmt.Errorf("SMTP configuration missing in environment")
+	}
+	if p.port == "" {
+		p.port = "587"
+	}
+
+	return p, nil
+}
+
+func (p *SMTPProvider) Name() string {
+	return "smtp"
+}
+
+func (p *SMTPProvider) AttachmentLimit() int {
+	return smtpAttachmentLimit
+}
+
+// Send delivers the message to the relay; the generated Message-ID is used as the request ID
+func (p *SMTPProvider) Send(params SendEmailParams) (*EmailResult, error) {
+	messageID, msg, err := p.buildMessage(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var auth smtp.Auth
+	if p.username != "" {
+		auth = smtp.PlainAuth("", p.username, p.password, p.host)
+	}
+
+	addr := net.JoinHostPort(p.host, p.port)
+	if p.port == "465" {
+		err = p.sendImplicitTLS(addr, auth, params.ToEmail, msg)
+	} else {
+		err = smtp.SendMail(addr, auth, p.fromEmail, []string{params.ToEmail}, msg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to send email: %w", err)
+	}
+
+	raw, _ := json.Marshal(map[string]string{"message_id": messageID, "host": p.host})
+	return &EmailResult{
+		Provider:  p.Name(),
+		RequestID: messageID,
+		Code:      "250",
+		Message:   "Accepted by SMTP relay",
+		Raw:       raw,
+	}, nil
+}
+
+// buildMessage renders the HTML body quoted-printable. With attachments the message is
+// multipart/mixed, and inline images sit with the body in a multipart/related part.
+func (p *SMTPProvider) buildMessage(params SendEmailParams) (string, []byte, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, fmt.Errorf("failed to generate message id: %w", err)
+	}
+	domain := p.fromEmail[strings.LastIndex(p.fromEmail, "@")+1:]
+	messageID := hex.EncodeToString(idBytes) + "@" + domain
+
+	from := mail.Address{Name: p.fromName, Address: p.fromEmail}
+	to := mail.Address{Name: params.ToName, Address: params.ToEmail}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", params.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s>\r\n", messageID)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(params.Attachments) == 0 {
+		buf.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, params.HTMLBody); err != nil {
+			return "", nil, err
+		}
+		return messageID, buf.Bytes(), nil
+	}
+
+	var inline, attached []Attachment
+	for _, attachment := range params.Attachments {
+		if attachment.Inline() {
+			inline = append(inline, attachment)
+		} else {
+			attached = append(attached, attachment)
+		}
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", mixed.Boundary())
+
+	body := mixed
+	if len(inline) > 0 {
+		// The related part's header names its boundary, so the boundary is chosen first
+		boundary := multipart.NewWriter(io.Discard).Boundary()
+		related, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {fmt.Sprintf("multipart/related; boundary=%q", boundary)},
+		})
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to build email: %w", err)
+		}
+		body = multipart.NewWriter(related)
+		if err := body.SetBoundary(boundary); err != nil {
+			return "", nil, fmt.Errorf("failed to build email: %w", err)
+		}
+	}
+
+	htmlPart, err := body.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to build email: %w", err)
+	}
+	if err := writeQuotedPrintable(htmlPart, params.HTMLBody); err != nil {
+		return "", nil, err
+	}
+	for _, attachment := range inline {
+		if err := writeAttachment(body, attachment); err != nil {
+			return "", nil, err
+		}
+	}
+	if body != mixed {
+		if err := body.Close(); err != nil {
+			return "", nil, fmt.Errorf("failed to build email: %w", err)
+		}
+	}
+	for _, attachment := range attached {
+		if err := writeAttachment(mixed, attachment); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return "", nil, fmt.Errorf("failed to build email: %w", err)
+	}
+
+	return messageID, buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return fmt.Errorf("failed to encode email body: %w", err)
+	}
+	if err := qp.Close(); err != nil {
+		return fmt.Errorf("failed to encode email body: %w", err)
+	}
+	return nil
+}
+
+// writeAttachment adds a base64 part, wrapped at 76 characters as MIME requires
+func writeAttachment(w *multipart.Writer, attachment Attachment) error {
+	name := mime.QEncoding.Encode("utf-8", attachment.Name)
+	header := textproto.MIMEHeader{
+		"Content-Type":              {fmt.Sprintf("%s; name=%q", attachment.MimeType, name)},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", name)},
+	}
+	if attachment.Inline() {
+		header.Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", name))
+		header.Set("Content-ID", "<"+attachment.ContentID+">")
+	}
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(part, encoded[:76]+"\r\n"); err != nil {
+			return fmt.Errorf("failed to build email: %w", err)
+		}
+		encoded = encoded[76:]
+	}
+	if _, err := io.WriteString(part, encoded+"\r\n"); err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+	return nil
+}
+
+// sendImplicitTLS is smtp.SendMail for servers that expect TLS from the first byte (port 465)
+func (p *SMTPProvider) sendImplicitTLS(addr string, auth smtp.Auth, to string, msg []byte) error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: p.host})
+	if err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, p.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(p.fromEmail); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const ZeptoMailURL = "https://api.zeptomail.in/v1.1/email"
+
+type EmailRecipient struct {
+	Address string `json:"address"`
+	Name    string `json:"name,omitempty"`
+}
+
+type EmailRequest struct {
+	From struct {
+		Address string `json:"address"`
+		Name    string `json:"name,omitempty"`
+	} `json:"from"`
+	To []struct {
+		EmailAddress EmailRecipient `json:"email_address"`
+	} `json:"to"`
+	Subject      string                `json:"subject"`
+	HTMLBody     string                `json:"htmlbody"`
+	Attachments  []ZeptoMailAttachment `json:"attachments,omitempty"`
+	InlineImages []ZeptoMailAttachment `json:"inline_images,omitempty"`
+}
+
+// ZeptoMailAttachment is a base64-encoded file; inline images carry a CID instead of a name
+type ZeptoMailAttachment struct {
+	Content  string `json:"content"`
+	MimeType string `json:"mime_type"`
+	Name     string `json:"name,omitempty"`
+	CID      string `json:"cid,omitempty"`
+}
+
+type ZeptoMailResponse struct {
+	Data []struct {
+		Code           string   `json:"code"`
+		AdditionalInfo []string `json:"additional_info"`
+		Message        string   `json:"message"`
+	} `json:"data"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+	Object    string `json:"object"`
+}
+
+// ZeptoMailProvider sends through the ZeptoMail HTTP API
+type ZeptoMailProvider struct {
+	apiKey    string
+	fromEmail string
+	fromName  string
+	client    *http.Client
+}
+
+// NewZeptoMailProvider reads ZEPTO_API_KEY, ZEPTO_FROM_EMAIL and ZEPTO_FROM_NAME
+func NewZeptoMailProvider() (*ZeptoMailProvider, error) {
+	apiKey := os.Getenv("ZEPTO_API_KEY")
+	fromEmail := os.Getenv("ZEPTO_FROM_EMAIL")
+
+	if apiKey == "" || fromEmail == "" {
+		return nil, fmt.Errorf("ZeptoMail configuration missing in environment")
+	}
+
+	return &ZeptoMailProvider{
+		apiKey:    apiKey,
+		fromEmail: fromEmail,
+		fromName:  os.Getenv("ZEPTO_FROM_NAME"),
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *ZeptoMailProvider) Name() string {
+	return "zeptomail"
+}
+
+func (p *ZeptoMailProvider) AttachmentLimit() int {
+	return zeptoAttachmentLimit
+}
+
+// Send performs the ZeptoMail API call
+func (p *ZeptoMailProvider) Send(params SendEmailParams) (*EmailResult, error) {
+	// Construct request body
+	emailReq := EmailRequest{
+		Subject:  params.Subject,
+		HTMLBody: params.HTMLBody,
+	}
+	emailReq.From.Address = p.fromEmail
+	emailReq.From.Name = p.fromName
+	emailReq.To = []struct {
+		EmailAddress EmailRecipient `json:"email_address"`
+	}{
+		{
+			EmailAddress: EmailRecipient{
+				Address: params.ToEmail,
+				Name:    params.ToName,
+			},
+		},
+	}
+	for _, attachment := range params.Attachments {
+		encoded := ZeptoMailAttachment{
+			Content:  base64.StdEncoding.EncodeToString(attachment.Data),
+			MimeType: attachment.MimeType,
+		}
+		if attachment.Inline() {
+			encoded.CID = attachment.ContentID
+			emailReq.InlineImages = append(emailReq.InlineImages, encoded)
+			continue
+		}
+		encoded.Name = attachment.Name
+		emailReq.Attachments = append(emailReq.Attachments, encoded)
+	}
+
+	// Marshal to JSON
+	jsonData, err := json.Marshal(emailReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal email request: %w", err)
+	}
+
+	// Create HTTP request
+	req, err := http.NewRequest("POST", ZeptoMailURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", p.apiKey)
+
+	// Send request
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send email: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read response
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("email send failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	// Parse ZeptoMail response
+	var zeptoResp ZeptoMailResponse
+	if err := json.Unmarshal(body, &zeptoResp); err != nil {
+		return nil, fmt.Errorf("failed to parse ZeptoMail response: %w", err)
+	}
+
+	result := &EmailResult{
+		Provider:  p.Name(),
+		RequestID: zeptoResp.RequestID,
+		Message:   zeptoResp.Message,
+		Raw:       body,
+	}
+	if len(zeptoResp.Data) > 0 {
+		result.Code = zeptoResp.Data[0].Code
+		result.Message = zeptoResp.Data[0].Message
+	}
+
+	return result, nil
+}